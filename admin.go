@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// The admin API, which reports on a running oplogtoredis and controls its
+// tailing without a restart. It's served under /admin/ when
+// OTR_ADMIN_TOKEN is set:
+//
+// - GET /admin/status: the last-processed timestamp, lag, and status of each
+// shard's tailer, the number of publications buffered for the publisher, and
+// the number of entries and publications for each namespace
+//
+// - POST /admin/pause and POST /admin/resume: pause and resume tailing
+//
+// - POST /admin/skip?ts=<seconds>:<increment>: skip the entry with that
+// timestamp, if it hasn't been read yet
+//
+// The actions apply to every shard, or to the one named by the shard
+// parameter.
+type adminAPI struct {
+	token   string
+	tailers *readyTailers
+
+	// Set by attach once we're the leader
+	mutex           sync.Mutex
+	checkpointStore redispub.CheckpointStore
+	bufferDepth     func() int
+
+	counters namespaceCounters
+}
+
+// Entries and publications per namespace
+type namespaceCounters struct {
+	mutex      sync.Mutex
+	namespaces map[string]*namespaceCounts
+}
+
+type namespaceCounts struct {
+	Entries      int64 `json:"entries"`
+	Publications int64 `json:"publications"`
+}
+
+// Returns the admin API, or nil if OTR_ADMIN_TOKEN isn't set
+func newAdminAPI(token string, tailers *readyTailers) *adminAPI {
+	if token == "" {
+		return nil
+	}
+
+	return &adminAPI{
+		token:    token,
+		tailers:  tailers,
+		counters: namespaceCounters{namespaces: map[string]*namespaceCounts{}},
+	}
+}
+
+// Registers hooks on the tailers to count entries and publications per
+// namespace. It must be called before they start tailing.
+func (a *adminAPI) countTailers(tailers []*oplog.Tailer) {
+	if a == nil {
+		return
+	}
+
+	for _, tailer := range tailers {
+		tailer.OnEntry(func(entry oplog.EntryInfo) {
+			a.counters.add(entry.Namespace, 1, 0)
+		})
+		tailer.OnPublish(func(p *redispub.Publication) {
+			if !p.Meta {
+				a.counters.add(p.CollectionChannel, 0, 1)
+			}
+		})
+	}
+}
+
+// Gives the admin API what it needs to report on the publisher, once it's
+// been created
+func (a *adminAPI) attach(checkpointStore redispub.CheckpointStore, bufferDepth func() int) {
+	if a == nil {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.checkpointStore = checkpointStore
+	a.bufferDepth = bufferDepth
+}
+
+func (c *namespaceCounters) add(namespace string, entries int64, publications int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	counts, ok := c.namespaces[namespace]
+	if !ok {
+		counts = &namespaceCounts{}
+		c.namespaces[namespace] = counts
+	}
+
+	counts.Entries += entries
+	counts.Publications += publications
+}
+
+func (c *namespaceCounters) get() map[string]namespaceCounts {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result := make(map[string]namespaceCounts, len(c.namespaces))
+	for namespace, counts := range c.namespaces {
+		result[namespace] = *counts
+	}
+
+	return result
+}
+
+// Registers the admin API's handlers
+func (a *adminAPI) register(mux *http.ServeMux) {
+	if a == nil {
+		return
+	}
+
+	mux.HandleFunc("/admin/status", a.handle("GET", a.status))
+	mux.HandleFunc("/admin/pause", a.handle("POST", a.control("pause", (*oplog.Tailer).Pause)))
+	mux.HandleFunc("/admin/resume", a.handle("POST", a.control("resume", (*oplog.Tailer).Resume)))
+	mux.HandleFunc("/admin/skip", a.handle("POST", a.skip))
+}
+
+// Wraps a handler with authentication and a method check. The handler
+// returns the response body, or an error with its status code.
+func (a *adminAPI) handle(method string, handler func(r *http.Request) (interface{}, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if !a.authorized(r) {
+			log.Log.Warnw("Unauthorized admin API request",
+				"path", r.URL.Path,
+				"remoteAddr", r.RemoteAddr)
+			writeAdminError(w, http.StatusUnauthorized, "Missing or incorrect admin token")
+			return
+		}
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s requires %s", r.URL.Path, method))
+			return
+		}
+
+		body, status, err := handler(r)
+		if err != nil {
+			writeAdminError(w, status, err.Error())
+			return
+		}
+
+		w.WriteHeader(status)
+		jsonErr := json.NewEncoder(w).Encode(body)
+		if jsonErr != nil {
+			log.Log.Errorw("Error writing admin API response",
+				"path", r.URL.Path,
+				"error", jsonErr)
+		}
+	}
+}
+
+// Returns whether a request carries the admin token
+func (a *adminAPI) authorized(r *http.Request) bool {
+	expected := []byte("Bearer " + a.token)
+	got := []byte(r.Header.Get("Authorization"))
+	return subtle.ConstantTimeCompare(got, expected) == 1
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// The status of one shard's tailer
+type adminShardStatus struct {
+	Shard          string     `json:"shard"`
+	Tailing        bool       `json:"tailing"`
+	Paused         bool       `json:"paused"`
+	Failures       int        `json:"failures"`
+	LastTimestamp  string     `json:"lastTimestamp"`
+	LastActivity   time.Time  `json:"lastActivity"`
+	LagSeconds     *float64   `json:"lagSeconds"`
+	Checkpoint     string     `json:"checkpoint,omitempty"`
+	CheckpointTime *time.Time `json:"checkpointTime,omitempty"`
+	Errors         []string   `json:"errors,omitempty"`
+}
+
+// Handles GET /admin/status
+func (a *adminAPI) status(r *http.Request) (interface{}, int, error) {
+	a.mutex.Lock()
+	checkpointStore, bufferDepth := a.checkpointStore, a.bufferDepth
+	a.mutex.Unlock()
+
+	shards := []adminShardStatus{}
+	for _, tailer := range a.tailers.get() {
+		status := tailer.Status()
+		shard := adminShardStatus{
+			Shard:         tailer.Shard,
+			Tailing:       status.Tailing,
+			Paused:        status.Paused,
+			Failures:      status.Failures,
+			LastTimestamp: oplog.FormatTimestamp(status.LastTimestamp),
+			LastActivity:  status.LastActivity,
+		}
+
+		lag, err := tailer.Lag()
+		if err != nil {
+			shard.Errors = append(shard.Errors, fmt.Sprintf("Error getting lag: %s", err))
+		} else {
+			lagSeconds := lag.Seconds()
+			shard.LagSeconds = &lagSeconds
+		}
+
+		if checkpointStore != nil {
+			prefix := redispub.ShardMetadataPrefix(metadataPrefix(), tailer.Shard)
+			ts, t, err := checkpointStore.LastProcessedTimestamp(prefix)
+			if err != nil {
+				shard.Errors = append(shard.Errors, fmt.Sprintf("Error getting checkpoint: %s", err))
+			} else {
+				shard.Checkpoint = oplog.FormatTimestamp(ts)
+				shard.CheckpointTime = &t
+			}
+		}
+
+		shards = append(shards, shard)
+	}
+
+	depth := 0
+	if bufferDepth != nil {
+		depth = bufferDepth()
+	}
+
+	return map[string]interface{}{
+		"shards":      shards,
+		"bufferDepth": depth,
+		"namespaces":  a.counters.get(),
+	}, http.StatusOK, nil
+}
+
+// Returns a handler that calls action on the tailers the request is for
+func (a *adminAPI) control(name string, action func(*oplog.Tailer)) func(r *http.Request) (interface{}, int, error) {
+	return func(r *http.Request) (interface{}, int, error) {
+		tailers, err := a.requestTailers(r)
+		if err != nil {
+			return nil, http.StatusNotFound, err
+		}
+
+		log.Log.Warnw("Admin API request to "+name+" tailing",
+			"shard", r.URL.Query().Get("shard"),
+			"remoteAddr", r.RemoteAddr)
+
+		for _, tailer := range tailers {
+			action(tailer)
+		}
+
+		return map[string]interface{}{"ok": true, "tailers": len(tailers)}, http.StatusOK, nil
+	}
+}
+
+// Handles POST /admin/skip
+func (a *adminAPI) skip(r *http.Request) (interface{}, int, error) {
+	param := r.URL.Query().Get("ts")
+	if param == "" {
+		return nil, http.StatusBadRequest, errors.New("Missing ts parameter")
+	}
+
+	ts, err := oplog.ParseTimestamp(param)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	tailers, err := a.requestTailers(r)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+
+	log.Log.Warnw("Admin API request to skip an oplog entry",
+		"timestamp", oplog.FormatTimestamp(ts),
+		"shard", r.URL.Query().Get("shard"),
+		"remoteAddr", r.RemoteAddr)
+
+	for _, tailer := range tailers {
+		tailer.Skip(ts)
+	}
+
+	return map[string]interface{}{"ok": true, "tailers": len(tailers), "ts": oplog.FormatTimestamp(ts)}, http.StatusOK, nil
+}
+
+// Returns the tailers a request is for: the one for the shard parameter, or
+// every tailer if there isn't one
+func (a *adminAPI) requestTailers(r *http.Request) ([]*oplog.Tailer, error) {
+	tailers := a.tailers.get()
+
+	shard := r.URL.Query().Get("shard")
+	if shard == "" {
+		return tailers, nil
+	}
+
+	for _, tailer := range tailers {
+		if tailer.Shard == shard {
+			return []*oplog.Tailer{tailer}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No tailer for shard %q", shard)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Implements `oplogtoredis bench`, which drives synthetic oplog entries
+// through the same parse -> process -> publish pipeline that oplogtoredis
+// uses, and reports throughput and allocation stats. By default it publishes
+// to an in-process mock Redis server, so it measures oplogtoredis itself;
+// pass --redis-url to include a real Redis server in the measurement.
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	count := flags.Int("n", 100000, "Number of synthetic oplog entries to process")
+	redisURL := flags.String("redis-url", "", "Redis URL to publish to. Uses an in-process mock Redis server if empty. Never point this at a Redis server with real subscribers.")
+	mixFlag := flags.String("mix", "insert=50,update=40,delete=10", "Relative weights of each kind of entry")
+	docSize := flags.Int("doc-size", 100, "Approximate size of each document's payload, in bytes")
+	relay := flags.Bool("relay", false, "Publish in relay mode")
+	relayBatchSize := flags.Int("relay-batch-size", 500, "Batch size in relay mode")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *count <= 0 || *relayBatchSize <= 0 {
+		return errors.New("-n and --relay-batch-size must be positive")
+	}
+
+	mix, err := parseOperationMix(*mixFlag)
+	if err != nil {
+		return err
+	}
+
+	var redisClient redis.UniversalClient
+	if *redisURL == "" {
+		server, serverErr := miniredis.Run()
+		if serverErr != nil {
+			return fmt.Errorf("Error starting mock Redis server: %s", serverErr)
+		}
+		defer server.Close()
+
+		redisClient = redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs: []string{server.Addr()},
+		})
+	} else {
+		redisClient, err = dialRedis(*redisURL)
+		if err != nil {
+			return err
+		}
+	}
+	defer redisClient.Close()
+
+	// Generate all the entries up front, so we don't measure the generator
+	fmt.Fprintf(os.Stderr, "Generating %d entries...\n", *count)
+	entries, err := generateBenchEntries(*count, mix, *docSize)
+	if err != nil {
+		return err
+	}
+
+	opts := &redispub.PublishOpts{
+		FlushInterval:    time.Second,
+		DedupeExpiration: time.Minute,
+		// Use a unique prefix so repeated runs don't dedupe against each other
+		MetadataPrefix: fmt.Sprintf("oplogtoredis::bench::%d::", time.Now().UnixNano()),
+	}
+	if *relay {
+		opts.Relay = &redispub.RelayOpts{
+			BatchSize:   *relayBatchSize,
+			BatchWindow: 250 * time.Millisecond,
+			MaxOutage:   time.Minute,
+		}
+	}
+
+	tailer := &oplog.Tailer{}
+
+	// Measure parsing on its own, and then the whole pipeline
+	parseResult := measureBench(*count, func() {
+		for _, entry := range entries {
+			tailer.Process(entry)
+		}
+	})
+
+	pipelineResult := measureBench(*count, func() {
+		pubs := make(chan *redispub.Publication, 10000)
+		done := make(chan bool)
+		go func() {
+			redispub.PublishStream(context.Background(), redisClient, pubs, opts)
+			close(done)
+		}()
+
+		for _, entry := range entries {
+			if pub := tailer.Process(entry); pub != nil {
+				pubs <- pub
+			}
+		}
+		close(pubs)
+		<-done
+	})
+
+	fmt.Printf("%-10s %12s %12s %12s %12s\n", "stage", "ops/sec", "ns/op", "allocs/op", "bytes/op")
+	parseResult.print("parse")
+	pipelineResult.print("pipeline")
+
+	return nil
+}
+
+type benchResult struct {
+	elapsed time.Duration
+	ops     int
+	allocs  uint64
+	bytes   uint64
+}
+
+// Runs fn, which should perform ops operations, and measures how long it
+// took and how much it allocated
+func measureBench(ops int, fn func()) benchResult {
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	fn()
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return benchResult{
+		elapsed: elapsed,
+		ops:     ops,
+		allocs:  after.Mallocs - before.Mallocs,
+		bytes:   after.TotalAlloc - before.TotalAlloc,
+	}
+}
+
+func (r benchResult) print(stage string) {
+	ops := float64(r.ops)
+	fmt.Printf("%-10s %12.0f %12.0f %12.1f %12.0f\n",
+		stage,
+		ops/r.elapsed.Seconds(),
+		float64(r.elapsed.Nanoseconds())/ops,
+		float64(r.allocs)/ops,
+		float64(r.bytes)/ops)
+}
+
+// Generates raw oplog entries with the given mix of operations, in the same
+// format Mongo stores them in the oplog
+func generateBenchEntries(count int, mix operationMix, docSize int) ([]bson.Raw, error) {
+	payload := strings.Repeat("x", docSize)
+	start := time.Now().Unix() << 32
+
+	entries := make([]bson.Raw, count)
+	for i := range entries {
+		id := bson.NewObjectId()
+		entry := bson.M{
+			"ts": bson.MongoTimestamp(start + int64(i)),
+			"h":  int64(i),
+			"v":  2,
+			"ns": "bench.entries",
+		}
+
+		switch mix.pick() {
+		case "insert":
+			entry["op"] = "i"
+			entry["o"] = bson.M{"_id": id, "payload": payload, "counter": 0}
+		case "update":
+			entry["op"] = "u"
+			entry["o"] = bson.M{"$set": bson.M{"payload": payload}, "$inc": bson.M{"counter": 1}}
+			entry["o2"] = bson.M{"_id": id}
+		case "delete":
+			entry["op"] = "d"
+			entry["o"] = bson.M{"_id": id}
+		}
+
+		data, err := bson.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = bson.Raw{Kind: 3, Data: data}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/config"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/version"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// A subcommand of oplogtoredis. Running oplogtoredis with no arguments tails
+// the oplog and publishes to Redis; running it with a subcommand name as the
+// first argument runs that subcommand instead.
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"bench": {
+		usage:       "bench [-n <entries>] [--redis-url <url>] [--mix ...] [--relay]",
+		description: "Drive synthetic oplog entries through the publishing pipeline and report throughput",
+		run:         runBench,
+	},
+	"replay": {
+		usage:       "replay --from <ts> [--to <ts>] [--ns <db.collection>]...",
+		description: "Republish the oplog entries in the given time range, then exit",
+		run:         runReplay,
+	},
+	"verify": {
+		usage:       "verify [--window <duration>] [--ns <db.collection>]...",
+		description: "Check that recent oplog entries were published, reporting any gaps",
+		run:         runVerify,
+	},
+	"loadgen": {
+		usage:       "loadgen --db <database> [--rate <writes/sec>] [--duration <duration>] [--mix insert=50,update=40,delete=10]",
+		description: "Write synthetic insert/update/delete traffic into a test database at a target rate",
+		run:         runLoadgen,
+	},
+	"doctor": {
+		usage:       "doctor",
+		description: "Check the Mongo and Redis servers for common misconfigurations",
+		run:         runDoctor,
+	},
+	"check": {
+		usage:       "check",
+		description: "An alias for doctor",
+		run:         runDoctor,
+	},
+	"export": {
+		usage:       "export --dir <dir> --from <ts> [--to <ts>] [--ns <db.collection>]... [--gzip]",
+		description: "Write the publications for a range of the oplog to rotating JSONL files",
+		run:         runExport,
+	},
+	"latency": {
+		usage:       "latency --db <database> [-n <inserts>] [--rate <inserts/sec>] [--out <file>]",
+		description: "Insert documents and report the distribution of times until their messages arrive from Redis",
+		run:         runLatency,
+	},
+	"listen": {
+		usage:       "listen [--prefix <prefix>] [--ns <db.collection>]... [--raw]",
+		description: "Subscribe to the channels oplogtoredis publishes to and print each message received",
+		run:         runListen,
+	},
+	"migrate-position": {
+		usage:       "migrate-position [--to-redis-url <url>] [--to-prefix <prefix>] [--dedupe=false] [--force]",
+		description: "Copy the last-processed timestamp and dedupe keys to another Redis server or metadata prefix",
+		run:         runMigratePosition,
+	},
+	"tail-dump": {
+		usage:       "tail-dump [--from <ts>] [--ns <db.collection>]...",
+		description: "Tail the oplog and print each entry and the publication it would produce, without publishing",
+		run:         runTailDump,
+	},
+	"version": {
+		usage:       "version",
+		description: "Print the version, commit, and build time of oplogtoredis (also --version)",
+		run:         runVersion,
+	},
+	"validate-config": {
+		usage:       "validate-config [<file>]",
+		description: "Check the configuration file (or OTR_CONFIG_FILE) and environment variables, then exit",
+		run:         runValidateConfig,
+	},
+}
+
+// Runs the named subcommand, returning the process exit code
+func runCommand(name string, args []string) int {
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage()
+		return 0
+	}
+
+	if name == "--validate-config" {
+		name = "validate-config"
+	} else if name == "--version" {
+		name = "version"
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		return 2
+	}
+
+	err := cmd.run(args)
+	if err == flag.ErrHelp {
+		// The flag package already printed the command's usage
+		return 0
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: oplogtoredis [--start-ts <ts> | --start-from checkpoint|oldest|newest] [--stop-at-ts <ts>]")
+	fmt.Fprintln(os.Stderr, "       oplogtoredis <command>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "With no command, tails the oplog and publishes changes to Redis.")
+	fmt.Fprintln(os.Stderr, "Timestamps may be RFC 3339, Unix seconds, or <seconds>:<increment>.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n        %s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+// Flags for running oplogtoredis without a command, which override where
+// tailing starts and stops
+type runFlags struct {
+	// If non-zero, start after this timestamp
+	startTS bson.MongoTimestamp
+
+	startFrom oplog.StartPosition
+
+	// If non-zero, exit after publishing the entries up to this timestamp
+	stopAtTS bson.MongoTimestamp
+}
+
+// Whether arg is one of the runFlags, rather than a command
+func isRunFlag(arg string) bool {
+	return strings.HasPrefix(arg, "-") && arg != "-h" && arg != "--help" && arg != "--validate-config" && arg != "--version"
+}
+
+// Prints the version of oplogtoredis
+func runVersion(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("Unexpected argument %q", args[0])
+	}
+
+	fmt.Println(version.Get())
+	return nil
+}
+
+func parseRunFlags(args []string) (*runFlags, error) {
+	flags := flag.NewFlagSet("oplogtoredis", flag.ContinueOnError)
+	startTS := flags.String("start-ts", "", "Start tailing after this timestamp, ignoring the last-processed timestamp and OTR_MAX_CATCH_UP")
+	startFrom := flags.String("start-from", string(oplog.StartFromCheckpoint), "Where to start tailing: checkpoint (the last-processed timestamp), oldest (the oldest entry in the oplog), or newest (the end of the oplog)")
+	stopAtTS := flags.String("stop-at-ts", "", "Exit after publishing the entries up to and including this timestamp")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("Unexpected argument %q", flags.Arg(0))
+	}
+
+	result := &runFlags{}
+
+	result.startFrom, err = oplog.ParseStartPosition(*startFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	if *startTS != "" {
+		if result.startFrom != oplog.StartFromCheckpoint {
+			return nil, errors.New("--start-ts can't be combined with --start-from")
+		}
+
+		result.startTS, err = oplog.ParseTimestamp(*startTS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *stopAtTS != "" {
+		result.stopAtTS, err = oplog.ParseTimestamp(*stopAtTS)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.startTS != 0 && result.stopAtTS <= result.startTS {
+			return nil, errors.New("--stop-at-ts must be after --start-ts")
+		}
+	}
+
+	return result, nil
+}
+
+// Parses configuration from the environment and connects to Mongo and Redis,
+// for use by subcommands. The caller is responsible for closing both
+// clients.
+func connectForCommand() (*mongo.Client, redis.UniversalClient, error) {
+	err := config.ParseEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing environment variables: %s", err)
+	}
+
+	err = configureLogging()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error configuring logging: %s", err)
+	}
+
+	mongoClient, err := createMongoClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	redisClient, err := createRedisClient()
+	if err != nil {
+		_ = mongoClient.Disconnect(context.Background())
+		return nil, nil, err
+	}
+
+	return mongoClient, redisClient, nil
+}
+
+// A flag.Value that collects the values of a flag that may be repeated
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
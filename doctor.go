@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/config"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The amount of clock skew we tolerate between this machine and the Mongo
+// and Redis servers before warning about it
+const maxClockSkew = 5 * time.Second
+
+// How many PINGs we time to measure Redis latency, and the median round trip
+// above which we warn about it. Each publish waits for at least one round
+// trip, so latency limits how fast oplogtoredis can publish.
+const (
+	redisLatencySamples = 10
+	maxRedisLatency     = 10 * time.Millisecond
+)
+
+// The severity of a doctor finding
+type findingLevel string
+
+const (
+	findingOK   findingLevel = " OK "
+	findingWarn findingLevel = "WARN"
+	findingFail findingLevel = "FAIL"
+)
+
+// A single result from `oplogtoredis doctor`
+type finding struct {
+	level   findingLevel
+	check   string
+	message string
+
+	// What the user should do about it, if anything
+	advice string
+}
+
+// Implements `oplogtoredis doctor` (or `oplogtoredis check`), which checks
+// the Mongo and Redis servers that oplogtoredis is configured to use for
+// common misconfigurations, and prints what it finds along with what to do
+// about it. It's meant to be run before deploying, since most of these
+// problems otherwise only show up as oplogtoredis reconnecting over and over.
+func runDoctor(args []string) error {
+	if len(args) > 0 {
+		return errors.New("doctor does not take any arguments")
+	}
+
+	err := config.ParseEnv()
+	if err != nil {
+		return fmt.Errorf("Error parsing environment variables: %s", err)
+	}
+
+	var findings []finding
+	findings = append(findings, checkMongo()...)
+	findings = append(findings, checkRedis()...)
+
+	failures, warnings := 0, 0
+	for _, f := range findings {
+		fmt.Printf("[%s] %s: %s\n", f.level, f.check, f.message)
+		if f.advice != "" {
+			fmt.Printf("       %s\n", f.advice)
+		}
+
+		switch f.level {
+		case findingFail:
+			failures++
+		case findingWarn:
+			warnings++
+		}
+	}
+
+	fmt.Printf("\n%d checks: %d passed, %d warnings, %d failed\n",
+		len(findings), len(findings)-failures-warnings, warnings, failures)
+
+	if failures > 0 {
+		return fmt.Errorf("Found %d problem(s) that will prevent oplogtoredis from working correctly", failures)
+	}
+
+	return nil
+}
+
+// Checks the Mongo server's replica set status, oplog window, clock, and
+// our permissions
+func checkMongo() []finding {
+	mongoClient, err := dialMongo(config.MongoURL())
+	if err != nil {
+		return []finding{{
+			level:   findingFail,
+			check:   "Mongo connection",
+			message: err.Error(),
+			advice:  "Check OTR_MONGO_URL and that the Mongo server is reachable from here.",
+		}}
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+
+	findings := []finding{{level: findingOK, check: "Mongo connection", message: "Connected"}}
+	findings = append(findings, checkReplicaSet(mongoClient))
+	findings = append(findings, checkOplogWindow(mongoClient))
+	findings = append(findings, checkMongoClock(mongoClient))
+	if config.CheckpointStore() == "mongo" {
+		findings = append(findings, checkCheckpointWrite(nil, mongoClient))
+		findings = append(findings, checkLastProcessed(createCheckpointStore(nil, mongoClient)))
+	}
+
+	return findings
+}
+
+// Checks that the Mongo server is a member of a replica set (otherwise it has
+// no oplog)
+func checkReplicaSet(mongoClient *mongo.Client) finding {
+	var status struct {
+		Set     string `bson:"set"`
+		MyState int    `bson:"myState"`
+	}
+
+	err := mongodb.Run(mongoClient.Database("admin"), bson.D{{Name: "replSetGetStatus", Value: 1}}, &status)
+	if err != nil {
+		if isMongoUnauthorized(err) {
+			return finding{
+				level:   findingWarn,
+				check:   "Mongo replica set",
+				message: fmt.Sprintf("Could not check replica set status: %s", err),
+				advice:  "This check needs the clusterMonitor role; oplogtoredis itself doesn't.",
+			}
+		}
+
+		return finding{
+			level:   findingFail,
+			check:   "Mongo replica set",
+			message: fmt.Sprintf("Could not get replica set status: %s", err),
+			advice:  "Mongo only keeps an oplog when running as a replica set member. A single-node replica set is fine.",
+		}
+	}
+
+	return finding{
+		level:   findingOK,
+		check:   "Mongo replica set",
+		message: fmt.Sprintf("Member of replica set %q (state %d)", status.Set, status.MyState),
+	}
+}
+
+// Checks that we can read the oplog, and that it covers enough time that
+// oplogtoredis can catch up after an outage
+func checkOplogWindow(mongoClient *mongo.Client) finding {
+	oplogCollection := mongoClient.Database("local").Collection("oplog.rs")
+
+	var first, last struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+
+	err := mongodb.FindOne(oplogCollection, nil, &first, options.FindOne().SetSort(mongodb.Doc(bson.M{"$natural": 1})))
+	if err == nil {
+		err = mongodb.FindOne(oplogCollection, nil, &last, options.FindOne().SetSort(mongodb.Doc(bson.M{"$natural": -1})))
+	}
+	if err != nil {
+		return finding{
+			level:   findingFail,
+			check:   "Mongo oplog",
+			message: fmt.Sprintf("Could not read local.oplog.rs: %s", err),
+			advice:  "The Mongo user needs read access to the local database, and OTR_MONGO_URL should point at it.",
+		}
+	}
+
+	window := time.Duration(int64(last.Timestamp)>>32-int64(first.Timestamp)>>32) * time.Second
+
+	minWindow, minWindowVar := config.MaxCatchUp(), "OTR_MAX_CATCH_UP"
+	if config.MinOplogWindow() > minWindow {
+		minWindow, minWindowVar = config.MinOplogWindow(), "OTR_MIN_OPLOG_WINDOW"
+	}
+
+	if window < minWindow {
+		return finding{
+			level:   findingWarn,
+			check:   "Mongo oplog",
+			message: fmt.Sprintf("The oplog covers %s, which is less than %s (%s)", window, minWindowVar, minWindow),
+			advice:  "Entries may roll off the oplog before oplogtoredis can publish them after an outage. Increase the oplog size.",
+		}
+	}
+
+	return finding{
+		level:   findingOK,
+		check:   "Mongo oplog",
+		message: fmt.Sprintf("Readable; covers %s", window),
+	}
+}
+
+// Checks that the Mongo server's clock agrees with ours. We compare oplog
+// timestamps (set by Mongo's clock) to the local time when deciding how far
+// back to resume from.
+func checkMongoClock(mongoClient *mongo.Client) finding {
+	var result struct {
+		LocalTime time.Time `bson:"localTime"`
+	}
+
+	before := time.Now()
+	err := mongodb.Run(mongoClient.Database("admin"), bson.D{{Name: "isMaster", Value: 1}}, &result)
+	if err != nil {
+		return finding{
+			level:   findingWarn,
+			check:   "Mongo clock",
+			message: fmt.Sprintf("Could not read the server time: %s", err),
+		}
+	}
+
+	return clockSkewFinding("Mongo clock", result.LocalTime, before, time.Now())
+}
+
+// Checks the Redis server's eviction policy, clock, and our permissions
+func checkRedis() []finding {
+	client, err := createRedisClient()
+	if err != nil {
+		return []finding{{
+			level:   findingFail,
+			check:   "Redis connection",
+			message: err.Error(),
+			advice:  "Check OTR_REDIS_URL and that the Redis server is reachable from here.",
+		}}
+	}
+	defer client.Close()
+
+	findings := []finding{{level: findingOK, check: "Redis connection", message: "Connected"}}
+	findings = append(findings, checkRedisLatency(client))
+	findings = append(findings, checkEvictionPolicy(client))
+	findings = append(findings, checkRedisClock(client))
+	findings = append(findings, checkRedisPermissions(client))
+	if config.CheckpointStore() != "mongo" {
+		findings = append(findings, checkCheckpointWrite(client, nil))
+		findings = append(findings, checkLastProcessed(createCheckpointStore(client, nil)))
+	}
+
+	return findings
+}
+
+// Checks the round-trip time to Redis
+func checkRedisLatency(client redis.UniversalClient) finding {
+	samples := make([]time.Duration, redisLatencySamples)
+	for i := range samples {
+		start := time.Now()
+		err := client.Ping().Err()
+		if err != nil {
+			return finding{
+				level:   findingFail,
+				check:   "Redis latency",
+				message: fmt.Sprintf("PING failed: %s", err),
+			}
+		}
+		samples[i] = time.Since(start)
+	}
+
+	return redisLatencyFinding(samples)
+}
+
+// Evaluates the round-trip times of a few PINGs
+func redisLatencyFinding(samples []time.Duration) finding {
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	median, max := samples[len(samples)/2], samples[len(samples)-1]
+	message := fmt.Sprintf("Median round trip %s (max %s) over %d PINGs",
+		median.Round(10*time.Microsecond), max.Round(10*time.Microsecond), len(samples))
+
+	if median > maxRedisLatency {
+		return finding{
+			level:   findingWarn,
+			check:   "Redis latency",
+			message: message,
+			advice: fmt.Sprintf("Each publish waits for a round trip, so oplogtoredis can publish at most about %d messages/second. "+
+				"Run it closer to Redis, or raise OTR_PUBLISH_WORKERS or OTR_PUBLISH_BATCH_SIZE.", int(time.Second/median)),
+		}
+	}
+
+	return finding{level: findingOK, check: "Redis latency", message: message}
+}
+
+// Checks that Redis won't evict our metadata keys under memory pressure.
+// Evicting the last-processed timestamp makes oplogtoredis lose its place,
+// and evicting dedupe keys causes duplicate publications.
+func checkEvictionPolicy(client redis.UniversalClient) finding {
+	maxMemory, err := redisConfigValue(client, "maxmemory")
+	var policy string
+	if err == nil {
+		policy, err = redisConfigValue(client, "maxmemory-policy")
+	}
+	if err != nil {
+		return finding{
+			level:   findingWarn,
+			check:   "Redis eviction policy",
+			message: fmt.Sprintf("Could not read the Redis config: %s", err),
+			advice:  "Many hosted Redis services disable CONFIG. Check that maxmemory-policy is noeviction some other way.",
+		}
+	}
+
+	return evictionPolicyFinding(maxMemory, policy)
+}
+
+// Evaluates the maxmemory and maxmemory-policy settings
+func evictionPolicyFinding(maxMemory string, policy string) finding {
+	if maxMemory == "0" {
+		return finding{
+			level:   findingOK,
+			check:   "Redis eviction policy",
+			message: "No maxmemory limit is set, so keys are never evicted",
+		}
+	}
+
+	switch {
+	case policy == "noeviction":
+		return finding{
+			level:   findingOK,
+			check:   "Redis eviction policy",
+			message: "maxmemory-policy is noeviction",
+		}
+	case strings.HasPrefix(policy, "allkeys-"):
+		return finding{
+			level:   findingFail,
+			check:   "Redis eviction policy",
+			message: fmt.Sprintf("maxmemory-policy is %s, so Redis may evict the last-processed timestamp", policy),
+			advice:  "oplogtoredis will lose its place and skip or replay events if that happens. Use noeviction.",
+		}
+	default:
+		return finding{
+			level:   findingWarn,
+			check:   "Redis eviction policy",
+			message: fmt.Sprintf("maxmemory-policy is %s, so Redis may evict dedupe keys", policy),
+			advice:  "Messages may be published more than once under memory pressure. Use noeviction if that matters to you.",
+		}
+	}
+}
+
+// Reads a single setting with CONFIG GET
+func redisConfigValue(client redis.UniversalClient, name string) (string, error) {
+	values, err := client.ConfigGet(name).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if len(values) != 2 {
+		return "", fmt.Errorf("Unexpected response to CONFIG GET %s: %v", name, values)
+	}
+
+	return fmt.Sprint(values[1]), nil
+}
+
+// Checks that the Redis server's clock agrees with ours
+func checkRedisClock(client redis.UniversalClient) finding {
+	before := time.Now()
+	serverTime, err := client.Time().Result()
+	if err != nil {
+		return finding{
+			level:   findingWarn,
+			check:   "Redis clock",
+			message: fmt.Sprintf("Could not read the server time: %s", err),
+		}
+	}
+
+	return clockSkewFinding("Redis clock", serverTime, before, time.Now())
+}
+
+// Checks that we can do everything oplogtoredis needs to do in Redis: read
+// and write keys under the metadata prefix, run Lua scripts, and publish.
+func checkRedisPermissions(client redis.UniversalClient) finding {
+	key := config.RedisMetadataPrefix() + "doctor::" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	err := client.Set(key, 1, time.Minute).Err()
+	if err == nil {
+		err = client.Get(key).Err()
+	}
+	if err == nil {
+		err = client.Del(key).Err()
+	}
+	if err != nil {
+		return finding{
+			level:   findingFail,
+			check:   "Redis permissions",
+			message: fmt.Sprintf("Could not read and write keys under %q: %s", config.RedisMetadataPrefix(), err),
+			advice:  "oplogtoredis needs GET, SET, and SETEX on keys with the metadata prefix.",
+		}
+	}
+
+	err = client.Eval("return 1", nil).Err()
+	if err != nil {
+		return finding{
+			level:   findingFail,
+			check:   "Redis permissions",
+			message: fmt.Sprintf("Could not run a Lua script: %s", err),
+			advice:  "oplogtoredis publishes with EVAL and EVALSHA to deduplicate messages.",
+		}
+	}
+
+	err = client.Publish(config.RedisMetadataPrefix()+"doctor", "").Err()
+	if err != nil {
+		return finding{
+			level:   findingFail,
+			check:   "Redis permissions",
+			message: fmt.Sprintf("Could not publish: %s", err),
+			advice:  "oplogtoredis needs PUBLISH on every channel it publishes to.",
+		}
+	}
+
+	return finding{
+		level:   findingOK,
+		check:   "Redis permissions",
+		message: "Can read and write metadata keys, run scripts, and publish",
+	}
+}
+
+// Checks that we can save checkpoints to the checkpoint store, by saving one
+// under a scratch metadata prefix, reading it back, and removing it. We
+// don't touch the real checkpoint, since oplogtoredis may be running.
+func checkCheckpointWrite(client redis.UniversalClient, mongoClient *mongo.Client) finding {
+	if config.CheckpointStore() == "file" {
+		return checkCheckpointFileWrite(config.CheckpointFile())
+	}
+
+	prefix := metadataPrefix() + "doctor::"
+	ts := bson.MongoTimestamp(time.Now().Unix() << 32)
+
+	store := createCheckpointStore(client, mongoClient)
+	err := store.SaveCheckpoint(prefix, ts, "")
+	var saved bson.MongoTimestamp
+	if err == nil {
+		saved, _, err = store.LastProcessedTimestamp(prefix)
+	}
+	if err == nil && saved != ts {
+		err = fmt.Errorf("Saved %d, but read back %d", ts, saved)
+	}
+	if err != nil {
+		return finding{
+			level:   findingFail,
+			check:   "Checkpoint writes",
+			message: fmt.Sprintf("Could not save a checkpoint to the %s checkpoint store: %s", config.CheckpointStore(), err),
+			advice:  "oplogtoredis can't record its position, so it will lose its place whenever it restarts.",
+		}
+	}
+
+	if client != nil {
+		err = client.Del(prefix + "lastProcessedEntry").Err()
+	} else {
+		// ParseEnv checks that this is "database.collection"
+		parts := strings.SplitN(config.CheckpointCollection(), ".", 2)
+		_, err = mongoClient.Database(parts[0]).Collection(parts[1]).DeleteOne(context.Background(), mongodb.Doc(bson.M{"_id": prefix}))
+	}
+	if err != nil {
+		return finding{
+			level:   findingWarn,
+			check:   "Checkpoint writes",
+			message: fmt.Sprintf("Saved a test checkpoint under %q, but could not remove it: %s", prefix, err),
+		}
+	}
+
+	return finding{
+		level:   findingOK,
+		check:   "Checkpoint writes",
+		message: fmt.Sprintf("Can save checkpoints to the %s checkpoint store", config.CheckpointStore()),
+	}
+}
+
+// Checks that we can replace the checkpoint file: a new file is written
+// next to it and then moved into place, so we need to be able to create
+// files in its directory
+func checkCheckpointFileWrite(path string) finding {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".doctor")
+	if err == nil {
+		_ = tmp.Close()
+		err = os.Remove(tmp.Name())
+	}
+	if err != nil {
+		return finding{
+			level:   findingFail,
+			check:   "Checkpoint writes",
+			message: fmt.Sprintf("Could not create a file next to %s: %s", path, err),
+			advice:  "oplogtoredis can't record its position, so it will lose its place whenever it restarts. Check OTR_CHECKPOINT_FILE.",
+		}
+	}
+
+	return finding{
+		level:   findingOK,
+		check:   "Checkpoint writes",
+		message: fmt.Sprintf("Can write %s", path),
+	}
+}
+
+// Checks whether oplogtoredis has recently recorded its position in the
+// checkpoint store
+func checkLastProcessed(store redispub.CheckpointStore) finding {
+	_, lastTime, err := store.LastProcessedTimestamp(metadataPrefix())
+	if err == redispub.ErrNoCheckpoint {
+		return finding{
+			level:   findingWarn,
+			check:   "Last-processed timestamp",
+			message: fmt.Sprintf("None found under %q", metadataPrefix()),
+			advice:  "This is expected if oplogtoredis has never run. Otherwise, check OTR_REDIS_METADATA_PREFIX.",
+		}
+	} else if err != nil {
+		return finding{
+			level:   findingFail,
+			check:   "Last-processed timestamp",
+			message: fmt.Sprintf("Could not read it: %s", err),
+		}
+	}
+
+	age := time.Since(lastTime)
+	if age > config.MaxCatchUp() {
+		return finding{
+			level:   findingWarn,
+			check:   "Last-processed timestamp",
+			message: fmt.Sprintf("Last updated %s ago, which is more than OTR_MAX_CATCH_UP (%s)", age.Round(time.Second), config.MaxCatchUp()),
+			advice:  "If oplogtoredis isn't running, it will skip the entries in between when it restarts. Use `oplogtoredis replay` to recover them.",
+		}
+	}
+
+	return finding{
+		level:   findingOK,
+		check:   "Last-processed timestamp",
+		message: fmt.Sprintf("Last updated %s ago", age.Round(time.Second)),
+	}
+}
+
+// Compares a server's time to our own. before and after bracket the request
+// that read the server's time.
+func clockSkewFinding(check string, serverTime time.Time, before time.Time, after time.Time) finding {
+	var skew time.Duration
+	if serverTime.Before(before) {
+		skew = before.Sub(serverTime)
+	} else if serverTime.After(after) {
+		skew = serverTime.Sub(after)
+	}
+
+	if skew > maxClockSkew {
+		return finding{
+			level:   findingWarn,
+			check:   check,
+			message: fmt.Sprintf("The server's clock differs from ours by about %s", skew.Round(time.Millisecond)),
+			advice:  "oplogtoredis compares oplog timestamps to its own clock to decide where to resume. Sync clocks with NTP.",
+		}
+	}
+
+	return finding{
+		level:   findingOK,
+		check:   check,
+		message: fmt.Sprintf("Within %s of ours", maxClockSkew),
+	}
+}
+
+// Returns whether a Mongo error indicates that we're not authorized to run a
+// command
+func isMongoUnauthorized(err error) bool {
+	if commandErr, ok := err.(mongo.CommandError); ok {
+		return commandErr.Code == 13
+	}
+
+	return strings.Contains(err.Error(), "not authorized")
+}
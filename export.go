@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/export"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Implements `oplogtoredis export`, which reads a range of the oplog and
+// writes the publication oplogtoredis would send for each entry to rotating
+// JSONL files, instead of publishing it. It doesn't connect to Redis.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	mongoURL := flags.String("mongo-url", os.Getenv("OTR_MONGO_URL"), "Mongo URL to read the oplog from. Defaults to OTR_MONGO_URL.")
+	dir := flags.String("dir", "", "Directory to write files to. Required.")
+	from := flags.String("from", "", "Export entries after this timestamp (RFC 3339, Unix seconds, or <seconds>:<increment>). Required.")
+	to := flags.String("to", "", "Export entries up to and including this timestamp. Defaults to now.")
+	compress := flags.Bool("gzip", false, "Gzip the files")
+	maxBytes := flags.Int64("max-file-size", 100*1024*1024, "Start a new file after this many (uncompressed) bytes. 0 means no limit.")
+	maxAge := flags.Duration("max-file-age", 0, "Start a new file after this long. 0 means no limit.")
+	var namespaces stringSliceFlag
+	flags.Var(&namespaces, "ns", "Only export entries for this namespace (<database>.<collection>). May be repeated.")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		return errors.New("--dir is required")
+	}
+	if *from == "" {
+		return errors.New("--from is required")
+	}
+
+	fromTS, err := oplog.ParseTimestamp(*from)
+	if err != nil {
+		return err
+	}
+
+	// By default, export through the last possible timestamp in the current
+	// second
+	toTS := bson.MongoTimestamp((time.Now().Unix()+1)<<32 - 1)
+	if *to != "" {
+		toTS, err = oplog.ParseTimestamp(*to)
+		if err != nil {
+			return err
+		}
+	}
+
+	if toTS <= fromTS {
+		return errors.New("--to must be after --from")
+	}
+
+	mongoClient, err := dialMongo(*mongoURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+
+	writer, err := export.NewWriter(export.WriterOpts{
+		Dir:      *dir,
+		Compress: *compress,
+		MaxBytes: *maxBytes,
+		MaxAge:   *maxAge,
+	})
+	if err != nil {
+		return err
+	}
+
+	pubs := make(chan *redispub.Publication, 1000)
+	replayErrC := make(chan error, 1)
+	go func() {
+		tailer := oplog.Tailer{MongoClient: mongoClient}
+		replayErrC <- tailer.Replay(pubs, fromTS, toTS, namespaces)
+		close(pubs)
+	}()
+
+	count := 0
+	var writeErr error
+	for p := range pubs {
+		if writeErr != nil {
+			// Keep draining so the replay goroutine can exit
+			continue
+		}
+
+		writeErr = writer.Write(p)
+		count++
+	}
+
+	closeErr := writer.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		return fmt.Errorf("Error writing export file: %s", writeErr)
+	}
+
+	err = <-replayErrC
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d publications to %s\n", count, *dir)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/integration-tests/helpers"
+)
+
+// An atomic applyOps command is recorded as a single applyOps oplog entry,
+// just like a multi-document transaction, so each of its operations should be
+// published
+func TestApplyOps(t *testing.T) {
+	harness := startHarness()
+	defer harness.stop()
+
+	err := harness.mongoClient.Session.DB("admin").Run(bson.D{
+		{Name: "applyOps", Value: []bson.M{
+			{"op": "i", "ns": "tests.Foo", "o": bson.M{"_id": "someid", "hello": "world"}},
+			{"op": "i", "ns": "tests.Foo", "o": bson.M{"_id": "someid2", "hello": "world"}},
+		}},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	insertMessage := func(id string) helpers.OTRMessage {
+		return helpers.OTRMessage{
+			Event: "i",
+			Document: map[string]interface{}{
+				"_id": id,
+			},
+			Fields: []string{"_id", "hello"},
+		}
+	}
+
+	harness.verify(t, map[string][]helpers.OTRMessage{
+		"tests.Foo":          {insertMessage("someid"), insertMessage("someid2")},
+		"tests.Foo::someid":  {insertMessage("someid")},
+		"tests.Foo::someid2": {insertMessage("someid2")},
+	})
+}
@@ -3,5 +3,9 @@
 set -e
 cd `dirname "$0"`
 
+if [ "$REDIS_TOPOLOGY" = "cluster" ]; then
+    ./redis/create-cluster.sh
+fi
+
 mongo "$MONGO_URL" --eval 'rs.initiate({ _id: "myapp", members: [{ _id: 0, host: "mongo:27017"}] })'
 go test . -timeout 60s
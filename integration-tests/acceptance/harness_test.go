@@ -19,6 +19,9 @@ type harness struct {
 	subscription  *redis.PubSub
 	subscriptionC <-chan *redis.Message
 	mongoClient   *mgo.Database
+
+	// Started by the first call to inject
+	injector *helpers.OplogInjector
 }
 
 // Clears the mongo database, connects to redis, and starts a subscription to
@@ -35,8 +38,23 @@ func startHarness() *harness {
 	return &h
 }
 
+// Publishes hand-crafted oplog entries (built with the oplogtest package)
+// through an in-process oplogtoredis pipeline, bypassing Mongo. Use it for
+// oplog shapes that are hard to produce with Mongo writes.
+func (h *harness) inject(entries ...map[string]interface{}) {
+	if h.injector == nil {
+		h.injector = helpers.StartOplogInjector(h.redisClient)
+	}
+
+	h.injector.Inject(entries...)
+}
+
 // Shuts down all the mongo/redis clients
 func (h *harness) stop() {
+	if h.injector != nil {
+		h.injector.Stop()
+	}
+
 	h.mongoClient.Session.Close()
 	h.redisClient.Close()
 	h.subscription.Close()
@@ -46,3 +46,35 @@ func TestHealthz(t *testing.T) {
 		t.Errorf("Got incorrect response.\n    Expected: {\"ok\": true}\n    Got: %#v", data)
 	}
 }
+
+// oplogtoredis should be ready once it's tailing and caught up
+func TestReadyz(t *testing.T) {
+	requestURL := fmt.Sprintf("%s/readyz", os.Getenv("OTR_URL"))
+
+	resp, err := http.Get(requestURL)
+	if err != nil {
+		t.Fatalf("Error sending request: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error receiving response body: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status code 200, but got %d.\n    Body was: %s",
+			resp.StatusCode, respBody)
+	}
+
+	var data map[string]interface{}
+	err = json.Unmarshal(respBody, &data)
+	if err != nil {
+		t.Fatalf("Error parsing JSON response: %s", err)
+	}
+
+	if data["mongoOK"] != true || data["redisOK"] != true || data["tailingOK"] != true {
+		t.Errorf("Got incorrect response: %#v", data)
+	}
+}
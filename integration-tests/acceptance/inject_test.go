@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/integration-tests/helpers"
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis/oplogtest"
+)
+
+// Injected entries with _ids of various types. Only string and ObjectId _ids
+// can be published, since the _id is part of the channel name.
+func TestInjectIDTypes(t *testing.T) {
+	harness := startHarness()
+	defer harness.stop()
+
+	oid := bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")
+
+	harness.inject(
+		oplogtest.Insert("tests.Foo", bson.M{"_id": oid, "hello": "world"}),
+		oplogtest.Insert("tests.Foo", bson.M{"_id": "with::colons", "hello": "world"}),
+		oplogtest.Insert("tests.Foo", bson.M{"_id": 42, "hello": "world"}),
+		oplogtest.Insert("tests.Foo", bson.M{"_id": bson.M{"nested": "id"}, "hello": "world"}),
+	)
+
+	oidMessage := helpers.OTRMessage{
+		Event: "i",
+		Document: map[string]interface{}{
+			"_id": map[string]interface{}{
+				"$type":  "oid",
+				"$value": oid.Hex(),
+			},
+		},
+		Fields: []string{"_id", "hello"},
+	}
+	colonsMessage := helpers.OTRMessage{
+		Event: "i",
+		Document: map[string]interface{}{
+			"_id": "with::colons",
+		},
+		Fields: []string{"_id", "hello"},
+	}
+
+	harness.verify(t, map[string][]helpers.OTRMessage{
+		"tests.Foo":               {oidMessage, colonsMessage},
+		"tests.Foo::" + oid.Hex(): {oidMessage},
+		"tests.Foo::with::colons": {colonsMessage},
+	})
+}
+
+// Injected entries that oplogtoredis should never publish anything for
+func TestInjectUnpublished(t *testing.T) {
+	harness := startHarness()
+	defer harness.stop()
+
+	harness.inject(
+		oplogtest.Drop("tests.Foo"),
+		oplogtest.DropDatabase("tests"),
+		oplogtest.Insert("tests.system.indexes", bson.M{"_id": "someindex"}),
+		bson.M{"op": "n", "ns": "", "o": bson.M{"msg": "periodic noop"}},
+	)
+
+	harness.verify(t, map[string][]helpers.OTRMessage{})
+}
@@ -0,0 +1,26 @@
+#!/bin/sh
+
+# Joins the redis, redis-2, and redis-3 containers into a Redis Cluster and
+# splits the hash slots between them. Used by entry.sh when REDIS_TOPOLOGY is
+# "cluster".
+
+set -e
+
+# CLUSTER MEET needs an IP address rather than a host name
+ip() {
+    getent hosts "$1" | awk '{ print $1 }'
+}
+
+redis-cli -h redis cluster meet "$(ip redis-2)" 6379
+redis-cli -h redis cluster meet "$(ip redis-3)" 6379
+
+seq 0 5460 | xargs redis-cli -h redis cluster addslots > /dev/null
+seq 5461 10922 | xargs redis-cli -h redis-2 cluster addslots > /dev/null
+seq 10923 16383 | xargs redis-cli -h redis-3 cluster addslots > /dev/null
+
+for host in redis redis-2 redis-3; do
+    until redis-cli -h "$host" cluster info | grep -q 'cluster_state:ok'; do
+        echo "Waiting for $host to join the cluster"
+        sleep 1
+    done
+done
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/integration-tests/helpers"
+)
+
+// With OTR_RENAME_REMOVES, renaming a collection publishes a remove for each
+// of its documents under the old name, so Meteor servers stop serving them
+func TestRenameCollection(t *testing.T) {
+	harness := startHarness()
+	defer harness.stop()
+
+	for _, id := range []string{"someid", "someid2"} {
+		err := harness.mongoClient.C("Foo").Insert(bson.M{"_id": id, "hello": "world"})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	harness.resetMessages()
+
+	err := harness.mongoClient.Session.DB("admin").Run(bson.D{
+		{Name: "renameCollection", Value: "tests.Foo"},
+		{Name: "to", Value: "tests.Bar"},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	removeMessage := func(id string) helpers.OTRMessage {
+		return helpers.OTRMessage{
+			Event: "r",
+			Document: map[string]interface{}{
+				"_id": id,
+			},
+			Fields: []string{},
+		}
+	}
+
+	harness.verify(t, map[string][]helpers.OTRMessage{
+		"tests.Foo":          {removeMessage("someid"), removeMessage("someid2")},
+		"tests.Foo::someid":  {removeMessage("someid")},
+		"tests.Foo::someid2": {removeMessage("someid2")},
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tulip/oplogtoredis/integration-tests/helpers"
+)
+
+// Synthetic mutations published to the inbound channels (OTR_SYNTHETIC_CHANNEL_PREFIX
+// is set to "synthetic::" in docker-compose.yml) are republished without the
+// prefix
+func TestSyntheticRelay(t *testing.T) {
+	harness := startHarness()
+	defer harness.stop()
+
+	msg := helpers.OTRMessage{
+		Event: "u",
+		Document: map[string]interface{}{
+			"_id": "someid",
+		},
+		Fields: []string{"hello"},
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, channel := range []string{"synthetic::tests.Foo", "synthetic::tests.Foo::someid"} {
+		err = harness.redisClient.Publish(channel, payload).Err()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// We see both the inbound and the relayed messages
+	harness.verify(t, map[string][]helpers.OTRMessage{
+		"synthetic::tests.Foo":         {msg},
+		"synthetic::tests.Foo::someid": {msg},
+		"tests.Foo":                    {msg},
+		"tests.Foo::someid":            {msg},
+	})
+}
@@ -185,6 +185,86 @@ func (server *MongoServer) StepDown() {
 	}
 }
 
+// KillPrimary kills the process of the current primary without giving it a
+// chance to step down, and returns its port. Call RestartNode with the port
+// to bring it back.
+func (server *MongoServer) KillPrimary() int {
+	port := server.primaryPort()
+	if port == 0 {
+		panic("Could not find the primary to kill")
+	}
+
+	log.Printf("Killing Mongo primary on port %d", port)
+	if err := (*server.node(port)).Process.Kill(); err != nil {
+		panic("Error killing primary: " + err.Error())
+	}
+
+	waitTCPDown(fmt.Sprintf("localhost:%d", port))
+	log.Printf("Killed Mongo primary on port %d", port)
+
+	return port
+}
+
+// RestartNode starts a node that was stopped by KillPrimary. It rejoins the
+// replica set as a secondary.
+func (server *MongoServer) RestartNode(port int) {
+	*server.node(port) = server.startNode(fmt.Sprintf("mongo%d", port-27000), port)
+}
+
+// WaitForPrimary waits until one of the nodes is primary, and returns its
+// port. It panics if there's no primary after 60 seconds.
+func (server *MongoServer) WaitForPrimary() int {
+	for startTime := time.Now(); time.Since(startTime) < 60*time.Second; time.Sleep(time.Second) {
+		if port := server.primaryPort(); port != 0 {
+			log.Printf("Mongo primary is on port %d", port)
+			return port
+		}
+	}
+
+	panic("Timed out waiting for a Mongo primary to be elected")
+}
+
+// Returns the port of the node that reports that it's primary, or 0 if none
+// of them do
+func (server *MongoServer) primaryPort() int {
+	for _, port := range []int{27001, 27002, 27003} {
+		session, err := mgo.DialWithInfo(&mgo.DialInfo{
+			Addrs:   []string{fmt.Sprintf("localhost:%d", port)},
+			Direct:  true,
+			Timeout: time.Second,
+		})
+		if err != nil {
+			continue
+		}
+
+		var result struct {
+			IsMaster bool `bson:"ismaster"`
+		}
+		err = session.Run("isMaster", &result)
+		session.Close()
+
+		if err == nil && result.IsMaster {
+			return port
+		}
+	}
+
+	return 0
+}
+
+// Returns the field holding the process of the node on the given port
+func (server *MongoServer) node(port int) **exec.Cmd {
+	switch port {
+	case 27001:
+		return &server.node1
+	case 27002:
+		return &server.node2
+	case 27003:
+		return &server.node3
+	}
+
+	panic(fmt.Sprintf("No Mongo node on port %d", port))
+}
+
 // startNode starts a single node of a mongo cluster, panicing on failure
 func (server *MongoServer) startNode(name string, port int) *exec.Cmd {
 	dbPath := filepath.Join(server.dataPrefix, name)
@@ -0,0 +1,237 @@
+package harness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// The port of the sharded cluster's mongos router
+const mongosPort = 27017
+
+// The port of the sharded cluster's (single-node) config server replica set
+const configServerPort = 27019
+
+// The ports of the sharded cluster's shards; each is a single-node replica
+// set named shard1, shard2, etc.
+var shardPorts = []int{27101, 27102}
+
+// MongoShardedCluster represents a Mongo sharded cluster with two shards, a
+// config server, and a mongos router, running on this host
+type MongoShardedCluster struct {
+	// The URL of the mongos router; this is what applications write to
+	Addr string
+
+	// The URLs of each shard's replica set. oplogtoredis tails the oplog of a
+	// single replica set, so you need one oplogtoredis per shard.
+	ShardAddrs []string
+
+	nodes      []*exec.Cmd
+	dataPrefix string
+}
+
+// StartMongoShardedCluster starts a sharded cluster and returns a
+// MongoShardedCluster for further operations. It doesn't return until the
+// shards have been added to the cluster.
+func StartMongoShardedCluster() *MongoShardedCluster {
+	dir, err := ioutil.TempDir("", "mongo-sharded")
+	if err != nil {
+		panic("Error making temp dir: " + err.Error())
+	}
+
+	cluster := MongoShardedCluster{
+		Addr:       fmt.Sprintf("mongodb://localhost:%d/testdb", mongosPort),
+		dataPrefix: dir,
+	}
+
+	log.Print("Starting up Mongo sharded cluster")
+
+	cluster.startReplSet("config", configServerPort, "--configsvr")
+	for i, port := range shardPorts {
+		name := fmt.Sprintf("shard%d", i+1)
+		cluster.startReplSet(name, port, "--shardsvr")
+		cluster.ShardAddrs = append(cluster.ShardAddrs,
+			fmt.Sprintf("mongodb://localhost:%d/testdb?replicaSet=%s", port, name))
+	}
+
+	mongos := exec.Command(
+		"mongos",
+		fmt.Sprintf("--configdb=config/localhost:%d", configServerPort),
+		fmt.Sprintf("--port=%d", mongosPort),
+	) // #nosec
+	mongos.Stdout = makeLogStreamer("mongos", "stdout")
+	mongos.Stderr = makeLogStreamer("mongos", "stderr")
+
+	err = mongos.Start()
+	if err != nil {
+		panic("Error starting up mongos: " + err.Error())
+	}
+	cluster.nodes = append(cluster.nodes, mongos)
+	waitTCP(fmt.Sprintf("localhost:%d", mongosPort))
+
+	client := cluster.Client()
+	defer client.Close()
+
+	for i, port := range shardPorts {
+		cluster.runAdmin(client, bson.D{{Name: "addShard", Value: fmt.Sprintf("shard%d/localhost:%d", i+1, port)}})
+	}
+
+	log.Print("Started up Mongo sharded cluster")
+
+	return &cluster
+}
+
+// Client returns an mgo.Session configured to talk to the mongos router
+func (cluster *MongoShardedCluster) Client() *mgo.Session {
+	dialInfo, err := mgo.ParseURL(cluster.Addr)
+	if err != nil {
+		panic("Error parsing mongo URL: " + err.Error())
+	}
+
+	dialInfo.Timeout = 5 * time.Second
+	client, err := mgo.DialWithInfo(dialInfo)
+	if err != nil {
+		panic("Error creating Mongo client: " + err.Error())
+	}
+
+	return client
+}
+
+// ShardCollection shards testdb.<collection> on _id, splits it into two
+// chunks at splitID, and puts the chunk starting at splitID on the second
+// shard
+func (cluster *MongoShardedCluster) ShardCollection(collection string, splitID string) {
+	client := cluster.Client()
+	defer client.Close()
+
+	namespace := "testdb." + collection
+
+	cluster.runAdmin(client, bson.D{{Name: "enableSharding", Value: "testdb"}})
+	cluster.runAdmin(client, bson.D{
+		{Name: "shardCollection", Value: namespace},
+		{Name: "key", Value: bson.M{"_id": 1}},
+	})
+	cluster.runAdmin(client, bson.D{
+		{Name: "split", Value: namespace},
+		{Name: "middle", Value: bson.M{"_id": splitID}},
+	})
+
+	cluster.MoveChunk(collection, splitID, "shard2")
+}
+
+// MoveChunk moves the chunk of testdb.<collection> that contains id to the
+// given shard (shard1 or shard2). It waits until the donor shard has deleted
+// its copy of the chunk's documents, so the migration's oplog entries have
+// all been written when it returns.
+func (cluster *MongoShardedCluster) MoveChunk(collection string, id string, shard string) {
+	log.Printf("Moving chunk containing %s to %s", id, shard)
+
+	client := cluster.Client()
+	defer client.Close()
+
+	cluster.runAdmin(client, bson.D{
+		{Name: "moveChunk", Value: "testdb." + collection},
+		{Name: "find", Value: bson.M{"_id": id}},
+		{Name: "to", Value: shard},
+		{Name: "_waitForDelete", Value: true},
+	})
+
+	log.Printf("Moved chunk containing %s to %s", id, shard)
+}
+
+// Stop shuts down every process in the cluster
+func (cluster *MongoShardedCluster) Stop() {
+	log.Print("Shutting down Mongo sharded cluster")
+
+	for _, node := range cluster.nodes {
+		err := node.Process.Kill()
+		if err != nil {
+			log.Printf("Error killing mongo process: %s", err)
+		}
+	}
+
+	waitTCPDown(fmt.Sprintf("localhost:%d", mongosPort))
+	waitTCPDown(fmt.Sprintf("localhost:%d", configServerPort))
+	for _, port := range shardPorts {
+		waitTCPDown(fmt.Sprintf("localhost:%d", port))
+	}
+
+	err := os.RemoveAll(cluster.dataPrefix)
+	if err != nil {
+		log.Printf("Error removing Mongo data: %s", err)
+	}
+
+	log.Print("Shut down Mongo sharded cluster")
+}
+
+// Starts a single-node replica set with the given name, and waits until its
+// node is primary. role is --configsvr or --shardsvr.
+func (cluster *MongoShardedCluster) startReplSet(name string, port int, role string) {
+	dbPath := filepath.Join(cluster.dataPrefix, name)
+	err := os.MkdirAll(dbPath, 0700)
+	if err != nil {
+		panic(err)
+	}
+
+	cmd := exec.Command(
+		"mongod",
+		role,
+		fmt.Sprintf("--replSet=%s", name),
+		fmt.Sprintf("--dbpath=%s", dbPath),
+		fmt.Sprintf("--port=%d", port),
+	) // #nosec
+	cmd.Stdout = makeLogStreamer(name, "stdout")
+	cmd.Stderr = makeLogStreamer(name, "stderr")
+
+	err = cmd.Start()
+	if err != nil {
+		panic("Error starting up mongo node: " + err.Error())
+	}
+	cluster.nodes = append(cluster.nodes, cmd)
+	waitTCP(fmt.Sprintf("localhost:%d", port))
+
+	client, err := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:   []string{fmt.Sprintf("127.0.0.1:%d", port)},
+		Direct:  true,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		panic("Error connecting to " + name + ": " + err.Error())
+	}
+	defer client.Close()
+	client.SetMode(mgo.Monotonic, true)
+
+	cluster.runAdmin(client, bson.D{{Name: "replSetInitiate", Value: bson.M{
+		"_id":       name,
+		"configsvr": role == "--configsvr",
+		"members":   []bson.M{{"_id": 0, "host": fmt.Sprintf("localhost:%d", port)}},
+	}}})
+
+	for startTime := time.Now(); time.Since(startTime) < 60*time.Second; time.Sleep(250 * time.Millisecond) {
+		var result struct {
+			IsMaster bool `bson:"ismaster"`
+		}
+		err = client.Run("isMaster", &result)
+		if err == nil && result.IsMaster {
+			log.Printf("Initialized replica set %s", name)
+			return
+		}
+	}
+
+	panic("Timed out waiting for replica set " + name + " to elect a primary")
+}
+
+// Runs an admin command, panicking if it fails
+func (cluster *MongoShardedCluster) runAdmin(client *mgo.Session, cmd bson.D) {
+	err := client.Run(cmd, nil)
+	if err != nil {
+		panic(fmt.Sprintf("Error running %s: %s", cmd[0].Name, err))
+	}
+}
@@ -85,6 +85,7 @@ func (proc *OTRProcess) GetPromMetrics() map[string]*promdata.MetricFamily {
 	if err != nil {
 		panic(err)
 	}
+	defer resp.Body.Close()
 
 	metrics, err := (&expfmt.TextParser{}).TextToMetricFamilies(resp.Body)
 	if err != nil {
@@ -94,6 +95,17 @@ func (proc *OTRProcess) GetPromMetrics() map[string]*promdata.MetricFamily {
 	return metrics
 }
 
+// GetProcessStats scrapes the resident memory size and goroutine count of
+// the OTR process from its prometheus metrics
+func (proc *OTRProcess) GetProcessStats() (rssBytes int64, goroutines int) {
+	metrics := proc.GetPromMetrics()
+
+	rss := FindPromMetric(metrics, "process_resident_memory_bytes", map[string]string{}).Gauge.GetValue()
+	routines := FindPromMetric(metrics, "go_goroutines", map[string]string{}).Gauge.GetValue()
+
+	return int64(rss), int(routines)
+}
+
 func randString(n int) string {
 	letterRunes := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 	b := make([]rune, n)
@@ -0,0 +1,226 @@
+package harness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// The ports of the cluster's masters; each has a replica on port+3
+var clusterMasterPorts = []int{7000, 7001, 7002}
+
+// RedisCluster represents a Redis Cluster with three masters and a replica of
+// each, running on this host
+type RedisCluster struct {
+	// A comma-separated list of URLs of the masters, in the form expected by
+	// OTR_REDIS_URL
+	Addr string
+
+	nodes map[int]*exec.Cmd
+	dir   string
+}
+
+// StartRedisCluster starts a Redis Cluster with masters on ports 7000-7002
+// and replicas on ports 7003-7005, and returns a RedisCluster for further
+// operations
+func StartRedisCluster() *RedisCluster {
+	dir, err := ioutil.TempDir("", "redis-cluster")
+	if err != nil {
+		panic("Error making temp dir: " + err.Error())
+	}
+
+	urls := make([]string, len(clusterMasterPorts))
+	for i, port := range clusterMasterPorts {
+		urls[i] = fmt.Sprintf("redis://localhost:%d", port)
+	}
+
+	cluster := RedisCluster{
+		Addr:  strings.Join(urls, ","),
+		nodes: map[int]*exec.Cmd{},
+		dir:   dir,
+	}
+
+	cluster.Start()
+
+	return &cluster
+}
+
+// Start starts up the cluster's nodes, joins them into a cluster, and splits
+// the hash slots between the masters. This is automatically called by
+// StartRedisCluster.
+//
+// This function does not return until every node reports that the cluster
+// is healthy.
+func (cluster *RedisCluster) Start() {
+	log.Print("Starting up Redis cluster")
+
+	for _, port := range cluster.ports() {
+		cluster.nodes[port] = startRedisProcess(fmt.Sprintf("redis:%d", port),
+			"--port", fmt.Sprint(port),
+			"--cluster-enabled", "yes",
+			"--cluster-config-file", filepath.Join(cluster.dir, fmt.Sprintf("nodes-%d.conf", port)),
+			"--cluster-node-timeout", "1000",
+			"--dir", cluster.dir)
+	}
+	for _, port := range cluster.ports() {
+		waitTCP(fmt.Sprintf("localhost:%d", port))
+	}
+
+	// Introduce every node to the first one
+	first := cluster.nodeClient(clusterMasterPorts[0])
+	defer first.Close()
+	for _, port := range cluster.ports()[1:] {
+		err := first.ClusterMeet("127.0.0.1", fmt.Sprint(port)).Err()
+		if err != nil {
+			panic("Error joining Redis cluster: " + err.Error())
+		}
+	}
+
+	// Split the slots evenly between the masters
+	slotsPerMaster := 16384 / len(clusterMasterPorts)
+	for i, port := range clusterMasterPorts {
+		min := i * slotsPerMaster
+		max := min + slotsPerMaster - 1
+		if i == len(clusterMasterPorts)-1 {
+			max = 16383
+		}
+
+		client := cluster.nodeClient(port)
+		err := client.ClusterAddSlotsRange(min, max).Err()
+		client.Close()
+		if err != nil {
+			panic("Error assigning Redis cluster slots: " + err.Error())
+		}
+	}
+
+	// Make the replicas replicate their masters. A replica can only
+	// replicate a master once it has heard about it.
+	for _, port := range clusterMasterPorts {
+		masterID := cluster.nodeID(port)
+
+		client := cluster.nodeClient(port + 3)
+		cluster.waitFor(fmt.Sprintf("node %d to learn about node %d", port+3, port), func() bool {
+			return client.ClusterReplicate(masterID).Err() == nil
+		})
+		client.Close()
+	}
+
+	for _, port := range cluster.ports() {
+		client := cluster.nodeClient(port)
+		cluster.waitFor(fmt.Sprintf("node %d to report a healthy cluster", port), func() bool {
+			info, err := client.ClusterInfo().Result()
+			return err == nil && strings.Contains(info, "cluster_state:ok")
+		})
+		client.Close()
+	}
+
+	log.Print("Started up Redis cluster")
+}
+
+// Stop kills all of the cluster's nodes
+func (cluster *RedisCluster) Stop() {
+	log.Print("Shutting down Redis cluster")
+
+	for port, node := range cluster.nodes {
+		err := node.Process.Kill()
+		if err != nil {
+			log.Printf("Error killing redis on port %d: %s", port, err)
+		}
+	}
+
+	for _, port := range cluster.ports() {
+		waitTCPDown(fmt.Sprintf("localhost:%d", port))
+	}
+
+	err := os.RemoveAll(cluster.dir)
+	if err != nil {
+		log.Printf("Error removing cluster directory: %s", err)
+	}
+
+	log.Print("Shut down Redis cluster")
+}
+
+// Failover promotes the replica of the master on the given port (which should
+// be one of 7000-7002), and waits until the promotion is complete
+func (cluster *RedisCluster) Failover(masterPort int) {
+	log.Printf("Failing over Redis cluster master %d", masterPort)
+
+	replica := cluster.nodeClient(masterPort + 3)
+	defer replica.Close()
+
+	err := replica.ClusterFailover().Err()
+	if err != nil {
+		panic("Error starting cluster failover: " + err.Error())
+	}
+
+	cluster.waitFor(fmt.Sprintf("node %d to become a master", masterPort+3), func() bool {
+		nodes, err := replica.ClusterNodes().Result()
+		return err == nil && strings.Contains(nodes, "myself,master")
+	})
+
+	log.Printf("Failed over Redis cluster master %d to %d", masterPort, masterPort+3)
+}
+
+// Client returns a go-redis cluster client for the cluster
+func (cluster *RedisCluster) Client() redis.UniversalClient {
+	addrs := make([]string, len(clusterMasterPorts))
+	for i, port := range clusterMasterPorts {
+		addrs[i] = fmt.Sprintf("localhost:%d", port)
+	}
+
+	return redis.NewClusterClient(&redis.ClusterOptions{Addrs: addrs})
+}
+
+// The ports of all of the cluster's nodes
+func (cluster *RedisCluster) ports() []int {
+	var ports []int
+	for _, port := range clusterMasterPorts {
+		ports = append(ports, port, port+3)
+	}
+
+	return ports
+}
+
+func (cluster *RedisCluster) nodeClient(port int) *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: fmt.Sprintf("localhost:%d", port)})
+}
+
+// Returns the cluster node ID of the node on the given port
+func (cluster *RedisCluster) nodeID(port int) string {
+	client := cluster.nodeClient(port)
+	defer client.Close()
+
+	nodes, err := client.ClusterNodes().Result()
+	if err != nil {
+		panic("Error getting Redis cluster nodes: " + err.Error())
+	}
+
+	for _, line := range strings.Split(nodes, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 2 && strings.Contains(fields[2], "myself") {
+			return fields[0]
+		}
+	}
+
+	panic(fmt.Sprintf("Could not find the node ID of node %d", port))
+}
+
+// Waits until condition returns true. Panics after 30 seconds.
+func (cluster *RedisCluster) waitFor(description string, condition func() bool) {
+	log.Printf("Waiting for %s", description)
+
+	for startTime := time.Now(); time.Since(startTime) < 30*time.Second; time.Sleep(250 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+
+	panic("Timed out waiting for " + description)
+}
@@ -0,0 +1,188 @@
+package harness
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisSentinel represents a Redis master and replica, monitored by a single
+// Redis Sentinel, running on this host
+type RedisSentinel struct {
+	// The URL of the sentinel
+	Addr string
+
+	// The name the sentinel knows the master by
+	MasterName string
+
+	master   *exec.Cmd
+	replica  *exec.Cmd
+	sentinel *exec.Cmd
+	dir      string
+}
+
+// StartRedisSentinel starts a Redis master on port 6379, a replica on port
+// 6380, and a sentinel on port 26379, and returns a RedisSentinel for further
+// operations
+func StartRedisSentinel() *RedisSentinel {
+	dir, err := ioutil.TempDir("", "redis-sentinel")
+	if err != nil {
+		panic("Error making temp dir: " + err.Error())
+	}
+
+	server := RedisSentinel{
+		Addr:       "redis://localhost:26379",
+		MasterName: "mymaster",
+		dir:        dir,
+	}
+
+	server.Start()
+
+	return &server
+}
+
+// Start starts up the master, replica, and sentinel. This is automatically
+// called by StartRedisSentinel, so you should only need to call this if
+// you've stopped them.
+//
+// This function does not return until the sentinel knows about the master.
+func (server *RedisSentinel) Start() {
+	log.Print("Starting up Redis master and replica")
+	server.master = startRedisProcess("redis:6379", "--port", "6379")
+	server.replica = startRedisProcess("redis:6380", "--port", "6380", "--slaveof", "127.0.0.1", "6379")
+	waitTCP("localhost:6379")
+	waitTCP("localhost:6380")
+
+	// Sentinel rewrites its config file, so it needs to be able to write it
+	configPath := filepath.Join(server.dir, "sentinel.conf")
+	config := fmt.Sprintf("port 26379\n"+
+		"sentinel monitor %s 127.0.0.1 6379 1\n"+
+		"sentinel down-after-milliseconds %s 1000\n"+
+		"sentinel failover-timeout %s 5000\n",
+		server.MasterName, server.MasterName, server.MasterName)
+	err := ioutil.WriteFile(configPath, []byte(config), 0644)
+	if err != nil {
+		panic("Error writing sentinel config: " + err.Error())
+	}
+
+	log.Print("Starting up Redis sentinel")
+	server.sentinel = startRedisProcess("sentinel", configPath, "--sentinel")
+	waitTCP("localhost:26379")
+
+	server.waitForMaster(func(addr string) bool { return addr != "" })
+	log.Print("Started up Redis sentinel")
+}
+
+// Stop kills the master, replica, and sentinel
+func (server *RedisSentinel) Stop() {
+	log.Print("Shutting down Redis sentinel")
+
+	for _, proc := range []*exec.Cmd{server.sentinel, server.master, server.replica} {
+		err := proc.Process.Kill()
+		if err != nil {
+			log.Printf("Error killing redis: %s", err)
+		}
+	}
+
+	waitTCPDown("localhost:26379")
+	waitTCPDown("localhost:6379")
+	waitTCPDown("localhost:6380")
+
+	err := os.RemoveAll(server.dir)
+	if err != nil {
+		log.Printf("Error removing sentinel config: %s", err)
+	}
+
+	log.Print("Shut down Redis sentinel")
+}
+
+// Failover asks the sentinel to fail over to the replica, and waits until it
+// reports the new master
+func (server *RedisSentinel) Failover() {
+	oldMaster := server.masterAddr()
+	log.Printf("Failing over Redis master %s", oldMaster)
+
+	client := server.sentinelClient()
+	defer client.Close()
+
+	err := client.Process(redis.NewStatusCmd("SENTINEL", "FAILOVER", server.MasterName))
+	if err != nil {
+		panic("Error starting sentinel failover: " + err.Error())
+	}
+
+	newMaster := server.waitForMaster(func(addr string) bool { return addr != oldMaster })
+	log.Printf("Failed over Redis master to %s", newMaster)
+}
+
+// Client returns a go-redis client that connects to the master through the
+// sentinel
+func (server *RedisSentinel) Client() redis.UniversalClient {
+	return redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    server.MasterName,
+		SentinelAddrs: []string{"localhost:26379"},
+	})
+}
+
+// OTREnv returns the extra environment variables oplogtoredis needs to
+// connect through the sentinel
+func (server *RedisSentinel) OTREnv() []string {
+	return []string{"OTR_REDIS_SENTINEL_MASTER=" + server.MasterName}
+}
+
+func (server *RedisSentinel) sentinelClient() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: "localhost:26379"})
+}
+
+// Returns the host:port of the master, according to the sentinel, or "" if
+// the sentinel doesn't know
+func (server *RedisSentinel) masterAddr() string {
+	client := server.sentinelClient()
+	defer client.Close()
+
+	cmd := redis.NewStringSliceCmd("SENTINEL", "GET-MASTER-ADDR-BY-NAME", server.MasterName)
+	err := client.Process(cmd)
+	if err != nil {
+		return ""
+	}
+
+	parts := cmd.Val()
+	if len(parts) != 2 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%s", parts[0], parts[1])
+}
+
+// Waits until the master's address satisfies the condition, and returns it.
+// Panics after 30 seconds.
+func (server *RedisSentinel) waitForMaster(condition func(addr string) bool) string {
+	for startTime := time.Now(); time.Since(startTime) < 30*time.Second; time.Sleep(250 * time.Millisecond) {
+		addr := server.masterAddr()
+		if condition(addr) {
+			return addr
+		}
+	}
+
+	panic("Timed out waiting for the sentinel to report the Redis master")
+}
+
+// Starts a redis-server process with the given arguments, logging its output
+// with the given name
+func startRedisProcess(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command("redis-server", args...) // #nosec
+	cmd.Stdout = makeLogStreamer(name, "stdout")
+	cmd.Stderr = makeLogStreamer(name, "stderr")
+
+	err := cmd.Start()
+	if err != nil {
+		panic("Error starting up " + name + ": " + err.Error())
+	}
+
+	return cmd
+}
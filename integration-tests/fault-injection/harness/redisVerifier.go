@@ -83,3 +83,71 @@ func (verifier *RedisVerifier) ReceivedCount() int {
 		}
 	}
 }
+
+// VerifyExactlyOnce verifies that every ID in confirmedIDs was published
+// exactly once, in order, and that nothing was published twice. It's for
+// tests that inject faults mid-write: an insert whose confirmation was lost
+// may still have been applied, so messages for IDs that aren't in
+// confirmedIDs are allowed (as long as they're not duplicated).
+//
+// It blocks until no messages have been received for 5 seconds.
+func (verifier *RedisVerifier) VerifyExactlyOnce(t *testing.T, confirmedIDs []string) {
+	verifier.verifyExactlyOnce(t, confirmedIDs, true)
+}
+
+// VerifyExactlyOnceAnyOrder is like VerifyExactlyOnce, but doesn't check the
+// order the messages arrived in. It's for tests with more than one
+// oplogtoredis publishing to the same channel (e.g. one per shard), where
+// there's no ordering between publishers.
+func (verifier *RedisVerifier) VerifyExactlyOnceAnyOrder(t *testing.T, confirmedIDs []string) {
+	verifier.verifyExactlyOnce(t, confirmedIDs, false)
+}
+
+func (verifier *RedisVerifier) verifyExactlyOnce(t *testing.T, confirmedIDs []string, ordered bool) {
+	var received []string
+	for {
+		select {
+		case id := <-verifier.receivedIDs:
+			received = append(received, id)
+			continue
+		case <-time.After(5 * time.Second):
+		}
+		break
+	}
+
+	counts := map[string]int{}
+	for _, id := range received {
+		counts[id]++
+		if counts[id] == 2 {
+			t.Errorf("Received message for %s more than once", id)
+		}
+	}
+
+	// Position of each confirmed ID in the insertion order
+	positions := map[string]int{}
+	for i, id := range confirmedIDs {
+		positions[id] = i
+		if counts[id] == 0 {
+			t.Errorf("Never received message for %s", id)
+		}
+	}
+
+	if !ordered {
+		return
+	}
+
+	// The confirmed IDs should have arrived in the order they were inserted
+	lastPosition := -1
+	for _, id := range received {
+		position, ok := positions[id]
+		if !ok {
+			log.Printf("Received message for unconfirmed insert %s", id)
+			continue
+		}
+
+		if position < lastPosition {
+			t.Errorf("Received message for %s out of order", id)
+		}
+		lastPosition = position
+	}
+}
@@ -0,0 +1,208 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/integration-tests/helpers"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The collection SoakLoad writes to
+const soakCollection = "Soak"
+
+// The typical number of documents SoakLoad keeps around to update and remove
+const soakLiveDocs = 1000
+
+// SoakLoad runs a mix of inserts, updates, and removes against the Soak
+// collection in the background, and measures how long it takes for the
+// messages for its inserts to be published.
+type SoakLoad struct {
+	db     *mgo.Database
+	pubsub *redis.PubSub
+
+	mutex   sync.Mutex
+	sent    map[string]time.Time
+	maxLag  time.Duration
+	writes  int
+	errors  int
+	stopped chan bool
+	done    sync.WaitGroup
+}
+
+// SoakSample is a snapshot of the load and of the oplogtoredis process under
+// test
+type SoakSample struct {
+	Elapsed    time.Duration
+	RSSBytes   int64
+	Goroutines int
+
+	// The highest lag seen since the previous sample (including the age of
+	// inserts that haven't been published yet), and the number of inserts
+	// that haven't been published yet
+	MaxLag  time.Duration
+	Pending int
+
+	// Writes attempted, and writes that failed, since the previous sample
+	Writes    int
+	WriteErrs int
+}
+
+func (sample SoakSample) String() string {
+	return fmt.Sprintf("elapsed=%s rss=%.1fMB goroutines=%d maxLag=%s pending=%d writes=%d writeErrors=%d",
+		sample.Elapsed.Round(time.Second), float64(sample.RSSBytes)/(1<<20), sample.Goroutines,
+		sample.MaxLag, sample.Pending, sample.Writes, sample.WriteErrs)
+}
+
+// StartSoakLoad starts writing to db at the given rate (writes per second),
+// and subscribes to the resulting messages with redisClient
+func StartSoakLoad(db *mgo.Database, redisClient redis.UniversalClient, rate float64) *SoakLoad {
+	load := SoakLoad{
+		db:      db,
+		pubsub:  redisClient.Subscribe(db.Name + "." + soakCollection),
+		sent:    map[string]time.Time{},
+		stopped: make(chan bool),
+	}
+
+	_, err := load.pubsub.Receive()
+	if err != nil {
+		panic("Error subscribing to soak messages: " + err.Error())
+	}
+
+	load.done.Add(2)
+	go load.receive()
+	go load.write(rate)
+
+	return &load
+}
+
+// Sample returns the lag and write counts since the last call to Sample
+// (or since the load started). The process fields are left for the caller
+// to fill in.
+func (load *SoakLoad) Sample() SoakSample {
+	load.mutex.Lock()
+	defer load.mutex.Unlock()
+
+	sample := SoakSample{
+		MaxLag:    load.maxLag,
+		Writes:    load.writes,
+		WriteErrs: load.errors,
+	}
+
+	// Inserts that still haven't been published count towards the lag
+	now := time.Now()
+	for _, sentAt := range load.sent {
+		sample.Pending++
+		if lag := now.Sub(sentAt); lag > sample.MaxLag {
+			sample.MaxLag = lag
+		}
+	}
+
+	load.maxLag = 0
+	load.writes = 0
+	load.errors = 0
+
+	return sample
+}
+
+// Stop stops writing, and unsubscribes from the messages
+func (load *SoakLoad) Stop() {
+	close(load.stopped)
+	err := load.pubsub.Close()
+	if err != nil {
+		log.Printf("Error closing soak subscription: %s", err)
+	}
+
+	load.done.Wait()
+}
+
+func (load *SoakLoad) write(rate float64) {
+	defer load.done.Done()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+
+	collection := load.db.C(soakCollection)
+
+	// The live documents are soak<oldest> through soak<next-1>
+	oldest, next := 0, 0
+
+	for {
+		select {
+		case <-load.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		// Inserts and removes are equally likely, so the collection doesn't
+		// grow (we fall back to one or the other to keep the number of live
+		// documents between soakLiveDocs/2 and soakLiveDocs*2). Every insert
+		// gives us a lag measurement.
+		var err error
+		live := next - oldest
+		switch op := rand.Intn(10); {
+		case live < soakLiveDocs/2 || (op < 4 && live < 2*soakLiveDocs):
+			id := fmt.Sprintf("soak%d", next)
+			next++
+
+			load.mutex.Lock()
+			load.sent[id] = time.Now()
+			load.mutex.Unlock()
+
+			err = collection.Insert(bson.M{"_id": id, "n": 0})
+			if err != nil {
+				load.mutex.Lock()
+				delete(load.sent, id)
+				load.mutex.Unlock()
+			}
+		case op < 6:
+			id := fmt.Sprintf("soak%d", next-1-rand.Intn(live))
+			err = collection.UpdateId(id, bson.M{"$inc": bson.M{"n": 1}})
+		default:
+			id := fmt.Sprintf("soak%d", oldest)
+			oldest++
+			err = collection.RemoveId(id)
+		}
+
+		load.mutex.Lock()
+		load.writes++
+		if err != nil {
+			load.errors++
+		}
+		load.mutex.Unlock()
+	}
+}
+
+func (load *SoakLoad) receive() {
+	defer load.done.Done()
+
+	for msg := range load.pubsub.Channel() {
+		received := time.Now()
+
+		parsedMsg := helpers.OTRMessage{}
+		err := json.Unmarshal([]byte(msg.Payload), &parsedMsg)
+		if err != nil {
+			panic("Error parsing message from Redis: " + err.Error())
+		}
+		if parsedMsg.Event != "i" {
+			continue
+		}
+
+		id, _ := parsedMsg.Document["_id"].(string)
+
+		load.mutex.Lock()
+		if sentAt, ok := load.sent[id]; ok {
+			if lag := received.Sub(sentAt); lag > load.maxLag {
+				load.maxLag = lag
+			}
+			delete(load.sent, id)
+		}
+		load.mutex.Unlock()
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/tulip/oplogtoredis/integration-tests/fault-injection/harness"
+)
+
+// This test kills the Mongo primary during execution, without giving it a
+// chance to step down. We expect that every insert that was confirmed by a
+// majority of the replica set is published exactly once.
+func TestMongoKillPrimary(t *testing.T) {
+	mongo := harness.StartMongoServer()
+	defer mongo.Stop()
+
+	redis := harness.StartRedisServer()
+	defer redis.Stop()
+
+	otr := harness.StartOTRProcess(mongo.Addr, redis.Addr, 9000)
+	defer otr.Stop()
+
+	mongoClient := mongo.Client()
+	defer mongoClient.Close()
+
+	// Inserts that are only confirmed by the primary could be rolled back
+	// when it's killed; majority-confirmed ones can't be
+	mongoClient.SetSafe(&mgo.Safe{WMode: "majority"})
+
+	redisClient := redis.Client()
+	defer redisClient.Close()
+
+	verifier := harness.NewRedisVerifier(redisClient)
+	inserter := harness.Run100InsertsInBackground(mongoClient.DB(""))
+
+	time.Sleep(time.Second)
+	killedPort := mongo.KillPrimary()
+	newPrimary := mongo.WaitForPrimary()
+	if newPrimary == killedPort {
+		t.Errorf("Expected a new primary, but %d is still primary", killedPort)
+	}
+
+	insertedIDs := inserter.Result()
+	mongo.RestartNode(killedPort)
+
+	if len(insertedIDs) < 50 {
+		t.Errorf("Expected at least 50 inserted IDs, got %d", len(insertedIDs))
+	}
+
+	if len(insertedIDs) >= 100 {
+		// If every insert was successful, the primary didn't go away
+		// mid-test; fail this test because it wasn't a valid test
+		t.Errorf("Expected no more than 99 successful writes, got %d", len(insertedIDs))
+	}
+
+	verifier.VerifyExactlyOnce(t, insertedIDs)
+}
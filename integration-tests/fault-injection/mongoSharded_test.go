@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tulip/oplogtoredis/integration-tests/fault-injection/harness"
+)
+
+// This test runs an oplogtoredis for each shard of a sharded cluster, and
+// moves chunks between the shards while inserts are running (and once more
+// after they've finished). Migrating a chunk copies its documents into the
+// recipient shard's oplog and deletes them from the donor's, so we expect
+// every insert to be published exactly once, and nothing else to be published.
+func TestMongoSharded(t *testing.T) {
+	mongo := harness.StartMongoShardedCluster()
+	defer mongo.Stop()
+
+	redis := harness.StartRedisServer()
+	defer redis.Stop()
+
+	// _ids from doc0 up to doc5 (lexicographically) start on shard1, and the
+	// rest on shard2
+	mongo.ShardCollection("Test", "doc5")
+
+	for i, shardAddr := range mongo.ShardAddrs {
+		otr := harness.StartOTRProcess(shardAddr, redis.Addr, 9000+i)
+		defer otr.Stop()
+	}
+
+	runShardedInserts(t, mongo, redis)
+}
+
+// This is the same as TestMongoSharded, but with a single oplogtoredis in
+// sharded mode, which connects to mongos and tails every shard itself.
+func TestMongoShardedSingleProcess(t *testing.T) {
+	mongo := harness.StartMongoShardedCluster()
+	defer mongo.Stop()
+
+	redis := harness.StartRedisServer()
+	defer redis.Stop()
+
+	mongo.ShardCollection("Test", "doc5")
+
+	otr := harness.StartOTRProcessWithEnv(mongo.Addr, redis.Addr, 9000, []string{
+		"OTR_SHARDED=true",
+	})
+	defer otr.Stop()
+
+	runShardedInserts(t, mongo, redis)
+}
+
+// Runs 100 inserts while moving chunks between the shards, and verifies that
+// each insert was published exactly once
+func runShardedInserts(t *testing.T, mongo *harness.MongoShardedCluster, redis *harness.RedisServer) {
+	mongoClient := mongo.Client()
+	defer mongoClient.Close()
+
+	redisClient := redis.Client()
+	defer redisClient.Close()
+
+	verifier := harness.NewRedisVerifier(redisClient)
+	inserter := harness.Run100InsertsInBackground(mongoClient.DB(""))
+
+	time.Sleep(2 * time.Second)
+	mongo.MoveChunk("Test", "doc0", "shard2")
+	time.Sleep(2 * time.Second)
+	mongo.MoveChunk("Test", "doc5", "shard1")
+	time.Sleep(2 * time.Second)
+	mongo.MoveChunk("Test", "doc0", "shard1")
+
+	insertedIDs := inserter.Result()
+
+	// Move a chunk that's no longer being written to, so every document in
+	// it is migrated
+	mongo.MoveChunk("Test", "doc5", "shard2")
+
+	if len(insertedIDs) != 100 {
+		t.Errorf("Expected 100 successful inserts, got %d", len(insertedIDs))
+	}
+
+	verifier.VerifyExactlyOnceAnyOrder(t, insertedIDs)
+}
@@ -8,7 +8,8 @@ import (
 )
 
 // This test triggers a mongo stepdown during execution. We expect that every
-// insert that was confirmed by mongo was picked up by oplogtoredis.
+// insert that was confirmed by mongo was picked up by oplogtoredis, and that
+// nothing was published twice.
 func TestMongoStepdown(t *testing.T) {
 	mongo := harness.StartMongoServer()
 	defer mongo.Stop()
@@ -45,5 +46,5 @@ func TestMongoStepdown(t *testing.T) {
 		t.Errorf("Expected no more than 99 successful writes, got %d", len(insertedIDs))
 	}
 
-	verifier.Verify(t, insertedIDs)
+	verifier.VerifyExactlyOnce(t, insertedIDs)
 }
@@ -54,4 +54,10 @@ func TestMongoStopStart(t *testing.T) {
 	}
 
 	verifier.Verify(t, insertedIDs)
+
+	// Tailing should have been restarted when Mongo went away
+	restarts := harness.FindPromMetricCounter(otr.GetPromMetrics(), "otr_oplog_tail_restarts", map[string]string{})
+	if restarts < 1 {
+		t.Errorf("Metric otr_oplog_tail_restarts = %d, expected at least 1", restarts)
+	}
 }
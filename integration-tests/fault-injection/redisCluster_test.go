@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tulip/oplogtoredis/integration-tests/fault-injection/harness"
+)
+
+// This test runs against a Redis Cluster without injecting any faults
+func TestRedisCluster(t *testing.T) {
+	mongo := harness.StartMongoServer()
+	defer mongo.Stop()
+
+	redis := harness.StartRedisCluster()
+	defer redis.Stop()
+
+	otr := harness.StartOTRProcess(mongo.Addr, redis.Addr, 9000)
+	defer otr.Stop()
+
+	mongoClient := mongo.Client()
+	defer mongoClient.Close()
+
+	redisClient := redis.Client()
+	defer redisClient.Close()
+
+	verifier := harness.NewRedisVerifier(redisClient)
+	inserter := harness.Run100InsertsInBackground(mongoClient.DB(""))
+
+	verifier.Verify(t, inserter.Result())
+}
+
+// This test runs against a Redis Cluster, and fails over every master to its
+// replica during the test. Like TestRedisSentinelFailover, it only loosely
+// verifies what our listener receives.
+func TestRedisClusterFailover(t *testing.T) {
+	mongo := harness.StartMongoServer()
+	defer mongo.Stop()
+
+	redis := harness.StartRedisCluster()
+	defer redis.Stop()
+
+	otr := harness.StartOTRProcess(mongo.Addr, redis.Addr, 9000)
+	defer otr.Stop()
+
+	mongoClient := mongo.Client()
+	defer mongoClient.Close()
+
+	redisClient := redis.Client()
+	defer redisClient.Close()
+
+	verifier := harness.NewRedisVerifier(redisClient)
+	inserter := harness.Run100InsertsInBackground(mongoClient.DB(""))
+
+	time.Sleep(2 * time.Second)
+	for _, port := range []int{7000, 7001, 7002} {
+		redis.Failover(port)
+	}
+
+	inserter.Result()
+
+	receivedCount := verifier.ReceivedCount()
+	if receivedCount < 60 {
+		t.Errorf("Expected at least 60 received messages, got %d", receivedCount)
+	}
+
+	verifyAllSent(t, otr)
+}
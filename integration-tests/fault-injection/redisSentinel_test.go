@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tulip/oplogtoredis/integration-tests/fault-injection/harness"
+)
+
+// This test runs against a Redis master and replica monitored by Sentinel,
+// and fails over to the replica during the test.
+//
+// Like TestRedisStopStart, it does looser verification than the other
+// tests: our listener may miss messages while it follows the failover, but
+// oplogtoredis should follow it too, and send every message exactly once.
+func TestRedisSentinelFailover(t *testing.T) {
+	mongo := harness.StartMongoServer()
+	defer mongo.Stop()
+
+	redis := harness.StartRedisSentinel()
+	defer redis.Stop()
+
+	otr := harness.StartOTRProcessWithEnv(mongo.Addr, redis.Addr, 9000, redis.OTREnv())
+	defer otr.Stop()
+
+	mongoClient := mongo.Client()
+	defer mongoClient.Close()
+
+	redisClient := redis.Client()
+	defer redisClient.Close()
+
+	verifier := harness.NewRedisVerifier(redisClient)
+	inserter := harness.Run100InsertsInBackground(mongoClient.DB(""))
+
+	time.Sleep(2 * time.Second)
+	redis.Failover()
+
+	inserter.Result()
+
+	receivedCount := verifier.ReceivedCount()
+	if receivedCount < 60 {
+		t.Errorf("Expected at least 60 received messages, got %d", receivedCount)
+	}
+
+	verifyAllSent(t, otr)
+}
+
+// Checks that oplogtoredis sent exactly 100 messages, and gave up on none
+func verifyAllSent(t *testing.T, otr *harness.OTRProcess) {
+	metrics := otr.GetPromMetrics()
+
+	nSuccess := harness.FindPromMetricCounter(metrics, "otr_redispub_processed_messages", map[string]string{
+		"status": "sent",
+	})
+	if nSuccess != 100 {
+		t.Errorf("Metric otr_redispub_processed_messages(status: sent) = %d, expected 100", nSuccess)
+	}
+
+	nPermFail := harness.FindPromMetricCounter(metrics, "otr_redispub_processed_messages", map[string]string{
+		"status": "failed",
+	})
+	if nPermFail != 0 {
+		t.Errorf("Metric otr_redispub_processed_messages(status: failed) = %d, expected 0", nPermFail)
+	}
+}
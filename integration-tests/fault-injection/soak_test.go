@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/tulip/oplogtoredis/integration-tests/fault-injection/harness"
+)
+
+// Thresholds for TestSoak. The baseline is taken after the warmup period,
+// once caches and buffers have filled up.
+const (
+	soakWarmup = 5 * time.Minute
+
+	// How much RSS and goroutine count may grow over the baseline
+	soakMaxRSSGrowth       = 1.5
+	soakMaxGoroutineGrowth = 20
+
+	// The most lag we tolerate in any sample
+	soakMaxLag = 10 * time.Second
+)
+
+// TestSoak runs a mixed write load for a long time (SOAK_DURATION, e.g. 4h)
+// while sampling oplogtoredis's memory use, goroutine count, and lag, to
+// catch slow leaks in the oplog cursor and publisher loops. It only runs when
+// SOAK_DURATION is set; use scripts/runIntegrationSoak.sh.
+//
+// SOAK_RATE sets the writes per second (default 100), and SOAK_INTERVAL how
+// often to sample (default 1m).
+func TestSoak(t *testing.T) {
+	duration := soakEnvDuration(t, "SOAK_DURATION", 0)
+	if duration == 0 {
+		t.Skip("SOAK_DURATION is not set")
+	}
+	interval := soakEnvDuration(t, "SOAK_INTERVAL", time.Minute)
+
+	rate := 100.0
+	if rateStr := os.Getenv("SOAK_RATE"); rateStr != "" {
+		var err error
+		rate, err = strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			t.Fatalf("Invalid SOAK_RATE %q", rateStr)
+		}
+	}
+
+	mongo := harness.StartMongoServer()
+	defer mongo.Stop()
+
+	redis := harness.StartRedisServer()
+	defer redis.Stop()
+
+	// Debug logging for hours on end is too much
+	otr := harness.StartOTRProcessWithEnv(mongo.Addr, redis.Addr, 9000, []string{"OTR_LOG_DEBUG=false"})
+	defer otr.Stop()
+
+	mongoClient := mongo.Client()
+	defer mongoClient.Close()
+
+	redisClient := redis.Client()
+	defer redisClient.Close()
+
+	log.Printf("Soaking for %s at %.0f writes/sec", duration, rate)
+	load := harness.StartSoakLoad(mongoClient.DB(""), redisClient, rate)
+	defer load.Stop()
+
+	var baseline *harness.SoakSample
+	var last harness.SoakSample
+	startTime := time.Now()
+
+	for time.Since(startTime) < duration {
+		time.Sleep(interval)
+
+		sample := load.Sample()
+		sample.Elapsed = time.Since(startTime)
+		sample.RSSBytes, sample.Goroutines = otr.GetProcessStats()
+		log.Printf("Soak sample: %s", sample)
+
+		if sample.MaxLag > soakMaxLag {
+			t.Errorf("Lag of %s at %s exceeds %s", sample.MaxLag, sample.Elapsed, soakMaxLag)
+		}
+
+		if baseline == nil && sample.Elapsed >= soakWarmup {
+			baseline = &sample
+		}
+		last = sample
+	}
+
+	if baseline == nil {
+		t.Fatalf("SOAK_DURATION must be longer than the %s warmup", soakWarmup)
+	}
+
+	log.Printf("Soak baseline: %s", *baseline)
+	log.Printf("Soak final: %s", last)
+
+	if float64(last.RSSBytes) > float64(baseline.RSSBytes)*soakMaxRSSGrowth {
+		t.Errorf("RSS grew from %d to %d bytes", baseline.RSSBytes, last.RSSBytes)
+	}
+
+	if last.Goroutines > baseline.Goroutines+soakMaxGoroutineGrowth {
+		t.Errorf("Goroutines grew from %d to %d", baseline.Goroutines, last.Goroutines)
+	}
+}
+
+// Parses a duration from an environment variable, returning def if it's not
+// set
+func soakEnvDuration(t *testing.T, name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		t.Fatalf("Invalid %s %q: %s", name, value, err)
+	}
+
+	return duration
+}
@@ -0,0 +1,85 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis/oplogtest"
+)
+
+// OplogInjector runs an oplogtoredis pipeline in the test process that reads
+// hand-crafted oplog entries instead of tailing Mongo, and publishes to
+// Redis just like the oplogtoredis under test. It lets tests cover oplog
+// shapes that are hard or impossible to produce with Mongo writes, like
+// transactions, $v:2 update diffs, and unusual _ids.
+//
+// Build entries with the oplogtest package's helpers (oplogtest.Insert,
+// oplogtest.UpdateV2, etc.).
+type OplogInjector struct {
+	gen    *oplogtest.Generator
+	cancel context.CancelFunc
+	done   chan bool
+}
+
+// StartOplogInjector starts an OplogInjector that publishes to the given
+// Redis client. It doesn't connect to Mongo.
+func StartOplogInjector(redisClient redis.UniversalClient) *OplogInjector {
+	start := time.Now()
+	gen := oplogtest.NewGenerator(start)
+
+	pipeline, err := oplogtoredis.New(oplogtoredis.Config{
+		RedisClient: redisClient,
+
+		// Use our own metadata prefix so we don't dedupe against, or
+		// overwrite the last-processed timestamp of, the real oplogtoredis
+		MetadataPrefix: fmt.Sprintf("oplog-injector-%d::", start.UnixNano()),
+
+		TailerOptions: []oplogtoredis.TailerOption{
+			oplogtoredis.WithSource(gen.Source()),
+
+			// Start from before the first entry, no matter how soon
+			// entries are injected
+			oplogtoredis.WithResumeFrom(oplogtoredis.TimestampAt(start)),
+		},
+	})
+	if err != nil {
+		panic("Could not create oplog injector pipeline: " + err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	injector := OplogInjector{
+		gen:    gen,
+		cancel: cancel,
+		done:   make(chan bool),
+	}
+
+	go func() {
+		err := pipeline.Run(ctx)
+		if err != context.Canceled {
+			panic(fmt.Sprintf("Oplog injector pipeline stopped: %v", err))
+		}
+		close(injector.done)
+	}()
+
+	return &injector
+}
+
+// Inject adds entries to the oplog that the injector's pipeline is reading.
+// They're given increasing timestamps, in order. It returns without waiting
+// for them to be published.
+func (injector *OplogInjector) Inject(entries ...map[string]interface{}) {
+	_, err := injector.gen.Add(entries...)
+	if err != nil {
+		panic("Could not marshal injected oplog entries: " + err.Error())
+	}
+}
+
+// Stop shuts down the injector's pipeline, after it has published every
+// entry it has already read
+func (injector *OplogInjector) Stop() {
+	injector.cancel()
+	<-injector.done
+}
@@ -2,17 +2,37 @@ package helpers
 
 import (
 	"os"
+	"strings"
 
 	"github.com/go-redis/redis"
 )
 
 // RedisClient returns a redis client to the URL specified in the REDIS_URL
-// env var
-func RedisClient() *redis.Client {
-	redisOpts, err := redis.ParseURL(os.Getenv("REDIS_URL"))
-	if err != nil {
-		panic(err)
+// env var.
+//
+// Like OTR_REDIS_URL, REDIS_URL may be a comma-separated list of URLs. If
+// REDIS_SENTINEL_MASTER is set, they're the URLs of Redis Sentinels;
+// otherwise, if there's more than one, they're nodes of a Redis Cluster.
+func RedisClient() redis.UniversalClient {
+	var addrs []string
+	var firstOpts *redis.Options
+
+	for _, url := range strings.Split(os.Getenv("REDIS_URL"), ",") {
+		redisOpts, err := redis.ParseURL(url)
+		if err != nil {
+			panic(err)
+		}
+
+		if firstOpts == nil {
+			firstOpts = redisOpts
+		}
+		addrs = append(addrs, redisOpts.Addr)
 	}
 
-	return redis.NewClient(redisOpts)
+	return redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs:      addrs,
+		MasterName: os.Getenv("REDIS_SENTINEL_MASTER"),
+		DB:         firstOpts.DB,
+		Password:   firstOpts.Password,
+	})
 }
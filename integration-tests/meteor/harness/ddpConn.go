@@ -38,10 +38,17 @@ func newDDPConn(url string) *DDPConn {
 		receivedMessages: make(chan *DDPMsg, 1000),
 	}
 
+	// The test only waits for the first two Meteor servers to come up before
+	// starting, so retry for a while in case this one is still starting
 	var err error
-	conn.ws, _, err = websocket.DefaultDialer.Dial(url, http.Header{})
-	if err != nil {
-		panic(err)
+	for startTime := time.Now(); ; time.Sleep(time.Second) {
+		conn.ws, _, err = websocket.DefaultDialer.Dial(url, http.Header{})
+		if err == nil {
+			break
+		}
+		if time.Since(startTime) > 2*time.Minute {
+			panic(err)
+		}
 	}
 
 	conn.handshake()
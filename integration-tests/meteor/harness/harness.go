@@ -1,34 +1,82 @@
 package harness
 
 import (
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/tulip/oplogtoredis/integration-tests/helpers"
 )
 
-var server1Conn *DDPConn
-var server2Conn *DDPConn
+var serverConns []*DDPConn
 
-// Start connects to both Meteor servers and returns (server 1 conn, server 2 conn)
+// Start connects to the first two Meteor servers and returns (server 1 conn,
+// server 2 conn)
 func Start() (*DDPConn, *DDPConn) {
-	server1Conn, server2Conn = StartWithFixtures(helpers.DBData{})
-
-	return server1Conn, server2Conn
+	return StartWithFixtures(helpers.DBData{})
 }
 
 // StartWithFixtures is like Start, but seeds the Mongo DB with a given set of
 // records
 func StartWithFixtures(fixtures helpers.DBData) (*DDPConn, *DDPConn) {
+	conns := StartNWithFixtures(2, fixtures)
+
+	return conns[0], conns[1]
+}
+
+// StartN connects to the first n Meteor servers and returns a conn for each.
+// It panics if fewer than n servers are configured; use NumServers to find out
+// how many there are.
+func StartN(n int) []*DDPConn {
+	return StartNWithFixtures(n, helpers.DBData{})
+}
+
+// StartNWithFixtures is like StartN, but seeds the Mongo DB with a given set
+// of records
+func StartNWithFixtures(n int, fixtures helpers.DBData) []*DDPConn {
+	urls := serverURLs()
+	if len(urls) < n {
+		panic(fmt.Sprintf("Wanted %d Meteor servers, but only %d are configured", n, len(urls)))
+	}
+
 	helpers.SeedTestDB(fixtures)
 
-	server1Conn = newDDPConn(os.Getenv("TESTAPP_1_URL"))
-	server2Conn = newDDPConn(os.Getenv("TESTAPP_2_URL"))
+	serverConns = make([]*DDPConn, n)
+	for i, url := range urls[:n] {
+		serverConns[i] = newDDPConn(url)
+	}
 
-	return server1Conn, server2Conn
+	return serverConns
 }
 
-// Stop disconnects from both meteor servers
+// NumServers returns the number of Meteor servers available to the tests
+func NumServers() int {
+	return len(serverURLs())
+}
+
+// Stop disconnects from all the meteor servers
 func Stop() {
-	server1Conn.Close()
-	server2Conn.Close()
+	for _, conn := range serverConns {
+		conn.Close()
+	}
+	serverConns = nil
+}
+
+// Returns the websocket URLs of the Meteor servers. They're read from
+// TESTAPP_URLS (a comma-separated list) if it's set, and otherwise from
+// TESTAPP_1_URL, TESTAPP_2_URL, etc.
+func serverURLs() []string {
+	if urls := os.Getenv("TESTAPP_URLS"); urls != "" {
+		return strings.Split(urls, ",")
+	}
+
+	var urls []string
+	for i := 1; ; i++ {
+		url := os.Getenv(fmt.Sprintf("TESTAPP_%d_URL", i))
+		if url == "" {
+			return urls
+		}
+
+		urls = append(urls, url)
+	}
 }
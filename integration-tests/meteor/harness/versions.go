@@ -0,0 +1,87 @@
+package harness
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The versions the test app is pinned to in testapp/.meteor, which are used
+// unless METEOR_RELEASE or REDIS_OPLOG_VERSION say otherwise
+const defaultMeteorRelease = "1.6.1.1"
+const defaultRedisOplogVersion = "1.2.7_1"
+
+// Versions describes the versions of Meteor and redis-oplog that the Meteor
+// servers under test were built with
+type Versions struct {
+	Meteor     string
+	RedisOplog string
+}
+
+// VersionsUnderTest returns the versions of Meteor and redis-oplog that the
+// Meteor servers were built with, as set by scripts/runIntegrationMeteor.sh
+func VersionsUnderTest() Versions {
+	versions := Versions{
+		Meteor:     os.Getenv("METEOR_RELEASE"),
+		RedisOplog: os.Getenv("REDIS_OPLOG_VERSION"),
+	}
+
+	if versions.Meteor == "" {
+		versions.Meteor = defaultMeteorRelease
+	}
+	if versions.RedisOplog == "" {
+		versions.RedisOplog = defaultRedisOplogVersion
+	}
+
+	return versions
+}
+
+// RequireRedisOplog skips the test unless the redis-oplog under test is at
+// least minVersion. Use it for tests of behavior that older versions of
+// redis-oplog don't support.
+func RequireRedisOplog(t *testing.T, minVersion string) {
+	version := VersionsUnderTest().RedisOplog
+	if compareVersions(version, minVersion) < 0 {
+		t.Skipf("Requires redis-oplog %s or later; testing %s", minVersion, version)
+	}
+}
+
+// RequireMeteor skips the test unless the Meteor release under test is at
+// least minVersion
+func RequireMeteor(t *testing.T, minVersion string) {
+	version := VersionsUnderTest().Meteor
+	if compareVersions(version, minVersion) < 0 {
+		t.Skipf("Requires Meteor %s or later; testing %s", minVersion, version)
+	}
+}
+
+// Compares two Meteor-style version numbers (like 1.6.1.1 or 1.2.7_1),
+// returning -1, 0, or 1. Components are compared numerically; the _N suffix
+// Meteor uses for wrapped packages is treated as one more component.
+func compareVersions(a string, b string) int {
+	aParts := strings.FieldsFunc(a, isVersionSeparator)
+	bParts := strings.FieldsFunc(b, isVersionSeparator)
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := 0, 0
+		if i < len(aParts) {
+			aPart, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bPart, _ = strconv.Atoi(bParts[i])
+		}
+
+		if aPart < bPart {
+			return -1
+		} else if aPart > bPart {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func isVersionSeparator(r rune) bool {
+	return r == '.' || r == '_'
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tulip/oplogtoredis/integration-tests/meteor/harness"
+)
+
+// Checks that an insert on one Meteor server reaches every other server, when
+// there are more than two (set METEOR_INSTANCES when running
+// scripts/runIntegrationMeteor.sh)
+func TestInsertAllInstances(t *testing.T) {
+	n := harness.NumServers()
+	if n <= 2 {
+		t.Skipf("Only %d Meteor servers; TestInsert already covers this", n)
+	}
+
+	meteors := harness.StartN(n)
+	defer harness.Stop()
+
+	for _, meteor := range meteors {
+		meteor.Send(harness.DDPSub("subId", "tasks"))
+	}
+	for _, meteor := range meteors {
+		meteor.ClearReceiveBuffer()
+	}
+
+	meteors[0].Send(harness.DDPMethod("methodCallId", "tasks.insert", "some text"))
+
+	meteors[0].VerifyReceive(t, harness.DDPMsgGroup{
+		harness.DDPResult("methodCallId", harness.DDPData{}),
+		harness.DDPAdded("tasks", harness.DDPFirstRandomID, harness.DDPData{
+			"text":     "some text",
+			"owner":    "testuser",
+			"username": "Test User",
+		}),
+	}, harness.DDPMsgGroup{
+		harness.DDPUpdated([]string{"methodCallId"}),
+	})
+
+	for _, meteor := range meteors[1:] {
+		meteor.VerifyReceive(t, harness.DDPMsgGroup{
+			harness.DDPAdded("tasks", harness.DDPFirstRandomID, harness.DDPData{
+				"text":     "some text",
+				"owner":    "testuser",
+				"username": "Test User",
+			}),
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tulip/oplogtoredis/integration-tests/meteor/harness"
+)
+
+// Array operators, positional updates, and deep nested updates are where
+// working out the changed fields from the oplog has historically gone wrong.
+// For each, both Meteor servers must end up with the same, correct document.
+func TestNestedAndArrayUpdates(t *testing.T) {
+	tests := map[string]struct {
+		method string
+		want   harness.DDPData
+	}{
+		"$push": {
+			method: "nestedTest.push",
+			want: harness.DDPData{
+				"tags": []interface{}{"a", "b", "c", "d"},
+			},
+		},
+		"$pull": {
+			method: "nestedTest.pull",
+			want: harness.DDPData{
+				"tags": []interface{}{"a", "c"},
+			},
+		},
+		"Positional $set": {
+			method: "nestedTest.positional",
+			want: harness.DDPData{
+				"items": []interface{}{
+					map[string]interface{}{"name": "x", "qty": 1},
+					map[string]interface{}{"name": "y", "qty": 5},
+				},
+			},
+		},
+		"Deep $set": {
+			method: "nestedTest.deepSet",
+			want: harness.DDPData{
+				"profile": map[string]interface{}{
+					"name":    "someone",
+					"address": map[string]interface{}{"city": "Cambridge", "zip": "02110"},
+				},
+			},
+		},
+		"Deep $unset": {
+			method: "nestedTest.deepUnset",
+			want: harness.DDPData{
+				"profile": map[string]interface{}{
+					"name":    "someone",
+					"address": map[string]interface{}{"city": "Boston"},
+				},
+			},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			meteor1, meteor2 := harness.Start()
+			defer harness.Stop()
+
+			meteor1.Send(harness.DDPMethod("insertCall", "nestedTest.initializeFixtures"))
+
+			// Subscribe to nestedTest from both servers
+			meteor1.Send(harness.DDPSub("subId", "nestedTest.pub"))
+			meteor2.Send(harness.DDPSub("subId", "nestedTest.pub"))
+
+			meteor1.ClearReceiveBuffer()
+			meteor2.ClearReceiveBuffer()
+
+			meteor1.Send(harness.DDPMethod("methodCallId", test.method))
+
+			expectedChange := harness.DDPChanged("nestedTest", "test", test.want, []string{})
+
+			// On meteor1, we should get changed and result, and then updated
+			meteor1.VerifyReceive(t, harness.DDPMsgGroup{
+				harness.DDPResult("methodCallId", harness.DDPData{}),
+				expectedChange,
+			}, harness.DDPMsgGroup{
+				harness.DDPUpdated([]string{"methodCallId"}),
+			})
+
+			// On meteor2, we should just get changed
+			meteor2.VerifyReceive(t, harness.DDPMsgGroup{
+				expectedChange,
+			})
+		})
+	}
+}
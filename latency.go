@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+)
+
+// The upper bounds of the buckets in the latency histogram
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// Implements `oplogtoredis latency`, which inserts documents into a test
+// Mongo database, waits for the corresponding messages to arrive from Redis,
+// and reports the distribution of end-to-end latencies. It's used to
+// validate changes to how oplogtoredis batches and publishes messages.
+//
+// Latency is measured from just before each insert is sent to Mongo, so it
+// includes the time Mongo takes to acknowledge the write. Like loadgen, it
+// never writes to a database unless it's explicitly named with --db.
+func runLatency(args []string) error {
+	flags := flag.NewFlagSet("latency", flag.ContinueOnError)
+	mongoURL := flags.String("mongo-url", os.Getenv("OTR_MONGO_URL"), "Mongo URL to write to. Defaults to OTR_MONGO_URL.")
+	redisURL := flags.String("redis-url", os.Getenv("OTR_REDIS_URL"), "Redis URL to subscribe to. Defaults to OTR_REDIS_URL.")
+	prefix := flags.String("prefix", os.Getenv("OTR_CHANNEL_PREFIX"), "Channel prefix. Defaults to OTR_CHANNEL_PREFIX.")
+	dbName := flags.String("db", "", "Database to write to. Required.")
+	collectionName := flags.String("collection", "latency", "Collection to write to")
+	count := flags.Int("n", 1000, "Number of documents to insert")
+	rate := flags.Float64("rate", 50, "Inserts per second")
+	timeout := flags.Duration("timeout", 10*time.Second, "How long to wait for messages after the last insert")
+	outPath := flags.String("out", "", "Also write each latency, in milliseconds, to this file (one per line)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *dbName == "" {
+		return errors.New("--db is required")
+	}
+	if *count <= 0 || *rate <= 0 {
+		return errors.New("-n and --rate must be positive")
+	}
+
+	mongoClient, err := dialMongo(*mongoURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+
+	client, err := dialRedis(*redisURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	channel := *prefix + *dbName + "." + *collectionName
+	pubsub := client.Subscribe(channel)
+	defer pubsub.Close()
+
+	_, err = pubsub.Receive()
+	if err != nil {
+		return fmt.Errorf("Error subscribing to %s: %s", channel, err)
+	}
+
+	// Every document in this run has an _id starting with runID, so we can
+	// ignore messages from other writers and clean up afterwards
+	runID := "latency-" + bson.NewObjectId().Hex()
+	m := &latencyMeasurement{sent: map[string]time.Time{}}
+
+	go m.receive(pubsub.Channel())
+
+	collection := mongoClient.Database(*dbName).Collection(*collectionName)
+	defer func() {
+		_, removeErr := collection.DeleteMany(context.Background(), mongodb.Doc(bson.M{"_id": bson.M{"$regex": "^" + runID}}))
+		if removeErr != nil {
+			fmt.Fprintf(os.Stderr, "Error removing test documents: %s\n", removeErr)
+		}
+	}()
+
+	fmt.Fprintf(os.Stderr, "Inserting %d documents into %s.%s at %.1f/sec, listening on %s\n",
+		*count, *dbName, *collectionName, *rate, channel)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+
+	writeErrors := 0
+	for i := 0; i < *count; i++ {
+		<-ticker.C
+
+		id := fmt.Sprintf("%s-%d", runID, i)
+		m.markSent(id)
+
+		_, insertErr := collection.InsertOne(context.Background(), mongodb.Doc(bson.M{"_id": id}))
+		if insertErr != nil {
+			m.unmarkSent(id)
+			writeErrors++
+			fmt.Fprintf(os.Stderr, "Error inserting %s: %s\n", id, insertErr)
+		}
+	}
+
+	m.waitForAll(*timeout)
+	latencies, lost := m.result()
+
+	printLatencyReport(latencies, lost, writeErrors)
+
+	if *outPath != "" {
+		err = writeLatencies(*outPath, latencies)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tracks the documents we've inserted and the latencies of the messages
+// we've received for them
+type latencyMeasurement struct {
+	mutex     sync.Mutex
+	sent      map[string]time.Time
+	latencies []time.Duration
+}
+
+func (m *latencyMeasurement) markSent(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sent[id] = time.Now()
+}
+
+func (m *latencyMeasurement) unmarkSent(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sent, id)
+}
+
+// Reads messages until the subscription is closed, recording the latency of
+// each one for a document we inserted
+func (m *latencyMeasurement) receive(messages <-chan *redis.Message) {
+	for msg := range messages {
+		received := time.Now()
+
+		decoded, err := decodeMessage([]byte(msg.Payload))
+		if err != nil {
+			continue
+		}
+		id, _ := decoded.Doc["_id"].(string)
+
+		m.mutex.Lock()
+		if sentAt, ok := m.sent[id]; ok {
+			m.latencies = append(m.latencies, received.Sub(sentAt))
+			delete(m.sent, id)
+		}
+		m.mutex.Unlock()
+	}
+}
+
+// Waits until every message has been received, or until nothing has been
+// received for timeout
+func (m *latencyMeasurement) waitForAll(timeout time.Duration) {
+	lastProgress := time.Now()
+	lastCount := -1
+
+	for time.Since(lastProgress) < timeout {
+		m.mutex.Lock()
+		remaining := len(m.sent)
+		received := len(m.latencies)
+		m.mutex.Unlock()
+
+		if remaining == 0 {
+			return
+		}
+		if received != lastCount {
+			lastCount = received
+			lastProgress = time.Now()
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Returns the latencies received, sorted, and the number of documents whose
+// messages never arrived
+func (m *latencyMeasurement) result() ([]time.Duration, int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	latencies := append([]time.Duration(nil), m.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return latencies, len(m.sent)
+}
+
+// Returns the latency at the given percentile (0-100) of a sorted slice
+func latencyPercentile(sorted []time.Duration, percentile float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	i := int(float64(len(sorted))*percentile/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return sorted[i]
+}
+
+func printLatencyReport(sorted []time.Duration, lost int, writeErrors int) {
+	fmt.Printf("received: %d  lost: %d  write errors: %d\n", len(sorted), lost, writeErrors)
+	if len(sorted) == 0 {
+		return
+	}
+
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+
+	fmt.Printf("min: %s  mean: %s  max: %s\n",
+		sorted[0], total/time.Duration(len(sorted)), sorted[len(sorted)-1])
+	fmt.Printf("p50: %s  p90: %s  p99: %s  p99.9: %s\n",
+		latencyPercentile(sorted, 50),
+		latencyPercentile(sorted, 90),
+		latencyPercentile(sorted, 99),
+		latencyPercentile(sorted, 99.9))
+
+	fmt.Println()
+	counts := make([]int, len(latencyBuckets)+1)
+	for _, latency := range sorted {
+		bucket := sort.Search(len(latencyBuckets), func(i int) bool { return latency <= latencyBuckets[i] })
+		counts[bucket]++
+	}
+
+	for i, n := range counts {
+		label := "> " + latencyBuckets[len(latencyBuckets)-1].String()
+		if i < len(latencyBuckets) {
+			label = "<= " + latencyBuckets[i].String()
+		}
+
+		bar := strings.Repeat("#", (n*50+len(sorted)-1)/len(sorted))
+		fmt.Printf("%10s %7d %s\n", label, n, bar)
+	}
+}
+
+// Writes latencies in milliseconds, one per line
+func writeLatencies(path string, latencies []time.Duration) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %s", path, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, latency := range latencies {
+		fmt.Fprintf(writer, "%.3f\n", float64(latency)/float64(time.Millisecond))
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,103 @@
+// Package backoff computes how long to wait before retrying something that
+// failed, like reconnecting to Mongo or publishing to Redis: exponentially
+// longer after each failure in a row, up to a maximum, and randomized
+// (jittered) so that many copies of oplogtoredis that lost their connection
+// at the same time don't all retry at the same moment.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff describes the delays between retries. Its zero value uses the
+// defaults below, without jitter; see Default.
+type Backoff struct {
+	// The delay before the first retry. Defaults to 1 second.
+	Initial time.Duration
+
+	// The longest delay. Defaults to 30 seconds.
+	Max time.Duration
+
+	// How much longer each delay is than the one before it. Defaults to 2.
+	Multiplier float64
+
+	// The fraction (from 0 to 1) of each delay that's randomized: a delay d
+	// becomes a random delay between d*(1-Jitter) and d.
+	Jitter float64
+}
+
+// Default is the Backoff used when one isn't configured: 1 second, doubling
+// up to 30 seconds, with half of each delay randomized.
+var Default = Backoff{
+	Initial:    time.Second,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.5,
+}
+
+// Returns a random number in [0, 1). Replaced by tests.
+var randFloat = rand.Float64
+
+// OrDefault returns b, or Default if b is the zero value
+func (b Backoff) OrDefault() Backoff {
+	if b == (Backoff{}) {
+		return Default
+	}
+
+	return b
+}
+
+// Delay returns how long to wait before the retry that follows the given
+// number of failures in a row (starting at 1)
+func (b Backoff) Delay(failures int) time.Duration {
+	delay := b.maxDelay(failures)
+
+	jitter := b.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		delay -= time.Duration(float64(delay) * jitter * randFloat())
+	}
+
+	return delay
+}
+
+// Total returns the longest total time spent waiting before the given
+// number of retries, so that callers that retry for a length of time can
+// retry for as long as they would retry a number of times
+func (b Backoff) Total(retries int) time.Duration {
+	var total time.Duration
+	for failures := 1; failures <= retries; failures++ {
+		total += b.maxDelay(failures)
+	}
+
+	return total
+}
+
+// Returns the delay after the given number of failures, before jitter
+func (b Backoff) maxDelay(failures int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = Default.Initial
+	}
+	max := b.Max
+	if max <= 0 {
+		max = Default.Max
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = Default.Multiplier
+	}
+
+	delay := float64(initial)
+	for i := 1; i < failures && delay < float64(max); i++ {
+		delay *= multiplier
+	}
+
+	if delay > float64(max) {
+		return max
+	}
+	return time.Duration(delay)
+}
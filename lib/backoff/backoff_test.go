@@ -0,0 +1,92 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	tests := map[string]struct {
+		backoff  Backoff
+		expected []time.Duration
+	}{
+		"Zero value": {
+			backoff:  Backoff{},
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+		"Custom": {
+			backoff:  Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 3},
+			expected: []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second},
+		},
+		"Constant": {
+			backoff:  Backoff{Initial: time.Second, Multiplier: 1},
+			expected: []time.Duration{time.Second, time.Second, time.Second},
+		},
+		"Initial above max": {
+			backoff:  Backoff{Initial: time.Minute, Max: time.Second},
+			expected: []time.Duration{time.Second, time.Second},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for i, expected := range test.expected {
+				if got := test.backoff.Delay(i + 1); got != expected {
+					t.Errorf("Delay(%d) = %s, expected %s", i+1, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDelayManyFailures(t *testing.T) {
+	// Doesn't overflow, however long the outage
+	if got := Default.Delay(1000000); got > Default.Max || got < Default.Max/2 {
+		t.Errorf("Delay(1000000) = %s, expected between %s and %s", got, Default.Max/2, Default.Max)
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	defer func(f func() float64) { randFloat = f }(randFloat)
+
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Jitter: 0.5}
+
+	randFloat = func() float64 { return 0 }
+	if got := b.Delay(2); got != 2*time.Second {
+		t.Errorf("Delay(2) with no randomness = %s, expected 2s", got)
+	}
+
+	randFloat = func() float64 { return 0.5 }
+	if got := b.Delay(2); got != 1500*time.Millisecond {
+		t.Errorf("Delay(2) with randomness of 0.5 = %s, expected 1.5s", got)
+	}
+
+	randFloat = func() float64 { return 0.999 }
+	if got := b.Delay(5); got <= 5*time.Second || got > 10*time.Second {
+		t.Errorf("Delay(5) = %s, expected it to stay within (5s, 10s]", got)
+	}
+}
+
+func TestTotal(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second, Jitter: 0.5}
+
+	// 1s + 2s + 4s + 5s + 5s
+	if got := b.Total(5); got != 17*time.Second {
+		t.Errorf("Total(5) = %s, expected 17s", got)
+	}
+
+	if got := b.Total(0); got != 0 {
+		t.Errorf("Total(0) = %s, expected 0", got)
+	}
+}
+
+func TestOrDefault(t *testing.T) {
+	if got := (Backoff{}).OrDefault(); got != Default {
+		t.Errorf("Expected the zero value to use Default, got %#v", got)
+	}
+
+	custom := Backoff{Initial: time.Millisecond}
+	if got := custom.OrDefault(); got != custom {
+		t.Errorf("Expected a configured Backoff to be kept, got %#v", got)
+	}
+}
@@ -0,0 +1,108 @@
+// Package chaos injects faults into oplogtoredis, so that its recovery and
+// buffering behavior can be exercised in a staging environment. It must
+// never be enabled in production.
+//
+// A nil *Injector is valid and never injects anything, so callers don't
+// need to check whether chaos mode is enabled.
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrInjected is returned in place of a real error when a fault is injected
+var ErrInjected = errors.New("Injected fault (chaos mode is enabled)")
+
+var metricInjectedFaults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "chaos",
+	Name:      "injected_faults",
+	Help:      "Faults injected by chaos mode, partitioned by type",
+}, []string{"type"})
+
+// Injector randomly injects faults at configured rates. Each rate is the
+// fraction (from 0 to 1) of opportunities at which the fault is injected.
+type Injector struct {
+	PublishFailureRate float64
+	CursorErrorRate    float64
+	LatencyRate        float64
+
+	// The maximum latency to inject. Each injected delay is chosen uniformly
+	// between 0 and this value.
+	MaxLatency time.Duration
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+
+	// Allows tests to skip actually sleeping
+	sleep func(time.Duration)
+}
+
+// NewInjector creates an Injector with the given rates
+func NewInjector(publishFailureRate float64, cursorErrorRate float64, latencyRate float64, maxLatency time.Duration) *Injector {
+	return &Injector{
+		PublishFailureRate: publishFailureRate,
+		CursorErrorRate:    cursorErrorRate,
+		LatencyRate:        latencyRate,
+		MaxLatency:         maxLatency,
+		rand:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleep:              time.Sleep,
+	}
+}
+
+// PublishError returns ErrInjected if a Redis publish should fail, and nil
+// otherwise. It also adds latency, if latency should be injected.
+func (i *Injector) PublishError() error {
+	if i == nil {
+		return nil
+	}
+
+	if i.roll(i.LatencyRate) {
+		metricInjectedFaults.WithLabelValues("latency").Inc()
+		i.sleep(time.Duration(i.float64() * float64(i.MaxLatency)))
+	}
+
+	if i.roll(i.PublishFailureRate) {
+		metricInjectedFaults.WithLabelValues("publish_failure").Inc()
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// CursorError returns ErrInjected if the oplog cursor should fail, and nil
+// otherwise.
+func (i *Injector) CursorError() error {
+	if i == nil {
+		return nil
+	}
+
+	if i.roll(i.CursorErrorRate) {
+		metricInjectedFaults.WithLabelValues("cursor_error").Inc()
+		return ErrInjected
+	}
+
+	return nil
+}
+
+// Returns true with the given probability
+func (i *Injector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	return i.float64() < rate
+}
+
+func (i *Injector) float64() float64 {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.rand.Float64()
+}
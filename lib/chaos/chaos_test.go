@@ -0,0 +1,94 @@
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNilInjector(t *testing.T) {
+	var injector *Injector
+
+	if err := injector.PublishError(); err != nil {
+		t.Errorf("Expected nil injector not to inject publish errors, got %s", err)
+	}
+
+	if err := injector.CursorError(); err != nil {
+		t.Errorf("Expected nil injector not to inject cursor errors, got %s", err)
+	}
+}
+
+func TestInjectorRates(t *testing.T) {
+	tests := map[string]struct {
+		publishFailureRate float64
+		cursorErrorRate    float64
+		latencyRate        float64
+		wantPublishErrors  bool
+		wantCursorErrors   bool
+		wantLatency        bool
+	}{
+		"Disabled": {},
+		"Always fail publishes": {
+			publishFailureRate: 1,
+			wantPublishErrors:  true,
+		},
+		"Always fail cursors": {
+			cursorErrorRate:  1,
+			wantCursorErrors: true,
+		},
+		"Always add latency": {
+			latencyRate: 1,
+			wantLatency: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			injector := NewInjector(test.publishFailureRate, test.cursorErrorRate, test.latencyRate, time.Second)
+
+			var slept []time.Duration
+			injector.sleep = func(d time.Duration) {
+				slept = append(slept, d)
+			}
+
+			for n := 0; n < 100; n++ {
+				if gotErr := injector.PublishError() != nil; gotErr != test.wantPublishErrors {
+					t.Fatalf("PublishError() returned error: %t, expected %t", gotErr, test.wantPublishErrors)
+				}
+
+				if gotErr := injector.CursorError() != nil; gotErr != test.wantCursorErrors {
+					t.Fatalf("CursorError() returned error: %t, expected %t", gotErr, test.wantCursorErrors)
+				}
+			}
+
+			if test.wantLatency && len(slept) != 100 {
+				t.Errorf("Expected latency to be injected 100 times, got %d", len(slept))
+			}
+			if !test.wantLatency && len(slept) != 0 {
+				t.Errorf("Expected no latency to be injected, got %d delays", len(slept))
+			}
+
+			for _, d := range slept {
+				if d < 0 || d > time.Second {
+					t.Errorf("Injected latency %s was outside [0, 1s]", d)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectorPartialRate(t *testing.T) {
+	injector := NewInjector(0.5, 0, 0, 0)
+
+	failures := 0
+	for n := 0; n < 1000; n++ {
+		if injector.PublishError() != nil {
+			failures++
+		}
+	}
+
+	// This should be about 500; the bounds make a spurious failure
+	// astronomically unlikely
+	if failures < 350 || failures > 650 {
+		t.Errorf("Expected about half of publishes to fail, got %d out of 1000", failures)
+	}
+}
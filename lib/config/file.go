@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/yaml.v2"
+)
+
+// The environment variable that names the configuration file
+const configFileEnvVar = "OTR_CONFIG_FILE"
+
+// Reads the YAML configuration file named by OTR_CONFIG_FILE, if it's set,
+// and sets the environment variable for each option in it that isn't already
+// set in the environment, so that envconfig picks it up and environment
+// variables take precedence over the file.
+//
+// Options are named like their environment variables, without the OTR_
+// prefix, and case doesn't matter, so `redis_url` sets OTR_REDIS_URL. They
+// may also be grouped into sections whose names are joined to the option
+// names with "_":
+//
+//	redis:
+//	  url: redis://localhost
+//	  tls:
+//	    ca_file: /etc/redis/ca.pem
+//
+// Lists are joined with ",", and maps are written as "key:value" pairs
+// joined with ",", with list values joined with "|", which is the form the
+// environment variables take.
+func loadConfigFile() error {
+	path := os.Getenv(configFileEnvVar)
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %s", configFileEnvVar, err)
+	}
+
+	values, err := parseConfigFile(data)
+	if err != nil {
+		return fmt.Errorf("Error in config file %s: %s", path, err)
+	}
+
+	for key, value := range values {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+
+		err := os.Setenv(key, value)
+		if err != nil {
+			return fmt.Errorf("Error setting %s from config file: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+// Parses a YAML configuration file into the values of the environment
+// variables it sets
+func parseConfigFile(data []byte) (map[string]string, error) {
+	var doc yaml.MapSlice
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := configKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	err = flattenConfigSection(doc, "", keys, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Adds the options in one section of the configuration file (or the whole
+// file, when prefix is empty) to values
+func flattenConfigSection(section yaml.MapSlice, prefix string, keys map[string]bool, values map[string]string) error {
+	for _, item := range section {
+		name := strings.ToUpper(fmt.Sprint(item.Key))
+		if prefix == "" {
+			name = strings.TrimPrefix(name, "OTR_")
+		}
+		name = prefix + name
+
+		// A known option is set by this item, unless the option isn't a map
+		// and the item is, in which case it's a section containing more
+		// options (like `redis_tls` and the `redis: {tls: {ca_file: ...}}`
+		// section)
+		key := "OTR_" + name
+		subsection, isSection := item.Value.(yaml.MapSlice)
+		if isMap, ok := keys[key]; ok && (isMap || !isSection) {
+			if _, ok := values[key]; ok {
+				return fmt.Errorf("%s is set more than once", strings.ToLower(name))
+			}
+
+			value, err := configValue(item.Value)
+			if err != nil {
+				return fmt.Errorf("Invalid value for %s: %s", strings.ToLower(name), err)
+			}
+
+			values[key] = value
+			continue
+		}
+
+		if !isSection {
+			return fmt.Errorf("Unknown option %s", strings.ToLower(name))
+		}
+
+		err := flattenConfigSection(subsection, name+"_", keys, values)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Converts a value from the configuration file into the form of an
+// environment variable
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		return joinConfigList(v, ",")
+	case yaml.MapSlice:
+		pairs := make([]string, 0, len(v))
+		for _, item := range v {
+			var pairValue string
+			var err error
+			if list, ok := item.Value.([]interface{}); ok {
+				pairValue, err = joinConfigList(list, "|")
+			} else {
+				pairValue, err = configScalar(item.Value)
+			}
+			if err != nil {
+				return "", err
+			}
+
+			pairs = append(pairs, fmt.Sprintf("%v:%s", item.Key, pairValue))
+		}
+
+		return strings.Join(pairs, ","), nil
+	default:
+		return configScalar(v)
+	}
+}
+
+func joinConfigList(list []interface{}, separator string) (string, error) {
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		s, err := configScalar(item)
+		if err != nil {
+			return "", err
+		}
+
+		items = append(items, s)
+	}
+
+	return strings.Join(items, separator), nil
+}
+
+func configScalar(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, yaml.MapSlice:
+		return "", fmt.Errorf("expected a single value, got %v", value)
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// Returns the names of the environment variables that configure
+// oplogtoredis, mapped to whether the option is a map
+func configKeys() (map[string]bool, error) {
+	tmpl := template.Must(template.New("keys").Parse("{{range .}}{{.Key}} {{.Field.Kind}}\n{{end}}"))
+
+	var out bytes.Buffer
+	err := envconfig.Usaget("otr", &oplogtoredisConfiguration{}, &out, tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		keys[fields[0]] = fields[1] == "map"
+	}
+
+	return keys, nil
+}
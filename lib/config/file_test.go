@@ -0,0 +1,156 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	tests := map[string]struct {
+		in          string
+		want        map[string]string
+		expectError bool
+	}{
+		"Flat options": {
+			in: `
+redis_url: redis://localhost
+OTR_MONGO_URL: mongodb://localhost
+buffer_size: 10
+relay_mode: true
+`,
+			want: map[string]string{
+				"OTR_REDIS_URL":   "redis://localhost",
+				"OTR_MONGO_URL":   "mongodb://localhost",
+				"OTR_BUFFER_SIZE": "10",
+				"OTR_RELAY_MODE":  "true",
+			},
+		},
+		"Sections, lists, and maps": {
+			in: `
+redis_tls: true
+redis:
+  url: redis://localhost
+  tls:
+    ca_file: /etc/redis/ca.pem
+include_namespaces: [app.*, "*.users"]
+collection_channels:
+  db.tasks: custom
+full_document_projections:
+  db.tasks: [name, status]
+  db.users: email
+`,
+			want: map[string]string{
+				"OTR_REDIS_TLS":                 "true",
+				"OTR_REDIS_URL":                 "redis://localhost",
+				"OTR_REDIS_TLS_CA_FILE":         "/etc/redis/ca.pem",
+				"OTR_INCLUDE_NAMESPACES":        "app.*,*.users",
+				"OTR_COLLECTION_CHANNELS":       "db.tasks:custom",
+				"OTR_FULL_DOCUMENT_PROJECTIONS": "db.tasks:name|status,db.users:email",
+			},
+		},
+		"Unknown option": {
+			in:          "redis_urll: redis://localhost",
+			expectError: true,
+		},
+		"Unknown section": {
+			in:          "redis:\n  nope: 1",
+			expectError: true,
+		},
+		"Duplicate option": {
+			in:          "redis_url: redis://a\nredis:\n  url: redis://b",
+			expectError: true,
+		},
+		"Nested value": {
+			in:          "include_namespaces: [[app.*]]",
+			expectError: true,
+		},
+		"Invalid YAML": {
+			in:          "redis_url: [",
+			expectError: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseConfigFile([]byte(test.in))
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("Expected an error, got %#v", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %#v, expected %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvWithConfigFile(t *testing.T) {
+	for _, envPair := range os.Environ() {
+		if strings.HasPrefix(envPair, "OTR_") {
+			os.Unsetenv(strings.SplitN(envPair, "=", 2)[0])
+		}
+	}
+
+	file, err := ioutil.TempFile("", "oplogtoredis-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(`
+redis_url: redis://from-file
+mongo_url: mongodb://from-file
+ready_max_lag: 2m
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	os.Setenv("OTR_CONFIG_FILE", file.Name())
+	os.Setenv("OTR_MONGO_URL", "mongodb://from-env")
+	defer func() {
+		os.Unsetenv("OTR_CONFIG_FILE")
+		os.Unsetenv("OTR_REDIS_URL")
+		os.Unsetenv("OTR_MONGO_URL")
+		os.Unsetenv("OTR_READY_MAX_LAG")
+	}()
+
+	err = ParseEnv()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if RedisURL() != "redis://from-file" {
+		t.Errorf("Expected RedisURL from the config file, got %q", RedisURL())
+	}
+
+	if MongoURL() != "mongodb://from-env" {
+		t.Errorf("Expected MongoURL from the environment, got %q", MongoURL())
+	}
+
+	if ReadyMaxLag() != 2*time.Minute {
+		t.Errorf("Expected ReadyMaxLag from the config file, got %s", ReadyMaxLag())
+	}
+}
+
+func TestParseEnvWithMissingConfigFile(t *testing.T) {
+	os.Setenv("OTR_CONFIG_FILE", "/nonexistent/oplogtoredis.yml")
+	defer os.Unsetenv("OTR_CONFIG_FILE")
+
+	err := ParseEnv()
+	if err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+}
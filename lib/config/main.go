@@ -1,9 +1,15 @@
 // Package config reads oplogtoredis configuration values from environment
-// variables. The documentation for this package also documents all of the
+// variables, and optionally from a YAML configuration file (see ParseEnv).
+// The documentation for this package also documents all of the
 // configuration options that are available to configure oplogtoredis.
 package config
 
 import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -11,37 +17,394 @@ import (
 
 type oplogtoredisConfiguration struct {
 	RedisURL               string        `required:"true" split_words:"true"`
+	RedisSentinelMaster    string        `split_words:"true"`
+	RedisTLS               bool          `envconfig:"REDIS_TLS"`
+	RedisTLSCAFile         string        `envconfig:"REDIS_TLS_CA_FILE"`
+	RedisTLSCertFile       string        `envconfig:"REDIS_TLS_CERT_FILE"`
+	RedisTLSKeyFile        string        `envconfig:"REDIS_TLS_KEY_FILE"`
+	RedisTLSInsecure       bool          `envconfig:"REDIS_TLS_INSECURE_SKIP_VERIFY"`
+	RedisTLSServerName     string        `envconfig:"REDIS_TLS_SERVER_NAME"`
+	SecondaryRedisURL      string        `split_words:"true"`
 	MongoURL               string        `required:"true" split_words:"true"`
+	MongoReadPreference    string        `split_words:"true"`
+	MongoDirect            bool          `split_words:"true"`
+	MongoConnectTimeout    time.Duration `default:"10s" split_words:"true"`
+	MongoSocketTimeout     time.Duration `default:"10s" split_words:"true"`
+	MongoPoolSize          int           `split_words:"true"`
+	MongoAppName           string        `default:"oplogtoredis" split_words:"true"`
+	ChangeStreams          bool          `split_words:"true"`
+	Sharded                bool          `split_words:"true"`
 	HTTPServerAddr         string        `default:"0.0.0.0:9000" envconfig:"HTTP_SERVER_ADDR"`
+	ReadyMaxLag            time.Duration `default:"60s" split_words:"true"`
+	AdminToken             string        `split_words:"true"`
+	DebugEndpoints         bool          `split_words:"true"`
+	LagMetricInterval      time.Duration `default:"10s" split_words:"true"`
 	BufferSize             int           `default:"10000" split_words:"true"`
 	TimestampFlushInterval time.Duration `default:"1s" split_words:"true"`
 	MaxCatchUp             time.Duration `default:"60s" split_words:"true"`
 	RedisDedupeExpiration  time.Duration `default:"120s" split_words:"true"`
+	DedupeByContent        bool          `split_words:"true"`
 	RedisMetadataPrefix    string        `default:"oplogtoredis::" split_words:"true"`
+	PublishMaxRetries      int           `default:"30" split_words:"true"`
+
+	ReconnectBackoffInitial time.Duration `default:"1s" split_words:"true"`
+	ReconnectBackoffMax     time.Duration `default:"30s" split_words:"true"`
+	ReconnectBackoffJitter  float64       `default:"0.5" split_words:"true"`
+	TailMaxRestarts         int           `split_words:"true"`
+
+	LeaderElection               string        `split_words:"true"`
+	LeaderElectionLeaseName      string        `default:"oplogtoredis" split_words:"true"`
+	LeaderElectionLeaseNamespace string        `split_words:"true"`
+	LeaderElectionIdentity       string        `split_words:"true"`
+	LeaderElectionLeaseDuration  time.Duration `default:"15s" split_words:"true"`
+	LeaderElectionRenewDeadline  time.Duration `default:"10s" split_words:"true"`
+	LeaderElectionRetryPeriod    time.Duration `default:"2s" split_words:"true"`
+
+	RelayMode        bool          `split_words:"true"`
+	RelayBatchSize   int           `default:"500" split_words:"true"`
+	RelayBatchWindow time.Duration `default:"250ms" split_words:"true"`
+	RelayCompression bool          `split_words:"true"`
+	RelayMaxOutage   time.Duration `default:"5m" split_words:"true"`
+
+	PublishBatchSize   int           `default:"1" split_words:"true"`
+	PublishBatchWindow time.Duration `default:"5ms" split_words:"true"`
+
+	PublishWorkers         int `default:"1" split_words:"true"`
+	PublishWorkerQueueSize int `default:"1000" split_words:"true"`
+
+	BufferOverflow string `default:"block" split_words:"true"`
+	BufferSpillDir string `split_words:"true"`
+
+	PublishWALFile string `envconfig:"PUBLISH_WAL_FILE"`
+
+	CheckpointStore      string `default:"redis" split_words:"true"`
+	CheckpointFile       string `split_words:"true"`
+	CheckpointCollection string `default:"oplogtoredis.checkpoints" split_words:"true"`
+
+	CheckpointFlushStrategy string `default:"interval" split_words:"true"`
+	CheckpointFlushCount    int    `default:"1000" split_words:"true"`
+
+	ClusterName string `split_words:"true"`
+
+	Streams            bool  `split_words:"true"`
+	StreamsMaxLen      int64 `default:"10000" split_words:"true"`
+	StreamsPerDocument bool  `split_words:"true"`
+
+	WebhookURL         string        `envconfig:"WEBHOOK_URL"`
+	WebhookSecret      string        `split_words:"true"`
+	WebhookBatchSize   int           `default:"100" split_words:"true"`
+	WebhookBatchWindow time.Duration `default:"1s" split_words:"true"`
+	WebhookConcurrency int           `default:"4" split_words:"true"`
+	WebhookMaxRetries  int           `default:"10" split_words:"true"`
+
+	KafkaBrokers       []string      `split_words:"true"`
+	KafkaTopicPrefix   string        `split_words:"true"`
+	KafkaBatchSize     int           `default:"100" split_words:"true"`
+	KafkaBatchTimeout  time.Duration `default:"1s" split_words:"true"`
+	KafkaTLS           bool          `envconfig:"KAFKA_TLS"`
+	KafkaTLSCAFile     string        `envconfig:"KAFKA_TLS_CA_FILE"`
+	KafkaTLSCertFile   string        `envconfig:"KAFKA_TLS_CERT_FILE"`
+	KafkaTLSKeyFile    string        `envconfig:"KAFKA_TLS_KEY_FILE"`
+	KafkaTLSInsecure   bool          `envconfig:"KAFKA_TLS_INSECURE_SKIP_VERIFY"`
+	KafkaSASLMechanism string        `envconfig:"KAFKA_SASL_MECHANISM"`
+	KafkaSASLUsername  string        `envconfig:"KAFKA_SASL_USERNAME"`
+	KafkaSASLPassword  string        `envconfig:"KAFKA_SASL_PASSWORD"`
+
+	ChannelPrefix        string            `split_words:"true"`
+	TeeChannelPrefix     string            `split_words:"true"`
+	CollectionNamespaces map[string]string `split_words:"true"`
+	CollectionChannels   map[string]string `split_words:"true"`
+	FullDocument         bool              `split_words:"true"`
+	ChangedValues        bool              `split_words:"true"`
+	DocumentVersion      bool              `split_words:"true"`
+	GlobalChannel        string            `split_words:"true"`
+	IncludeNamespaces    []string          `split_words:"true"`
+	ExcludeNamespaces    []string          `split_words:"true"`
+
+	FullDocumentNamespaces  []string          `split_words:"true"`
+	FullDocumentProjections map[string]string `split_words:"true"`
+
+	CollectionChannelTemplate string `split_words:"true"`
+	SpecificChannelTemplate   string `split_words:"true"`
+
+	RoutingFields map[string]string `split_words:"true"`
+	RoutingLookup bool              `split_words:"true"`
+
+	SnapshotNamespaces []string `split_words:"true"`
+	SnapshotRate       int      `default:"1000" split_words:"true"`
+
+	RedactFields map[string]string `split_words:"true"`
+	HashFields   map[string]string `split_words:"true"`
+
+	DeadLetterChannel string `split_words:"true"`
+
+	CollectionEvents bool `split_words:"true"`
+
+	RenameRemoves int `split_words:"true"`
+
+	PublishMigrations bool `split_words:"true"`
+
+	FieldPaths string `default:"full" split_words:"true"`
+
+	EventNames string `default:"meteor" split_words:"true"`
+
+	ProtocolVersion int `default:"1" split_words:"true"`
+
+	PayloadFormat string `default:"redis-oplog" split_words:"true"`
+
+	RedisEncoding          string `default:"json" split_words:"true"`
+	SecondaryRedisEncoding string `default:"json" split_words:"true"`
+
+	RedisCompression          string `default:"none" split_words:"true"`
+	SecondaryRedisCompression string `default:"none" split_words:"true"`
+	CompressionThreshold      int    `default:"65536" split_words:"true"`
+
+	MaxMessageSize int    `split_words:"true"`
+	OversizeAction string `default:"truncate" split_words:"true"`
+
+	MessageNamespace bool `split_words:"true"`
+	MessageTimestamp bool `split_words:"true"`
+	MessageWallTime  bool `split_words:"true"`
+
+	MessagePublisherVersion bool `split_words:"true"`
+
+	DataGapChannel string `split_words:"true"`
+	DataGapRestart string `default:"oldest" split_words:"true"`
+
+	OplogWindowMetricInterval time.Duration `default:"60s" split_words:"true"`
+	MinOplogWindow            time.Duration `split_words:"true"`
+
+	SyntheticChannelPrefix string `split_words:"true"`
+
+	Handoff        bool          `split_words:"true"`
+	HandoffTimeout time.Duration `default:"30s" split_words:"true"`
+
+	ShutdownTimeout time.Duration `default:"20s" split_words:"true"`
+
+	LogLevel              string        `split_words:"true"`
+	LogFormat             string        `split_words:"true"`
+	LogSamplingInitial    int           `split_words:"true"`
+	LogSamplingThereafter int           `default:"100" split_words:"true"`
+	LogThrottle           time.Duration `split_words:"true"`
+
+	OtlpEndpoint    string  `split_words:"true"`
+	TraceSampleRate float64 `default:"1" split_words:"true"`
+
+	ChaosMode               bool          `split_words:"true"`
+	ChaosPublishFailureRate float64       `split_words:"true"`
+	ChaosCursorErrorRate    float64       `split_words:"true"`
+	ChaosLatencyRate        float64       `split_words:"true"`
+	ChaosLatency            time.Duration `default:"1s" split_words:"true"`
 }
 
 var globalConfig *oplogtoredisConfiguration
 
 // RedisURL is the Redis URL configuration. It is required, and is set via the
 // environment variable `OTR_REDIS_URL`.
+//
+// To connect to a Redis Cluster, set it to a comma-separated list of URLs for
+// some of the nodes in the cluster. To connect through Redis Sentinel, set it
+// to the URLs of the sentinels, and set `OTR_REDIS_SENTINEL_MASTER`.
 func RedisURL() string {
 	return globalConfig.RedisURL
 }
 
+// RedisSentinelMaster is the name of the master to ask the Redis Sentinels
+// for. If it's set, oplogtoredis connects to the Redis master through the
+// sentinels at OTR_REDIS_URL, and follows the master when it fails over.
+// While the sentinels are failing over, publishing fails and is retried (see
+// PublishMaxRetries), so make sure the retries cover your failover time. It is
+// set via the environment variable `OTR_REDIS_SENTINEL_MASTER`.
+func RedisSentinelMaster() string {
+	return globalConfig.RedisSentinelMaster
+}
+
+// RedisTLS controls whether oplogtoredis connects to Redis over TLS, as
+// managed Redis services often require. Using a `rediss://` URL also enables
+// TLS, but only with the default settings; the OTR_REDIS_TLS_* options need
+// OTR_REDIS_TLS. The Redis client only supports TLS for a single Redis server,
+// not for Redis Cluster or Sentinel. It is set via the environment variable
+// `OTR_REDIS_TLS` and defaults to false.
+func RedisTLS() bool {
+	return globalConfig.RedisTLS
+}
+
+// RedisTLSCAFile is the path to a PEM file with the CA certificates to verify
+// the Redis server against, instead of the system's root CAs. It is set via
+// the environment variable `OTR_REDIS_TLS_CA_FILE`.
+func RedisTLSCAFile() string {
+	return globalConfig.RedisTLSCAFile
+}
+
+// RedisTLSCertFile is the path to a PEM file with a client certificate to
+// present to the Redis server. It must be set along with RedisTLSKeyFile. It
+// is set via the environment variable `OTR_REDIS_TLS_CERT_FILE`.
+func RedisTLSCertFile() string {
+	return globalConfig.RedisTLSCertFile
+}
+
+// RedisTLSKeyFile is the path to a PEM file with the private key of the
+// client certificate in RedisTLSCertFile. It is set via the environment
+// variable `OTR_REDIS_TLS_KEY_FILE`.
+func RedisTLSKeyFile() string {
+	return globalConfig.RedisTLSKeyFile
+}
+
+// RedisTLSInsecureSkipVerify disables verifying the Redis server's
+// certificate. Only use this for testing. It is set via the environment
+// variable `OTR_REDIS_TLS_INSECURE_SKIP_VERIFY` and defaults to false.
+func RedisTLSInsecureSkipVerify() bool {
+	return globalConfig.RedisTLSInsecure
+}
+
+// RedisTLSServerName is the server name to send with SNI and to verify the
+// Redis server's certificate against, if it's different from the host in
+// OTR_REDIS_URL. It is set via the environment variable
+// `OTR_REDIS_TLS_SERVER_NAME`.
+func RedisTLSServerName() string {
+	return globalConfig.RedisTLSServerName
+}
+
+// SecondaryRedisURL is the URL of another Redis server (or Redis Cluster, as
+// a comma-separated list of URLs) to publish every message to, in addition to
+// OTR_REDIS_URL. This allows dual-writing while migrating from one Redis to
+// another. The last-processed timestamp is recorded in both, so once the new
+// Redis has caught up, it can become OTR_REDIS_URL. Sentinel and the
+// OTR_REDIS_TLS_* options only apply to OTR_REDIS_URL; use a `rediss://` URL
+// for TLS. It can't be combined with relay mode. It is set via the
+// environment variable `OTR_SECONDARY_REDIS_URL`.
+func SecondaryRedisURL() string {
+	return globalConfig.SecondaryRedisURL
+}
+
 // MongoURL is the Mongo URL configuration. Is is required, and is set via the
 // environment variable `OTR_MONGO_URL`.
 func MongoURL() string {
 	return globalConfig.MongoURL
 }
 
+// MongoReadPreference is which replica set members oplogtoredis reads the
+// oplog (and documents) from: primary, primaryPreferred, secondary,
+// secondaryPreferred, or nearest. Reading from a secondary keeps load off
+// the primary. To tail a hidden secondary, which replica set discovery
+// never finds, point OTR_MONGO_URL at it and set OTR_MONGO_DIRECT too. With
+// OTR_CHANGE_STREAMS, it only applies to looking up documents. It is set via
+// the environment variable `OTR_MONGO_READ_PREFERENCE`, and defaults to ""
+// (the primary).
+func MongoReadPreference() string {
+	return globalConfig.MongoReadPreference
+}
+
+// MongoDirect connects only to the server in OTR_MONGO_URL, instead of
+// discovering the rest of its replica set, like `?connect=direct` in the
+// URL. It is set via the environment variable `OTR_MONGO_DIRECT`, and
+// defaults to false.
+func MongoDirect() bool {
+	return globalConfig.MongoDirect
+}
+
+// MongoConnectTimeout is how long to wait to connect to Mongo, and for a
+// usable server (like the primary) to become available. It is set via the
+// environment variable `OTR_MONGO_CONNECT_TIMEOUT`, and defaults to 10s.
+func MongoConnectTimeout() time.Duration {
+	return globalConfig.MongoConnectTimeout
+}
+
+// MongoSocketTimeout is how long to wait for Mongo to respond to a request
+// before giving up on the connection. It is set via the environment
+// variable `OTR_MONGO_SOCKET_TIMEOUT`, and defaults to 10s.
+func MongoSocketTimeout() time.Duration {
+	return globalConfig.MongoSocketTimeout
+}
+
+// MongoPoolSize is the most connections to open to each Mongo server. It
+// overrides `maxPoolSize` in OTR_MONGO_URL. It is set via the environment
+// variable `OTR_MONGO_POOL_SIZE`, and defaults to 0 (the URL's
+// `maxPoolSize`, or 4096).
+func MongoPoolSize() int {
+	return globalConfig.MongoPoolSize
+}
+
+// MongoAppName is the name oplogtoredis gives Mongo for its connections,
+// which shows up in Mongo's logs, profiler, and currentOp. It is set via the
+// environment variable `OTR_MONGO_APP_NAME`, and defaults to
+// "oplogtoredis".
+func MongoAppName() string {
+	return globalConfig.MongoAppName
+}
+
+// ChangeStreams controls whether oplogtoredis reads changes with a
+// cluster-wide change stream rather than by tailing `local.oplog.rs`. This
+// needs MongoDB 4.0+, but works without access to the `local` database (e.g.
+// on Atlas), and against a mongos for a sharded cluster. It is set via the
+// environment variable `OTR_CHANGE_STREAMS` and defaults to false.
+func ChangeStreams() bool {
+	return globalConfig.ChangeStreams
+}
+
+// Sharded controls whether oplogtoredis tails every shard of a sharded
+// cluster. If set, OTR_MONGO_URL must point at mongos; oplogtoredis lists the
+// shards in `config.shards`, connects to each shard's replica set directly
+// (with the credentials and options from OTR_MONGO_URL, so the user must
+// exist on the shards too), and tails each shard's oplog, with a separate
+// last-processed timestamp for each. It can't be combined with
+// OTR_CHANGE_STREAMS (a change stream through mongos already covers every
+// shard) or OTR_HANDOFF. It is set via the environment variable
+// `OTR_SHARDED` and defaults to false.
+func Sharded() bool {
+	return globalConfig.Sharded
+}
+
 // HTTPServerAddr the address we bind our HTTP server to. The HTTP server
-// exposes a health-checking endpoint on `/healthz` and Prometheus metrics on
-// `/metrics`. It is set via the environment variable `OTR_HTTP_SERVER_ADDR` and
+// exposes a health-checking endpoint on `/healthz`, a readiness endpoint on
+// `/readyz`, Prometheus metrics on `/metrics`, the admin API on `/admin/`
+// (see AdminToken), and profiling endpoints on `/debug/` (see
+// DebugEndpoints). It is set via the environment variable `OTR_HTTP_SERVER_ADDR` and
 // defaults to `0.0.0.0:9000`
 func HTTPServerAddr() string {
 	return globalConfig.HTTPServerAddr
 }
 
+// ReadyMaxLag is how far behind the end of the oplog oplogtoredis can fall
+// before the `/readyz` endpoint reports that it isn't ready. `/readyz` also
+// reports not ready if the oplog cursor hasn't returned anything (entries,
+// or a timeout waiting for them) for this long, which means tailing is stuck.
+// It is set via the environment variable `OTR_READY_MAX_LAG` and defaults
+// to 60s.
+func ReadyMaxLag() time.Duration {
+	return globalConfig.ReadyMaxLag
+}
+
+// AdminToken enables the admin API on the HTTP server, under `/admin/`,
+// which reports on oplogtoredis's progress and can pause and resume tailing
+// or skip an entry. Every request must carry the token, as
+// "Authorization: Bearer <token>". It is set via the environment variable
+// `OTR_ADMIN_TOKEN`, and defaults to "" (no admin API).
+func AdminToken() string {
+	return globalConfig.AdminToken
+}
+
+// DebugEndpoints serves Go's profiling (net/http/pprof) endpoints under
+// `/debug/pprof/`, and its expvar variables (like memory statistics) on
+// `/debug/vars`, on the HTTP server, so you can profile oplogtoredis in
+// production (e.g. with `go tool pprof http://<host>:9000/debug/pprof/profile`).
+// The endpoints aren't authenticated, and profiles can reveal details of
+// oplogtoredis's data, so only turn this on when the HTTP server isn't
+// publicly reachable. It is set via the environment variable
+// `OTR_DEBUG_ENDPOINTS`, and defaults to false.
+func DebugEndpoints() bool {
+	return globalConfig.DebugEndpoints
+}
+
+// LagMetricInterval is how often to update the `otr_oplog_seconds_behind_head`
+// metric, which reports how far behind the end of the oplog oplogtoredis is.
+// Each update queries the oplog (once per shard, for sharded clusters). It is
+// set via the environment variable `OTR_LAG_METRIC_INTERVAL` and defaults to
+// 10s.
+func LagMetricInterval() time.Duration {
+	return globalConfig.LagMetricInterval
+}
+
 // BufferSize is the size of the internal buffers that hold oplog messages while
 // they're being processed. It is set via the environment variable
 // `OTR_BUFFER_SIZE` and defaults to 10,000.
@@ -83,6 +446,70 @@ func RedisDedupeExpiration() time.Duration {
 	return globalConfig.RedisDedupeExpiration
 }
 
+// DedupeByContent makes the keys used to deduplicate messages (see
+// RedisDedupeExpiration) include a hash of each message and the channels it's
+// published to, as well as the oplog entry's timestamp. Copies of
+// oplogtoredis that publish different messages for an entry -- because they
+// have a different channel prefix, say, during a rolling deploy -- then each
+// publish their own messages, rather than only the first copy's being
+// published. Each distinct message is still published exactly once, however
+// many copies publish it. All copies must run the same version of
+// oplogtoredis, and either all or none of them must enable it. `oplogtoredis
+// verify` can't check publications deduplicated this way. It is set via the
+// environment variable `OTR_DEDUPE_BY_CONTENT` and defaults to false.
+func DedupeByContent() bool {
+	return globalConfig.DedupeByContent
+}
+
+// PublishMaxRetries is how many times we retry publishing a message (backing
+// off between retries; see ReconnectBackoffInitial) before giving up on it
+// and moving on to the next one. While we're retrying, we stop reading from
+// the oplog (once the buffer fills up), so nothing else is published out of
+// order in the meantime. It is set via the environment variable
+// `OTR_PUBLISH_MAX_RETRIES` and defaults to 30. In relay mode,
+// OTR_RELAY_MAX_OUTAGE is used instead.
+func PublishMaxRetries() int {
+	return globalConfig.PublishMaxRetries
+}
+
+// ReconnectBackoffInitial is how long we wait before reconnecting after
+// tailing the oplog fails (because Mongo is down or failing over, for
+// example), and before retrying a failed publish to Redis. Each failure in a
+// row doubles the wait, up to ReconnectBackoffMax, so that an outage doesn't
+// turn into a flood of connection attempts from every copy of oplogtoredis.
+// It is set via the environment variable `OTR_RECONNECT_BACKOFF_INITIAL`, and
+// defaults to 1s.
+func ReconnectBackoffInitial() time.Duration {
+	return globalConfig.ReconnectBackoffInitial
+}
+
+// ReconnectBackoffMax is the longest we wait before reconnecting or retrying
+// (see ReconnectBackoffInitial). It is set via the environment variable
+// `OTR_RECONNECT_BACKOFF_MAX`, and defaults to 30s.
+func ReconnectBackoffMax() time.Duration {
+	return globalConfig.ReconnectBackoffMax
+}
+
+// ReconnectBackoffJitter is the fraction (from 0 to 1) of each wait before
+// reconnecting or retrying that's randomized, so that copies of oplogtoredis
+// that lost their connection at the same time don't all retry at the same
+// moment. A wait of 10s with a jitter of 0.5 is somewhere between 5s and 10s.
+// It is set via the environment variable `OTR_RECONNECT_BACKOFF_JITTER`, and
+// defaults to 0.5.
+func ReconnectBackoffJitter() float64 {
+	return globalConfig.ReconnectBackoffJitter
+}
+
+// TailMaxRestarts is how many times in a row tailing the oplog may fail (with
+// nothing read from the oplog in between) before oplogtoredis gives up and
+// exits, so that a supervisor can restart it or alert on it. The number of
+// failures in a row is reported by the otr_oplog_consecutive_tail_failures
+// metric either way. It is set via the environment variable
+// `OTR_TAIL_MAX_RESTARTS`, and defaults to 0, which retries forever.
+func TailMaxRestarts() int {
+	return globalConfig.TailMaxRestarts
+}
+
 // RedisMetadataPrefix controls the prefix for keys used to store oplogtoredis
 // metadata (such as the timestamp of the last oplog entry processed). If you're
 // running multiple instances of oplogtoredis for the same MongoDB (for high
@@ -92,7 +519,7 @@ func RedisDedupeExpiration() time.Duration {
 // should have different RedisMetadataPrefixes for each.
 //
 // This *does not* affect the channel names used to publish oplog entries. The
-// channel names are always `<db-name>.<collection-name>`` and
+// channel names are always `<db-name>.<collection-name>“ and
 // `<db-name>.<collection-name>::<document-id>`.`
 //
 // It is set via the environment variable `OTR_REDIS_METADATA_PREFIX` and
@@ -101,17 +528,1548 @@ func RedisMetadataPrefix() string {
 	return globalConfig.RedisMetadataPrefix
 }
 
+// ClusterName identifies the Mongo cluster being tailed, so copies of
+// oplogtoredis tailing different clusters can share a Redis server and
+// RedisMetadataPrefix. If set, the last-processed timestamp, dedupe keys,
+// leader election lease, and handoff channel are stored under
+// "<prefix>cluster::<name>::" (with each shard under that, when tailing a
+// sharded cluster). On startup, a last-processed timestamp stored under the
+// old layout is copied to the new one, so setting it doesn't lose our place.
+// It is set via the environment variable `OTR_CLUSTER_NAME` and defaults to
+// empty.
+func ClusterName() string {
+	return globalConfig.ClusterName
+}
+
+// LeaderElection selects the leader election mechanism. When leader election
+// is enabled, only one running copy of oplogtoredis tails the oplog and
+// publishes to Redis at a time; the others wait on standby to take over. It is
+// set via the environment variable `OTR_LEADER_ELECTION` and defaults to
+// empty, which disables leader election (all copies publish, and Redis is used
+// to deduplicate messages -- see RedisDedupeExpiration).
+//
+// Supported values are:
+//
+// - `kubernetes`: Use a Lease object from the Kubernetes coordination API
+// (coordination.k8s.io/v1) as the lock. oplogtoredis must be running in a
+// Kubernetes pod whose service account is allowed to get, create, and update
+// Lease objects in the lease namespace.
+//
+// - `redis`: Use a key in Redis (at OTR_REDIS_URL) as the lock, named
+// `<OTR_REDIS_METADATA_PREFIX>leader::<OTR_LEADER_ELECTION_LEASE_NAME>`. The
+// key expires after LeaderElectionLeaseDuration unless the leader renews it,
+// and the leader stops publishing if it can't renew it within
+// LeaderElectionRenewDeadline, before the key can expire.
+func LeaderElection() string {
+	return globalConfig.LeaderElection
+}
+
+// LeaderElectionLeaseName is the name of the Kubernetes Lease object (or
+// Redis key) used for leader election. All copies of oplogtoredis that tail the same MongoDB must
+// use the same lease name. It is set via the environment variable
+// `OTR_LEADER_ELECTION_LEASE_NAME` and defaults to "oplogtoredis".
+func LeaderElectionLeaseName() string {
+	return globalConfig.LeaderElectionLeaseName
+}
+
+// LeaderElectionLeaseNamespace is the Kubernetes namespace of the Lease object
+// used for leader election. It is set via the environment variable
+// `OTR_LEADER_ELECTION_LEASE_NAMESPACE` and defaults to the namespace of the
+// pod oplogtoredis is running in.
+func LeaderElectionLeaseNamespace() string {
+	return globalConfig.LeaderElectionLeaseNamespace
+}
+
+// LeaderElectionIdentity is the identity this copy of oplogtoredis uses when
+// campaigning for leadership. It must be unique among all of the copies of
+// oplogtoredis. It is set via the environment variable
+// `OTR_LEADER_ELECTION_IDENTITY` and defaults to the hostname (which, in
+// Kubernetes, is the pod name).
+func LeaderElectionIdentity() string {
+	return globalConfig.LeaderElectionIdentity
+}
+
+// LeaderElectionLeaseDuration is how long standby copies wait after the
+// leader's last renewal before taking over leadership. It is set via the
+// environment variable `OTR_LEADER_ELECTION_LEASE_DURATION` and defaults to
+// 15s.
+func LeaderElectionLeaseDuration() time.Duration {
+	return globalConfig.LeaderElectionLeaseDuration
+}
+
+// LeaderElectionRenewDeadline is how long the leader keeps retrying to renew
+// its leadership before giving up. When it gives up, it stops tailing the
+// oplog and exits. It must be shorter than LeaderElectionLeaseDuration. It is
+// set via the environment variable `OTR_LEADER_ELECTION_RENEW_DEADLINE` and
+// defaults to 10s.
+func LeaderElectionRenewDeadline() time.Duration {
+	return globalConfig.LeaderElectionRenewDeadline
+}
+
+// LeaderElectionRetryPeriod is how often we attempt to acquire or renew
+// leadership. It is set via the environment variable
+// `OTR_LEADER_ELECTION_RETRY_PERIOD` and defaults to 2s.
+func LeaderElectionRetryPeriod() time.Duration {
+	return globalConfig.LeaderElectionRetryPeriod
+}
+
+// RelayMode enables a publishing mode optimized for a Redis server in another
+// region. In relay mode, publications are sent to Redis in batches (see
+// RelayBatchSize and RelayBatchWindow) using a single pipelined round trip
+// per batch, and failed batches are retried for up to RelayMaxOutage to ride
+// out WAN blips. You'll usually want to increase BufferSize as well, so
+// oplog entries can queue up during those outages. The time between an oplog
+// entry being written and it reaching the remote Redis is exposed as the
+// `otr_redispub_relay_lag_seconds` metric. It is set via the environment
+// variable `OTR_RELAY_MODE` and defaults to false.
+func RelayMode() bool {
+	return globalConfig.RelayMode
+}
+
+// RelayBatchSize is the maximum number of publications sent to Redis in a
+// single pipeline in relay mode. It is set via the environment variable
+// `OTR_RELAY_BATCH_SIZE` and defaults to 500.
+func RelayBatchSize() int {
+	return globalConfig.RelayBatchSize
+}
+
+// RelayBatchWindow is the maximum time we wait for a batch to fill up before
+// sending it in relay mode. It is set via the environment variable
+// `OTR_RELAY_BATCH_WINDOW` and defaults to 250ms.
+func RelayBatchWindow() time.Duration {
+	return globalConfig.RelayBatchWindow
+}
+
+// RelayCompression controls whether message payloads are gzipped in relay
+// mode. This reduces WAN bandwidth, but consumers must decompress each message,
+// so it is *not* compatible with redis-oplog consuming directly from the remote
+// Redis. It is set via the environment variable `OTR_RELAY_COMPRESSION` and
+// defaults to false.
+func RelayCompression() bool {
+	return globalConfig.RelayCompression
+}
+
+// RelayMaxOutage is how long we keep retrying to send a batch in relay mode
+// before giving up on it. It is set via the environment variable
+// `OTR_RELAY_MAX_OUTAGE` and defaults to 5m.
+func RelayMaxOutage() time.Duration {
+	return globalConfig.RelayMaxOutage
+}
+
+// PublishBatchSize is the maximum number of publications sent to Redis in a
+// single pipelined round trip. Above 1, publications are collected into
+// batches (see PublishBatchWindow) and published with the same pipelined
+// publisher as relay mode, which raises throughput several-fold when
+// publishing is bound by the round trip time to Redis. Publications are
+// still published in oplog order, so each document's changes stay in order.
+// Batches are retried for as long as PublishMaxRetries would retry a single
+// publication. Relay mode has its own batch size (see RelayBatchSize), and
+// batching can't be combined with OTR_STREAMS, OTR_SECONDARY_REDIS_URL, or
+// OTR_WEBHOOK_URL. It is set via the environment variable
+// `OTR_PUBLISH_BATCH_SIZE` and defaults to 1 (no batching).
+func PublishBatchSize() int {
+	return globalConfig.PublishBatchSize
+}
+
+// PublishBatchWindow is the maximum time we wait for a batch to fill up before
+// sending it, when PublishBatchSize is above 1. It is set via the
+// environment variable `OTR_PUBLISH_BATCH_WINDOW` and defaults to 5ms.
+func PublishBatchWindow() time.Duration {
+	return globalConfig.PublishBatchWindow
+}
+
+// PublishWorkers is the number of workers that publish to Redis at once.
+// Above 1, publications are assigned to workers by a hash of their document,
+// so independent documents are published concurrently while each document's
+// changes are still published in order. Changes to different documents may
+// be published out of order, though, even within a collection. The
+// last-processed timestamp only advances past a change once every earlier
+// change has been published. The depth of each worker's queue is exposed as
+// the `otr_redispub_worker_queue_depth` metric. It can't be combined with
+// OTR_RELAY_MODE, OTR_PUBLISH_BATCH_SIZE, OTR_SECONDARY_REDIS_URL, or
+// OTR_WEBHOOK_URL. It is set via the environment variable
+// `OTR_PUBLISH_WORKERS` and defaults to 1.
+func PublishWorkers() int {
+	return globalConfig.PublishWorkers
+}
+
+// PublishWorkerQueueSize is the number of publications each publisher worker
+// (see PublishWorkers) queues up. It is set via the environment variable
+// `OTR_PUBLISH_WORKER_QUEUE_SIZE` and defaults to 1000.
+func PublishWorkerQueueSize() int {
+	return globalConfig.PublishWorkerQueueSize
+}
+
+// BufferOverflow is what to do when the buffer between the oplog tailer and
+// the publisher (see BufferSize) fills up because Redis is slow or
+// unreachable: "block" stops tailing the oplog until there's room, "drop-oldest"
+// drops the oldest buffered message (counted in the
+// `otr_redispub_buffer_dropped` metric) so tailing never falls behind, and
+// "spill" writes messages to a file in BufferSpillDir until there's room,
+// so memory use stays bounded without losing any messages. It is set via the
+// environment variable `OTR_BUFFER_OVERFLOW` and defaults to "block".
+func BufferOverflow() string {
+	return globalConfig.BufferOverflow
+}
+
+// BufferSpillDir is the directory messages are spilled to when BufferOverflow
+// is "spill". The spill file is deleted when oplogtoredis exits. It is set via
+// the environment variable `OTR_BUFFER_SPILL_DIR` and defaults to the
+// system's temporary directory.
+func BufferSpillDir() string {
+	return globalConfig.BufferSpillDir
+}
+
+// PublishWALFile is the path of a write-ahead log file. If it's set, messages
+// that can't be published to Redis (the primary Redis, with
+// OTR_SECONDARY_REDIS_URL) are appended to it instead of being retried, and
+// so is every later message until it's been published, in order, once Redis
+// recovers. This gives at-least-once delivery across Redis outages instead of
+// dropping messages after OTR_PUBLISH_MAX_RETRIES. Messages are counted as
+// processed once they're in the log, so it must be kept across restarts
+// (e.g. on a persistent volume); anything left in it is published first
+// when oplogtoredis next starts. The number of messages in it is exposed as
+// the `otr_redispub_wal_entries` metric. It can't be combined with
+// OTR_RELAY_MODE, OTR_PUBLISH_BATCH_SIZE, or OTR_PUBLISH_WORKERS. It is set
+// via the environment variable `OTR_PUBLISH_WAL_FILE` and defaults to no
+// log.
+func PublishWALFile() string {
+	return globalConfig.PublishWALFile
+}
+
+// CheckpointStore is where the last-processed timestamp is stored: "redis"
+// (under OTR_REDIS_METADATA_PREFIX), "file" (in CheckpointFile on the local
+// disk), or "mongo" (in CheckpointCollection). Use "file" or "mongo" if Redis
+// doesn't persist its data, since otherwise oplogtoredis loses its place on
+// every Redis restart and starts from the end of the oplog. It is set via the
+// environment variable `OTR_CHECKPOINT_STORE` and defaults to "redis".
+func CheckpointStore() string {
+	return globalConfig.CheckpointStore
+}
+
+// CheckpointFile is the path of the file the last-processed timestamp is
+// stored in when CheckpointStore is "file". It should be on a persistent
+// volume. It is set via the environment variable `OTR_CHECKPOINT_FILE`, and
+// is required for the file store.
+func CheckpointFile() string {
+	return globalConfig.CheckpointFile
+}
+
+// CheckpointCollection is the collection ("<db-name>.<collection-name>") of
+// the Mongo server at OTR_MONGO_URL that the last-processed timestamp is
+// stored in when CheckpointStore is "mongo". Writes to it aren't published.
+// It is set via the environment variable `OTR_CHECKPOINT_COLLECTION` and
+// defaults to "oplogtoredis.checkpoints".
+func CheckpointCollection() string {
+	return globalConfig.CheckpointCollection
+}
+
+// CheckpointFlushStrategy is when the last-processed timestamp is written to
+// the checkpoint store: "interval" (at most once per
+// OTR_TIMESTAMP_FLUSH_INTERVAL), "count" (once every
+// OTR_CHECKPOINT_FLUSH_COUNT messages), or "idle" (only once no messages
+// arrive for OTR_TIMESTAMP_FLUSH_INTERVAL, which under constant load means
+// only at shutdown). With any strategy, it's also written when no messages
+// arrive for OTR_TIMESTAMP_FLUSH_INTERVAL. It is set via the environment
+// variable `OTR_CHECKPOINT_FLUSH_STRATEGY` and defaults to "interval".
+func CheckpointFlushStrategy() string {
+	return globalConfig.CheckpointFlushStrategy
+}
+
+// CheckpointFlushCount is how many messages to publish between writes of the
+// last-processed timestamp when CheckpointFlushStrategy is "count". It is set
+// via the environment variable `OTR_CHECKPOINT_FLUSH_COUNT` and defaults to
+// 1000.
+func CheckpointFlushCount() int {
+	return globalConfig.CheckpointFlushCount
+}
+
+// Streams enables streams mode: instead of PUBLISHing messages, oplogtoredis
+// adds them (with XADD) to Redis Streams named after the channels they would
+// have been published to, so consumers that are briefly disconnected can read
+// the messages they missed. Each entry has a single field, `msg`, holding the
+// message. This needs Redis 5.0+, and can't be combined with OTR_RELAY_MODE.
+// It is set via the environment variable `OTR_STREAMS` and defaults to false.
+func Streams() bool {
+	return globalConfig.Streams
+}
+
+// StreamsMaxLen is the approximate maximum length of each stream in streams
+// mode; older entries are trimmed as new ones are added. 0 disables trimming.
+// It is set via the environment variable `OTR_STREAMS_MAX_LEN` and defaults
+// to 10,000.
+func StreamsMaxLen() int64 {
+	return globalConfig.StreamsMaxLen
+}
+
+// StreamsPerDocument controls whether, in streams mode, messages are also
+// added to a stream for each document (named like the document's channel,
+// `<db>.<collection>::<id>`). This creates a key in Redis for every document
+// that changes. It is set via the environment variable
+// `OTR_STREAMS_PER_DOCUMENT` and defaults to false.
+func StreamsPerDocument() bool {
+	return globalConfig.StreamsPerDocument
+}
+
+// WebhookURL is a URL to POST every message to, in addition to publishing it
+// to Redis, for consumers that can't hold a Redis connection open. Messages
+// are sent in batches, as JSON in the same format as `oplogtoredis export`
+// files: `{"publications": [{"ts": ..., "collectionChannel": ..., ...}]}`.
+// Batches are sent concurrently, so they may arrive out of order, and a batch
+// that fails after its retries is dropped. It can't be combined with relay
+// mode. It is set via the environment variable `OTR_WEBHOOK_URL`.
+func WebhookURL() string {
+	return globalConfig.WebhookURL
+}
+
+// WebhookSecret, if set, is used to sign each webhook request: the
+// `X-OTR-Signature` header is `sha256=<hex HMAC-SHA256 of the body>`. It is set
+// via the environment variable `OTR_WEBHOOK_SECRET`.
+func WebhookSecret() string {
+	return globalConfig.WebhookSecret
+}
+
+// WebhookBatchSize is the most messages to send in one webhook request. It is
+// set via the environment variable `OTR_WEBHOOK_BATCH_SIZE` and defaults to
+// 100.
+func WebhookBatchSize() int {
+	return globalConfig.WebhookBatchSize
+}
+
+// WebhookBatchWindow is how long to wait for a webhook batch to fill up
+// before sending it anyway. It is set via the environment variable
+// `OTR_WEBHOOK_BATCH_WINDOW` and defaults to 1 second.
+func WebhookBatchWindow() time.Duration {
+	return globalConfig.WebhookBatchWindow
+}
+
+// WebhookConcurrency is the most webhook requests to have in flight at once.
+// Once this many are in flight, publishing waits for one of them to finish.
+// It is set via the environment variable `OTR_WEBHOOK_CONCURRENCY` and
+// defaults to 4.
+func WebhookConcurrency() int {
+	return globalConfig.WebhookConcurrency
+}
+
+// WebhookMaxRetries is how many times to retry a failed webhook request
+// before dropping its batch. Retries back off exponentially, from 1 second up
+// to 1 minute. It is set via the environment variable
+// `OTR_WEBHOOK_MAX_RETRIES` and defaults to 10.
+func WebhookMaxRetries() int {
+	return globalConfig.WebhookMaxRetries
+}
+
+// KafkaBrokers is a comma-separated list of Kafka brokers ("host:port") to
+// write every message to, in addition to publishing it to Redis. Each
+// collection's messages go to its own topic, `<OTR_KAFKA_TOPIC_PREFIX><db>.<collection>`,
+// keyed by document ID. It can't be combined with relay mode. It is set via
+// the environment variable `OTR_KAFKA_BROKERS`.
+func KafkaBrokers() []string {
+	return globalConfig.KafkaBrokers
+}
+
+// KafkaTopicPrefix is prepended to `<db>.<collection>` to name the Kafka
+// topic for each collection. It is set via the environment variable
+// `OTR_KAFKA_TOPIC_PREFIX`, and defaults to no prefix.
+func KafkaTopicPrefix() string {
+	return globalConfig.KafkaTopicPrefix
+}
+
+// KafkaBatchSize is the most messages to write to a Kafka partition in one
+// request. Messages are written in the background, in batches. It is set via
+// the environment variable `OTR_KAFKA_BATCH_SIZE` and defaults to 100.
+func KafkaBatchSize() int {
+	return globalConfig.KafkaBatchSize
+}
+
+// KafkaBatchTimeout is how long to wait for a Kafka batch to fill up before
+// writing it anyway. It is set via the environment variable
+// `OTR_KAFKA_BATCH_TIMEOUT` and defaults to 1 second.
+func KafkaBatchTimeout() time.Duration {
+	return globalConfig.KafkaBatchTimeout
+}
+
+// KafkaTLS controls whether oplogtoredis connects to the Kafka brokers over
+// TLS. The OTR_KAFKA_TLS_* options need OTR_KAFKA_TLS. It is set via the
+// environment variable `OTR_KAFKA_TLS` and defaults to false.
+func KafkaTLS() bool {
+	return globalConfig.KafkaTLS
+}
+
+// KafkaTLSCAFile is the path to a PEM file with the CA certificates to verify
+// the Kafka brokers against, instead of the system's root CAs. It is set via
+// the environment variable `OTR_KAFKA_TLS_CA_FILE`.
+func KafkaTLSCAFile() string {
+	return globalConfig.KafkaTLSCAFile
+}
+
+// KafkaTLSCertFile is the path to a PEM file with a client certificate to
+// present to the Kafka brokers. It must be set along with KafkaTLSKeyFile. It
+// is set via the environment variable `OTR_KAFKA_TLS_CERT_FILE`.
+func KafkaTLSCertFile() string {
+	return globalConfig.KafkaTLSCertFile
+}
+
+// KafkaTLSKeyFile is the path to a PEM file with the private key of the
+// client certificate in KafkaTLSCertFile. It is set via the environment
+// variable `OTR_KAFKA_TLS_KEY_FILE`.
+func KafkaTLSKeyFile() string {
+	return globalConfig.KafkaTLSKeyFile
+}
+
+// KafkaTLSInsecureSkipVerify disables verifying the Kafka brokers'
+// certificates. Only use it for testing. It is set via the environment
+// variable `OTR_KAFKA_TLS_INSECURE_SKIP_VERIFY` and defaults to false.
+func KafkaTLSInsecureSkipVerify() bool {
+	return globalConfig.KafkaTLSInsecure
+}
+
+// KafkaSASLMechanism is the SASL mechanism to authenticate to the Kafka
+// brokers with: "plain", "scram-sha-256", or "scram-sha-512". It is set via
+// the environment variable `OTR_KAFKA_SASL_MECHANISM`, and defaults to empty,
+// which doesn't authenticate.
+func KafkaSASLMechanism() string {
+	return globalConfig.KafkaSASLMechanism
+}
+
+// KafkaSASLUsername is the username to authenticate to the Kafka brokers
+// with. It's required with KafkaSASLMechanism. It is set via the environment
+// variable `OTR_KAFKA_SASL_USERNAME`.
+func KafkaSASLUsername() string {
+	return globalConfig.KafkaSASLUsername
+}
+
+// KafkaSASLPassword is the password to authenticate to the Kafka brokers
+// with. It is set via the environment variable `OTR_KAFKA_SASL_PASSWORD`.
+func KafkaSASLPassword() string {
+	return globalConfig.KafkaSASLPassword
+}
+
+// ChannelPrefix is a prefix prepended to the names of the channels we publish
+// to. It is set via the environment variable `OTR_CHANNEL_PREFIX` and
+// defaults to empty (so channel names are `<db-name>.<collection-name>` and
+// `<db-name>.<collection-name>::<document-id>`, as expected by redis-oplog).
+func ChannelPrefix() string {
+	return globalConfig.ChannelPrefix
+}
+
+// TeeChannelPrefix enables migration tee mode. When set, every message is
+// published twice: once on the channels prefixed with ChannelPrefix, and once
+// on the channels prefixed with TeeChannelPrefix. This lets you migrate
+// consumers from one channel naming scheme to another without a flag-day
+// cutover: set TeeChannelPrefix to the new prefix, move consumers over, then
+// set ChannelPrefix to the new prefix and unset TeeChannelPrefix. It is set via
+// the environment variable `OTR_TEE_CHANNEL_PREFIX` and defaults to empty
+// (tee mode disabled).
+func TeeChannelPrefix() string {
+	return globalConfig.TeeChannelPrefix
+}
+
+// CollectionChannelTemplate is a template (in the syntax of Go's
+// text/template) for the names of collection channels, for consumers that
+// expect a different channel naming scheme than redis-oplog's. It's executed
+// with `.Prefix` (the channel prefix; with tee mode, it's executed once per
+// prefix), `.Database`, and `.Collection`. It doesn't apply to custom
+// collection channels (OTR_COLLECTION_NAMESPACES and OTR_COLLECTION_CHANNELS)
+// or the global channel. It is set via the environment variable
+// `OTR_COLLECTION_CHANNEL_TEMPLATE`, and defaults to empty, which uses
+// `{{.Prefix}}{{.Database}}.{{.Collection}}`.
+func CollectionChannelTemplate() string {
+	return globalConfig.CollectionChannelTemplate
+}
+
+// SpecificChannelTemplate is a template (like CollectionChannelTemplate) for
+// the names of document-specific channels. It's also executed with `.DocID`,
+// the document's _id in the form used by the default channel. It is set via
+// the environment variable `OTR_SPECIFIC_CHANNEL_TEMPLATE`, and defaults to
+// empty, which uses `{{.Prefix}}{{.Database}}.{{.Collection}}::{{.DocID}}`.
+func SpecificChannelTemplate() string {
+	return globalConfig.SpecificChannelTemplate
+}
+
+// ChannelPrefixes returns all of the prefixes that each message should be
+// published under: ChannelPrefix, and TeeChannelPrefix if tee mode is enabled.
+func ChannelPrefixes() []string {
+	if globalConfig.TeeChannelPrefix == "" || globalConfig.TeeChannelPrefix == globalConfig.ChannelPrefix {
+		return []string{globalConfig.ChannelPrefix}
+	}
+
+	return []string{globalConfig.ChannelPrefix, globalConfig.TeeChannelPrefix}
+}
+
+// CollectionNamespaces maps collections ("<db-name>.<collection-name>") to
+// the redis-oplog namespaces their changes are published under, for apps that
+// use redis-oplog's `namespace` (or `namespaces`) mutation option. Separate
+// multiple namespaces for a collection with "|". Changes to those collections
+// are published to `<db-name>.<namespace>::<collection-name>` instead of
+// `<db-name>.<collection-name>`; the document-specific channel is unchanged.
+// It is set via the environment variable `OTR_COLLECTION_NAMESPACES`, in the
+// form `db.coll1:ns1|ns2,db.coll2:ns3`, and defaults to empty.
+func CollectionNamespaces() map[string]string {
+	return globalConfig.CollectionNamespaces
+}
+
+// CollectionChannels maps collections ("<db-name>.<collection-name>") to the
+// custom redis-oplog channels their changes are published to, for apps that
+// use redis-oplog's `channel` (or `channels`) mutation option. Separate
+// multiple channels for a collection with "|". Changes to those collections
+// are published to `<db-name>.<channel>` instead of
+// `<db-name>.<collection-name>`; the document-specific channel is unchanged.
+// A collection may have both namespaces and channels. It is set via the
+// environment variable `OTR_COLLECTION_CHANNELS`, in the form
+// `db.coll1:chan1|chan2,db.coll2:chan3`, and defaults to empty.
+func CollectionChannels() map[string]string {
+	return globalConfig.CollectionChannels
+}
+
+// FullDocument controls whether insert and update messages include the whole
+// document rather than just its _id, for redis-oplog consumers running with
+// `protectAgainstRaceConditions: false`, which then don't need to fetch
+// documents from Mongo. Inserts and replacements carry the document in the
+// oplog, but every other update costs a Mongo query, and the document it
+// returns may already include later changes. It is set via the environment
+// variable `OTR_FULL_DOCUMENT` and defaults to false.
+func FullDocument() bool {
+	return globalConfig.FullDocument
+}
+
+// FullDocumentNamespaces restricts OTR_FULL_DOCUMENT to the collections that
+// match one of these glob patterns (with the same syntax as
+// OTR_INCLUDE_NAMESPACES), since fetching documents adds read load to Mongo.
+// Messages for other collections only include the _id. It is set via the
+// environment variable `OTR_FULL_DOCUMENT_NAMESPACES` as a comma-separated
+// list, and defaults to every collection.
+func FullDocumentNamespaces() []string {
+	return globalConfig.FullDocumentNamespaces
+}
+
+// FullDocumentProjections maps collections ("<db-name>.<collection-name>") to
+// the top-level fields that OTR_FULL_DOCUMENT includes in their documents
+// (along with _id), to keep messages small and spare Mongo from returning
+// fields nobody needs. Separate multiple fields with "|". Collections that
+// aren't listed get the whole document. It is set via the environment
+// variable `OTR_FULL_DOCUMENT_PROJECTIONS`, in the form
+// `db.coll1:field1|field2,db.coll2:field3`, and defaults to empty.
+func FullDocumentProjections() map[string][]string {
+	return splitFieldLists(globalConfig.FullDocumentProjections)
+}
+
+// RoutingFields maps collections ("<db-name>.<collection-name>") to fields
+// (which may be dotted paths) whose values messages are also published by,
+// so consumers can subscribe to one value's changes, like one tenant's,
+// rather than filter every change to the collection. Separate multiple
+// fields with "|". For example, with `app.tasks:tenantId`, a change to a task
+// with tenantId "acme" is also published to `app.tasks::tenantId::acme`
+// (with the channel prefixes applied). The values are read from inserts and
+// from the values updates set; updates that don't set a field are only
+// published by it with OTR_ROUTING_LOOKUP, and removes never are. It is set
+// via the environment variable `OTR_ROUTING_FIELDS`, in the form
+// `db.coll1:field1|field2,db.coll2:field3`, and defaults to empty.
+func RoutingFields() map[string][]string {
+	return splitFieldLists(globalConfig.RoutingFields)
+}
+
+// RoutingLookup controls whether routing fields (see RoutingFields) that an
+// update doesn't set are fetched from Mongo, so updates are published by
+// their routing fields too. That's one Mongo query per such update. It is set
+// via the environment variable `OTR_ROUTING_LOOKUP` and defaults to false.
+func RoutingLookup() bool {
+	return globalConfig.RoutingLookup
+}
+
+// RedactFields maps collections ("<db-name>.<collection-name>") to fields
+// (which may be dotted paths, like `services.password`) that are left out of
+// messages entirely, so sensitive data never reaches Redis: they're removed
+// from the field lists and, with OTR_FULL_DOCUMENT or OTR_CHANGED_VALUES,
+// from the documents in messages, including from each element of arrays of
+// embedded documents. They're never used as routing fields either. Separate
+// multiple fields with "|". It is set via the environment variable
+// `OTR_REDACT_FIELDS`, in the form `db.coll1:field1|field2,db.coll2:field3`,
+// and defaults to empty.
+func RedactFields() map[string][]string {
+	return splitFieldLists(globalConfig.RedactFields)
+}
+
+// HashFields maps collections to fields whose values are replaced by the
+// hex SHA-256 hash of their EJSON encoding in the documents in messages and
+// in routing channels (see RedactFields for where documents are included),
+// so consumers can compare or route by values without seeing them. The
+// hashes aren't salted, so only hash values that are hard to guess. They stay
+// in the field lists. It is set via the environment variable
+// `OTR_HASH_FIELDS`, in the same form as OTR_REDACT_FIELDS, and defaults to
+// empty.
+func HashFields() map[string][]string {
+	return splitFieldLists(globalConfig.HashFields)
+}
+
+// DeadLetterChannel is a channel that oplog entries that can't be processed
+// (like entries with an unsupported _id, or malformed updates) are published
+// to, instead of only being logged, so operators can inspect and replay them.
+// Each message is a JSON object with the `error` and the `entry` (with its
+// values converted like documents are for EJSON). The channel prefixes are
+// applied to it, and in streams mode it's a stream. Dead letters are counted
+// in the `otr_oplog_dead_letters` metric. It is set via the environment
+// variable `OTR_DEAD_LETTER_CHANNEL`, and defaults to empty (which only logs
+// those entries).
+func DeadLetterChannel() string {
+	return globalConfig.DeadLetterChannel
+}
+
+// CollectionEvents makes oplogtoredis publish an event when a collection is
+// dropped or renamed, or a database is dropped, so that consumers can
+// invalidate everything they have cached for it. Events are published to the
+// collection's meta channel, "<db>.<collection>::$meta" ("<db>::$meta" for
+// dropDatabase), as JSON with the event ("e": dropCollection,
+// renameCollection, or dropDatabase) and namespace ("ns"), and, for renames,
+// the new namespace ("to"). A rename is published on the meta channels of
+// both namespaces. It is set via the environment variable
+// `OTR_COLLECTION_EVENTS`, and defaults to false.
+func CollectionEvents() bool {
+	return globalConfig.CollectionEvents
+}
+
+// RenameRemoves is the largest collection whose documents oplogtoredis
+// publishes removes for when it's renamed, so that Meteor servers stop
+// serving them under the old name (redis-oplog only understands changes to
+// individual documents). The documents are looked up while tailing, which
+// holds up everything behind the rename, so renames of larger collections
+// are published as renameCollection events on their meta channels instead
+// (see OTR_COLLECTION_EVENTS), even if OTR_COLLECTION_EVENTS isn't set. It
+// is set via the environment variable `OTR_RENAME_REMOVES`, and defaults to
+// 0 (which only logs renames).
+func RenameRemoves() int {
+	return globalConfig.RenameRemoves
+}
+
+// PublishMigrations makes oplogtoredis publish the inserts and removes a
+// sharded cluster writes to the shards' oplogs when it moves a chunk from one
+// shard to another (which are marked with fromMigrate). By default they're
+// skipped, since the documents haven't changed; they've just moved. It is set
+// via the environment variable `OTR_PUBLISH_MIGRATIONS`, and defaults to
+// false.
+func PublishMigrations() bool {
+	return globalConfig.PublishMigrations
+}
+
+// FieldPaths is how the changed fields in messages are reported when an
+// update changes a field inside an embedded document or array: "full" (the
+// dotted path, like "a.2.b"), "top-level" (only the top-level field, like
+// "a", which is how Meteor reports changes), or "both". It is set via the
+// environment variable `OTR_FIELD_PATHS`, and defaults to "full".
+func FieldPaths() string {
+	return globalConfig.FieldPaths
+}
+
+// EventNames is the set of event names ("e") that messages are published
+// with: "meteor" ("i", "u", and "r" for inserts, updates, and removes, which
+// is what redis-oplog expects), "oplog" (the oplog's operation codes, "i",
+// "u", and "d"), or "words" ("insert", "update", and "remove"). It is set via
+// the environment variable `OTR_EVENT_NAMES`, and defaults to "meteor".
+func EventNames() string {
+	return globalConfig.EventNames
+}
+
+// ProtocolVersion is the version of the message format to publish. Version 1
+// is exactly what redis-oplog expects. Each later version includes its number
+// in every message ("pv"), and may add to the format in ways that existing
+// consumers don't understand, so upgrade consumers before raising it.
+// Version 2 adds only the version number. It is set via the environment
+// variable `OTR_PROTOCOL_VERSION`, and defaults to 1.
+func ProtocolVersion() int {
+	return globalConfig.ProtocolVersion
+}
+
+// PayloadFormat is the format of the messages oplogtoredis publishes:
+// "redis-oplog" (what redis-oplog expects) or "cloudevents", which wraps each
+// message in a CloudEvents 1.0 JSON envelope, with the message as its data,
+// so event routers (like Knative) can consume them without an adapter. Each
+// event's source is "/<OTR_CLUSTER_NAME>/<db>.<collection>" (or
+// "/<db>.<collection>" without a cluster name), its type is
+// "oplogtoredis.insert", "oplogtoredis.update", or "oplogtoredis.remove", its
+// id is the oplog timestamp and a hash of the message, and its subject is
+// the document ID. "debezium" publishes messages in the format of Debezium's
+// MongoDB connector (with "after", "patch", "filter", "source", and "op"), so
+// existing Debezium consumers can read them. Meteor servers only understand
+// "redis-oplog". It is set via the environment variable `OTR_PAYLOAD_FORMAT`,
+// and defaults to "redis-oplog".
+func PayloadFormat() string {
+	return globalConfig.PayloadFormat
+}
+
+// RedisEncoding is how messages published to Redis are encoded: "json",
+// "msgpack" (MessagePack maps with the same keys and values as the JSON), or
+// "protobuf" (in the schema in lib/encoding/message.proto). The other
+// encodings are smaller and cheaper for consumers to decode, but Meteor
+// servers only understand "json". It can't be combined with
+// OTR_RELAY_MODE, OTR_PUBLISH_BATCH_SIZE, or OTR_PUBLISH_WORKERS. It is set
+// via the environment variable `OTR_REDIS_ENCODING`, and defaults to "json".
+func RedisEncoding() string {
+	return globalConfig.RedisEncoding
+}
+
+// SecondaryRedisEncoding is how messages published to the secondary Redis
+// (OTR_SECONDARY_REDIS_URL) are encoded, like OTR_REDIS_ENCODING. It is set
+// via the environment variable `OTR_SECONDARY_REDIS_ENCODING`, and defaults
+// to "json".
+func SecondaryRedisEncoding() string {
+	return globalConfig.SecondaryRedisEncoding
+}
+
+// RedisCompression is how messages published to Redis that are larger than
+// OTR_COMPRESSION_THRESHOLD are compressed: "none" or "gzip". Compressed
+// messages start with gzip's magic number (0x1f 0x8b), which uncompressed
+// messages never start with, so consumers can tell which messages to
+// decompress; messages that compressing doesn't make smaller aren't
+// compressed. Meteor servers can't decompress messages. It can't be
+// combined with OTR_RELAY_MODE (use OTR_RELAY_COMPRESSION),
+// OTR_PUBLISH_BATCH_SIZE, or OTR_PUBLISH_WORKERS. It is set via the
+// environment variable `OTR_REDIS_COMPRESSION`, and defaults to "none".
+func RedisCompression() string {
+	return globalConfig.RedisCompression
+}
+
+// SecondaryRedisCompression is how large messages published to the
+// secondary Redis (OTR_SECONDARY_REDIS_URL) are compressed, like
+// OTR_REDIS_COMPRESSION. It is set via the environment variable
+// `OTR_SECONDARY_REDIS_COMPRESSION`, and defaults to "none".
+func SecondaryRedisCompression() string {
+	return globalConfig.SecondaryRedisCompression
+}
+
+// CompressionThreshold is the size in bytes (after encoding) above which
+// messages are compressed, with OTR_REDIS_COMPRESSION or
+// OTR_SECONDARY_REDIS_COMPRESSION. It is set via the environment variable
+// `OTR_COMPRESSION_THRESHOLD`, and defaults to 65536.
+func CompressionThreshold() int {
+	return globalConfig.CompressionThreshold
+}
+
+// MaxMessageSize is the largest message, in bytes, that oplogtoredis
+// publishes (before any encoding or compression), or 0 for no limit. Huge
+// messages (like those for replacements of big documents) can fill up
+// Redis's output buffers. What's done with larger messages is set by
+// OTR_OVERSIZE_ACTION. It is set via the environment variable
+// `OTR_MAX_MESSAGE_SIZE`, and defaults to 0.
+func MaxMessageSize() int {
+	return globalConfig.MaxMessageSize
+}
+
+// OversizeAction is what's done with messages larger than
+// OTR_MAX_MESSAGE_SIZE: "truncate" leaves the document's values out of the
+// message, and then, if it's still too large, reports only its top-level
+// changed fields; "refetch" publishes a marker message (with the event
+// "refetch" and just the document's _id) instead, so consumers know to
+// fetch the document themselves; and "drop" drops the message. Messages
+// that are still too large are dropped. It is set via the environment
+// variable `OTR_OVERSIZE_ACTION`, and defaults to "truncate".
+func OversizeAction() string {
+	return globalConfig.OversizeAction
+}
+
+// MessageNamespace makes every message include the namespace of its document
+// ("ns", like "app.tasks"), for consumers that subscribe to several channels
+// with a pattern and can't tell which collection a message is about. It's
+// always included when OTR_GLOBAL_CHANNEL is set. It is set via the
+// environment variable `OTR_MESSAGE_NAMESPACE`, and defaults to false.
+func MessageNamespace() bool {
+	return globalConfig.MessageNamespace
+}
+
+// MessageTimestamp makes every message include the timestamp of its oplog
+// entry ("ts", the cluster time, as "<seconds>:<increment>"). It is set via
+// the environment variable `OTR_MESSAGE_TIMESTAMP`, and defaults to false.
+func MessageTimestamp() bool {
+	return globalConfig.MessageTimestamp
+}
+
+// MessageWallTime makes every message include the wall-clock time at which
+// its oplog entry was written ("wall", as EJSON: {"$date": <milliseconds>}).
+// Before Mongo 3.6 (or 6.0 with OTR_CHANGE_STREAMS), it's the time from the
+// entry's timestamp, which only has a resolution of one second. It is set
+// via the environment variable `OTR_MESSAGE_WALL_TIME`, and defaults to
+// false.
+func MessageWallTime() bool {
+	return globalConfig.MessageWallTime
+}
+
+// MessagePublisherVersion makes every message include the version of
+// oplogtoredis that published it ("otrv", as shown by `--version`), so
+// consumers can tell when copies running different versions are publishing
+// at once (like during a rolling deploy). It is set via the environment
+// variable `OTR_MESSAGE_PUBLISHER_VERSION`, and defaults to false.
+func MessagePublisherVersion() bool {
+	return globalConfig.MessagePublisherVersion
+}
+
+// DataGapChannel is a channel that an event is published to when oplogtoredis
+// can't resume where it left off because the oplog has already rolled over
+// (e.g. because it was stopped for longer than the oplog window), so the
+// changes in between were lost. Each event is a JSON object with the position
+// we tried to resume from (`from` and `fromTime`), the oldest entry still in
+// the oplog (`oldest` and `oldestTime`), where we restarted (`restartFrom`),
+// and the `shard`, for sharded clusters. Data gaps are always logged and
+// counted in the `otr_oplog_data_gaps` metric. It is set via the environment
+// variable `OTR_DATA_GAP_CHANNEL`, and defaults to empty (which doesn't
+// publish them).
+func DataGapChannel() string {
+	return globalConfig.DataGapChannel
+}
+
+// DataGapRestart is where to restart after a data gap: "oldest" (the oldest
+// entry still in the oplog, publishing as much as we can) or "newest" (the
+// end of the oplog). It is set via the environment variable
+// `OTR_DATA_GAP_RESTART`, and defaults to "oldest".
+func DataGapRestart() string {
+	return globalConfig.DataGapRestart
+}
+
+// OplogWindowMetricInterval is how often to update the
+// `otr_oplog_window_seconds` metric, which reports how much time the oplog
+// covers. Each update queries the oplog twice (per shard, for sharded
+// clusters). It is set via the environment variable
+// `OTR_OPLOG_WINDOW_METRIC_INTERVAL` and defaults to 60s.
+func OplogWindowMetricInterval() time.Duration {
+	return globalConfig.OplogWindowMetricInterval
+}
+
+// MinOplogWindow is how much time the oplog should cover: how long you want
+// oplogtoredis to be able to be down without losing changes. oplogtoredis
+// logs a warning (and `oplogtoredis doctor` reports one) when the oplog
+// covers less than this, or less than MaxCatchUp, whichever is longer. It is
+// set via the environment variable `OTR_MIN_OPLOG_WINDOW` and defaults to 0
+// (which only compares against MaxCatchUp).
+func MinOplogWindow() time.Duration {
+	return globalConfig.MinOplogWindow
+}
+
+// SnapshotNamespaces enables snapshot mode: when oplogtoredis starts
+// tailing without a last-processed timestamp to resume from, it first
+// publishes an insert for every existing document in the collections that
+// match one of these glob patterns (with the same syntax as
+// OTR_INCLUDE_NAMESPACES, e.g. "app.*"), so that new consumers like caches or
+// search indexes can bootstrap from oplogtoredis alone. It then tails the
+// oplog from where it was when the snapshot started, so consumers may see
+// changes made during the snapshot twice, but don't miss any. The
+// last-processed timestamp is only saved once the whole snapshot is
+// published, so a snapshot that's interrupted is taken again, but once one
+// finishes, oplogtoredis resumes tailing instead. It can't be combined with
+// OTR_SHARDED or OTR_HANDOFF. It is set via the environment
+// variable `OTR_SNAPSHOT_NAMESPACES` as a comma-separated list, and defaults
+// to empty (no snapshot).
+func SnapshotNamespaces() []string {
+	return globalConfig.SnapshotNamespaces
+}
+
+// SnapshotRate is the maximum number of documents per second a snapshot (see
+// SnapshotNamespaces) publishes, so it doesn't overload Mongo, Redis, or
+// consumers. 0 means no limit. It is set via the environment variable
+// `OTR_SNAPSHOT_RATE` and defaults to 1000.
+func SnapshotRate() int {
+	return globalConfig.SnapshotRate
+}
+
+// Splits the "|"-separated field lists in a map of collections to fields
+func splitFieldLists(lists map[string]string) map[string][]string {
+	fields := map[string][]string{}
+	for collection, list := range lists {
+		fields[collection] = strings.Split(list, "|")
+	}
+
+	return fields
+}
+
+// ChangedValues controls whether insert and update messages include the
+// values they wrote, for consumers other than redis-oplog that need the data
+// itself and would otherwise have to read every changed document from Mongo.
+// Inserts (and replacements) include the whole document; other updates
+// include the `$set` values, keyed by dotted field path like `$set` (e.g.
+// `{"_id": "abc", "profile.name": "Ada"}`). Unset fields, and array elements
+// changed in place, are only listed in the message's fields. Unlike
+// FullDocument, this never queries Mongo. It can't be combined with
+// FullDocument. It is set via the environment variable `OTR_CHANGED_VALUES`
+// and defaults to false.
+func ChangedValues() bool {
+	return globalConfig.ChangedValues
+}
+
+// DocumentVersion controls whether every message includes a document version
+// (`"v"`), which increases with each change to a document, so consumers (like
+// redis-oplog Vent handlers or optimistic-UI reconciliation) can detect stale
+// events without reading from Mongo. The version is derived from the oplog
+// timestamp; it's a zero-padded decimal string, since oplog timestamps are
+// too big for JavaScript numbers, so versions should be compared as strings.
+// It is set via the environment variable `OTR_DOCUMENT_VERSION` and defaults
+// to false.
+func DocumentVersion() bool {
+	return globalConfig.DocumentVersion
+}
+
+// GlobalChannel is a channel that every message is also published to (with
+// the channel prefixes applied), for consumers that want every change without
+// pattern-subscribing to every collection's channel. When it's set, messages
+// include the namespace of their document (`"ns"`, in the form
+// `<db-name>.<collection-name>`), since the global channel's name doesn't
+// say. It is set via the environment variable `OTR_GLOBAL_CHANNEL`, and
+// defaults to empty (which disables the global channel).
+func GlobalChannel() string {
+	return globalConfig.GlobalChannel
+}
+
+// IncludeNamespaces restricts oplogtoredis to the collections that match one
+// of these glob patterns (in the syntax of Go's path.Match, matched against
+// "<db-name>.<collection-name>", where "*" also matches dots), e.g.
+// "app.*,*.users". Changes to other collections are skipped before they're
+// processed. It is set via the environment variable `OTR_INCLUDE_NAMESPACES`
+// as a comma-separated list, and defaults to including every collection.
+func IncludeNamespaces() []string {
+	return globalConfig.IncludeNamespaces
+}
+
+// ExcludeNamespaces skips changes to the collections that match one of these
+// glob patterns (with the same syntax as OTR_INCLUDE_NAMESPACES), even if
+// they're included by OTR_INCLUDE_NAMESPACES. It is set via the environment
+// variable `OTR_EXCLUDE_NAMESPACES` as a comma-separated list, and defaults
+// to excluding nothing.
+func ExcludeNamespaces() []string {
+	return globalConfig.ExcludeNamespaces
+}
+
+// SyntheticChannelPrefix enables relaying redis-oplog synthetic mutations.
+// If set, messages that app servers publish to `<prefix><channel>` are
+// republished to `<channel>` (with the channel prefixes applied, and
+// compressed in relay mode with compression enabled), so consumers get
+// synthetic mutations from the same place as oplogtoredis's own messages. It
+// is set via the environment variable `OTR_SYNTHETIC_CHANNEL_PREFIX`, and
+// defaults to empty (which disables relaying).
+func SyntheticChannelPrefix() string {
+	return globalConfig.SyntheticChannelPrefix
+}
+
+// CustomCollectionChannels combines CollectionNamespaces and
+// CollectionChannels into the full names of the channels to publish to
+// instead of the default collection channel, keyed by the default collection
+// channel. Channel prefixes are applied on top of these.
+func CustomCollectionChannels() map[string][]string {
+	channels := map[string][]string{}
+
+	for collection, namespaces := range globalConfig.CollectionNamespaces {
+		db, collectionName := splitCollection(collection)
+		for _, namespace := range strings.Split(namespaces, "|") {
+			channels[collection] = append(channels[collection], db+"."+namespace+"::"+collectionName)
+		}
+	}
+
+	for collection, customChannels := range globalConfig.CollectionChannels {
+		db, _ := splitCollection(collection)
+		for _, channel := range strings.Split(customChannels, "|") {
+			channels[collection] = append(channels[collection], db+"."+channel)
+		}
+	}
+
+	return channels
+}
+
+// Splits "<db-name>.<collection-name>" into its parts. Collection names may
+// contain dots, but database names can't.
+func splitCollection(collection string) (string, string) {
+	parts := strings.SplitN(collection, ".", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// Handoff enables the zero-downtime restart handoff protocol. When enabled,
+// a newly-started copy of oplogtoredis asks an already-running copy (with the
+// same RedisMetadataPrefix) to stop tailing, finish publishing everything it
+// has buffered, and tell the new copy the exact timestamp it stopped at. The
+// old copy then exits, and the new copy resumes from that timestamp. This
+// closes the window during deploys where events could be published twice or
+// missed. It is set via the environment variable `OTR_HANDOFF` and defaults to
+// false.
+func Handoff() bool {
+	return globalConfig.Handoff
+}
+
+// HandoffTimeout is how long a newly-started copy of oplogtoredis waits for
+// the running copy to complete a handoff. If the handoff doesn't complete in
+// time, the new copy starts up as it would without a handoff. It is set via
+// the environment variable `OTR_HANDOFF_TIMEOUT` and defaults to 30s.
+func HandoffTimeout() time.Duration {
+	return globalConfig.HandoffTimeout
+}
+
+// ShutdownTimeout is how long oplogtoredis spends publishing the messages it
+// has buffered when it gets a SIGINT or SIGTERM (or hands off to a new copy),
+// before exiting anyway. Whatever it doesn't publish is re-read from the oplog
+// the next time oplogtoredis starts. The default leaves time to spare before
+// Kubernetes's default 30 second termination grace period runs out. It is
+// set via the environment variable `OTR_SHUTDOWN_TIMEOUT` and defaults to
+// 20s.
+func ShutdownTimeout() time.Duration {
+	return globalConfig.ShutdownTimeout
+}
+
+// LogLevel is the minimum level of the messages that are logged: "debug",
+// "info", "warn", or "error". It is set via the environment variable
+// `OTR_LOG_LEVEL`, and defaults to "" (debug with OTR_LOG_DEBUG, and info
+// otherwise).
+func LogLevel() string {
+	return globalConfig.LogLevel
+}
+
+// LogFormat is how log lines are encoded: "json", for structured logging
+// systems, or "console", for people. It is set via the environment variable
+// `OTR_LOG_FORMAT`, and defaults to "" (console with OTR_LOG_DEBUG, and json
+// otherwise).
+func LogFormat() string {
+	return globalConfig.LogFormat
+}
+
+// LogSamplingInitial is how many log lines with the same level and message
+// are logged each second, before only every LogSamplingThereafter'th one
+// is, or -1 to log every line. It is set via the environment variable
+// `OTR_LOG_SAMPLING_INITIAL`, and defaults to 0 (100, or no sampling with
+// OTR_LOG_DEBUG).
+func LogSamplingInitial() int {
+	return globalConfig.LogSamplingInitial
+}
+
+// LogSamplingThereafter is how often a log line is logged once more than
+// LogSamplingInitial with the same level and message have been in a second:
+// every LogSamplingThereafter'th one. It is set via the environment
+// variable `OTR_LOG_SAMPLING_THEREAFTER`, and defaults to 100.
+func LogSamplingThereafter() int {
+	return globalConfig.LogSamplingThereafter
+}
+
+// LogThrottle is how often a warning or error with the same message is
+// logged, at most, to keep errors that repeat for as long as an outage
+// lasts (like those from reconnecting to Mongo) from flooding log
+// aggregators. The next line logged says how many were suppressed. It is
+// set via the environment variable `OTR_LOG_THROTTLE`, and defaults to 0
+// (no throttling).
+func LogThrottle() time.Duration {
+	return globalConfig.LogThrottle
+}
+
+// OtlpEndpoint is the base URL of an OpenTelemetry collector's OTLP/HTTP
+// receiver (like http://localhost:4318). If it's set, oplog entries are
+// traced through decoding, processing, publishing, and checkpointing, and
+// the spans are sent there, to show where latency accumulates when
+// oplogtoredis falls behind. It is set via the environment variable
+// `OTR_OTLP_ENDPOINT`, and defaults to "" (no tracing).
+func OtlpEndpoint() string {
+	return globalConfig.OtlpEndpoint
+}
+
+// TraceSampleRate is the fraction (greater than 0, and at most 1) of oplog
+// entries that are traced, with OTR_OTLP_ENDPOINT. It is set via the
+// environment variable `OTR_TRACE_SAMPLE_RATE`, and defaults to 1 (every
+// entry).
+func TraceSampleRate() float64 {
+	return globalConfig.TraceSampleRate
+}
+
+// ChaosMode enables fault injection, for exercising oplogtoredis's recovery
+// and buffering behavior in a staging environment. When enabled, oplogtoredis
+// randomly fails Redis publishes, aborts its oplog cursor, and adds latency to
+// publishes, at the rates configured by ChaosPublishFailureRate,
+// ChaosCursorErrorRate, and ChaosLatencyRate. Never enable this in
+// production. It is set via the environment variable `OTR_CHAOS_MODE` and
+// defaults to false.
+func ChaosMode() bool {
+	return globalConfig.ChaosMode
+}
+
+// ChaosPublishFailureRate is the fraction (from 0 to 1) of Redis publishes
+// that fail when ChaosMode is enabled. It is set via the environment variable
+// `OTR_CHAOS_PUBLISH_FAILURE_RATE` and defaults to 0.
+func ChaosPublishFailureRate() float64 {
+	return globalConfig.ChaosPublishFailureRate
+}
+
+// ChaosCursorErrorRate is the fraction (from 0 to 1) of oplog entries that
+// cause the oplog cursor to fail when ChaosMode is enabled. It is set via the
+// environment variable `OTR_CHAOS_CURSOR_ERROR_RATE` and defaults to 0.
+func ChaosCursorErrorRate() float64 {
+	return globalConfig.ChaosCursorErrorRate
+}
+
+// ChaosLatencyRate is the fraction (from 0 to 1) of Redis publishes that are
+// delayed when ChaosMode is enabled. It is set via the environment variable
+// `OTR_CHAOS_LATENCY_RATE` and defaults to 0.
+func ChaosLatencyRate() float64 {
+	return globalConfig.ChaosLatencyRate
+}
+
+// ChaosLatency is the maximum delay added to a publish selected by
+// ChaosLatencyRate. Each delay is chosen uniformly between 0 and this value.
+// It is set via the environment variable `OTR_CHAOS_LATENCY` and defaults to
+// 1s.
+func ChaosLatency() time.Duration {
+	return globalConfig.ChaosLatency
+}
+
 // ParseEnv parses the current environment variables and updates the stored
 // configuration. It is *not* threadsafe, and should just be called once
 // at the start of the program.
+//
+// If the environment variable `OTR_CONFIG_FILE` is set, options are also
+// read from the YAML file it names. Options set in the environment take
+// precedence over the file, which is applied by setting the environment
+// variables that aren't already set.
 func ParseEnv() error {
 	var config oplogtoredisConfiguration
 
-	err := envconfig.Process("otr", &config)
+	err := loadConfigFile()
+	if err != nil {
+		return err
+	}
+
+	err = envconfig.Process("otr", &config)
 	if err != nil {
 		return err
 	}
 
+	if !config.RedisTLS && (config.RedisTLSCAFile != "" || config.RedisTLSCertFile != "" ||
+		config.RedisTLSKeyFile != "" || config.RedisTLSInsecure || config.RedisTLSServerName != "") {
+		return errors.New("OTR_REDIS_TLS_* options are set, but OTR_REDIS_TLS is not enabled")
+	}
+
+	if (config.RedisTLSCertFile == "") != (config.RedisTLSKeyFile == "") {
+		return errors.New("OTR_REDIS_TLS_CERT_FILE and OTR_REDIS_TLS_KEY_FILE must be set together")
+	}
+
+	if config.LeaderElection != "" && config.LeaderElection != "kubernetes" && config.LeaderElection != "redis" {
+		return fmt.Errorf("Invalid OTR_LEADER_ELECTION %q; must be empty, \"kubernetes\", or \"redis\"", config.LeaderElection)
+	}
+
+	if config.LeaderElection != "" && config.LeaderElectionRenewDeadline >= config.LeaderElectionLeaseDuration {
+		return errors.New("OTR_LEADER_ELECTION_RENEW_DEADLINE must be shorter than OTR_LEADER_ELECTION_LEASE_DURATION")
+	}
+
+	if config.Sharded && config.ChangeStreams {
+		return errors.New("OTR_SHARDED can't be combined with OTR_CHANGE_STREAMS; a change stream through mongos already covers every shard")
+	}
+
+	switch config.MongoReadPreference {
+	case "", "primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest":
+	default:
+		return fmt.Errorf("Invalid OTR_MONGO_READ_PREFERENCE %q: must be primary, primaryPreferred, secondary, secondaryPreferred, or nearest", config.MongoReadPreference)
+	}
+
+	if config.MongoDirect && config.Sharded {
+		return errors.New("OTR_MONGO_DIRECT can't be combined with OTR_SHARDED, which connects to each shard's replica set")
+	}
+
+	if config.MongoConnectTimeout <= 0 {
+		return fmt.Errorf("Invalid OTR_MONGO_CONNECT_TIMEOUT %s: must be positive", config.MongoConnectTimeout)
+	}
+
+	if config.MongoSocketTimeout <= 0 {
+		return fmt.Errorf("Invalid OTR_MONGO_SOCKET_TIMEOUT %s: must be positive", config.MongoSocketTimeout)
+	}
+
+	if config.MongoPoolSize < 0 {
+		return fmt.Errorf("Invalid OTR_MONGO_POOL_SIZE %d: must be at least 0", config.MongoPoolSize)
+	}
+
+	if len(config.MongoAppName) > 128 {
+		return fmt.Errorf("Invalid OTR_MONGO_APP_NAME %q: must be at most 128 bytes", config.MongoAppName)
+	}
+
+	if config.Sharded && config.Handoff {
+		return errors.New("OTR_SHARDED can't be combined with OTR_HANDOFF")
+	}
+
+	if (len(config.FullDocumentNamespaces) > 0 || len(config.FullDocumentProjections) > 0) && !config.FullDocument {
+		return errors.New("OTR_FULL_DOCUMENT_NAMESPACES and OTR_FULL_DOCUMENT_PROJECTIONS are set, but OTR_FULL_DOCUMENT is not enabled")
+	}
+
+	for collection, fields := range config.FullDocumentProjections {
+		if db, collectionName := splitCollection(collection); db == "" || collectionName == "" {
+			return fmt.Errorf("Invalid collection %q in OTR_FULL_DOCUMENT_PROJECTIONS; must be <db-name>.<collection-name>", collection)
+		}
+
+		for _, field := range strings.Split(fields, "|") {
+			if field == "" || strings.Contains(field, ".") {
+				return fmt.Errorf("Invalid field %q for collection %q in OTR_FULL_DOCUMENT_PROJECTIONS; must be a top-level field", field, collection)
+			}
+		}
+	}
+
+	for collection, fields := range config.RoutingFields {
+		if db, collectionName := splitCollection(collection); db == "" || collectionName == "" {
+			return fmt.Errorf("Invalid collection %q in OTR_ROUTING_FIELDS; must be <db-name>.<collection-name>", collection)
+		}
+
+		for _, field := range strings.Split(fields, "|") {
+			if field == "" {
+				return fmt.Errorf("Empty field for collection %q in OTR_ROUTING_FIELDS", collection)
+			}
+		}
+	}
+
+	for name, fieldLists := range map[string]map[string]string{
+		"OTR_REDACT_FIELDS": config.RedactFields,
+		"OTR_HASH_FIELDS":   config.HashFields,
+	} {
+		for collection, fields := range fieldLists {
+			if db, collectionName := splitCollection(collection); db == "" || collectionName == "" {
+				return fmt.Errorf("Invalid collection %q in %s; must be <db-name>.<collection-name>", collection, name)
+			}
+
+			for _, field := range strings.Split(fields, "|") {
+				if field == "" || field == "_id" || strings.HasPrefix(field, "_id.") {
+					return fmt.Errorf("Invalid field %q for collection %q in %s; must not be empty or the _id", field, collection, name)
+				}
+			}
+		}
+	}
+
+	if config.RoutingLookup && len(config.RoutingFields) == 0 {
+		return errors.New("OTR_ROUTING_LOOKUP is set, but OTR_ROUTING_FIELDS is empty")
+	}
+
+	if config.ChangedValues && config.FullDocument {
+		return errors.New("OTR_CHANGED_VALUES can't be combined with OTR_FULL_DOCUMENT, which already includes the values")
+	}
+
+	if len(config.SnapshotNamespaces) > 0 && config.Sharded {
+		return errors.New("OTR_SNAPSHOT_NAMESPACES can't be combined with OTR_SHARDED")
+	}
+
+	if len(config.SnapshotNamespaces) > 0 && config.Handoff {
+		return errors.New("OTR_SNAPSHOT_NAMESPACES can't be combined with OTR_HANDOFF, which already says where to resume")
+	}
+
+	if config.SnapshotRate < 0 {
+		return errors.New("OTR_SNAPSHOT_RATE must not be negative")
+	}
+
+	if config.LagMetricInterval <= 0 {
+		return errors.New("OTR_LAG_METRIC_INTERVAL must be positive")
+	}
+
+	if config.OplogWindowMetricInterval <= 0 {
+		return errors.New("OTR_OPLOG_WINDOW_METRIC_INTERVAL must be positive")
+	}
+
+	if config.MinOplogWindow < 0 {
+		return errors.New("OTR_MIN_OPLOG_WINDOW must not be negative")
+	}
+
+	if config.ShutdownTimeout <= 0 {
+		return errors.New("OTR_SHUTDOWN_TIMEOUT must be positive")
+	}
+
+	if config.PublishMaxRetries < 1 {
+		return errors.New("OTR_PUBLISH_MAX_RETRIES must be at least 1")
+	}
+
+	if config.ReconnectBackoffInitial <= 0 {
+		return fmt.Errorf("Invalid OTR_RECONNECT_BACKOFF_INITIAL %s: must be positive", config.ReconnectBackoffInitial)
+	}
+
+	if config.ReconnectBackoffMax < config.ReconnectBackoffInitial {
+		return fmt.Errorf("Invalid OTR_RECONNECT_BACKOFF_MAX %s: must be at least OTR_RECONNECT_BACKOFF_INITIAL (%s)", config.ReconnectBackoffMax, config.ReconnectBackoffInitial)
+	}
+
+	if config.ReconnectBackoffJitter < 0 || config.ReconnectBackoffJitter > 1 {
+		return fmt.Errorf("Invalid OTR_RECONNECT_BACKOFF_JITTER %v: must be between 0 and 1", config.ReconnectBackoffJitter)
+	}
+
+	if config.RenameRemoves < 0 {
+		return fmt.Errorf("Invalid OTR_RENAME_REMOVES %d: must be at least 0", config.RenameRemoves)
+	}
+
+	if config.TailMaxRestarts < 0 {
+		return fmt.Errorf("Invalid OTR_TAIL_MAX_RESTARTS %d: must be at least 0", config.TailMaxRestarts)
+	}
+
+	if config.RelayMode && config.RelayBatchSize < 1 {
+		return errors.New("OTR_RELAY_BATCH_SIZE must be at least 1")
+	}
+
+	if config.PublishBatchSize < 1 {
+		return errors.New("OTR_PUBLISH_BATCH_SIZE must be at least 1")
+	}
+
+	if config.PublishBatchSize > 1 {
+		if config.PublishBatchWindow <= 0 {
+			return errors.New("OTR_PUBLISH_BATCH_WINDOW must be positive")
+		}
+
+		if config.RelayMode {
+			return errors.New("OTR_PUBLISH_BATCH_SIZE can't be combined with OTR_RELAY_MODE; use OTR_RELAY_BATCH_SIZE")
+		}
+
+		if config.Streams || config.SecondaryRedisURL != "" || config.WebhookURL != "" || len(config.KafkaBrokers) > 0 {
+			return errors.New("OTR_PUBLISH_BATCH_SIZE can't be combined with OTR_STREAMS, OTR_SECONDARY_REDIS_URL, OTR_WEBHOOK_URL, or OTR_KAFKA_BROKERS")
+		}
+	}
+
+	if config.PublishWorkers < 1 || config.PublishWorkerQueueSize < 1 {
+		return errors.New("OTR_PUBLISH_WORKERS and OTR_PUBLISH_WORKER_QUEUE_SIZE must be at least 1")
+	}
+
+	if config.PublishWorkers > 1 && (config.RelayMode || config.PublishBatchSize > 1 || config.SecondaryRedisURL != "" || config.WebhookURL != "" || len(config.KafkaBrokers) > 0) {
+		return errors.New("OTR_PUBLISH_WORKERS can't be combined with OTR_RELAY_MODE, OTR_PUBLISH_BATCH_SIZE, OTR_SECONDARY_REDIS_URL, OTR_WEBHOOK_URL, or OTR_KAFKA_BROKERS")
+	}
+
+	if config.PublishWALFile != "" && (config.RelayMode || config.PublishBatchSize > 1 || config.PublishWorkers > 1) {
+		return errors.New("OTR_PUBLISH_WAL_FILE can't be combined with OTR_RELAY_MODE, OTR_PUBLISH_BATCH_SIZE, or OTR_PUBLISH_WORKERS")
+	}
+
+	switch config.CheckpointStore {
+	case "redis", "mongo":
+	case "file":
+		if config.CheckpointFile == "" {
+			return errors.New("OTR_CHECKPOINT_FILE is required with OTR_CHECKPOINT_STORE=file")
+		}
+	default:
+		return fmt.Errorf("Invalid OTR_CHECKPOINT_STORE %q: must be redis, file, or mongo", config.CheckpointStore)
+	}
+
+	if parts := strings.SplitN(config.CheckpointCollection, ".", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("Invalid OTR_CHECKPOINT_COLLECTION %q: must be <db-name>.<collection-name>", config.CheckpointCollection)
+	}
+
+	if strings.Contains(config.ClusterName, "::") {
+		return fmt.Errorf("Invalid OTR_CLUSTER_NAME %q: must not contain \"::\"", config.ClusterName)
+	}
+
+	switch config.FieldPaths {
+	case "full", "top-level", "both":
+	default:
+		return fmt.Errorf("Invalid OTR_FIELD_PATHS %q: must be full, top-level, or both", config.FieldPaths)
+	}
+
+	if config.ProtocolVersion < 1 || config.ProtocolVersion > 2 {
+		return fmt.Errorf("Invalid OTR_PROTOCOL_VERSION %d: must be 1 or 2", config.ProtocolVersion)
+	}
+
+	switch config.PayloadFormat {
+	case "redis-oplog", "cloudevents", "debezium":
+	default:
+		return fmt.Errorf("Invalid OTR_PAYLOAD_FORMAT %q: must be redis-oplog, cloudevents, or debezium", config.PayloadFormat)
+	}
+
+	for name, value := range map[string]string{
+		"OTR_REDIS_ENCODING":           config.RedisEncoding,
+		"OTR_SECONDARY_REDIS_ENCODING": config.SecondaryRedisEncoding,
+	} {
+		switch value {
+		case "json", "msgpack", "protobuf":
+		default:
+			return fmt.Errorf("Invalid %s %q: must be json, msgpack, or protobuf", name, value)
+		}
+	}
+
+	if config.RedisEncoding != "json" && (config.RelayMode || config.PublishBatchSize > 1 || config.PublishWorkers > 1) {
+		return errors.New("OTR_REDIS_ENCODING can't be combined with OTR_RELAY_MODE, OTR_PUBLISH_BATCH_SIZE, or OTR_PUBLISH_WORKERS")
+	}
+
+	for name, value := range map[string]string{
+		"OTR_REDIS_COMPRESSION":           config.RedisCompression,
+		"OTR_SECONDARY_REDIS_COMPRESSION": config.SecondaryRedisCompression,
+	} {
+		if value != "none" && value != "gzip" {
+			return fmt.Errorf("Invalid %s %q: must be none or gzip", name, value)
+		}
+	}
+
+	if config.RedisCompression != "none" && (config.RelayMode || config.PublishBatchSize > 1 || config.PublishWorkers > 1) {
+		return errors.New("OTR_REDIS_COMPRESSION can't be combined with OTR_RELAY_MODE, OTR_PUBLISH_BATCH_SIZE, or OTR_PUBLISH_WORKERS")
+	}
+
+	if config.MaxMessageSize < 0 {
+		return fmt.Errorf("Invalid OTR_MAX_MESSAGE_SIZE %d: must be at least 0", config.MaxMessageSize)
+	}
+
+	switch config.OversizeAction {
+	case "truncate", "refetch", "drop":
+	default:
+		return fmt.Errorf("Invalid OTR_OVERSIZE_ACTION %q: must be truncate, refetch, or drop", config.OversizeAction)
+	}
+
+	if config.CompressionThreshold < 0 {
+		return fmt.Errorf("Invalid OTR_COMPRESSION_THRESHOLD %d: must be at least 0", config.CompressionThreshold)
+	}
+
+	switch config.EventNames {
+	case "meteor", "oplog", "words":
+	default:
+		return fmt.Errorf("Invalid OTR_EVENT_NAMES %q: must be meteor, oplog, or words", config.EventNames)
+	}
+
+	if config.DataGapRestart != "oldest" && config.DataGapRestart != "newest" {
+		return fmt.Errorf("Invalid OTR_DATA_GAP_RESTART %q: must be oldest or newest", config.DataGapRestart)
+	}
+
+	switch config.CheckpointFlushStrategy {
+	case "interval", "idle":
+	case "count":
+		if config.CheckpointFlushCount < 1 {
+			return errors.New("OTR_CHECKPOINT_FLUSH_COUNT must be at least 1 with OTR_CHECKPOINT_FLUSH_STRATEGY=count")
+		}
+	default:
+		return fmt.Errorf("Invalid OTR_CHECKPOINT_FLUSH_STRATEGY %q: must be interval, count, or idle", config.CheckpointFlushStrategy)
+	}
+
+	switch config.BufferOverflow {
+	case "block":
+	case "drop-oldest", "spill":
+		if config.BufferSize < 1 {
+			return errors.New("OTR_BUFFER_SIZE must be at least 1 with OTR_BUFFER_OVERFLOW=" + config.BufferOverflow)
+		}
+	default:
+		return fmt.Errorf("Invalid OTR_BUFFER_OVERFLOW %q: must be block, drop-oldest, or spill", config.BufferOverflow)
+	}
+
+	if config.BufferSpillDir != "" && config.BufferOverflow != "spill" {
+		return errors.New("OTR_BUFFER_SPILL_DIR can only be set with OTR_BUFFER_OVERFLOW=spill")
+	}
+
+	if config.SecondaryRedisURL != "" && config.RelayMode {
+		return errors.New("OTR_SECONDARY_REDIS_URL can't be combined with OTR_RELAY_MODE")
+	}
+
+	if config.WebhookURL != "" && config.RelayMode {
+		return errors.New("OTR_WEBHOOK_URL can't be combined with OTR_RELAY_MODE")
+	}
+
+	if len(config.KafkaBrokers) > 0 && config.RelayMode {
+		return errors.New("OTR_KAFKA_BROKERS can't be combined with OTR_RELAY_MODE")
+	}
+
+	if !config.KafkaTLS && (config.KafkaTLSCAFile != "" || config.KafkaTLSCertFile != "" ||
+		config.KafkaTLSKeyFile != "" || config.KafkaTLSInsecure) {
+		return errors.New("OTR_KAFKA_TLS_* options are set, but OTR_KAFKA_TLS is not enabled")
+	}
+
+	if (config.KafkaTLSCertFile == "") != (config.KafkaTLSKeyFile == "") {
+		return errors.New("OTR_KAFKA_TLS_CERT_FILE and OTR_KAFKA_TLS_KEY_FILE must be set together")
+	}
+
+	switch config.KafkaSASLMechanism {
+	case "", "plain", "scram-sha-256", "scram-sha-512":
+	default:
+		return fmt.Errorf("Invalid OTR_KAFKA_SASL_MECHANISM %q; must be empty, \"plain\", \"scram-sha-256\", or \"scram-sha-512\"", config.KafkaSASLMechanism)
+	}
+
+	if config.KafkaSASLMechanism != "" && config.KafkaSASLUsername == "" {
+		return errors.New("OTR_KAFKA_SASL_USERNAME is required with OTR_KAFKA_SASL_MECHANISM")
+	}
+
+	if config.KafkaBatchSize < 1 {
+		return errors.New("OTR_KAFKA_BATCH_SIZE must be at least 1")
+	}
+
+	if config.WebhookBatchSize < 1 || config.WebhookConcurrency < 1 || config.WebhookMaxRetries < 1 {
+		return errors.New("OTR_WEBHOOK_BATCH_SIZE, OTR_WEBHOOK_CONCURRENCY, and OTR_WEBHOOK_MAX_RETRIES must be at least 1")
+	}
+
+	if config.Streams && config.RelayMode {
+		return errors.New("OTR_STREAMS can't be combined with OTR_RELAY_MODE")
+	}
+
+	if config.StreamsMaxLen < 0 {
+		return errors.New("OTR_STREAMS_MAX_LEN must not be negative")
+	}
+
+	for name, mapping := range map[string]map[string]string{
+		"OTR_COLLECTION_NAMESPACES": config.CollectionNamespaces,
+		"OTR_COLLECTION_CHANNELS":   config.CollectionChannels,
+	} {
+		for collection, channels := range mapping {
+			if db, collectionName := splitCollection(collection); db == "" || collectionName == "" {
+				return fmt.Errorf("Invalid collection %q in %s; must be <db-name>.<collection-name>", collection, name)
+			}
+
+			for _, channel := range strings.Split(channels, "|") {
+				if channel == "" {
+					return fmt.Errorf("Empty channel for collection %q in %s", collection, name)
+				}
+			}
+		}
+	}
+
+	for name, patterns := range map[string][]string{
+		"OTR_INCLUDE_NAMESPACES":       config.IncludeNamespaces,
+		"OTR_EXCLUDE_NAMESPACES":       config.ExcludeNamespaces,
+		"OTR_FULL_DOCUMENT_NAMESPACES": config.FullDocumentNamespaces,
+		"OTR_SNAPSHOT_NAMESPACES":      config.SnapshotNamespaces,
+	} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid pattern %q in %s: %s", pattern, name, err)
+			}
+		}
+	}
+
+	for name, text := range map[string]string{
+		"OTR_COLLECTION_CHANNEL_TEMPLATE": config.CollectionChannelTemplate,
+		"OTR_SPECIFIC_CHANNEL_TEMPLATE":   config.SpecificChannelTemplate,
+	} {
+		if _, err := template.New(name).Parse(text); err != nil {
+			return fmt.Errorf("Invalid template in %s: %s", name, err)
+		}
+	}
+
+	if config.SyntheticChannelPrefix != "" {
+		for _, channelPrefix := range []string{config.ChannelPrefix, config.TeeChannelPrefix} {
+			if strings.HasPrefix(channelPrefix, config.SyntheticChannelPrefix) {
+				// We'd relay our own relayed messages
+				return fmt.Errorf("OTR_SYNTHETIC_CHANNEL_PREFIX must not be a prefix of the channel prefix %q", channelPrefix)
+			}
+		}
+	}
+
+	switch config.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("Invalid OTR_LOG_LEVEL %q: must be debug, info, warn, or error", config.LogLevel)
+	}
+
+	switch config.LogFormat {
+	case "", "json", "console":
+	default:
+		return fmt.Errorf("Invalid OTR_LOG_FORMAT %q: must be json or console", config.LogFormat)
+	}
+
+	if config.LogSamplingInitial < -1 {
+		return fmt.Errorf("Invalid OTR_LOG_SAMPLING_INITIAL %d: must be at least -1", config.LogSamplingInitial)
+	}
+
+	if config.LogSamplingThereafter < 1 {
+		return fmt.Errorf("Invalid OTR_LOG_SAMPLING_THEREAFTER %d: must be at least 1", config.LogSamplingThereafter)
+	}
+
+	if config.LogThrottle < 0 {
+		return fmt.Errorf("Invalid OTR_LOG_THROTTLE %s: must not be negative", config.LogThrottle)
+	}
+
+	if config.TraceSampleRate <= 0 || config.TraceSampleRate > 1 {
+		return fmt.Errorf("Invalid OTR_TRACE_SAMPLE_RATE %v: must be greater than 0 and at most 1", config.TraceSampleRate)
+	}
+
+	for name, rate := range map[string]float64{
+		"OTR_CHAOS_PUBLISH_FAILURE_RATE": config.ChaosPublishFailureRate,
+		"OTR_CHAOS_CURSOR_ERROR_RATE":    config.ChaosCursorErrorRate,
+		"OTR_CHAOS_LATENCY_RATE":         config.ChaosLatencyRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+
+		if rate > 0 && !config.ChaosMode {
+			return fmt.Errorf("%s is set, but OTR_CHAOS_MODE is not enabled", name)
+		}
+	}
+
 	globalConfig = &config
 	return nil
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -14,24 +15,185 @@ var envTests = map[string]struct {
 }{
 	"Full env": {
 		env: map[string]string{
-			"OTR_REDIS_URL":                "redis://something",
-			"OTR_MONGO_URL":                "mongodb://something",
-			"OTR_HTTP_SERVER_ADDR":         "localhost:1234",
-			"OTR_BUFFER_SIZE":              "10",
-			"OTR_TIMESTAMP_FLUSH_INTERVAL": "10m",
-			"OTR_MAX_CATCH_UP":             "0",
-			"OTR_REDIS_DEDUPE_EXPIRATION":  "12s",
-			"OTR_REDIS_METADATA_PREFIX":    "someprefix.",
+			"OTR_REDIS_URL":                      "redis://something",
+			"OTR_REDIS_SENTINEL_MASTER":          "mymaster",
+			"OTR_REDIS_TLS":                      "true",
+			"OTR_REDIS_TLS_CA_FILE":              "/etc/redis/ca.pem",
+			"OTR_REDIS_TLS_CERT_FILE":            "/etc/redis/cert.pem",
+			"OTR_REDIS_TLS_KEY_FILE":             "/etc/redis/key.pem",
+			"OTR_REDIS_TLS_INSECURE_SKIP_VERIFY": "true",
+			"OTR_REDIS_TLS_SERVER_NAME":          "redis.internal",
+			"OTR_MONGO_URL":                      "mongodb://something",
+			"OTR_MONGO_READ_PREFERENCE":          "secondary",
+			"OTR_MONGO_DIRECT":                   "true",
+			"OTR_MONGO_CONNECT_TIMEOUT":          "5s",
+			"OTR_MONGO_SOCKET_TIMEOUT":           "1m",
+			"OTR_MONGO_POOL_SIZE":                "20",
+			"OTR_MONGO_APP_NAME":                 "otr-test",
+			"OTR_CHANGE_STREAMS":                 "true",
+			"OTR_HTTP_SERVER_ADDR":               "localhost:1234",
+			"OTR_READY_MAX_LAG":                  "5m",
+			"OTR_ADMIN_TOKEN":                    "secret",
+			"OTR_DEBUG_ENDPOINTS":                "true",
+			"OTR_LAG_METRIC_INTERVAL":            "30s",
+			"OTR_BUFFER_SIZE":                    "10",
+			"OTR_TIMESTAMP_FLUSH_INTERVAL":       "10m",
+			"OTR_MAX_CATCH_UP":                   "0",
+			"OTR_REDIS_DEDUPE_EXPIRATION":        "12s",
+			"OTR_REDIS_METADATA_PREFIX":          "someprefix.",
+			"OTR_DEDUPE_BY_CONTENT":              "true",
+			"OTR_PUBLISH_MAX_RETRIES":            "120",
+			"OTR_RECONNECT_BACKOFF_INITIAL":      "500ms",
+			"OTR_RECONNECT_BACKOFF_MAX":          "2m",
+			"OTR_RECONNECT_BACKOFF_JITTER":       "0.25",
+			"OTR_TAIL_MAX_RESTARTS":              "100",
+			"OTR_LEADER_ELECTION":                "kubernetes",
+			"OTR_LEADER_ELECTION_LEASE_NAME":     "somelease",
+			"OTR_RELAY_MODE":                     "true",
+			"OTR_RELAY_BATCH_WINDOW":             "1s",
+			"OTR_STREAMS_PER_DOCUMENT":           "true",
+			"OTR_TEE_CHANNEL_PREFIX":             "new.",
+			"OTR_COLLECTION_NAMESPACES":          "db.tasks:ns1|ns2",
+			"OTR_COLLECTION_CHANNELS":            "db.tasks:custom,db.other.coll:other",
+			"OTR_FULL_DOCUMENT":                  "true",
+			"OTR_DOCUMENT_VERSION":               "true",
+			"OTR_GLOBAL_CHANNEL":                 "firehose",
+			"OTR_INCLUDE_NAMESPACES":             "app.*,*.users",
+			"OTR_EXCLUDE_NAMESPACES":             "app.events",
+			"OTR_SYNTHETIC_CHANNEL_PREFIX":       "synthetic::",
+			"OTR_SHUTDOWN_TIMEOUT":               "1m",
+			"OTR_LOG_LEVEL":                      "warn",
+			"OTR_LOG_FORMAT":                     "console",
+			"OTR_LOG_SAMPLING_INITIAL":           "-1",
+			"OTR_LOG_SAMPLING_THEREAFTER":        "10",
+			"OTR_LOG_THROTTLE":                   "1m",
+			"OTR_OTLP_ENDPOINT":                  "http://localhost:4318",
+			"OTR_TRACE_SAMPLE_RATE":              "0.5",
+			"OTR_CHAOS_MODE":                     "true",
+			"OTR_CHAOS_LATENCY_RATE":             "0.5",
+			"OTR_DEAD_LETTER_CHANNEL":            "deadletters",
+			"OTR_COLLECTION_EVENTS":              "true",
+			"OTR_RENAME_REMOVES":                 "5000",
+			"OTR_PUBLISH_MIGRATIONS":             "true",
+			"OTR_FIELD_PATHS":                    "both",
+			"OTR_EVENT_NAMES":                    "words",
+			"OTR_PROTOCOL_VERSION":               "2",
+			"OTR_PAYLOAD_FORMAT":                 "cloudevents",
+			"OTR_SECONDARY_REDIS_ENCODING":       "protobuf",
+			"OTR_SECONDARY_REDIS_COMPRESSION":    "gzip",
+			"OTR_COMPRESSION_THRESHOLD":          "1024",
+			"OTR_MAX_MESSAGE_SIZE":               "1048576",
+			"OTR_OVERSIZE_ACTION":                "refetch",
+			"OTR_MESSAGE_NAMESPACE":              "true",
+			"OTR_MESSAGE_TIMESTAMP":              "true",
+			"OTR_MESSAGE_WALL_TIME":              "true",
+			"OTR_MESSAGE_PUBLISHER_VERSION":      "true",
+			"OTR_DATA_GAP_CHANNEL":               "datagaps",
+			"OTR_DATA_GAP_RESTART":               "newest",
+			"OTR_OPLOG_WINDOW_METRIC_INTERVAL":   "5m",
+			"OTR_MIN_OPLOG_WINDOW":               "24h",
 		},
 		expectedConfig: &oplogtoredisConfiguration{
-			RedisURL:               "redis://something",
-			MongoURL:               "mongodb://something",
-			HTTPServerAddr:         "localhost:1234",
-			BufferSize:             10,
-			TimestampFlushInterval: 10 * time.Minute,
-			MaxCatchUp:             0,
-			RedisDedupeExpiration:  12 * time.Second,
-			RedisMetadataPrefix:    "someprefix.",
+			RedisURL:                    "redis://something",
+			RedisSentinelMaster:         "mymaster",
+			RedisTLS:                    true,
+			RedisTLSCAFile:              "/etc/redis/ca.pem",
+			RedisTLSCertFile:            "/etc/redis/cert.pem",
+			RedisTLSKeyFile:             "/etc/redis/key.pem",
+			RedisTLSInsecure:            true,
+			RedisTLSServerName:          "redis.internal",
+			MongoURL:                    "mongodb://something",
+			ChangeStreams:               true,
+			HTTPServerAddr:              "localhost:1234",
+			ReadyMaxLag:                 5 * time.Minute,
+			AdminToken:                  "secret",
+			DebugEndpoints:              true,
+			LagMetricInterval:           30 * time.Second,
+			BufferSize:                  10,
+			TimestampFlushInterval:      10 * time.Minute,
+			MaxCatchUp:                  0,
+			RedisDedupeExpiration:       12 * time.Second,
+			RedisMetadataPrefix:         "someprefix.",
+			DedupeByContent:             true,
+			PublishMaxRetries:           120,
+			LeaderElection:              "kubernetes",
+			LeaderElectionLeaseName:     "somelease",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayMode:                   true,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            time.Second,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			StreamsPerDocument:          true,
+			TeeChannelPrefix:            "new.",
+			CollectionNamespaces:        map[string]string{"db.tasks": "ns1|ns2"},
+			CollectionChannels:          map[string]string{"db.tasks": "custom", "db.other.coll": "other"},
+			FullDocument:                true,
+			DocumentVersion:             true,
+			GlobalChannel:               "firehose",
+			IncludeNamespaces:           []string{"app.*", "*.users"},
+			ExcludeNamespaces:           []string{"app.events"},
+			SyntheticChannelPrefix:      "synthetic::",
+			ChaosMode:                   true,
+			ChaosLatencyRate:            0.5,
+			DeadLetterChannel:           "deadletters",
+			CollectionEvents:            true,
+			RenameRemoves:               5000,
+			PublishMigrations:           true,
+			MessageNamespace:            true,
+			MessageTimestamp:            true,
+			MessageWallTime:             true,
+			MessagePublisherVersion:     true,
+			DataGapChannel:              "datagaps",
+			ShutdownTimeout:             time.Minute,
+			LogLevel:                    "warn",
+			LogFormat:                   "console",
+			LogSamplingInitial:          -1,
+			LogSamplingThereafter:       10,
+			LogThrottle:                 time.Minute,
+			OtlpEndpoint:                "http://localhost:4318",
+			TraceSampleRate:             0.5,
+			MongoReadPreference:         "secondary",
+			MongoDirect:                 true,
+			MongoConnectTimeout:         5 * time.Second,
+			MongoSocketTimeout:          time.Minute,
+			MongoPoolSize:               20,
+			MongoAppName:                "otr-test",
+			ReconnectBackoffInitial:     500 * time.Millisecond,
+			ReconnectBackoffMax:         2 * time.Minute,
+			ReconnectBackoffJitter:      0.25,
+			TailMaxRestarts:             100,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "newest",
+			FieldPaths:                  "both",
+			EventNames:                  "words",
+			ProtocolVersion:             2,
+			PayloadFormat:               "cloudevents",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "protobuf",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "gzip",
+			CompressionThreshold:        1024,
+			MaxMessageSize:              1048576,
+			OversizeAction:              "refetch",
+			OplogWindowMetricInterval:   5 * time.Minute,
+			MinOplogWindow:              24 * time.Hour,
+			SnapshotRate:                1000,
 		},
 	},
 	"Minimal env": {
@@ -40,22 +202,2119 @@ var envTests = map[string]struct {
 			"OTR_MONGO_URL": "mongodb://xxx",
 		},
 		expectedConfig: &oplogtoredisConfiguration{
-			RedisURL:               "redis://yyy",
-			MongoURL:               "mongodb://xxx",
-			HTTPServerAddr:         "0.0.0.0:9000",
-			BufferSize:             10000,
-			TimestampFlushInterval: time.Second,
-			MaxCatchUp:             time.Minute,
-			RedisDedupeExpiration:  2 * time.Minute,
-			RedisMetadataPrefix:    "oplogtoredis::",
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
 		},
 	},
+	"Streams": {
+		env: map[string]string{
+			"OTR_REDIS_URL":       "redis://yyy",
+			"OTR_MONGO_URL":       "mongodb://xxx",
+			"OTR_STREAMS":         "true",
+			"OTR_STREAMS_MAX_LEN": "0",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			Streams:                     true,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Secondary Redis": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_SECONDARY_REDIS_URL": "redis://zzz",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			SecondaryRedisURL:           "redis://zzz",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Secondary Redis with relay mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_SECONDARY_REDIS_URL": "redis://zzz",
+			"OTR_RELAY_MODE":          "true",
+		},
+		expectError: true,
+	},
+	"Webhook": {
+		env: map[string]string{
+			"OTR_REDIS_URL":            "redis://yyy",
+			"OTR_MONGO_URL":            "mongodb://xxx",
+			"OTR_WEBHOOK_URL":          "https://example.com/hook",
+			"OTR_WEBHOOK_SECRET":       "s3cret",
+			"OTR_WEBHOOK_BATCH_SIZE":   "10",
+			"OTR_WEBHOOK_BATCH_WINDOW": "5s",
+			"OTR_WEBHOOK_CONCURRENCY":  "1",
+			"OTR_WEBHOOK_MAX_RETRIES":  "3",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookURL:                  "https://example.com/hook",
+			WebhookSecret:               "s3cret",
+			WebhookBatchSize:            10,
+			WebhookBatchWindow:          5 * time.Second,
+			WebhookConcurrency:          1,
+			WebhookMaxRetries:           3,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Kafka": {
+		env: map[string]string{
+			"OTR_REDIS_URL":          "redis://yyy",
+			"OTR_MONGO_URL":          "mongodb://xxx",
+			"OTR_KAFKA_BROKERS":      "kafka1:9092,kafka2:9092",
+			"OTR_KAFKA_TOPIC_PREFIX": "otr.",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBrokers:                []string{"kafka1:9092", "kafka2:9092"},
+			KafkaTopicPrefix:            "otr.",
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Kafka with TLS and SASL": {
+		env: map[string]string{
+			"OTR_REDIS_URL":            "redis://yyy",
+			"OTR_MONGO_URL":            "mongodb://xxx",
+			"OTR_KAFKA_BROKERS":        "kafka1:9092,kafka2:9092",
+			"OTR_KAFKA_TOPIC_PREFIX":   "otr.",
+			"OTR_KAFKA_BATCH_SIZE":     "500",
+			"OTR_KAFKA_BATCH_TIMEOUT":  "100ms",
+			"OTR_KAFKA_TLS":            "true",
+			"OTR_KAFKA_TLS_CA_FILE":    "/etc/kafka/ca.pem",
+			"OTR_KAFKA_SASL_MECHANISM": "scram-sha-512",
+			"OTR_KAFKA_SASL_USERNAME":  "otr",
+			"OTR_KAFKA_SASL_PASSWORD":  "secret",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBrokers:                []string{"kafka1:9092", "kafka2:9092"},
+			KafkaTopicPrefix:            "otr.",
+			KafkaBatchSize:              500,
+			KafkaBatchTimeout:           100 * time.Millisecond,
+			KafkaTLS:                    true,
+			KafkaTLSCAFile:              "/etc/kafka/ca.pem",
+			KafkaSASLMechanism:          "scram-sha-512",
+			KafkaSASLUsername:           "otr",
+			KafkaSASLPassword:           "secret",
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Kafka TLS options without TLS": {
+		env: map[string]string{
+			"OTR_REDIS_URL":         "redis://yyy",
+			"OTR_MONGO_URL":         "mongodb://xxx",
+			"OTR_KAFKA_BROKERS":     "kafka:9092",
+			"OTR_KAFKA_TLS_CA_FILE": "/etc/kafka/ca.pem",
+		},
+		expectError: true,
+	},
+	"Invalid Kafka SASL mechanism": {
+		env: map[string]string{
+			"OTR_REDIS_URL":            "redis://yyy",
+			"OTR_MONGO_URL":            "mongodb://xxx",
+			"OTR_KAFKA_BROKERS":        "kafka:9092",
+			"OTR_KAFKA_SASL_MECHANISM": "gssapi",
+			"OTR_KAFKA_SASL_USERNAME":  "otr",
+		},
+		expectError: true,
+	},
+	"Kafka SASL without a username": {
+		env: map[string]string{
+			"OTR_REDIS_URL":            "redis://yyy",
+			"OTR_MONGO_URL":            "mongodb://xxx",
+			"OTR_KAFKA_BROKERS":        "kafka:9092",
+			"OTR_KAFKA_SASL_MECHANISM": "plain",
+		},
+		expectError: true,
+	},
+	"Kafka with relay mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":     "redis://yyy",
+			"OTR_MONGO_URL":     "mongodb://xxx",
+			"OTR_KAFKA_BROKERS": "kafka:9092",
+			"OTR_RELAY_MODE":    "true",
+		},
+		expectError: true,
+	},
+	"Webhook with relay mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":   "redis://yyy",
+			"OTR_MONGO_URL":   "mongodb://xxx",
+			"OTR_WEBHOOK_URL": "https://example.com/hook",
+			"OTR_RELAY_MODE":  "true",
+		},
+		expectError: true,
+	},
+	"Invalid webhook concurrency": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_WEBHOOK_CONCURRENCY": "0",
+		},
+		expectError: true,
+	},
+	"Redis TLS options without TLS": {
+		env: map[string]string{
+			"OTR_REDIS_URL":         "redis://yyy",
+			"OTR_MONGO_URL":         "mongodb://xxx",
+			"OTR_REDIS_TLS_CA_FILE": "/etc/redis/ca.pem",
+		},
+		expectError: true,
+	},
+	"Redis TLS cert without key": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_REDIS_TLS":           "true",
+			"OTR_REDIS_TLS_CERT_FILE": "/etc/redis/cert.pem",
+		},
+		expectError: true,
+	},
+	"Changed values": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_CHANGED_VALUES": "true",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ChangedValues:               true,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Changed values with full document": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_CHANGED_VALUES": "true",
+			"OTR_FULL_DOCUMENT":  "true",
+		},
+		expectError: true,
+	},
+	"Full document for some collections": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_FULL_DOCUMENT":             "true",
+			"OTR_FULL_DOCUMENT_NAMESPACES":  "app.*,*.users",
+			"OTR_FULL_DOCUMENT_PROJECTIONS": "app.tasks:title|status,db.users:name",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			FullDocument:                true,
+			FullDocumentNamespaces:      []string{"app.*", "*.users"},
+			FullDocumentProjections:     map[string]string{"app.tasks": "title|status", "db.users": "name"},
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Full document namespaces without full document": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                "redis://yyy",
+			"OTR_MONGO_URL":                "mongodb://xxx",
+			"OTR_FULL_DOCUMENT_NAMESPACES": "app.*",
+		},
+		expectError: true,
+	},
+	"Invalid full document projection": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_FULL_DOCUMENT":             "true",
+			"OTR_FULL_DOCUMENT_PROJECTIONS": "app.tasks:owner.name",
+		},
+		expectError: true,
+	},
+	"Channel templates": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                   "redis://yyy",
+			"OTR_MONGO_URL":                   "mongodb://xxx",
+			"OTR_COLLECTION_CHANNEL_TEMPLATE": "{{.Prefix}}{{.Database}}/{{.Collection}}",
+			"OTR_SPECIFIC_CHANNEL_TEMPLATE":   "{{.Prefix}}{{.Database}}/{{.Collection}}/{{.DocID}}",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			CollectionChannelTemplate:   "{{.Prefix}}{{.Database}}/{{.Collection}}",
+			SpecificChannelTemplate:     "{{.Prefix}}{{.Database}}/{{.Collection}}/{{.DocID}}",
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Invalid channel template": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_SPECIFIC_CHANNEL_TEMPLATE": "{{.Database}",
+		},
+		expectError: true,
+	},
+	"Routing fields": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_ROUTING_FIELDS": "app.tasks:tenantId|meta.region",
+			"OTR_ROUTING_LOOKUP": "true",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			RoutingFields:               map[string]string{"app.tasks": "tenantId|meta.region"},
+			RoutingLookup:               true,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Invalid routing field": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_ROUTING_FIELDS": "app.tasks:tenantId|",
+		},
+		expectError: true,
+	},
+	"Routing lookup without routing fields": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_ROUTING_LOOKUP": "true",
+		},
+		expectError: true,
+	},
+	"Publish batching": {
+		env: map[string]string{
+			"OTR_REDIS_URL":            "redis://yyy",
+			"OTR_MONGO_URL":            "mongodb://xxx",
+			"OTR_PUBLISH_BATCH_SIZE":   "100",
+			"OTR_PUBLISH_BATCH_WINDOW": "2ms",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            100,
+			PublishBatchWindow:          2 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Publish batching in relay mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":          "redis://yyy",
+			"OTR_MONGO_URL":          "mongodb://xxx",
+			"OTR_PUBLISH_BATCH_SIZE": "100",
+			"OTR_RELAY_MODE":         "true",
+		},
+		expectError: true,
+	},
+	"Publish batching with streams": {
+		env: map[string]string{
+			"OTR_REDIS_URL":          "redis://yyy",
+			"OTR_MONGO_URL":          "mongodb://xxx",
+			"OTR_PUBLISH_BATCH_SIZE": "100",
+			"OTR_STREAMS":            "true",
+		},
+		expectError: true,
+	},
+	"Invalid publish batch size": {
+		env: map[string]string{
+			"OTR_REDIS_URL":          "redis://yyy",
+			"OTR_MONGO_URL":          "mongodb://xxx",
+			"OTR_PUBLISH_BATCH_SIZE": "0",
+		},
+		expectError: true,
+	},
+	"Publish workers": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_PUBLISH_WORKERS":           "8",
+			"OTR_PUBLISH_WORKER_QUEUE_SIZE": "50",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              8,
+			PublishWorkerQueueSize:      50,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Publish workers with batching": {
+		env: map[string]string{
+			"OTR_REDIS_URL":          "redis://yyy",
+			"OTR_MONGO_URL":          "mongodb://xxx",
+			"OTR_PUBLISH_WORKERS":    "8",
+			"OTR_PUBLISH_BATCH_SIZE": "100",
+		},
+		expectError: true,
+	},
+	"Invalid publish workers": {
+		env: map[string]string{
+			"OTR_REDIS_URL":       "redis://yyy",
+			"OTR_MONGO_URL":       "mongodb://xxx",
+			"OTR_PUBLISH_WORKERS": "0",
+		},
+		expectError: true,
+	},
+	"Buffer spill": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_BUFFER_OVERFLOW":  "spill",
+			"OTR_BUFFER_SPILL_DIR": "/var/spill",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "spill",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			BufferSpillDir:              "/var/spill",
+			SnapshotRate:                1000,
+		},
+	},
+	"Publish WAL file": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_PUBLISH_WAL_FILE": "/var/lib/oplogtoredis/wal",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			PublishWALFile:              "/var/lib/oplogtoredis/wal",
+			SnapshotRate:                1000,
+		},
+	},
+	"Publish WAL file with relay mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_PUBLISH_WAL_FILE": "/var/lib/oplogtoredis/wal",
+			"OTR_RELAY_MODE":       "true",
+		},
+		expectError: true,
+	},
+	"Checkpoint file": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_CHECKPOINT_STORE": "file",
+			"OTR_CHECKPOINT_FILE":  "/var/lib/oplogtoredis/checkpoint.json",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "file",
+			CheckpointFile:              "/var/lib/oplogtoredis/checkpoint.json",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Checkpoint file without a path": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_CHECKPOINT_STORE": "file",
+		},
+		expectError: true,
+	},
+	"Invalid checkpoint store": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_CHECKPOINT_STORE": "etcd",
+		},
+		expectError: true,
+	},
+	"Invalid checkpoint collection": {
+		env: map[string]string{
+			"OTR_REDIS_URL":             "redis://yyy",
+			"OTR_MONGO_URL":             "mongodb://xxx",
+			"OTR_CHECKPOINT_STORE":      "mongo",
+			"OTR_CHECKPOINT_COLLECTION": "checkpoints",
+		},
+		expectError: true,
+	},
+	"Checkpoint flush count": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_CHECKPOINT_FLUSH_STRATEGY": "count",
+			"OTR_CHECKPOINT_FLUSH_COUNT":    "50",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "count",
+			CheckpointFlushCount:        50,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Checkpoint flush count of zero": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_CHECKPOINT_FLUSH_STRATEGY": "count",
+			"OTR_CHECKPOINT_FLUSH_COUNT":    "0",
+		},
+		expectError: true,
+	},
+	"Invalid checkpoint flush strategy": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_CHECKPOINT_FLUSH_STRATEGY": "never",
+		},
+		expectError: true,
+	},
+	"Cluster name": {
+		env: map[string]string{
+			"OTR_REDIS_URL":    "redis://yyy",
+			"OTR_MONGO_URL":    "mongodb://xxx",
+			"OTR_CLUSTER_NAME": "eu-west",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			ClusterName:                 "eu-west",
+			SnapshotRate:                1000,
+		},
+	},
+	"Invalid cluster name": {
+		env: map[string]string{
+			"OTR_REDIS_URL":    "redis://yyy",
+			"OTR_MONGO_URL":    "mongodb://xxx",
+			"OTR_CLUSTER_NAME": "eu::west",
+		},
+		expectError: true,
+	},
+	"Invalid oplog window metric interval": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                    "redis://yyy",
+			"OTR_MONGO_URL":                    "mongodb://xxx",
+			"OTR_OPLOG_WINDOW_METRIC_INTERVAL": "0s",
+		},
+		expectError: true,
+	},
+	"Negative min oplog window": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_MIN_OPLOG_WINDOW": "-1h",
+		},
+		expectError: true,
+	},
+	"Invalid field paths": {
+		env: map[string]string{
+			"OTR_REDIS_URL":   "redis://yyy",
+			"OTR_MONGO_URL":   "mongodb://xxx",
+			"OTR_FIELD_PATHS": "top",
+		},
+		expectError: true,
+	},
+	"Invalid event names": {
+		env: map[string]string{
+			"OTR_REDIS_URL":   "redis://yyy",
+			"OTR_MONGO_URL":   "mongodb://xxx",
+			"OTR_EVENT_NAMES": "long",
+		},
+		expectError: true,
+	},
+	"Invalid protocol version": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_PROTOCOL_VERSION": "3",
+		},
+		expectError: true,
+	},
+	"Invalid payload format": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_PAYLOAD_FORMAT": "json",
+		},
+		expectError: true,
+	},
+	"Invalid Redis encoding": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_REDIS_ENCODING": "bson",
+		},
+		expectError: true,
+	},
+	"Invalid secondary Redis encoding": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                "redis://yyy",
+			"OTR_MONGO_URL":                "mongodb://xxx",
+			"OTR_SECONDARY_REDIS_ENCODING": "bson",
+		},
+		expectError: true,
+	},
+	"Redis encoding with relay mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_REDIS_ENCODING": "msgpack",
+			"OTR_RELAY_MODE":     "true",
+		},
+		expectError: true,
+	},
+	"Invalid Redis compression": {
+		env: map[string]string{
+			"OTR_REDIS_URL":         "redis://yyy",
+			"OTR_MONGO_URL":         "mongodb://xxx",
+			"OTR_REDIS_COMPRESSION": "zstd",
+		},
+		expectError: true,
+	},
+	"Redis compression with publish workers": {
+		env: map[string]string{
+			"OTR_REDIS_URL":         "redis://yyy",
+			"OTR_MONGO_URL":         "mongodb://xxx",
+			"OTR_REDIS_COMPRESSION": "gzip",
+			"OTR_PUBLISH_WORKERS":   "4",
+		},
+		expectError: true,
+	},
+	"Negative compression threshold": {
+		env: map[string]string{
+			"OTR_REDIS_URL":             "redis://yyy",
+			"OTR_MONGO_URL":             "mongodb://xxx",
+			"OTR_COMPRESSION_THRESHOLD": "-1",
+		},
+		expectError: true,
+	},
+	"Negative max message size": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_MAX_MESSAGE_SIZE": "-1",
+		},
+		expectError: true,
+	},
+	"Invalid oversize action": {
+		env: map[string]string{
+			"OTR_REDIS_URL":       "redis://yyy",
+			"OTR_MONGO_URL":       "mongodb://xxx",
+			"OTR_OVERSIZE_ACTION": "split",
+		},
+		expectError: true,
+	},
+	"Invalid log level": {
+		env: map[string]string{
+			"OTR_REDIS_URL": "redis://yyy",
+			"OTR_MONGO_URL": "mongodb://xxx",
+			"OTR_LOG_LEVEL": "loud",
+		},
+		expectError: true,
+	},
+	"Invalid log format": {
+		env: map[string]string{
+			"OTR_REDIS_URL":  "redis://yyy",
+			"OTR_MONGO_URL":  "mongodb://xxx",
+			"OTR_LOG_FORMAT": "xml",
+		},
+		expectError: true,
+	},
+	"Invalid log sampling": {
+		env: map[string]string{
+			"OTR_REDIS_URL":               "redis://yyy",
+			"OTR_MONGO_URL":               "mongodb://xxx",
+			"OTR_LOG_SAMPLING_THEREAFTER": "0",
+		},
+		expectError: true,
+	},
+	"Negative log throttle": {
+		env: map[string]string{
+			"OTR_REDIS_URL":    "redis://yyy",
+			"OTR_MONGO_URL":    "mongodb://xxx",
+			"OTR_LOG_THROTTLE": "-1s",
+		},
+		expectError: true,
+	},
+	"Invalid Mongo read preference": {
+		env: map[string]string{
+			"OTR_REDIS_URL":             "redis://yyy",
+			"OTR_MONGO_URL":             "mongodb://xxx",
+			"OTR_MONGO_READ_PREFERENCE": "hidden",
+		},
+		expectError: true,
+	},
+	"Direct Mongo connection with sharding": {
+		env: map[string]string{
+			"OTR_REDIS_URL":    "redis://yyy",
+			"OTR_MONGO_URL":    "mongodb://xxx",
+			"OTR_MONGO_DIRECT": "true",
+			"OTR_SHARDED":      "true",
+		},
+		expectError: true,
+	},
+	"Mongo socket timeout of 0": {
+		env: map[string]string{
+			"OTR_REDIS_URL":            "redis://yyy",
+			"OTR_MONGO_URL":            "mongodb://xxx",
+			"OTR_MONGO_SOCKET_TIMEOUT": "0s",
+		},
+		expectError: true,
+	},
+	"Negative Mongo pool size": {
+		env: map[string]string{
+			"OTR_REDIS_URL":       "redis://yyy",
+			"OTR_MONGO_URL":       "mongodb://xxx",
+			"OTR_MONGO_POOL_SIZE": "-1",
+		},
+		expectError: true,
+	},
+	"Zero reconnect backoff": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                 "redis://yyy",
+			"OTR_MONGO_URL":                 "mongodb://xxx",
+			"OTR_RECONNECT_BACKOFF_INITIAL": "0s",
+		},
+		expectError: true,
+	},
+	"Reconnect backoff max below initial": {
+		env: map[string]string{
+			"OTR_REDIS_URL":             "redis://yyy",
+			"OTR_MONGO_URL":             "mongodb://xxx",
+			"OTR_RECONNECT_BACKOFF_MAX": "500ms",
+		},
+		expectError: true,
+	},
+	"Reconnect backoff jitter above 1": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                "redis://yyy",
+			"OTR_MONGO_URL":                "mongodb://xxx",
+			"OTR_RECONNECT_BACKOFF_JITTER": "1.5",
+		},
+		expectError: true,
+	},
+	"Negative reconnect backoff jitter": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                "redis://yyy",
+			"OTR_MONGO_URL":                "mongodb://xxx",
+			"OTR_RECONNECT_BACKOFF_JITTER": "-0.1",
+		},
+		expectError: true,
+	},
+	"Negative rename removes": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_RENAME_REMOVES": "-1",
+		},
+		expectError: true,
+	},
+	"Negative tail max restarts": {
+		env: map[string]string{
+			"OTR_REDIS_URL":         "redis://yyy",
+			"OTR_MONGO_URL":         "mongodb://xxx",
+			"OTR_TAIL_MAX_RESTARTS": "-1",
+		},
+		expectError: true,
+	},
+	"Trace sample rate of 0": {
+		env: map[string]string{
+			"OTR_REDIS_URL":         "redis://yyy",
+			"OTR_MONGO_URL":         "mongodb://xxx",
+			"OTR_TRACE_SAMPLE_RATE": "0",
+		},
+		expectError: true,
+	},
+	"Trace sample rate over 1": {
+		env: map[string]string{
+			"OTR_REDIS_URL":         "redis://yyy",
+			"OTR_MONGO_URL":         "mongodb://xxx",
+			"OTR_TRACE_SAMPLE_RATE": "1.5",
+		},
+		expectError: true,
+	},
+	"Invalid data gap restart": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_DATA_GAP_RESTART": "checkpoint",
+		},
+		expectError: true,
+	},
+	"Invalid buffer overflow": {
+		env: map[string]string{
+			"OTR_REDIS_URL":       "redis://yyy",
+			"OTR_MONGO_URL":       "mongodb://xxx",
+			"OTR_BUFFER_OVERFLOW": "drop-newest",
+		},
+		expectError: true,
+	},
+	"Buffer spill dir without spilling": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_BUFFER_SPILL_DIR": "/var/spill",
+		},
+		expectError: true,
+	},
+	"Redaction": {
+		env: map[string]string{
+			"OTR_REDIS_URL":     "redis://yyy",
+			"OTR_MONGO_URL":     "mongodb://xxx",
+			"OTR_REDACT_FIELDS": "app.users:services.password|secret",
+			"OTR_HASH_FIELDS":   "app.users:email",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			RedactFields:                map[string]string{"app.users": "services.password|secret"},
+			HashFields:                  map[string]string{"app.users": "email"},
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Redacting the _id": {
+		env: map[string]string{
+			"OTR_REDIS_URL":     "redis://yyy",
+			"OTR_MONGO_URL":     "mongodb://xxx",
+			"OTR_REDACT_FIELDS": "app.users:_id",
+		},
+		expectError: true,
+	},
+	"Invalid hash field collection": {
+		env: map[string]string{
+			"OTR_REDIS_URL":   "redis://yyy",
+			"OTR_MONGO_URL":   "mongodb://xxx",
+			"OTR_HASH_FIELDS": "users:email",
+		},
+		expectError: true,
+	},
+	"Snapshot": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_SNAPSHOT_NAMESPACES": "app.*,*.users",
+			"OTR_SNAPSHOT_RATE":       "0",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			SnapshotNamespaces:          []string{"app.*", "*.users"},
+			SnapshotRate:                0,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+		},
+	},
+	"Snapshot with handoff": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_SNAPSHOT_NAMESPACES": "app.*",
+			"OTR_HANDOFF":             "true",
+		},
+		expectError: true,
+	},
+	"Invalid snapshot rate": {
+		env: map[string]string{
+			"OTR_REDIS_URL":     "redis://yyy",
+			"OTR_MONGO_URL":     "mongodb://xxx",
+			"OTR_SNAPSHOT_RATE": "-1",
+		},
+		expectError: true,
+	},
+	"Invalid lag metric interval": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_LAG_METRIC_INTERVAL": "0",
+		},
+		expectError: true,
+	},
+	"Invalid shutdown timeout": {
+		env: map[string]string{
+			"OTR_REDIS_URL":        "redis://yyy",
+			"OTR_MONGO_URL":        "mongodb://xxx",
+			"OTR_SHUTDOWN_TIMEOUT": "0",
+		},
+		expectError: true,
+	},
+	"Invalid publish max retries": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_PUBLISH_MAX_RETRIES": "0",
+		},
+		expectError: true,
+	},
+	"Streams with relay mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":  "redis://yyy",
+			"OTR_MONGO_URL":  "mongodb://xxx",
+			"OTR_STREAMS":    "true",
+			"OTR_RELAY_MODE": "true",
+		},
+		expectError: true,
+	},
 	"Missing redis URL": {
 		env: map[string]string{
 			"OTR_MONGO_URL": "mongodb://xxx",
 		},
 		expectError: true,
 	},
+	"Invalid leader election": {
+		env: map[string]string{
+			"OTR_REDIS_URL":       "redis://yyy",
+			"OTR_MONGO_URL":       "mongodb://xxx",
+			"OTR_LEADER_ELECTION": "zookeeper",
+		},
+		expectError: true,
+	},
+	"Redis leader election": {
+		env: map[string]string{
+			"OTR_REDIS_URL":       "redis://yyy",
+			"OTR_MONGO_URL":       "mongodb://xxx",
+			"OTR_LEADER_ELECTION": "redis",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElection:              "redis",
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Leader election renew deadline too long": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                      "redis://yyy",
+			"OTR_MONGO_URL":                      "mongodb://xxx",
+			"OTR_LEADER_ELECTION":                "kubernetes",
+			"OTR_LEADER_ELECTION_RENEW_DEADLINE": "20s",
+		},
+		expectError: true,
+	},
+	"Synthetic channel prefix matches channel prefix": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                "redis://yyy",
+			"OTR_MONGO_URL":                "mongodb://xxx",
+			"OTR_CHANNEL_PREFIX":           "synthetic::relayed.",
+			"OTR_SYNTHETIC_CHANNEL_PREFIX": "synthetic::",
+		},
+		expectError: true,
+	},
+	"Synthetic channel prefix with no channel prefix": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                "redis://yyy",
+			"OTR_MONGO_URL":                "mongodb://xxx",
+			"OTR_SYNTHETIC_CHANNEL_PREFIX": "synthetic::",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+			SyntheticChannelPrefix:      "synthetic::",
+		},
+	},
+	"Sharded": {
+		env: map[string]string{
+			"OTR_REDIS_URL": "redis://yyy",
+			"OTR_MONGO_URL": "mongodb://xxx",
+			"OTR_SHARDED":   "true",
+		},
+		expectedConfig: &oplogtoredisConfiguration{
+			RedisURL:                    "redis://yyy",
+			MongoURL:                    "mongodb://xxx",
+			Sharded:                     true,
+			HTTPServerAddr:              "0.0.0.0:9000",
+			ReadyMaxLag:                 time.Minute,
+			LagMetricInterval:           10 * time.Second,
+			BufferSize:                  10000,
+			TimestampFlushInterval:      time.Second,
+			MaxCatchUp:                  time.Minute,
+			RedisDedupeExpiration:       2 * time.Minute,
+			RedisMetadataPrefix:         "oplogtoredis::",
+			PublishMaxRetries:           30,
+			LeaderElectionLeaseName:     "oplogtoredis",
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			RelayBatchSize:              500,
+			RelayBatchWindow:            250 * time.Millisecond,
+			StreamsMaxLen:               10000,
+			WebhookBatchSize:            100,
+			WebhookBatchWindow:          time.Second,
+			WebhookConcurrency:          4,
+			WebhookMaxRetries:           10,
+			KafkaBatchSize:              100,
+			KafkaBatchTimeout:           time.Second,
+			ShutdownTimeout:             20 * time.Second,
+			LogSamplingThereafter:       100,
+			TraceSampleRate:             1,
+			MongoConnectTimeout:         10 * time.Second,
+			MongoSocketTimeout:          10 * time.Second,
+			MongoAppName:                "oplogtoredis",
+			ReconnectBackoffInitial:     time.Second,
+			ReconnectBackoffMax:         30 * time.Second,
+			ReconnectBackoffJitter:      0.5,
+			ChaosLatency:                time.Second,
+			PublishBatchSize:            1,
+			PublishBatchWindow:          5 * time.Millisecond,
+			PublishWorkers:              1,
+			PublishWorkerQueueSize:      1000,
+			BufferOverflow:              "block",
+			CheckpointStore:             "redis",
+			CheckpointCollection:        "oplogtoredis.checkpoints",
+			CheckpointFlushStrategy:     "interval",
+			CheckpointFlushCount:        1000,
+			DataGapRestart:              "oldest",
+			FieldPaths:                  "full",
+			EventNames:                  "meteor",
+			ProtocolVersion:             1,
+			PayloadFormat:               "redis-oplog",
+			RedisEncoding:               "json",
+			SecondaryRedisEncoding:      "json",
+			RedisCompression:            "none",
+			SecondaryRedisCompression:   "none",
+			CompressionThreshold:        65536,
+			OversizeAction:              "truncate",
+			OplogWindowMetricInterval:   60 * time.Second,
+			SnapshotRate:                1000,
+		},
+	},
+	"Sharded with change streams": {
+		env: map[string]string{
+			"OTR_REDIS_URL":      "redis://yyy",
+			"OTR_MONGO_URL":      "mongodb://xxx",
+			"OTR_SHARDED":        "true",
+			"OTR_CHANGE_STREAMS": "true",
+		},
+		expectError: true,
+	},
+	"Sharded with handoff": {
+		env: map[string]string{
+			"OTR_REDIS_URL": "redis://yyy",
+			"OTR_MONGO_URL": "mongodb://xxx",
+			"OTR_SHARDED":   "true",
+			"OTR_HANDOFF":   "true",
+		},
+		expectError: true,
+	},
+	"Chaos rate out of range": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                  "redis://yyy",
+			"OTR_MONGO_URL":                  "mongodb://xxx",
+			"OTR_CHAOS_MODE":                 "true",
+			"OTR_CHAOS_PUBLISH_FAILURE_RATE": "1.5",
+		},
+		expectError: true,
+	},
+	"Chaos rate without chaos mode": {
+		env: map[string]string{
+			"OTR_REDIS_URL":                  "redis://yyy",
+			"OTR_MONGO_URL":                  "mongodb://xxx",
+			"OTR_CHAOS_PUBLISH_FAILURE_RATE": "0.1",
+		},
+		expectError: true,
+	},
+	"Collection namespace without a database": {
+		env: map[string]string{
+			"OTR_REDIS_URL":             "redis://yyy",
+			"OTR_MONGO_URL":             "mongodb://xxx",
+			"OTR_COLLECTION_NAMESPACES": "tasks:ns1",
+		},
+		expectError: true,
+	},
+	"Empty collection channel": {
+		env: map[string]string{
+			"OTR_REDIS_URL":           "redis://yyy",
+			"OTR_MONGO_URL":           "mongodb://xxx",
+			"OTR_COLLECTION_CHANNELS": "db.tasks:custom|",
+		},
+		expectError: true,
+	},
+	"Invalid namespace pattern": {
+		env: map[string]string{
+			"OTR_REDIS_URL":          "redis://yyy",
+			"OTR_MONGO_URL":          "mongodb://xxx",
+			"OTR_EXCLUDE_NAMESPACES": "app.[events",
+		},
+		expectError: true,
+	},
 	"Missing mongo URL": {
 		env: map[string]string{
 			"OTR_REDIS_URL": "redis://yyy",
@@ -64,58 +2323,198 @@ var envTests = map[string]struct {
 	},
 }
 
-// nolint: gocyclo
-func TestParseEnv(t *testing.T) {
-	for name, envTest := range envTests {
-		t.Run(name, func(t *testing.T) {
-			// clear env
-			for _, envPair := range os.Environ() {
-				if strings.HasPrefix(envPair, "OTR_") {
-					// envPair is of the format "KEY=VALUE" so we split on "="
-					os.Unsetenv(strings.SplitN(envPair, "=", 2)[0])
-				}
-			}
+// nolint: gocyclo
+func TestParseEnv(t *testing.T) {
+	for name, envTest := range envTests {
+		t.Run(name, func(t *testing.T) {
+			// clear env
+			for _, envPair := range os.Environ() {
+				if strings.HasPrefix(envPair, "OTR_") {
+					// envPair is of the format "KEY=VALUE" so we split on "="
+					os.Unsetenv(strings.SplitN(envPair, "=", 2)[0])
+				}
+			}
+
+			// Set up env
+			for k, v := range envTest.env {
+				os.Setenv(k, v)
+			}
+
+			// Run parseEnv
+			err := ParseEnv()
+
+			// Check error expectations
+			if envTest.expectError && err == nil {
+				t.Fatalf(
+					"Expected a error but did not get one for env: %#v.\n    Parsed config was: %#v",
+					envTest.env, envTest.expectedConfig,
+				)
+			}
+
+			if !envTest.expectError && err != nil {
+				t.Fatalf(
+					"Recevied unexpected error while parsing env: %#v.\n    Error was: %s",
+					envTest.env, err,
+				)
+			}
+
+			// Check config expectations
+			if envTest.expectedConfig != nil {
+				checkConfigExpectation(t, envTest.expectedConfig)
+			}
+		})
+	}
+}
+
+func checkConfigExpectation(t *testing.T, expectedConfig *oplogtoredisConfiguration) {
+	if expectedConfig.MongoURL != MongoURL() {
+		t.Errorf("Incorrect Mongo URL. Got \"%s\", Expected \"%s\"",
+			expectedConfig.MongoURL, MongoURL())
+	}
+
+	if expectedConfig.ChangeStreams != ChangeStreams() {
+		t.Errorf("Incorrect ChangeStreams. Got %t, Expected %t",
+			expectedConfig.ChangeStreams, ChangeStreams())
+	}
+
+	if expectedConfig.Sharded != Sharded() {
+		t.Errorf("Incorrect Sharded. Got %t, Expected %t",
+			expectedConfig.Sharded, Sharded())
+	}
+
+	if expectedConfig.RedisURL != RedisURL() {
+		t.Errorf("Incorrect Redis URL. Got \"%s\", Expected \"%s\"",
+			expectedConfig.RedisURL, RedisURL())
+	}
+
+	if expectedConfig.RedisSentinelMaster != RedisSentinelMaster() {
+		t.Errorf("Incorrect RedisSentinelMaster. Got \"%s\", Expected \"%s\"",
+			expectedConfig.RedisSentinelMaster, RedisSentinelMaster())
+	}
+
+	if expectedConfig.RedisTLS != RedisTLS() {
+		t.Errorf("Incorrect RedisTLS. Got %t, Expected %t",
+			expectedConfig.RedisTLS, RedisTLS())
+	}
+
+	if expectedConfig.RedisTLSCAFile != RedisTLSCAFile() {
+		t.Errorf("Incorrect RedisTLSCAFile. Got \"%s\", Expected \"%s\"",
+			expectedConfig.RedisTLSCAFile, RedisTLSCAFile())
+	}
+
+	if expectedConfig.RedisTLSCertFile != RedisTLSCertFile() {
+		t.Errorf("Incorrect RedisTLSCertFile. Got \"%s\", Expected \"%s\"",
+			expectedConfig.RedisTLSCertFile, RedisTLSCertFile())
+	}
+
+	if expectedConfig.RedisTLSKeyFile != RedisTLSKeyFile() {
+		t.Errorf("Incorrect RedisTLSKeyFile. Got \"%s\", Expected \"%s\"",
+			expectedConfig.RedisTLSKeyFile, RedisTLSKeyFile())
+	}
+
+	if expectedConfig.RedisTLSInsecure != RedisTLSInsecureSkipVerify() {
+		t.Errorf("Incorrect RedisTLSInsecureSkipVerify. Got %t, Expected %t",
+			expectedConfig.RedisTLSInsecure, RedisTLSInsecureSkipVerify())
+	}
+
+	if expectedConfig.RedisTLSServerName != RedisTLSServerName() {
+		t.Errorf("Incorrect RedisTLSServerName. Got \"%s\", Expected \"%s\"",
+			expectedConfig.RedisTLSServerName, RedisTLSServerName())
+	}
+
+	if expectedConfig.WebhookURL != WebhookURL() {
+		t.Errorf("Incorrect WebhookURL. Got \"%s\", Expected \"%s\"",
+			expectedConfig.WebhookURL, WebhookURL())
+	}
+
+	if expectedConfig.WebhookSecret != WebhookSecret() {
+		t.Errorf("Incorrect WebhookSecret. Got \"%s\", Expected \"%s\"",
+			expectedConfig.WebhookSecret, WebhookSecret())
+	}
+
+	if expectedConfig.WebhookBatchSize != WebhookBatchSize() {
+		t.Errorf("Incorrect WebhookBatchSize. Got %d, Expected %d",
+			expectedConfig.WebhookBatchSize, WebhookBatchSize())
+	}
+
+	if expectedConfig.WebhookBatchWindow != WebhookBatchWindow() {
+		t.Errorf("Incorrect WebhookBatchWindow. Got %d, Expected %d",
+			expectedConfig.WebhookBatchWindow, WebhookBatchWindow())
+	}
+
+	if expectedConfig.WebhookConcurrency != WebhookConcurrency() {
+		t.Errorf("Incorrect WebhookConcurrency. Got %d, Expected %d",
+			expectedConfig.WebhookConcurrency, WebhookConcurrency())
+	}
+
+	if expectedConfig.WebhookMaxRetries != WebhookMaxRetries() {
+		t.Errorf("Incorrect WebhookMaxRetries. Got %d, Expected %d",
+			expectedConfig.WebhookMaxRetries, WebhookMaxRetries())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.KafkaBrokers, KafkaBrokers()) {
+		t.Errorf("Incorrect KafkaBrokers. Got %#v, Expected %#v",
+			expectedConfig.KafkaBrokers, KafkaBrokers())
+	}
+
+	if expectedConfig.KafkaTopicPrefix != KafkaTopicPrefix() {
+		t.Errorf("Incorrect KafkaTopicPrefix. Got %s, Expected %s",
+			expectedConfig.KafkaTopicPrefix, KafkaTopicPrefix())
+	}
+
+	if expectedConfig.KafkaBatchSize != KafkaBatchSize() {
+		t.Errorf("Incorrect KafkaBatchSize. Got %d, Expected %d",
+			expectedConfig.KafkaBatchSize, KafkaBatchSize())
+	}
+
+	if expectedConfig.KafkaBatchTimeout != KafkaBatchTimeout() {
+		t.Errorf("Incorrect KafkaBatchTimeout. Got %s, Expected %s",
+			expectedConfig.KafkaBatchTimeout, KafkaBatchTimeout())
+	}
+
+	if expectedConfig.KafkaTLS != KafkaTLS() {
+		t.Errorf("Incorrect KafkaTLS. Got %t, Expected %t",
+			expectedConfig.KafkaTLS, KafkaTLS())
+	}
+
+	if expectedConfig.KafkaTLSCAFile != KafkaTLSCAFile() {
+		t.Errorf("Incorrect KafkaTLSCAFile. Got %s, Expected %s",
+			expectedConfig.KafkaTLSCAFile, KafkaTLSCAFile())
+	}
 
-			// Set up env
-			for k, v := range envTest.env {
-				os.Setenv(k, v)
-			}
+	if expectedConfig.KafkaSASLMechanism != KafkaSASLMechanism() {
+		t.Errorf("Incorrect KafkaSASLMechanism. Got %s, Expected %s",
+			expectedConfig.KafkaSASLMechanism, KafkaSASLMechanism())
+	}
 
-			// Run parseEnv
-			err := ParseEnv()
+	if expectedConfig.KafkaSASLUsername != KafkaSASLUsername() {
+		t.Errorf("Incorrect KafkaSASLUsername. Got %s, Expected %s",
+			expectedConfig.KafkaSASLUsername, KafkaSASLUsername())
+	}
 
-			// Check error expectations
-			if envTest.expectError && err == nil {
-				t.Fatalf(
-					"Expected a error but did not get one for env: %#v.\n    Parsed config was: %#v",
-					envTest.env, envTest.expectedConfig,
-				)
-			}
+	if expectedConfig.KafkaSASLPassword != KafkaSASLPassword() {
+		t.Errorf("Incorrect KafkaSASLPassword. Got %s, Expected %s",
+			expectedConfig.KafkaSASLPassword, KafkaSASLPassword())
+	}
 
-			if !envTest.expectError && err != nil {
-				t.Fatalf(
-					"Recevied unexpected error while parsing env: %#v.\n    Error was: %s",
-					envTest.env, err,
-				)
-			}
+	if expectedConfig.DedupeByContent != DedupeByContent() {
+		t.Errorf("Incorrect DedupeByContent. Got %t, Expected %t",
+			expectedConfig.DedupeByContent, DedupeByContent())
+	}
 
-			// Check config expectations
-			if envTest.expectedConfig != nil {
-				checkConfigExpectation(t, envTest.expectedConfig)
-			}
-		})
+	if expectedConfig.ChangedValues != ChangedValues() {
+		t.Errorf("Incorrect ChangedValues. Got %t, Expected %t",
+			expectedConfig.ChangedValues, ChangedValues())
 	}
-}
 
-func checkConfigExpectation(t *testing.T, expectedConfig *oplogtoredisConfiguration) {
-	if expectedConfig.MongoURL != MongoURL() {
-		t.Errorf("Incorrect Mongo URL. Got \"%s\", Expected \"%s\"",
-			expectedConfig.MongoURL, MongoURL())
+	if expectedConfig.ShutdownTimeout != ShutdownTimeout() {
+		t.Errorf("Incorrect ShutdownTimeout. Got %d, Expected %d",
+			expectedConfig.ShutdownTimeout, ShutdownTimeout())
 	}
 
-	if expectedConfig.RedisURL != RedisURL() {
-		t.Errorf("Incorrect Redis URL. Got \"%s\", Expected \"%s\"",
-			expectedConfig.RedisURL, RedisURL())
+	if expectedConfig.SecondaryRedisURL != SecondaryRedisURL() {
+		t.Errorf("Incorrect SecondaryRedisURL. Got \"%s\", Expected \"%s\"",
+			expectedConfig.SecondaryRedisURL, SecondaryRedisURL())
 	}
 
 	if expectedConfig.HTTPServerAddr != HTTPServerAddr() {
@@ -123,6 +2522,26 @@ func checkConfigExpectation(t *testing.T, expectedConfig *oplogtoredisConfigurat
 			expectedConfig.HTTPServerAddr, HTTPServerAddr())
 	}
 
+	if expectedConfig.ReadyMaxLag != ReadyMaxLag() {
+		t.Errorf("Incorrect ReadyMaxLag. Got %d, Expected %d",
+			expectedConfig.ReadyMaxLag, ReadyMaxLag())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.SnapshotNamespaces, SnapshotNamespaces()) {
+		t.Errorf("Incorrect SnapshotNamespaces. Got %#v, Expected %#v",
+			expectedConfig.SnapshotNamespaces, SnapshotNamespaces())
+	}
+
+	if expectedConfig.SnapshotRate != SnapshotRate() {
+		t.Errorf("Incorrect SnapshotRate. Got %d, Expected %d",
+			expectedConfig.SnapshotRate, SnapshotRate())
+	}
+
+	if expectedConfig.LagMetricInterval != LagMetricInterval() {
+		t.Errorf("Incorrect LagMetricInterval. Got %d, Expected %d",
+			expectedConfig.LagMetricInterval, LagMetricInterval())
+	}
+
 	if expectedConfig.BufferSize != BufferSize() {
 		t.Errorf("Incorrect BufferSize. Got %d, Expected %d",
 			expectedConfig.BufferSize, BufferSize())
@@ -147,4 +2566,503 @@ func checkConfigExpectation(t *testing.T, expectedConfig *oplogtoredisConfigurat
 		t.Errorf("Incorrect RedisMetadataPrefix. Got \"%s\", Expected \"%s\"",
 			expectedConfig.RedisMetadataPrefix, RedisMetadataPrefix())
 	}
+
+	if expectedConfig.PublishMaxRetries != PublishMaxRetries() {
+		t.Errorf("Incorrect PublishMaxRetries. Got %d, Expected %d",
+			expectedConfig.PublishMaxRetries, PublishMaxRetries())
+	}
+
+	if expectedConfig.LeaderElection != LeaderElection() {
+		t.Errorf("Incorrect LeaderElection. Got \"%s\", Expected \"%s\"",
+			expectedConfig.LeaderElection, LeaderElection())
+	}
+
+	if expectedConfig.LeaderElectionLeaseName != LeaderElectionLeaseName() {
+		t.Errorf("Incorrect LeaderElectionLeaseName. Got \"%s\", Expected \"%s\"",
+			expectedConfig.LeaderElectionLeaseName, LeaderElectionLeaseName())
+	}
+
+	if expectedConfig.LeaderElectionLeaseDuration != LeaderElectionLeaseDuration() {
+		t.Errorf("Incorrect LeaderElectionLeaseDuration. Got %d, Expected %d",
+			expectedConfig.LeaderElectionLeaseDuration, LeaderElectionLeaseDuration())
+	}
+
+	if expectedConfig.LeaderElectionRenewDeadline != LeaderElectionRenewDeadline() {
+		t.Errorf("Incorrect LeaderElectionRenewDeadline. Got %d, Expected %d",
+			expectedConfig.LeaderElectionRenewDeadline, LeaderElectionRenewDeadline())
+	}
+
+	if expectedConfig.RelayMode != RelayMode() {
+		t.Errorf("Incorrect RelayMode. Got %t, Expected %t",
+			expectedConfig.RelayMode, RelayMode())
+	}
+
+	if expectedConfig.RelayBatchSize != RelayBatchSize() {
+		t.Errorf("Incorrect RelayBatchSize. Got %d, Expected %d",
+			expectedConfig.RelayBatchSize, RelayBatchSize())
+	}
+
+	if expectedConfig.RelayBatchWindow != RelayBatchWindow() {
+		t.Errorf("Incorrect RelayBatchWindow. Got %d, Expected %d",
+			expectedConfig.RelayBatchWindow, RelayBatchWindow())
+	}
+
+	if expectedConfig.PublishBatchSize != PublishBatchSize() {
+		t.Errorf("Incorrect PublishBatchSize. Got %d, Expected %d",
+			expectedConfig.PublishBatchSize, PublishBatchSize())
+	}
+
+	if expectedConfig.PublishBatchWindow != PublishBatchWindow() {
+		t.Errorf("Incorrect PublishBatchWindow. Got %d, Expected %d",
+			expectedConfig.PublishBatchWindow, PublishBatchWindow())
+	}
+
+	if expectedConfig.PublishWorkers != PublishWorkers() {
+		t.Errorf("Incorrect PublishWorkers. Got %d, Expected %d",
+			expectedConfig.PublishWorkers, PublishWorkers())
+	}
+
+	if expectedConfig.PublishWorkerQueueSize != PublishWorkerQueueSize() {
+		t.Errorf("Incorrect PublishWorkerQueueSize. Got %d, Expected %d",
+			expectedConfig.PublishWorkerQueueSize, PublishWorkerQueueSize())
+	}
+
+	if expectedConfig.BufferOverflow != BufferOverflow() {
+		t.Errorf("Incorrect BufferOverflow. Got %s, Expected %s",
+			expectedConfig.BufferOverflow, BufferOverflow())
+	}
+
+	if expectedConfig.BufferSpillDir != BufferSpillDir() {
+		t.Errorf("Incorrect BufferSpillDir. Got %s, Expected %s",
+			expectedConfig.BufferSpillDir, BufferSpillDir())
+	}
+
+	if expectedConfig.DeadLetterChannel != DeadLetterChannel() {
+		t.Errorf("Incorrect DeadLetterChannel. Got %s, Expected %s",
+			expectedConfig.DeadLetterChannel, DeadLetterChannel())
+	}
+
+	if expectedConfig.CollectionEvents != CollectionEvents() {
+		t.Errorf("Incorrect CollectionEvents. Got %t, Expected %t",
+			expectedConfig.CollectionEvents, CollectionEvents())
+	}
+
+	if expectedConfig.RenameRemoves != RenameRemoves() {
+		t.Errorf("Incorrect RenameRemoves. Got %d, Expected %d",
+			expectedConfig.RenameRemoves, RenameRemoves())
+	}
+
+	if expectedConfig.PublishMigrations != PublishMigrations() {
+		t.Errorf("Incorrect PublishMigrations. Got %t, Expected %t",
+			expectedConfig.PublishMigrations, PublishMigrations())
+	}
+
+	if expectedConfig.FieldPaths != FieldPaths() {
+		t.Errorf("Incorrect FieldPaths. Got %s, Expected %s",
+			expectedConfig.FieldPaths, FieldPaths())
+	}
+
+	if expectedConfig.EventNames != EventNames() {
+		t.Errorf("Incorrect EventNames. Got %s, Expected %s",
+			expectedConfig.EventNames, EventNames())
+	}
+
+	if expectedConfig.ProtocolVersion != ProtocolVersion() {
+		t.Errorf("Incorrect ProtocolVersion. Got %d, Expected %d",
+			expectedConfig.ProtocolVersion, ProtocolVersion())
+	}
+
+	if expectedConfig.PayloadFormat != PayloadFormat() {
+		t.Errorf("Incorrect PayloadFormat. Got %s, Expected %s",
+			expectedConfig.PayloadFormat, PayloadFormat())
+	}
+
+	if expectedConfig.MessageNamespace != MessageNamespace() {
+		t.Errorf("Incorrect MessageNamespace. Got %t, Expected %t",
+			expectedConfig.MessageNamespace, MessageNamespace())
+	}
+
+	if expectedConfig.MessageTimestamp != MessageTimestamp() {
+		t.Errorf("Incorrect MessageTimestamp. Got %t, Expected %t",
+			expectedConfig.MessageTimestamp, MessageTimestamp())
+	}
+
+	if expectedConfig.MessageWallTime != MessageWallTime() {
+		t.Errorf("Incorrect MessageWallTime. Got %t, Expected %t",
+			expectedConfig.MessageWallTime, MessageWallTime())
+	}
+
+	if expectedConfig.MessagePublisherVersion != MessagePublisherVersion() {
+		t.Errorf("Incorrect MessagePublisherVersion. Got %t, Expected %t",
+			expectedConfig.MessagePublisherVersion, MessagePublisherVersion())
+	}
+
+	if expectedConfig.RedisEncoding != RedisEncoding() {
+		t.Errorf("Incorrect RedisEncoding. Got %s, Expected %s",
+			RedisEncoding(), expectedConfig.RedisEncoding)
+	}
+
+	if expectedConfig.SecondaryRedisEncoding != SecondaryRedisEncoding() {
+		t.Errorf("Incorrect SecondaryRedisEncoding. Got %s, Expected %s",
+			SecondaryRedisEncoding(), expectedConfig.SecondaryRedisEncoding)
+	}
+
+	if expectedConfig.RedisCompression != RedisCompression() {
+		t.Errorf("Incorrect RedisCompression. Got %s, Expected %s",
+			RedisCompression(), expectedConfig.RedisCompression)
+	}
+
+	if expectedConfig.SecondaryRedisCompression != SecondaryRedisCompression() {
+		t.Errorf("Incorrect SecondaryRedisCompression. Got %s, Expected %s",
+			SecondaryRedisCompression(), expectedConfig.SecondaryRedisCompression)
+	}
+
+	if expectedConfig.CompressionThreshold != CompressionThreshold() {
+		t.Errorf("Incorrect CompressionThreshold. Got %d, Expected %d",
+			CompressionThreshold(), expectedConfig.CompressionThreshold)
+	}
+
+	if expectedConfig.MaxMessageSize != MaxMessageSize() {
+		t.Errorf("Incorrect MaxMessageSize. Got %d, Expected %d",
+			MaxMessageSize(), expectedConfig.MaxMessageSize)
+	}
+
+	if expectedConfig.OversizeAction != OversizeAction() {
+		t.Errorf("Incorrect OversizeAction. Got %s, Expected %s",
+			OversizeAction(), expectedConfig.OversizeAction)
+	}
+
+	if expectedConfig.AdminToken != AdminToken() {
+		t.Errorf("Incorrect AdminToken. Got %q, Expected %q",
+			AdminToken(), expectedConfig.AdminToken)
+	}
+
+	if expectedConfig.DebugEndpoints != DebugEndpoints() {
+		t.Errorf("Incorrect DebugEndpoints. Got %t, Expected %t",
+			DebugEndpoints(), expectedConfig.DebugEndpoints)
+	}
+
+	if expectedConfig.MongoReadPreference != MongoReadPreference() {
+		t.Errorf("Incorrect MongoReadPreference. Got %q, Expected %q",
+			MongoReadPreference(), expectedConfig.MongoReadPreference)
+	}
+
+	if expectedConfig.MongoDirect != MongoDirect() {
+		t.Errorf("Incorrect MongoDirect. Got %t, Expected %t",
+			MongoDirect(), expectedConfig.MongoDirect)
+	}
+
+	if expectedConfig.MongoConnectTimeout != MongoConnectTimeout() {
+		t.Errorf("Incorrect MongoConnectTimeout. Got %s, Expected %s",
+			MongoConnectTimeout(), expectedConfig.MongoConnectTimeout)
+	}
+
+	if expectedConfig.MongoSocketTimeout != MongoSocketTimeout() {
+		t.Errorf("Incorrect MongoSocketTimeout. Got %s, Expected %s",
+			MongoSocketTimeout(), expectedConfig.MongoSocketTimeout)
+	}
+
+	if expectedConfig.MongoPoolSize != MongoPoolSize() {
+		t.Errorf("Incorrect MongoPoolSize. Got %d, Expected %d",
+			MongoPoolSize(), expectedConfig.MongoPoolSize)
+	}
+
+	if expectedConfig.MongoAppName != MongoAppName() {
+		t.Errorf("Incorrect MongoAppName. Got %q, Expected %q",
+			MongoAppName(), expectedConfig.MongoAppName)
+	}
+
+	if expectedConfig.ReconnectBackoffInitial != ReconnectBackoffInitial() {
+		t.Errorf("Incorrect ReconnectBackoffInitial. Got %s, Expected %s",
+			ReconnectBackoffInitial(), expectedConfig.ReconnectBackoffInitial)
+	}
+
+	if expectedConfig.ReconnectBackoffMax != ReconnectBackoffMax() {
+		t.Errorf("Incorrect ReconnectBackoffMax. Got %s, Expected %s",
+			ReconnectBackoffMax(), expectedConfig.ReconnectBackoffMax)
+	}
+
+	if expectedConfig.ReconnectBackoffJitter != ReconnectBackoffJitter() {
+		t.Errorf("Incorrect ReconnectBackoffJitter. Got %v, Expected %v",
+			ReconnectBackoffJitter(), expectedConfig.ReconnectBackoffJitter)
+	}
+
+	if expectedConfig.TailMaxRestarts != TailMaxRestarts() {
+		t.Errorf("Incorrect TailMaxRestarts. Got %d, Expected %d",
+			TailMaxRestarts(), expectedConfig.TailMaxRestarts)
+	}
+
+	if expectedConfig.LogLevel != LogLevel() {
+		t.Errorf("Incorrect LogLevel. Got %q, Expected %q",
+			LogLevel(), expectedConfig.LogLevel)
+	}
+
+	if expectedConfig.LogFormat != LogFormat() {
+		t.Errorf("Incorrect LogFormat. Got %q, Expected %q",
+			LogFormat(), expectedConfig.LogFormat)
+	}
+
+	if expectedConfig.LogSamplingInitial != LogSamplingInitial() {
+		t.Errorf("Incorrect LogSamplingInitial. Got %d, Expected %d",
+			LogSamplingInitial(), expectedConfig.LogSamplingInitial)
+	}
+
+	if expectedConfig.LogSamplingThereafter != LogSamplingThereafter() {
+		t.Errorf("Incorrect LogSamplingThereafter. Got %d, Expected %d",
+			LogSamplingThereafter(), expectedConfig.LogSamplingThereafter)
+	}
+
+	if expectedConfig.LogThrottle != LogThrottle() {
+		t.Errorf("Incorrect LogThrottle. Got %s, Expected %s",
+			LogThrottle(), expectedConfig.LogThrottle)
+	}
+
+	if expectedConfig.OtlpEndpoint != OtlpEndpoint() {
+		t.Errorf("Incorrect OtlpEndpoint. Got %q, Expected %q",
+			OtlpEndpoint(), expectedConfig.OtlpEndpoint)
+	}
+
+	if expectedConfig.TraceSampleRate != TraceSampleRate() {
+		t.Errorf("Incorrect TraceSampleRate. Got %v, Expected %v",
+			TraceSampleRate(), expectedConfig.TraceSampleRate)
+	}
+
+	if expectedConfig.DataGapChannel != DataGapChannel() {
+		t.Errorf("Incorrect DataGapChannel. Got %s, Expected %s",
+			expectedConfig.DataGapChannel, DataGapChannel())
+	}
+
+	if expectedConfig.OplogWindowMetricInterval != OplogWindowMetricInterval() {
+		t.Errorf("Incorrect OplogWindowMetricInterval. Got %d, Expected %d",
+			expectedConfig.OplogWindowMetricInterval, OplogWindowMetricInterval())
+	}
+
+	if expectedConfig.MinOplogWindow != MinOplogWindow() {
+		t.Errorf("Incorrect MinOplogWindow. Got %d, Expected %d",
+			expectedConfig.MinOplogWindow, MinOplogWindow())
+	}
+
+	if expectedConfig.DataGapRestart != DataGapRestart() {
+		t.Errorf("Incorrect DataGapRestart. Got %s, Expected %s",
+			expectedConfig.DataGapRestart, DataGapRestart())
+	}
+
+	if expectedConfig.CheckpointStore != CheckpointStore() {
+		t.Errorf("Incorrect CheckpointStore. Got %s, Expected %s",
+			expectedConfig.CheckpointStore, CheckpointStore())
+	}
+
+	if expectedConfig.CheckpointFile != CheckpointFile() {
+		t.Errorf("Incorrect CheckpointFile. Got %s, Expected %s",
+			expectedConfig.CheckpointFile, CheckpointFile())
+	}
+
+	if expectedConfig.CheckpointCollection != CheckpointCollection() {
+		t.Errorf("Incorrect CheckpointCollection. Got %s, Expected %s",
+			expectedConfig.CheckpointCollection, CheckpointCollection())
+	}
+
+	if expectedConfig.CheckpointFlushStrategy != CheckpointFlushStrategy() {
+		t.Errorf("Incorrect CheckpointFlushStrategy. Got %s, Expected %s",
+			expectedConfig.CheckpointFlushStrategy, CheckpointFlushStrategy())
+	}
+
+	if expectedConfig.CheckpointFlushCount != CheckpointFlushCount() {
+		t.Errorf("Incorrect CheckpointFlushCount. Got %d, Expected %d",
+			expectedConfig.CheckpointFlushCount, CheckpointFlushCount())
+	}
+
+	if expectedConfig.ClusterName != ClusterName() {
+		t.Errorf("Incorrect ClusterName. Got %s, Expected %s",
+			expectedConfig.ClusterName, ClusterName())
+	}
+
+	if expectedConfig.PublishWALFile != PublishWALFile() {
+		t.Errorf("Incorrect PublishWALFile. Got %s, Expected %s",
+			expectedConfig.PublishWALFile, PublishWALFile())
+	}
+
+	if expectedConfig.ChannelPrefix != ChannelPrefix() {
+		t.Errorf("Incorrect ChannelPrefix. Got \"%s\", Expected \"%s\"",
+			expectedConfig.ChannelPrefix, ChannelPrefix())
+	}
+
+	if expectedConfig.TeeChannelPrefix != TeeChannelPrefix() {
+		t.Errorf("Incorrect TeeChannelPrefix. Got \"%s\", Expected \"%s\"",
+			expectedConfig.TeeChannelPrefix, TeeChannelPrefix())
+	}
+
+	if expectedConfig.CollectionChannelTemplate != CollectionChannelTemplate() {
+		t.Errorf("Incorrect CollectionChannelTemplate. Got \"%s\", Expected \"%s\"",
+			expectedConfig.CollectionChannelTemplate, CollectionChannelTemplate())
+	}
+
+	if expectedConfig.SpecificChannelTemplate != SpecificChannelTemplate() {
+		t.Errorf("Incorrect SpecificChannelTemplate. Got \"%s\", Expected \"%s\"",
+			expectedConfig.SpecificChannelTemplate, SpecificChannelTemplate())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.CollectionNamespaces, CollectionNamespaces()) {
+		t.Errorf("Incorrect CollectionNamespaces. Got %#v, Expected %#v",
+			expectedConfig.CollectionNamespaces, CollectionNamespaces())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.CollectionChannels, CollectionChannels()) {
+		t.Errorf("Incorrect CollectionChannels. Got %#v, Expected %#v",
+			expectedConfig.CollectionChannels, CollectionChannels())
+	}
+
+	if expectedConfig.FullDocument != FullDocument() {
+		t.Errorf("Incorrect FullDocument. Got %t, Expected %t",
+			expectedConfig.FullDocument, FullDocument())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.FullDocumentNamespaces, FullDocumentNamespaces()) {
+		t.Errorf("Incorrect FullDocumentNamespaces. Got %#v, Expected %#v",
+			expectedConfig.FullDocumentNamespaces, FullDocumentNamespaces())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.FullDocumentProjections, globalConfig.FullDocumentProjections) {
+		t.Errorf("Incorrect FullDocumentProjections. Got %#v, Expected %#v",
+			expectedConfig.FullDocumentProjections, globalConfig.FullDocumentProjections)
+	}
+
+	if !reflect.DeepEqual(expectedConfig.RoutingFields, globalConfig.RoutingFields) {
+		t.Errorf("Incorrect RoutingFields. Got %#v, Expected %#v",
+			expectedConfig.RoutingFields, globalConfig.RoutingFields)
+	}
+
+	if !reflect.DeepEqual(expectedConfig.RedactFields, globalConfig.RedactFields) {
+		t.Errorf("Incorrect RedactFields. Got %#v, Expected %#v",
+			expectedConfig.RedactFields, globalConfig.RedactFields)
+	}
+
+	if !reflect.DeepEqual(expectedConfig.HashFields, globalConfig.HashFields) {
+		t.Errorf("Incorrect HashFields. Got %#v, Expected %#v",
+			expectedConfig.HashFields, globalConfig.HashFields)
+	}
+
+	if expectedConfig.RoutingLookup != RoutingLookup() {
+		t.Errorf("Incorrect RoutingLookup. Got %t, Expected %t",
+			expectedConfig.RoutingLookup, RoutingLookup())
+	}
+
+	if expectedConfig.DocumentVersion != DocumentVersion() {
+		t.Errorf("Incorrect DocumentVersion. Got %t, Expected %t",
+			expectedConfig.DocumentVersion, DocumentVersion())
+	}
+
+	if expectedConfig.GlobalChannel != GlobalChannel() {
+		t.Errorf("Incorrect GlobalChannel. Got \"%s\", Expected \"%s\"",
+			expectedConfig.GlobalChannel, GlobalChannel())
+	}
+
+	if expectedConfig.Streams != Streams() {
+		t.Errorf("Incorrect Streams. Got %t, Expected %t",
+			expectedConfig.Streams, Streams())
+	}
+
+	if expectedConfig.StreamsMaxLen != StreamsMaxLen() {
+		t.Errorf("Incorrect StreamsMaxLen. Got %d, Expected %d",
+			expectedConfig.StreamsMaxLen, StreamsMaxLen())
+	}
+
+	if expectedConfig.StreamsPerDocument != StreamsPerDocument() {
+		t.Errorf("Incorrect StreamsPerDocument. Got %t, Expected %t",
+			expectedConfig.StreamsPerDocument, StreamsPerDocument())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.IncludeNamespaces, IncludeNamespaces()) {
+		t.Errorf("Incorrect IncludeNamespaces. Got %#v, Expected %#v",
+			expectedConfig.IncludeNamespaces, IncludeNamespaces())
+	}
+
+	if !reflect.DeepEqual(expectedConfig.ExcludeNamespaces, ExcludeNamespaces()) {
+		t.Errorf("Incorrect ExcludeNamespaces. Got %#v, Expected %#v",
+			expectedConfig.ExcludeNamespaces, ExcludeNamespaces())
+	}
+
+	if expectedConfig.SyntheticChannelPrefix != SyntheticChannelPrefix() {
+		t.Errorf("Incorrect SyntheticChannelPrefix. Got \"%s\", Expected \"%s\"",
+			expectedConfig.SyntheticChannelPrefix, SyntheticChannelPrefix())
+	}
+
+	if expectedConfig.ChaosMode != ChaosMode() {
+		t.Errorf("Incorrect ChaosMode. Got %t, Expected %t",
+			expectedConfig.ChaosMode, ChaosMode())
+	}
+
+	if expectedConfig.ChaosLatencyRate != ChaosLatencyRate() {
+		t.Errorf("Incorrect ChaosLatencyRate. Got %f, Expected %f",
+			expectedConfig.ChaosLatencyRate, ChaosLatencyRate())
+	}
+
+	if expectedConfig.ChaosLatency != ChaosLatency() {
+		t.Errorf("Incorrect ChaosLatency. Got %d, Expected %d",
+			expectedConfig.ChaosLatency, ChaosLatency())
+	}
+}
+
+func TestCustomCollectionChannels(t *testing.T) {
+	globalConfig = &oplogtoredisConfiguration{
+		CollectionNamespaces: map[string]string{"db.tasks": "ns1|ns2", "db.a.b": "ns"},
+		CollectionChannels:   map[string]string{"db.tasks": "custom", "db.other": "x|y"},
+	}
+
+	got := CustomCollectionChannels()
+	want := map[string][]string{
+		"db.tasks": {"db.ns1::tasks", "db.ns2::tasks", "db.custom"},
+		"db.a.b":   {"db.ns::a.b"},
+		"db.other": {"db.x", "db.y"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CustomCollectionChannels() = %#v, want %#v", got, want)
+	}
+}
+
+func TestFullDocumentProjections(t *testing.T) {
+	globalConfig = &oplogtoredisConfiguration{
+		FullDocumentProjections: map[string]string{"app.tasks": "title|status", "db.users": "name"},
+	}
+
+	got := FullDocumentProjections()
+	want := map[string][]string{
+		"app.tasks": {"title", "status"},
+		"db.users":  {"name"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FullDocumentProjections() = %#v, want %#v", got, want)
+	}
+}
+
+func TestRoutingFields(t *testing.T) {
+	globalConfig = &oplogtoredisConfiguration{
+		RoutingFields: map[string]string{"app.tasks": "tenantId|meta.region"},
+	}
+
+	got := RoutingFields()
+	want := map[string][]string{"app.tasks": {"tenantId", "meta.region"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RoutingFields() = %#v, want %#v", got, want)
+	}
+}
+
+func TestRedactFields(t *testing.T) {
+	globalConfig = &oplogtoredisConfiguration{
+		RedactFields: map[string]string{"app.users": "services.password|secret"},
+		HashFields:   map[string]string{"app.users": "email"},
+	}
+
+	got := RedactFields()
+	want := map[string][]string{"app.users": {"services.password", "secret"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactFields() = %#v, want %#v", got, want)
+	}
+
+	got = HashFields()
+	want = map[string][]string{"app.users": {"email"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("HashFields() = %#v, want %#v", got, want)
+	}
 }
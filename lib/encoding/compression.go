@@ -0,0 +1,79 @@
+package encoding
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Compression is how large messages are compressed. Compressed messages
+// start with their compression's magic number (0x1f 0x8b for gzip), which
+// no uncompressed message (in any encoding) starts with, so consumers can
+// tell from a message's first byte whether to decompress it.
+type Compression string
+
+const (
+	// NoCompression publishes every message as it is. This is the default.
+	NoCompression Compression = "none"
+
+	// Gzip compresses large messages with gzip
+	Gzip Compression = "gzip"
+)
+
+// ParseCompression parses "none" or "gzip"
+func ParseCompression(value string) (Compression, error) {
+	switch compression := Compression(value); compression {
+	case NoCompression, Gzip:
+		return compression, nil
+	default:
+		return "", fmt.Errorf("Invalid compression %q: must be none or gzip", value)
+	}
+}
+
+var metricCompressedMessages = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "encoding",
+	Name:      "compressed_messages",
+	Help:      "Messages that were compressed because they were larger than the compression threshold",
+})
+
+var metricCompressionSavedBytes = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "encoding",
+	Name:      "compression_saved_bytes",
+	Help:      "Bytes saved by compressing messages",
+})
+
+// Returns msg compressed with the given compression, or msg itself if it
+// doesn't get any smaller
+func compress(msg []byte, compression Compression) ([]byte, error) {
+	switch compression {
+	case Gzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+
+		_, err := writer.Write(msg)
+		if err != nil {
+			return nil, fmt.Errorf("Error compressing message: %s", err)
+		}
+
+		err = writer.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Error compressing message: %s", err)
+		}
+
+		if buf.Len() >= len(msg) {
+			// Compressing it doesn't help
+			return msg, nil
+		}
+
+		metricCompressedMessages.Inc()
+		metricCompressionSavedBytes.Add(float64(len(msg) - buf.Len()))
+		return buf.Bytes(), nil
+	default:
+		return msg, nil
+	}
+}
@@ -0,0 +1,116 @@
+package encoding
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestParseCompression(t *testing.T) {
+	for _, value := range []string{"none", "gzip"} {
+		compression, err := ParseCompression(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(compression) != value {
+			t.Errorf("Parsed %q as %q", value, compression)
+		}
+	}
+
+	if _, err := ParseCompression("zstd"); err == nil {
+		t.Error("Expected an error parsing an invalid compression")
+	}
+}
+
+func TestSinkCompression(t *testing.T) {
+	large := `{"e":"u","d":{"_id":"x","text":"` + strings.Repeat("a", 200) + `"},"f":["text"]}`
+
+	tests := map[string]struct {
+		opts           SinkOpts
+		msg            string
+		wantCompressed bool
+	}{
+		"Large message": {
+			opts:           SinkOpts{Compression: Gzip, CompressionThreshold: 100},
+			msg:            large,
+			wantCompressed: true,
+		},
+		"Small message": {
+			opts: SinkOpts{Compression: Gzip, CompressionThreshold: 100},
+			msg:  `{"e":"r","d":{"_id":"x"},"f":[]}`,
+		},
+		"No threshold": {
+			opts:           SinkOpts{Compression: Gzip},
+			msg:            large,
+			wantCompressed: true,
+		},
+		"Message that doesn't get smaller": {
+			opts: SinkOpts{Compression: Gzip},
+			msg:  `{"e":"r","d":{"_id":"x"},"f":[]}`,
+		},
+		"No compression": {
+			opts: SinkOpts{Compression: NoCompression, CompressionThreshold: 100},
+			msg:  large,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			inner := &fakeSink{}
+			sink := NewSink(inner, test.opts)
+
+			if err := sink.Publish(&redispub.Publication{Msg: []byte(test.msg)}); err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+			got := inner.published[0].Msg
+
+			if !test.wantCompressed {
+				if string(got) != test.msg {
+					t.Errorf("Expected the message as it was, got %x", got)
+				}
+				return
+			}
+
+			if !bytes.HasPrefix(got, []byte{0x1f, 0x8b}) {
+				t.Fatalf("Expected a gzipped message, got %x", got)
+			}
+
+			reader, err := gzip.NewReader(bytes.NewReader(got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decompressed) != test.msg {
+				t.Errorf("Got %s after decompressing, expected %s", decompressed, test.msg)
+			}
+		})
+	}
+}
+
+func TestSinkEncodesBeforeCompressing(t *testing.T) {
+	inner := &fakeSink{}
+	sink := NewSink(inner, SinkOpts{Encoding: MessagePack, Compression: Gzip})
+
+	text := strings.Repeat("a", 200)
+	if err := sink.Publish(&redispub.Publication{Msg: []byte(`{"t":"` + text + `"}`)}); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(inner.published[0].Msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x81\xa1t\xd9\xc8" + text; string(decompressed) != want {
+		t.Errorf("Got %x after decompressing", decompressed)
+	}
+}
@@ -0,0 +1,271 @@
+// Package encoding encodes the messages oplogtoredis publishes as
+// MessagePack or protobuf, for consumers that would rather not parse JSON.
+// JSON (the format redis-oplog expects) remains the default; other
+// encodings, and compression of large messages, are chosen per sink, by
+// wrapping the sink with NewSink.
+package encoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Encoding is the name of a message encoding
+type Encoding string
+
+const (
+	// JSON publishes messages as JSON, exactly as they're processed. This is
+	// the default, and the only encoding Meteor servers understand.
+	JSON Encoding = "json"
+
+	// MessagePack publishes messages as MessagePack maps, with the same keys
+	// and values as the JSON (see https://msgpack.org)
+	MessagePack Encoding = "msgpack"
+
+	// Protobuf publishes messages as protobuf, in the schema in
+	// message.proto
+	Protobuf Encoding = "protobuf"
+)
+
+// Parse parses "json", "msgpack", or "protobuf"
+func Parse(value string) (Encoding, error) {
+	switch encoding := Encoding(value); encoding {
+	case JSON, MessagePack, Protobuf:
+		return encoding, nil
+	default:
+		return "", fmt.Errorf("Invalid encoding %q: must be json, msgpack, or protobuf", value)
+	}
+}
+
+// Message is the message published for a change to a document, in the
+// format redis-oplog expects. Its JSON encoding is the message itself; it's
+// also a publication's Payload, so that other encodings don't have to parse
+// the JSON.
+type Message struct {
+	Event     string                 `json:"e"`
+	Doc       map[string]interface{} `json:"d"`
+	Fields    []string               `json:"f"`
+	Version   string                 `json:"v,omitempty"`
+	Namespace string                 `json:"ns,omitempty"`
+	Timestamp string                 `json:"ts,omitempty"`
+	WallTime  interface{}            `json:"wall,omitempty"`
+
+	ProtocolVersion  int    `json:"pv,omitempty"`
+	PublisherVersion string `json:"otrv,omitempty"`
+}
+
+// Returns the message's keys and values, as in its JSON encoding
+func (msg *Message) values() map[string]interface{} {
+	values := map[string]interface{}{
+		"e": msg.Event,
+		"d": msg.Doc,
+		"f": msg.Fields,
+	}
+
+	if msg.Version != "" {
+		values["v"] = msg.Version
+	}
+	if msg.Namespace != "" {
+		values["ns"] = msg.Namespace
+	}
+	if msg.Timestamp != "" {
+		values["ts"] = msg.Timestamp
+	}
+	if msg.WallTime != nil {
+		values["wall"] = msg.WallTime
+	}
+	if msg.ProtocolVersion != 0 {
+		values["pv"] = msg.ProtocolVersion
+	}
+	if msg.PublisherVersion != "" {
+		values["otrv"] = msg.PublisherVersion
+	}
+
+	return values
+}
+
+// Encoder encodes the messages of publications
+type Encoder interface {
+	// Encode returns the message of a publication, in the encoder's
+	// encoding
+	Encode(p *redispub.Publication) ([]byte, error)
+}
+
+// NewEncoder returns the Encoder for an encoding, or nil for JSON, which
+// needs no encoding
+func NewEncoder(encoding Encoding) Encoder {
+	switch encoding {
+	case MessagePack:
+		return MessagePackEncoder{}
+	case Protobuf:
+		return ProtobufEncoder{}
+	default:
+		return nil
+	}
+}
+
+// Returns the keys and values of a publication's message: those of its
+// Payload, if it has one, or else those of its JSON message. Publications
+// that were stored (like those replayed from a write-ahead log) and
+// publications that aren't changes to documents (like dead letters) have
+// no Payload.
+func messageValues(p *redispub.Publication) (map[string]interface{}, error) {
+	if msg, ok := p.Payload.(*Message); ok {
+		return msg.values(), nil
+	}
+
+	var values map[string]interface{}
+	if err := decodeJSON(p.Msg, &values); err != nil {
+		return nil, fmt.Errorf("Error decoding message: %s", err)
+	}
+
+	return values, nil
+}
+
+// Decodes JSON, keeping integers as integers
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// Converts a value to the types we know how to encode: nil, bool, int64,
+// float64, string, []interface{}, or map[string]interface{}. The elements
+// of arrays and maps are left as they are. Anything else is converted the
+// way encoding/json would encode it.
+// nolint: gocyclo
+func normalize(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, bool, int64, float64, string, []interface{}, map[string]interface{}:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := rv.Uint(); u <= 1<<63-1 {
+			return int64(u), nil
+		}
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String && !isJSONMarshaler(rv) {
+			converted := make(map[string]interface{}, rv.Len())
+			for _, key := range rv.MapKeys() {
+				converted[key.String()] = rv.MapIndex(key).Interface()
+			}
+			return converted, nil
+		}
+	case reflect.Slice:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		if rv.Type().Elem().Kind() != reflect.Uint8 && !isJSONMarshaler(rv) {
+			converted := make([]interface{}, rv.Len())
+			for i := range converted {
+				converted[i] = rv.Index(i).Interface()
+			}
+			return converted, nil
+		}
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var converted interface{}
+	if err := decodeJSON(data, &converted); err != nil {
+		return nil, err
+	}
+
+	return normalize(converted)
+}
+
+func isJSONMarshaler(rv reflect.Value) bool {
+	_, ok := rv.Interface().(json.Marshaler)
+	return ok
+}
+
+// SinkOpts configures a Sink
+type SinkOpts struct {
+	// The encoding of messages. Defaults to JSON.
+	Encoding Encoding
+
+	// How to compress messages that are larger than CompressionThreshold
+	// bytes once they're encoded. Defaults to no compression.
+	Compression          Compression
+	CompressionThreshold int
+}
+
+// Sink is a redispub.Sink that encodes (and compresses) each publication's
+// message before passing it on to another sink
+type Sink struct {
+	sink    redispub.Sink
+	encoder Encoder
+	opts    SinkOpts
+}
+
+// NewSink wraps a sink so that it receives messages in the given encoding
+// and compression. It returns the sink itself for uncompressed JSON.
+func NewSink(sink redispub.Sink, opts SinkOpts) redispub.Sink {
+	encoder := NewEncoder(opts.Encoding)
+	if encoder == nil && (opts.Compression == "" || opts.Compression == NoCompression) {
+		return sink
+	}
+
+	return &Sink{sink: sink, encoder: encoder, opts: opts}
+}
+
+// Publish encodes p's message, compresses it if it's larger than the
+// compression threshold, and publishes it to the wrapped sink. p itself
+// isn't modified, since other sinks may need it as it is.
+func (s *Sink) Publish(p *redispub.Publication) error {
+	msg := p.Msg
+	var err error
+
+	if s.encoder != nil {
+		msg, err = s.encoder.Encode(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(msg) > s.opts.CompressionThreshold {
+		msg, err = compress(msg, s.opts.Compression)
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded := *p
+	encoded.Msg = msg
+	return s.sink.Publish(&encoded)
+}
+
+// Checkpoint checkpoints p in the wrapped sink
+func (s *Sink) Checkpoint(p *redispub.Publication) {
+	s.sink.Checkpoint(p)
+}
@@ -0,0 +1,224 @@
+package encoding
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestParse(t *testing.T) {
+	for _, value := range []string{"json", "msgpack", "protobuf"} {
+		encoding, err := Parse(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(encoding) != value {
+			t.Errorf("Parsed %q as %q", value, encoding)
+		}
+	}
+
+	if _, err := Parse("bson"); err == nil {
+		t.Error("Expected an error parsing an invalid encoding")
+	}
+}
+
+func TestMessageJSON(t *testing.T) {
+	// The JSON encoding of a Message is exactly what redis-oplog expects
+	tests := map[string]struct {
+		msg  Message
+		want string
+	}{
+		"Minimal": {
+			msg:  Message{Event: "i", Doc: map[string]interface{}{"_id": "someid"}, Fields: []string{"_id"}},
+			want: `{"e":"i","d":{"_id":"someid"},"f":["_id"]}`,
+		},
+		"Everything": {
+			msg: Message{
+				Event:           "u",
+				Doc:             map[string]interface{}{"_id": "someid"},
+				Fields:          []string{"a"},
+				Version:         "01",
+				Namespace:       "foo.bar",
+				Timestamp:       "1500000000:3",
+				WallTime:        map[string]int64{"$date": 1500000000250},
+				ProtocolVersion: 2,
+
+				PublisherVersion: "v2.1.0",
+			},
+			want: `{"e":"u","d":{"_id":"someid"},"f":["a"],"v":"01","ns":"foo.bar","ts":"1500000000:3","wall":{"$date":1500000000250},"pv":2,"otrv":"v2.1.0"}`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got, err := json.Marshal(&test.msg)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if string(got) != test.want {
+				t.Errorf("Got %s, expected %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMessageValues(t *testing.T) {
+	msg := &Message{
+		Event:           "u",
+		Doc:             map[string]interface{}{"_id": "someid"},
+		Fields:          []string{"a"},
+		WallTime:        map[string]int64{"$date": 1500000000250},
+		ProtocolVersion: 2,
+
+		PublisherVersion: "v2.1.0",
+	}
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"e":    "u",
+		"d":    map[string]interface{}{"_id": "someid"},
+		"f":    []interface{}{"a"},
+		"wall": map[string]interface{}{"$date": int64(1500000000250)},
+		"pv":   int64(2),
+		"otrv": "v2.1.0",
+	}
+
+	for testName, p := range map[string]*redispub.Publication{
+		"Payload": {Msg: msgJSON, Payload: msg},
+		"JSON":    {Msg: msgJSON},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			values, err := messageValues(p)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if got := normalizeAll(t, values); !reflect.DeepEqual(got, want) {
+				t.Errorf("Got %#v, expected %#v", got, want)
+			}
+		})
+	}
+
+	if _, err := messageValues(&redispub.Publication{Msg: []byte("not json")}); err == nil {
+		t.Error("Expected an error for a message that isn't JSON")
+	}
+}
+
+// Normalizes a value and everything in it
+func normalizeAll(t *testing.T, value interface{}) interface{} {
+	value, err := normalize(value)
+	if err != nil {
+		t.Fatalf("Got unexpected error normalizing %#v: %s", value, err)
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = normalizeAll(t, elem)
+		}
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = normalizeAll(t, elem)
+		}
+	}
+
+	return value
+}
+
+func TestNormalize(t *testing.T) {
+	type someStruct struct {
+		A int `json:"a"`
+	}
+
+	tests := map[string]struct {
+		value interface{}
+		want  interface{}
+	}{
+		"Nil":         {value: nil, want: nil},
+		"Int":         {value: 3, want: int64(3)},
+		"Int32":       {value: int32(-3), want: int64(-3)},
+		"Uint64":      {value: uint64(1) << 63, want: float64(uint64(1) << 63)},
+		"Float":       {value: float32(1.5), want: float64(1.5)},
+		"JSON int":    {value: json.Number("12"), want: int64(12)},
+		"JSON float":  {value: json.Number("1.5"), want: float64(1.5)},
+		"String map":  {value: map[string]string{"a": "b"}, want: map[string]interface{}{"a": "b"}},
+		"String list": {value: []string{"a"}, want: []interface{}{"a"}},
+		"Nil list":    {value: []string(nil), want: nil},
+		"Bytes":       {value: []byte("abc"), want: "YWJj"},
+		"Struct":      {value: someStruct{A: 1}, want: map[string]interface{}{"a": json.Number("1")}},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got, err := normalize(test.value)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %#v, expected %#v", got, test.want)
+			}
+		})
+	}
+}
+
+type fakeSink struct {
+	published    []*redispub.Publication
+	checkpointed []*redispub.Publication
+	err          error
+}
+
+func (s *fakeSink) Publish(p *redispub.Publication) error {
+	s.published = append(s.published, p)
+	return s.err
+}
+
+func (s *fakeSink) Checkpoint(p *redispub.Publication) {
+	s.checkpointed = append(s.checkpointed, p)
+}
+
+func TestNewSink(t *testing.T) {
+	inner := &fakeSink{}
+	if sink := NewSink(inner, SinkOpts{Encoding: JSON}); sink != inner {
+		t.Errorf("Expected the sink itself for JSON, got %#v", sink)
+	}
+
+	sink := NewSink(inner, SinkOpts{Encoding: MessagePack})
+
+	p := &redispub.Publication{
+		CollectionChannel: "foo.bar",
+		Msg:               []byte(`{"e":"r"}`),
+	}
+	if err := sink.Publish(p); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	sink.Checkpoint(p)
+
+	if len(inner.published) != 1 {
+		t.Fatalf("Expected 1 publication, got %d", len(inner.published))
+	}
+	published := inner.published[0]
+	if string(published.Msg) != "\x81\xa1e\xa1r" || published.CollectionChannel != "foo.bar" {
+		t.Errorf("Got unexpected publication %#v", published)
+	}
+
+	// The original publication is unchanged
+	if string(p.Msg) != `{"e":"r"}` {
+		t.Errorf("Publish modified its publication: %s", p.Msg)
+	}
+
+	if len(inner.checkpointed) != 1 || inner.checkpointed[0] != p {
+		t.Errorf("Got checkpoints %#v", inner.checkpointed)
+	}
+
+	inner.err = errors.New("down")
+	if err := sink.Publish(p); err != inner.err {
+		t.Errorf("Expected the sink's error, got %v", err)
+	}
+}
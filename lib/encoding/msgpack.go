@@ -0,0 +1,149 @@
+package encoding
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// MessagePackEncoder encodes messages as MessagePack maps, with the same
+// keys and values as their JSON. Map keys are sorted, so that the message
+// for a given oplog entry is always byte-for-byte the same.
+type MessagePackEncoder struct{}
+
+// Encode returns the MessagePack encoding of p's message
+func (MessagePackEncoder) Encode(p *redispub.Publication) ([]byte, error) {
+	values, err := messageValues(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var w msgpackWriter
+	if err := w.value(values); err != nil {
+		return nil, fmt.Errorf("Error encoding message as MessagePack: %s", err)
+	}
+
+	return w.buf, nil
+}
+
+// Appends MessagePack-encoded values to a buffer. See
+// https://github.com/msgpack/msgpack/blob/master/spec.md
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) value(value interface{}) error {
+	value, err := normalize(value)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		w.buf = append(w.buf, 0xc0)
+	case bool:
+		if v {
+			w.buf = append(w.buf, 0xc3)
+		} else {
+			w.buf = append(w.buf, 0xc2)
+		}
+	case int64:
+		w.int(v)
+	case float64:
+		w.buf = append(w.buf, 0xcb)
+		w.uint(math.Float64bits(v), 8)
+	case string:
+		w.header(len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		w.buf = append(w.buf, v...)
+	case []interface{}:
+		w.header(len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, elem := range v {
+			if err := w.value(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		w.header(len(v), 0x80, 16, 0, 0xde, 0xdf)
+		for _, key := range keys {
+			if err := w.value(key); err != nil {
+				return err
+			}
+			if err := w.value(v[key]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Appends an integer in the smallest form that holds it
+func (w *msgpackWriter) int(v int64) {
+	switch {
+	case v >= 0 && v < 128:
+		w.buf = append(w.buf, byte(v))
+	case v >= 0:
+		switch {
+		case v <= math.MaxUint8:
+			w.buf = append(w.buf, 0xcc)
+			w.uint(uint64(v), 1)
+		case v <= math.MaxUint16:
+			w.buf = append(w.buf, 0xcd)
+			w.uint(uint64(v), 2)
+		case v <= math.MaxUint32:
+			w.buf = append(w.buf, 0xce)
+			w.uint(uint64(v), 4)
+		default:
+			w.buf = append(w.buf, 0xcf)
+			w.uint(uint64(v), 8)
+		}
+	case v >= -32:
+		w.buf = append(w.buf, byte(int8(v)))
+	case v >= math.MinInt8:
+		w.buf = append(w.buf, 0xd0)
+		w.uint(uint64(v), 1)
+	case v >= math.MinInt16:
+		w.buf = append(w.buf, 0xd1)
+		w.uint(uint64(v), 2)
+	case v >= math.MinInt32:
+		w.buf = append(w.buf, 0xd2)
+		w.uint(uint64(v), 4)
+	default:
+		w.buf = append(w.buf, 0xd3)
+		w.uint(uint64(v), 8)
+	}
+}
+
+// Appends the header of a string, array, or map of length n: the fix form
+// (fix|n) if n is less than fixMax, or else the 8-, 16-, or 32-bit form
+// (arrays and maps have no 8-bit form, which is given as 0)
+func (w *msgpackWriter) header(n int, fix byte, fixMax int, code8 byte, code16 byte, code32 byte) {
+	switch {
+	case n < fixMax:
+		w.buf = append(w.buf, fix|byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		w.buf = append(w.buf, code8)
+		w.uint(uint64(n), 1)
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, code16)
+		w.uint(uint64(n), 2)
+	default:
+		w.buf = append(w.buf, code32)
+		w.uint(uint64(n), 4)
+	}
+}
+
+// Appends the low size bytes of v, big-endian
+func (w *msgpackWriter) uint(v uint64, size int) {
+	for i := size - 1; i >= 0; i-- {
+		w.buf = append(w.buf, byte(v>>(uint(i)*8)))
+	}
+}
@@ -0,0 +1,75 @@
+package encoding
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestMessagePackEncode(t *testing.T) {
+	msg := &Message{Event: "i", Doc: map[string]interface{}{"_id": "x"}, Fields: []string{"_id"}}
+	want := []byte("\x83\xa1d\x81\xa3_id\xa1x\xa1e\xa1i\xa1f\x91\xa3_id")
+
+	for testName, p := range map[string]*redispub.Publication{
+		"Payload": {Msg: []byte(`{"e":"i","d":{"_id":"x"},"f":["_id"]}`), Payload: msg},
+		"JSON":    {Msg: []byte(`{"e":"i","d":{"_id":"x"},"f":["_id"]}`)},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			got, err := MessagePackEncoder{}.Encode(p)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("Got %x, expected %x", got, want)
+			}
+		})
+	}
+}
+
+func TestMessagePackValues(t *testing.T) {
+	tests := map[string]struct {
+		value interface{}
+		want  []byte
+	}{
+		"Nil":               {value: nil, want: []byte{0xc0}},
+		"True":              {value: true, want: []byte{0xc3}},
+		"False":             {value: false, want: []byte{0xc2}},
+		"Positive fixint":   {value: 127, want: []byte{0x7f}},
+		"Uint8":             {value: 128, want: []byte{0xcc, 0x80}},
+		"Uint16":            {value: 256, want: []byte{0xcd, 0x01, 0x00}},
+		"Uint32":            {value: 1 << 16, want: []byte{0xce, 0x00, 0x01, 0x00, 0x00}},
+		"Uint64":            {value: int64(1) << 32, want: []byte{0xcf, 0, 0, 0, 1, 0, 0, 0, 0}},
+		"Negative fixint":   {value: -32, want: []byte{0xe0}},
+		"Int8":              {value: -33, want: []byte{0xd0, 0xdf}},
+		"Int16":             {value: -129, want: []byte{0xd1, 0xff, 0x7f}},
+		"Int32":             {value: -32769, want: []byte{0xd2, 0xff, 0xff, 0x7f, 0xff}},
+		"Int64":             {value: int64(math.MinInt64), want: []byte{0xd3, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		"Float":             {value: 1.5, want: []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		"Fixstr":            {value: "ab", want: []byte{0xa2, 'a', 'b'}},
+		"Str8":              {value: strings.Repeat("a", 32), want: append([]byte{0xd9, 32}, strings.Repeat("a", 32)...)},
+		"Str16":             {value: strings.Repeat("a", 256), want: append([]byte{0xda, 0x01, 0x00}, strings.Repeat("a", 256)...)},
+		"Fixarray":          {value: []interface{}{1, "a"}, want: []byte{0x92, 0x01, 0xa1, 'a'}},
+		"Array16":           {value: make([]interface{}, 16), want: append([]byte{0xdc, 0x00, 0x10}, bytes.Repeat([]byte{0xc0}, 16)...)},
+		"Fixmap sorted":     {value: map[string]interface{}{"b": 2, "a": 1}, want: []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		"Typed map":         {value: map[string]int64{"$date": 1}, want: []byte{0x81, 0xa5, '$', 'd', 'a', 't', 'e', 0x01}},
+		"Typed list":        {value: []string{"a"}, want: []byte{0x91, 0xa1, 'a'}},
+		"Nested empty maps": {value: map[string]interface{}{"a": map[string]interface{}{}}, want: []byte{0x81, 0xa1, 'a', 0x80}},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var w msgpackWriter
+			if err := w.value(test.value); err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if !bytes.Equal(w.buf, test.want) {
+				t.Errorf("Got %x, expected %x", w.buf, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package encoding
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// ProtobufEncoder encodes messages as protobuf, in the schema in
+// message.proto. Map entries are sorted by key, so that the message for a
+// given oplog entry is always byte-for-byte the same.
+type ProtobufEncoder struct{}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// Encode returns the protobuf encoding of p's message
+func (ProtobufEncoder) Encode(p *redispub.Publication) ([]byte, error) {
+	values, err := messageValues(p)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := protobufMessage(values)
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding message as protobuf: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Returns the Message for the keys and values of a message, with its fields
+// in order. Keys whose values don't fit their field go in "other".
+func protobufMessage(values map[string]interface{}) (*proto.Buffer, error) {
+	other := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		other[key] = value
+	}
+
+	buf := proto.NewBuffer(nil)
+	protobufStringField(buf, 1, other, "e")
+
+	if doc, ok := other["d"]; ok {
+		delete(other, "d")
+		if err := protobufField(buf, 2, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if fields, ok := stringList(other["f"]); ok {
+		delete(other, "f")
+		for _, field := range fields {
+			protobufKey(buf, 3, wireBytes)
+			_ = buf.EncodeStringBytes(field)
+		}
+	}
+
+	protobufStringField(buf, 4, other, "v")
+	protobufStringField(buf, 5, other, "ns")
+	protobufStringField(buf, 6, other, "ts")
+
+	if wall, ok := other["wall"]; ok {
+		delete(other, "wall")
+		if err := protobufField(buf, 7, wall); err != nil {
+			return nil, err
+		}
+	}
+
+	if pv, err := normalize(other["pv"]); err == nil {
+		if i, ok := pv.(int64); ok {
+			delete(other, "pv")
+			protobufKey(buf, 8, wireVarint)
+			_ = buf.EncodeVarint(uint64(i))
+		}
+	}
+
+	protobufStringField(buf, 9, other, "otrv")
+
+	if err := protobufMap(buf, 15, other); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Encodes values[key] as a string field, and removes it from values, if
+// it's a string
+func protobufStringField(buf *proto.Buffer, number uint64, values map[string]interface{}, key string) {
+	s, ok := values[key].(string)
+	if !ok {
+		return
+	}
+
+	delete(values, key)
+	protobufKey(buf, number, wireBytes)
+	_ = buf.EncodeStringBytes(s)
+}
+
+// Returns value as a list of strings, if it is one
+func stringList(value interface{}) ([]string, bool) {
+	if strs, ok := value.([]string); ok {
+		return strs, true
+	}
+
+	elems, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	strs := make([]string, len(elems))
+	for i, elem := range elems {
+		if strs[i], ok = elem.(string); !ok {
+			return nil, false
+		}
+	}
+
+	return strs, true
+}
+
+// Encodes a Value field
+func protobufField(buf *proto.Buffer, number uint64, value interface{}) error {
+	encoded, err := protobufValue(value)
+	if err != nil {
+		return err
+	}
+
+	protobufKey(buf, number, wireBytes)
+	return buf.EncodeRawBytes(encoded.Bytes())
+}
+
+// Encodes a map<string, Value> field: an entry message for each key, with
+// the key as field 1 and the value as field 2
+func protobufMap(buf *proto.Buffer, number uint64, values map[string]interface{}) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := proto.NewBuffer(nil)
+		protobufKey(entry, 1, wireBytes)
+		_ = entry.EncodeStringBytes(key)
+		if err := protobufField(entry, 2, values[key]); err != nil {
+			return err
+		}
+
+		protobufKey(buf, number, wireBytes)
+		_ = buf.EncodeRawBytes(entry.Bytes())
+	}
+
+	return nil
+}
+
+// Returns the Value message for a value
+func protobufValue(value interface{}) (*proto.Buffer, error) {
+	value, err := normalize(value)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := proto.NewBuffer(nil)
+	switch v := value.(type) {
+	case nil:
+		protobufKey(buf, 1, wireVarint)
+		_ = buf.EncodeVarint(1)
+	case bool:
+		protobufKey(buf, 2, wireVarint)
+		if v {
+			_ = buf.EncodeVarint(1)
+		} else {
+			_ = buf.EncodeVarint(0)
+		}
+	case int64:
+		protobufKey(buf, 3, wireVarint)
+		_ = buf.EncodeVarint(uint64(v))
+	case float64:
+		protobufKey(buf, 4, wireFixed64)
+		_ = buf.EncodeFixed64(math.Float64bits(v))
+	case string:
+		protobufKey(buf, 5, wireBytes)
+		_ = buf.EncodeStringBytes(v)
+	case map[string]interface{}:
+		object := proto.NewBuffer(nil)
+		if err := protobufMap(object, 1, v); err != nil {
+			return nil, err
+		}
+
+		protobufKey(buf, 6, wireBytes)
+		_ = buf.EncodeRawBytes(object.Bytes())
+	case []interface{}:
+		array := proto.NewBuffer(nil)
+		for _, elem := range v {
+			if err := protobufField(array, 1, elem); err != nil {
+				return nil, err
+			}
+		}
+
+		protobufKey(buf, 7, wireBytes)
+		_ = buf.EncodeRawBytes(array.Bytes())
+	}
+
+	return buf, nil
+}
+
+func protobufKey(buf *proto.Buffer, number uint64, wireType uint64) {
+	_ = buf.EncodeVarint(number<<3 | wireType)
+}
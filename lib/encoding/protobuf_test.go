@@ -0,0 +1,80 @@
+package encoding
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestProtobufEncode(t *testing.T) {
+	tests := map[string]struct {
+		p    *redispub.Publication
+		want []byte
+	}{
+		"Payload": {
+			p: &redispub.Publication{
+				Msg:     []byte(`{"e":"i","d":{"_id":"x"},"f":["_id"]}`),
+				Payload: &Message{Event: "i", Doc: map[string]interface{}{"_id": "x"}, Fields: []string{"_id"}},
+			},
+			want: []byte(
+				// e: "i"
+				"\x0a\x01i" +
+					// d: {object: {fields: {"_id": {string: "x"}}}}
+					"\x12\x0e\x32\x0c\x0a\x0a\x0a\x03_id\x12\x03\x2a\x01x" +
+					// f: ["_id"]
+					"\x1a\x03_id"),
+		},
+		"JSON": {
+			p: &redispub.Publication{
+				Msg: []byte(`{"e":"i","d":{"_id":"x"},"f":["_id"]}`),
+			},
+			want: []byte("\x0a\x01i\x12\x0e\x32\x0c\x0a\x0a\x0a\x03_id\x12\x03\x2a\x01x\x1a\x03_id"),
+		},
+		"Metadata": {
+			p: &redispub.Publication{
+				Msg: []byte(`{"e":"u","d":{"_id":"x"},"f":[],"ts":"1:2","wall":null,"pv":2,"otrv":"v2"}`),
+			},
+			want: []byte(
+				"\x0a\x01u" +
+					"\x12\x0e\x32\x0c\x0a\x0a\x0a\x03_id\x12\x03\x2a\x01x" +
+					// ts: "1:2"
+					"\x32\x031:2" +
+					// wall: {null: true}
+					"\x3a\x02\x08\x01" +
+					// pv: 2
+					"\x40\x02" +
+					// otrv: "v2"
+					"\x4a\x02v2"),
+		},
+		"Other keys": {
+			p: &redispub.Publication{
+				Msg: []byte(`{"e":3,"op":"c","n":1.5,"ok":false,"l":[true]}`),
+			},
+			want: []byte(
+				// other: {"e": {int: 3}}
+				"\x7a\x07\x0a\x01e\x12\x02\x18\x03" +
+					// other: {"l": {array: {values: [{bool: true}]}}}
+					"\x7a\x0b\x0a\x01l\x12\x06\x3a\x04\x0a\x02\x10\x01" +
+					// other: {"n": {double: 1.5}}
+					"\x7a\x0e\x0a\x01n\x12\x09\x21\x00\x00\x00\x00\x00\x00\xf8\x3f" +
+					// other: {"ok": {bool: false}}
+					"\x7a\x08\x0a\x02ok\x12\x02\x10\x00" +
+					// other: {"op": {string: "c"}}
+					"\x7a\x09\x0a\x02op\x12\x03\x2a\x01c"),
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got, err := ProtobufEncoder{}.Encode(test.p)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("Got %x, expected %x", got, test.want)
+			}
+		})
+	}
+}
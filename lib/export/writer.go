@@ -0,0 +1,210 @@
+// Package export writes publications to rotating JSONL files, one
+// publication per line. The files are useful for audits, and for replaying
+// a production workload into a test environment.
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Record is the format of each line of an export file
+type Record struct {
+	// The timestamp of the oplog entry, in the "<seconds>:<increment>" form
+	Timestamp string `json:"ts"`
+
+	// The time of the oplog entry (accurate to the second)
+	Time time.Time `json:"time"`
+
+	CollectionChannel string `json:"collectionChannel"`
+	SpecificChannel   string `json:"specificChannel"`
+
+	// Other channels the message is published to, like routing channels
+	ExtraChannels []string `json:"extraChannels,omitempty"`
+
+	// The message that is published to the channels
+	Message json.RawMessage `json:"msg"`
+}
+
+// NewRecord returns the Record for a publication
+func NewRecord(p *redispub.Publication) *Record {
+	return &Record{
+		Timestamp:         oplog.FormatTimestamp(p.OplogTimestamp),
+		Time:              time.Unix(int64(p.OplogTimestamp)>>32, 0).UTC(),
+		CollectionChannel: p.CollectionChannel,
+		SpecificChannel:   p.SpecificChannel,
+		ExtraChannels:     p.ExtraChannels,
+		Message:           json.RawMessage(p.Msg),
+	}
+}
+
+// WriterOpts configures a Writer
+type WriterOpts struct {
+	// Directory to write files to. It's created if it doesn't exist.
+	Dir string
+
+	// Prefix for file names. Files are named
+	// <prefix>-<creation time>.jsonl (or .jsonl.gz).
+	Prefix string
+
+	// Whether to gzip files
+	Compress bool
+
+	// Start a new file once the current one has this many bytes of
+	// (uncompressed) records. 0 means no limit.
+	MaxBytes int64
+
+	// Start a new file once the current one is this old. 0 means no limit.
+	MaxAge time.Duration
+}
+
+// Writer writes publications to rotating JSONL files. It is not safe for
+// concurrent use.
+type Writer struct {
+	opts WriterOpts
+
+	file    *os.File
+	gzip    *gzip.Writer
+	buf     *bufio.Writer
+	written int64
+	opened  time.Time
+
+	// Used to give files created in the same second distinct names
+	lastName string
+	sequence int
+
+	// Allows tests to control the clock
+	now func() time.Time
+}
+
+// NewWriter creates a Writer. No file is created until the first call to
+// Write.
+func NewWriter(opts WriterOpts) (*Writer, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = "oplogtoredis"
+	}
+
+	err := os.MkdirAll(opts.Dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create export directory: %s", err)
+	}
+
+	return &Writer{opts: opts, now: time.Now}, nil
+}
+
+// Write appends a publication to the current file, rotating first if the
+// file is too large or too old.
+func (w *Writer) Write(p *redispub.Publication) error {
+	line, err := json.Marshal(NewRecord(p))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if w.shouldRotate() {
+		err = w.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := w.buf.Write(line)
+	w.written += int64(n)
+	return err
+}
+
+// Close flushes and closes the current file.
+func (w *Writer) Close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.buf.Flush()
+	if err == nil && w.gzip != nil {
+		err = w.gzip.Close()
+	}
+
+	closeErr := w.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	w.file = nil
+	w.gzip = nil
+	w.buf = nil
+	return err
+}
+
+// Returns whether we need to start a new file before writing
+func (w *Writer) shouldRotate() bool {
+	if w.file == nil {
+		return true
+	}
+
+	if w.opts.MaxBytes > 0 && w.written >= w.opts.MaxBytes {
+		return true
+	}
+
+	if w.opts.MaxAge > 0 && w.now().Sub(w.opened) >= w.opts.MaxAge {
+		return true
+	}
+
+	return false
+}
+
+// Closes the current file (if any) and opens a new one
+func (w *Writer) rotate() error {
+	err := w.Close()
+	if err != nil {
+		return err
+	}
+
+	w.opened = w.now()
+	file, err := os.OpenFile(w.nextFileName(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not create export file: %s", err)
+	}
+
+	var out io.Writer = file
+	if w.opts.Compress {
+		w.gzip = gzip.NewWriter(file)
+		out = w.gzip
+	}
+
+	w.file = file
+	w.buf = bufio.NewWriter(out)
+	w.written = 0
+
+	return nil
+}
+
+// Returns the path of the next file to create
+func (w *Writer) nextFileName() string {
+	name := w.opts.Prefix + "-" + w.opened.UTC().Format("20060102T150405Z")
+	if name == w.lastName {
+		w.sequence++
+	} else {
+		w.lastName = name
+		w.sequence = 0
+	}
+
+	if w.sequence > 0 {
+		name = fmt.Sprintf("%s-%d", name, w.sequence)
+	}
+
+	name += ".jsonl"
+	if w.opts.Compress {
+		name += ".gz"
+	}
+
+	return filepath.Join(w.opts.Dir, name)
+}
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func testPublication(i int) *redispub.Publication {
+	return &redispub.Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+		Msg:               []byte(`{"e":"i","d":{"_id":"someid"},"f":["some"]}`),
+		OplogTimestamp:    bson.MongoTimestamp(1526648511<<32 | int64(i)),
+	}
+}
+
+// Reads every record from every file in dir, in file name order
+func readRecords(t *testing.T, dir string, compressed bool) ([]string, []Record) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("Could not list export files: %s", err)
+	}
+	sort.Strings(files)
+
+	var records []Record
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("Could not open export file: %s", err)
+		}
+
+		var in io.Reader = file
+		if compressed {
+			in, err = gzip.NewReader(file)
+			if err != nil {
+				t.Fatalf("Could not read gzip header of %s: %s", name, err)
+			}
+		}
+
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			var record Record
+			err = json.Unmarshal(scanner.Bytes(), &record)
+			if err != nil {
+				t.Fatalf("Could not unmarshal line %q: %s", scanner.Text(), err)
+			}
+			records = append(records, record)
+		}
+
+		file.Close()
+	}
+
+	return files, records
+}
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otr-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := NewWriter(WriterOpts{Dir: dir})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = writer.Write(testPublication(i))
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	files, records := readRecords(t, dir, false)
+	if len(files) != 1 {
+		t.Errorf("Expected 1 file, got %v", files)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+
+	record := records[2]
+	if record.Timestamp != "1526648511:2" {
+		t.Errorf("Got timestamp %q, expected \"1526648511:2\"", record.Timestamp)
+	}
+	if !record.Time.Equal(time.Unix(1526648511, 0)) {
+		t.Errorf("Got time %s, expected %s", record.Time, time.Unix(1526648511, 0))
+	}
+	if record.CollectionChannel != "foo.bar" || record.SpecificChannel != "foo.bar::someid" {
+		t.Errorf("Got channels %q and %q", record.CollectionChannel, record.SpecificChannel)
+	}
+	if string(record.Message) != `{"e":"i","d":{"_id":"someid"},"f":["some"]}` {
+		t.Errorf("Got message %s", record.Message)
+	}
+}
+
+func TestWriterRotatesBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otr-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := NewWriter(WriterOpts{Dir: dir, Compress: true, MaxBytes: 1})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		err = writer.Write(testPublication(i))
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	files, records := readRecords(t, dir, true)
+	if len(files) != 3 {
+		t.Errorf("Expected 3 files, got %v", files)
+	}
+	for _, name := range files {
+		if filepath.Ext(name) != ".gz" {
+			t.Errorf("Expected compressed file name to end in .gz, got %s", name)
+		}
+	}
+	if len(records) != 3 {
+		t.Errorf("Expected 3 records, got %d", len(records))
+	}
+}
+
+func TestWriterRotatesByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otr-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := NewWriter(WriterOpts{Dir: dir, MaxAge: time.Minute})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	now := time.Unix(1526648511, 0)
+	writer.now = func() time.Time { return now }
+
+	// The first two writes go in the same file; the third is after MaxAge
+	_ = writer.Write(testPublication(0))
+	now = now.Add(30 * time.Second)
+	_ = writer.Write(testPublication(1))
+	now = now.Add(30 * time.Second)
+	_ = writer.Write(testPublication(2))
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	files, records := readRecords(t, dir, false)
+	if len(files) != 2 {
+		t.Errorf("Expected 2 files, got %v", files)
+	}
+	if len(records) != 3 {
+		t.Errorf("Expected 3 records, got %d", len(records))
+	}
+}
@@ -0,0 +1,239 @@
+// Package kafka delivers publications to Kafka, so that the same change feed
+// oplogtoredis publishes to Redis can feed a data pipeline without running a
+// second tailer. Each collection gets its own topic, and each record is keyed
+// by the ID of the document it's about, so changes to a document stay in
+// order on a single partition.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Opts configures a Sink
+type Opts struct {
+	// The addresses ("host:port") of one or more brokers to look up the rest
+	// of the cluster from
+	Brokers []string
+
+	// Prepended to "<db>.<collection>" to name the topic for each collection
+	TopicPrefix string
+
+	// The client ID to send to the brokers. Defaults to "oplogtoredis".
+	ClientID string
+
+	// The timeout for connecting to a broker, and for each request. Defaults
+	// to 10 seconds.
+	Timeout time.Duration
+
+	// The most records to send to a partition in one request. Defaults to
+	// 100.
+	BatchSize int
+
+	// How long to wait for a batch to fill up before sending it anyway.
+	// Defaults to 1 second.
+	BatchTimeout time.Duration
+
+	// If set, connections to the brokers use TLS with this config
+	TLS *tls.Config
+
+	// If set, connections to the brokers are authenticated with SASL, using
+	// this mechanism (see SASLMechanisms) and credentials
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+}
+
+// SASLMechanisms are the SASL mechanisms a Sink can authenticate with
+var SASLMechanisms = []string{"plain", "scram-sha-256", "scram-sha-512"}
+
+// TimestampHeader is the record header that carries the oplog timestamp of
+// the entry a record is about, as a decimal 64-bit integer
+const TimestampHeader = "otr-ts"
+
+var metricWriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "kafka",
+	Name:      "write_errors",
+	Help:      "Number of failed requests to write a batch of records to Kafka. The records in a failed batch are dropped.",
+})
+
+// The parts of kafka.Writer that Sink uses, so tests can stand in for it
+type writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Sink is a redispub.Sink that writes each publication's message to Kafka,
+// to the topic for its collection (Opts.TopicPrefix + "<db>.<collection>"),
+// keyed by its document ID (see redispub.DocumentID). Keys are assigned to
+// partitions the same way as by Kafka's Java client. Publications that aren't
+// changes to documents (like dead letters) aren't written to Kafka.
+//
+// Records are batched per partition, and written in the background once a
+// batch is full or Opts.BatchTimeout has passed; each batch is acknowledged
+// by every in-sync replica. Delivery is best-effort, like the webhook's: a
+// batch that fails is logged, counted in otr_kafka_write_errors, and
+// dropped, and records that haven't been written when oplogtoredis crashes
+// aren't rewritten when it restarts. Consumers can use a record's key and
+// TimestampHeader to ignore the duplicates that a restart may cause.
+type Sink struct {
+	opts   Opts
+	dialer *kafka.Dialer
+
+	// Creates the writer for a topic
+	newWriter func(topic string) writer
+
+	mutex   sync.Mutex
+	writers map[string]writer
+}
+
+// New creates a Sink. The caller must call Close when done, to write the
+// last batches and close its connections.
+func New(opts Opts) (*Sink, error) {
+	if opts.ClientID == "" {
+		opts.ClientID = "oplogtoredis"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = time.Second
+	}
+
+	mechanism, err := saslMechanism(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sink{
+		opts: opts,
+		dialer: &kafka.Dialer{
+			ClientID:      opts.ClientID,
+			Timeout:       opts.Timeout,
+			DualStack:     true,
+			TLS:           opts.TLS,
+			SASLMechanism: mechanism,
+		},
+		writers: map[string]writer{},
+	}
+	s.newWriter = s.kafkaWriter
+
+	return s, nil
+}
+
+// Returns the SASL mechanism configured by opts, or nil for none
+func saslMechanism(opts Opts) (sasl.Mechanism, error) {
+	if opts.SASLMechanism != "" && opts.SASLUsername == "" {
+		return nil, fmt.Errorf("A username is required for Kafka SASL mechanism %s", opts.SASLMechanism)
+	}
+
+	switch opts.SASLMechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: opts.SASLUsername, Password: opts.SASLPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, opts.SASLUsername, opts.SASLPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, opts.SASLUsername, opts.SASLPassword)
+	default:
+		return nil, fmt.Errorf("Invalid Kafka SASL mechanism %q; must be one of %v", opts.SASLMechanism, SASLMechanisms)
+	}
+}
+
+// Publish queues p's message to be written to Kafka. It blocks only while
+// the queue for p's topic is full, and fails only once the Sink is closed.
+func (s *Sink) Publish(p *redispub.Publication) error {
+	if p.Meta {
+		return nil
+	}
+
+	topic := s.opts.TopicPrefix + p.CollectionChannel
+	err := s.writer(topic).WriteMessages(context.Background(), message(p))
+	if err != nil {
+		return fmt.Errorf("Error writing to Kafka topic %s: %s", topic, err)
+	}
+
+	return nil
+}
+
+// Checkpoint does nothing: Kafka doesn't keep track of where oplogtoredis
+// left off.
+func (s *Sink) Checkpoint(p *redispub.Publication) {}
+
+// Close writes the batches that haven't been written yet, and closes the
+// Sink's connections.
+func (s *Sink) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for topic, w := range s.writers {
+		_ = w.Close()
+		delete(s.writers, topic)
+	}
+}
+
+// Returns the writer for a topic, creating it if we haven't already
+func (s *Sink) writer(topic string) writer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	w, ok := s.writers[topic]
+	if !ok {
+		w = s.newWriter(topic)
+		s.writers[topic] = w
+	}
+
+	return w
+}
+
+// Creates a kafka.Writer for a topic
+func (s *Sink) kafkaWriter(topic string) writer {
+	return kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      s.opts.Brokers,
+		Topic:        topic,
+		Dialer:       s.dialer,
+		Balancer:     &kafka.Murmur2Balancer{},
+		BatchSize:    s.opts.BatchSize,
+		BatchTimeout: s.opts.BatchTimeout,
+		ReadTimeout:  s.opts.Timeout,
+		WriteTimeout: s.opts.Timeout,
+		RequiredAcks: -1,
+		Async:        true,
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...interface{}) {
+			metricWriteErrors.Inc()
+			log.Log.Errorw("Error writing to Kafka; dropping the batch",
+				"topic", topic,
+				"error", fmt.Sprintf(msg, args...))
+		}),
+	})
+}
+
+// Returns the record to write for a publication
+func message(p *redispub.Publication) kafka.Message {
+	return kafka.Message{
+		Key:   []byte(redispub.DocumentID(p)),
+		Value: p.Msg,
+		Headers: []kafka.Header{
+			{Key: TimestampHeader, Value: []byte(strconv.FormatInt(int64(p.OplogTimestamp), 10))},
+		},
+		Time: time.Now(),
+	}
+}
@@ -0,0 +1,214 @@
+package kafka
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// A record written to fakeWriter
+type produced struct {
+	topic string
+	key   string
+	value string
+	ts    string
+}
+
+// Stands in for a kafka.Writer, recording what's written to it
+type fakeWriter struct {
+	topic string
+	sink  *fakeWriters
+
+	closed bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.sink.mutex.Lock()
+	defer w.sink.mutex.Unlock()
+
+	if w.closed {
+		return io.ErrClosedPipe
+	}
+
+	for _, msg := range msgs {
+		ts := ""
+		for _, header := range msg.Headers {
+			if header.Key == TimestampHeader {
+				ts = string(header.Value)
+			}
+		}
+
+		w.sink.records = append(w.sink.records, produced{w.topic, string(msg.Key), string(msg.Value), ts})
+	}
+
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.sink.mutex.Lock()
+	defer w.sink.mutex.Unlock()
+
+	w.closed = true
+	return nil
+}
+
+// The fakeWriters a Sink has created
+type fakeWriters struct {
+	mutex   sync.Mutex
+	writers []*fakeWriter
+	records []produced
+}
+
+// Makes the sink write to fakeWriters
+func fake(s *Sink) *fakeWriters {
+	writers := &fakeWriters{}
+	s.newWriter = func(topic string) writer {
+		w := &fakeWriter{topic: topic, sink: writers}
+		writers.writers = append(writers.writers, w)
+		return w
+	}
+
+	return writers
+}
+
+func newSink(t *testing.T, opts Opts) *Sink {
+	sink, err := New(opts)
+	if err != nil {
+		t.Fatalf("Error creating sink: %s", err)
+	}
+
+	return sink
+}
+
+func TestSinkPublish(t *testing.T) {
+	sink := newSink(t, Opts{Brokers: []string{"kafka:9092"}, TopicPrefix: "otr."})
+	writers := fake(sink)
+
+	pubs := []*redispub.Publication{
+		{CollectionChannel: "db.tasks", SpecificChannel: "db.tasks::id1", Msg: []byte("1"), OplogTimestamp: 1},
+		{CollectionChannel: "db.tasks", SpecificChannel: "db.tasks::id2", Msg: []byte("2"), OplogTimestamp: 2},
+		{CollectionChannel: "db.other", SpecificChannel: "db.other::id1", Msg: []byte("3"), OplogTimestamp: 3},
+		{CollectionChannel: "db.tasks", SpecificChannel: "db.tasks::id1", Msg: []byte("4"), OplogTimestamp: 4},
+		{CollectionChannel: "otr.events", Msg: []byte("dead letter"), Meta: true},
+	}
+	for _, p := range pubs {
+		if err := sink.Publish(p); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+
+	expected := []produced{
+		{"otr.db.tasks", "id1", "1", "1"},
+		{"otr.db.tasks", "id2", "2", "2"},
+		{"otr.db.other", "id1", "3", "3"},
+		{"otr.db.tasks", "id1", "4", "4"},
+	}
+	if !reflect.DeepEqual(writers.records, expected) {
+		t.Errorf("Expected records %v, got %v", expected, writers.records)
+	}
+
+	// There's one writer per topic
+	if len(writers.writers) != 2 {
+		t.Errorf("Expected 2 writers, got %d", len(writers.writers))
+	}
+
+	sink.Close()
+	for _, w := range writers.writers {
+		if !w.closed {
+			t.Errorf("Writer for %s wasn't closed", w.topic)
+		}
+	}
+}
+
+// Writing is asynchronous, so Publish doesn't fail (or block) when Kafka is
+// unreachable
+func TestSinkBrokerDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	sink := newSink(t, Opts{Brokers: []string{addr}, Timeout: time.Second, BatchTimeout: 10 * time.Millisecond})
+
+	done := make(chan error)
+	go func() {
+		done <- sink.Publish(&redispub.Publication{CollectionChannel: "db.tasks", SpecificChannel: "db.tasks::id1"})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Got unexpected error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked")
+	}
+
+	sink.Close()
+}
+
+func TestNewSASL(t *testing.T) {
+	tests := map[string]struct {
+		opts          Opts
+		expectedError bool
+		expectedName  string
+	}{
+		"None": {
+			opts: Opts{},
+		},
+		"Plain": {
+			opts:         Opts{SASLMechanism: "plain", SASLUsername: "otr", SASLPassword: "secret"},
+			expectedName: "PLAIN",
+		},
+		"SCRAM-SHA-256": {
+			opts:         Opts{SASLMechanism: "scram-sha-256", SASLUsername: "otr", SASLPassword: "secret"},
+			expectedName: "SCRAM-SHA-256",
+		},
+		"SCRAM-SHA-512": {
+			opts:         Opts{SASLMechanism: "scram-sha-512", SASLUsername: "otr", SASLPassword: "secret"},
+			expectedName: "SCRAM-SHA-512",
+		},
+		"Missing username": {
+			opts:          Opts{SASLMechanism: "plain", SASLPassword: "secret"},
+			expectedError: true,
+		},
+		"Invalid mechanism": {
+			opts:          Opts{SASLMechanism: "gssapi", SASLUsername: "otr"},
+			expectedError: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			sink, err := New(test.opts)
+			if test.expectedError {
+				if err == nil {
+					t.Error("Expected an error, but didn't get one")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			mechanism := sink.dialer.SASLMechanism
+			if test.expectedName == "" {
+				if mechanism != nil {
+					t.Errorf("Expected no SASL mechanism, got %s", mechanism.Name())
+				}
+			} else if mechanism == nil || mechanism.Name() != test.expectedName {
+				t.Errorf("Expected SASL mechanism %s, got %v", test.expectedName, mechanism)
+			}
+		})
+	}
+}
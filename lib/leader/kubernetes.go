@@ -0,0 +1,456 @@
+package leader
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// Paths where Kubernetes mounts the pod's service account credentials
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// The format Kubernetes uses for MicroTime fields, such as a Lease's renewTime
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+var metricIsLeader = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "leader",
+	Name:      "is_leader",
+	Help:      "1 if this process currently holds leadership, 0 otherwise",
+})
+
+// KubernetesLease is an Elector that uses a Lease object from the Kubernetes
+// coordination API (coordination.k8s.io/v1) as a lock. It follows the same
+// protocol as the client-go leaderelection package, so it interoperates with
+// tools that inspect Lease objects.
+type KubernetesLease struct {
+	// Base URL of the Kubernetes API server, e.g. https://10.0.0.1:443
+	APIServer string
+
+	// Bearer token used to authenticate to the API server
+	Token string
+
+	// HTTP client used to talk to the API server
+	HTTPClient *http.Client
+
+	// Namespace and name of the Lease object
+	Namespace string
+	Name      string
+
+	// Identity of this process, recorded as the Lease's holderIdentity. It
+	// must be unique among all of the processes campaigning for the Lease.
+	Identity string
+
+	// LeaseDuration is how long other candidates wait after the last
+	// observed renewal before taking over leadership. It's recorded in the
+	// Lease as leaseDurationSeconds while we hold it, and candidates use the
+	// holder's recorded duration rather than their own, so candidates
+	// configured with different durations agree on when a Lease expires.
+	LeaseDuration time.Duration
+
+	// RenewDeadline is how long the leader keeps trying to renew the Lease
+	// before it gives up leadership.
+	RenewDeadline time.Duration
+
+	// RetryPeriod is how long to wait between attempts to acquire or renew
+	// the Lease.
+	RetryPeriod time.Duration
+
+	// The most recently observed lease, and the (local) time at which we
+	// observed its holder or renewTime change. A Lease expires
+	// leaseDurationSeconds after the later of this and the renewTime written
+	// by the holder (see expiry), so clock skew between candidates can only
+	// make us wait longer, never take over early.
+	observedLease *lease
+	observedTime  time.Time
+
+	stopRenew chan struct{}
+	renewDone chan struct{}
+	mutex     sync.Mutex
+}
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// NewInClusterKubernetesLease creates a KubernetesLease that talks to the
+// Kubernetes API server using the service account credentials that Kubernetes
+// mounts into every pod.
+//
+// If namespace is empty, the pod's own namespace is used. If identity is
+// empty, the pod's hostname is used.
+func NewInClusterKubernetesLease(namespace, name, identity string, leaseDuration, renewDeadline, retryPeriod time.Duration) (*KubernetesLease, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set to use Kubernetes leader election")
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountDir + "token")
+	if err != nil {
+		return nil, fmt.Errorf("Could not read service account token: %s", err)
+	}
+
+	caCert, err := ioutil.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("Could not read service account CA certificate: %s", err)
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("Could not parse service account CA certificate")
+	}
+
+	if namespace == "" {
+		namespaceBytes, nsErr := ioutil.ReadFile(serviceAccountDir + "namespace")
+		if nsErr != nil {
+			return nil, fmt.Errorf("Could not read service account namespace: %s", nsErr)
+		}
+		namespace = strings.TrimSpace(string(namespaceBytes))
+	}
+
+	if identity == "" {
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("Could not determine hostname to use as leader election identity: %s", err)
+		}
+	}
+
+	return &KubernetesLease{
+		APIServer: "https://" + net.JoinHostPort(host, port),
+		Token:     strings.TrimSpace(string(token)),
+		HTTPClient: &http.Client{
+			Timeout: renewDeadline,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: caPool},
+			},
+		},
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      identity,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+	}, nil
+}
+
+// Campaign blocks until we hold the Lease, and then renews it in the
+// background. See Elector.Campaign.
+func (k *KubernetesLease) Campaign() <-chan struct{} {
+	log.Log.Infow("Campaigning for leadership",
+		"namespace", k.Namespace,
+		"lease", k.Name,
+		"identity", k.Identity)
+
+	for {
+		acquired, err := k.tryAcquireOrRenew()
+		if err != nil {
+			log.Log.Errorw("Error trying to acquire leader election lease",
+				"error", err)
+		}
+
+		if acquired {
+			break
+		}
+
+		time.Sleep(k.RetryPeriod)
+	}
+
+	log.Log.Infow("Acquired leadership",
+		"namespace", k.Namespace,
+		"lease", k.Name,
+		"identity", k.Identity)
+	metricIsLeader.Set(1)
+
+	lost := make(chan struct{})
+
+	k.mutex.Lock()
+	k.stopRenew = make(chan struct{})
+	k.renewDone = make(chan struct{})
+	go k.renewLoop(lost, k.stopRenew, k.renewDone)
+	k.mutex.Unlock()
+
+	return lost
+}
+
+// Resign stops renewing the Lease and releases it. See Elector.Resign.
+func (k *KubernetesLease) Resign() error {
+	k.mutex.Lock()
+	stopRenew, renewDone := k.stopRenew, k.renewDone
+	k.stopRenew = nil
+	k.mutex.Unlock()
+
+	if stopRenew == nil {
+		// We never acquired leadership, or already resigned
+		return nil
+	}
+
+	close(stopRenew)
+	<-renewDone
+	metricIsLeader.Set(0)
+
+	current, err := k.get()
+	if err != nil {
+		return err
+	}
+
+	if current == nil || current.Spec.HolderIdentity != k.Identity {
+		// Someone else holds the lease already; nothing to release
+		return nil
+	}
+
+	// Releasing the lease the same way client-go does: clear the holder and
+	// set a minimal duration so other candidates can take over right away.
+	current.Spec.HolderIdentity = ""
+	current.Spec.LeaseDurationSeconds = 1
+	current.Spec.RenewTime = time.Now().UTC().Format(microTimeFormat)
+
+	_, err = k.update(current)
+	if err == nil {
+		log.Log.Infow("Released leadership",
+			"namespace", k.Namespace,
+			"lease", k.Name,
+			"identity", k.Identity)
+	}
+
+	return err
+}
+
+// Renews the Lease every RetryPeriod until told to stop. If we fail to renew
+// for longer than RenewDeadline, closes the lost channel and returns.
+func (k *KubernetesLease) renewLoop(lost chan<- struct{}, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(k.RetryPeriod):
+		}
+
+		renewed, err := k.tryAcquireOrRenew()
+		if err != nil {
+			log.Log.Errorw("Error renewing leader election lease",
+				"error", err)
+		}
+
+		if renewed {
+			lastRenew = time.Now()
+			continue
+		}
+
+		if time.Since(lastRenew) > k.RenewDeadline {
+			log.Log.Errorw("Failed to renew leader election lease before the deadline; giving up leadership",
+				"namespace", k.Namespace,
+				"lease", k.Name,
+				"identity", k.Identity)
+			metricIsLeader.Set(0)
+			close(lost)
+			return
+		}
+	}
+}
+
+// Makes a single attempt to acquire the Lease (if nobody holds it or the
+// holder's lease has expired) or renew it (if we already hold it). Returns
+// whether we hold the Lease after this attempt.
+func (k *KubernetesLease) tryAcquireOrRenew() (bool, error) {
+	now := time.Now()
+	nowString := now.UTC().Format(microTimeFormat)
+	leaseDurationSeconds := int(k.LeaseDuration.Seconds())
+	if leaseDurationSeconds < 1 {
+		leaseDurationSeconds = 1
+	}
+
+	current, err := k.get()
+	if err != nil {
+		return false, err
+	}
+
+	if current == nil {
+		// Nobody has created the lease yet; create it with ourselves as the
+		// holder.
+		created, createErr := k.create(&lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata: leaseMetadata{
+				Name:      k.Name,
+				Namespace: k.Namespace,
+			},
+			Spec: leaseSpec{
+				HolderIdentity:       k.Identity,
+				LeaseDurationSeconds: leaseDurationSeconds,
+				AcquireTime:          nowString,
+				RenewTime:            nowString,
+			},
+		})
+		if createErr != nil || created == nil {
+			return false, createErr
+		}
+
+		k.observe(created, now)
+		return true, nil
+	}
+
+	k.observe(current, now)
+
+	held := current.Spec.HolderIdentity != ""
+	heldByUs := current.Spec.HolderIdentity == k.Identity
+	expired := k.expiry(current).Before(now)
+	if held && !heldByUs && !expired {
+		return false, nil
+	}
+
+	if !heldByUs {
+		current.Spec.AcquireTime = nowString
+		current.Spec.LeaseTransitions++
+	}
+	current.Spec.HolderIdentity = k.Identity
+	current.Spec.LeaseDurationSeconds = leaseDurationSeconds
+	current.Spec.RenewTime = nowString
+
+	updated, err := k.update(current)
+	if err != nil || updated == nil {
+		return false, err
+	}
+
+	k.observe(updated, now)
+	return true, nil
+}
+
+// Records the lease we've seen, updating observedTime if it changed
+func (k *KubernetesLease) observe(l *lease, now time.Time) {
+	if k.observedLease == nil ||
+		k.observedLease.Spec.HolderIdentity != l.Spec.HolderIdentity ||
+		k.observedLease.Spec.RenewTime != l.Spec.RenewTime {
+		k.observedTime = now
+	}
+
+	k.observedLease = l
+}
+
+// Returns when a Lease we've observed expires: the holder's recorded
+// leaseDurationSeconds (or our own LeaseDuration, if it didn't record one)
+// after it was last renewed, according to both its renewTime and the time we
+// observed the renewal
+func (k *KubernetesLease) expiry(l *lease) time.Time {
+	duration := time.Duration(l.Spec.LeaseDurationSeconds) * time.Second
+	if duration <= 0 {
+		duration = k.LeaseDuration
+	}
+
+	renewed := k.observedTime
+	renewTime, err := time.Parse(microTimeFormat, l.Spec.RenewTime)
+	if err == nil && renewTime.After(renewed) {
+		renewed = renewTime
+	}
+
+	return renewed.Add(duration)
+}
+
+func (k *KubernetesLease) leasesURL() string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+		strings.TrimSuffix(k.APIServer, "/"), k.Namespace)
+}
+
+// Fetches the Lease. Returns nil (and no error) if it does not exist.
+func (k *KubernetesLease) get() (*lease, error) {
+	return k.do("GET", k.leasesURL()+"/"+k.Name, nil)
+}
+
+// Creates the Lease. Returns nil (and no error) if someone else created it
+// first.
+func (k *KubernetesLease) create(l *lease) (*lease, error) {
+	return k.do("POST", k.leasesURL(), l)
+}
+
+// Updates the Lease. Returns nil (and no error) if the Lease was modified
+// since we read it.
+func (k *KubernetesLease) update(l *lease) (*lease, error) {
+	return k.do("PUT", k.leasesURL()+"/"+k.Name, l)
+}
+
+// Sends a request to the API server. Not-found and conflict responses are
+// expected during normal operation, and are reported as a nil lease with no
+// error.
+func (k *KubernetesLease) do(method string, url string, body *lease) (*lease, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if k.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+
+	httpClient := k.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var result lease
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding Lease from API server: %s", err)
+		}
+		return &result, nil
+
+	case http.StatusNotFound, http.StatusConflict:
+		return nil, nil
+
+	default:
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Unexpected response from API server for %s %s: %s: %s",
+			method, url, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+}
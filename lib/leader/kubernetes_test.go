@@ -0,0 +1,280 @@
+package leader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A minimal fake of the Kubernetes API server that stores a single Lease,
+// enforcing optimistic concurrency on resourceVersion the same way the real
+// API server does.
+type fakeLeaseServer struct {
+	mutex           sync.Mutex
+	lease           *lease
+	resourceVersion int
+	failRequests    bool
+}
+
+func (f *fakeLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.failRequests {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/apis/coordination.k8s.io/v1/namespaces/ns/leases") {
+		http.Error(w, "bad path "+r.URL.Path, http.StatusBadRequest)
+		return
+	}
+
+	var body lease
+	if r.Method != "GET" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.Method {
+	case "GET":
+		if f.lease == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+	case "POST":
+		if f.lease != nil {
+			http.Error(w, "already exists", http.StatusConflict)
+			return
+		}
+		f.store(&body)
+		w.WriteHeader(http.StatusCreated)
+	case "PUT":
+		if f.lease == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if body.Metadata.ResourceVersion != f.lease.Metadata.ResourceVersion {
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		f.store(&body)
+	}
+
+	_ = json.NewEncoder(w).Encode(f.lease)
+}
+
+func (f *fakeLeaseServer) store(l *lease) {
+	f.resourceVersion++
+	l.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+	f.lease = l
+}
+
+func (f *fakeLeaseServer) holder() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.lease == nil {
+		return ""
+	}
+	return f.lease.Spec.HolderIdentity
+}
+
+func (f *fakeLeaseServer) setFailing(failing bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failRequests = failing
+}
+
+func newTestLease(server *httptest.Server, identity string) *KubernetesLease {
+	return &KubernetesLease{
+		APIServer:     server.URL,
+		Namespace:     "ns",
+		Name:          "oplogtoredis",
+		Identity:      identity,
+		LeaseDuration: 300 * time.Millisecond,
+		RenewDeadline: 200 * time.Millisecond,
+		RetryPeriod:   20 * time.Millisecond,
+	}
+}
+
+// Runs Campaign in the background, returning a channel that receives the
+// lost channel once leadership is acquired
+func campaignAsync(k *KubernetesLease) <-chan (<-chan struct{}) {
+	result := make(chan (<-chan struct{}), 1)
+	go func() {
+		result <- k.Campaign()
+	}()
+	return result
+}
+
+func TestKubernetesLeaseAcquireWhenMissing(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	elector := newTestLease(server, "a")
+	elector.Campaign()
+	defer elector.Resign()
+
+	if fake.holder() != "a" {
+		t.Errorf("Expected lease to be held by \"a\", got \"%s\"", fake.holder())
+	}
+}
+
+func TestKubernetesLeaseWaitsForHolder(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	first := newTestLease(server, "a")
+	first.Campaign()
+
+	second := newTestLease(server, "b")
+	secondAcquired := campaignAsync(second)
+
+	select {
+	case <-secondAcquired:
+		t.Fatalf("Second candidate acquired leadership while the first still held it")
+	case <-time.After(2 * first.LeaseDuration):
+	}
+
+	if err := first.Resign(); err != nil {
+		t.Fatalf("Unexpected error resigning: %s", err)
+	}
+
+	select {
+	case <-secondAcquired:
+	case <-time.After(2 * first.LeaseDuration):
+		t.Fatalf("Second candidate did not acquire leadership after the first resigned")
+	}
+	defer second.Resign()
+
+	if fake.holder() != "b" {
+		t.Errorf("Expected lease to be held by \"b\", got \"%s\"", fake.holder())
+	}
+}
+
+func TestKubernetesLeaseTakesOverExpiredLease(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	fake.store(&lease{
+		Metadata: leaseMetadata{Name: "oplogtoredis", Namespace: "ns"},
+		Spec: leaseSpec{
+			HolderIdentity: "dead",
+			RenewTime:      time.Now().UTC().Format(microTimeFormat),
+		},
+	})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	elector := newTestLease(server, "a")
+	start := time.Now()
+	elector.Campaign()
+	defer elector.Resign()
+
+	if time.Since(start) < elector.LeaseDuration {
+		t.Errorf("Took over lease after %s, before it expired", time.Since(start))
+	}
+
+	if fake.holder() != "a" {
+		t.Errorf("Expected lease to be held by \"a\", got \"%s\"", fake.holder())
+	}
+}
+
+func TestKubernetesLeaseUsesHoldersLeaseDuration(t *testing.T) {
+	// The holder records a longer lease duration than ours, so we must wait
+	// for its duration, not our own, before taking over
+	fake := &fakeLeaseServer{}
+	fake.store(&lease{
+		Metadata: leaseMetadata{Name: "oplogtoredis", Namespace: "ns"},
+		Spec: leaseSpec{
+			HolderIdentity:       "dead",
+			LeaseDurationSeconds: 1,
+			RenewTime:            time.Now().UTC().Format(microTimeFormat),
+		},
+	})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	elector := newTestLease(server, "a")
+	start := time.Now()
+	elector.Campaign()
+	defer elector.Resign()
+
+	if time.Since(start) < time.Second {
+		t.Errorf("Took over lease after %s, before the holder's lease duration of 1s", time.Since(start))
+	}
+
+	if fake.holder() != "a" {
+		t.Errorf("Expected lease to be held by \"a\", got \"%s\"", fake.holder())
+	}
+}
+
+func TestKubernetesLeaseExpiry(t *testing.T) {
+	observed := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := map[string]struct {
+		spec     leaseSpec
+		expected time.Time
+	}{
+		"Holder's duration": {
+			spec:     leaseSpec{LeaseDurationSeconds: 60, RenewTime: observed.Format(microTimeFormat)},
+			expected: observed.Add(time.Minute),
+		},
+		"No recorded duration": {
+			spec:     leaseSpec{RenewTime: observed.Format(microTimeFormat)},
+			expected: observed.Add(15 * time.Second),
+		},
+		"Holder's clock ahead": {
+			spec:     leaseSpec{LeaseDurationSeconds: 60, RenewTime: observed.Add(10 * time.Second).Format(microTimeFormat)},
+			expected: observed.Add(70 * time.Second),
+		},
+		"Holder's clock behind": {
+			spec:     leaseSpec{LeaseDurationSeconds: 60, RenewTime: observed.Add(-10 * time.Second).Format(microTimeFormat)},
+			expected: observed.Add(time.Minute),
+		},
+		"Malformed renew time": {
+			spec:     leaseSpec{LeaseDurationSeconds: 60, RenewTime: "yesterday"},
+			expected: observed.Add(time.Minute),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			k := &KubernetesLease{LeaseDuration: 15 * time.Second, observedTime: observed}
+			if got := k.expiry(&lease{Spec: test.spec}); !got.Equal(test.expected) {
+				t.Errorf("Got expiry %s, expected %s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestKubernetesLeaseLostAfterRenewDeadline(t *testing.T) {
+	fake := &fakeLeaseServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	elector := newTestLease(server, "a")
+	lost := elector.Campaign()
+
+	select {
+	case <-lost:
+		t.Fatalf("Lost leadership while API server was healthy")
+	case <-time.After(2 * elector.RenewDeadline):
+	}
+
+	fake.setFailing(true)
+
+	select {
+	case <-lost:
+	case <-time.After(4 * elector.RenewDeadline):
+		t.Fatalf("Did not lose leadership after renewals failed")
+	}
+}
@@ -0,0 +1,20 @@
+// Package leader implements leader election between multiple running copies of
+// oplogtoredis. When leader election is enabled, only the copy that currently
+// holds leadership tails the oplog and publishes to Redis; the other copies
+// wait on standby and take over if the leader goes away.
+package leader
+
+// Elector campaigns for leadership on behalf of this process.
+type Elector interface {
+	// Campaign blocks until this process becomes the leader. It returns a
+	// channel that is closed if leadership is subsequently lost (for example,
+	// because we could not renew it before it expired). Once the returned
+	// channel is closed, the process must stop doing work that only the leader
+	// is allowed to do.
+	Campaign() <-chan struct{}
+
+	// Resign gives up leadership (if we hold it) so that another process can
+	// take over immediately, rather than waiting for our leadership to
+	// expire.
+	Resign() error
+}
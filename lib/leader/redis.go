@@ -0,0 +1,227 @@
+package leader
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// Sets KEYS[1] to ARGV[1], expiring after ARGV[2] milliseconds, if it isn't
+// already set. Returns whether it was set.
+var acquireRedisLock = redis.NewScript(`
+	if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+		return 1
+	end
+
+	return 0
+`)
+
+// Extends the expiration of KEYS[1] to ARGV[2] milliseconds, if it's set to
+// ARGV[1]. Returns whether it was extended.
+var renewRedisLock = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		return 1
+	end
+
+	return 0
+`)
+
+// Deletes KEYS[1] if it's set to ARGV[1]
+var releaseRedisLock = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		redis.call("DEL", KEYS[1])
+	end
+
+	return 0
+`)
+
+// RedisLock is an Elector that uses a key in Redis as a lock. The leader
+// holds the key, which expires after LeaseDuration unless the leader renews
+// it. This lets copies of oplogtoredis elect a leader without Kubernetes.
+//
+// The leader gives up leadership if it can't renew the lock for
+// RenewDeadline, which is shorter than LeaseDuration. So as long as clocks
+// run at about the same rate, the old leader has stopped before the lock
+// expires and a new leader can acquire it. There's no fencing token (it
+// couldn't be checked when publishing to a Redis Cluster, where the lock and
+// the keys we publish with are in different slots), so the leader must stop
+// publishing as soon as the channel returned by Campaign is closed, rather
+// than finishing or retrying what it's publishing.
+type RedisLock struct {
+	Client redis.UniversalClient
+
+	// The key to use as the lock
+	Key string
+
+	// Identity of this process, for logging. The lock's value is the
+	// identity plus a random suffix, so that a restarted process with the
+	// same identity doesn't think it still holds the lock.
+	Identity string
+
+	// LeaseDuration is how long the lock lasts without being renewed.
+	LeaseDuration time.Duration
+
+	// RenewDeadline is how long the leader keeps trying to renew the lock
+	// before it gives up leadership.
+	RenewDeadline time.Duration
+
+	// RetryPeriod is how long to wait between attempts to acquire or renew
+	// the lock.
+	RetryPeriod time.Duration
+
+	value     string
+	stopRenew chan struct{}
+	renewDone chan struct{}
+	mutex     sync.Mutex
+}
+
+// NewRedisLock creates a RedisLock. If identity is empty, the hostname is
+// used.
+func NewRedisLock(client redis.UniversalClient, key, identity string, leaseDuration, renewDeadline, retryPeriod time.Duration) (*RedisLock, error) {
+	if identity == "" {
+		var err error
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("Could not determine hostname to use as leader election identity: %s", err)
+		}
+	}
+
+	suffix := make([]byte, 8)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return nil, fmt.Errorf("Could not generate leader election lock value: %s", err)
+	}
+
+	return &RedisLock{
+		Client:        client,
+		Key:           key,
+		Identity:      identity,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		value:         identity + "::" + hex.EncodeToString(suffix),
+	}, nil
+}
+
+// Campaign blocks until we hold the lock, and then renews it in the
+// background. See Elector.Campaign.
+func (r *RedisLock) Campaign() <-chan struct{} {
+	log.Log.Infow("Campaigning for leadership",
+		"key", r.Key,
+		"identity", r.Identity)
+
+	for {
+		acquired, err := r.run(acquireRedisLock)
+		if err != nil {
+			log.Log.Errorw("Error trying to acquire leader election lock",
+				"error", err)
+		}
+
+		if acquired {
+			break
+		}
+
+		time.Sleep(r.RetryPeriod)
+	}
+
+	log.Log.Infow("Acquired leadership",
+		"key", r.Key,
+		"identity", r.Identity)
+	metricIsLeader.Set(1)
+
+	lost := make(chan struct{})
+
+	r.mutex.Lock()
+	r.stopRenew = make(chan struct{})
+	r.renewDone = make(chan struct{})
+	go r.renewLoop(lost, r.stopRenew, r.renewDone)
+	r.mutex.Unlock()
+
+	return lost
+}
+
+// Resign stops renewing the lock and releases it. See Elector.Resign.
+func (r *RedisLock) Resign() error {
+	r.mutex.Lock()
+	stopRenew, renewDone := r.stopRenew, r.renewDone
+	r.stopRenew = nil
+	r.mutex.Unlock()
+
+	if stopRenew == nil {
+		// We never acquired leadership, or already resigned
+		return nil
+	}
+
+	close(stopRenew)
+	<-renewDone
+	metricIsLeader.Set(0)
+
+	_, err := r.run(releaseRedisLock)
+	if err == nil {
+		log.Log.Infow("Released leadership",
+			"key", r.Key,
+			"identity", r.Identity)
+	}
+
+	return err
+}
+
+// Renews the lock every RetryPeriod until told to stop. If the lock is lost,
+// or we fail to renew it for longer than RenewDeadline, closes the lost
+// channel and returns.
+func (r *RedisLock) renewLoop(lost chan<- struct{}, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(r.RetryPeriod):
+		}
+
+		renewed, err := r.run(renewRedisLock)
+		if err != nil {
+			log.Log.Errorw("Error renewing leader election lock",
+				"error", err)
+
+			if time.Since(lastRenew) <= r.RenewDeadline {
+				continue
+			}
+		} else if renewed {
+			lastRenew = time.Now()
+			continue
+		}
+
+		// Either we couldn't renew the lock before the deadline, or someone
+		// else holds it
+		log.Log.Errorw("Lost leader election lock; giving up leadership",
+			"key", r.Key,
+			"identity", r.Identity)
+		metricIsLeader.Set(0)
+		close(lost)
+		return
+	}
+}
+
+// Runs one of the lock scripts, and returns whether it succeeded
+func (r *RedisLock) run(script *redis.Script) (bool, error) {
+	result, err := script.Run(
+		r.Client,
+		[]string{r.Key},
+		r.value,
+		int64(r.LeaseDuration/time.Millisecond),
+	).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return result == int64(1), nil
+}
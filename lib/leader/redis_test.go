@@ -0,0 +1,102 @@
+package leader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+)
+
+func newTestRedisLock(t *testing.T, client redis.UniversalClient, identity string) *RedisLock {
+	lock, err := NewRedisLock(client, "oplogtoredis::leader::test", identity,
+		time.Second, 500*time.Millisecond, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	return lock
+}
+
+func TestRedisLockHandover(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer redisServer.Close()
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisServer.Addr()},
+	})
+
+	first := newTestRedisLock(t, client, "first")
+	second := newTestRedisLock(t, client, "second")
+
+	first.Campaign()
+	if ttl := redisServer.TTL(first.Key); ttl != time.Second {
+		t.Errorf("Got lock TTL %s, expected the lease duration", ttl)
+	}
+
+	secondLeading := make(chan struct{})
+	go func() {
+		second.Campaign()
+		close(secondLeading)
+	}()
+
+	select {
+	case <-secondLeading:
+		t.Fatal("Second candidate became leader while the first held the lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := first.Resign(); err != nil {
+		t.Fatalf("Got unexpected error resigning: %s", err)
+	}
+
+	select {
+	case <-secondLeading:
+	case <-time.After(time.Second):
+		t.Fatal("Second candidate didn't become leader after the first resigned")
+	}
+
+	if err := second.Resign(); err != nil {
+		t.Fatalf("Got unexpected error resigning: %s", err)
+	}
+	if redisServer.Exists(second.Key) {
+		t.Errorf("Lock still held after resigning")
+	}
+}
+
+func TestRedisLockLost(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer redisServer.Close()
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisServer.Addr()},
+	})
+
+	lock := newTestRedisLock(t, client, "first")
+	lost := lock.Campaign()
+
+	// Simulate the lock expiring and another process taking it
+	err = redisServer.Set(lock.Key, "someone else")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("Leadership wasn't lost after another process took the lock")
+	}
+
+	if err := lock.Resign(); err != nil {
+		t.Fatalf("Got unexpected error resigning: %s", err)
+	}
+	if got, _ := redisServer.Get(lock.Key); got != "someone else" {
+		t.Errorf("Resigning released another process's lock")
+	}
+}
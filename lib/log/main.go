@@ -3,10 +3,13 @@
 package log
 
 import (
+	"fmt"
 	golog "log"
 	"os"
+	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // Log is a zap Sugared logger (a logger with a convenient API). You'll almost
@@ -19,6 +22,18 @@ var RawLog *zap.Logger
 
 // Initialize Log and RawLog
 func init() {
+	var err error
+	RawLog, err = defaultConfig().Build()
+	if err != nil {
+		golog.Print(err)
+		panic("Unable to create a logger")
+	}
+
+	Log = RawLog.Sugar()
+}
+
+// Returns the logger configuration to use until Configure is called
+func defaultConfig() zap.Config {
 	var logConfig zap.Config
 
 	// The OPLOGTOREDIS_LOG_DEBUG flag controls development vs production config.
@@ -47,14 +62,82 @@ func init() {
 		logConfig.Level.SetLevel(zap.PanicLevel)
 	}
 
-	var err error
-	RawLog, err = logConfig.Build()
+	return logConfig
+}
+
+// Opts overrides parts of the default logger configuration. Zero values
+// leave the default alone.
+type Opts struct {
+	// The minimum level to log: "debug", "info", "warn", or "error"
+	Level string
+
+	// How to encode log lines: "json", or "console" for a human-friendly
+	// format
+	Format string
+
+	// Sampling: each second, only the first SamplingInitial lines with a
+	// given level and message are logged, and then every
+	// SamplingThereafter'th one (every 100th if it isn't set).
+	// SamplingInitial of -1 disables sampling.
+	SamplingInitial    int
+	SamplingThereafter int
+
+	// If set, warnings and errors with the same message are logged at most
+	// once per Throttle. See NewThrottledCore.
+	Throttle time.Duration
+}
+
+// Configure replaces Log and RawLog with loggers configured by opts. It's
+// meant to be called once at startup, before anything else is logging.
+// OTR_LOG_QUIET still silences everything.
+func Configure(opts Opts) error {
+	logConfig := defaultConfig()
+
+	if opts.Level != "" && os.Getenv("OTR_LOG_QUIET") == "" {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return fmt.Errorf("Invalid log level %q: %s", opts.Level, err)
+		}
+		logConfig.Level.SetLevel(level)
+	}
+
+	switch opts.Format {
+	case "":
+	case "json", "console":
+		logConfig.Encoding = opts.Format
+	default:
+		return fmt.Errorf("Invalid log format %q: must be json or console", opts.Format)
+	}
+
+	if opts.SamplingInitial < 0 {
+		logConfig.Sampling = nil
+	} else if opts.SamplingInitial > 0 {
+		thereafter := opts.SamplingThereafter
+		if thereafter <= 0 {
+			thereafter = 100
+		}
+
+		logConfig.Sampling = &zap.SamplingConfig{
+			Initial:    opts.SamplingInitial,
+			Thereafter: thereafter,
+		}
+	}
+
+	var buildOpts []zap.Option
+	if opts.Throttle > 0 {
+		buildOpts = append(buildOpts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return NewThrottledCore(core, opts.Throttle)
+		}))
+	}
+
+	rawLog, err := logConfig.Build(buildOpts...)
 	if err != nil {
-		golog.Print(err)
-		panic("Unable to create a logger")
+		return fmt.Errorf("Error creating logger: %s", err)
 	}
 
-	Log = RawLog.Sugar()
+	RawLog = rawLog
+	Log = rawLog.Sugar()
+	return nil
 }
 
 // Sync writes the log to its output stream (typically stdout/stderr). This should
@@ -0,0 +1,47 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigure(t *testing.T) {
+	rawLog, sugared := RawLog, Log
+	defer func() {
+		RawLog, Log = rawLog, sugared
+	}()
+
+	err := Configure(Opts{
+		Level:              "warn",
+		Format:             "console",
+		SamplingInitial:    10,
+		SamplingThereafter: 10,
+		Throttle:           time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Error configuring logger: %s", err)
+	}
+
+	if RawLog == rawLog {
+		t.Errorf("Expected Configure to replace RawLog")
+	}
+	if RawLog.Core().Enabled(-1) {
+		t.Errorf("Expected debug logs to be disabled at the warn level")
+	}
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	rawLog, sugared := RawLog, Log
+	defer func() {
+		RawLog, Log = rawLog, sugared
+	}()
+
+	for name, opts := range map[string]Opts{
+		"level":  {Level: "loud"},
+		"format": {Format: "xml"},
+	} {
+		if err := Configure(opts); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package log
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The most messages a throttled core keeps track of before it forgets the
+// ones it hasn't seen for a whole interval
+const maxThrottledMessages = 1000
+
+// A zapcore.Core that logs warnings and errors with the same level and
+// message at most once per interval. Unlike sampling, which resets every
+// second, this quiets lines that repeat slowly but endlessly, like the
+// errors from reconnecting to a server that's down. The next line to be
+// logged says how many were suppressed before it.
+type throttledCore struct {
+	zapcore.Core
+
+	interval time.Duration
+
+	// Shared by the cores made by With, since they log the same messages
+	state *throttleState
+}
+
+type throttleState struct {
+	mutex    sync.Mutex
+	messages map[throttleKey]*throttledMessage
+}
+
+type throttleKey struct {
+	level   zapcore.Level
+	message string
+}
+
+type throttledMessage struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+// NewThrottledCore wraps a core so that warnings and errors with the same
+// level and message are logged at most once per interval. Lines that are
+// logged after others were suppressed have a "suppressed" field with how
+// many.
+func NewThrottledCore(core zapcore.Core, interval time.Duration) zapcore.Core {
+	return &throttledCore{
+		Core:     core,
+		interval: interval,
+		state:    &throttleState{messages: map[throttleKey]*throttledMessage{}},
+	}
+}
+
+func (c *throttledCore) With(fields []zapcore.Field) zapcore.Core {
+	return &throttledCore{
+		Core:     c.Core.With(fields),
+		interval: c.interval,
+		state:    c.state,
+	}
+}
+
+func (c *throttledCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.Level < zapcore.WarnLevel || !c.Enabled(ent.Level) {
+		return c.Core.Check(ent, ce)
+	}
+
+	suppressed, ok := c.state.allow(throttleKey{level: ent.Level, message: ent.Message}, ent.Time, c.interval)
+	if !ok {
+		return ce
+	}
+
+	if suppressed > 0 {
+		return c.Core.With([]zapcore.Field{zap.Int("suppressed", suppressed)}).Check(ent, ce)
+	}
+
+	return c.Core.Check(ent, ce)
+}
+
+// Returns whether a message should be logged at the given time, and if so,
+// how many times it was suppressed since it was last logged
+func (s *throttleState) allow(key throttleKey, now time.Time, interval time.Duration) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	msg, ok := s.messages[key]
+	if ok && now.Sub(msg.lastLogged) < interval {
+		msg.suppressed++
+		return 0, false
+	}
+
+	if !ok && len(s.messages) >= maxThrottledMessages {
+		s.forget(now, interval)
+	}
+
+	suppressed := 0
+	if ok {
+		suppressed = msg.suppressed
+	}
+	s.messages[key] = &throttledMessage{lastLogged: now}
+
+	return suppressed, true
+}
+
+// Forgets the messages that weren't logged within the last interval. Their
+// suppressed counts are lost, but they'd be logged next time anyway.
+func (s *throttleState) forget(now time.Time, interval time.Duration) {
+	for key, msg := range s.messages {
+		if now.Sub(msg.lastLogged) >= interval {
+			delete(s.messages, key)
+		}
+	}
+}
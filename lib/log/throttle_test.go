@@ -0,0 +1,124 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Returns a throttled core that writes JSON lines to the returned buffer
+func throttledTestCore(interval time.Duration) (zapcore.Core, *bytes.Buffer) {
+	var buf bytes.Buffer
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	core := zapcore.NewCore(encoder, zapcore.AddSync(&buf), zapcore.DebugLevel)
+
+	return NewThrottledCore(core, interval), &buf
+}
+
+// Logs an entry through a core, as a Logger would
+func logEntry(core zapcore.Core, level zapcore.Level, message string, t time.Time) {
+	ent := zapcore.Entry{Level: level, Message: message, Time: t}
+	if ce := core.Check(ent, nil); ce != nil {
+		ce.Write()
+	}
+}
+
+// Returns the lines written to a buffer
+func loggedLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("Error decoding log line %q: %s", line, err)
+		}
+		lines = append(lines, decoded)
+	}
+
+	return lines
+}
+
+func TestThrottledCore(t *testing.T) {
+	core, buf := throttledTestCore(10 * time.Second)
+	start := time.Unix(1526648511, 0)
+
+	logEntry(core, zapcore.ErrorLevel, "Error connecting", start)
+	logEntry(core, zapcore.ErrorLevel, "Error connecting", start.Add(time.Second))
+	logEntry(core, zapcore.ErrorLevel, "Error connecting", start.Add(2*time.Second))
+	logEntry(core, zapcore.ErrorLevel, "Something else", start.Add(3*time.Second))
+	logEntry(core, zapcore.WarnLevel, "Error connecting", start.Add(4*time.Second))
+	logEntry(core, zapcore.ErrorLevel, "Error connecting", start.Add(10*time.Second))
+
+	lines := loggedLines(t, buf)
+
+	expected := []struct {
+		level      string
+		message    string
+		suppressed float64
+	}{
+		{"error", "Error connecting", 0},
+		{"error", "Something else", 0},
+		{"warn", "Error connecting", 0},
+		{"error", "Error connecting", 2},
+	}
+
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d: %s", len(expected), len(lines), buf.String())
+	}
+
+	for i, want := range expected {
+		line := lines[i]
+		suppressed, _ := line["suppressed"].(float64)
+		if line["level"] != want.level || line["msg"] != want.message || suppressed != want.suppressed {
+			t.Errorf("Line %d: expected %s %q with %v suppressed, got %v", i, want.level, want.message, want.suppressed, line)
+		}
+	}
+}
+
+func TestThrottledCoreInfo(t *testing.T) {
+	core, buf := throttledTestCore(10 * time.Second)
+	start := time.Unix(1526648511, 0)
+
+	for i := 0; i < 3; i++ {
+		logEntry(core, zapcore.InfoLevel, "Received oplog entry", start)
+	}
+
+	if lines := loggedLines(t, buf); len(lines) != 3 {
+		t.Errorf("Expected info lines not to be throttled, got %d lines", len(lines))
+	}
+}
+
+func TestThrottledCoreWith(t *testing.T) {
+	core, buf := throttledTestCore(10 * time.Second)
+	start := time.Unix(1526648511, 0)
+
+	logEntry(core.With([]zapcore.Field{zap.String("shard", "a")}), zapcore.ErrorLevel, "Error connecting", start)
+	logEntry(core.With([]zapcore.Field{zap.String("shard", "b")}), zapcore.ErrorLevel, "Error connecting", start)
+
+	if lines := loggedLines(t, buf); len(lines) != 1 {
+		t.Errorf("Expected loggers made with With to share throttling, got %d lines", len(lines))
+	}
+}
+
+func TestThrottleStateForgets(t *testing.T) {
+	state := &throttleState{messages: map[throttleKey]*throttledMessage{}}
+	start := time.Unix(1526648511, 0)
+
+	for i := 0; i < maxThrottledMessages; i++ {
+		state.allow(throttleKey{message: strings.Repeat("x", i)}, start, time.Second)
+	}
+
+	state.allow(throttleKey{message: "new"}, start.Add(time.Minute), time.Second)
+
+	if len(state.messages) != 1 {
+		t.Errorf("Expected the stale messages to be forgotten, got %d messages", len(state.messages))
+	}
+}
@@ -0,0 +1,180 @@
+// Package mongodb connects to Mongo with the official Go driver, and bridges
+// between the driver and the mgo/bson types that oplogtoredis uses for
+// documents everywhere else: queries and commands built with mgo/bson are
+// marshalled with it and handed to the driver as raw BSON, and the raw
+// documents the driver returns are unmarshalled with it.
+package mongodb
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/globalsign/mgo/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Connect connects to Mongo with opts (see mongourl.Parse), and pings the
+// server to check that it's reachable, like mgo.DialWithInfo did. The caller
+// must call Disconnect on the client when done.
+func Connect(opts *options.ClientOptions) (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Ping(context.Background(), nil)
+	if err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Doc wraps a query, command, or other document built with mgo/bson types
+// (like bson.M, bson.D, bson.ObjectId, and bson.MongoTimestamp) so that the
+// driver marshals it with mgo/bson. A nil doc is an empty document.
+func Doc(doc interface{}) interface{} {
+	if doc == nil {
+		doc = bson.D{}
+	}
+
+	return mgoDoc{doc: doc}
+}
+
+type mgoDoc struct {
+	doc interface{}
+}
+
+// MarshalBSON implements the driver's bson.Marshaler
+func (d mgoDoc) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(d.doc)
+}
+
+// Unmarshal unmarshals a raw document returned by the driver into result
+// with mgo/bson. result may be a *bson.Raw. The driver reuses its buffers, so
+// we unmarshal a copy of data.
+func Unmarshal(data []byte, result interface{}) error {
+	data = append([]byte(nil), data...)
+
+	if raw, ok := result.(*bson.Raw); ok {
+		*raw = bson.Raw{Kind: 3, Data: data}
+		return nil
+	}
+
+	return bson.Unmarshal(data, result)
+}
+
+// FindOne unmarshals the first document in coll that matches filter (built
+// with mgo/bson types) into result. It returns mongo.ErrNoDocuments if no
+// document matches.
+func FindOne(coll *mongo.Collection, filter interface{}, result interface{}, opts ...*options.FindOneOptions) error {
+	data, err := coll.FindOne(context.Background(), Doc(filter), opts...).DecodeBytes()
+	if err != nil {
+		return err
+	}
+
+	return Unmarshal(data, result)
+}
+
+// Run runs a command (built with mgo/bson types, like bson.D) against db,
+// and unmarshals the response into result, unless it's nil.
+func Run(db *mongo.Database, cmd interface{}, result interface{}) error {
+	data, err := db.RunCommand(context.Background(), Doc(cmd)).DecodeBytes()
+	if err != nil || result == nil {
+		return err
+	}
+
+	return Unmarshal(data, result)
+}
+
+// Iter is a cursor that unmarshals each document with mgo/bson. It has the
+// same semantics as an *mgo.Iter: Next returns false once there are no more
+// documents or there's an error, and with a tailable cursor (see NewTailIter)
+// it also returns false, with Timeout returning true, when no documents
+// arrive within the cursor's MaxAwaitTime. Next can then be called again to
+// keep waiting.
+type Iter struct {
+	cursor   *mongo.Cursor
+	tailable bool
+	timedOut bool
+	err      error
+}
+
+// NewIter wraps the cursor returned by Find or Aggregate, or the error they
+// returned instead, which Err then returns.
+func NewIter(cursor *mongo.Cursor, err error) *Iter {
+	return &Iter{cursor: cursor, err: err}
+}
+
+// NewTailIter is like NewIter, for a tailable cursor (see
+// options.FindOptions.SetCursorType)
+func NewTailIter(cursor *mongo.Cursor, err error) *Iter {
+	return &Iter{cursor: cursor, tailable: true, err: err}
+}
+
+// Next unmarshals the next document into result (see Unmarshal), and returns
+// whether there was one
+func (i *Iter) Next(result interface{}) bool {
+	i.timedOut = false
+	if i.err != nil || i.cursor == nil {
+		return false
+	}
+
+	var ok bool
+	if i.tailable {
+		ok = i.cursor.TryNext(context.Background())
+	} else {
+		ok = i.cursor.Next(context.Background())
+	}
+
+	if !ok {
+		i.err = i.cursor.Err()
+		i.timedOut = i.err == nil && i.tailable && i.cursor.ID() != 0
+		return false
+	}
+
+	i.err = Unmarshal(i.cursor.Current, result)
+	return i.err == nil
+}
+
+// All unmarshals the rest of the documents into result, which must be a
+// pointer to a slice, and closes the Iter
+func (i *Iter) All(result interface{}) error {
+	slice := reflect.ValueOf(result).Elem()
+	slice.SetLen(0)
+
+	elem := reflect.New(slice.Type().Elem())
+	for i.Next(elem.Interface()) {
+		slice.Set(reflect.Append(slice, elem.Elem()))
+		elem = reflect.New(slice.Type().Elem())
+	}
+
+	return i.Close()
+}
+
+// Err returns the error that made Next return false, if any
+func (i *Iter) Err() error {
+	return i.err
+}
+
+// Timeout returns whether Next returned false because a tailable cursor
+// timed out
+func (i *Iter) Timeout() bool {
+	return i.timedOut
+}
+
+// Close closes the cursor, and returns the error that made Next return false,
+// if any, or else the error closing the cursor
+func (i *Iter) Close() error {
+	if i.cursor != nil {
+		err := i.cursor.Close(context.Background())
+		if i.err == nil {
+			i.err = err
+		}
+		i.cursor = nil
+	}
+
+	return i.err
+}
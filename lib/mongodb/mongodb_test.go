@@ -0,0 +1,98 @@
+package mongodb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	driverbson "go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDoc(t *testing.T) {
+	tests := map[string]struct {
+		doc      interface{}
+		expected interface{}
+	}{
+		"Nil": {
+			doc:      nil,
+			expected: bson.D{},
+		},
+		"Map": {
+			doc:      bson.M{"_id": "foo"},
+			expected: bson.D{{Name: "_id", Value: "foo"}},
+		},
+		"Ordered": {
+			doc:      bson.D{{Name: "b", Value: 1}, {Name: "a", Value: 2}},
+			expected: bson.D{{Name: "b", Value: 1}, {Name: "a", Value: 2}},
+		},
+		"Timestamp": {
+			doc:      bson.M{"ts": bson.MongoTimestamp(1<<32 | 2)},
+			expected: bson.D{{Name: "ts", Value: bson.MongoTimestamp(1<<32 | 2)}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Marshal the way the driver does
+			data, err := driverbson.Marshal(Doc(test.doc))
+			if err != nil {
+				t.Fatalf("Error marshalling: %s", err)
+			}
+
+			var result bson.D
+			err = Unmarshal(data, &result)
+			if err != nil {
+				t.Fatalf("Error unmarshalling: %s", err)
+			}
+
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Expected %#v, got %#v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestUnmarshalCopies(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"_id": "foo"})
+	if err != nil {
+		t.Fatalf("Error marshalling: %s", err)
+	}
+
+	var raw bson.Raw
+	err = Unmarshal(data, &raw)
+	if err != nil {
+		t.Fatalf("Error unmarshalling: %s", err)
+	}
+
+	// The driver reuses its buffer for the next document
+	for i := range data {
+		data[i] = 0
+	}
+
+	var result bson.M
+	err = raw.Unmarshal(&result)
+	if err != nil {
+		t.Fatalf("Error unmarshalling raw document: %s", err)
+	}
+
+	if !reflect.DeepEqual(result, bson.M{"_id": "foo"}) {
+		t.Errorf("Expected the raw document to be unchanged, got %#v", result)
+	}
+}
+
+func TestIterErr(t *testing.T) {
+	findErr := errors.New("down")
+	iter := NewIter(nil, findErr)
+
+	var result bson.M
+	if iter.Next(&result) {
+		t.Error("Expected Next to return false")
+	}
+	if iter.Timeout() {
+		t.Error("Expected Timeout to return false")
+	}
+	if iter.Close() != findErr {
+		t.Errorf("Expected Close to return the error, got %v", iter.Close())
+	}
+}
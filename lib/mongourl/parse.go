@@ -1,5 +1,6 @@
-// Package mongourl parses Mongo URLs. It includes support for URL parameters
-// not supported by mgo.ParseURL, such as `?ssl=true`.
+// Package mongourl parses Mongo URLs into options for the Mongo driver. It
+// supports the URL parameters oplogtoredis documents, including `?ssl=true`
+// and the other TLS options, and rejects any others.
 package mongourl
 
 /*
@@ -12,58 +13,85 @@ as found at https://github.com/hashicorp/vault/blob/44aa151b78976c6da41dc63d93b4
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
-	"net"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/globalsign/mgo"
+	"github.com/tulip/oplogtoredis/lib/tlsconfig"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// DefaultTimeout is the timeout value applied to all parsed URLs.
-//
-// See https://godoc.org/github.com/globalsign/mgo#DialInfo for details on
-// what it does.
+// DefaultTimeout is the connect and server selection timeout applied to all
+// parsed URLs.
 //
 // You can modify this value.
 var DefaultTimeout = 10 * time.Second
 
-// The TLS config we use to connect to mongo via SSL. We keep this as a
-// package global so that testing code can modify it.
+// The TLS config we use to connect to mongo via SSL, when the URL doesn't
+// have any other TLS options. We keep this as a package global so that
+// testing code can modify it.
 var tlsConfig = &tls.Config{}
 
-// Parse parses a mongo URL.
-func Parse(mongoURL string) (*mgo.DialInfo, error) {
+// The TLS options in a URL. They can come in any order, so we collect them
+// all before setting up TLS.
+type tlsOptions struct {
+	// Whether ssl=true or tls=true was set, or false if it was set to false
+	enabled *bool
+
+	// Whether any of the other TLS options was set
+	configured bool
+
+	opts tlsconfig.Options
+}
+
+// Parse parses a mongo URL into options for mongo.Connect.
+func Parse(mongoURL string) (*options.ClientOptions, error) {
 	url, err := url.Parse(mongoURL)
 	if err != nil {
 		return nil, err
 	}
 
-	info := mgo.DialInfo{
-		Addrs:    strings.Split(url.Host, ","),
-		Database: strings.TrimPrefix(url.Path, "/"),
-		Timeout:  DefaultTimeout,
-	}
+	opts := options.Client().
+		SetHosts(strings.Split(url.Host, ",")).
+		SetConnectTimeout(DefaultTimeout).
+		SetServerSelectionTimeout(DefaultTimeout)
 
+	// The database in the path is only used to authenticate against, unless
+	// there's an authSource
+	var cred options.Credential
+	cred.AuthSource = strings.TrimPrefix(url.Path, "/")
 	if url.User != nil {
-		info.Username = url.User.Username()
-		info.Password, _ = url.User.Password()
+		cred.Username = url.User.Username()
+		cred.Password, cred.PasswordSet = url.User.Password()
 	}
 
+	var tlsOpts tlsOptions
 	query := url.Query()
 	for key, values := range query {
-		optionErr := handleOption(&info, key, values)
+		optionErr := handleOption(opts, &cred, &tlsOpts, key, values)
 		if optionErr != nil {
 			return nil, optionErr
 		}
 	}
 
-	return &info, nil
+	err = handleTLS(&cred, &tlsOpts, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cred.Username != "" || cred.AuthMechanism != "" {
+		opts.SetAuth(cred)
+	}
+
+	return opts, nil
 }
 
-func handleOption(info *mgo.DialInfo, key string, values []string) error {
+func handleOption(opts *options.ClientOptions, cred *options.Credential, tlsOpts *tlsOptions, key string, values []string) error {
 	var value string
 	if len(values) > 0 {
 		value = values[0]
@@ -71,25 +99,45 @@ func handleOption(info *mgo.DialInfo, key string, values []string) error {
 
 	switch key {
 	case "authSource":
-		info.Source = value
+		cred.AuthSource = value
 	case "authMechanism":
-		info.Mechanism = value
+		cred.AuthMechanism = value
 	case "gssapiServiceName":
-		info.Service = value
+		cred.AuthMechanismProperties = map[string]string{"SERVICE_NAME": value}
 	case "replicaSet":
-		info.ReplicaSetName = value
+		opts.SetReplicaSet(value)
 	case "maxPoolSize":
-		err := handleMaxPoolSize(info, value)
+		err := handleMaxPoolSize(opts, value)
 		if err != nil {
 			return err
 		}
-	case "ssl":
-		err := handleSSL(info, value)
+	case "ssl", "tls":
+		ssl, err := strconv.ParseBool(value)
 		if err != nil {
-			return err
+			return errors.New("bad value for " + key + ": " + value)
+		}
+		tlsOpts.enabled = &ssl
+	case "tlsCAFile":
+		tlsOpts.opts.CAFile = value
+		tlsOpts.configured = true
+	case "tlsCertificateKeyFile":
+		// The certificate and its key are in the same file
+		tlsOpts.opts.CertFile = value
+		tlsOpts.opts.KeyFile = value
+		tlsOpts.configured = true
+	case "tlsAllowInvalidHostnames", "tlsAllowInvalidCertificates", "tlsInsecure":
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("bad value for " + key + ": " + value)
 		}
+		if key == "tlsAllowInvalidHostnames" {
+			tlsOpts.opts.AllowInvalidHostnames = allow
+		} else {
+			tlsOpts.opts.InsecureSkipVerify = allow
+		}
+		tlsOpts.configured = true
 	case "connect":
-		err := handleConnect(info, value)
+		err := handleConnect(opts, value)
 		if err != nil {
 			return err
 		}
@@ -100,36 +148,78 @@ func handleOption(info *mgo.DialInfo, key string, values []string) error {
 	return nil
 }
 
-func handleMaxPoolSize(info *mgo.DialInfo, value string) error {
-	poolLimit, err := strconv.Atoi(value)
+func handleMaxPoolSize(opts *options.ClientOptions, value string) error {
+	poolLimit, err := strconv.ParseUint(value, 10, 64)
 
 	if err != nil {
 		return errors.New("bad value for maxPoolSize: " + value)
 	}
 
-	info.PoolLimit = poolLimit
+	opts.SetMaxPoolSize(poolLimit)
 	return nil
 }
 
-// Unfortunately, mgo doesn't support the ssl parameter in its MongoDB URI parsing logic, so we have to handle that
-// ourselves. See https://github.com/go-mgo/mgo/issues/84
-func handleSSL(info *mgo.DialInfo, value string) error {
-	ssl, err := strconv.ParseBool(value)
+// Sets up TLS if ssl=true or tls=true is set. Setting any of the other TLS
+// options enables TLS too, unless ssl=false or tls=false is set explicitly.
+func handleTLS(cred *options.Credential, tlsOpts *tlsOptions, opts *options.ClientOptions) error {
+	enabled := tlsOpts.configured
+	if tlsOpts.enabled != nil {
+		if !*tlsOpts.enabled && tlsOpts.configured {
+			return errors.New("TLS options are set, but TLS is disabled")
+		}
+		enabled = *tlsOpts.enabled
+	}
+
+	if !enabled {
+		return nil
+	}
+
+	if !tlsOpts.configured {
+		opts.SetTLSConfig(tlsConfig)
+		return nil
+	}
+
+	config, err := tlsconfig.New(tlsOpts.opts)
 	if err != nil {
-		return errors.New("bad value for ssl: " + value)
+		return err
 	}
-	if ssl {
-		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
-			return tls.Dial("tcp", addr.String(), tlsConfig)
+
+	opts.SetTLSConfig(config)
+
+	// For X.509 authentication, the user is the subject of the client
+	// certificate. MongoDB 3.4+ works that out itself, but older servers
+	// need to be told, so we fill it in.
+	if cred.AuthMechanism == "MONGODB-X509" && cred.Username == "" && len(config.Certificates) > 0 {
+		username, err := certificateSubject(config.Certificates[0])
+		if err != nil {
+			return err
 		}
+		cred.Username = username
 	}
 
 	return nil
 }
 
-func handleConnect(info *mgo.DialInfo, value string) error {
+// Returns the subject of a certificate, in the RFC 2253 format MongoDB uses
+// for X.509 users
+func certificateSubject(cert tls.Certificate) (string, error) {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", errors.New("error parsing client certificate: " + err.Error())
+	}
+
+	var subject pkix.RDNSequence
+	_, err = asn1.Unmarshal(parsed.RawSubject, &subject)
+	if err != nil {
+		return "", errors.New("error parsing client certificate subject: " + err.Error())
+	}
+
+	return subject.String(), nil
+}
+
+func handleConnect(opts *options.ClientOptions, value string) error {
 	if value == "direct" {
-		info.Direct = true
+		opts.SetDirect(true)
 		return nil
 	}
 
@@ -1,17 +1,23 @@
 package mongourl
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
-	"reflect"
+	"os"
+	"path/filepath"
 	"testing"
-	"unsafe"
-
-	"github.com/globalsign/mgo"
+	"time"
 )
 
 func TestSSLVerificationFail(t *testing.T) {
@@ -26,7 +32,7 @@ func TestSSLVerificationFail(t *testing.T) {
 	}
 
 	// We expect Dial() to fail, because we haven't trusted the server's cert
-	_, err = parsedURL.DialServer(getServerFromURL(server.URL))
+	_, err = tls.Dial("tcp", getServerFromURL(server.URL), parsedURL.TLSConfig)
 
 	if err == nil {
 		t.Errorf("Expected DialServer to fail, but it did not")
@@ -39,11 +45,6 @@ func TestSSLVerificationSuccess(t *testing.T) {
 	}))
 	defer server.Close()
 
-	parsedURL, err := Parse("mongodb://someserver?ssl=true")
-	if err != nil {
-		t.Fatalf("Parse failed")
-	}
-
 	// Modify the TLS config we're using to trust our server's self-signed cert
 	certificate, _ := x509.ParseCertificate(server.TLS.Certificates[0].Certificate[0])
 	certpool := x509.NewCertPool()
@@ -56,35 +57,111 @@ func TestSSLVerificationSuccess(t *testing.T) {
 		tlsConfig = &tls.Config{}
 	}()
 
+	parsedURL, err := Parse("mongodb://someserver?ssl=true")
+	if err != nil {
+		t.Fatalf("Parse failed")
+	}
+
 	// We expect Dial() to succeed
-	_, err = parsedURL.DialServer(getServerFromURL(server.URL))
+	_, err = tls.Dial("tcp", getServerFromURL(server.URL), parsedURL.TLSConfig)
 
 	if err != nil {
 		t.Errorf("Got unexpected error: %s", err)
 	}
 }
 
-// Helper to get just the host (e.g. localhost:1234) from a URL
-// (e.g. https://localhost:1234). Panics if the URL is invalid.
-func getServerFromURL(urlStr string) *mgo.ServerAddr {
-	parsedURL, err := url.Parse(urlStr)
+func TestSSLCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello, client")
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "mongourl")
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Trust our server's self-signed cert through tlsCAFile
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
 	}
 
-	host := parsedURL.Host
+	parsedURL, err := Parse("mongodb://someserver?tlsCAFile=" + url.QueryEscape(caFile))
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
 
-	serverAddr := &mgo.ServerAddr{}
+	// We expect Dial() to succeed
+	_, err = tls.Dial("tcp", getServerFromURL(server.URL), parsedURL.TLSConfig)
 
-	// It's not possible to construct a ServerAddr outside of the mgo package
-	// itself without doing this unsafe reflection
-	pointerVal := reflect.ValueOf(serverAddr)
-	val := reflect.Indirect(pointerVal)
+	if err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+}
 
-	member := val.FieldByName("str")
-	ptrToY := unsafe.Pointer(member.UnsafeAddr())
-	realPtrToY := (*string)(ptrToY)
-	*realPtrToY = host
+func TestX509Username(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mongourl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "oplogtoredis", Organization: []string{"Tulip"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate and key go in the same file
+	certKeyFile := filepath.Join(dir, "client.pem")
+	certKeyPEM := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...,
+	)
+	if err := ioutil.WriteFile(certKeyFile, certKeyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	parsedURL, err := Parse("mongodb://someserver?authMechanism=MONGODB-X509&tlsCertificateKeyFile=" +
+		url.QueryEscape(certKeyFile))
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+
+	if parsedURL.Auth == nil || parsedURL.Auth.Username != "CN=oplogtoredis,O=Tulip" {
+		t.Errorf("Got credential %#v, expected the certificate's subject as the username", parsedURL.Auth)
+	}
+
+	if parsedURL.TLSConfig == nil {
+		t.Errorf("Expected TLS to be enabled by tlsCertificateKeyFile")
+	}
+}
+
+// Helper to get just the host (e.g. localhost:1234) from a URL
+// (e.g. https://localhost:1234). Panics if the URL is invalid.
+func getServerFromURL(urlStr string) string {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		panic(err)
+	}
 
-	return serverAddr
+	return parsedURL.Host
 }
@@ -0,0 +1,87 @@
+package oplog
+
+import (
+	"fmt"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// StartPosition is where a Tailer starts tailing the first time, when it
+// doesn't have a ResumeFrom timestamp
+type StartPosition string
+
+const (
+	// StartFromCheckpoint resumes from the last-processed timestamp, if it's
+	// within MaxCatchUp, and otherwise starts from the end of the oplog. This
+	// is the default.
+	StartFromCheckpoint StartPosition = "checkpoint"
+
+	// StartFromOldest starts from the oldest entry still in the oplog,
+	// ignoring the last-processed timestamp and MaxCatchUp
+	StartFromOldest StartPosition = "oldest"
+
+	// StartFromNewest starts from the end of the oplog, ignoring the
+	// last-processed timestamp
+	StartFromNewest StartPosition = "newest"
+)
+
+// ParseStartPosition parses "checkpoint", "oldest", or "newest"
+func ParseStartPosition(value string) (StartPosition, error) {
+	switch position := StartPosition(value); position {
+	case StartFromCheckpoint, StartFromOldest, StartFromNewest:
+		return position, nil
+	default:
+		return "", fmt.Errorf("Invalid start position %q: must be checkpoint, oldest, or newest", value)
+	}
+}
+
+// An OplogSource that can tell us the timestamp of the oldest entry it can
+// read. Sources that don't implement it read the whole oplog when tailed from
+// timestamp 0, and can't detect data gaps.
+type oldestTimestampSource interface {
+	OplogSource
+
+	// OldestTimestamp returns the timestamp of the oldest oplog entry
+	OldestTimestamp() (bson.MongoTimestamp, error)
+}
+
+// Returns the timestamp to tail from to start at the oldest entry in the
+// oplog
+func (tailer *Tailer) oldestStartTime() bson.MongoTimestamp {
+	source, ok := tailer.source().(oldestTimestampSource)
+	if !ok {
+		log.Log.Info("Starting tailing from the start of the oplog")
+		return 0
+	}
+
+	ts, err := source.OldestTimestamp()
+	if err != nil {
+		log.Log.Errorw("Error finding the oldest oplog entry; tailing from timestamp 0 instead",
+			"error", err)
+		return 0
+	}
+
+	log.Log.Infof("Starting tailing from the oldest oplog entry (timestamp %d)", int64(ts)>>32)
+
+	// We tail the entries after the timestamp we start from, so start just
+	// before the oldest entry
+	return ts - 1
+}
+
+// Whether rawData is an entry after StopAt
+func (tailer *Tailer) pastStopAt(rawData bson.Raw) bool {
+	if tailer.StopAt == 0 {
+		return false
+	}
+
+	var entry struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	if rawData.Unmarshal(&entry) != nil {
+		// Let unmarshalEntry report it
+		return false
+	}
+
+	return entry.Timestamp > tailer.StopAt
+}
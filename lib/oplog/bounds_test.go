@@ -0,0 +1,152 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// A fakeSource that knows its oldest entry
+type fakeOldestSource struct {
+	fakeSource
+	oldest    bson.MongoTimestamp
+	oldestErr error
+}
+
+func (s *fakeOldestSource) OldestTimestamp() (bson.MongoTimestamp, error) {
+	return s.oldest, s.oldestErr
+}
+
+func TestParseStartPosition(t *testing.T) {
+	for _, value := range []string{"checkpoint", "oldest", "newest"} {
+		position, err := ParseStartPosition(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(position) != value {
+			t.Errorf("Parsed %q as %q", value, position)
+		}
+	}
+
+	_, err := ParseStartPosition("latest")
+	if err == nil {
+		t.Error("Expected an error parsing an invalid start position")
+	}
+}
+
+func TestGetStartTimeStartFrom(t *testing.T) {
+	lastOplogEntry := func() (bson.MongoTimestamp, error) {
+		return bson.MongoTimestamp(100 << 32), nil
+	}
+
+	tests := map[string]struct {
+		startFrom StartPosition
+		source    OplogSource
+		want      bson.MongoTimestamp
+	}{
+		"Checkpoint": {
+			startFrom: StartFromCheckpoint,
+			source:    &fakeSource{},
+			want:      bson.MongoTimestamp(50 << 32),
+		},
+		"Newest ignores the checkpoint": {
+			startFrom: StartFromNewest,
+			source:    &fakeSource{},
+			want:      bson.MongoTimestamp(100 << 32),
+		},
+		"Oldest reads the whole oplog": {
+			startFrom: StartFromOldest,
+			source:    &fakeSource{},
+			want:      0,
+		},
+		"Oldest starts just before the oldest entry": {
+			startFrom: StartFromOldest,
+			source:    &fakeOldestSource{oldest: bson.MongoTimestamp(10<<32 | 1)},
+			want:      bson.MongoTimestamp(10 << 32),
+		},
+		"Oldest falls back to now": {
+			startFrom: StartFromOldest,
+			source:    &fakeOldestSource{oldestErr: errors.New("not authorized on local")},
+			want:      0,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			tailer, err := NewTailer(
+				WithSource(test.source),
+				WithSink(&fakeSink{ts: bson.MongoTimestamp(50 << 32)}),
+				WithMaxCatchUp(time.Hour),
+				WithStartFrom(test.startFrom),
+			)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			got := tailer.getStartTime(lastOplogEntry)
+			if got != test.want {
+				t.Errorf("Got start time %d, expected %d", got, test.want)
+			}
+
+			// Only the first tail uses the start position
+			got = tailer.getStartTime(lastOplogEntry)
+			if got != bson.MongoTimestamp(50<<32) {
+				t.Errorf("Got start time %d on the second tail, expected the checkpoint", got)
+			}
+		})
+	}
+}
+
+func TestWithStartFromInvalid(t *testing.T) {
+	_, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithStartFrom("latest"))
+	if err == nil {
+		t.Error("Expected an error for an invalid start position")
+	}
+}
+
+func TestTailStopAt(t *testing.T) {
+	source := &fakeSource{}
+	for i, id := range []string{"a", "b", "c", "d"} {
+		source.add(t, bson.M{
+			"ts": bson.MongoTimestamp(i + 1),
+			"op": "i",
+			"ns": "foo.bar",
+			"o":  bson.M{"_id": id},
+		})
+	}
+
+	tailer, err := NewTailer(
+		WithSource(source),
+		WithSink(&fakeSink{err: ErrNoLastProcessed}),
+		WithResumeFrom(bson.MongoTimestamp(1)),
+		WithStopAt(bson.MongoTimestamp(3)),
+	)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	out := make(chan *redispub.Publication, 10)
+	done := make(chan bool)
+	go func() {
+		tailer.Tail(context.Background(), out)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Tail did not return after reaching StopAt")
+	}
+	close(out)
+
+	var got []string
+	for pub := range out {
+		got = append(got, pub.SpecificChannel)
+	}
+	if len(got) != 2 || got[0] != "foo.bar::b" || got[1] != "foo.bar::c" {
+		t.Errorf("Got publications on %v, expected foo.bar::b and foo.bar::c", got)
+	}
+}
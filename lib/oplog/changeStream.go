@@ -0,0 +1,282 @@
+package oplog
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewChangeStreamSource creates an OplogSource that reads changes with a
+// cluster-wide change stream (MongoDB 4.0+), rather than by querying
+// local.oplog.rs. This works where direct access to the oplog is restricted,
+// like on Atlas, and against a mongos for a sharded cluster. Each change
+// event is converted to the oplog entry it corresponds to, so the rest of
+// the Tailer doesn't need to know the difference; timestamps are the events'
+// cluster times, so the last-processed timestamp carries over between the
+// two modes. Each entry also carries its event's resume token, which is
+// recorded with the last-processed timestamp by a ResumeTokenSink, so the
+// stream can be resumed exactly where it left off.
+//
+// Replay and TailDump still read local.oplog.rs directly.
+func NewChangeStreamSource(client *mongo.Client) OplogSource {
+	return &changeStreamSource{client: client}
+}
+
+type changeStreamSource struct {
+	client *mongo.Client
+}
+
+// An OplogSource that can resume after a change stream resume token, as well
+// as from a timestamp
+type resumableSource interface {
+	OplogSource
+
+	// TailAfterToken is like TailFrom, but starts right after the change
+	// event with the given resume token, whose timestamp is ts
+	TailAfterToken(ts bson.MongoTimestamp, token string, timeout time.Duration) OplogIterator
+}
+
+// A change event, with the fields we use. See
+// https://docs.mongodb.com/manual/reference/change-events/
+type changeEvent struct {
+	ResumeToken   bson.Raw               `bson:"_id"`
+	OperationType string                 `bson:"operationType"`
+	ClusterTime   bson.MongoTimestamp    `bson:"clusterTime"`
+	WallTime      time.Time              `bson:"wallTime"`
+	Namespace     changeEventNamespace   `bson:"ns"`
+	To            changeEventNamespace   `bson:"to"`
+	DocumentKey   rawOplogEntryID        `bson:"documentKey"`
+	FullDocument  map[string]interface{} `bson:"fullDocument"`
+
+	UpdateDescription changeEventUpdate `bson:"updateDescription"`
+}
+
+type changeEventUpdate struct {
+	UpdatedFields map[string]interface{} `bson:"updatedFields"`
+	RemovedFields []string               `bson:"removedFields"`
+}
+
+type changeEventNamespace struct {
+	DB   string `bson:"db"`
+	Coll string `bson:"coll"`
+}
+
+func (ns changeEventNamespace) String() string {
+	return ns.DB + "." + ns.Coll
+}
+
+func (s *changeStreamSource) LastTimestamp() (bson.MongoTimestamp, error) {
+	// Replica set members and mongos include the operation time with every
+	// command response
+	var result struct {
+		OperationTime bson.MongoTimestamp `bson:"operationTime"`
+	}
+	err := mongodb.Run(s.client.Database("admin"), bson.M{"isMaster": 1}, &result)
+	if err != nil {
+		return 0, err
+	}
+
+	if result.OperationTime == 0 {
+		return 0, errors.New("Mongo did not report an operation time; change streams need a replica set or sharded cluster")
+	}
+
+	return result.OperationTime, nil
+}
+
+// OldestTimestamp returns the timestamp of the oldest entry in
+// local.oplog.rs, since change streams can go back that far. It fails where
+// the oplog can't be read directly (like through mongos).
+func (s *changeStreamSource) OldestTimestamp() (bson.MongoTimestamp, error) {
+	return oldestTimestamp(s.client.Database("local").Collection("oplog.rs"))
+}
+
+func (s *changeStreamSource) TailFrom(ts bson.MongoTimestamp, timeout time.Duration) OplogIterator {
+	opts := options.ChangeStream()
+	if ts != 0 {
+		opts.SetStartAtOperationTime(&primitive.Timestamp{T: uint32(ts >> 32), I: uint32(ts)})
+	}
+
+	return s.tail(opts, ts, timeout)
+}
+
+func (s *changeStreamSource) TailAfterToken(ts bson.MongoTimestamp, token string, timeout time.Duration) OplogIterator {
+	data, err := hex.DecodeString(token)
+	if err != nil {
+		return s.TailFrom(ts, timeout)
+	}
+
+	// Events after the token may share its timestamp (e.g. the rest of a
+	// transaction), so we don't skip any
+	opts := options.ChangeStream().SetResumeAfter(mongodb.Doc(bson.Raw{Kind: 3, Data: data}))
+
+	return s.tail(opts, 0, timeout)
+}
+
+// Opens a cluster-wide change stream with the given options, skipping events
+// up to the timestamp after
+func (s *changeStreamSource) tail(opts *options.ChangeStreamOptions, after bson.MongoTimestamp, timeout time.Duration) OplogIterator {
+	stream, err := s.client.Watch(context.Background(), mongo.Pipeline{}, opts.SetMaxAwaitTime(timeout))
+
+	return &changeStreamIterator{
+		stream: stream,
+		after:  after,
+		err:    err,
+	}
+}
+
+// An OplogIterator over a change stream. We use TryNext rather than Next, so
+// that Next returns when no changes arrive within the stream's
+// MaxAwaitTime.
+type changeStreamIterator struct {
+	stream *mongo.ChangeStream
+
+	// startAtOperationTime is inclusive, but TailFrom is exclusive, so we
+	// skip events up to this timestamp (zero when resuming after a token)
+	after bson.MongoTimestamp
+
+	timedOut bool
+	err      error
+}
+
+func (i *changeStreamIterator) Next(result interface{}) bool {
+	i.timedOut = false
+
+	for i.err == nil {
+		if !i.stream.TryNext(context.Background()) {
+			i.err = i.stream.Err()
+
+			// The stream is only closed by the server when it's invalidated
+			i.timedOut = i.err == nil && i.stream.ID() != 0
+			return false
+		}
+
+		var event changeEvent
+		i.err = mongodb.Unmarshal(i.stream.Current, &event)
+		if i.err != nil {
+			return false
+		}
+
+		if event.ClusterTime <= i.after {
+			continue
+		}
+
+		entry := changeEventToOplogEntry(&event)
+		if entry == nil {
+			continue
+		}
+
+		data, err := bson.Marshal(entry)
+		if err != nil {
+			i.err = err
+			return false
+		}
+
+		i.err = mongodb.Unmarshal(data, result)
+		return i.err == nil
+	}
+
+	return false
+}
+
+func (i *changeStreamIterator) Err() error {
+	return i.err
+}
+
+func (i *changeStreamIterator) Timeout() bool {
+	return i.timedOut
+}
+
+func (i *changeStreamIterator) Close() error {
+	if i.stream != nil {
+		err := i.stream.Close(context.Background())
+		if i.err == nil {
+			i.err = err
+		}
+		i.stream = nil
+	}
+
+	return i.err
+}
+
+// Converts a change event to the oplog entry that produced it, or returns
+// nil for events we have no use for
+func changeEventToOplogEntry(event *changeEvent) bson.M {
+	entry := bson.M{
+		"ts": event.ClusterTime,
+		"ns": event.Namespace.String(),
+	}
+	if len(event.ResumeToken.Data) > 0 {
+		entry["_resumeToken"] = hex.EncodeToString(event.ResumeToken.Data)
+	}
+	if !event.WallTime.IsZero() {
+		// Only reported by Mongo 6.0+
+		entry["wall"] = event.WallTime
+	}
+
+	switch event.OperationType {
+	case "insert":
+		entry["op"] = operationInsert
+		entry["o"] = orderedFullDocument(event)
+	case "update":
+		update := bson.M{}
+		if len(event.UpdateDescription.UpdatedFields) > 0 {
+			update["$set"] = event.UpdateDescription.UpdatedFields
+		}
+		if len(event.UpdateDescription.RemovedFields) > 0 {
+			unset := bson.M{}
+			for _, field := range event.UpdateDescription.RemovedFields {
+				unset[field] = true
+			}
+			update["$unset"] = unset
+		}
+		if len(update) == 0 {
+			// Keep it from looking like a replacement
+			update["$set"] = bson.M{}
+		}
+
+		entry["op"] = operationUpdate
+		entry["o"] = update
+		entry["o2"] = bson.M{"_id": event.DocumentKey.ID}
+	case "replace":
+		entry["op"] = operationUpdate
+		entry["o"] = orderedFullDocument(event)
+		entry["o2"] = bson.M{"_id": event.DocumentKey.ID}
+	case "delete":
+		entry["op"] = operationRemove
+		entry["o"] = bson.M{"_id": event.DocumentKey.ID}
+	case "drop":
+		entry["op"] = operationCommand
+		entry["ns"] = event.Namespace.DB + ".$cmd"
+		entry["o"] = bson.M{"drop": event.Namespace.Coll}
+	case "rename":
+		entry["op"] = operationCommand
+		entry["ns"] = event.Namespace.DB + ".$cmd"
+		entry["o"] = bson.M{"renameCollection": event.Namespace.String(), "to": event.To.String()}
+	case "dropDatabase":
+		entry["op"] = operationCommand
+		entry["ns"] = event.Namespace.DB + ".$cmd"
+		entry["o"] = bson.M{"dropDatabase": 1}
+	default:
+		// invalidate, and anything newer versions of Mongo add
+		return nil
+	}
+
+	return entry
+}
+
+// Returns the full document of a change event with the _id from its document
+// key, which keeps the field order of a composite _id (see decodeDocumentID)
+func orderedFullDocument(event *changeEvent) map[string]interface{} {
+	if event.FullDocument != nil && event.DocumentKey.ID != nil {
+		event.FullDocument["_id"] = event.DocumentKey.ID
+	}
+
+	return event.FullDocument
+}
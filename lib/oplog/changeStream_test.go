@@ -0,0 +1,222 @@
+package oplog
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestChangeEventToOplogEntry(t *testing.T) {
+	ts := bson.MongoTimestamp(1234 << 32)
+	ns := changeEventNamespace{DB: "foo", Coll: "bar"}
+
+	tokenData, err := bson.Marshal(bson.M{"_data": "826a3e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := bson.Raw{Kind: 3, Data: tokenData}
+
+	tests := map[string]struct {
+		event    changeEvent
+		expected bson.M
+	}{
+		"Insert": {
+			event: changeEvent{
+				ResumeToken:   token,
+				OperationType: "insert",
+				ClusterTime:   ts,
+				Namespace:     ns,
+				DocumentKey:   rawOplogEntryID{ID: "someid"},
+				FullDocument:  map[string]interface{}{"_id": "someid", "hello": "world"},
+			},
+			expected: bson.M{
+				"ts":           ts,
+				"op":           "i",
+				"ns":           "foo.bar",
+				"o":            map[string]interface{}{"_id": "someid", "hello": "world"},
+				"_resumeToken": hex.EncodeToString(tokenData),
+			},
+		},
+		"Insert with wall time": {
+			event: changeEvent{
+				OperationType: "insert",
+				ClusterTime:   ts,
+				WallTime:      time.Unix(1234, 500000000),
+				Namespace:     ns,
+				DocumentKey:   rawOplogEntryID{ID: "someid"},
+				FullDocument:  map[string]interface{}{"_id": "someid"},
+			},
+			expected: bson.M{
+				"ts":   ts,
+				"op":   "i",
+				"ns":   "foo.bar",
+				"o":    map[string]interface{}{"_id": "someid"},
+				"wall": time.Unix(1234, 500000000),
+			},
+		},
+		"Update": {
+			event: changeEvent{
+				OperationType: "update",
+				ClusterTime:   ts,
+				Namespace:     ns,
+				DocumentKey:   rawOplogEntryID{ID: "someid"},
+				UpdateDescription: changeEventUpdate{
+					UpdatedFields: map[string]interface{}{"a.b": 1},
+					RemovedFields: []string{"c", "d"},
+				},
+			},
+			expected: bson.M{
+				"ts": ts,
+				"op": "u",
+				"ns": "foo.bar",
+				"o": bson.M{
+					"$set":   map[string]interface{}{"a.b": 1},
+					"$unset": bson.M{"c": true, "d": true},
+				},
+				"o2": bson.M{"_id": "someid"},
+			},
+		},
+		"Empty update": {
+			event: changeEvent{
+				OperationType: "update",
+				ClusterTime:   ts,
+				Namespace:     ns,
+				DocumentKey:   rawOplogEntryID{ID: "someid"},
+			},
+			expected: bson.M{
+				"ts": ts,
+				"op": "u",
+				"ns": "foo.bar",
+				"o":  bson.M{"$set": bson.M{}},
+				"o2": bson.M{"_id": "someid"},
+			},
+		},
+		"Replace": {
+			event: changeEvent{
+				OperationType: "replace",
+				ClusterTime:   ts,
+				Namespace:     ns,
+				DocumentKey:   rawOplogEntryID{ID: "someid"},
+				FullDocument:  map[string]interface{}{"_id": "someid", "replaced": true},
+			},
+			expected: bson.M{
+				"ts": ts,
+				"op": "u",
+				"ns": "foo.bar",
+				"o":  map[string]interface{}{"_id": "someid", "replaced": true},
+				"o2": bson.M{"_id": "someid"},
+			},
+		},
+		"Delete": {
+			event: changeEvent{
+				OperationType: "delete",
+				ClusterTime:   ts,
+				Namespace:     ns,
+				DocumentKey:   rawOplogEntryID{ID: "someid"},
+			},
+			expected: bson.M{
+				"ts": ts,
+				"op": "d",
+				"ns": "foo.bar",
+				"o":  bson.M{"_id": "someid"},
+			},
+		},
+		"Drop": {
+			event: changeEvent{
+				OperationType: "drop",
+				ClusterTime:   ts,
+				Namespace:     ns,
+			},
+			expected: bson.M{
+				"ts": ts,
+				"op": "c",
+				"ns": "foo.$cmd",
+				"o":  bson.M{"drop": "bar"},
+			},
+		},
+		"Rename": {
+			event: changeEvent{
+				OperationType: "rename",
+				ClusterTime:   ts,
+				Namespace:     ns,
+				To:            changeEventNamespace{DB: "foo", Coll: "baz"},
+			},
+			expected: bson.M{
+				"ts": ts,
+				"op": "c",
+				"ns": "foo.$cmd",
+				"o":  bson.M{"renameCollection": "foo.bar", "to": "foo.baz"},
+			},
+		},
+		"Drop database": {
+			event: changeEvent{
+				OperationType: "dropDatabase",
+				ClusterTime:   ts,
+				Namespace:     changeEventNamespace{DB: "foo"},
+			},
+			expected: bson.M{
+				"ts": ts,
+				"op": "c",
+				"ns": "foo.$cmd",
+				"o":  bson.M{"dropDatabase": 1},
+			},
+		},
+		"Invalidate": {
+			event: changeEvent{
+				OperationType: "invalidate",
+				ClusterTime:   ts,
+			},
+			expected: nil,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := changeEventToOplogEntry(&test.event)
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("changeEventToOplogEntry returned incorrect result\n    Got: %#v\n    Expected: %#v",
+					got, test.expected)
+			}
+		})
+	}
+}
+
+// Change events should come out of the conversion as entries the Tailer
+// processes just like the oplog's own
+func TestChangeEventProcessing(t *testing.T) {
+	entry := changeEventToOplogEntry(&changeEvent{
+		ResumeToken:   bson.Raw{Kind: 3, Data: []byte{5, 0, 0, 0, 0}},
+		OperationType: "update",
+		ClusterTime:   bson.MongoTimestamp(1234 << 32),
+		Namespace:     changeEventNamespace{DB: "foo", Coll: "bar"},
+		DocumentKey:   rawOplogEntryID{ID: "someid"},
+		UpdateDescription: changeEventUpdate{
+			UpdatedFields: map[string]interface{}{"hello": "world"},
+		},
+	})
+
+	data, err := bson.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := (&Tailer{}).Process(bson.Raw{Kind: 3, Data: data})
+	if pub == nil {
+		t.Fatal("Expected a publication")
+	}
+
+	if pub.CollectionChannel != "foo.bar" || pub.SpecificChannel != "foo.bar::someid" {
+		t.Errorf("Got channels %s and %s", pub.CollectionChannel, pub.SpecificChannel)
+	}
+
+	if string(pub.Msg) != `{"e":"u","d":{"_id":"someid"},"f":["hello"]}` {
+		t.Errorf("Got message %s", pub.Msg)
+	}
+
+	if pub.ResumeToken != "0500000000" {
+		t.Errorf("Got resume token %q, expected the event's", pub.ResumeToken)
+	}
+}
@@ -0,0 +1,69 @@
+package oplog
+
+import (
+	"strings"
+)
+
+// Returns the values an entry wrote, for Tailers with ChangedValues set:
+// the whole document for inserts and replacements, and the values of the
+// fields that were set (keyed by dotted field path, like `$set`) for other
+// updates. Unlike addFullDocument, this only uses what's in the oplog, so it
+// never queries Mongo. Fields that were unset aren't included (they're still
+// listed in the message's fields), and neither are elements of arrays that
+// were changed in place by a delta update; consumers that need those have to
+// read the document. Returns nil for removes.
+func (op *oplogEntry) changedValues() map[string]interface{} {
+	if op.IsInsert() || (op.IsUpdate() && op.UpdateIsReplace()) {
+		return op.Data
+	}
+
+	if !op.IsUpdate() {
+		return nil
+	}
+
+	values := map[string]interface{}{}
+	if op.updateIsDelta() {
+		if diff, ok := asMap(op.Data["diff"]); ok {
+			diffValues(diff, "", values)
+		}
+		return values
+	}
+
+	// Other operators' operands aren't the values they wrote (like $inc's
+	// increments), and positional paths don't say which elements they wrote
+	if set, ok := asMap(op.Data["$set"]); ok {
+		for field, value := range set {
+			if !hasPositional(field) {
+				values[field] = value
+			}
+		}
+	}
+
+	return values
+}
+
+// Adds the values that a delta update's diff sets to values, keyed by their
+// path under prefix. See diffFields for the format of diffs.
+func diffValues(diff map[string]interface{}, prefix string, values map[string]interface{}) {
+	if _, isArray := diff["a"]; isArray {
+		return
+	}
+
+	for key, value := range diff {
+		switch {
+		case key == "u" || key == "i":
+			section, ok := asMap(value)
+			if !ok {
+				continue
+			}
+
+			for field, fieldValue := range section {
+				values[prefix+field] = fieldValue
+			}
+		case strings.HasPrefix(key, "s"):
+			if subdiff, ok := asMap(value); ok {
+				diffValues(subdiff, prefix+key[1:]+".", values)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestChangedValues(t *testing.T) {
+	doc := map[string]interface{}{"_id": "someid", "a": 1}
+
+	tests := map[string]struct {
+		in   *oplogEntry
+		want map[string]interface{}
+	}{
+		"Insert": {
+			in:   &oplogEntry{Operation: "i", Data: doc},
+			want: doc,
+		},
+		"Replacement update": {
+			in:   &oplogEntry{Operation: "u", Data: doc},
+			want: doc,
+		},
+		"$set update": {
+			in: &oplogEntry{Operation: "u", Data: map[string]interface{}{
+				"$v":     1,
+				"$set":   bson.M{"a": 2, "b.c": "x"},
+				"$unset": bson.M{"d": true},
+			}},
+			want: map[string]interface{}{"a": 2, "b.c": "x"},
+		},
+		"$unset update": {
+			in:   &oplogEntry{Operation: "u", Data: map[string]interface{}{"$unset": bson.M{"d": true}}},
+			want: map[string]interface{}{},
+		},
+		"Other operators": {
+			in: &oplogEntry{Operation: "u", Data: map[string]interface{}{
+				"$set":  bson.M{"a": 2, "items.$.count": 3},
+				"$inc":  bson.M{"b": 1},
+				"$push": bson.M{"tags": "x"},
+			}},
+			want: map[string]interface{}{"a": 2},
+		},
+		"Delta update": {
+			in: &oplogEntry{Operation: "u", Data: map[string]interface{}{
+				"$v": 2,
+				"diff": bson.M{
+					"u": bson.M{"a": 2},
+					"i": bson.M{"b": "new"},
+					"d": bson.M{"c": false},
+					"sprofile": bson.M{
+						"u": bson.M{"name": "Ada"},
+					},
+					"stags": bson.M{
+						"a":  true,
+						"u1": "changed",
+					},
+				},
+			}},
+			want: map[string]interface{}{"a": 2, "b": "new", "profile.name": "Ada"},
+		},
+		"Remove": {
+			in:   &oplogEntry{Operation: "d", Data: map[string]interface{}{"_id": "someid"}},
+			want: nil,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got := test.in.changedValues()
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got changed values %#v, expected %#v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package oplog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// PayloadFormat is the format of the messages we publish
+type PayloadFormat string
+
+const (
+	// PayloadFormatRedisOplog publishes messages in the format redis-oplog
+	// expects. This is the default.
+	PayloadFormatRedisOplog PayloadFormat = "redis-oplog"
+
+	// PayloadFormatCloudEvents wraps each message in a CloudEvents 1.0 JSON
+	// envelope (see https://cloudevents.io), so that event routers can
+	// consume them without an adapter
+	PayloadFormatCloudEvents PayloadFormat = "cloudevents"
+
+	// PayloadFormatDebezium publishes messages in the format of Debezium's
+	// MongoDB connector, so existing Debezium consumers can read them (see
+	// debeziumMessage)
+	PayloadFormatDebezium PayloadFormat = "debezium"
+)
+
+// ParsePayloadFormat parses "redis-oplog", "cloudevents", or "debezium"
+func ParsePayloadFormat(value string) (PayloadFormat, error) {
+	switch payloadFormat := PayloadFormat(value); payloadFormat {
+	case PayloadFormatRedisOplog, PayloadFormatCloudEvents, PayloadFormatDebezium:
+		return payloadFormat, nil
+	default:
+		return "", fmt.Errorf("Invalid payload format %q: must be redis-oplog, cloudevents, or debezium", value)
+	}
+}
+
+// The CloudEvent types of the operations
+var cloudEventTypes = map[string]string{
+	operationInsert: "oplogtoredis.insert",
+	operationUpdate: "oplogtoredis.update",
+	operationRemove: "oplogtoredis.remove",
+}
+
+// A CloudEvents 1.0 event, in the JSON event format. See
+// https://github.com/cloudevents/spec/blob/v1.0/json-format.md
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// Wraps the message for an entry in a CloudEvent. Its source is the
+// namespace, after the cluster name if there is one
+// ("/<cluster>/<db>.<collection>"); its type is the operation
+// ("oplogtoredis.insert", "oplogtoredis.update", or "oplogtoredis.remove");
+// its id is the entry's timestamp and a hash of the message, which is unique
+// even among the messages of a transaction (which share a timestamp); its
+// subject is the document ID, as it appears in the document's channel; and
+// its data is the message itself.
+func wrapCloudEvent(op *oplogEntry, idForChannel string, msg []byte) ([]byte, error) {
+	source := "/" + op.Namespace
+	if op.Cluster != "" {
+		source = "/" + op.Cluster + source
+	}
+
+	eventType, ok := cloudEventTypes[op.Operation]
+	if !ok {
+		eventType = "oplogtoredis." + op.Operation
+	}
+
+	hash := sha256.Sum256(msg)
+
+	return json.Marshal(&cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              FormatTimestamp(op.Timestamp) + "-" + hex.EncodeToString(hash[:8]),
+		Source:          source,
+		Type:            eventType,
+		Subject:         idForChannel,
+		Time:            op.wallTime().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            msg,
+	})
+}
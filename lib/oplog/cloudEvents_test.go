@@ -0,0 +1,119 @@
+package oplog
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestParsePayloadFormat(t *testing.T) {
+	for _, value := range []string{"redis-oplog", "cloudevents", "debezium"} {
+		payloadFormat, err := ParsePayloadFormat(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(payloadFormat) != value {
+			t.Errorf("Parsed %q as %q", value, payloadFormat)
+		}
+	}
+
+	if _, err := ParsePayloadFormat("json"); err == nil {
+		t.Error("Expected an error parsing an invalid payload format")
+	}
+}
+
+func TestProcessOplogEntryCloudEvents(t *testing.T) {
+	tests := map[string]struct {
+		operation  string
+		data       map[string]interface{}
+		cluster    string
+		wantSource string
+		wantType   string
+		wantData   string
+	}{
+		"Insert": {
+			operation:  "i",
+			data:       map[string]interface{}{"_id": "someid"},
+			wantSource: "/foo.bar",
+			wantType:   "oplogtoredis.insert",
+			wantData:   `{"e":"i","d":{"_id":"someid"},"f":["_id"]}`,
+		},
+		"Update with a cluster name": {
+			operation:  "u",
+			data:       map[string]interface{}{"$set": map[string]interface{}{"a": 1}},
+			cluster:    "eu-west",
+			wantSource: "/eu-west/foo.bar",
+			wantType:   "oplogtoredis.update",
+			wantData:   `{"e":"u","d":{"_id":"someid"},"f":["a"]}`,
+		},
+		"Remove": {
+			operation:  "d",
+			data:       map[string]interface{}{"_id": "someid"},
+			wantSource: "/foo.bar",
+			wantType:   "oplogtoredis.remove",
+			wantData:   `{"e":"r","d":{"_id":"someid"},"f":[]}`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pub, err := processOplogEntry(&oplogEntry{
+				DocID:         "someid",
+				Timestamp:     bson.MongoTimestamp(1500000000<<32 | 3),
+				WallTime:      time.Unix(1500000000, 250000000),
+				Operation:     test.operation,
+				Namespace:     "foo.bar",
+				Database:      "foo",
+				Collection:    "bar",
+				Data:          test.data,
+				PayloadFormat: PayloadFormatCloudEvents,
+				Cluster:       test.cluster,
+			})
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			var event map[string]interface{}
+			if err := json.Unmarshal(pub.Msg, &event); err != nil {
+				t.Fatal(err)
+			}
+
+			var data struct {
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(pub.Msg, &data); err != nil {
+				t.Fatal(err)
+			}
+			if string(data.Data) != test.wantData {
+				t.Errorf("Got data %s, expected %s", data.Data, test.wantData)
+			}
+			delete(event, "data")
+
+			id, _ := event["id"].(string)
+			if !strings.HasPrefix(id, "1500000000:3-") || len(id) != len("1500000000:3-")+16 {
+				t.Errorf("Got unexpected id %q", id)
+			}
+			delete(event, "id")
+
+			want := map[string]interface{}{
+				"specversion":     "1.0",
+				"source":          test.wantSource,
+				"type":            test.wantType,
+				"subject":         "someid",
+				"time":            "2017-07-14T02:40:00.25Z",
+				"datacontenttype": "application/json",
+			}
+			if !reflect.DeepEqual(event, want) {
+				t.Errorf("Got CloudEvent %#v, expected %#v", event, want)
+			}
+
+			// The channels are unchanged
+			if pub.CollectionChannel != "foo.bar" || pub.SpecificChannel != "foo.bar::someid" {
+				t.Errorf("Got channels %s and %s", pub.CollectionChannel, pub.SpecificChannel)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package oplog
+
+import (
+	"encoding/json"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Collection events are published to the namespace ("<db>.<collection>", or
+// "<db>" for dropDatabase) followed by this suffix. Collection names can't
+// contain "$", so these channels can't collide with another collection's.
+const collectionEventChannelSuffix = "::$meta"
+
+// The names of collection events
+const (
+	collectionEventDrop         = "dropCollection"
+	collectionEventRename       = "renameCollection"
+	collectionEventDropDatabase = "dropDatabase"
+)
+
+// The message published for a collection event
+type collectionEventMessage struct {
+	// dropCollection, renameCollection, or dropDatabase
+	Event string `json:"e"`
+
+	// The dropped or renamed collection ("<db>.<collection>"), or the
+	// dropped database
+	Namespace string `json:"ns"`
+
+	// For renameCollection, the collection's new namespace, and whether a
+	// collection that already had that name was dropped
+	To         string `json:"to,omitempty"`
+	DropTarget bool   `json:"dropTarget,omitempty"`
+}
+
+// CollectionEventChannel returns the channel that events about a whole
+// collection (or, for dropDatabase, a whole database) are published to when
+// Tailer.CollectionEvents is set: the namespace followed by "::$meta".
+func CollectionEventChannel(namespace string) string {
+	return namespace + collectionEventChannelSuffix
+}
+
+// Returns the publications for a collection event, or nil if
+// CollectionEvents isn't set
+func (tailer *Tailer) collectionEvent(msg collectionEventMessage, ts bson.MongoTimestamp) []*redispub.Publication {
+	if !tailer.CollectionEvents {
+		return nil
+	}
+
+	return tailer.collectionEventPublications(msg, ts)
+}
+
+// Returns the publications for a collection event. A rename is published on
+// the channels of both the old and new namespaces (each if it passes the
+// namespace filter).
+func (tailer *Tailer) collectionEventPublications(msg collectionEventMessage, ts bson.MongoTimestamp) []*redispub.Publication {
+	namespaces := []string{msg.Namespace}
+	if msg.Event == collectionEventRename {
+		namespaces = append(namespaces, msg.To)
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Log.Errorw("Error encoding collection event",
+			"event", msg.Event,
+			"namespace", msg.Namespace,
+			"error", err)
+		return nil
+	}
+
+	var pubs []*redispub.Publication
+	for _, namespace := range namespaces {
+		if msg.Event != collectionEventDropDatabase && tailer.namespaceFilter != nil {
+			database, collection := parseNamespace(namespace)
+			if !tailer.namespaceFilter(database, collection) {
+				continue
+			}
+		}
+
+		channel := CollectionEventChannel(namespace)
+		pubs = append(pubs, &redispub.Publication{
+			CollectionChannel: channel,
+			Msg:               data,
+			OplogTimestamp:    ts,
+			DedupeSuffix:      "collectionEvent::" + channel,
+			Meta:              true,
+		})
+	}
+
+	return pubs
+}
@@ -0,0 +1,129 @@
+package oplog
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestCollectionEvents(t *testing.T) {
+	tests := map[string]struct {
+		command      bson.M
+		wantChannels []string
+		wantMsg      map[string]interface{}
+	}{
+		"Drop": {
+			command:      bson.M{"drop": "bar"},
+			wantChannels: []string{"foo.bar::$meta"},
+			wantMsg:      map[string]interface{}{"e": "dropCollection", "ns": "foo.bar"},
+		},
+		"Drop database": {
+			command:      bson.M{"dropDatabase": 1},
+			wantChannels: []string{"foo::$meta"},
+			wantMsg:      map[string]interface{}{"e": "dropDatabase", "ns": "foo"},
+		},
+		"Rename": {
+			command:      bson.M{"renameCollection": "foo.bar", "to": "foo.baz", "dropTarget": false},
+			wantChannels: []string{"foo.bar::$meta", "foo.baz::$meta"},
+			wantMsg:      map[string]interface{}{"e": "renameCollection", "ns": "foo.bar", "to": "foo.baz"},
+		},
+		"Rename with dropTarget": {
+			command:      bson.M{"renameCollection": "foo.bar", "to": "foo.baz", "dropTarget": "uuid"},
+			wantChannels: []string{"foo.bar::$meta", "foo.baz::$meta"},
+			wantMsg:      map[string]interface{}{"e": "renameCollection", "ns": "foo.bar", "to": "foo.baz", "dropTarget": true},
+		},
+		"Create": {
+			command: bson.M{"create": "bar"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pubs := (&Tailer{CollectionEvents: true}).processCommand(&rawOplogEntry{
+				Timestamp: bson.MongoTimestamp(1234),
+				Operation: "c",
+				Namespace: "foo.$cmd",
+				Doc:       test.command,
+			})
+
+			if len(pubs) != len(test.wantChannels) {
+				t.Fatalf("Got %d publications, expected %d", len(pubs), len(test.wantChannels))
+			}
+
+			for i, pub := range pubs {
+				if !pub.Meta || pub.CollectionChannel != test.wantChannels[i] || pub.OplogTimestamp != 1234 {
+					t.Errorf("Got unexpected publication %#v", pub)
+				}
+
+				var msg map[string]interface{}
+				if err := json.Unmarshal(pub.Msg, &msg); err != nil {
+					t.Fatal(err)
+				}
+				if len(msg) != len(test.wantMsg) {
+					t.Errorf("Got message %v, expected %v", msg, test.wantMsg)
+				}
+				for key, value := range test.wantMsg {
+					if msg[key] != value {
+						t.Errorf("Got message %v, expected %v", msg, test.wantMsg)
+					}
+				}
+			}
+
+			if len(pubs) == 2 && pubs[0].DedupeSuffix == pubs[1].DedupeSuffix {
+				t.Errorf("Publications have the same dedupe suffix %q", pubs[0].DedupeSuffix)
+			}
+		})
+	}
+}
+
+func TestCollectionEventsFiltered(t *testing.T) {
+	tailer := &Tailer{
+		CollectionEvents: true,
+		namespaceFilter: func(database string, collection string) bool {
+			return collection != "bar"
+		},
+	}
+
+	pubs := tailer.processCommand(&rawOplogEntry{
+		Timestamp: bson.MongoTimestamp(1234),
+		Operation: "c",
+		Namespace: "foo.$cmd",
+		Doc:       bson.M{"drop": "bar"},
+	})
+	if len(pubs) != 0 {
+		t.Errorf("Got %d publications for a filtered collection, expected none", len(pubs))
+	}
+
+	// Only the unfiltered side of a rename is published
+	pubs = tailer.processCommand(&rawOplogEntry{
+		Timestamp: bson.MongoTimestamp(1234),
+		Operation: "c",
+		Namespace: "foo.$cmd",
+		Doc:       bson.M{"renameCollection": "foo.baz", "to": "foo.bar"},
+	})
+	if len(pubs) != 1 || pubs[0].CollectionChannel != "foo.baz::$meta" {
+		t.Errorf("Got publications %#v, expected one on foo.baz::$meta", pubs)
+	}
+}
+
+func TestCollectionEventsFromMigrate(t *testing.T) {
+	// A shard drops its copy of a collection when its last chunk moves away
+	rawEntry := &rawOplogEntry{
+		Timestamp:   bson.MongoTimestamp(1234),
+		Operation:   "c",
+		Namespace:   "foo.$cmd",
+		Doc:         map[string]interface{}{"drop": "bar"},
+		FromMigrate: true,
+	}
+
+	pubs, database, status := (&Tailer{CollectionEvents: true}).processEntry(rawEntry)
+	if len(pubs) != 0 || database != "foo" || status != "ignored" {
+		t.Errorf("Got %d publications, database %q, status %q; expected a migration to be ignored", len(pubs), database, status)
+	}
+
+	pubs, _, status = (&Tailer{CollectionEvents: true, PublishMigrations: true}).processEntry(rawEntry)
+	if len(pubs) != 1 || status != "processed" {
+		t.Errorf("Got %d publications, status %q; expected the migration to be published", len(pubs), status)
+	}
+}
@@ -0,0 +1,252 @@
+package oplog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Returns the publications for a command entry that affects a whole
+// collection. redis-oplog only understands changes to individual documents,
+// so Meteor servers would keep serving the documents of a renamed collection
+// under its old name; to clear them, with RenameRemoves, we publish a remove
+// for each document on the old collection's channels (or, for collections
+// with more documents than that, a rename collection event, whether or not
+// CollectionEvents is set).
+//
+// We can't do the same for dropped collections (or the collection replaced
+// by a rename with dropTarget), because the oplog doesn't tell us which
+// documents they had, so we just log those.
+//
+// With CollectionEvents, drops, renames, and dropDatabase are also published
+// as events on the collection's (or database's) meta channel, so consumers
+// can invalidate everything they have for it.
+//
+// applyOps entries (which is how the oplog records multi-document
+// transactions) are expanded into the operations they contain.
+func (tailer *Tailer) processCommand(rawEntry *rawOplogEntry) []*redispub.Publication {
+	database, _ := parseNamespace(rawEntry.Namespace)
+
+	if _, ok := rawEntry.Doc["applyOps"]; ok {
+		return tailer.processApplyOps(rawEntry.applyOps, rawEntry.Timestamp)
+	}
+
+	if from, ok := rawEntry.Doc["renameCollection"].(string); ok {
+		to, _ := rawEntry.Doc["to"].(string)
+		// dropTarget is the UUID of the replaced collection, or false (a
+		// bool in older versions of Mongo)
+		dropTargetValue, ok := rawEntry.Doc["dropTarget"]
+		dropTarget := ok && dropTargetValue != false
+		if dropTarget {
+			logUnclearable(to)
+		}
+
+		event := collectionEventMessage{
+			Event:      collectionEventRename,
+			Namespace:  from,
+			To:         to,
+			DropTarget: dropTarget,
+		}
+
+		pubs, tooLarge := tailer.processRename(from, to, rawEntry.Timestamp)
+		if tooLarge {
+			return tailer.collectionEventPublications(event, rawEntry.Timestamp)
+		}
+
+		return append(pubs, tailer.collectionEvent(event, rawEntry.Timestamp)...)
+	}
+
+	if collection, ok := rawEntry.Doc["drop"].(string); ok {
+		if tailer.namespaceFilter == nil || tailer.namespaceFilter(database, collection) {
+			logUnclearable(database + "." + collection)
+		}
+
+		return tailer.collectionEvent(collectionEventMessage{
+			Event:     collectionEventDrop,
+			Namespace: database + "." + collection,
+		}, rawEntry.Timestamp)
+	} else if _, ok := rawEntry.Doc["dropDatabase"]; ok {
+		logUnclearable(database)
+
+		return tailer.collectionEvent(collectionEventMessage{
+			Event:     collectionEventDropDatabase,
+			Namespace: database,
+		}, rawEntry.Timestamp)
+	}
+
+	return nil
+}
+
+// Returns the publications for the operations of an applyOps entry. Each
+// operation is processed just like an oplog entry of its own (so nested
+// applyOps are expanded too), except that operations don't have timestamps,
+// so they all get the applyOps entry's; to keep them from being deduplicated
+// against each other, each publication's dedupe suffix starts with the index
+// of its operation.
+//
+// The operations of a transaction that's prepared (on a sharded cluster) are
+// published when it's prepared, not when it's committed, so a transaction
+// that's then aborted produces spurious messages. redis-oplog re-reads the
+// documents from Mongo, so these are harmless.
+func (tailer *Tailer) processApplyOps(ops []bson.Raw, ts bson.MongoTimestamp) []*redispub.Publication {
+	var pubs []*redispub.Publication
+
+	for i, op := range ops {
+		var inner rawOplogEntry
+		err := unmarshalRawOplogEntry(op, &inner)
+		if err != nil {
+			log.Log.Errorw("Error unmarshaling applyOps operation",
+				"index", i,
+				"error", err)
+			tailer.hooks.error(fmt.Errorf("Error unmarshaling applyOps operation: %s", err))
+			continue
+		}
+
+		inner.Timestamp = ts
+
+		innerPubs, _, _ := tailer.processEntry(&inner)
+		for _, pub := range innerPubs {
+			pub.DedupeSuffix = joinDedupeSuffix(strconv.Itoa(i), pub.DedupeSuffix)
+		}
+		pubs = append(pubs, innerPubs...)
+	}
+
+	return pubs
+}
+
+// Prepends prefix to a publication's dedupe suffix
+func joinDedupeSuffix(prefix string, suffix string) string {
+	if suffix == "" {
+		return prefix
+	}
+
+	return prefix + "::" + suffix
+}
+
+// How many _ids of a renamed collection to read at a time
+const renameBatchSize = 1000
+
+// Returns the removes for every document of a renamed collection, if
+// RenameRemoves is set. We look the documents up under the new name, so
+// documents that have been removed or renamed again since don't get a
+// remove. If the collection has more than RenameRemoves documents, it
+// returns true instead, so the rename is published as a collection event.
+func (tailer *Tailer) processRename(from string, to string, ts bson.MongoTimestamp) ([]*redispub.Publication, bool) {
+	fromDatabase, fromCollection := parseNamespace(from)
+	if tailer.namespaceFilter != nil && !tailer.namespaceFilter(fromDatabase, fromCollection) {
+		return nil, false
+	}
+
+	if tailer.RenameRemoves <= 0 {
+		log.Log.Warnw("Collection was renamed; redis-oplog has no way to clear its documents, so Meteor servers may keep serving them under the old name until their subscriptions are restarted",
+			"from", from,
+			"to", to)
+		return nil, false
+	}
+
+	if tailer.MongoClient == nil {
+		log.Log.Errorw("Can't publish removes for renamed collection without a Mongo client",
+			"from", from,
+			"to", to)
+		return nil, false
+	}
+
+	// We read one more than RenameRemoves, to tell whether there are too
+	// many, rather than scanning the whole collection
+	toDatabase, toCollection := parseNamespace(to)
+	opts := options.Find().
+		SetProjection(mongodb.Doc(bson.M{"_id": 1})).
+		SetBatchSize(renameBatchSize).
+		SetLimit(int64(tailer.RenameRemoves + 1))
+	iter := mongodb.NewIter(tailer.database(toDatabase).Collection(toCollection).Find(context.Background(), mongodb.Doc(nil), opts))
+
+	var ids []interface{}
+	var doc rawOplogEntryID
+	for iter.Next(&doc) {
+		if len(ids) == tailer.RenameRemoves {
+			_ = iter.Close()
+			log.Log.Warnw("Renamed collection has too many documents to publish removes for; publishing a rename collection event instead",
+				"from", from,
+				"to", to,
+				"limit", tailer.RenameRemoves)
+			return nil, true
+		}
+
+		ids = append(ids, doc.ID)
+	}
+
+	if err := iter.Close(); err != nil {
+		// Publish what we have; the rest stay stale
+		log.Log.Errorw("Error looking up documents of renamed collection",
+			"from", from,
+			"to", to,
+			"error", err)
+		tailer.hooks.error(fmt.Errorf("Error looking up documents of renamed collection %s: %s", to, err))
+	}
+
+	log.Log.Infow("Publishing removes for renamed collection",
+		"from", from,
+		"to", to,
+		"count", len(ids))
+
+	return tailer.removePublications(from, ts, ids), false
+}
+
+// Returns a remove publication for each of the given document IDs in a
+// namespace, all for the same oplog entry. Documents whose IDs can't be
+// published are skipped.
+func (tailer *Tailer) removePublications(namespace string, ts bson.MongoTimestamp, ids []interface{}) []*redispub.Publication {
+	database, collection := parseNamespace(namespace)
+
+	version := ""
+	if tailer.DocumentVersion {
+		version = documentVersion(ts)
+	}
+
+	var pubs []*redispub.Publication
+	for _, id := range ids {
+		pub, err := processOplogEntry(&oplogEntry{
+			DocID:      id,
+			Timestamp:  ts,
+			Data:       map[string]interface{}{"_id": id},
+			Operation:  operationRemove,
+			Namespace:  namespace,
+			Database:   database,
+			Collection: collection,
+			Version:    version,
+
+			IncludeNamespace: tailer.IncludeNamespace,
+			IncludeTimestamp: tailer.IncludeTimestamp,
+			IncludeWallTime:  tailer.IncludeWallTime,
+			PublisherVersion: tailer.PublisherVersion,
+			EventNames:       tailer.EventNames,
+			ProtocolVersion:  tailer.ProtocolVersion,
+			PayloadFormat:    tailer.PayloadFormat,
+			Cluster:          tailer.ClusterName,
+			Shard:            tailer.Shard,
+			MaxMessageSize:   tailer.MaxMessageSize,
+			OversizeAction:   tailer.OversizeAction,
+		})
+		if err != nil || pub == nil {
+			continue
+		}
+
+		// The specific channel is unique to each document
+		pub.DedupeSuffix = pub.SpecificChannel
+		pubs = append(pubs, pub)
+	}
+
+	return pubs
+}
+
+// Logs a namespace whose documents Meteor servers may keep serving
+func logUnclearable(namespace string) {
+	log.Log.Warnw("Collection or database was dropped; redis-oplog has no way to clear its documents, so Meteor servers may keep serving them until their subscriptions are restarted",
+		"namespace", namespace)
+}
@@ -0,0 +1,177 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestRemovePublications(t *testing.T) {
+	oid := bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")
+	pubs := (&Tailer{}).removePublications("foo.bar", bson.MongoTimestamp(1234), []interface{}{"a", oid, true, "b"})
+
+	// The boolean ID is skipped
+	wantChannels := []string{"foo.bar::a", "foo.bar::" + oid.Hex(), "foo.bar::b"}
+	if len(pubs) != len(wantChannels) {
+		t.Fatalf("Got %d publications, expected %d", len(pubs), len(wantChannels))
+	}
+
+	for i, pub := range pubs {
+		if pub.CollectionChannel != "foo.bar" || pub.SpecificChannel != wantChannels[i] {
+			t.Errorf("Got channels %s and %s, expected foo.bar and %s",
+				pub.CollectionChannel, pub.SpecificChannel, wantChannels[i])
+		}
+		if pub.OplogTimestamp != bson.MongoTimestamp(1234) {
+			t.Errorf("Got timestamp %d, expected 1234", pub.OplogTimestamp)
+		}
+		if pub.DedupeSuffix != wantChannels[i] {
+			t.Errorf("Got dedupe suffix %q, expected %q", pub.DedupeSuffix, wantChannels[i])
+		}
+	}
+
+	if string(pubs[0].Msg) != `{"e":"r","d":{"_id":"a"},"f":[]}` {
+		t.Errorf("Got message %s", pubs[0].Msg)
+	}
+}
+
+func TestRemovePublicationsVersioned(t *testing.T) {
+	pubs := (&Tailer{DocumentVersion: true}).removePublications("foo.bar", bson.MongoTimestamp(1234), []interface{}{"a"})
+	if len(pubs) != 1 {
+		t.Fatalf("Got %d publications, expected 1", len(pubs))
+	}
+
+	if string(pubs[0].Msg) != `{"e":"r","d":{"_id":"a"},"f":[],"v":"0000000000000001234"}` {
+		t.Errorf("Got message %s", pubs[0].Msg)
+	}
+}
+
+func TestRemovePublicationsSystemCollection(t *testing.T) {
+	pubs := (&Tailer{}).removePublications("foo.system.indexes", bson.MongoTimestamp(1234), []interface{}{"a"})
+	if len(pubs) != 0 {
+		t.Errorf("Got publications for a system collection: %#v", pubs)
+	}
+}
+
+// Commands that don't need a Mongo server to process
+func TestProcessCommand(t *testing.T) {
+	tests := map[string]bson.M{
+		"Drop":          {"drop": "bar"},
+		"Drop database": {"dropDatabase": 1},
+		"Create":        {"create": "bar"},
+		// Without RenameRemoves, we don't look up the documents
+		"Rename": {"renameCollection": "foo.bar", "to": "foo.baz", "dropTarget": false},
+	}
+
+	for testName, command := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pubs := (&Tailer{}).processCommand(&rawOplogEntry{
+				Timestamp: bson.MongoTimestamp(1234),
+				Operation: "c",
+				Namespace: "foo.$cmd",
+				Doc:       command,
+			})
+
+			if pubs != nil {
+				t.Errorf("Got publications %#v, expected none", pubs)
+			}
+		})
+	}
+}
+
+func TestProcessRenameFiltered(t *testing.T) {
+	var filtered []string
+	tailer := &Tailer{
+		namespaceFilter: func(database string, collection string) bool {
+			filtered = append(filtered, database+"."+collection)
+			return false
+		},
+	}
+
+	pubs, tooLarge := tailer.processRename("foo.bar", "foo.baz", bson.MongoTimestamp(1234))
+	if pubs != nil || tooLarge {
+		t.Errorf("Got publications %#v (too large: %t), expected none", pubs, tooLarge)
+	}
+
+	if !reflect.DeepEqual(filtered, []string{"foo.bar"}) {
+		t.Errorf("Filter was called with %v, expected [foo.bar]", filtered)
+	}
+}
+
+func TestProcessApplyOps(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "c",
+		"ns": "admin.$cmd",
+		"o": bson.M{
+			"applyOps": []bson.M{
+				{"op": "i", "ns": "foo.bar", "o": bson.M{"_id": "a", "hello": "world"}},
+				{"op": "u", "ns": "foo.baz", "o": bson.M{"$set": bson.M{"hello": "world"}}, "o2": bson.M{"_id": "b"}},
+				{"op": "n", "ns": "", "o": bson.M{"msg": "noop"}},
+				{"op": "c", "ns": "admin.$cmd", "o": bson.M{
+					"applyOps": []bson.M{
+						{"op": "d", "ns": "foo.bar", "o": bson.M{"_id": "c"}},
+					},
+				}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	pubs, ts := (&Tailer{}).unmarshalEntry(bson.Raw{Kind: 3, Data: data})
+	if ts == nil || *ts != bson.MongoTimestamp(1234) {
+		t.Errorf("Got timestamp %v, expected 1234", ts)
+	}
+
+	type publication struct {
+		channel      string
+		dedupeSuffix string
+		msg          string
+		moreInEntry  bool
+	}
+	var got []publication
+	for _, pub := range pubs {
+		if pub.OplogTimestamp != bson.MongoTimestamp(1234) {
+			t.Errorf("Got timestamp %d for %s, expected 1234", pub.OplogTimestamp, pub.SpecificChannel)
+		}
+		got = append(got, publication{pub.SpecificChannel, pub.DedupeSuffix, string(pub.Msg), pub.MoreInEntry})
+	}
+
+	// Only the last publication records the entry as processed
+	want := []publication{
+		{"foo.bar::a", "0", `{"e":"i","d":{"_id":"a"},"f":["_id","hello"]}`, true},
+		{"foo.baz::b", "1", `{"e":"u","d":{"_id":"b"},"f":["hello"]}`, true},
+		{"foo.bar::c", "3::0", `{"e":"r","d":{"_id":"c"},"f":[]}`, false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got publications %v, expected %v", got, want)
+	}
+}
+
+func TestProcessApplyOpsFiltered(t *testing.T) {
+	tailer := &Tailer{
+		namespaceFilter: func(database string, collection string) bool {
+			return collection != "baz"
+		},
+	}
+
+	var ops []bson.Raw
+	for _, op := range []bson.M{
+		{"op": "i", "ns": "foo.baz", "o": bson.M{"_id": "a"}},
+		{"op": "i", "ns": "foo.bar", "o": bson.M{"_id": "b"}},
+	} {
+		data, err := bson.Marshal(op)
+		if err != nil {
+			t.Fatalf("Could not marshal test operation: %s", err)
+		}
+		ops = append(ops, bson.Raw{Kind: 3, Data: data})
+	}
+
+	pubs := tailer.processApplyOps(ops, bson.MongoTimestamp(1234))
+
+	if len(pubs) != 1 || pubs[0].SpecificChannel != "foo.bar::b" || pubs[0].DedupeSuffix != "1" {
+		t.Errorf("Expected just the publication for foo.bar::b, got %#v", pubs)
+	}
+}
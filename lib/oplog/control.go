@@ -0,0 +1,115 @@
+package oplog
+
+import (
+	"context"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// Runtime controls of a Tailer, which are set from other goroutines (like
+// the admin API's) while it's tailing
+type control struct {
+	mutex sync.Mutex
+
+	paused bool
+
+	// Closed when the Tailer is resumed
+	resumed chan struct{}
+
+	// Timestamps of entries to skip
+	skip map[bson.MongoTimestamp]bool
+}
+
+// Pause stops the Tailer from processing entries until Resume is called.
+// Its cursor stays open, and the entry it's waiting to process is processed
+// once it's resumed. It's safe to call while the Tailer is tailing.
+func (tailer *Tailer) Pause() {
+	c := &tailer.control
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.paused {
+		return
+	}
+
+	log.Log.Warnw("Pausing oplog tailing",
+		"shard", tailer.Shard)
+	c.paused = true
+	c.resumed = make(chan struct{})
+}
+
+// Resume resumes a Tailer stopped by Pause. It's safe to call while the
+// Tailer is tailing.
+func (tailer *Tailer) Resume() {
+	c := &tailer.control
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.paused {
+		return
+	}
+
+	log.Log.Warnw("Resuming oplog tailing",
+		"shard", tailer.Shard)
+	c.paused = false
+	close(c.resumed)
+}
+
+// Skip makes the Tailer skip the entry with timestamp ts, if it hasn't read
+// it yet: the entry isn't processed or published, and is counted with the
+// status "skipped". This gets the Tailer past a poison entry (one that
+// can't be processed or published) without restarting it. It's safe to
+// call while the Tailer is tailing.
+func (tailer *Tailer) Skip(ts bson.MongoTimestamp) {
+	c := &tailer.control
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.skip == nil {
+		c.skip = map[bson.MongoTimestamp]bool{}
+	}
+	c.skip[ts] = true
+}
+
+// Returns whether the Tailer is paused
+func (c *control) isPaused() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.paused
+}
+
+// Blocks while the Tailer is paused. Returns false if ctx is cancelled
+// first.
+func (c *control) wait(ctx context.Context) bool {
+	c.mutex.Lock()
+	paused, resumed := c.paused, c.resumed
+	c.mutex.Unlock()
+
+	if !paused {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Returns whether the entry with timestamp ts should be skipped. Each
+// timestamp is only skipped once.
+func (c *control) skipped(ts bson.MongoTimestamp) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.skip[ts] {
+		return false
+	}
+
+	delete(c.skip, ts)
+	return true
+}
@@ -0,0 +1,124 @@
+package oplog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestPauseResume(t *testing.T) {
+	source := &fakeSource{}
+	source.add(t, bson.M{
+		"ts": bson.MongoTimestamp(2),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "a"},
+	})
+
+	tailer, err := NewTailer(WithSource(source), WithSink(&fakeSink{ts: bson.MongoTimestamp(1)}))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	tailer.Pause()
+	tailer.Pause()
+	if !tailer.Status().Paused {
+		t.Errorf("Tailer didn't report being paused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan *redispub.Publication)
+	go tailer.Tail(ctx, out)
+
+	select {
+	case pub := <-out:
+		t.Fatalf("Got publication %#v while paused", pub)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	tailer.Resume()
+	tailer.Resume()
+	if tailer.Status().Paused {
+		t.Errorf("Tailer reported being paused after it was resumed")
+	}
+
+	select {
+	case pub := <-out:
+		if pub.OplogTimestamp != bson.MongoTimestamp(2) {
+			t.Errorf("Got publication for timestamp %d, expected 2", pub.OplogTimestamp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for publication after resuming")
+	}
+}
+
+func TestPausedTailerStops(t *testing.T) {
+	source := &fakeSource{}
+	source.add(t, bson.M{
+		"ts": bson.MongoTimestamp(2),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "a"},
+	})
+
+	tailer, err := NewTailer(WithSource(source), WithSink(&fakeSink{ts: bson.MongoTimestamp(1)}))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	tailer.Pause()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		tailer.Tail(ctx, make(chan *redispub.Publication))
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Paused Tail did not return after its context was cancelled")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	var statuses []string
+	tailer := &Tailer{metricsHook: func(database string, status string, size int) {
+		statuses = append(statuses, status)
+	}}
+	tailer.Skip(bson.MongoTimestamp(1234))
+
+	insert, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "someid"},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	pubs, ts := tailer.unmarshalEntry(bson.Raw{Kind: 3, Data: insert})
+	if len(pubs) != 0 {
+		t.Errorf("Expected no publications for a skipped entry, got %d", len(pubs))
+	}
+	if ts == nil || *ts != bson.MongoTimestamp(1234) {
+		t.Errorf("Expected the skipped entry's timestamp, got %v", ts)
+	}
+
+	// Timestamps are only skipped once
+	if pub := tailer.Process(bson.Raw{Kind: 3, Data: insert}); pub == nil {
+		t.Errorf("Expected a publication the second time the entry was read")
+	}
+
+	if len(statuses) != 2 || statuses[0] != "skipped" || statuses[1] != "processed" {
+		t.Errorf("Got statuses %v, expected [skipped processed]", statuses)
+	}
+}
@@ -0,0 +1,120 @@
+package oplog
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+var metricDataGaps = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "data_gaps",
+	Help:      "Times we couldn't resume where we left off because the oplog had already rolled over, losing the changes in between, by shard",
+}, []string{"shard"})
+
+// The message published to the data gap channel
+type dataGapMessage struct {
+	// The position we tried to resume from (as "<seconds>:<increment>"), and
+	// the time it represents
+	From     string    `json:"from"`
+	FromTime time.Time `json:"fromTime"`
+
+	// The oldest entry still in the oplog, and the time it represents.
+	// Changes between From and Oldest weren't published.
+	Oldest     string    `json:"oldest"`
+	OldestTime time.Time `json:"oldestTime"`
+
+	// Where we restarted: "oldest" or "newest"
+	RestartFrom StartPosition `json:"restartFrom"`
+
+	Shard string `json:"shard,omitempty"`
+}
+
+// Checks whether the entries after startTime have already rolled off the
+// oplog (e.g. because we were stopped for longer than the oplog window). If
+// they have, it reports the gap, and returns where to start instead. It
+// returns false if ctx was cancelled.
+func (tailer *Tailer) checkForDataGap(ctx context.Context, out chan<- *redispub.Publication, source OplogSource, startTime bson.MongoTimestamp) (bson.MongoTimestamp, bool) {
+	oldestSource, ok := source.(oldestTimestampSource)
+	if !ok || startTime == 0 {
+		return startTime, true
+	}
+
+	oldest, err := oldestSource.OldestTimestamp()
+	if err != nil {
+		log.Log.Debugw("Couldn't find the oldest oplog entry; can't check for a data gap",
+			"error", err)
+		return startTime, true
+	}
+
+	// The entry right after startTime can't be any earlier than startTime+1,
+	// so if that's the oldest entry, nothing is missing
+	if oldest <= startTime+1 {
+		return startTime, true
+	}
+
+	restartFrom := tailer.DataGapRestart
+	if restartFrom != StartFromNewest {
+		restartFrom = StartFromOldest
+	}
+
+	log.Log.Errorw("DATA GAP: the position we're resuming from has already rolled off the oplog. Changes since then have been lost and won't be published.",
+		"from", FormatTimestamp(startTime),
+		"oldestEntry", FormatTimestamp(oldest),
+		"shard", tailer.Shard,
+		"restartFrom", restartFrom)
+	metricDataGaps.WithLabelValues(tailer.Shard).Inc()
+
+	restartTS := oldest - 1
+	if restartFrom == StartFromNewest {
+		newest, err := source.LastTimestamp()
+		if err != nil {
+			log.Log.Errorw("Error getting the latest oplog entry; restarting from the oldest instead",
+				"error", err)
+		} else {
+			restartTS = newest
+		}
+	}
+
+	if tailer.DataGapChannel == "" {
+		return restartTS, true
+	}
+
+	msg, err := json.Marshal(dataGapMessage{
+		From:        FormatTimestamp(startTime),
+		FromTime:    time.Unix(int64(startTime)>>32, 0).UTC(),
+		Oldest:      FormatTimestamp(oldest),
+		OldestTime:  time.Unix(int64(oldest)>>32, 0).UTC(),
+		RestartFrom: restartFrom,
+		Shard:       tailer.Shard,
+	})
+	if err != nil {
+		log.Log.Errorw("Error encoding data gap event",
+			"error", err)
+		return restartTS, true
+	}
+
+	pub := &redispub.Publication{
+		CollectionChannel: tailer.DataGapChannel,
+		Msg:               msg,
+		OplogTimestamp:    restartTS,
+		DedupeSuffix:      "dataGap::" + FormatTimestamp(startTime),
+		Shard:             tailer.Shard,
+		Meta:              true,
+	}
+
+	select {
+	case out <- pub:
+		tailer.hooks.publish(pub)
+		return restartTS, true
+	case <-ctx.Done():
+		return restartTS, false
+	}
+}
@@ -0,0 +1,117 @@
+package oplog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestCheckForDataGap(t *testing.T) {
+	tests := map[string]struct {
+		source    OplogSource
+		startTime bson.MongoTimestamp
+		restart   StartPosition
+		want      bson.MongoTimestamp
+		wantGap   bool
+	}{
+		"Source can't report its oldest entry": {
+			source:    &fakeSource{},
+			startTime: bson.MongoTimestamp(5 << 32),
+			want:      bson.MongoTimestamp(5 << 32),
+		},
+		"Oldest entry unavailable": {
+			source:    &fakeOldestSource{oldestErr: errors.New("not authorized on local")},
+			startTime: bson.MongoTimestamp(5 << 32),
+			want:      bson.MongoTimestamp(5 << 32),
+		},
+		"No gap": {
+			source:    &fakeOldestSource{oldest: bson.MongoTimestamp(3 << 32)},
+			startTime: bson.MongoTimestamp(5 << 32),
+			want:      bson.MongoTimestamp(5 << 32),
+		},
+		"Oldest entry is the next one": {
+			source:    &fakeOldestSource{oldest: bson.MongoTimestamp(5<<32 | 1)},
+			startTime: bson.MongoTimestamp(5 << 32),
+			want:      bson.MongoTimestamp(5 << 32),
+		},
+		"Gap restarts from the oldest entry": {
+			source:    &fakeOldestSource{oldest: bson.MongoTimestamp(10<<32 | 1)},
+			startTime: bson.MongoTimestamp(5 << 32),
+			want:      bson.MongoTimestamp(10 << 32),
+			wantGap:   true,
+		},
+		"Gap restarts from the newest entry": {
+			source:    &fakeOldestSource{oldest: bson.MongoTimestamp(10<<32 | 1)},
+			startTime: bson.MongoTimestamp(5 << 32),
+			restart:   StartFromNewest,
+			want:      bson.MongoTimestamp(1),
+			wantGap:   true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			tailer := &Tailer{
+				DataGapChannel: "datagaps",
+				DataGapRestart: test.restart,
+				Shard:          "shard1",
+			}
+			out := make(chan *redispub.Publication, 1)
+
+			got, ok := tailer.checkForDataGap(context.Background(), out, test.source, test.startTime)
+			if !ok {
+				t.Fatal("checkForDataGap reported cancellation")
+			}
+			if got != test.want {
+				t.Errorf("Got start time %d, expected %d", got, test.want)
+			}
+
+			if !test.wantGap {
+				if len(out) != 0 {
+					t.Errorf("Got a data gap event, expected none: %#v", <-out)
+				}
+				return
+			}
+
+			if len(out) != 1 {
+				t.Fatalf("Got %d data gap events, expected 1", len(out))
+			}
+
+			pub := <-out
+			if !pub.Meta || pub.CollectionChannel != "datagaps" || pub.Shard != "shard1" || pub.DedupeSuffix == "" {
+				t.Errorf("Got unexpected data gap event %#v", pub)
+			}
+
+			var msg dataGapMessage
+			if err := json.Unmarshal(pub.Msg, &msg); err != nil {
+				t.Fatal(err)
+			}
+
+			wantRestart := test.restart
+			if wantRestart == "" {
+				wantRestart = StartFromOldest
+			}
+			if msg.From != "5:0" || msg.Oldest != "10:1" || msg.RestartFrom != wantRestart || msg.Shard != "shard1" {
+				t.Errorf("Got unexpected data gap message %#v", msg)
+			}
+		})
+	}
+}
+
+func TestCheckForDataGapNoChannel(t *testing.T) {
+	tailer := &Tailer{}
+	out := make(chan *redispub.Publication, 1)
+	source := &fakeOldestSource{oldest: bson.MongoTimestamp(10<<32 | 1)}
+
+	got, ok := tailer.checkForDataGap(context.Background(), out, source, bson.MongoTimestamp(5<<32))
+	if !ok || got != bson.MongoTimestamp(10<<32) {
+		t.Errorf("Got start time %d (%v), expected %d", got, ok, bson.MongoTimestamp(10<<32))
+	}
+	if len(out) != 0 {
+		t.Errorf("Got a data gap event without a data gap channel")
+	}
+}
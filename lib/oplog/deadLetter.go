@@ -0,0 +1,142 @@
+package oplog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+var metricDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "dead_letters",
+	Help:      "Oplog entries that couldn't be processed and were published to the dead-letter channel (see OTR_DEAD_LETTER_CHANNEL), by database",
+}, []string{"database"})
+
+// The message published to the dead-letter channel for an entry
+type deadLetterMessage struct {
+	// Why the entry couldn't be processed
+	Error string `json:"error"`
+
+	// The entry, converted like documents are for EJSON
+	Entry map[string]interface{} `json:"entry"`
+}
+
+// Returns the dead letter for an entry that couldn't be processed, or nil if
+// there's no DeadLetterChannel
+func (tailer *Tailer) deadLetter(rawEntry *rawOplogEntry, err error) []*redispub.Publication {
+	if tailer.DeadLetterChannel == "" {
+		return nil
+	}
+
+	entry := map[string]interface{}{
+		"ts": rawEntry.Timestamp,
+		"op": rawEntry.Operation,
+		"ns": rawEntry.Namespace,
+		"o":  rawEntry.Doc,
+	}
+	if rawEntry.Update.ID != nil {
+		entry["o2"] = map[string]interface{}{"_id": rawEntry.Update.ID}
+	}
+
+	database, _ := parseNamespace(rawEntry.Namespace)
+	return tailer.deadLetterPublication(rawEntry.Timestamp, database, entry, err)
+}
+
+// Returns the dead letter for an entry that couldn't be unmarshalled, or nil
+// if there's no DeadLetterChannel. It's published as much as we can decode
+// of it, or as binary data if we can't decode it at all. Entries without a
+// timestamp can't be checkpointed, so they're only logged.
+func (tailer *Tailer) deadLetterRaw(rawData bson.Raw, err error) []*redispub.Publication {
+	if tailer.DeadLetterChannel == "" {
+		return nil
+	}
+
+	var entry map[string]interface{}
+	if rawData.Unmarshal(&entry) != nil {
+		entry = map[string]interface{}{"raw": rawData.Data}
+	}
+
+	ts, ok := entry["ts"].(bson.MongoTimestamp)
+	if !ok {
+		log.Log.Errorw("Can't publish oplog entry without a timestamp to the dead-letter channel",
+			"error", err)
+		return nil
+	}
+
+	ns, _ := entry["ns"].(string)
+	database, _ := parseNamespace(ns)
+	return tailer.deadLetterPublication(ts, database, entry, err)
+}
+
+func (tailer *Tailer) deadLetterPublication(ts bson.MongoTimestamp, database string, entry map[string]interface{}, err error) []*redispub.Publication {
+	tailer.redactDeadLetter(entry)
+
+	msg, marshalErr := json.Marshal(deadLetterMessage{
+		Error: err.Error(),
+		Entry: ejsonDocument(entry),
+	})
+	if marshalErr != nil {
+		log.Log.Errorw("Error encoding dead letter",
+			"error", marshalErr)
+		return nil
+	}
+
+	if database == "" {
+		database = "(no database)"
+	}
+	metricDeadLetters.WithLabelValues(database).Inc()
+
+	// Entries in an applyOps share a timestamp, so dead letters are
+	// deduplicated by their content
+	hash := sha256.Sum256(msg)
+
+	return []*redispub.Publication{{
+		CollectionChannel: tailer.DeadLetterChannel,
+		Msg:               msg,
+		OplogTimestamp:    ts,
+		DedupeSuffix:      "deadLetter::" + hex.EncodeToString(hash[:8]),
+		Shard:             tailer.Shard,
+		Meta:              true,
+	}}
+}
+
+// Applies the Tailer's RedactFields and HashFields rules for the entry's
+// collection to a dead letter's document or update ("o") and the ID of the
+// document it updates ("o2"), like redact does for messages
+func (tailer *Tailer) redactDeadLetter(entry map[string]interface{}) {
+	ns, _ := entry["ns"].(string)
+	redacted := tailer.RedactFields[ns]
+	hashed := tailer.HashFields[ns]
+	if len(redacted) == 0 && len(hashed) == 0 {
+		return
+	}
+
+	op, _ := entry["op"].(string)
+	doc, hasDoc := asMap(entry["o"])
+	update, hasUpdate := asMap(entry["o2"])
+
+	apply := func(field string, replace func(interface{}) interface{}) {
+		if hasDoc {
+			doc = redactEntryData(&oplogEntry{Operation: op, Data: doc}, field, replace)
+			entry["o"] = doc
+		}
+		if hasUpdate {
+			update = redactField(update, field, replace)
+			entry["o2"] = update
+		}
+	}
+
+	for _, field := range redacted {
+		apply(field, nil)
+	}
+	for _, field := range hashed {
+		apply(field, hashValue)
+	}
+}
@@ -0,0 +1,136 @@
+package oplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestDeadLetter(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(4),
+		"op": "u",
+		"ns": "foo.bar",
+		"o":  bson.M{"$set": bson.M{"a": 1}},
+		"o2": bson.M{"_id": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := bson.Raw{Kind: 3, Data: data}
+
+	// Without a dead-letter channel, the entry is just skipped
+	if pubs, _ := (&Tailer{}).unmarshalEntry(raw); len(pubs) != 0 {
+		t.Errorf("Got %d publications without a dead-letter channel, expected none", len(pubs))
+	}
+
+	tailer := &Tailer{DeadLetterChannel: "deadletters", Shard: "shard1"}
+	pubs, ts := tailer.unmarshalEntry(raw)
+	if len(pubs) != 1 {
+		t.Fatalf("Got %d publications, expected 1", len(pubs))
+	}
+	if ts == nil || *ts != 4 {
+		t.Errorf("Got timestamp %v, expected 4", ts)
+	}
+
+	pub := pubs[0]
+	if !pub.Meta || pub.CollectionChannel != "deadletters" || pub.OplogTimestamp != 4 || pub.Shard != "shard1" || pub.DedupeSuffix == "" {
+		t.Errorf("Got unexpected dead letter %#v", pub)
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(pub.Msg, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"error": "op.ID was of unsupported type bool",
+		"entry": map[string]interface{}{
+			"ts": float64(4),
+			"op": "u",
+			"ns": "foo.bar",
+			"o":  map[string]interface{}{"$set": map[string]interface{}{"a": float64(1)}},
+			"o2": map[string]interface{}{"_id": true},
+		},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("Got dead letter message %#v, expected %#v", msg, want)
+	}
+}
+
+func TestDeadLetterRaw(t *testing.T) {
+	tailer := &Tailer{DeadLetterChannel: "deadletters"}
+
+	data, err := bson.Marshal(bson.M{"ts": bson.MongoTimestamp(7), "ns": "foo.bar", "op": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubs := tailer.deadLetterRaw(bson.Raw{Kind: 3, Data: data}, errors.New("Some error"))
+	if len(pubs) != 1 {
+		t.Fatalf("Got %d publications, expected 1", len(pubs))
+	}
+	if pubs[0].OplogTimestamp != 7 {
+		t.Errorf("Got timestamp %d, expected 7", pubs[0].OplogTimestamp)
+	}
+
+	expectedMsg := `{"error":"Some error","entry":{"ns":"foo.bar","op":"x","ts":7}}`
+	if string(pubs[0].Msg) != expectedMsg {
+		t.Errorf("Got message %s, expected %s", pubs[0].Msg, expectedMsg)
+	}
+
+	// Entries we can't read a timestamp from can't be dead-lettered
+	pubs = tailer.deadLetterRaw(bson.Raw{Kind: 3, Data: []byte{1, 2, 3}}, errors.New("Some error"))
+	if len(pubs) != 0 {
+		t.Errorf("Got %d publications for an unreadable entry, expected none", len(pubs))
+	}
+}
+
+func TestDeadLetterRedacted(t *testing.T) {
+	tailer := &Tailer{
+		DeadLetterChannel: "deadletters",
+		RedactFields:      map[string][]string{"foo.bar": {"secret"}},
+		HashFields:        map[string][]string{"foo.bar": {"email"}},
+	}
+
+	entries := map[string]*rawOplogEntry{
+		"Insert": {
+			Timestamp: 4,
+			Operation: "i",
+			Namespace: "foo.bar",
+			Doc:       map[string]interface{}{"_id": "a", "secret": "hunter2", "email": "a@example.com"},
+		},
+		"Update": {
+			Timestamp: 5,
+			Operation: "u",
+			Namespace: "foo.bar",
+			Doc:       map[string]interface{}{"$set": map[string]interface{}{"secret": "hunter2", "email": "a@example.com"}},
+			Update:    rawOplogEntryID{ID: "a"},
+		},
+	}
+
+	for name, entry := range entries {
+		t.Run(name, func(t *testing.T) {
+			pubs := tailer.deadLetter(entry, errors.New("Some error"))
+			if len(pubs) != 1 {
+				t.Fatalf("Got %d publications, expected 1", len(pubs))
+			}
+
+			if bytes.Contains(pubs[0].Msg, []byte("secret")) || bytes.Contains(pubs[0].Msg, []byte("hunter2")) {
+				t.Errorf("Expected the redacted field to be left out of the dead letter, got %s", pubs[0].Msg)
+			}
+			if bytes.Contains(pubs[0].Msg, []byte("a@example.com")) || !bytes.Contains(pubs[0].Msg, []byte(hashValue("a@example.com").(string))) {
+				t.Errorf("Expected the hashed field to be hashed in the dead letter, got %s", pubs[0].Msg)
+			}
+		})
+	}
+
+	// The entry itself isn't modified
+	if entries["Insert"].Doc["secret"] != "hunter2" {
+		t.Errorf("Expected the entry to be left alone, got %#v", entries["Insert"].Doc)
+	}
+}
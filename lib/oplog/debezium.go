@@ -0,0 +1,187 @@
+package oplog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// A change event in the format of Debezium's MongoDB connector (with the
+// JSON converter's schemas disabled). See
+// https://debezium.io/documentation/reference/1.9/connectors/mongodb.html#mongodb-events
+type debeziumEvent struct {
+	// Documents are MongoDB extended JSON, encoded as strings. The oplog
+	// doesn't have the document before the change, so Before is always null.
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+	Patch  *string `json:"patch"`
+	Filter *string `json:"filter"`
+
+	Source debeziumSource `json:"source"`
+
+	// "c" (create), "u" (update), or "d" (delete)
+	Op string `json:"op"`
+
+	// When the change was written, in milliseconds. (Debezium uses the time
+	// it processed the change, but that would make every message for an
+	// entry different.)
+	TimestampMillis int64 `json:"ts_ms"`
+}
+
+type debeziumSource struct {
+	Version         string `json:"version"`
+	Connector       string `json:"connector"`
+	Name            string `json:"name"`
+	TimestampMillis int64  `json:"ts_ms"`
+	Snapshot        string `json:"snapshot"`
+	Database        string `json:"db"`
+	ReplicaSet      string `json:"rs"`
+	Collection      string `json:"collection"`
+	Order           int64  `json:"ord"`
+}
+
+// The Debezium op of each operation
+var debeziumOps = map[string]string{
+	operationInsert: "c",
+	operationUpdate: "u",
+	operationRemove: "d",
+}
+
+// Returns the message for an entry in Debezium's format: inserts and
+// replacements have the document in "after", other updates have the update
+// in "patch", and updates and removes have the document's _id in "filter".
+// With FullDocument set, "after" has the document for updates too (with
+// ChangedValues, the values that were set). The source's name is the cluster
+// name ("oplogtoredis" if there isn't one), and its rs is the shard name.
+func debeziumMessage(op *oplogEntry) ([]byte, error) {
+	event := debeziumEvent{
+		Source: debeziumSource{
+			Version:         "oplogtoredis",
+			Connector:       "mongodb",
+			Name:            op.Cluster,
+			TimestampMillis: (int64(op.Timestamp) >> 32) * 1000,
+			Snapshot:        "false",
+			Database:        op.Database,
+			ReplicaSet:      op.Shard,
+			Collection:      op.Collection,
+			Order:           int64(op.Timestamp) & 0xFFFFFFFF,
+		},
+		Op:              debeziumOps[op.Operation],
+		TimestampMillis: op.wallTime().UnixNano() / int64(time.Millisecond),
+	}
+	if event.Source.Name == "" {
+		event.Source.Name = "oplogtoredis"
+	}
+
+	var err error
+	switch {
+	case op.IsInsert() || (op.IsUpdate() && op.UpdateIsReplace()):
+		event.After, err = extendedJSONString(op.Data)
+	case op.IsUpdate():
+		event.Patch, err = extendedJSONString(op.Data)
+		if err == nil && op.FullDocument != nil {
+			event.After, err = extendedJSONString(op.FullDocument)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if op.IsUpdate() || op.IsRemove() {
+		event.Filter, err = extendedJSONString(map[string]interface{}{"_id": op.DocID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(&event)
+}
+
+// Returns a document as a string of MongoDB (canonical) extended JSON,
+// which is how Debezium encodes documents
+func extendedJSONString(doc map[string]interface{}) (*string, error) {
+	data, err := json.Marshal(extendedJSONValue(doc))
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding document as extended JSON: %s", err)
+	}
+
+	s := string(data)
+	return &s, nil
+}
+
+// Converts a value to the form it takes in MongoDB extended JSON. See
+// https://docs.mongodb.com/manual/reference/mongodb-extended-json/
+// nolint: gocyclo
+func extendedJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		return extendedJSONValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			converted[key] = extendedJSONValue(elem)
+		}
+		return converted
+	case bson.D:
+		converted := make(map[string]interface{}, len(v))
+		for _, elem := range v {
+			converted[elem.Name] = extendedJSONValue(elem.Value)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, elem := range v {
+			converted[i] = extendedJSONValue(elem)
+		}
+		return converted
+	case bson.ObjectId:
+		return map[string]string{"$oid": v.Hex()}
+	case time.Time:
+		return map[string]interface{}{
+			"$date": map[string]string{"$numberLong": strconv.FormatInt(v.UnixNano()/int64(time.Millisecond), 10)},
+		}
+	case int:
+		return map[string]string{"$numberInt": strconv.Itoa(v)}
+	case int64:
+		return map[string]string{"$numberLong": strconv.FormatInt(v, 10)}
+	case float64:
+		if math.IsNaN(v) {
+			return map[string]string{"$numberDouble": "NaN"}
+		} else if math.IsInf(v, 1) {
+			return map[string]string{"$numberDouble": "Infinity"}
+		} else if math.IsInf(v, -1) {
+			return map[string]string{"$numberDouble": "-Infinity"}
+		}
+		return map[string]string{"$numberDouble": strconv.FormatFloat(v, 'g', -1, 64)}
+	case []byte:
+		return extendedJSONBinary(v, 0)
+	case bson.Binary:
+		return extendedJSONBinary(v.Data, v.Kind)
+	case bson.Decimal128:
+		return map[string]string{"$numberDecimal": v.String()}
+	case bson.RegEx:
+		return map[string]interface{}{
+			"$regularExpression": map[string]string{"pattern": v.Pattern, "options": v.Options},
+		}
+	case bson.MongoTimestamp:
+		return map[string]interface{}{
+			"$timestamp": map[string]int64{"t": int64(v) >> 32, "i": int64(v) & 0xFFFFFFFF},
+		}
+	default:
+		return v
+	}
+}
+
+func extendedJSONBinary(data []byte, kind byte) interface{} {
+	return map[string]interface{}{
+		"$binary": map[string]string{
+			"base64":  base64.StdEncoding.EncodeToString(data),
+			"subType": fmt.Sprintf("%02x", kind),
+		},
+	}
+}
@@ -0,0 +1,187 @@
+package oplog
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestProcessOplogEntryDebezium(t *testing.T) {
+	tests := map[string]struct {
+		operation    string
+		data         map[string]interface{}
+		fullDocument map[string]interface{}
+		cluster      string
+		wantOp       string
+		wantAfter    interface{}
+		wantPatch    interface{}
+		wantFilter   interface{}
+		wantName     string
+	}{
+		"Insert": {
+			operation: "i",
+			data:      map[string]interface{}{"_id": "someid", "n": 1},
+			wantOp:    "c",
+			wantAfter: `{"_id":"someid","n":{"$numberInt":"1"}}`,
+			wantName:  "oplogtoredis",
+		},
+		"Update": {
+			operation:  "u",
+			data:       map[string]interface{}{"$set": map[string]interface{}{"a": "b"}},
+			cluster:    "eu-west",
+			wantOp:     "u",
+			wantPatch:  `{"$set":{"a":"b"}}`,
+			wantFilter: `{"_id":"someid"}`,
+			wantName:   "eu-west",
+		},
+		"Update with the full document": {
+			operation:    "u",
+			data:         map[string]interface{}{"$set": map[string]interface{}{"a": "b"}},
+			fullDocument: map[string]interface{}{"_id": "someid", "a": "b"},
+			wantOp:       "u",
+			wantAfter:    `{"_id":"someid","a":"b"}`,
+			wantPatch:    `{"$set":{"a":"b"}}`,
+			wantFilter:   `{"_id":"someid"}`,
+			wantName:     "oplogtoredis",
+		},
+		"Replacement": {
+			operation:  "u",
+			data:       map[string]interface{}{"_id": "someid", "a": "b"},
+			wantOp:     "u",
+			wantAfter:  `{"_id":"someid","a":"b"}`,
+			wantFilter: `{"_id":"someid"}`,
+			wantName:   "oplogtoredis",
+		},
+		"Remove": {
+			operation:  "d",
+			data:       map[string]interface{}{"_id": "someid"},
+			wantOp:     "d",
+			wantFilter: `{"_id":"someid"}`,
+			wantName:   "oplogtoredis",
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pub, err := processOplogEntry(&oplogEntry{
+				DocID:         "someid",
+				Timestamp:     bson.MongoTimestamp(1500000000<<32 | 3),
+				WallTime:      time.Unix(1500000000, 250000000),
+				Operation:     test.operation,
+				Namespace:     "foo.bar",
+				Database:      "foo",
+				Collection:    "bar",
+				Data:          test.data,
+				FullDocument:  test.fullDocument,
+				PayloadFormat: PayloadFormatDebezium,
+				Cluster:       test.cluster,
+				Shard:         "rs0",
+			})
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			var event map[string]interface{}
+			if err := json.Unmarshal(pub.Msg, &event); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]interface{}{
+				"before": nil,
+				"after":  test.wantAfter,
+				"patch":  test.wantPatch,
+				"filter": test.wantFilter,
+				"source": map[string]interface{}{
+					"version":    "oplogtoredis",
+					"connector":  "mongodb",
+					"name":       test.wantName,
+					"ts_ms":      float64(1500000000000),
+					"snapshot":   "false",
+					"db":         "foo",
+					"rs":         "rs0",
+					"collection": "bar",
+					"ord":        float64(3),
+				},
+				"op":    test.wantOp,
+				"ts_ms": float64(1500000000250),
+			}
+			if !reflect.DeepEqual(event, want) {
+				t.Errorf("Got event %#v, expected %#v", event, want)
+			}
+
+			// The channels are unchanged
+			if pub.CollectionChannel != "foo.bar" || pub.SpecificChannel != "foo.bar::someid" {
+				t.Errorf("Got channels %s and %s", pub.CollectionChannel, pub.SpecificChannel)
+			}
+		})
+	}
+}
+
+func TestExtendedJSONValue(t *testing.T) {
+	tests := map[string]struct {
+		value interface{}
+		want  string
+	}{
+		"String": {
+			value: "foo",
+			want:  `"foo"`,
+		},
+		"ObjectId": {
+			value: bson.ObjectIdHex("5a1f4c1e2d3b4a5c6d7e8f90"),
+			want:  `{"$oid":"5a1f4c1e2d3b4a5c6d7e8f90"}`,
+		},
+		"Date": {
+			value: time.Unix(1500000000, 250000000),
+			want:  `{"$date":{"$numberLong":"1500000000250"}}`,
+		},
+		"Int": {
+			value: 42,
+			want:  `{"$numberInt":"42"}`,
+		},
+		"Long": {
+			value: int64(1) << 40,
+			want:  `{"$numberLong":"1099511627776"}`,
+		},
+		"Double": {
+			value: 1.5,
+			want:  `{"$numberDouble":"1.5"}`,
+		},
+		"NaN": {
+			value: math.NaN(),
+			want:  `{"$numberDouble":"NaN"}`,
+		},
+		"Binary": {
+			value: bson.Binary{Kind: 4, Data: []byte("abc")},
+			want:  `{"$binary":{"base64":"YWJj","subType":"04"}}`,
+		},
+		"Regular expression": {
+			value: bson.RegEx{Pattern: "^a", Options: "i"},
+			want:  `{"$regularExpression":{"options":"i","pattern":"^a"}}`,
+		},
+		"Timestamp": {
+			value: bson.MongoTimestamp(1500000000<<32 | 3),
+			want:  `{"$timestamp":{"i":3,"t":1500000000}}`,
+		},
+		"Nested": {
+			value: bson.M{"a": []interface{}{1, bson.D{{Name: "b", Value: int64(2)}}}},
+			want:  `{"a":[{"$numberInt":"1"},{"b":{"$numberLong":"2"}}]}`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got, err := json.Marshal(extendedJSONValue(test.value))
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if string(got) != test.want {
+				t.Errorf("Got %s, expected %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package oplog
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Returns the forms of a document's _id that go in its message and at the end
+// of its specific channel.
+//
+// Strings are used as they are, and ObjectIds are sent the way Meteor's EJSON
+// encodes them, with their hex string in the channel. Other types are wrapped
+// the same way as ObjectIds, as `{"$type": <type>, "$value": <value>}`, and
+// their channel suffix is "<type>:<value>", so a given _id always maps to the
+// same channel:
+//
+//   - 32-bit integers are "int", 64-bit integers "long", and doubles "double",
+//     with the number as a string, since JavaScript numbers can't hold every
+//     64-bit integer
+//   - UUIDs (binary subtype 4) are "uuid", in the usual hyphenated form
+//   - other binary data is "binary", base64-encoded
+//   - Decimal128s are "Decimal" (like in ejsonValue), as a string
+//   - documents are "document", with the document (in EJSON form) as the
+//     value, and its JSON encoding in the channel. Ordered documents (bson.D)
+//     keep their field order, since Mongo considers _ids with the same fields
+//     in a different order to be different; other documents' keys are sorted.
+//
+// Returns an error for any other type.
+// nolint: gocyclo
+func encodeDocumentID(id interface{}) (interface{}, string, error) {
+	var idType, idString string
+	var idValue interface{}
+
+	switch v := id.(type) {
+	case string:
+		return v, v, nil
+	case bson.ObjectId:
+		idHex := v.Hex()
+		return map[string]interface{}{
+			"$type":  "oid",
+			"$value": idHex,
+		}, idHex, nil
+	case int:
+		idType, idString = "int", strconv.Itoa(v)
+	case int32:
+		idType, idString = "int", strconv.FormatInt(int64(v), 10)
+	case int64:
+		idType, idString = "long", strconv.FormatInt(v, 10)
+	case float64:
+		idType, idString = "double", strconv.FormatFloat(v, 'g', -1, 64)
+	case bson.Decimal128:
+		idType, idString = "Decimal", v.String()
+	case bson.Binary:
+		if v.Kind == 0x04 && len(v.Data) == 16 {
+			idType, idString = "uuid", formatUUID(v.Data)
+		} else {
+			idType, idString = "binary", base64.StdEncoding.EncodeToString(v.Data)
+		}
+	case []byte:
+		idType, idString = "binary", base64.StdEncoding.EncodeToString(v)
+	case bson.D:
+		doc := ejsonOrderedDocument(v)
+		docJSON, err := json.Marshal(doc)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error marshalling document _id: %s", err)
+		}
+
+		idType, idString, idValue = "document", string(docJSON), doc
+	case bson.M:
+		return encodeDocumentID(map[string]interface{}(v))
+	case map[string]interface{}:
+		doc := ejsonDocument(v)
+		docJSON, err := json.Marshal(doc)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error marshalling document _id: %s", err)
+		}
+
+		idType, idString, idValue = "document", string(docJSON), doc
+	default:
+		return nil, "", fmt.Errorf("op.ID was of unsupported type %T", id)
+	}
+
+	if idValue == nil {
+		idValue = idString
+	}
+
+	forMessage := map[string]interface{}{
+		"$type":  idType,
+		"$value": idValue,
+	}
+
+	return forMessage, idType + ":" + idString, nil
+}
+
+// A document whose JSON encoding keeps the order of its fields
+type orderedDocument []bson.DocElem
+
+// MarshalJSON encodes the document as a JSON object, with its fields in order
+func (doc orderedDocument) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, elem := range doc {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(elem.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(elem.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Converts an ordered document like ejsonDocument does, keeping the order of
+// its fields (and of the fields of any ordered documents inside it)
+func ejsonOrderedDocument(doc bson.D) interface{} {
+	converted := make(orderedDocument, len(doc))
+	keys := make(map[string]interface{}, len(doc))
+	for i, elem := range doc {
+		value := elem.Value
+		if nested, ok := value.(bson.D); ok {
+			value = ejsonOrderedDocument(nested)
+		} else {
+			value = ejsonValue(value)
+		}
+
+		converted[i] = bson.DocElem{Name: elem.Name, Value: value}
+		keys[elem.Name] = value
+	}
+
+	if isEJSONLike(keys) {
+		// EJSON would mistake this object for one of its own types
+		return map[string]interface{}{"$escape": converted}
+	}
+
+	return converted
+}
+
+// Formats 16 bytes as a UUID, like 01234567-89ab-cdef-0123-456789abcdef
+func formatUUID(data []byte) string {
+	h := hex.EncodeToString(data)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// Returns the _id of a raw document (or nil if it doesn't have one). Embedded
+// documents are otherwise decoded as maps, which lose their field order, so
+// a composite _id is decoded as a bson.D: Mongo considers _ids with the same
+// fields in a different order to be different documents.
+func decodeDocumentID(doc bson.Raw) (interface{}, error) {
+	var fields struct {
+		ID bson.Raw `bson:"_id"`
+	}
+	err := doc.Unmarshal(&fields)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fields.ID.Kind {
+	case 0x00:
+		// No _id
+		return nil, nil
+	case 0x03:
+		var id bson.D
+		err = fields.ID.Unmarshal(&id)
+		return id, err
+	default:
+		var id interface{}
+		err = fields.ID.Unmarshal(&id)
+		return id, err
+	}
+}
+
+// Decodes a raw document, with its _id decoded by decodeDocumentID
+func decodeDocument(data bson.Raw) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	err := data.Unmarshal(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch doc["_id"].(type) {
+	case bson.M, map[string]interface{}:
+		doc["_id"], err = decodeDocumentID(data)
+	}
+
+	return doc, err
+}
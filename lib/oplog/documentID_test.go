@@ -0,0 +1,202 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestEncodeDocumentID(t *testing.T) {
+	decimal, err := bson.ParseDecimal128("12.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uuid := []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+	tests := map[string]struct {
+		in          interface{}
+		wantMessage interface{}
+		wantChannel string
+	}{
+		"String": {
+			in:          "someid",
+			wantMessage: "someid",
+			wantChannel: "someid",
+		},
+		"ObjectId": {
+			in:          bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3"),
+			wantMessage: map[string]interface{}{"$type": "oid", "$value": "5c8a9d5b1e2a4f0001a1b2c3"},
+			wantChannel: "5c8a9d5b1e2a4f0001a1b2c3",
+		},
+		"Int": {
+			in:          1234,
+			wantMessage: map[string]interface{}{"$type": "int", "$value": "1234"},
+			wantChannel: "int:1234",
+		},
+		"Long": {
+			in:          int64(9007199254740993),
+			wantMessage: map[string]interface{}{"$type": "long", "$value": "9007199254740993"},
+			wantChannel: "long:9007199254740993",
+		},
+		"Double": {
+			in:          1.5,
+			wantMessage: map[string]interface{}{"$type": "double", "$value": "1.5"},
+			wantChannel: "double:1.5",
+		},
+		"Decimal128": {
+			in:          decimal,
+			wantMessage: map[string]interface{}{"$type": "Decimal", "$value": "12.50"},
+			wantChannel: "Decimal:12.50",
+		},
+		"UUID": {
+			in:          bson.Binary{Kind: 0x04, Data: uuid},
+			wantMessage: map[string]interface{}{"$type": "uuid", "$value": "01234567-89ab-cdef-0123-456789abcdef"},
+			wantChannel: "uuid:01234567-89ab-cdef-0123-456789abcdef",
+		},
+		"Binary": {
+			in:          bson.Binary{Kind: 0x00, Data: []byte("hi")},
+			wantMessage: map[string]interface{}{"$type": "binary", "$value": "aGk="},
+			wantChannel: "binary:aGk=",
+		},
+		"Document": {
+			in: bson.M{"user": "ada", "ref": bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")},
+			wantMessage: map[string]interface{}{
+				"$type": "document",
+				"$value": map[string]interface{}{
+					"user": "ada",
+					"ref":  map[string]string{"$type": "oid", "$value": "5c8a9d5b1e2a4f0001a1b2c3"},
+				},
+			},
+			wantChannel: `document:{"ref":{"$type":"oid","$value":"5c8a9d5b1e2a4f0001a1b2c3"},"user":"ada"}`,
+		},
+		"Ordered document": {
+			in: bson.D{{Name: "user", Value: "ada"}, {Name: "n", Value: 1}},
+			wantMessage: map[string]interface{}{
+				"$type":  "document",
+				"$value": orderedDocument{{Name: "user", Value: "ada"}, {Name: "n", Value: 1}},
+			},
+			wantChannel: `document:{"user":"ada","n":1}`,
+		},
+		"Nested ordered document": {
+			in: bson.D{{Name: "b", Value: bson.D{{Name: "y", Value: 1}, {Name: "x", Value: 2}}}, {Name: "a", Value: bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")}},
+			wantMessage: map[string]interface{}{
+				"$type": "document",
+				"$value": orderedDocument{
+					{Name: "b", Value: orderedDocument{{Name: "y", Value: 1}, {Name: "x", Value: 2}}},
+					{Name: "a", Value: map[string]string{"$type": "oid", "$value": "5c8a9d5b1e2a4f0001a1b2c3"}},
+				},
+			},
+			wantChannel: `document:{"b":{"y":1,"x":2},"a":{"$type":"oid","$value":"5c8a9d5b1e2a4f0001a1b2c3"}}`,
+		},
+		"EJSON-like ordered document": {
+			in: bson.D{{Name: "$type", Value: "a"}, {Name: "$value", Value: "b"}},
+			wantMessage: map[string]interface{}{
+				"$type": "document",
+				"$value": map[string]interface{}{
+					"$escape": orderedDocument{{Name: "$type", Value: "a"}, {Name: "$value", Value: "b"}},
+				},
+			},
+			wantChannel: `document:{"$escape":{"$type":"a","$value":"b"}}`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			gotMessage, gotChannel, err := encodeDocumentID(test.in)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(gotMessage, test.wantMessage) {
+				t.Errorf("Got message _id %#v, expected %#v", gotMessage, test.wantMessage)
+			}
+			if gotChannel != test.wantChannel {
+				t.Errorf("Got channel suffix %q, expected %q", gotChannel, test.wantChannel)
+			}
+		})
+	}
+
+	if _, _, err := encodeDocumentID(nil); err == nil {
+		t.Errorf("Expected an error for a nil _id")
+	}
+}
+
+func TestEncodeDocumentIDFieldOrder(t *testing.T) {
+	// Mongo treats these as different _ids, so they must be published to
+	// different channels
+	_, channelAB, err := encodeDocumentID(bson.D{{Name: "a", Value: 1}, {Name: "b", Value: 2}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	_, channelBA, err := encodeDocumentID(bson.D{{Name: "b", Value: 2}, {Name: "a", Value: 1}})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if channelAB == channelBA {
+		t.Errorf("Expected _ids that differ only in field order to get different channels, both got %q", channelAB)
+	}
+}
+
+// Composite _ids read from the oplog must keep their field order, even
+// though the rest of the entry is decoded into maps
+func TestUnmarshalEntryCompositeID(t *testing.T) {
+	id := bson.D{{Name: "b", Value: 2}, {Name: "a", Value: bson.D{{Name: "y", Value: 1}, {Name: "x", Value: 2}}}}
+	_, idChannel, err := encodeDocumentID(id)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	tests := map[string]bson.D{
+		"Insert": {
+			{Name: "ts", Value: bson.MongoTimestamp(1234)},
+			{Name: "op", Value: "i"},
+			{Name: "ns", Value: "foo.bar"},
+			{Name: "o", Value: bson.D{{Name: "_id", Value: id}, {Name: "some", Value: "field"}}},
+		},
+		"Update": {
+			{Name: "ts", Value: bson.MongoTimestamp(1234)},
+			{Name: "op", Value: "u"},
+			{Name: "ns", Value: "foo.bar"},
+			{Name: "o", Value: bson.D{{Name: "$set", Value: bson.D{{Name: "some", Value: "field"}}}}},
+			{Name: "o2", Value: bson.D{{Name: "_id", Value: id}}},
+		},
+		"Remove": {
+			{Name: "ts", Value: bson.MongoTimestamp(1234)},
+			{Name: "op", Value: "d"},
+			{Name: "ns", Value: "foo.bar"},
+			{Name: "o", Value: bson.D{{Name: "_id", Value: id}}},
+		},
+		"ApplyOps": {
+			{Name: "ts", Value: bson.MongoTimestamp(1234)},
+			{Name: "op", Value: "c"},
+			{Name: "ns", Value: "admin.$cmd"},
+			{Name: "o", Value: bson.D{{Name: "applyOps", Value: []bson.D{{
+				{Name: "op", Value: "i"},
+				{Name: "ns", Value: "foo.bar"},
+				{Name: "o", Value: bson.D{{Name: "_id", Value: id}}},
+			}}}}},
+		},
+	}
+
+	for name, entry := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := bson.Marshal(entry)
+			if err != nil {
+				t.Fatalf("Could not marshal test entry: %s", err)
+			}
+
+			pubs, _ := (&Tailer{}).unmarshalEntry(bson.Raw{Kind: 3, Data: data})
+			if len(pubs) != 1 {
+				t.Fatalf("Got %d publications, expected 1", len(pubs))
+			}
+
+			if expected := "foo.bar::" + idChannel; pubs[0].SpecificChannel != expected {
+				t.Errorf("Got channel %s, expected %s", pubs[0].SpecificChannel, expected)
+			}
+		})
+	}
+}
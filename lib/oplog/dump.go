@@ -0,0 +1,126 @@
+package oplog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TailDump tails the oplog and writes a human-readable description of each
+// entry to w: the raw entry, the parsed entry, and the publication that
+// would be sent to Redis (or why there isn't one). It's a debugging aid for
+// figuring out why a change was or wasn't published, and doesn't need Redis.
+//
+// It starts after the given timestamp, or at the end of the oplog if start is
+// 0. If namespaces is non-empty, only entries for those namespaces are
+// printed. It returns when ctx is cancelled.
+func (tailer *Tailer) TailDump(ctx context.Context, w io.Writer, start bson.MongoTimestamp, namespaces []string) error {
+	oplogCollection := tailer.database("local").Collection("oplog.rs")
+
+	if start == 0 {
+		var err error
+		start, err = newMongoSource(tailer.database("local")).LastTimestamp()
+		if err != nil {
+			return fmt.Errorf("Could not read the end of the oplog: %s", err)
+		}
+	}
+
+	// Entries that aren't inserts, updates, or removes are only described
+	// as ignored, so there's no need to read commands (like applyOps) for
+	// other namespaces
+	query := bson.M{}
+	if len(namespaces) > 0 {
+		query["ns"] = bson.M{"$in": namespaces}
+	}
+	lastTimestamp := start
+
+	for {
+		query["ts"] = bson.M{"$gt": lastTimestamp}
+		opts := options.Find().
+			SetCursorType(options.TailableAwait).
+			SetMaxAwaitTime(requeryDuration).
+			SetOplogReplay(true).
+			SetSort(mongodb.Doc(bson.M{"$natural": 1}))
+		iter := mongodb.NewTailIter(oplogCollection.Find(ctx, mongodb.Doc(query), opts))
+
+		var rawData bson.Raw
+		for iter.Next(&rawData) {
+			ts := tailer.dumpEntry(w, rawData)
+			if ts != 0 {
+				lastTimestamp = ts
+			}
+
+			if ctx.Err() != nil {
+				return iter.Close()
+			}
+		}
+
+		if iter.Err() != nil {
+			err := iter.Err()
+			_ = iter.Close()
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return iter.Close()
+		}
+	}
+}
+
+// Writes the description of a single entry, returning its timestamp (or 0
+// if it couldn't be unmarshalled)
+func (tailer *Tailer) dumpEntry(w io.Writer, rawData bson.Raw) bson.MongoTimestamp {
+	var raw bson.M
+	var result rawOplogEntry
+
+	err := rawData.Unmarshal(&raw)
+	if err == nil {
+		err = unmarshalRawOplogEntry(rawData, &result)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "=== Unparseable entry: %s\n\n", err)
+		return 0
+	}
+
+	fmt.Fprintf(w, "=== %d:%d %s %s\n",
+		int64(result.Timestamp)>>32, int64(result.Timestamp)&0xFFFFFFFF,
+		result.Operation, result.Namespace)
+	fmt.Fprintf(w, "Raw entry:\n%s\n", dumpJSON(raw))
+
+	entry := tailer.parseRawOplogEntry(&result)
+	if entry == nil {
+		fmt.Fprintf(w, "Ignored: operation %q is not an insert, update, or remove\n\n", result.Operation)
+		return result.Timestamp
+	}
+
+	fmt.Fprintf(w, "Parsed entry:\n%s\n", dumpJSON(entry))
+	fmt.Fprintf(w, "Changed fields: %v\n", entry.ChangedFields())
+
+	pub, err := processOplogEntry(entry)
+	if err != nil {
+		fmt.Fprintf(w, "Error processing entry: %s\n\n", err)
+	} else if pub == nil {
+		fmt.Fprintf(w, "Ignored: nothing is published for this entry\n\n")
+	} else {
+		fmt.Fprintf(w, "Publication:\n  Channels: %s, %s\n  Message: %s\n\n",
+			pub.CollectionChannel, pub.SpecificChannel, pub.Msg)
+	}
+
+	return result.Timestamp
+}
+
+// Formats a value as indented JSON, falling back to Go syntax if it can't be
+// represented as JSON
+func dumpJSON(v interface{}) string {
+	out, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		return fmt.Sprintf("  %#v", v)
+	}
+
+	return "  " + string(out)
+}
@@ -0,0 +1,75 @@
+package oplog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestDumpEntry(t *testing.T) {
+	tests := map[string]struct {
+		in       bson.M
+		wantTS   bson.MongoTimestamp
+		contains []string
+	}{
+		"Insert": {
+			in: bson.M{
+				"ts": bson.MongoTimestamp(1526648511<<32 + 3),
+				"op": "i",
+				"ns": "foo.bar",
+				"o":  bson.M{"_id": "someid", "some": "field"},
+			},
+			wantTS: bson.MongoTimestamp(1526648511<<32 + 3),
+			contains: []string{
+				"=== 1526648511:3 i foo.bar",
+				"Changed fields: [",
+				"Channels: foo.bar, foo.bar::someid",
+				`Message: {"e":"i","d":{"_id":"someid"},"f":[`,
+			},
+		},
+		"Command": {
+			in: bson.M{
+				"ts": bson.MongoTimestamp(1234),
+				"op": "c",
+				"ns": "foo.$cmd",
+				"o":  bson.M{"drop": "bar"},
+			},
+			wantTS:   bson.MongoTimestamp(1234),
+			contains: []string{`Ignored: operation "c" is not an insert, update, or remove`},
+		},
+		"Unsupported ID": {
+			in: bson.M{
+				"ts": bson.MongoTimestamp(1234),
+				"op": "i",
+				"ns": "foo.bar",
+				"o":  bson.M{"_id": true},
+			},
+			wantTS:   bson.MongoTimestamp(1234),
+			contains: []string{"Error processing entry"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			data, err := bson.Marshal(test.in)
+			if err != nil {
+				t.Fatalf("Could not marshal test entry: %s", err)
+			}
+
+			var out bytes.Buffer
+			ts := (&Tailer{}).dumpEntry(&out, bson.Raw{Kind: 3, Data: data})
+
+			if ts != test.wantTS {
+				t.Errorf("Got timestamp %d, expected %d", ts, test.wantTS)
+			}
+
+			for _, want := range test.contains {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("Output did not contain %q. Output was:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
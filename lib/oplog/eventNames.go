@@ -0,0 +1,65 @@
+package oplog
+
+import (
+	"fmt"
+)
+
+// EventNames is the set of event names ("e") that messages are published
+// with
+type EventNames string
+
+const (
+	// EventNamesMeteor names events the way redis-oplog does: "i" for
+	// inserts, "u" for updates, and "r" for removes. This is the default.
+	EventNamesMeteor EventNames = "meteor"
+
+	// EventNamesOplog names events with their oplog operation codes: "i",
+	// "u", and "d"
+	EventNamesOplog EventNames = "oplog"
+
+	// EventNamesWords names events "insert", "update", and "remove"
+	EventNamesWords EventNames = "words"
+)
+
+// The event name for each operation, for each set of event names
+var eventNameTables = map[EventNames]map[string]string{
+	EventNamesMeteor: {
+		operationInsert: "i",
+		operationUpdate: "u",
+		operationRemove: "r",
+	},
+	EventNamesOplog: {
+		operationInsert: "i",
+		operationUpdate: "u",
+		operationRemove: "d",
+	},
+	EventNamesWords: {
+		operationInsert: "insert",
+		operationUpdate: "update",
+		operationRemove: "remove",
+	},
+}
+
+// ParseEventNames parses "meteor", "oplog", or "words"
+func ParseEventNames(value string) (EventNames, error) {
+	eventNames := EventNames(value)
+	if _, ok := eventNameTables[eventNames]; !ok {
+		return "", fmt.Errorf("Invalid event names %q: must be meteor, oplog, or words", value)
+	}
+
+	return eventNames, nil
+}
+
+// Returns the event name to publish for an entry, per its EventNames
+// (EventNamesMeteor if unset)
+func eventNameForOperation(op *oplogEntry) string {
+	table, ok := eventNameTables[op.EventNames]
+	if !ok {
+		table = eventNameTables[EventNamesMeteor]
+	}
+
+	if name, ok := table[op.Operation]; ok {
+		return name
+	}
+	return op.Operation
+}
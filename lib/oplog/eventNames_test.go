@@ -0,0 +1,66 @@
+package oplog
+
+import (
+	"testing"
+)
+
+func TestParseEventNames(t *testing.T) {
+	for _, value := range []string{"meteor", "oplog", "words"} {
+		eventNames, err := ParseEventNames(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(eventNames) != value {
+			t.Errorf("Parsed %q as %q", value, eventNames)
+		}
+	}
+
+	if _, err := ParseEventNames("long"); err == nil {
+		t.Error("Expected an error parsing invalid event names")
+	}
+}
+
+func TestEventNameForOperation(t *testing.T) {
+	tests := map[string]struct {
+		eventNames EventNames
+		want       map[string]string
+	}{
+		"Default": {
+			eventNames: "",
+			want:       map[string]string{"i": "i", "u": "u", "d": "r"},
+		},
+		"Meteor": {
+			eventNames: EventNamesMeteor,
+			want:       map[string]string{"i": "i", "u": "u", "d": "r"},
+		},
+		"Oplog": {
+			eventNames: EventNamesOplog,
+			want:       map[string]string{"i": "i", "u": "u", "d": "d"},
+		},
+		"Words": {
+			eventNames: EventNamesWords,
+			want:       map[string]string{"i": "insert", "u": "update", "d": "remove"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			for operation, want := range test.want {
+				got := eventNameForOperation(&oplogEntry{Operation: operation, EventNames: test.eventNames})
+				if got != want {
+					t.Errorf("Got event name %q for operation %q, expected %q", got, operation, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRemovePublicationsEventNames(t *testing.T) {
+	pubs := (&Tailer{EventNames: EventNamesOplog}).removePublications("foo.bar", 1234, []interface{}{"a"})
+	if len(pubs) != 1 {
+		t.Fatalf("Got %d publications, expected 1", len(pubs))
+	}
+
+	if string(pubs[0].Msg) != `{"e":"d","d":{"_id":"a"},"f":[]}` {
+		t.Errorf("Got message %s, expected a \"d\" event", pubs[0].Msg)
+	}
+}
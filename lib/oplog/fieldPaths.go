@@ -0,0 +1,63 @@
+package oplog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldPaths is how the changed fields in a message ("f") are reported,
+// when an update changes a field inside an embedded document or array
+type FieldPaths string
+
+const (
+	// FieldPathsFull reports the dotted path of each changed field, like
+	// "a.2.b". This is the default.
+	FieldPathsFull FieldPaths = "full"
+
+	// FieldPathsTopLevel reports only the top-level field of each changed
+	// field, like "a", which is how Meteor reports changed fields
+	FieldPathsTopLevel FieldPaths = "top-level"
+
+	// FieldPathsBoth reports the dotted path and the top-level field of
+	// each changed field
+	FieldPathsBoth FieldPaths = "both"
+)
+
+// ParseFieldPaths parses "full", "top-level", or "both"
+func ParseFieldPaths(value string) (FieldPaths, error) {
+	switch fieldPaths := FieldPaths(value); fieldPaths {
+	case FieldPathsFull, FieldPathsTopLevel, FieldPathsBoth:
+		return fieldPaths, nil
+	default:
+		return "", fmt.Errorf("Invalid field paths %q: must be full, top-level, or both", value)
+	}
+}
+
+// Returns the fields to report for the given changed fields. Fields are
+// deduplicated (an update to "a.b" and "a.c" changes "a" once), unless
+// they're reported in full, so that messages are unchanged by default.
+func normalizeFieldPaths(fields []string, fieldPaths FieldPaths) []string {
+	if fieldPaths != FieldPathsTopLevel && fieldPaths != FieldPathsBoth {
+		return fields
+	}
+
+	seen := make(map[string]bool, len(fields))
+	normalized := make([]string, 0, len(fields))
+	add := func(field string) {
+		if !seen[field] {
+			seen[field] = true
+			normalized = append(normalized, field)
+		}
+	}
+
+	for _, field := range fields {
+		topLevel := strings.SplitN(field, ".", 2)[0]
+		add(topLevel)
+
+		if fieldPaths == FieldPathsBoth {
+			add(field)
+		}
+	}
+
+	return normalized
+}
@@ -0,0 +1,86 @@
+package oplog
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldPaths(t *testing.T) {
+	for _, value := range []string{"full", "top-level", "both"} {
+		fieldPaths, err := ParseFieldPaths(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(fieldPaths) != value {
+			t.Errorf("Parsed %q as %q", value, fieldPaths)
+		}
+	}
+
+	if _, err := ParseFieldPaths("top"); err == nil {
+		t.Error("Expected an error parsing invalid field paths")
+	}
+}
+
+func TestNormalizeFieldPaths(t *testing.T) {
+	fields := []string{"a.2.b", "a.3", "c", "d.e"}
+
+	tests := map[string]struct {
+		fieldPaths FieldPaths
+		want       []string
+	}{
+		"Default": {
+			fieldPaths: "",
+			want:       []string{"a.2.b", "a.3", "c", "d.e"},
+		},
+		"Full": {
+			fieldPaths: FieldPathsFull,
+			want:       []string{"a.2.b", "a.3", "c", "d.e"},
+		},
+		"Top-level": {
+			fieldPaths: FieldPathsTopLevel,
+			want:       []string{"a", "c", "d"},
+		},
+		"Both": {
+			fieldPaths: FieldPathsBoth,
+			want:       []string{"a", "a.2.b", "a.3", "c", "d", "d.e"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got := normalizeFieldPaths(append([]string(nil), fields...), test.fieldPaths)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got fields %v, expected %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProcessOplogEntryFieldPaths(t *testing.T) {
+	pub, err := processOplogEntry(&oplogEntry{
+		DocID:      "someid",
+		Operation:  "u",
+		Namespace:  "foo.bar",
+		Database:   "foo",
+		Collection: "bar",
+		Data: map[string]interface{}{
+			"$set": map[string]interface{}{"a.2.b": 1, "a.3": 2, "c": 3},
+		},
+		FieldPaths: FieldPathsTopLevel,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	var msg struct {
+		Fields []string `json:"f"`
+	}
+	if err := json.Unmarshal(pub.Msg, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(msg.Fields, []string{"a", "c"}) {
+		t.Errorf("Got fields %v, expected [a c]", msg.Fields)
+	}
+}
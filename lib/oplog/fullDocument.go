@@ -0,0 +1,189 @@
+package oplog
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Fills in entry.FullDocument with the document as it is after the entry was
+// applied, for Tailers with FullDocument set. Inserts and replacements carry
+// the whole document in the oplog; for other updates we have to fetch it from
+// Mongo, so we get the current version of the document, which may include
+// later changes. Removes don't get a document. If the Tailer has a projection
+// for the entry's collection, the document only includes those fields.
+func (tailer *Tailer) addFullDocument(entry *oplogEntry) {
+	projection := tailer.FullDocumentProjections[entry.Namespace]
+
+	if entry.IsInsert() || (entry.IsUpdate() && entry.UpdateIsReplace()) {
+		entry.FullDocument = projectDocument(entry.Data, projection)
+		return
+	}
+
+	if !entry.IsUpdate() {
+		return
+	}
+
+	doc, err := tailer.fetchDocument(entry, projection)
+	if err == mongo.ErrNoDocuments {
+		// The document was removed since; we'll publish the remove soon
+		log.Log.Debugw("Document for full-document update no longer exists",
+			"namespace", entry.Namespace,
+			"id", entry.DocID)
+		return
+	} else if err != nil {
+		// We still publish the update, just without the document, so
+		// consumers that re-fetch documents still see it
+		log.Log.Errorw("Error fetching document for full-document update",
+			"namespace", entry.Namespace,
+			"id", entry.DocID,
+			"error", err)
+		tailer.hooks.error(fmt.Errorf("Error fetching document for full-document update in %s: %s", entry.Namespace, err))
+		return
+	}
+
+	entry.FullDocument = doc
+}
+
+// Fetches the current version of the document an entry is for, with only
+// the given fields (and _id) if there are any
+func (tailer *Tailer) fetchDocument(entry *oplogEntry, fields []string) (map[string]interface{}, error) {
+	if tailer.MongoClient == nil {
+		return nil, errors.New("No Mongo client to fetch the document with")
+	}
+
+	opts := options.FindOne()
+	if len(fields) > 0 {
+		selector := bson.M{}
+		for _, field := range fields {
+			selector[field] = 1
+		}
+		opts.SetProjection(mongodb.Doc(selector))
+	}
+
+	var doc map[string]interface{}
+	collection := tailer.database(entry.Database).Collection(entry.Collection)
+	err := mongodb.FindOne(collection, bson.M{"_id": entry.DocID}, &doc, opts)
+
+	return doc, err
+}
+
+// Returns a copy of doc with only the given top-level fields and _id, like
+// the projection fetchDocument uses. Returns doc itself if there are no
+// fields.
+func projectDocument(doc map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return doc
+	}
+
+	projected := map[string]interface{}{}
+	for _, field := range append([]string{"_id"}, fields...) {
+		if value, ok := doc[field]; ok {
+			projected[field] = value
+		}
+	}
+
+	return projected
+}
+
+// Converts a document to the form Meteor's EJSON expects once it's encoded as
+// JSON, so that ObjectIds, dates, and binary data survive the trip to
+// redis-oplog.
+func ejsonDocument(doc map[string]interface{}) map[string]interface{} {
+	converted := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		converted[key] = ejsonValue(value)
+	}
+
+	if isEJSONLike(converted) {
+		// EJSON would mistake this object for one of its own types
+		return map[string]interface{}{"$escape": converted}
+	}
+
+	return converted
+}
+
+// Converts a single value for ejsonDocument
+// nolint: gocyclo
+func ejsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		return ejsonDocument(v)
+	case map[string]interface{}:
+		return ejsonDocument(v)
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, elem := range v {
+			converted[i] = ejsonValue(elem)
+		}
+		return converted
+	case bson.ObjectId:
+		// The same format we use for ObjectId _ids
+		return map[string]string{
+			"$type":  "oid",
+			"$value": v.Hex(),
+		}
+	case time.Time:
+		return map[string]int64{
+			"$date": v.UnixNano() / int64(time.Millisecond),
+		}
+	case []byte:
+		return map[string]string{
+			"$binary": base64.StdEncoding.EncodeToString(v),
+		}
+	case bson.Binary:
+		return map[string]string{
+			"$binary": base64.StdEncoding.EncodeToString(v.Data),
+		}
+	case float64:
+		// JSON has no NaN or infinity
+		if math.IsNaN(v) {
+			return map[string]int{"$InfNaN": 0}
+		} else if math.IsInf(v, 1) {
+			return map[string]int{"$InfNaN": 1}
+		} else if math.IsInf(v, -1) {
+			return map[string]int{"$InfNaN": -1}
+		}
+		return v
+	case bson.Decimal128:
+		return map[string]string{
+			"$type":  "Decimal",
+			"$value": v.String(),
+		}
+	case bson.RegEx:
+		return map[string]string{
+			"$regexp": v.Pattern,
+			"$flags":  v.Options,
+		}
+	case bson.MongoTimestamp:
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+// Returns whether EJSON would decode an object with these keys as one of its
+// built-in or custom types rather than as a plain object
+func isEJSONLike(doc map[string]interface{}) bool {
+	has := func(key string) bool {
+		_, ok := doc[key]
+		return ok
+	}
+
+	switch len(doc) {
+	case 1:
+		return has("$date") || has("$binary") || has("$InfNaN") || has("$escape")
+	case 2:
+		return (has("$type") && has("$value")) || (has("$regexp") && has("$flags"))
+	default:
+		return false
+	}
+}
@@ -0,0 +1,136 @@
+package oplog
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestEJSONDocument(t *testing.T) {
+	tests := map[string]struct {
+		in   map[string]interface{}
+		want string
+	}{
+		"Plain values": {
+			in:   map[string]interface{}{"s": "str", "n": 1.5, "b": true, "null": nil},
+			want: `{"b":true,"n":1.5,"null":null,"s":"str"}`,
+		},
+		"ObjectId": {
+			in:   map[string]interface{}{"ref": bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")},
+			want: `{"ref":{"$type":"oid","$value":"5c8a9d5b1e2a4f0001a1b2c3"}}`,
+		},
+		"Date": {
+			in:   map[string]interface{}{"at": time.Unix(1500000000, 123*int64(time.Millisecond))},
+			want: `{"at":{"$date":1500000000123}}`,
+		},
+		"Binary": {
+			in:   map[string]interface{}{"raw": []byte("hi"), "typed": bson.Binary{Kind: 0x80, Data: []byte("hi")}},
+			want: `{"raw":{"$binary":"aGk="},"typed":{"$binary":"aGk="}}`,
+		},
+		"Non-finite numbers": {
+			in:   map[string]interface{}{"nan": math.NaN(), "inf": math.Inf(1), "ninf": math.Inf(-1)},
+			want: `{"inf":{"$InfNaN":1},"nan":{"$InfNaN":0},"ninf":{"$InfNaN":-1}}`,
+		},
+		"Regex": {
+			in:   map[string]interface{}{"re": bson.RegEx{Pattern: "^a", Options: "i"}},
+			want: `{"re":{"$flags":"i","$regexp":"^a"}}`,
+		},
+		"Nested": {
+			in: map[string]interface{}{
+				"obj": bson.M{"ref": bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")},
+				"arr": []interface{}{time.Unix(1, 0), bson.M{"a": 1}},
+			},
+			want: `{"arr":[{"$date":1000},{"a":1}],"obj":{"ref":{"$type":"oid","$value":"5c8a9d5b1e2a4f0001a1b2c3"}}}`,
+		},
+		"Escaped": {
+			in:   map[string]interface{}{"obj": bson.M{"$type": "x", "$value": "y"}},
+			want: `{"obj":{"$escape":{"$type":"x","$value":"y"}}}`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got, err := json.Marshal(ejsonDocument(test.in))
+			if err != nil {
+				t.Fatalf("Error marshalling document: %s", err)
+			}
+
+			if string(got) != test.want {
+				t.Errorf("Got %s, expected %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAddFullDocument(t *testing.T) {
+	doc := map[string]interface{}{"_id": "someid", "a": 1}
+
+	tests := map[string]struct {
+		in   *oplogEntry
+		want map[string]interface{}
+	}{
+		"Insert": {
+			in:   &oplogEntry{Operation: "i", DocID: "someid", Data: doc},
+			want: doc,
+		},
+		"Replacement update": {
+			in:   &oplogEntry{Operation: "u", DocID: "someid", Data: doc},
+			want: doc,
+		},
+		// Without a Mongo client the fetch fails, so we leave the document
+		// out rather than dropping the update
+		"Update that needs a fetch": {
+			in:   &oplogEntry{Operation: "u", DocID: "someid", Data: map[string]interface{}{"$set": bson.M{"a": 2}}},
+			want: nil,
+		},
+		"Remove": {
+			in:   &oplogEntry{Operation: "d", DocID: "someid", Data: map[string]interface{}{"_id": "someid"}},
+			want: nil,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			(&Tailer{}).addFullDocument(test.in)
+
+			if !reflect.DeepEqual(test.in.FullDocument, test.want) {
+				t.Errorf("Got full document %#v, expected %#v", test.in.FullDocument, test.want)
+			}
+		})
+	}
+}
+
+func TestAddFullDocumentProjection(t *testing.T) {
+	tailer := &Tailer{
+		FullDocumentProjections: map[string][]string{"foo.bar": {"a", "missing"}},
+	}
+
+	entry := &oplogEntry{
+		Operation: "i",
+		Namespace: "foo.bar",
+		DocID:     "someid",
+		Data:      map[string]interface{}{"_id": "someid", "a": 1, "b": 2},
+	}
+	tailer.addFullDocument(entry)
+
+	want := map[string]interface{}{"_id": "someid", "a": 1}
+	if !reflect.DeepEqual(entry.FullDocument, want) {
+		t.Errorf("Got full document %#v, expected %#v", entry.FullDocument, want)
+	}
+
+	other := &oplogEntry{
+		Operation: "i",
+		Namespace: "foo.other",
+		DocID:     "someid",
+		Data:      map[string]interface{}{"_id": "someid", "a": 1, "b": 2},
+	}
+	tailer.addFullDocument(other)
+
+	if !reflect.DeepEqual(other.FullDocument, other.Data) {
+		t.Errorf("Got full document %#v for a collection without a projection, expected the whole document", other.FullDocument)
+	}
+}
@@ -0,0 +1,45 @@
+// +build gofuzz
+
+package oplog
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Fuzz is the entry point for go-fuzz (https://github.com/dvyukov/go-fuzz).
+// It feeds data to a Tailer as a raw oplog entry, and panics if that
+// produces a publication that redis-oplog couldn't consume. To run it:
+//
+//	go-fuzz-build github.com/tulip/oplogtoredis/lib/oplog
+//	go-fuzz -bin oplog-fuzz.zip -workdir fuzz
+//
+// TestProcessRandomEntries checks the same properties on every test run.
+func Fuzz(data []byte) int {
+	pub := (&Tailer{}).Process(bson.Raw{Kind: 3, Data: data})
+	if pub == nil {
+		return 0
+	}
+
+	if !strings.HasPrefix(pub.SpecificChannel, pub.CollectionChannel+"::") {
+		panic("Specific channel " + pub.SpecificChannel + " is not in collection channel " + pub.CollectionChannel)
+	}
+
+	var msg struct {
+		Event  string                 `json:"e"`
+		Doc    map[string]interface{} `json:"d"`
+		Fields []string               `json:"f"`
+	}
+	err := json.Unmarshal(pub.Msg, &msg)
+	if err != nil {
+		panic("Publication message is not valid: " + err.Error())
+	}
+
+	if (msg.Event != "i" && msg.Event != "u" && msg.Event != "r") || msg.Doc["_id"] == nil {
+		panic("Publication message is not valid: " + string(pub.Msg))
+	}
+
+	return 1
+}
@@ -0,0 +1,288 @@
+package oplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Generates random oplog entries, values, and documents for property-based
+// tests
+type entryGenerator struct {
+	*rand.Rand
+}
+
+func (g entryGenerator) pick(options ...string) string {
+	return options[g.Intn(len(options))]
+}
+
+// Returns a random value of a random BSON type. depth limits how deeply
+// documents and arrays are nested.
+func (g entryGenerator) value(depth int) interface{} {
+	n := 12
+	if depth <= 0 {
+		// Don't generate documents or arrays
+		n = 10
+	}
+
+	switch g.Intn(n) {
+	case 0:
+		return g.pick("", "someid", "a.b", "::", "$weird", "unicode ☃", "\x00nul")
+	case 1:
+		return bson.NewObjectId()
+	case 2:
+		return g.Int63() - g.Int63()
+	case 3:
+		return int32(g.Int31())
+	case 4:
+		return g.NormFloat64()
+	case 5:
+		return g.Intn(2) == 0
+	case 6:
+		return nil
+	case 7:
+		return time.Unix(g.Int63n(1<<32), 0)
+	case 8:
+		return bson.Binary{Kind: byte(g.Intn(6)), Data: []byte(g.pick("", "abc", "\xff\xfe"))}
+	case 9:
+		return bson.MongoTimestamp(g.Int63())
+	case 10:
+		return g.document(depth - 1)
+	default:
+		arr := make([]interface{}, g.Intn(4))
+		for i := range arr {
+			arr[i] = g.value(depth - 1)
+		}
+		return arr
+	}
+}
+
+// Returns a document with random field names and values
+func (g entryGenerator) document(depth int) bson.M {
+	doc := bson.M{}
+	for i := g.Intn(4); i > 0; i-- {
+		doc[g.pick("a", "b", "a.b", "a.0.c", "$set", "", "_id", "é")] = g.value(depth)
+	}
+	return doc
+}
+
+// Returns an update document made of a random combination of operators
+func (g entryGenerator) update() bson.M {
+	update := bson.M{}
+	for i := g.Intn(4); i >= 0; i-- {
+		op := g.pick("$set", "$unset", "$inc", "$push", "$pull", "$rename", "$v", "$bogus", "notAnOperator")
+		if g.Intn(5) == 0 {
+			// Operators with non-document values
+			update[op] = g.value(0)
+		} else {
+			update[op] = g.document(2)
+		}
+	}
+	return update
+}
+
+// Returns a random oplog entry
+func (g entryGenerator) entry() bson.M {
+	entry := bson.M{
+		"ts": bson.MongoTimestamp(g.Int63()),
+		"h":  g.Int63(),
+		"v":  2,
+		"op": g.pick("i", "u", "d", "c", "n", "", "x"),
+		"ns": g.pick("foo.bar", "foo.bar.baz", "foo", "", ".", "foo.system.indexes", "foo.$cmd"),
+	}
+
+	var id interface{} = g.value(1)
+	if g.Intn(10) == 0 {
+		// Leave out the _id entirely
+		id = nil
+	}
+
+	switch entry["op"] {
+	case "u":
+		if g.Intn(2) == 0 {
+			entry["o"] = g.update()
+		} else {
+			entry["o"] = g.document(2)
+		}
+		entry["o2"] = bson.M{"_id": id}
+	default:
+		doc := g.document(2)
+		if id != nil {
+			doc["_id"] = id
+		}
+		entry["o"] = doc
+	}
+
+	// Occasionally replace a field with a value of the wrong type
+	if g.Intn(10) == 0 {
+		entry[g.pick("ts", "op", "ns", "o", "o2")] = g.value(1)
+	}
+
+	return entry
+}
+
+// Checks that a publication is one that redis-oplog can consume
+func checkPublication(pub *redispub.Publication) error {
+	if !strings.HasPrefix(pub.SpecificChannel, pub.CollectionChannel+"::") {
+		return fmt.Errorf("Specific channel %q is not in collection channel %q",
+			pub.SpecificChannel, pub.CollectionChannel)
+	}
+
+	var msg struct {
+		Event  string                 `json:"e"`
+		Doc    map[string]interface{} `json:"d"`
+		Fields []string               `json:"f"`
+	}
+	err := json.Unmarshal(pub.Msg, &msg)
+	if err != nil {
+		return fmt.Errorf("Message %s is not valid: %s", pub.Msg, err)
+	}
+
+	if msg.Event != "i" && msg.Event != "u" && msg.Event != "r" {
+		return fmt.Errorf("Message %s has unexpected event %q", pub.Msg, msg.Event)
+	}
+
+	if msg.Doc["_id"] == nil {
+		return fmt.Errorf("Message %s has no _id", pub.Msg)
+	}
+
+	if msg.Fields == nil {
+		return fmt.Errorf("Message %s has no field list", pub.Msg)
+	}
+
+	return nil
+}
+
+// Runs random oplog entries through the full processing path, and checks
+// that it never panics and only produces valid publications.
+func TestProcessRandomEntries(t *testing.T) {
+	seed := time.Now().UnixNano()
+	gen := entryGenerator{rand.New(rand.NewSource(seed))}
+	tailer := &Tailer{}
+
+	for i := 0; i < 2000; i++ {
+		entry := gen.entry()
+		data, err := bson.Marshal(entry)
+		if err != nil {
+			// Some generated values (like invalid UTF-8 keys) can't be
+			// marshalled; that's fine
+			continue
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("Panic processing entry %#v (seed %d): %v", entry, seed, r)
+				}
+			}()
+
+			pub := tailer.Process(bson.Raw{Kind: 3, Data: data})
+			if pub == nil {
+				return
+			}
+
+			err := checkPublication(pub)
+			if err != nil {
+				t.Errorf("Invalid publication for entry %#v (seed %d): %s", entry, seed, err)
+			}
+		}()
+	}
+}
+
+// Runs truncated and corrupted entries through the full processing path, and
+// checks that it never panics.
+func TestProcessCorruptEntries(t *testing.T) {
+	seed := time.Now().UnixNano()
+	gen := entryGenerator{rand.New(rand.NewSource(seed))}
+	tailer := &Tailer{}
+
+	for i := 0; i < 2000; i++ {
+		data, err := bson.Marshal(gen.entry())
+		if err != nil {
+			continue
+		}
+
+		if gen.Intn(4) == 0 {
+			data = data[:gen.Intn(len(data))]
+		}
+		for j := gen.Intn(4); j > 0 && len(data) > 0; j-- {
+			data[gen.Intn(len(data))] = byte(gen.Intn(256))
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("Panic processing %x (seed %d): %v", data, seed, r)
+				}
+			}()
+
+			pub := tailer.Process(bson.Raw{Kind: 3, Data: data})
+			if pub != nil {
+				err := checkPublication(pub)
+				if err != nil {
+					t.Errorf("Invalid publication for %x (seed %d): %s", data, seed, err)
+				}
+			}
+		}()
+	}
+}
+
+// Runs entries with _ids of every type through processOplogEntry, and checks
+// that each produces either a valid publication or an error.
+func TestProcessOplogEntryIDTypes(t *testing.T) {
+	gen := entryGenerator{rand.New(rand.NewSource(1))}
+
+	for i := 0; i < 500; i++ {
+		id := gen.value(2)
+		pub, err := processOplogEntry(&oplogEntry{
+			DocID:      id,
+			Operation:  gen.pick("i", "u", "d"),
+			Namespace:  "foo.bar",
+			Database:   "foo",
+			Collection: "bar",
+			Data:       gen.update(),
+		})
+
+		if err != nil {
+			if pub != nil {
+				t.Errorf("Got both a publication and an error for _id %#v", id)
+			}
+			continue
+		}
+
+		if pub == nil {
+			t.Errorf("Got neither a publication nor an error for _id %#v", id)
+			continue
+		}
+
+		err = checkPublication(pub)
+		if err != nil {
+			t.Errorf("Invalid publication for _id %#v: %s", id, err)
+		}
+	}
+}
+
+// Prometheus panics on label values that aren't valid UTF-8, so the database
+// name has to be sanitized before it's used as a label.
+func TestProcessInvalidUTF8Namespace(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1),
+		"op": "i",
+		"ns": "fo\xcd.bar",
+		"o":  bson.M{"_id": "someid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub := (&Tailer{}).Process(bson.Raw{Kind: 3, Data: data})
+	if pub == nil {
+		t.Fatal("Expected a publication")
+	}
+}
@@ -0,0 +1,141 @@
+package oplog
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Run `go test ./lib/oplog -run TestGolden -update` to rewrite the golden
+// files after an intentional change to the message format. Any such change
+// needs to be compatible with redis-oplog.
+var updateGolden = flag.Bool("update", false, "Update the golden files in testdata/golden")
+
+// A canonical set of oplog entries, covering every shape of publication we
+// produce. The golden file for each is testdata/golden/<name>.golden.
+var goldenEntries = map[string]bson.M{
+	"insert": {
+		"op": "i",
+		"ns": "tests.Foo",
+		"o":  bson.M{"_id": "someid", "hello": "world", "nested": bson.M{"a": 1}},
+	},
+	"insert_objectid": {
+		"op": "i",
+		"ns": "tests.Foo",
+		"o":  bson.M{"_id": bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3"), "hello": "world"},
+	},
+	"insert_unicode": {
+		"op": "i",
+		"ns": "tests.Foo",
+		"o":  bson.M{"_id": "ünïcødé ☃ <&>", "ключ": "value"},
+	},
+	"insert_dotted_collection": {
+		"op": "i",
+		"ns": "tests.foo.bar",
+		"o":  bson.M{"_id": "someid"},
+	},
+	"update_set": {
+		"op": "u",
+		"ns": "tests.Foo",
+		"o":  bson.M{"$set": bson.M{"hello": "new", "world": "new"}},
+		"o2": bson.M{"_id": "someid"},
+	},
+	"update_set_unset": {
+		"op": "u",
+		"ns": "tests.Foo",
+		"o":  bson.M{"$v": 1, "$set": bson.M{"a.b": 1, "c.0": 2}, "$unset": bson.M{"d": true}},
+		"o2": bson.M{"_id": "someid"},
+	},
+	"update_v2_diff": {
+		"op": "u",
+		"ns": "tests.Foo",
+		"o": bson.M{"$v": 2, "diff": bson.M{
+			"u":    bson.M{"hello": "new"},
+			"d":    bson.M{"old": false},
+			"sarr": bson.M{"a": true, "u1": "x"},
+		}},
+		"o2": bson.M{"_id": "someid"},
+	},
+	"update_replace": {
+		"op": "u",
+		"ns": "tests.Foo",
+		"o":  bson.M{"_id": "someid", "replaced": true, "other": 1},
+		"o2": bson.M{"_id": "someid"},
+	},
+	"update_objectid": {
+		"op": "u",
+		"ns": "tests.Foo",
+		"o":  bson.M{"$set": bson.M{"hello": "new"}},
+		"o2": bson.M{"_id": bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")},
+	},
+	"remove": {
+		"op": "d",
+		"ns": "tests.Foo",
+		"o":  bson.M{"_id": "someid"},
+	},
+	"remove_objectid": {
+		"op": "d",
+		"ns": "tests.Foo",
+		"o":  bson.M{"_id": bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3")},
+	},
+}
+
+// Serializes a publication for comparison with a golden file. The message is
+// included verbatim, since consumers parse it.
+func formatGolden(channels []string, msg []byte) []byte {
+	var buf bytes.Buffer
+	for _, channel := range channels {
+		fmt.Fprintf(&buf, "channel: %s\n", channel)
+	}
+	fmt.Fprintf(&buf, "message: %s\n", msg)
+
+	return buf.Bytes()
+}
+
+// Checks the publication for each canonical entry against its golden file,
+// byte for byte. redis-oplog parses these messages, so any change to them
+// must be deliberate.
+func TestGolden(t *testing.T) {
+	for name, entry := range goldenEntries {
+		t.Run(name, func(t *testing.T) {
+			entry["ts"] = bson.MongoTimestamp(1234 << 32)
+			entry["h"] = int64(5678)
+			entry["v"] = 2
+
+			data, err := bson.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pub := (&Tailer{}).Process(bson.Raw{Kind: 3, Data: data})
+			if pub == nil {
+				t.Fatal("Expected a publication")
+			}
+
+			got := formatGolden([]string{pub.CollectionChannel, pub.SpecificChannel}, pub.Msg)
+			path := filepath.Join("testdata", "golden", name+".golden")
+
+			if *updateGolden {
+				err = ioutil.WriteFile(path, got, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Error reading golden file (run with -update to create it): %s", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("Publication does not match %s.\nGot:\n%s\nWant:\n%s", path, got, want)
+			}
+		})
+	}
+}
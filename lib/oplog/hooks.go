@@ -0,0 +1,122 @@
+package oplog
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// EntryInfo describes an oplog entry that the Tailer received
+type EntryInfo struct {
+	Timestamp bson.MongoTimestamp
+	Namespace string
+
+	// The oplog operation: "i", "u", "d", "c", or "n"
+	Operation string
+
+	// Size of the raw entry in bytes
+	Size int
+}
+
+// Lifecycle hooks registered on a Tailer
+type hooks struct {
+	onEntry       []func(EntryInfo)
+	beforePublish []func(*redispub.Publication) bool
+	onPublish     []func(*redispub.Publication)
+	onError       []func(error)
+	onResume      []func(bson.MongoTimestamp)
+}
+
+// OnEntry registers a function to be called with each oplog entry received,
+// whether or not it produces a publication.
+//
+// Hooks are called synchronously from the tailing goroutine, so they should
+// be fast. They must be registered before Tail is called.
+func (tailer *Tailer) OnEntry(fn func(EntryInfo)) {
+	tailer.hooks.onEntry = append(tailer.hooks.onEntry, fn)
+}
+
+// BeforePublish registers a function to be called with each publication
+// before it's written to the output channel, which can change the
+// publication (for example, to redact fields from its message or add tenant
+// metadata) or drop it by returning false. Functions are called in the order
+// they were registered, and once one drops a publication, the rest aren't
+// called. An entry whose publications are all dropped is counted as
+// "filtered" in the metrics.
+//
+// Publications are ready to publish when these are called, so a function
+// that changes what a publication is about must keep its fields consistent:
+// for example, a change to Msg isn't reflected in the channels it's
+// published to, and vice versa.
+func (tailer *Tailer) BeforePublish(fn func(*redispub.Publication) bool) {
+	tailer.hooks.beforePublish = append(tailer.hooks.beforePublish, fn)
+}
+
+// OnPublish registers a function to be called with each publication, after
+// it's been written to the output channel.
+func (tailer *Tailer) OnPublish(fn func(*redispub.Publication)) {
+	tailer.hooks.onPublish = append(tailer.hooks.onPublish, fn)
+}
+
+// OnError registers a function to be called with each error the Tailer
+// encounters: oplog cursor errors, and entries that couldn't be unmarshalled
+// or processed. The Tailer recovers from all of these on its own.
+func (tailer *Tailer) OnError(fn func(error)) {
+	tailer.hooks.onError = append(tailer.hooks.onError, fn)
+}
+
+// OnResume registers a function to be called each time the Tailer starts (or
+// restarts) tailing, with the timestamp it's resuming after.
+func (tailer *Tailer) OnResume(fn func(bson.MongoTimestamp)) {
+	tailer.hooks.onResume = append(tailer.hooks.onResume, fn)
+}
+
+func (h *hooks) entry(info EntryInfo) {
+	for _, fn := range h.onEntry {
+		fn(info)
+	}
+}
+
+// Runs the BeforePublish hooks on each publication, and returns the ones
+// that weren't dropped
+func (h *hooks) filterPublications(pubs []*redispub.Publication) []*redispub.Publication {
+	if len(h.beforePublish) == 0 {
+		return pubs
+	}
+
+	kept := pubs[:0]
+	for _, pub := range pubs {
+		if h.keep(pub) {
+			kept = append(kept, pub)
+		}
+	}
+
+	return kept
+}
+
+func (h *hooks) keep(pub *redispub.Publication) bool {
+	for _, fn := range h.beforePublish {
+		if !fn(pub) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *hooks) publish(pub *redispub.Publication) {
+	for _, fn := range h.onPublish {
+		fn(pub)
+	}
+}
+
+func (h *hooks) error(err error) {
+	for _, fn := range h.onError {
+		fn(err)
+	}
+}
+
+func (h *hooks) resume(ts bson.MongoTimestamp) {
+	for _, fn := range h.onResume {
+		fn(ts)
+	}
+}
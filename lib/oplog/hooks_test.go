@@ -0,0 +1,150 @@
+package oplog
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestHooks(t *testing.T) {
+	source := &fakeSource{}
+	source.add(t, bson.M{"ts": bson.MongoTimestamp(2), "op": "i", "ns": "foo.bar", "o": bson.M{"_id": "a"}})
+	source.add(t, bson.M{"ts": bson.MongoTimestamp(3), "op": "c", "ns": "foo.$cmd", "o": bson.M{"drop": "bar"}})
+	// Floating-point IDs aren't supported, so this fails to process
+	source.add(t, bson.M{"ts": bson.MongoTimestamp(4), "op": "i", "ns": "foo.bar", "o": bson.M{"_id": true}})
+	source.add(t, bson.M{"ts": bson.MongoTimestamp(5), "op": "i", "ns": "foo.bar", "o": bson.M{"_id": "b"}})
+
+	tailer, err := NewTailer(WithSource(source), WithSink(&fakeSink{err: ErrNoLastProcessed}))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	var mutex sync.Mutex
+	var entries []string
+	var published []bson.MongoTimestamp
+	var errs []error
+	var resumed []bson.MongoTimestamp
+
+	tailer.OnEntry(func(info EntryInfo) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		entries = append(entries, info.Operation+" "+info.Namespace)
+	})
+	tailer.OnPublish(func(pub *redispub.Publication) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		published = append(published, pub.OplogTimestamp)
+	})
+	tailer.OnError(func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		errs = append(errs, err)
+	})
+	tailer.OnResume(func(ts bson.MongoTimestamp) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		resumed = append(resumed, ts)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan *redispub.Publication, 10)
+	done := make(chan bool)
+	go func() {
+		tailer.Tail(ctx, out)
+		close(done)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-out:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for publications")
+		}
+	}
+
+	cancel()
+	<-done
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(resumed) != 1 || resumed[0] != bson.MongoTimestamp(1) {
+		t.Errorf("Expected OnResume to be called with timestamp 1, got %v", resumed)
+	}
+
+	wantEntries := []string{"i foo.bar", "c foo.$cmd", "i foo.bar", "i foo.bar"}
+	if len(entries) != len(wantEntries) {
+		t.Fatalf("Got entries %v, expected %v", entries, wantEntries)
+	}
+	for i := range wantEntries {
+		if entries[i] != wantEntries[i] {
+			t.Errorf("Got entries %v, expected %v", entries, wantEntries)
+			break
+		}
+	}
+
+	if len(published) != 2 || published[0] != bson.MongoTimestamp(2) || published[1] != bson.MongoTimestamp(5) {
+		t.Errorf("Got published timestamps %v, expected [2 5]", published)
+	}
+
+	if len(errs) != 1 {
+		t.Errorf("Expected OnError to be called once, got %v", errs)
+	}
+}
+
+func TestBeforePublish(t *testing.T) {
+	var statuses []string
+	tailer, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithMetricsHook(func(database string, status string, size int) {
+		statuses = append(statuses, status)
+	}))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	var calls []string
+	tailer.BeforePublish(func(pub *redispub.Publication) bool {
+		calls = append(calls, "first")
+		pub.Msg = []byte(`{"redacted":true}`)
+		return pub.CollectionChannel != "foo.secret"
+	})
+	tailer.BeforePublish(func(pub *redispub.Publication) bool {
+		calls = append(calls, "second")
+		pub.ExtraChannels = append(pub.ExtraChannels, "tenant::acme")
+		return true
+	})
+
+	process := func(namespace string) []*redispub.Publication {
+		data, err := bson.Marshal(bson.M{"ts": bson.MongoTimestamp(1), "op": "i", "ns": namespace, "o": bson.M{"_id": "a"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pubs, _ := tailer.unmarshalEntry(bson.Raw{Kind: 3, Data: data})
+		return pubs
+	}
+
+	pubs := process("foo.bar")
+	if len(pubs) != 1 {
+		t.Fatalf("Got %d publications, expected 1", len(pubs))
+	}
+	if string(pubs[0].Msg) != `{"redacted":true}` || len(pubs[0].ExtraChannels) != 1 || pubs[0].ExtraChannels[0] != "tenant::acme" {
+		t.Errorf("BeforePublish hooks didn't change the publication: %#v", pubs[0])
+	}
+
+	if pubs := process("foo.secret"); len(pubs) != 0 {
+		t.Errorf("Got %d publications for a dropped entry, expected none", len(pubs))
+	}
+
+	wantCalls := []string{"first", "second", "first"}
+	if len(calls) != len(wantCalls) || calls[0] != wantCalls[0] || calls[1] != wantCalls[1] || calls[2] != wantCalls[2] {
+		t.Errorf("Got hook calls %v, expected %v", calls, wantCalls)
+	}
+
+	if len(statuses) != 2 || statuses[0] != "processed" || statuses[1] != "filtered" {
+		t.Errorf("Got statuses %v, expected [processed filtered]", statuses)
+	}
+}
@@ -0,0 +1,49 @@
+package oplog
+
+import (
+	"fmt"
+	"path"
+)
+
+// NewGlobNamespaceFilter creates a NamespaceFilter from glob patterns (in the
+// syntax of path.Match) that are matched against "<database>.<collection>".
+// "*" matches any run of characters, including dots, so "analytics.*"
+// matches every collection in the analytics database, and "*.sessions"
+// matches the sessions collection of every database.
+//
+// If include is non-empty, only collections that match one of its patterns
+// are processed; collections that match one of exclude's patterns never are.
+// It returns a nil filter (which processes everything) if both are empty, and
+// an error if any pattern is malformed.
+func NewGlobNamespaceFilter(include []string, exclude []string) (NamespaceFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid namespace pattern %q: %s", pattern, err)
+		}
+	}
+
+	return func(database string, collection string) bool {
+		namespace := database + "." + collection
+
+		if len(include) > 0 && !matchesAny(include, namespace) {
+			return false
+		}
+
+		return !matchesAny(exclude, namespace)
+	}, nil
+}
+
+// Returns whether namespace matches any of the (already validated) patterns
+func matchesAny(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package oplog
+
+import (
+	"testing"
+)
+
+func TestGlobNamespaceFilter(t *testing.T) {
+	tests := map[string]struct {
+		include  []string
+		exclude  []string
+		expected map[string]bool
+	}{
+		"Include": {
+			include: []string{"app.*", "*.users"},
+			expected: map[string]bool{
+				"app.tasks":       true,
+				"app.tasks.items": true,
+				"other.users":     true,
+				"other.tasks":     false,
+			},
+		},
+		"Exclude": {
+			exclude: []string{"app.events", "analytics.*"},
+			expected: map[string]bool{
+				"app.tasks":        true,
+				"app.events":       false,
+				"app.events2":      true,
+				"analytics.clicks": false,
+			},
+		},
+		"Include and exclude": {
+			include: []string{"app.*"},
+			exclude: []string{"app.events*"},
+			expected: map[string]bool{
+				"app.tasks":     true,
+				"app.events":    false,
+				"app.eventsLog": false,
+				"other.tasks":   false,
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			filter, err := NewGlobNamespaceFilter(test.include, test.exclude)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			for namespace, expected := range test.expected {
+				database, collection := parseNamespace(namespace)
+				if got := filter(database, collection); got != expected {
+					t.Errorf("filter(%q) = %t, expected %t", namespace, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestGlobNamespaceFilterEmpty(t *testing.T) {
+	filter, err := NewGlobNamespaceFilter(nil, nil)
+	if err != nil || filter != nil {
+		t.Errorf("Expected a nil filter and no error, got error %v", err)
+	}
+}
+
+func TestGlobNamespaceFilterInvalid(t *testing.T) {
+	_, err := NewGlobNamespaceFilter([]string{"app.*"}, []string{"app.[events"})
+	if err == nil {
+		t.Errorf("Expected an error for a malformed pattern")
+	}
+}
@@ -1,6 +1,10 @@
 package oplog
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/globalsign/mgo/bson"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -10,6 +14,7 @@ import (
 const operationInsert = "i"
 const operationUpdate = "u"
 const operationRemove = "d"
+const operationCommand = "c"
 
 var metricUnprocessableChangedFields = promauto.NewCounter(prometheus.CounterOpts{
 	Namespace: "otr",
@@ -23,11 +28,69 @@ var metricUnprocessableChangedFields = promauto.NewCounter(prometheus.CounterOpt
 type oplogEntry struct {
 	DocID      interface{}
 	Timestamp  bson.MongoTimestamp
+	WallTime   time.Time
 	Data       map[string]interface{}
 	Operation  string
 	Namespace  string
 	Database   string
 	Collection string
+
+	// The document after this entry was applied, if the Tailer has
+	// FullDocument set (see addFullDocument), or the values it wrote, if the
+	// Tailer has ChangedValues set (see changedValues). It's sent as the
+	// message's document.
+	FullDocument map[string]interface{}
+
+	// The document version to include in the message, if the Tailer has
+	// DocumentVersion set (see documentVersion)
+	Version string
+
+	// Whether to include the namespace in the message, if the Tailer has
+	// IncludeNamespace set
+	IncludeNamespace bool
+
+	// Whether to include the timestamp and the wall-clock time in the
+	// message, if the Tailer has IncludeTimestamp or IncludeWallTime set
+	IncludeTimestamp bool
+	IncludeWallTime  bool
+
+	// How to report the message's changed fields, from the Tailer's
+	// FieldPaths (see normalizeFieldPaths)
+	FieldPaths FieldPaths
+
+	// The event names to publish the message with, from the Tailer's
+	// EventNames (see eventNameForOperation)
+	EventNames EventNames
+
+	// The version of the message format, from the Tailer's ProtocolVersion
+	// (see messageProtocolVersion)
+	ProtocolVersion int
+
+	// The version of oplogtoredis to include in the message ("otrv"), from
+	// the Tailer's PublisherVersion
+	PublisherVersion string
+
+	// The format of the message, and the name of the cluster and shard it's
+	// from (for CloudEvents' and Debezium events' source), from the Tailer's
+	// PayloadFormat, ClusterName, and Shard (see wrapCloudEvent and
+	// debeziumMessage)
+	PayloadFormat PayloadFormat
+	Cluster       string
+	Shard         string
+
+	// The largest message to publish, and what to do with larger ones, from
+	// the Tailer's MaxMessageSize and OversizeAction (see shrinkMessage)
+	MaxMessageSize int
+	OversizeAction OversizeAction
+
+	// The values of the routing fields for the document, keyed by field, if
+	// the Tailer has RoutingFields set (see addRoutes). The message is also
+	// published to a channel for each of them.
+	Routes map[string]interface{}
+
+	// Fields (which may be dotted paths) to leave out of the message's field
+	// list, if the Tailer has RedactFields set (see redact)
+	RedactedFields []string
 }
 
 // Returns whether this oplogEntry is for an insert
@@ -45,22 +108,48 @@ func (op *oplogEntry) IsRemove() bool {
 	return op.Operation == operationRemove
 }
 
-// If this oplogEntry is for an insert, returns whether that insert is a
-// replacement (rather than a modification)
+// If this oplogEntry is for an update, returns whether that update is a
+// replacement (rather than a modification). Documents can't have top-level
+// fields starting with "$", so any such key (other than $v, the version of
+// the update language) is an update operator.
 func (op *oplogEntry) UpdateIsReplace() bool {
-	if _, ok := op.Data["$set"]; ok {
-		return false
-	} else if _, ok := op.Data["$unset"]; ok {
+	if op.updateIsDelta() {
 		return false
-	} else {
-		return true
 	}
+
+	for key := range op.Data {
+		if key != "$v" && strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns whether this oplogEntry is an update in the delta format that
+// MongoDB 5.0+ writes: {$v: 2, diff: {...}} rather than $set and $unset.
+// Documents can't have top-level fields starting with "$", so a replacement
+// never looks like this.
+func (op *oplogEntry) updateIsDelta() bool {
+	_, hasVersion := op.Data["$v"]
+	_, hasDiff := op.Data["diff"]
+	return hasVersion && hasDiff
 }
 
 // Given an operation, returned the fields affected by that operation
 func (op *oplogEntry) ChangedFields() []string {
 	if op.IsInsert() || (op.IsUpdate() && op.UpdateIsReplace()) {
 		return mapKeys(op.Data)
+	} else if op.IsUpdate() && op.updateIsDelta() {
+		diff, ok := asMap(op.Data["diff"])
+		if !ok {
+			metricUnprocessableChangedFields.Inc()
+			log.Log.Errorw("Oplog data for delta update contained a non-map diff",
+				"op", op)
+			return []string{}
+		}
+
+		return op.diffFields(diff, "")
 	} else if op.IsUpdate() {
 		fields := []string{}
 		for operationKey, operation := range op.Data {
@@ -71,7 +160,7 @@ func (op *oplogEntry) ChangedFields() []string {
 				continue
 			}
 
-			operationMap, operationMapOK := operation.(map[string]interface{})
+			operationMap, operationMapOK := asMap(operation)
 			if !operationMapOK {
 				metricUnprocessableChangedFields.Inc()
 				log.Log.Errorw("Oplog data for non-replacement update contained a key with a non-map value",
@@ -79,7 +168,7 @@ func (op *oplogEntry) ChangedFields() []string {
 				continue
 			}
 
-			fields = append(fields, mapKeys(operationMap)...)
+			fields = append(fields, operatorFields(operationKey, operationMap)...)
 		}
 
 		return fields
@@ -88,6 +177,112 @@ func (op *oplogEntry) ChangedFields() []string {
 	return []string{}
 }
 
+// Returns the fields changed by an update operator, given its operand. Mongo
+// records updates in the oplog with $set and $unset, but entries from other
+// sources (like applyOps commands, which apply their operations as given)
+// may use any operator. Every operator's operand is keyed by the fields it
+// changes ($inc, $push, $pull, $mul, $currentDate, ...), except that $rename
+// also changes the fields named by its values. Paths with positional
+// operators ("a.$.b", "a.$[].b", or "a.$[elem].b" with arrayFilters) are
+// reported up to the array, since we don't know which elements changed.
+func operatorFields(operator string, operand map[string]interface{}) []string {
+	fields := make([]string, 0, len(operand))
+	for field, value := range operand {
+		fields = append(fields, trimPositional(field))
+
+		if operator == "$rename" {
+			if to, ok := value.(string); ok {
+				fields = append(fields, trimPositional(to))
+			}
+		}
+	}
+
+	return fields
+}
+
+// Returns the part of a dotted field path before its first positional
+// operator ($, $[], or $[<identifier>]), or the whole path if it has none
+func trimPositional(field string) string {
+	parts := strings.Split(field, ".")
+	for i, part := range parts {
+		if i > 0 && strings.HasPrefix(part, "$") {
+			return strings.Join(parts[:i], ".")
+		}
+	}
+
+	return field
+}
+
+// Returns whether a dotted field path has a positional operator
+func hasPositional(field string) bool {
+	return trimPositional(field) != field
+}
+
+// Returns the fields changed by a delta update's diff, as dotted paths below
+// prefix. A diff has sections of fields that were updated ("u"), inserted
+// ("i"), and deleted ("d"), and a subdiff ("s<field>") for each embedded
+// document or array that was changed in place. An array's subdiff ("a":
+// true) has its updated elements ("u<index>"), the subdiffs of elements
+// changed in place ("s<index>"), and its new length ("l") if it was
+// truncated, which changes the array itself.
+func (op *oplogEntry) diffFields(diff map[string]interface{}, prefix string) []string {
+	fields := []string{}
+	_, isArray := diff["a"]
+
+	for key, value := range diff {
+		switch {
+		case isArray && key == "a":
+			continue
+		case isArray && key == "l":
+			fields = append(fields, strings.TrimSuffix(prefix, "."))
+		case isArray && strings.HasPrefix(key, "u"):
+			fields = append(fields, prefix+key[1:])
+		case !isArray && (key == "u" || key == "i" || key == "d"):
+			section, ok := asMap(value)
+			if !ok {
+				op.logUnprocessableDiff(prefix + key)
+				continue
+			}
+
+			for _, field := range mapKeys(section) {
+				fields = append(fields, prefix+field)
+			}
+		case strings.HasPrefix(key, "s"):
+			subdiff, ok := asMap(value)
+			if !ok {
+				op.logUnprocessableDiff(prefix + key)
+				continue
+			}
+
+			fields = append(fields, op.diffFields(subdiff, prefix+key[1:]+".")...)
+		default:
+			op.logUnprocessableDiff(prefix + key)
+		}
+	}
+
+	return fields
+}
+
+// Logs a part of a delta update's diff that we don't understand
+func (op *oplogEntry) logUnprocessableDiff(key string) {
+	metricUnprocessableChangedFields.Inc()
+	log.Log.Errorw("Oplog data for delta update contained an unexpected diff key",
+		"key", key,
+		"op", op)
+}
+
+// Returns v as a map, if it's a document
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case bson.M:
+		return m, true
+	default:
+		return nil, false
+	}
+}
+
 // Given a map, returns the keys of that map
 func mapKeys(m map[string]interface{}) []string {
 	fields := make([]string, len(m))
@@ -100,3 +295,22 @@ func mapKeys(m map[string]interface{}) []string {
 
 	return fields
 }
+
+// Returns when the entry was written: its wall-clock time, which Mongo 3.6+
+// records, or else the time from its timestamp, which only has a resolution
+// of one second
+func (op *oplogEntry) wallTime() time.Time {
+	if !op.WallTime.IsZero() {
+		return op.WallTime
+	}
+
+	return time.Unix(int64(op.Timestamp)>>32, 0)
+}
+
+// Returns the document version for an entry with the given timestamp. Oplog
+// timestamps are too big to be represented exactly as JavaScript numbers, so
+// the version is the timestamp as a zero-padded decimal string; versions
+// compare (as strings) in the same order as the timestamps.
+func documentVersion(ts bson.MongoTimestamp) string {
+	return fmt.Sprintf("%019d", uint64(ts))
+}
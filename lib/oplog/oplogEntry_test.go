@@ -4,6 +4,8 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+
+	"github.com/globalsign/mgo/bson"
 )
 
 func TestCategorization(t *testing.T) {
@@ -86,6 +88,13 @@ func TestUpdateIsReplace(t *testing.T) {
 			},
 			expectedResult: false,
 		},
+		"other operators": {
+			in: map[string]interface{}{
+				"$inc":  map[string]interface{}{"count": 1},
+				"$push": map[string]interface{}{"tags": "x"},
+			},
+			expectedResult: false,
+		},
 		"replacement": {
 			in: map[string]interface{}{
 				"$v":  map[string]interface{}{"foo": "bar"},
@@ -93,6 +102,13 @@ func TestUpdateIsReplace(t *testing.T) {
 			},
 			expectedResult: true,
 		},
+		"delta": {
+			in: map[string]interface{}{
+				"$v":   2,
+				"diff": map[string]interface{}{"u": map[string]interface{}{"foo": "bar"}},
+			},
+			expectedResult: false,
+		},
 	}
 
 	for testName, test := range tests {
@@ -174,6 +190,127 @@ func TestChangedFields(t *testing.T) {
 			want: []string{},
 		},
 
+		"Update, every operator": {
+			input: &oplogEntry{
+				Operation: "u",
+				Data: map[string]interface{}{
+					"$inc":         map[string]interface{}{"count": 1},
+					"$mul":         map[string]interface{}{"price": 1.5},
+					"$min":         map[string]interface{}{"low": 0},
+					"$max":         map[string]interface{}{"high": 10},
+					"$currentDate": map[string]interface{}{"updatedAt": true},
+					"$setOnInsert": map[string]interface{}{"createdAt": 1},
+					"$push":        map[string]interface{}{"tags": map[string]interface{}{"$each": []interface{}{"a"}}},
+					"$addToSet":    map[string]interface{}{"labels": "b"},
+					"$pull":        map[string]interface{}{"items": map[string]interface{}{"done": true}},
+					"$pullAll":     map[string]interface{}{"scores": []interface{}{1, 2}},
+					"$pop":         map[string]interface{}{"queue": -1},
+					"$bit":         map[string]interface{}{"flags": map[string]interface{}{"and": 1}},
+				},
+			},
+			want: []string{"count", "price", "low", "high", "updatedAt", "createdAt", "tags", "labels", "items", "scores", "queue", "flags"},
+		},
+
+		"Update, rename": {
+			input: &oplogEntry{
+				Operation: "u",
+				Data: map[string]interface{}{
+					"$rename": map[string]interface{}{"name": "fullName", "a.b": "c.d"},
+				},
+			},
+			want: []string{"name", "fullName", "a.b", "c.d"},
+		},
+
+		"Update, positional operators": {
+			input: &oplogEntry{
+				Operation: "u",
+				Data: map[string]interface{}{
+					"$set": map[string]interface{}{
+						"items.$.count":      1,
+						"grades.$[].score":   100,
+						"items.2.name":       "x",
+						"lists.$[elem].done": true,
+					},
+					"$inc": map[string]interface{}{
+						"profile.stats.$[s].views": 1,
+					},
+				},
+			},
+			want: []string{"items", "grades", "items.2.name", "lists", "profile.stats"},
+		},
+
+		"Update, bson.M operand": {
+			input: &oplogEntry{
+				Operation: "u",
+				Data: map[string]interface{}{
+					"$inc": bson.M{"count": 1},
+				},
+			},
+			want: []string{"count"},
+		},
+
+		"Delta update": {
+			input: &oplogEntry{
+				Operation: "u",
+				Data: map[string]interface{}{
+					"$v": 2,
+					"diff": map[string]interface{}{
+						"u": map[string]interface{}{"foo": "a"},
+						"i": map[string]interface{}{"bar": 10},
+						"d": map[string]interface{}{"baz": false},
+						"sprofile": map[string]interface{}{
+							"u": map[string]interface{}{"name": "x"},
+							"saddress": map[string]interface{}{
+								"d": map[string]interface{}{"zip": false},
+							},
+						},
+					},
+				},
+			},
+			want: []string{"foo", "bar", "baz", "profile.name", "profile.address.zip"},
+		},
+
+		"Delta update, arrays": {
+			input: &oplogEntry{
+				Operation: "u",
+				Data: map[string]interface{}{
+					"$v": 2,
+					"diff": map[string]interface{}{
+						"stags": map[string]interface{}{
+							"a":  true,
+							"u3": "d",
+						},
+						"sitems": map[string]interface{}{
+							"a": true,
+							"s1": map[string]interface{}{
+								"u": map[string]interface{}{"count": 3},
+							},
+						},
+						"slist": map[string]interface{}{
+							"a": true,
+							"l": 2,
+						},
+					},
+				},
+			},
+			want: []string{"tags.3", "items.1.count", "list"},
+		},
+
+		"Delta update, unexpected diff": {
+			input: &oplogEntry{
+				Operation: "u",
+				Data: map[string]interface{}{
+					"$v": 2,
+					"diff": map[string]interface{}{
+						"x": "weird",
+						"u": "weird",
+						"i": map[string]interface{}{"foo": "a"},
+					},
+				},
+			},
+			want: []string{"foo"},
+		},
+
 		"Update, unexpected operation value type": {
 			input: &oplogEntry{
 				Operation: "u",
@@ -239,3 +376,23 @@ func TestMapKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestDocumentVersion(t *testing.T) {
+	tests := map[bson.MongoTimestamp]string{
+		0:                                        "0000000000000000000",
+		1234:                                     "0000000000000001234",
+		bson.MongoTimestamp(1 << 32):             "0000000004294967296",
+		bson.MongoTimestamp(1500000000<<32 | 17): "6442450944000000017",
+	}
+
+	for ts, want := range tests {
+		if got := documentVersion(ts); got != want {
+			t.Errorf("documentVersion(%d) = %q, want %q", ts, got, want)
+		}
+	}
+
+	// Later timestamps have greater versions, even across digit boundaries
+	if !(documentVersion(999) < documentVersion(1000)) {
+		t.Errorf("Versions don't compare in timestamp order")
+	}
+}
@@ -0,0 +1,528 @@
+package oplog
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/chaos"
+	"github.com/tulip/oplogtoredis/lib/tracing"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Option configures a Tailer created with NewTailer.
+type Option func(*Tailer) error
+
+// NamespaceFilter decides whether entries for a collection should be
+// processed. Entries it returns false for are counted with the status
+// "filtered", and are not published.
+type NamespaceFilter func(database string, collection string) bool
+
+// MetricsHook is called once for each oplog entry received, with its
+// database, status (processed, ignored, filtered, skipped, or error), and
+// size in bytes. It's called in addition to updating oplogtoredis's own
+// Prometheus metrics, so callers can export their own.
+type MetricsHook func(database string, status string, size int)
+
+// The defaults for NewTailer. These match the defaults of the corresponding
+// environment variables in the config package.
+const (
+	defaultRedisPrefix = "oplogtoredis::"
+	defaultMaxCatchUp  = 60 * time.Second
+)
+
+// NewTailer creates a Tailer. It needs somewhere to read the oplog from
+// (WithMongoClient or WithSource) and somewhere to look up the
+// last-processed timestamp (WithRedisClient or WithSink); everything else
+// has a default.
+func NewTailer(opts ...Option) (*Tailer, error) {
+	tailer := &Tailer{
+		RedisPrefix: defaultRedisPrefix,
+		MaxCatchUp:  defaultMaxCatchUp,
+	}
+
+	for _, opt := range opts {
+		err := opt(tailer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tailer.MongoClient == nil && tailer.Source == nil {
+		return nil, errors.New("An oplog source is required; use WithMongoClient or WithSource")
+	}
+
+	if tailer.RedisClient == nil && tailer.Sink == nil {
+		return nil, errors.New("A last-processed timestamp sink is required; use WithRedisClient or WithSink")
+	}
+
+	if tailer.FullDocument && tailer.MongoClient == nil {
+		return nil, errors.New("WithFullDocument needs a Mongo client to fetch updated documents; use WithMongoClient")
+	}
+
+	if tailer.RoutingLookup && tailer.MongoClient == nil {
+		return nil, errors.New("WithRoutingLookup needs a Mongo client to fetch routing fields; use WithMongoClient")
+	}
+
+	return tailer, nil
+}
+
+// WithMongoClient sets the Mongo client to read the oplog from.
+func WithMongoClient(client *mongo.Client) Option {
+	return func(tailer *Tailer) error {
+		if client == nil {
+			return errors.New("Mongo client must not be nil")
+		}
+
+		tailer.MongoClient = client
+		return nil
+	}
+}
+
+// WithReadPreference sets which replica set members to read the oplog and
+// documents from. See Tailer.ReadPreference.
+func WithReadPreference(preference ReadPreference) Option {
+	return func(tailer *Tailer) error {
+		_, err := ParseReadPreference(string(preference))
+		if err != nil {
+			return err
+		}
+
+		tailer.ReadPreference = preference
+		return nil
+	}
+}
+
+// WithRedisClient sets the Redis client used to look up the last-processed
+// timestamp.
+func WithRedisClient(client redis.UniversalClient) Option {
+	return func(tailer *Tailer) error {
+		if client == nil {
+			return errors.New("Redis client must not be nil")
+		}
+
+		tailer.RedisClient = client
+		return nil
+	}
+}
+
+// WithSource sets where to read the oplog from, instead of a Mongo client.
+func WithSource(source OplogSource) Option {
+	return func(tailer *Tailer) error {
+		if source == nil {
+			return errors.New("Source must not be nil")
+		}
+
+		tailer.Source = source
+		return nil
+	}
+}
+
+// WithSink sets where to look up the last-processed timestamp, instead of a
+// Redis client.
+func WithSink(sink Sink) Option {
+	return func(tailer *Tailer) error {
+		if sink == nil {
+			return errors.New("Sink must not be nil")
+		}
+
+		tailer.Sink = sink
+		return nil
+	}
+}
+
+// WithRedisPrefix sets the metadata prefix used to look up the
+// last-processed timestamp. Defaults to "oplogtoredis::".
+func WithRedisPrefix(prefix string) Option {
+	return func(tailer *Tailer) error {
+		if prefix == "" {
+			return errors.New("Redis prefix must not be empty")
+		}
+
+		tailer.RedisPrefix = prefix
+		return nil
+	}
+}
+
+// WithMaxCatchUp sets how far in the past the last-processed timestamp may
+// be for us to resume from it. Defaults to 60s.
+func WithMaxCatchUp(maxCatchUp time.Duration) Option {
+	return func(tailer *Tailer) error {
+		if maxCatchUp < 0 {
+			return errors.New("MaxCatchUp must not be negative")
+		}
+
+		tailer.MaxCatchUp = maxCatchUp
+		return nil
+	}
+}
+
+// WithResumeFrom makes the first tail resume from the given timestamp,
+// rather than from the last-processed timestamp. See Tailer.ResumeFrom.
+func WithResumeFrom(ts bson.MongoTimestamp) Option {
+	return func(tailer *Tailer) error {
+		tailer.ResumeFrom = ts
+		return nil
+	}
+}
+
+// WithStartFrom sets where the first tail starts from, if there's no
+// ResumeFrom timestamp. See StartPosition.
+func WithStartFrom(position StartPosition) Option {
+	return func(tailer *Tailer) error {
+		_, err := ParseStartPosition(string(position))
+		if err != nil {
+			return err
+		}
+
+		tailer.StartFrom = position
+		return nil
+	}
+}
+
+// WithStopAt makes Tail return once it reaches an entry after the given
+// timestamp. See Tailer.StopAt.
+func WithStopAt(ts bson.MongoTimestamp) Option {
+	return func(tailer *Tailer) error {
+		tailer.StopAt = ts
+		return nil
+	}
+}
+
+// WithFullDocument makes messages for inserts and updates include the whole
+// document. See Tailer.FullDocument.
+func WithFullDocument(fullDocument bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.FullDocument = fullDocument
+		return nil
+	}
+}
+
+// WithFullDocumentFilter makes FullDocument only apply to collections that
+// the filter returns true for. See Tailer.FullDocumentFilter.
+func WithFullDocumentFilter(filter NamespaceFilter) Option {
+	return func(tailer *Tailer) error {
+		tailer.FullDocumentFilter = filter
+		return nil
+	}
+}
+
+// WithFullDocumentProjections limits the fields included in full documents,
+// per collection. See Tailer.FullDocumentProjections.
+func WithFullDocumentProjections(projections map[string][]string) Option {
+	return func(tailer *Tailer) error {
+		tailer.FullDocumentProjections = projections
+		return nil
+	}
+}
+
+// WithChangedValues makes messages for inserts and updates include the
+// values they wrote. See Tailer.ChangedValues.
+func WithChangedValues(changedValues bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.ChangedValues = changedValues
+		return nil
+	}
+}
+
+// WithDocumentVersion makes every message include a document version. See
+// Tailer.DocumentVersion.
+func WithDocumentVersion(documentVersion bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.DocumentVersion = documentVersion
+		return nil
+	}
+}
+
+// WithRoutingFields makes messages also be published to channels for the
+// values of some fields of their documents. See Tailer.RoutingFields.
+func WithRoutingFields(fields map[string][]string) Option {
+	return func(tailer *Tailer) error {
+		tailer.RoutingFields = fields
+		return nil
+	}
+}
+
+// WithRoutingLookup fetches routing fields that updates don't set from Mongo.
+// See Tailer.RoutingLookup.
+func WithRoutingLookup(routingLookup bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.RoutingLookup = routingLookup
+		return nil
+	}
+}
+
+// WithRedactFields leaves fields out of messages, per collection. See
+// Tailer.RedactFields.
+func WithRedactFields(fields map[string][]string) Option {
+	return func(tailer *Tailer) error {
+		tailer.RedactFields = fields
+		return nil
+	}
+}
+
+// WithHashFields replaces the values of fields in messages with their hash,
+// per collection. See Tailer.HashFields.
+func WithHashFields(fields map[string][]string) Option {
+	return func(tailer *Tailer) error {
+		tailer.HashFields = fields
+		return nil
+	}
+}
+
+// WithIncludeTimestamp makes every message include the timestamp of its
+// oplog entry. See Tailer.IncludeTimestamp.
+func WithIncludeTimestamp(includeTimestamp bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.IncludeTimestamp = includeTimestamp
+		return nil
+	}
+}
+
+// WithIncludeWallTime makes every message include the wall-clock time at
+// which its oplog entry was written. See Tailer.IncludeWallTime.
+func WithIncludeWallTime(includeWallTime bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.IncludeWallTime = includeWallTime
+		return nil
+	}
+}
+
+// WithPublisherVersion makes every message include the version of
+// oplogtoredis that published it, unless version is empty. See
+// Tailer.PublisherVersion.
+func WithPublisherVersion(version string) Option {
+	return func(tailer *Tailer) error {
+		tailer.PublisherVersion = version
+		return nil
+	}
+}
+
+// WithFieldPaths sets how the changed fields in messages are reported. See
+// Tailer.FieldPaths.
+func WithFieldPaths(fieldPaths FieldPaths) Option {
+	return func(tailer *Tailer) error {
+		_, err := ParseFieldPaths(string(fieldPaths))
+		if err != nil {
+			return err
+		}
+
+		tailer.FieldPaths = fieldPaths
+		return nil
+	}
+}
+
+// WithProtocolVersion sets the version of the message format to publish.
+// See Tailer.ProtocolVersion.
+func WithProtocolVersion(version int) Option {
+	return func(tailer *Tailer) error {
+		if err := validateProtocolVersion(version); err != nil {
+			return err
+		}
+
+		tailer.ProtocolVersion = version
+		return nil
+	}
+}
+
+// WithPayloadFormat sets the format of the messages we publish. See
+// Tailer.PayloadFormat.
+func WithPayloadFormat(payloadFormat PayloadFormat) Option {
+	return func(tailer *Tailer) error {
+		_, err := ParsePayloadFormat(string(payloadFormat))
+		if err != nil {
+			return err
+		}
+
+		tailer.PayloadFormat = payloadFormat
+		return nil
+	}
+}
+
+// WithClusterName sets the name of the Mongo cluster we tail. See
+// Tailer.ClusterName.
+func WithClusterName(name string) Option {
+	return func(tailer *Tailer) error {
+		tailer.ClusterName = name
+		return nil
+	}
+}
+
+// WithMaxMessageSize sets the largest message to publish, in bytes (0 for no
+// limit), and what to do with larger messages. See Tailer.MaxMessageSize.
+func WithMaxMessageSize(size int, action OversizeAction) Option {
+	return func(tailer *Tailer) error {
+		if size < 0 {
+			return fmt.Errorf("Invalid maximum message size %d: must be at least 0", size)
+		}
+
+		_, err := ParseOversizeAction(string(action))
+		if err != nil {
+			return err
+		}
+
+		tailer.MaxMessageSize = size
+		tailer.OversizeAction = action
+		return nil
+	}
+}
+
+// WithEventNames sets the event names messages are published with. See
+// Tailer.EventNames.
+func WithEventNames(eventNames EventNames) Option {
+	return func(tailer *Tailer) error {
+		_, err := ParseEventNames(string(eventNames))
+		if err != nil {
+			return err
+		}
+
+		tailer.EventNames = eventNames
+		return nil
+	}
+}
+
+// WithPublishMigrations publishes the entries a sharded cluster writes when
+// it moves a chunk between shards, instead of skipping them. See
+// Tailer.PublishMigrations.
+func WithPublishMigrations(publishMigrations bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.PublishMigrations = publishMigrations
+		return nil
+	}
+}
+
+// WithIncludeNamespace makes every message include the namespace of its
+// document. See Tailer.IncludeNamespace.
+func WithIncludeNamespace(includeNamespace bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.IncludeNamespace = includeNamespace
+		return nil
+	}
+}
+
+// WithDeadLetterChannel publishes entries that can't be processed to channel.
+// See Tailer.DeadLetterChannel.
+func WithDeadLetterChannel(channel string) Option {
+	return func(tailer *Tailer) error {
+		tailer.DeadLetterChannel = channel
+		return nil
+	}
+}
+
+// WithCollectionEvents publishes collection drops, renames, and database
+// drops as events. See Tailer.CollectionEvents.
+func WithCollectionEvents(collectionEvents bool) Option {
+	return func(tailer *Tailer) error {
+		tailer.CollectionEvents = collectionEvents
+		return nil
+	}
+}
+
+// WithRenameRemoves publishes removes for the documents of renamed
+// collections with at most limit documents. See Tailer.RenameRemoves.
+func WithRenameRemoves(limit int) Option {
+	return func(tailer *Tailer) error {
+		if limit < 0 {
+			return fmt.Errorf("Invalid rename removes limit %d: must not be negative", limit)
+		}
+
+		tailer.RenameRemoves = limit
+		return nil
+	}
+}
+
+// WithDataGapChannel publishes an event to channel when the entries after
+// the position we resume from have already rolled off the oplog. See
+// Tailer.DataGapChannel.
+func WithDataGapChannel(channel string) Option {
+	return func(tailer *Tailer) error {
+		tailer.DataGapChannel = channel
+		return nil
+	}
+}
+
+// WithDataGapRestart sets where to restart after a data gap:
+// StartFromOldest (the default) or StartFromNewest.
+func WithDataGapRestart(position StartPosition) Option {
+	return func(tailer *Tailer) error {
+		if position != StartFromOldest && position != StartFromNewest {
+			return fmt.Errorf("Invalid data gap restart position %q: must be oldest or newest", position)
+		}
+
+		tailer.DataGapRestart = position
+		return nil
+	}
+}
+
+// WithShard makes the Tailer tail the oplog of one shard of a sharded
+// cluster, with its own last-processed timestamp. The source (WithSource)
+// should read that shard's oplog; the Mongo client can be connected to mongos.
+func WithShard(name string) Option {
+	return func(tailer *Tailer) error {
+		tailer.Shard = name
+		return nil
+	}
+}
+
+// WithRestartBackoff sets how long to wait before restarting tailing after
+// it fails. See Tailer.RestartBackoff.
+func WithRestartBackoff(restartBackoff backoff.Backoff) Option {
+	return func(tailer *Tailer) error {
+		if restartBackoff.Jitter < 0 || restartBackoff.Jitter > 1 {
+			return fmt.Errorf("Invalid restart backoff jitter %v: must be between 0 and 1", restartBackoff.Jitter)
+		}
+
+		tailer.RestartBackoff = restartBackoff
+		return nil
+	}
+}
+
+// WithMaxRestarts makes Tail give up after tailing fails more than
+// maxRestarts times in a row. See Tailer.MaxRestarts.
+func WithMaxRestarts(maxRestarts int) Option {
+	return func(tailer *Tailer) error {
+		if maxRestarts < 0 {
+			return fmt.Errorf("Invalid max restarts %d: must not be negative", maxRestarts)
+		}
+
+		tailer.MaxRestarts = maxRestarts
+		return nil
+	}
+}
+
+// WithChaos injects oplog cursor errors. See the chaos package.
+func WithChaos(injector *chaos.Injector) Option {
+	return func(tailer *Tailer) error {
+		tailer.Chaos = injector
+		return nil
+	}
+}
+
+// WithTracer traces entries through decoding, processing, and publishing.
+// See the tracing package.
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(tailer *Tailer) error {
+		tailer.Tracer = tracer
+		return nil
+	}
+}
+
+// WithNamespaceFilter only processes entries for collections that the filter
+// returns true for.
+func WithNamespaceFilter(filter NamespaceFilter) Option {
+	return func(tailer *Tailer) error {
+		tailer.namespaceFilter = filter
+		return nil
+	}
+}
+
+// WithMetricsHook calls hook for each oplog entry received.
+func WithMetricsHook(hook MetricsHook) Option {
+	return func(tailer *Tailer) error {
+		tailer.metricsHook = hook
+		return nil
+	}
+}
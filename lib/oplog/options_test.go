@@ -0,0 +1,152 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestNewTailer(t *testing.T) {
+	mongoClient := &mongo.Client{}
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{"localhost:6379"}})
+	defer client.Close()
+
+	tests := map[string]struct {
+		opts           []Option
+		wantPrefix     string
+		wantMaxCatchUp time.Duration
+		expectError    bool
+	}{
+		"Defaults": {
+			opts:           []Option{WithMongoClient(mongoClient), WithRedisClient(client)},
+			wantPrefix:     "oplogtoredis::",
+			wantMaxCatchUp: 60 * time.Second,
+		},
+		"Overrides": {
+			opts: []Option{
+				WithMongoClient(mongoClient),
+				WithRedisClient(client),
+				WithRedisPrefix("someprefix."),
+				WithMaxCatchUp(0),
+			},
+			wantPrefix:     "someprefix.",
+			wantMaxCatchUp: 0,
+		},
+		"Missing Mongo client": {
+			opts:        []Option{WithRedisClient(client)},
+			expectError: true,
+		},
+		"Missing Redis client": {
+			opts:        []Option{WithMongoClient(mongoClient)},
+			expectError: true,
+		},
+		"Nil Mongo client": {
+			opts:        []Option{WithMongoClient(nil), WithRedisClient(client)},
+			expectError: true,
+		},
+		"Empty prefix": {
+			opts:        []Option{WithMongoClient(mongoClient), WithRedisClient(client), WithRedisPrefix("")},
+			expectError: true,
+		},
+		"Negative MaxCatchUp": {
+			opts:        []Option{WithMongoClient(mongoClient), WithRedisClient(client), WithMaxCatchUp(-time.Second)},
+			expectError: true,
+		},
+		"Full document": {
+			opts:           []Option{WithMongoClient(mongoClient), WithRedisClient(client), WithFullDocument(true)},
+			wantPrefix:     "oplogtoredis::",
+			wantMaxCatchUp: 60 * time.Second,
+		},
+		"Negative max restarts": {
+			opts:        []Option{WithMongoClient(mongoClient), WithRedisClient(client), WithMaxRestarts(-1)},
+			expectError: true,
+		},
+		"Negative rename removes": {
+			opts:        []Option{WithMongoClient(mongoClient), WithRedisClient(client), WithRenameRemoves(-1)},
+			expectError: true,
+		},
+		"Restart backoff jitter above 1": {
+			opts:        []Option{WithMongoClient(mongoClient), WithRedisClient(client), WithRestartBackoff(backoff.Backoff{Jitter: 2})},
+			expectError: true,
+		},
+		"Full document without Mongo client": {
+			opts:        []Option{WithSource(&fakeSource{}), WithRedisClient(client), WithFullDocument(true)},
+			expectError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			tailer, err := NewTailer(test.opts...)
+
+			if test.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, but didn't get one")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if tailer.MongoClient != mongoClient || tailer.RedisClient != client {
+				t.Errorf("Clients were not set")
+			}
+			if tailer.RedisPrefix != test.wantPrefix {
+				t.Errorf("Got RedisPrefix %q, expected %q", tailer.RedisPrefix, test.wantPrefix)
+			}
+			if tailer.MaxCatchUp != test.wantMaxCatchUp {
+				t.Errorf("Got MaxCatchUp %s, expected %s", tailer.MaxCatchUp, test.wantMaxCatchUp)
+			}
+		})
+	}
+}
+
+func TestNamespaceFilterAndMetricsHook(t *testing.T) {
+	type hookCall struct {
+		database string
+		status   string
+	}
+	var calls []hookCall
+
+	tailer := &Tailer{}
+	for _, opt := range []Option{
+		WithNamespaceFilter(func(database string, collection string) bool {
+			return collection == "included"
+		}),
+		WithMetricsHook(func(database string, status string, size int) {
+			if size <= 0 {
+				t.Errorf("Metrics hook called with size %d", size)
+			}
+			calls = append(calls, hookCall{database, status})
+		}),
+	} {
+		_ = opt(tailer)
+	}
+
+	for _, ns := range []string{"foo.included", "foo.excluded"} {
+		data, err := bson.Marshal(bson.M{
+			"ts": bson.MongoTimestamp(1234),
+			"op": "i",
+			"ns": ns,
+			"o":  bson.M{"_id": "someid"},
+		})
+		if err != nil {
+			t.Fatalf("Could not marshal test entry: %s", err)
+		}
+
+		pub := tailer.Process(bson.Raw{Kind: 3, Data: data})
+		if (pub != nil) != (ns == "foo.included") {
+			t.Errorf("Got publication %#v for %s", pub, ns)
+		}
+	}
+
+	if len(calls) != 2 || calls[0] != (hookCall{"foo", "processed"}) || calls[1] != (hookCall{"foo", "filtered"}) {
+		t.Errorf("Got metrics hook calls %v, expected [{foo processed} {foo filtered}]", calls)
+	}
+}
@@ -0,0 +1,110 @@
+package oplog
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/encoding"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// OversizeAction is what to do with a message that's larger than the
+// Tailer's MaxMessageSize
+type OversizeAction string
+
+const (
+	// OversizeTruncate leaves the document's values out of the message (so
+	// it has just the _id, as without full-document mode), and, if that
+	// isn't enough, reports only its top-level changed fields. This is the
+	// default.
+	OversizeTruncate OversizeAction = "truncate"
+
+	// OversizeRefetch publishes a marker message instead, with the event
+	// RefetchEvent, so consumers know to fetch the document themselves
+	OversizeRefetch OversizeAction = "refetch"
+
+	// OversizeDrop drops the message
+	OversizeDrop OversizeAction = "drop"
+)
+
+// RefetchEvent is the event ("e") of the marker message that's published in
+// place of a message that's too large, with OversizeRefetch. Its document
+// has just the _id, and its field list is empty.
+const RefetchEvent = "refetch"
+
+// ParseOversizeAction parses "truncate", "refetch", or "drop"
+func ParseOversizeAction(value string) (OversizeAction, error) {
+	switch action := OversizeAction(value); action {
+	case OversizeTruncate, OversizeRefetch, OversizeDrop:
+		return action, nil
+	default:
+		return "", fmt.Errorf("Invalid oversize action %q: must be truncate, refetch, or drop", value)
+	}
+}
+
+var metricOversizeMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "oversize_messages",
+	Help:      "Messages larger than the maximum message size, partitioned by what was done with them: truncated, refetch (replaced by a refetch marker), or dropped",
+}, []string{"action"})
+
+// Handles a message that's larger than op.MaxMessageSize, according to
+// op.OversizeAction. msg is modified to match. Returns the message to
+// publish in its place, or nil to drop it, which happens when even the
+// smaller message is too large (as Debezium messages always are, since
+// they aren't made from msg).
+func shrinkMessage(op *oplogEntry, msg *encoding.Message, idForMessage interface{}, idForChannel string, size int) ([]byte, error) {
+	log.Log.Warnw("Message is larger than the maximum message size",
+		"namespace", op.Namespace,
+		"id", idForChannel,
+		"size", size,
+		"maxSize", op.MaxMessageSize,
+		"action", op.OversizeAction)
+
+	fits := func() ([]byte, bool, error) {
+		data, err := marshalMessage(op, msg, idForChannel)
+		return data, err == nil && len(data) <= op.MaxMessageSize, err
+	}
+
+	switch op.OversizeAction {
+	case OversizeTruncate, "":
+		// Values are the bulk of most messages, and then nested field paths
+		msg.Doc = map[string]interface{}{"_id": idForMessage}
+		data, ok, err := fits()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			msg.Fields = normalizeFieldPaths(msg.Fields, FieldPathsTopLevel)
+			data, ok, err = fits()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if ok {
+			metricOversizeMessages.WithLabelValues("truncated").Inc()
+			return data, nil
+		}
+
+	case OversizeRefetch:
+		msg.Event = RefetchEvent
+		msg.Doc = map[string]interface{}{"_id": idForMessage}
+		msg.Fields = []string{}
+		data, ok, err := fits()
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			metricOversizeMessages.WithLabelValues("refetch").Inc()
+			return data, nil
+		}
+	}
+
+	metricOversizeMessages.WithLabelValues("dropped").Inc()
+	return nil, nil
+}
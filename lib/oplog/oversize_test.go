@@ -0,0 +1,145 @@
+package oplog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOversizeAction(t *testing.T) {
+	for _, value := range []string{"truncate", "refetch", "drop"} {
+		action, err := ParseOversizeAction(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(action) != value {
+			t.Errorf("Parsed %q as %q", value, action)
+		}
+	}
+
+	if _, err := ParseOversizeAction("split"); err == nil {
+		t.Error("Expected an error parsing an invalid oversize action")
+	}
+}
+
+func TestWithMaxMessageSize(t *testing.T) {
+	tailer, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithMaxMessageSize(1000, OversizeDrop))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if tailer.MaxMessageSize != 1000 || tailer.OversizeAction != OversizeDrop {
+		t.Errorf("Got maximum message size %d and action %q", tailer.MaxMessageSize, tailer.OversizeAction)
+	}
+
+	if _, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithMaxMessageSize(-1, OversizeDrop)); err == nil {
+		t.Error("Expected an error for a negative maximum message size")
+	}
+	if _, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithMaxMessageSize(1000, "split")); err == nil {
+		t.Error("Expected an error for an invalid oversize action")
+	}
+}
+
+func TestProcessOplogEntryOversize(t *testing.T) {
+	big := strings.Repeat("a", 200)
+
+	tests := map[string]struct {
+		data          map[string]interface{}
+		fullDocument  map[string]interface{}
+		maxSize       int
+		action        OversizeAction
+		payloadFormat PayloadFormat
+		want          string
+	}{
+		"Under the limit": {
+			data:         map[string]interface{}{"$set": map[string]interface{}{"a": big}},
+			fullDocument: map[string]interface{}{"_id": "someid", "a": "b"},
+			maxSize:      100,
+			action:       OversizeDrop,
+			want:         `{"e":"u","d":{"_id":"someid","a":"b"},"f":["a"]}`,
+		},
+		"No limit": {
+			data:         map[string]interface{}{"$set": map[string]interface{}{"a": big}},
+			fullDocument: map[string]interface{}{"_id": "someid", "a": big},
+			action:       OversizeDrop,
+			want:         `{"e":"u","d":{"_id":"someid","a":"` + big + `"},"f":["a"]}`,
+		},
+		"Truncate the document": {
+			data:         map[string]interface{}{"$set": map[string]interface{}{"a": big}},
+			fullDocument: map[string]interface{}{"_id": "someid", "a": big},
+			maxSize:      100,
+			action:       OversizeTruncate,
+			want:         `{"e":"u","d":{"_id":"someid"},"f":["a"]}`,
+		},
+		"Truncate by default": {
+			data:         map[string]interface{}{"$set": map[string]interface{}{"a": big}},
+			fullDocument: map[string]interface{}{"_id": "someid", "a": big},
+			maxSize:      100,
+			want:         `{"e":"u","d":{"_id":"someid"},"f":["a"]}`,
+		},
+		"Truncate the field paths": {
+			data: map[string]interface{}{"$set": map[string]interface{}{
+				"a.b" + big: 1,
+				"a.c" + big: 2,
+			}},
+			maxSize: 100,
+			action:  OversizeTruncate,
+			want:    `{"e":"u","d":{"_id":"someid"},"f":["a"]}`,
+		},
+		"Truncate, but still too large": {
+			data:    map[string]interface{}{"$set": map[string]interface{}{big: 1}},
+			maxSize: 100,
+			action:  OversizeTruncate,
+		},
+		"Refetch": {
+			data:         map[string]interface{}{"$set": map[string]interface{}{"a": big}},
+			fullDocument: map[string]interface{}{"_id": "someid", "a": big},
+			maxSize:      100,
+			action:       OversizeRefetch,
+			want:         `{"e":"refetch","d":{"_id":"someid"},"f":[]}`,
+		},
+		"Drop": {
+			data:         map[string]interface{}{"$set": map[string]interface{}{"a": big}},
+			fullDocument: map[string]interface{}{"_id": "someid", "a": big},
+			maxSize:      100,
+			action:       OversizeDrop,
+		},
+		"Debezium": {
+			data:          map[string]interface{}{"$set": map[string]interface{}{"a": big}},
+			maxSize:       100,
+			action:        OversizeTruncate,
+			payloadFormat: PayloadFormatDebezium,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pub, err := processOplogEntry(&oplogEntry{
+				DocID:          "someid",
+				Operation:      "u",
+				Namespace:      "foo.bar",
+				Database:       "foo",
+				Collection:     "bar",
+				Data:           test.data,
+				FullDocument:   test.fullDocument,
+				PayloadFormat:  test.payloadFormat,
+				MaxMessageSize: test.maxSize,
+				OversizeAction: test.action,
+			})
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if test.want == "" {
+				if pub != nil {
+					t.Errorf("Expected the message to be dropped, got %s", pub.Msg)
+				}
+				return
+			}
+
+			if pub == nil {
+				t.Fatal("Expected a publication, got nil")
+			}
+			if string(pub.Msg) != test.want {
+				t.Errorf("Got message %s, expected %s", pub.Msg, test.want)
+			}
+		})
+	}
+}
@@ -2,70 +2,116 @@ package oplog
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
-	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/encoding"
 	"github.com/tulip/oplogtoredis/lib/log"
 	"github.com/tulip/oplogtoredis/lib/redispub"
 )
 
 // Process a signal oplog entry. Returns the redispub.Publication that should
 // be published for this oplog entry, or nil if nothing should be published.
-//
-// TODO PERF: Add options for filtering to specific collections or
-// databases (https://github.com/tulip/oplogtoredis/issues/8)
 func processOplogEntry(op *oplogEntry) (*redispub.Publication, error) {
-	// Struct that matches the message format redis-oplog expects
-	type outgoingMessageDocument struct {
-		ID interface{} `json:"_id"`
-	}
-	type outgoingMessage struct {
-		Event  string                  `json:"e"`
-		Doc    outgoingMessageDocument `json:"d"`
-		Fields []string                `json:"f"`
-	}
-
 	if strings.HasPrefix(op.Collection, "system.") {
 		// We don't publish index creation events
 		return nil, nil
 	}
 
-	var idForChannel string
-	var idForMessage interface{}
-
-	if id, idOK := op.DocID.(string); idOK {
-		idForChannel = id
-		idForMessage = id
-	} else if id, idOK := op.DocID.(bson.ObjectId); idOK {
-		idHex := id.Hex()
-		idForChannel = idHex
-		idForMessage = map[string]string{
-			"$type":  "oid",
-			"$value": idHex,
-		}
-	} else {
-		// We don't know how to handle IDs that aren't strings or ObjectIDs,
-		// because we don't what what the specific channel (the channel for
-		// this specific document) should be.
-		return nil, errors.New("op.ID was not a string or ObjectID")
+	idForMessage, idForChannel, err := encodeDocumentID(op.DocID)
+	if err != nil {
+		// We don't know what the specific channel (the channel for this
+		// specific document) should be
+		return nil, err
 	}
 
 	// Construct the JSON we're going to send to Redis
 	//
 	// TODO PERF: consider a specialized JSON encoder
 	// https://github.com/tulip/oplogtoredis/issues/13
-	msg := outgoingMessage{
-		Event:  eventNameForOperation(op),
-		Doc:    outgoingMessageDocument{idForMessage},
-		Fields: op.ChangedFields(),
+	//
+	// The fields are sorted so that the message for a given oplog entry is
+	// always byte-for-byte the same.
+	fields := op.ChangedFields()
+	if len(op.RedactedFields) > 0 {
+		kept := fields[:0]
+		for _, field := range fields {
+			if !isRedactedField(field, op.RedactedFields) {
+				kept = append(kept, field)
+			}
+		}
+		fields = kept
 	}
-	log.Log.Debugw("Sending outgoing message", "message", msg)
-	msgJSON, err := json.Marshal(&msg)
+	fields = normalizeFieldPaths(fields, op.FieldPaths)
+	sort.Strings(fields)
+
+	msg := encoding.Message{
+		Event:   eventNameForOperation(op),
+		Doc:     map[string]interface{}{"_id": idForMessage},
+		Fields:  fields,
+		Version: op.Version,
 
+		ProtocolVersion:  messageProtocolVersion(op.ProtocolVersion),
+		PublisherVersion: op.PublisherVersion,
+	}
+
+	if op.IncludeNamespace {
+		msg.Namespace = op.Namespace
+	}
+	if op.IncludeTimestamp {
+		msg.Timestamp = FormatTimestamp(op.Timestamp)
+	}
+	if op.IncludeWallTime {
+		msg.WallTime = ejsonValue(op.wallTime())
+	}
+
+	if op.FullDocument != nil {
+		// Full-document mode: the whole document, with the _id in the same
+		// form as usual
+		doc := ejsonDocument(op.FullDocument)
+		doc["_id"] = idForMessage
+		msg.Doc = doc
+	}
+	log.Log.Debugw("Sending outgoing message", "message", msg)
+	msgJSON, err := marshalMessage(op, &msg, idForChannel)
 	if err != nil {
-		return nil, fmt.Errorf("Error marshalling outgoing message: %s", err)
+		return nil, err
+	}
+
+	if op.MaxMessageSize > 0 && len(msgJSON) > op.MaxMessageSize {
+		msgJSON, err = shrinkMessage(op, &msg, idForMessage, idForChannel, len(msgJSON))
+		if err != nil || msgJSON == nil {
+			return nil, err
+		}
+	}
+
+	// Routing fields get a channel for each of their values. Values are
+	// formatted like IDs are for specific channels; ones that can't be are
+	// skipped.
+	routingFields := mapKeys(op.Routes)
+	sort.Strings(routingFields)
+
+	var routingChannels []string
+	for _, field := range routingFields {
+		_, valueForChannel, err := encodeDocumentID(op.Routes[field])
+		if err != nil {
+			log.Log.Debugw("Skipping routing channel for unsupported value",
+				"namespace", op.Namespace,
+				"field", field,
+				"error", err)
+			continue
+		}
+
+		routingChannels = append(routingChannels, op.Namespace+"::"+field+"::"+valueForChannel)
+	}
+
+	// Sinks with other encodings encode redis-oplog messages from the
+	// message itself; messages in other payload formats are decoded from
+	// their JSON
+	var payload interface{}
+	if op.PayloadFormat == "" || op.PayloadFormat == PayloadFormatRedisOplog {
+		payload = &msg
 	}
 
 	// We need to publish on both the full-collection channel and the
@@ -79,14 +125,35 @@ func processOplogEntry(op *oplogEntry) (*redispub.Publication, error) {
 		// optimization for subscriptions that target a specific ID
 		SpecificChannel: op.Namespace + "::" + idForChannel,
 
+		// Channels for the document's routing field values, like
+		// "foo.bar::tenantId::acme"
+		ExtraChannels: routingChannels,
+
 		Msg:            msgJSON,
+		Payload:        payload,
 		OplogTimestamp: op.Timestamp,
 	}, nil
 }
 
-func eventNameForOperation(op *oplogEntry) string {
-	if op.Operation == "d" {
-		return "r"
+// Returns the message for an entry, in the entry's payload format
+func marshalMessage(op *oplogEntry, msg *encoding.Message, idForChannel string) ([]byte, error) {
+	msgJSON, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling outgoing message: %s", err)
 	}
-	return op.Operation
+
+	switch op.PayloadFormat {
+	case PayloadFormatCloudEvents:
+		msgJSON, err = wrapCloudEvent(op, idForChannel, msgJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Error marshalling CloudEvent: %s", err)
+		}
+	case PayloadFormatDebezium:
+		msgJSON, err = debeziumMessage(op)
+		if err != nil {
+			return nil, fmt.Errorf("Error marshalling Debezium event: %s", err)
+		}
+	}
+
+	return msgJSON, nil
 }
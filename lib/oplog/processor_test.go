@@ -7,8 +7,10 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/encoding"
 	"github.com/tulip/oplogtoredis/lib/redispub"
 )
 
@@ -18,9 +20,11 @@ func TestProcessOplogEntry(t *testing.T) {
 	// be ordered differently. We have this decodedPublication type that's
 	// the same as redispub.Publication but with the JSON decoded
 	type decodedPublicationMessage struct {
-		Event  string      `json:"e"`
-		Doc    interface{} `json:"d"`
-		Fields []string    `json:"f"`
+		Event     string      `json:"e"`
+		Doc       interface{} `json:"d"`
+		Fields    []string    `json:"f"`
+		Version   string      `json:"v"`
+		Namespace string      `json:"ns"`
 	}
 	type decodedPublication struct {
 		CollectionChannel string
@@ -122,6 +126,101 @@ func TestProcessOplogEntry(t *testing.T) {
 				OplogTimestamp: bson.MongoTimestamp(1234),
 			},
 		},
+		"Full-document update": {
+			in: &oplogEntry{
+				DocID:      bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3"),
+				Operation:  "u",
+				Namespace:  "foo.bar",
+				Database:   "foo",
+				Collection: "bar",
+				Data: bson.M{
+					"$set": map[string]interface{}{
+						"a": "new",
+					},
+				},
+				FullDocument: map[string]interface{}{
+					"_id": bson.ObjectIdHex("5c8a9d5b1e2a4f0001a1b2c3"),
+					"a":   "new",
+					"b":   bson.M{"c": 1},
+				},
+				Timestamp: bson.MongoTimestamp(1234),
+			},
+			want: &decodedPublication{
+				CollectionChannel: "foo.bar",
+				SpecificChannel:   "foo.bar::5c8a9d5b1e2a4f0001a1b2c3",
+				Msg: decodedPublicationMessage{
+					Event: "u",
+					Doc: map[string]interface{}{
+						"_id": map[string]interface{}{
+							"$type":  "oid",
+							"$value": "5c8a9d5b1e2a4f0001a1b2c3",
+						},
+						"a": "new",
+						"b": map[string]interface{}{"c": float64(1)},
+					},
+					Fields: []string{"a"},
+				},
+				OplogTimestamp: bson.MongoTimestamp(1234),
+			},
+		},
+		"Versioned update": {
+			in: &oplogEntry{
+				DocID:      "someid",
+				Operation:  "u",
+				Namespace:  "foo.bar",
+				Database:   "foo",
+				Collection: "bar",
+				Data: bson.M{
+					"$set": map[string]interface{}{
+						"a": "foo",
+					},
+				},
+				Version:   "0000000000000001234",
+				Timestamp: bson.MongoTimestamp(1234),
+			},
+			want: &decodedPublication{
+				CollectionChannel: "foo.bar",
+				SpecificChannel:   "foo.bar::someid",
+				Msg: decodedPublicationMessage{
+					Event: "u",
+					Doc: map[string]interface{}{
+						"_id": "someid",
+					},
+					Fields:  []string{"a"},
+					Version: "0000000000000001234",
+				},
+				OplogTimestamp: bson.MongoTimestamp(1234),
+			},
+		},
+		"Update with namespace": {
+			in: &oplogEntry{
+				DocID:      "someid",
+				Operation:  "u",
+				Namespace:  "foo.bar",
+				Database:   "foo",
+				Collection: "bar",
+				Data: bson.M{
+					"$set": map[string]interface{}{
+						"a": "foo",
+					},
+				},
+				IncludeNamespace: true,
+				Timestamp:        bson.MongoTimestamp(1234),
+			},
+			want: &decodedPublication{
+				CollectionChannel: "foo.bar",
+				SpecificChannel:   "foo.bar::someid",
+				Msg: decodedPublicationMessage{
+					Event: "u",
+					Doc: map[string]interface{}{
+						"_id": "someid",
+					},
+					Fields:    []string{"a"},
+					Namespace: "foo.bar",
+				},
+				OplogTimestamp: bson.MongoTimestamp(1234),
+			},
+		},
 		"Delete": {
 			in: &oplogEntry{
 				DocID:      "someid",
@@ -173,7 +272,7 @@ func TestProcessOplogEntry(t *testing.T) {
 				OplogTimestamp: bson.MongoTimestamp(1234),
 			},
 		},
-		"Unsupported id type": {
+		"Integer id": {
 			in: &oplogEntry{
 				DocID:      1234,
 				Operation:  "i",
@@ -185,7 +284,35 @@ func TestProcessOplogEntry(t *testing.T) {
 				},
 				Timestamp: bson.MongoTimestamp(1234),
 			},
-			wantError: errors.New("op.ID was not a string or ObjectID"),
+			want: &decodedPublication{
+				CollectionChannel: "foo.bar",
+				SpecificChannel:   "foo.bar::int:1234",
+				Msg: decodedPublicationMessage{
+					Event: "i",
+					Doc: map[string]interface{}{
+						"_id": map[string]interface{}{
+							"$type":  "int",
+							"$value": "1234",
+						},
+					},
+					Fields: []string{"some"},
+				},
+				OplogTimestamp: bson.MongoTimestamp(1234),
+			},
+		},
+		"Unsupported id type": {
+			in: &oplogEntry{
+				DocID:      true,
+				Operation:  "i",
+				Namespace:  "foo.bar",
+				Database:   "foo",
+				Collection: "bar",
+				Data: bson.M{
+					"some": "field",
+				},
+				Timestamp: bson.MongoTimestamp(1234),
+			},
+			wantError: errors.New("op.ID was of unsupported type bool"),
 			want:      nil,
 		},
 		"Index update": {
@@ -265,3 +392,87 @@ func TestProcessOplogEntry(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessOplogEntryMetadata(t *testing.T) {
+	entry := &oplogEntry{
+		DocID:      "someid",
+		Timestamp:  bson.MongoTimestamp(1500000000<<32 | 3),
+		Operation:  "i",
+		Namespace:  "foo.bar",
+		Database:   "foo",
+		Collection: "bar",
+		Data:       map[string]interface{}{"_id": "someid"},
+
+		IncludeNamespace: true,
+		IncludeTimestamp: true,
+		IncludeWallTime:  true,
+		PublisherVersion: "v2.1.0",
+	}
+
+	tests := map[string]struct {
+		wallTime time.Time
+		want     string
+	}{
+		"Wall time": {
+			wallTime: time.Unix(1500000000, 250000000),
+			want:     `{"e":"i","d":{"_id":"someid"},"f":["_id"],"ns":"foo.bar","ts":"1500000000:3","wall":{"$date":1500000000250},"otrv":"v2.1.0"}`,
+		},
+		"Wall time from the timestamp": {
+			want: `{"e":"i","d":{"_id":"someid"},"f":["_id"],"ns":"foo.bar","ts":"1500000000:3","wall":{"$date":1500000000000},"otrv":"v2.1.0"}`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			entry.WallTime = test.wallTime
+
+			pub, err := processOplogEntry(entry)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if string(pub.Msg) != test.want {
+				t.Errorf("Got message %s, expected %s", pub.Msg, test.want)
+			}
+		})
+	}
+}
+
+func TestProcessOplogEntryPayload(t *testing.T) {
+	for _, payloadFormat := range []PayloadFormat{"", PayloadFormatRedisOplog, PayloadFormatCloudEvents, PayloadFormatDebezium} {
+		pub, err := processOplogEntry(&oplogEntry{
+			DocID:         "someid",
+			Operation:     "i",
+			Namespace:     "foo.bar",
+			Database:      "foo",
+			Collection:    "bar",
+			Data:          map[string]interface{}{"_id": "someid"},
+			PayloadFormat: payloadFormat,
+		})
+		if err != nil {
+			t.Fatalf("Got unexpected error for %q: %s", payloadFormat, err)
+		}
+
+		// The payload is the message that's encoded as JSON, for redis-oplog
+		// messages only
+		msg, ok := pub.Payload.(*encoding.Message)
+		if payloadFormat == PayloadFormatCloudEvents || payloadFormat == PayloadFormatDebezium {
+			if pub.Payload != nil {
+				t.Errorf("Expected no payload for %q, got %#v", payloadFormat, pub.Payload)
+			}
+			continue
+		}
+
+		if !ok {
+			t.Fatalf("Expected a *encoding.Message payload for %q, got %#v", payloadFormat, pub.Payload)
+		}
+
+		msgJSON, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msgJSON) != string(pub.Msg) {
+			t.Errorf("Got payload %s, but message %s", msgJSON, pub.Msg)
+		}
+	}
+}
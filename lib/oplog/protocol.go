@@ -0,0 +1,43 @@
+package oplog
+
+import (
+	"fmt"
+)
+
+// The versions of the message format. Messages in version 1 are exactly what
+// redis-oplog expects, and don't say what version they are; every later
+// version includes its number as "pv", so consumers can tell which format
+// they're reading. Changes to the format that existing consumers wouldn't
+// understand go in a new version, so they can upgrade before we switch.
+const (
+	// ProtocolVersion1 is the redis-oplog message format. This is the
+	// default.
+	ProtocolVersion1 = 1
+
+	// ProtocolVersion2 is the redis-oplog message format, plus the protocol
+	// version ("pv")
+	ProtocolVersion2 = 2
+
+	// LatestProtocolVersion is the newest version of the message format
+	LatestProtocolVersion = ProtocolVersion2
+)
+
+// Returns an error if version isn't a version of the message format
+func validateProtocolVersion(version int) error {
+	if version < ProtocolVersion1 || version > LatestProtocolVersion {
+		return fmt.Errorf("Invalid protocol version %d: must be between %d and %d", version, ProtocolVersion1, LatestProtocolVersion)
+	}
+
+	return nil
+}
+
+// Returns the protocol version to include in a message ("pv"), or 0 to leave
+// it out, for an entry with the given ProtocolVersion (ProtocolVersion1 if
+// unset)
+func messageProtocolVersion(version int) int {
+	if version <= ProtocolVersion1 {
+		return 0
+	}
+
+	return version
+}
@@ -0,0 +1,64 @@
+package oplog
+
+import (
+	"testing"
+)
+
+func TestWithProtocolVersion(t *testing.T) {
+	for _, version := range []int{ProtocolVersion1, ProtocolVersion2} {
+		tailer, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithProtocolVersion(version))
+		if err != nil {
+			t.Errorf("Got unexpected error for protocol version %d: %s", version, err)
+		} else if tailer.ProtocolVersion != version {
+			t.Errorf("Got protocol version %d, expected %d", tailer.ProtocolVersion, version)
+		}
+	}
+
+	for _, version := range []int{0, LatestProtocolVersion + 1} {
+		_, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithProtocolVersion(version))
+		if err == nil {
+			t.Errorf("Expected an error for protocol version %d", version)
+		}
+	}
+}
+
+func TestProcessOplogEntryProtocolVersion(t *testing.T) {
+	tests := map[string]struct {
+		version int
+		want    string
+	}{
+		"Default": {
+			version: 0,
+			want:    `{"e":"i","d":{"_id":"someid"},"f":["_id"]}`,
+		},
+		"Version 1": {
+			version: ProtocolVersion1,
+			want:    `{"e":"i","d":{"_id":"someid"},"f":["_id"]}`,
+		},
+		"Version 2": {
+			version: ProtocolVersion2,
+			want:    `{"e":"i","d":{"_id":"someid"},"f":["_id"],"pv":2}`,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pub, err := processOplogEntry(&oplogEntry{
+				DocID:           "someid",
+				Operation:       "i",
+				Namespace:       "foo.bar",
+				Database:        "foo",
+				Collection:      "bar",
+				Data:            map[string]interface{}{"_id": "someid"},
+				ProtocolVersion: test.version,
+			})
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			if string(pub.Msg) != test.want {
+				t.Errorf("Got message %s, expected %s", pub.Msg, test.want)
+			}
+		})
+	}
+}
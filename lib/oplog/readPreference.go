@@ -0,0 +1,65 @@
+package oplog
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ReadPreference is which members of the replica set a Tailer reads the
+// oplog (and looks up documents) from
+type ReadPreference string
+
+const (
+	// ReadPrimary reads from the primary only
+	ReadPrimary ReadPreference = "primary"
+
+	// ReadPrimaryPreferred reads from the primary, or from a secondary if
+	// there's no primary
+	ReadPrimaryPreferred ReadPreference = "primaryPreferred"
+
+	// ReadSecondary reads from a secondary only. To tail a hidden secondary
+	// (which replica set discovery never finds), connect directly to it.
+	ReadSecondary ReadPreference = "secondary"
+
+	// ReadSecondaryPreferred reads from a secondary, or from the primary if
+	// there are no secondaries
+	ReadSecondaryPreferred ReadPreference = "secondaryPreferred"
+
+	// ReadNearest reads from the member with the lowest latency
+	ReadNearest ReadPreference = "nearest"
+)
+
+// The driver's mode for each read preference
+var readPreferenceModes = map[ReadPreference]readpref.Mode{
+	ReadPrimary:            readpref.PrimaryMode,
+	ReadPrimaryPreferred:   readpref.PrimaryPreferredMode,
+	ReadSecondary:          readpref.SecondaryMode,
+	ReadSecondaryPreferred: readpref.SecondaryPreferredMode,
+	ReadNearest:            readpref.NearestMode,
+}
+
+// ParseReadPreference parses "primary", "primaryPreferred", "secondary",
+// "secondaryPreferred", or "nearest"
+func ParseReadPreference(value string) (ReadPreference, error) {
+	preference := ReadPreference(value)
+	if _, ok := readPreferenceModes[preference]; !ok {
+		return "", fmt.Errorf("Invalid read preference %q: must be primary, primaryPreferred, secondary, secondaryPreferred, or nearest", value)
+	}
+
+	return preference, nil
+}
+
+// Returns the options for a database handle that reads per preference, or
+// per the client's read preference if preference is empty
+func databaseOptions(preference ReadPreference) *options.DatabaseOptions {
+	opts := options.Database()
+	if preference != "" {
+		// Only fails for tag sets or max staleness with ReadPrimary
+		readPref, _ := readpref.New(readPreferenceModes[preference])
+		opts.SetReadPreference(readPref)
+	}
+
+	return opts
+}
@@ -0,0 +1,64 @@
+package oplog
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestParseReadPreference(t *testing.T) {
+	for _, value := range []string{"primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest"} {
+		preference, err := ParseReadPreference(value)
+		if err != nil {
+			t.Errorf("Got unexpected error parsing %q: %s", value, err)
+		} else if string(preference) != value {
+			t.Errorf("Parsed %q as %q", value, preference)
+		}
+	}
+
+	for _, value := range []string{"", "Secondary", "hidden"} {
+		if _, err := ParseReadPreference(value); err == nil {
+			t.Errorf("Expected an error parsing %q", value)
+		}
+	}
+}
+
+func TestWithReadPreference(t *testing.T) {
+	tailer, err := NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithReadPreference(ReadSecondary))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if tailer.ReadPreference != ReadSecondary {
+		t.Errorf("Got read preference %q, expected %q", tailer.ReadPreference, ReadSecondary)
+	}
+
+	_, err = NewTailer(WithSource(&fakeSource{}), WithSink(&fakeSink{}), WithReadPreference("hidden"))
+	if err == nil {
+		t.Error("Expected an error for an invalid read preference")
+	}
+}
+
+func TestReadPreferenceModes(t *testing.T) {
+	expected := map[ReadPreference]readpref.Mode{
+		ReadPrimary:            readpref.PrimaryMode,
+		ReadPrimaryPreferred:   readpref.PrimaryPreferredMode,
+		ReadSecondary:          readpref.SecondaryMode,
+		ReadSecondaryPreferred: readpref.SecondaryPreferredMode,
+		ReadNearest:            readpref.NearestMode,
+	}
+
+	for preference, mode := range expected {
+		if readPreferenceModes[preference] != mode {
+			t.Errorf("Got mode %d for %q, expected %d", readPreferenceModes[preference], preference, mode)
+		}
+
+		readPref := databaseOptions(preference).ReadPreference
+		if readPref == nil || readPref.Mode() != mode {
+			t.Errorf("Got read preference %v for %q, expected mode %d", readPref, preference, mode)
+		}
+	}
+
+	if databaseOptions("").ReadPreference != nil {
+		t.Error("Expected no read preference, to use the client's")
+	}
+}
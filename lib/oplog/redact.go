@@ -0,0 +1,178 @@
+package oplog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Applies the Tailer's RedactFields and HashFields rules for the entry's
+// collection to the values that go in its message (its full document or
+// changed values, and, for Debezium messages, the entry's own document or
+// update) and to its routing field values, and records the redacted fields
+// so processOplogEntry leaves them out of the message's field list. It must
+// run after everything that fills in those values.
+func (tailer *Tailer) redact(entry *oplogEntry) {
+	redacted := tailer.RedactFields[entry.Namespace]
+	hashed := tailer.HashFields[entry.Namespace]
+	if len(redacted) == 0 && len(hashed) == 0 {
+		return
+	}
+
+	entry.RedactedFields = redacted
+	redactData := tailer.PayloadFormat == PayloadFormatDebezium
+
+	for _, field := range redacted {
+		entry.FullDocument = redactField(entry.FullDocument, field, nil)
+		entry.Routes = redactField(entry.Routes, field, nil)
+		if redactData {
+			entry.Data = redactEntryData(entry, field, nil)
+		}
+	}
+
+	for _, field := range hashed {
+		entry.FullDocument = redactField(entry.FullDocument, field, hashValue)
+		entry.Routes = redactField(entry.Routes, field, hashValue)
+		if redactData {
+			entry.Data = redactEntryData(entry, field, hashValue)
+		}
+	}
+}
+
+// Returns a copy of an entry's data with field redacted, like redactField:
+// in the document of an insert or replacement, in the operand of each
+// operator of an update, or in the sections of a delta update's diff
+func redactEntryData(entry *oplogEntry, field string, replace func(interface{}) interface{}) map[string]interface{} {
+	if !entry.IsUpdate() || entry.UpdateIsReplace() {
+		return redactField(entry.Data, field, replace)
+	}
+
+	result := make(map[string]interface{}, len(entry.Data))
+	for key, value := range entry.Data {
+		if operand, ok := asMap(value); ok {
+			if key == "diff" && entry.updateIsDelta() {
+				value = redactDiff(operand, field, replace)
+			} else {
+				value = redactField(operand, field, replace)
+			}
+		}
+
+		result[key] = value
+	}
+
+	return result
+}
+
+// Returns a copy of a delta update's diff (see diffFields) with field
+// redacted. A field that was changed in place (which has a subdiff) is
+// removed entirely, even when it's hashed, since there's no value to hash.
+func redactDiff(diff map[string]interface{}, field string, replace func(interface{}) interface{}) map[string]interface{} {
+	_, isArray := diff["a"]
+
+	result := make(map[string]interface{}, len(diff))
+	for key, value := range diff {
+		switch {
+		case isArray && key == "a", isArray && key == "l":
+		case isArray && strings.HasPrefix(key, "u"):
+			// An array element; the field is inside each element
+			value = redactNested(value, field, replace)
+		case isArray && strings.HasPrefix(key, "s"):
+			if subdiff, ok := asMap(value); ok {
+				value = redactDiff(subdiff, field, replace)
+			}
+		case key == "u" || key == "i" || key == "d":
+			if section, ok := asMap(value); ok {
+				value = redactField(section, field, replace)
+			}
+		case strings.HasPrefix(key, "s"):
+			name := key[1:]
+			if name == field {
+				continue
+			}
+
+			if subdiff, ok := asMap(value); ok && strings.HasPrefix(field, name+".") {
+				value = redactDiff(subdiff, field[len(name)+1:], replace)
+			}
+		}
+
+		result[key] = value
+	}
+
+	return result
+}
+
+// Returns a copy of values (a document, or a map of values keyed by dotted
+// path like `$set`) with field (a dotted path) removed, or replaced with the
+// result of replace if it's set. Fields inside arrays of embedded documents
+// are redacted in each element. The original values aren't modified.
+func redactField(values map[string]interface{}, field string, replace func(interface{}) interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		switch {
+		case key == field || strings.HasPrefix(key, field+"."):
+			// The field itself, or a field inside it
+			if replace == nil {
+				continue
+			}
+			value = replace(value)
+		case strings.HasPrefix(field, key+"."):
+			// A parent of the field
+			value = redactNested(value, field[len(key)+1:], replace)
+		}
+
+		result[key] = value
+	}
+
+	return result
+}
+
+// Redacts field (a dotted path) inside an embedded document, or inside each
+// element of an array
+func redactNested(value interface{}, field string, replace func(interface{}) interface{}) interface{} {
+	if doc, ok := asMap(value); ok {
+		return redactField(doc, field, replace)
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		result := make([]interface{}, len(array))
+		for i, element := range array {
+			result[i] = redactNested(element, field, replace)
+		}
+		return result
+	}
+
+	return value
+}
+
+// Replaces a value with the hex SHA-256 hash of its EJSON encoding, so
+// consumers can still compare values (and route by them) without seeing
+// them. The hash isn't salted, so values with few possibilities (like
+// booleans) can still be recovered.
+func hashValue(value interface{}) interface{} {
+	encoded, err := json.Marshal(ejsonValue(value))
+	if err != nil {
+		// Every value we read from Mongo can be encoded, but just in case
+		encoded = []byte(fmt.Sprintf("%#v", value))
+	}
+
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns whether a field in a message's field list is, or is inside, one of
+// the redacted fields
+func isRedactedField(field string, redacted []string) bool {
+	for _, r := range redacted {
+		if field == r || strings.HasPrefix(field, r+".") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,253 @@
+package oplog
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestRedactField(t *testing.T) {
+	replace := func(interface{}) interface{} { return "hashed" }
+
+	tests := map[string]struct {
+		values  map[string]interface{}
+		field   string
+		replace func(interface{}) interface{}
+		want    map[string]interface{}
+	}{
+		"Top-level field": {
+			values: map[string]interface{}{"a": 1, "b": 2},
+			field:  "a",
+			want:   map[string]interface{}{"b": 2},
+		},
+		"Nested field": {
+			values: map[string]interface{}{"services": bson.M{"password": "x", "google": "y"}},
+			field:  "services.password",
+			want:   map[string]interface{}{"services": map[string]interface{}{"google": "y"}},
+		},
+		"Dotted key": {
+			values: map[string]interface{}{"services.password": "x", "services.google": "y"},
+			field:  "services.password",
+			want:   map[string]interface{}{"services.google": "y"},
+		},
+		"Key inside the field": {
+			values: map[string]interface{}{"services.password.bcrypt": "x", "name": "Ada"},
+			field:  "services.password",
+			want:   map[string]interface{}{"name": "Ada"},
+		},
+		"Parent key with a dotted path": {
+			values: map[string]interface{}{"services.password": bson.M{"bcrypt": "x", "salt": "y"}},
+			field:  "services.password.bcrypt",
+			want:   map[string]interface{}{"services.password": map[string]interface{}{"salt": "y"}},
+		},
+		"Array of documents": {
+			values: map[string]interface{}{"emails": []interface{}{bson.M{"address": "a", "verified": true}, "other"}},
+			field:  "emails.address",
+			want:   map[string]interface{}{"emails": []interface{}{map[string]interface{}{"verified": true}, "other"}},
+		},
+		"Hashed field": {
+			values:  map[string]interface{}{"email": "ada@example.com", "name": "Ada"},
+			field:   "email",
+			replace: replace,
+			want:    map[string]interface{}{"email": "hashed", "name": "Ada"},
+		},
+		"Missing field": {
+			values: map[string]interface{}{"a": 1},
+			field:  "b.c",
+			want:   map[string]interface{}{"a": 1},
+		},
+		"Similar prefix": {
+			values: map[string]interface{}{"ab": 1},
+			field:  "a",
+			want:   map[string]interface{}{"ab": 1},
+		},
+		"Nil values": {
+			values: nil,
+			field:  "a",
+			want:   nil,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got := redactField(test.values, test.field, test.replace)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %#v, expected %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactFieldDoesNotModifyValues(t *testing.T) {
+	values := map[string]interface{}{"services": map[string]interface{}{"password": "x"}}
+	redactField(values, "services.password", nil)
+
+	if _, ok := values["services"].(map[string]interface{})["password"]; !ok {
+		t.Errorf("redactField modified its input: %#v", values)
+	}
+}
+
+func TestRedactEntryData(t *testing.T) {
+	replace := func(interface{}) interface{} { return "hashed" }
+
+	tests := map[string]struct {
+		operation string
+		data      map[string]interface{}
+		field     string
+		replace   func(interface{}) interface{}
+		want      map[string]interface{}
+	}{
+		"Insert": {
+			operation: "i",
+			data:      map[string]interface{}{"_id": "someid", "token": "x"},
+			field:     "token",
+			want:      map[string]interface{}{"_id": "someid"},
+		},
+		"Replacement": {
+			operation: "u",
+			data:      map[string]interface{}{"_id": "someid", "email": "x"},
+			field:     "email",
+			replace:   replace,
+			want:      map[string]interface{}{"_id": "someid", "email": "hashed"},
+		},
+		"Update operators": {
+			operation: "u",
+			data: map[string]interface{}{
+				"$v":     1,
+				"$set":   map[string]interface{}{"services.password": "x", "name": "Ada"},
+				"$unset": map[string]interface{}{"services.password": true},
+			},
+			field: "services.password",
+			want: map[string]interface{}{
+				"$v":     1,
+				"$set":   map[string]interface{}{"name": "Ada"},
+				"$unset": map[string]interface{}{},
+			},
+		},
+		"Delta update": {
+			operation: "u",
+			data: map[string]interface{}{
+				"$v": 2,
+				"diff": map[string]interface{}{
+					"u":         map[string]interface{}{"email": "x", "name": "Ada"},
+					"sservices": map[string]interface{}{"u": map[string]interface{}{"password": "x", "google": "y"}},
+				},
+			},
+			field:   "services.password",
+			replace: replace,
+			want: map[string]interface{}{
+				"$v": 2,
+				"diff": map[string]interface{}{
+					"u":         map[string]interface{}{"email": "x", "name": "Ada"},
+					"sservices": map[string]interface{}{"u": map[string]interface{}{"password": "hashed", "google": "y"}},
+				},
+			},
+		},
+		"Delta update of a field changed in place": {
+			operation: "u",
+			data: map[string]interface{}{
+				"$v": 2,
+				"diff": map[string]interface{}{
+					"u":         map[string]interface{}{"name": "Ada"},
+					"sservices": map[string]interface{}{"u": map[string]interface{}{"google": "y"}},
+				},
+			},
+			field:   "services",
+			replace: replace,
+			want: map[string]interface{}{
+				"$v":   2,
+				"diff": map[string]interface{}{"u": map[string]interface{}{"name": "Ada"}},
+			},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			entry := &oplogEntry{Operation: test.operation, Data: test.data}
+
+			got := redactEntryData(entry, test.field, test.replace)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %#v, expected %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHashValue(t *testing.T) {
+	first := hashValue("ada@example.com")
+	if first != hashValue("ada@example.com") {
+		t.Errorf("Hashes of the same value differ")
+	}
+
+	if first == hashValue("grace@example.com") {
+		t.Errorf("Hashes of different values are the same")
+	}
+
+	if first == "ada@example.com" || len(first.(string)) != 64 {
+		t.Errorf("Got unexpected hash %v", first)
+	}
+}
+
+func TestProcessRedaction(t *testing.T) {
+	insert, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "i",
+		"ns": "app.users",
+		"o": bson.M{
+			"_id":      "someid",
+			"name":     "Ada",
+			"email":    "ada@example.com",
+			"services": bson.M{"password": "secret", "google": "abc"},
+			"token":    "xyz",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	tailer := &Tailer{
+		ChangedValues: true,
+		RoutingFields: map[string][]string{"app.users": {"email"}},
+		RedactFields:  map[string][]string{"app.users": {"services.password", "token"}},
+		HashFields:    map[string][]string{"app.users": {"email"}},
+	}
+
+	pub := tailer.Process(bson.Raw{Kind: 3, Data: insert})
+	if pub == nil {
+		t.Fatalf("Expected a publication for an insert, got nil")
+	}
+
+	var msg struct {
+		Doc    map[string]interface{} `json:"d"`
+		Fields []string               `json:"f"`
+	}
+	err = json.Unmarshal(pub.Msg, &msg)
+	if err != nil {
+		t.Fatalf("Could not unmarshal message: %s", err)
+	}
+
+	emailHash := hashValue("ada@example.com").(string)
+	wantDoc := map[string]interface{}{
+		"_id":      "someid",
+		"name":     "Ada",
+		"email":    emailHash,
+		"services": map[string]interface{}{"google": "abc"},
+	}
+	if !reflect.DeepEqual(msg.Doc, wantDoc) {
+		t.Errorf("Got document %#v, expected %#v", msg.Doc, wantDoc)
+	}
+
+	// services stays in the field list, since only part of it is redacted
+	wantFields := []string{"_id", "email", "name", "services"}
+	if !reflect.DeepEqual(msg.Fields, wantFields) {
+		t.Errorf("Got fields %#v, expected %#v", msg.Fields, wantFields)
+	}
+
+	wantChannels := []string{"app.users::email::" + emailHash}
+	if !reflect.DeepEqual(pub.ExtraChannels, wantChannels) {
+		t.Errorf("Got extra channels %#v, expected %#v", pub.ExtraChannels, wantChannels)
+	}
+}
@@ -0,0 +1,82 @@
+package oplog
+
+import (
+	"context"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Replay reads the oplog entries with timestamps in the range (from, to] and
+// writes the resulting publications to the out channel, in the same way that
+// Tail does. If namespaces is non-empty, only publications for those
+// namespaces (in the form "<database>.<collection>") are replayed, including
+// those for the operations of transactions (applyOps entries) and commands.
+// The namespaces are applied on top of the Tailer's namespace filter for the
+// duration of the replay, so Replay must not be called while the Tailer is
+// tailing.
+//
+// Unlike Tail, Replay returns once it has read the whole range, and does not
+// consult or update the last-processed timestamp. It does not close the out
+// channel.
+func (tailer *Tailer) Replay(out chan<- *redispub.Publication, from bson.MongoTimestamp, to bson.MongoTimestamp, namespaces []string) error {
+	if len(namespaces) > 0 {
+		tailerFilter := tailer.namespaceFilter
+		tailer.namespaceFilter = replayNamespaceFilter(namespaces, tailerFilter)
+		defer func() { tailer.namespaceFilter = tailerFilter }()
+	}
+
+	oplogCollection := tailer.database("local").Collection("oplog.rs")
+	opts := options.Find().SetOplogReplay(true).SetSort(mongodb.Doc(bson.M{"$natural": 1}))
+	iter := mongodb.NewIter(oplogCollection.Find(context.Background(), mongodb.Doc(replayQuery(from, to)), opts))
+
+	count := 0
+	var rawData bson.Raw
+	for iter.Next(&rawData) {
+		pubs, _ := tailer.unmarshalEntry(rawData)
+		for _, pub := range pubs {
+			out <- pub
+			count++
+		}
+	}
+
+	log.Log.Infow("Finished replaying oplog entries",
+		"count", count)
+
+	return iter.Close()
+}
+
+// Builds the query for the oplog entries that Replay should read. It can't
+// select namespaces: transactions (applyOps entries) are in admin.$cmd, and
+// other commands in <database>.$cmd, so namespaces are filtered once those
+// are expanded (see replayNamespaceFilter). No-ops are never published, so
+// they aren't read.
+func replayQuery(from bson.MongoTimestamp, to bson.MongoTimestamp) bson.M {
+	return bson.M{
+		"ts": bson.M{
+			"$gt":  from,
+			"$lte": to,
+		},
+		"op": bson.M{
+			"$in": []string{operationInsert, operationUpdate, operationRemove, operationCommand},
+		},
+	}
+}
+
+// Creates the NamespaceFilter that Replay uses when it's given namespaces:
+// it accepts only those namespaces, and only if the Tailer's filter (if any)
+// accepts them too
+func replayNamespaceFilter(namespaces []string, tailerFilter NamespaceFilter) NamespaceFilter {
+	replayed := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		replayed[namespace] = true
+	}
+
+	return func(database string, collection string) bool {
+		return replayed[database+"."+collection] &&
+			(tailerFilter == nil || tailerFilter(database, collection))
+	}
+}
@@ -0,0 +1,68 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestReplayQuery(t *testing.T) {
+	query := replayQuery(bson.MongoTimestamp(1), bson.MongoTimestamp(2))
+
+	ts, ok := query["ts"].(bson.M)
+	if !ok || ts["$gt"] != bson.MongoTimestamp(1) || ts["$lte"] != bson.MongoTimestamp(2) {
+		t.Errorf("Incorrect timestamp range in query: %#v", query["ts"])
+	}
+
+	op, ok := query["op"].(bson.M)
+	if !ok || !reflect.DeepEqual(op["$in"], []string{"i", "u", "d", "c"}) {
+		t.Errorf("Incorrect operation filter in query: %#v", query["op"])
+	}
+
+	// Transactions and commands aren't in the namespaces they change
+	if _, ok := query["ns"]; ok {
+		t.Errorf("Expected no namespace filter in query: %#v", query["ns"])
+	}
+}
+
+func TestReplayNamespaceFilter(t *testing.T) {
+	filter := replayNamespaceFilter([]string{"foo.bar", "foo.baz"}, func(database string, collection string) bool {
+		return collection != "baz"
+	})
+
+	tests := map[string]bool{
+		"foo.bar":   true,
+		"foo.baz":   false, // Excluded by the tailer's filter
+		"foo.qux":   false,
+		"other.bar": false,
+	}
+	for namespace, expected := range tests {
+		database, collection := parseNamespace(namespace)
+		if got := filter(database, collection); got != expected {
+			t.Errorf("Got %t for %s, expected %t", got, namespace, expected)
+		}
+	}
+
+	// Operations of a transaction are filtered once it's expanded
+	data, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "c",
+		"ns": "admin.$cmd",
+		"o": bson.M{
+			"applyOps": []bson.M{
+				{"op": "i", "ns": "foo.bar", "o": bson.M{"_id": "a"}},
+				{"op": "i", "ns": "foo.qux", "o": bson.M{"_id": "b"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	tailer := &Tailer{namespaceFilter: replayNamespaceFilter([]string{"foo.bar"}, nil)}
+	pubs, _ := tailer.unmarshalEntry(bson.Raw{Kind: 3, Data: data})
+	if len(pubs) != 1 || pubs[0].SpecificChannel != "foo.bar::a" {
+		t.Errorf("Got publications %#v, expected only the one for foo.bar::a", pubs)
+	}
+}
@@ -0,0 +1,93 @@
+package oplog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Fills in entry.Routes with the values of the routing fields for the entry's
+// collection, for Tailers with RoutingFields set. Inserts and replacements
+// have every field; other updates only have the fields they set, unless the
+// Tailer has RoutingLookup set, in which case we fetch the rest from Mongo.
+// Removes don't have any, since the document is gone.
+func (tailer *Tailer) addRoutes(entry *oplogEntry) {
+	fields := tailer.RoutingFields[entry.Namespace]
+	if len(fields) == 0 || !(entry.IsInsert() || entry.IsUpdate()) {
+		return
+	}
+
+	isWholeDocument := entry.IsInsert() || entry.UpdateIsReplace()
+
+	values := entry.Data
+	if !isWholeDocument {
+		values = entry.changedValues()
+	}
+
+	routes := map[string]interface{}{}
+	var missing []string
+	for _, field := range fields {
+		if value, ok := lookupField(values, field); ok {
+			routes[field] = value
+		} else if !isWholeDocument {
+			missing = append(missing, field)
+		}
+	}
+
+	if len(missing) > 0 && tailer.RoutingLookup {
+		doc, err := tailer.fetchDocument(entry, missing)
+		if err == mongo.ErrNoDocuments {
+			// The document was removed since
+			log.Log.Debugw("Document for routing lookup no longer exists",
+				"namespace", entry.Namespace,
+				"id", entry.DocID)
+		} else if err != nil {
+			log.Log.Errorw("Error fetching document for routing lookup",
+				"namespace", entry.Namespace,
+				"id", entry.DocID,
+				"error", err)
+			tailer.hooks.error(fmt.Errorf("Error fetching document for routing lookup in %s: %s", entry.Namespace, err))
+		} else {
+			for _, field := range missing {
+				if value, ok := lookupField(doc, field); ok {
+					routes[field] = value
+				}
+			}
+		}
+	}
+
+	if len(routes) > 0 {
+		entry.Routes = routes
+	}
+}
+
+// Looks up a field, which may be a dotted path, in a document or in a map of
+// values keyed by dotted path (like `$set`), which may contain a parent of
+// the field rather than the field itself.
+func lookupField(values map[string]interface{}, field string) (interface{}, bool) {
+	if value, ok := values[field]; ok {
+		return value, true
+	}
+
+	for i := strings.Index(field, "."); i >= 0; i = nextDot(field, i) {
+		if parent, ok := asMap(values[field[:i]]); ok {
+			if value, ok := lookupField(parent, field[i+1:]); ok {
+				return value, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Returns the index of the next "." in s after index i, or -1
+func nextDot(s string, i int) int {
+	next := strings.Index(s[i+1:], ".")
+	if next < 0 {
+		return -1
+	}
+
+	return i + 1 + next
+}
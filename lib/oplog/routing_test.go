@@ -0,0 +1,109 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestAddRoutes(t *testing.T) {
+	tailer := &Tailer{
+		RoutingFields: map[string][]string{"foo.bar": {"tenantId", "meta.region"}},
+	}
+
+	tests := map[string]struct {
+		in   *oplogEntry
+		want map[string]interface{}
+	}{
+		"Insert": {
+			in: &oplogEntry{Operation: "i", Namespace: "foo.bar", Data: map[string]interface{}{
+				"_id":      "someid",
+				"tenantId": "acme",
+				"meta":     bson.M{"region": "eu"},
+			}},
+			want: map[string]interface{}{"tenantId": "acme", "meta.region": "eu"},
+		},
+		"Insert without the fields": {
+			in:   &oplogEntry{Operation: "i", Namespace: "foo.bar", Data: map[string]interface{}{"_id": "someid"}},
+			want: nil,
+		},
+		"$set update": {
+			in: &oplogEntry{Operation: "u", Namespace: "foo.bar", Data: map[string]interface{}{
+				"$set": bson.M{"tenantId": "acme", "meta.region": "eu"},
+			}},
+			want: map[string]interface{}{"tenantId": "acme", "meta.region": "eu"},
+		},
+		"$set of a parent": {
+			in: &oplogEntry{Operation: "u", Namespace: "foo.bar", Data: map[string]interface{}{
+				"$set": bson.M{"meta": bson.M{"region": "eu"}},
+			}},
+			want: map[string]interface{}{"meta.region": "eu"},
+		},
+		"Delta update": {
+			in: &oplogEntry{Operation: "u", Namespace: "foo.bar", Data: map[string]interface{}{
+				"$v":   2,
+				"diff": bson.M{"u": bson.M{"tenantId": "acme"}},
+			}},
+			want: map[string]interface{}{"tenantId": "acme"},
+		},
+		// Without RoutingLookup, we don't fetch fields the update didn't set
+		"Update without the fields": {
+			in: &oplogEntry{Operation: "u", Namespace: "foo.bar", Data: map[string]interface{}{
+				"$set": bson.M{"other": 1},
+			}},
+			want: nil,
+		},
+		"Remove": {
+			in:   &oplogEntry{Operation: "d", Namespace: "foo.bar", Data: map[string]interface{}{"_id": "someid"}},
+			want: nil,
+		},
+		"Other collection": {
+			in:   &oplogEntry{Operation: "i", Namespace: "foo.other", Data: map[string]interface{}{"tenantId": "acme"}},
+			want: nil,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			tailer.addRoutes(test.in)
+
+			if !reflect.DeepEqual(test.in.Routes, test.want) {
+				t.Errorf("Got routes %#v, expected %#v", test.in.Routes, test.want)
+			}
+		})
+	}
+}
+
+func TestLookupField(t *testing.T) {
+	values := map[string]interface{}{
+		"a":     1,
+		"b.c":   2,
+		"d":     bson.M{"e": bson.M{"f": 3}},
+		"g.h":   map[string]interface{}{"i": 4},
+		"array": []interface{}{1},
+	}
+
+	tests := map[string]struct {
+		field  string
+		want   interface{}
+		wantOK bool
+	}{
+		"Top-level":            {field: "a", want: 1, wantOK: true},
+		"Dotted key":           {field: "b.c", want: 2, wantOK: true},
+		"Nested":               {field: "d.e.f", want: 3, wantOK: true},
+		"Nested in dotted key": {field: "g.h.i", want: 4, wantOK: true},
+		"Missing":              {field: "x", wantOK: false},
+		"Missing nested":       {field: "d.x", wantOK: false},
+		"Through a non-object": {field: "array.0", wantOK: false},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got, ok := lookupField(values, test.field)
+			if ok != test.wantOK || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("lookupField(%q) = %#v, %t; expected %#v, %t", test.field, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
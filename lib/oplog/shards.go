@@ -0,0 +1,62 @@
+package oplog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Shard describes one shard of a sharded cluster, as registered in
+// config.shards
+type Shard struct {
+	// The shard's name (its _id in config.shards)
+	Name string
+
+	// The name of the shard's replica set, or empty if the shard is a
+	// standalone server
+	ReplicaSet string
+
+	// The addresses of the shard's members
+	Addrs []string
+}
+
+// ListShards returns the shards of the sharded cluster that session is
+// connected to (through mongos), from the config.shards collection.
+func ListShards(client *mongo.Client) ([]Shard, error) {
+	var docs []struct {
+		ID   string `bson:"_id"`
+		Host string `bson:"host"`
+	}
+	opts := options.Find().SetSort(mongodb.Doc(bson.M{"_id": 1}))
+	err := mongodb.NewIter(client.Database("config").Collection("shards").Find(context.Background(), mongodb.Doc(nil), opts)).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make([]Shard, len(docs))
+	for i, doc := range docs {
+		shards[i] = parseShardHost(doc.ID, doc.Host)
+	}
+
+	return shards, nil
+}
+
+// Parses the host field of a config.shards document, which is
+// "<replica set>/<addr>,<addr>,..." for replica set shards, and just the
+// address for standalone shards
+func parseShardHost(name string, host string) Shard {
+	shard := Shard{Name: name}
+
+	if slash := strings.Index(host, "/"); slash != -1 {
+		shard.ReplicaSet = host[:slash]
+		host = host[slash+1:]
+	}
+
+	shard.Addrs = strings.Split(host, ",")
+
+	return shard
+}
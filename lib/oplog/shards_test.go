@@ -0,0 +1,38 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseShardHost(t *testing.T) {
+	tests := map[string]struct {
+		host     string
+		expected Shard
+	}{
+		"Replica set": {
+			host: "rs0/mongo1:27017,mongo2:27017",
+			expected: Shard{
+				Name:       "shard0",
+				ReplicaSet: "rs0",
+				Addrs:      []string{"mongo1:27017", "mongo2:27017"},
+			},
+		},
+		"Standalone": {
+			host: "mongo1:27017",
+			expected: Shard{
+				Name:  "shard0",
+				Addrs: []string{"mongo1:27017"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseShardHost("shard0", test.host)
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("parseShardHost(%q) = %#v, expected %#v", test.host, got, test.expected)
+			}
+		})
+	}
+}
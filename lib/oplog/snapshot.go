@@ -0,0 +1,254 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var metricSnapshotDocuments = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "snapshot_documents",
+	Help:      "Documents published as inserts by snapshots (see OTR_SNAPSHOT_NAMESPACES)",
+})
+
+// Databases that never contain application data, so they're never included
+// in snapshots
+var snapshotSkippedDatabases = map[string]bool{
+	"admin":  true,
+	"config": true,
+	"local":  true,
+}
+
+// Snapshot publishes an insert for every document in the collections that
+// match one of the glob patterns (in the syntax of NewGlobNamespaceFilter),
+// and that the Tailer's namespace filter accepts, so that new consumers (like
+// caches or search indexes) can bootstrap from oplogtoredis alone. Documents
+// are processed just like inserts read from the oplog. If rate is positive,
+// at most that many documents are published per second.
+//
+// It returns the timestamp of the end of the oplog from just before the
+// snapshot started. Tailing from there (with ResumeFrom) covers every change
+// made during the snapshot, so consumers may see changes that are already
+// reflected in the snapshot, but never miss one.
+//
+// Every publication has that timestamp, but only the last one records it as
+// processed (the others have MoreInEntry set), so a checkpoint is only saved
+// once the whole snapshot is published, and a snapshot that's interrupted is
+// taken again. Snapshot returns ctx's error if ctx is cancelled before it's
+// done. It does not close the out channel.
+func (tailer *Tailer) Snapshot(ctx context.Context, out chan<- *redispub.Publication, patterns []string, rate int) (bson.MongoTimestamp, error) {
+	if tailer.MongoClient == nil {
+		return 0, errors.New("Snapshots need a Mongo client to read collections from")
+	}
+
+	filter, err := NewGlobNamespaceFilter(patterns, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	ts, err := tailer.source().LastTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("Error getting the timestamp of the end of the oplog: %s", err)
+	}
+
+	namespaces, err := snapshotNamespaces(tailer.MongoClient, func(database string, collection string) bool {
+		return (filter == nil || filter(database, collection)) &&
+			(tailer.namespaceFilter == nil || tailer.namespaceFilter(database, collection))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	log.Log.Infow("Starting snapshot",
+		"namespaces", namespaces,
+		"timestamp", FormatTimestamp(ts))
+
+	pacer := &snapshotPacer{rate: rate, start: time.Now()}
+	sender := &snapshotSender{out: out}
+	for _, namespace := range namespaces {
+		database, collection := parseNamespace(namespace)
+
+		count := 0
+		iter := mongodb.NewIter(tailer.database(database).Collection(collection).Find(ctx, mongodb.Doc(nil)))
+		var raw bson.Raw
+		for iter.Next(&raw) {
+			doc, err := decodeDocument(raw)
+			if err != nil {
+				_ = iter.Close()
+				return 0, fmt.Errorf("Error decoding document in %s for snapshot: %s", namespace, err)
+			}
+
+			for _, pub := range tailer.snapshotPublications(namespace, doc, ts) {
+				if err := sender.send(ctx, pub); err != nil {
+					_ = iter.Close()
+					return 0, err
+				}
+			}
+
+			count++
+			metricSnapshotDocuments.Inc()
+
+			if err := pacer.wait(ctx); err != nil {
+				_ = iter.Close()
+				return 0, err
+			}
+		}
+
+		if err := iter.Close(); err != nil {
+			return 0, fmt.Errorf("Error reading %s for snapshot: %s", namespace, err)
+		}
+
+		log.Log.Infow("Finished snapshot of collection",
+			"namespace", namespace,
+			"count", count)
+	}
+
+	if err := sender.finish(ctx); err != nil {
+		return 0, err
+	}
+
+	log.Log.Infow("Finished snapshot",
+		"timestamp", FormatTimestamp(ts))
+
+	return ts, nil
+}
+
+// Lists the namespaces ("<database>.<collection>") of the collections that
+// the filter accepts, skipping system databases and collections
+func snapshotNamespaces(client *mongo.Client, filter NamespaceFilter) ([]string, error) {
+	databases, err := client.ListDatabaseNames(context.Background(), mongodb.Doc(nil))
+	if err != nil {
+		return nil, fmt.Errorf("Error listing databases for snapshot: %s", err)
+	}
+	sort.Strings(databases)
+
+	var namespaces []string
+	for _, database := range databases {
+		if snapshotSkippedDatabases[database] {
+			continue
+		}
+
+		collections, err := client.Database(database).ListCollectionNames(context.Background(), mongodb.Doc(nil))
+		if err != nil {
+			return nil, fmt.Errorf("Error listing collections in %s for snapshot: %s", database, err)
+		}
+		sort.Strings(collections)
+
+		for _, collection := range collections {
+			if strings.HasPrefix(collection, "system.") || !filter(database, collection) {
+				continue
+			}
+
+			namespaces = append(namespaces, database+"."+collection)
+		}
+	}
+
+	return namespaces, nil
+}
+
+// Returns the publications for one document of a snapshot, processed like an
+// insert with timestamp ts
+func (tailer *Tailer) snapshotPublications(namespace string, doc map[string]interface{}, ts bson.MongoTimestamp) []*redispub.Publication {
+	pubs, _, _ := tailer.processEntry(&rawOplogEntry{
+		Timestamp: ts,
+		Operation: operationInsert,
+		Namespace: namespace,
+		Doc:       doc,
+	})
+
+	for _, pub := range pubs {
+		// Every publication in the snapshot has the same timestamp, so they
+		// have to be deduplicated by document
+		pub.DedupeSuffix = joinDedupeSuffix(pub.SpecificChannel, pub.DedupeSuffix)
+		pub.Shard = tailer.Shard
+	}
+
+	return tailer.hooks.filterPublications(pubs)
+}
+
+// Sends a snapshot's publications, holding back the latest one until the
+// next is sent, so the last one can be marked as the end of the snapshot
+type snapshotSender struct {
+	out  chan<- *redispub.Publication
+	held *redispub.Publication
+}
+
+// Sends the publication that was held back, if any, and holds back pub.
+// Returns ctx's error if ctx is cancelled first.
+func (s *snapshotSender) send(ctx context.Context, pub *redispub.Publication) error {
+	pub.MoreInEntry = true
+
+	if s.held != nil {
+		select {
+		case s.out <- s.held:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	s.held = pub
+	return nil
+}
+
+// Sends the publication that was held back, if any, as the one that records
+// the snapshot's timestamp as processed. Returns ctx's error if ctx is
+// cancelled first.
+func (s *snapshotSender) finish(ctx context.Context) error {
+	if s.held == nil {
+		return nil
+	}
+
+	s.held.MoreInEntry = false
+
+	select {
+	case s.out <- s.held:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.held = nil
+	return nil
+}
+
+// Spaces out snapshot documents so there are at most rate per second
+type snapshotPacer struct {
+	rate  int
+	start time.Time
+	count int
+}
+
+// Waits until the next document is due, or returns ctx's error if ctx is
+// cancelled first
+func (p *snapshotPacer) wait(ctx context.Context) error {
+	if p.rate <= 0 {
+		return nil
+	}
+
+	p.count++
+	due := p.start.Add(time.Duration(p.count) * time.Second / time.Duration(p.rate))
+
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+package oplog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestSnapshotPublications(t *testing.T) {
+	tailer := &Tailer{Shard: "shard1", DocumentVersion: true}
+	ts := bson.MongoTimestamp(1500000000 << 32)
+
+	pubs := tailer.snapshotPublications("foo.bar", map[string]interface{}{"_id": "someid", "some": "field"}, ts)
+	if len(pubs) != 1 {
+		t.Fatalf("Got %d publications, expected 1", len(pubs))
+	}
+
+	pub := pubs[0]
+	if pub.CollectionChannel != "foo.bar" || pub.SpecificChannel != "foo.bar::someid" {
+		t.Errorf("Got channels %s and %s", pub.CollectionChannel, pub.SpecificChannel)
+	}
+	if pub.OplogTimestamp != ts {
+		t.Errorf("Got timestamp %d, expected %d", pub.OplogTimestamp, ts)
+	}
+	if pub.DedupeSuffix != "foo.bar::someid" {
+		t.Errorf("Got dedupe suffix %q, expected the specific channel", pub.DedupeSuffix)
+	}
+	if pub.Shard != "shard1" {
+		t.Errorf("Got shard %q, expected shard1", pub.Shard)
+	}
+	if string(pub.Msg) != `{"e":"i","d":{"_id":"someid"},"f":["_id","some"],"v":"6442450944000000000"}` {
+		t.Errorf("Got message %s", pub.Msg)
+	}
+
+	filtered := &Tailer{namespaceFilter: func(database string, collection string) bool { return false }}
+	if pubs := filtered.snapshotPublications("foo.bar", map[string]interface{}{"_id": "someid"}, ts); len(pubs) != 0 {
+		t.Errorf("Got %d publications for a filtered-out collection, expected none", len(pubs))
+	}
+}
+
+func TestSnapshotSender(t *testing.T) {
+	out := make(chan *redispub.Publication, 3)
+	sender := &snapshotSender{out: out}
+
+	pubs := []*redispub.Publication{{Msg: []byte("1")}, {Msg: []byte("2")}, {Msg: []byte("3")}}
+	for i, pub := range pubs {
+		if err := sender.send(context.Background(), pub); err != nil {
+			t.Fatal(err)
+		}
+
+		// The latest publication is held back until the next one is sent
+		if len(out) != i {
+			t.Errorf("Got %d publications sent after sending %d, expected %d", len(out), i+1, i)
+		}
+	}
+
+	if err := sender.finish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	i := 0
+	for pub := range out {
+		if pub != pubs[i] {
+			t.Errorf("Got publication %s at index %d, expected %s", pub.Msg, i, pubs[i].Msg)
+		}
+
+		// Only the last publication records the snapshot as processed
+		if expected := i < len(pubs)-1; pub.MoreInEntry != expected {
+			t.Errorf("Got MoreInEntry %t for publication %d, expected %t", pub.MoreInEntry, i, expected)
+		}
+		i++
+	}
+	if i != len(pubs) {
+		t.Errorf("Got %d publications, expected %d", i, len(pubs))
+	}
+
+	// Finishing an empty snapshot sends nothing
+	empty := &snapshotSender{out: make(chan *redispub.Publication)}
+	if err := empty.finish(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSnapshotPacer(t *testing.T) {
+	unlimited := &snapshotPacer{start: time.Now()}
+	for i := 0; i < 1000; i++ {
+		if err := unlimited.wait(context.Background()); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+
+	start := time.Now()
+	limited := &snapshotPacer{rate: 200, start: start}
+	for i := 0; i < 10; i++ {
+		if err := limited.wait(context.Background()); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("10 documents at 200 per second took %s, expected at least 50ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := &snapshotPacer{rate: 1, start: time.Now()}
+	if err := slow.wait(ctx); err != context.Canceled {
+		t.Errorf("Got error %v after cancelling, expected context.Canceled", err)
+	}
+}
@@ -0,0 +1,150 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OplogSource provides the oplog entries that a Tailer reads. The default
+// implementation, used when a Tailer only has a MongoClient, reads the
+// local.oplog.rs collection of a Mongo replica set member. Other
+// implementations can supply entries from elsewhere, such as from memory in
+// tests.
+type OplogSource interface {
+	// LastTimestamp returns the timestamp of the most recent oplog entry
+	LastTimestamp() (bson.MongoTimestamp, error)
+
+	// TailFrom returns a tailable cursor over the oplog entries with
+	// timestamps after ts, in oplog order. If no entries arrive within
+	// timeout, the cursor's Next returns false and its Timeout returns true;
+	// the caller may then call Next again to keep waiting.
+	TailFrom(ts bson.MongoTimestamp, timeout time.Duration) OplogIterator
+}
+
+// OplogIterator is a cursor over raw oplog entries. It has the same
+// semantics as *mongodb.Iter, which implements it.
+type OplogIterator interface {
+	// Next unmarshals the next entry into result (typically a *bson.Raw),
+	// and returns false when there are no more entries (or an error
+	// occurred, or the cursor timed out).
+	Next(result interface{}) bool
+	Err() error
+	Timeout() bool
+	Close() error
+}
+
+// Sink is where a Tailer's publications are ultimately delivered. The Tailer
+// writes its publications to a channel, so the only thing it needs from the
+// sink is where to resume from: the timestamp of the last publication that
+// was delivered.
+type Sink interface {
+	// LastProcessedTimestamp returns the timestamp of the last oplog entry
+	// that was delivered, and the time it represents. It returns
+	// ErrNoLastProcessed if nothing has been delivered yet.
+	LastProcessedTimestamp() (bson.MongoTimestamp, time.Time, error)
+}
+
+// ResumeTokenSink is a Sink that also records the resume tokens of change
+// stream events (see NewChangeStreamSource). A Tailer reading a change stream
+// resumes after the recorded token if it's for the last-processed entry,
+// which is more reliable than resuming from the timestamp: it picks up
+// exactly where we left off even among events that share a timestamp.
+type ResumeTokenSink interface {
+	Sink
+
+	// LastResumeToken returns the resume token of the last change event
+	// that was delivered, and the event's timestamp. It returns
+	// ErrNoLastProcessed if no token has been recorded.
+	LastResumeToken() (bson.MongoTimestamp, string, error)
+}
+
+// ErrNoLastProcessed is returned by Sink.LastProcessedTimestamp if nothing
+// has been delivered yet.
+var ErrNoLastProcessed = errors.New("No last-processed timestamp")
+
+// NewMongoSource creates an OplogSource that reads the oplog of the Mongo
+// server the client is connected to.
+func NewMongoSource(client *mongo.Client) OplogSource {
+	return newMongoSource(client.Database("local"))
+}
+
+func newMongoSource(local *mongo.Database) OplogSource {
+	return &mongoSource{oplog: local.Collection("oplog.rs")}
+}
+
+type mongoSource struct {
+	oplog *mongo.Collection
+}
+
+func (s *mongoSource) LastTimestamp() (bson.MongoTimestamp, error) {
+	var entry rawOplogEntry
+	err := mongodb.FindOne(s.oplog, bson.M{}, &entry, options.FindOne().SetSort(mongodb.Doc(bson.M{"$natural": -1})))
+	return entry.Timestamp, err
+}
+
+func (s *mongoSource) OldestTimestamp() (bson.MongoTimestamp, error) {
+	return oldestTimestamp(s.oplog)
+}
+
+func (s *mongoSource) TailFrom(ts bson.MongoTimestamp, timeout time.Duration) OplogIterator {
+	opts := options.Find().
+		SetCursorType(options.TailableAwait).
+		SetMaxAwaitTime(timeout).
+		SetOplogReplay(true).
+		SetSort(mongodb.Doc(bson.M{"$natural": 1}))
+
+	return mongodb.NewTailIter(s.oplog.Find(context.Background(), mongodb.Doc(bson.M{"ts": bson.M{"$gt": ts}}), opts))
+}
+
+// Returns the timestamp of the oldest entry in an oplog collection
+func oldestTimestamp(oplog *mongo.Collection) (bson.MongoTimestamp, error) {
+	var entry rawOplogEntry
+	err := mongodb.FindOne(oplog, bson.M{}, &entry, options.FindOne().SetSort(mongodb.Doc(bson.M{"$natural": 1})))
+	return entry.Timestamp, err
+}
+
+// NewRedisSink creates a Sink that reads the last-processed timestamp (and
+// resume token) that redispub.PublishStream records in Redis. It implements
+// ResumeTokenSink.
+func NewRedisSink(client redis.UniversalClient, metadataPrefix string) Sink {
+	return NewCheckpointStoreSink(redispub.NewRedisCheckpointStore(client), metadataPrefix)
+}
+
+// NewCheckpointStoreSink creates a Sink that reads the last-processed
+// timestamp (and resume token) from a redispub.CheckpointStore, for when
+// redispub.PublishStream records it somewhere other than Redis (see
+// redispub.PublishOpts.CheckpointStore). It implements ResumeTokenSink.
+func NewCheckpointStoreSink(store redispub.CheckpointStore, metadataPrefix string) Sink {
+	return &checkpointStoreSink{store: store, metadataPrefix: metadataPrefix}
+}
+
+type checkpointStoreSink struct {
+	store          redispub.CheckpointStore
+	metadataPrefix string
+}
+
+func (s *checkpointStoreSink) LastProcessedTimestamp() (bson.MongoTimestamp, time.Time, error) {
+	ts, t, err := s.store.LastProcessedTimestamp(s.metadataPrefix)
+	if err == redispub.ErrNoCheckpoint {
+		return ts, t, ErrNoLastProcessed
+	}
+
+	return ts, t, err
+}
+
+func (s *checkpointStoreSink) LastResumeToken() (bson.MongoTimestamp, string, error) {
+	ts, token, err := s.store.LastResumeToken(s.metadataPrefix)
+	if err == redispub.ErrNoCheckpoint {
+		return ts, token, ErrNoLastProcessed
+	}
+
+	return ts, token, err
+}
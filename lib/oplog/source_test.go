@@ -0,0 +1,141 @@
+package oplog
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// An in-memory OplogSource
+type fakeSource struct {
+	mutex   sync.Mutex
+	entries []bson.Raw
+}
+
+func (s *fakeSource) add(t *testing.T, entry bson.M) {
+	data, err := bson.Marshal(entry)
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append(s.entries, bson.Raw{Kind: 3, Data: data})
+}
+
+func (s *fakeSource) LastTimestamp() (bson.MongoTimestamp, error) {
+	return bson.MongoTimestamp(1), nil
+}
+
+func (s *fakeSource) TailFrom(ts bson.MongoTimestamp, timeout time.Duration) OplogIterator {
+	return &fakeIterator{source: s, after: ts}
+}
+
+type fakeIterator struct {
+	source  *fakeSource
+	after   bson.MongoTimestamp
+	next    int
+	timeout bool
+}
+
+func (i *fakeIterator) Next(result interface{}) bool {
+	i.source.mutex.Lock()
+	defer i.source.mutex.Unlock()
+
+	for i.next < len(i.source.entries) {
+		entry := i.source.entries[i.next]
+		i.next++
+
+		var parsed rawOplogEntry
+		_ = entry.Unmarshal(&parsed)
+		if parsed.Timestamp > i.after {
+			*result.(*bson.Raw) = entry
+			i.timeout = false
+			return true
+		}
+	}
+
+	// Simulate waiting for more entries
+	time.Sleep(time.Millisecond)
+	i.timeout = true
+	return false
+}
+
+func (i *fakeIterator) Err() error    { return nil }
+func (i *fakeIterator) Timeout() bool { return i.timeout }
+func (i *fakeIterator) Close() error  { return nil }
+
+type fakeSink struct {
+	ts  bson.MongoTimestamp
+	err error
+}
+
+func (s *fakeSink) LastProcessedTimestamp() (bson.MongoTimestamp, time.Time, error) {
+	return s.ts, time.Now(), s.err
+}
+
+func TestTailWithFakeSourceAndSink(t *testing.T) {
+	tests := map[string]struct {
+		sink    *fakeSink
+		wantIDs []string
+	}{
+		"No last-processed timestamp": {
+			// Starts from the end of the oplog (timestamp 1)
+			sink:    &fakeSink{err: ErrNoLastProcessed},
+			wantIDs: []string{"b", "c"},
+		},
+		"Resume from last-processed timestamp": {
+			sink:    &fakeSink{ts: bson.MongoTimestamp(2)},
+			wantIDs: []string{"c"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			source := &fakeSource{}
+			for i, id := range []string{"a", "b", "c"} {
+				source.add(t, bson.M{
+					"ts": bson.MongoTimestamp(i + 1),
+					"op": "i",
+					"ns": "foo.bar",
+					"o":  bson.M{"_id": id},
+				})
+			}
+
+			tailer, err := NewTailer(WithSource(source), WithSink(test.sink))
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			out := make(chan *redispub.Publication)
+			done := make(chan bool)
+			go func() {
+				tailer.Tail(ctx, out)
+				close(done)
+			}()
+
+			for _, id := range test.wantIDs {
+				select {
+				case pub := <-out:
+					if pub.SpecificChannel != "foo.bar::"+id {
+						t.Errorf("Got publication on %s, expected foo.bar::%s", pub.SpecificChannel, id)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("Timed out waiting for publication for %s", id)
+				}
+			}
+
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("Tail did not return after its context was cancelled")
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package oplog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+var metricSecondsBehindHead = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "seconds_behind_head",
+	Help:      "How far behind the end of the oplog the tailer is, partitioned by shard (empty unless sharded). Updated by Tailer.MonitorLag. Oplog timestamps only have a resolution of one second.",
+}, []string{"shard"})
+
+// Status describes a Tailer's progress, for health checks
+type Status struct {
+	// Whether the Tailer has an open oplog cursor
+	Tailing bool
+
+	// When the cursor last returned an entry, or timed out waiting for one.
+	// A live cursor does one or the other at least once a second, so if this
+	// is old, the Tailer is stuck.
+	LastActivity time.Time
+
+	// The timestamp of the last entry the Tailer read (or that it started
+	// tailing after, if it hasn't read any yet)
+	LastTimestamp bson.MongoTimestamp
+
+	// Whether the Tailer is paused (see Pause). A paused Tailer's
+	// LastActivity doesn't change.
+	Paused bool
+
+	// How many times in a row tailing has stopped unexpectedly, without the
+	// cursor returning an entry or timing out in between
+	Failures int
+
+	// Whether Tail gave up after more than MaxRestarts failures in a row
+	GaveUp bool
+}
+
+// The Status of a Tailer, which is updated by the tailing goroutine and read
+// by health checks
+type tailerStatus struct {
+	mutex  sync.Mutex
+	status Status
+
+	// Tracks status.Failures, once there's been a failure
+	failuresGauge prometheus.Gauge
+}
+
+func (s *tailerStatus) get() Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.status
+}
+
+func (s *tailerStatus) update(fn func(*Status)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fn(&s.status)
+}
+
+// Records that the Tailer opened a cursor to tail after ts
+func (s *tailerStatus) started(ts bson.MongoTimestamp) {
+	s.update(func(status *Status) {
+		status.Tailing = true
+		status.LastActivity = time.Now()
+		status.LastTimestamp = ts
+	})
+}
+
+// Records that the Tailer's cursor returned an entry with timestamp ts, or
+// timed out (if ts is nil)
+func (s *tailerStatus) active(ts *bson.MongoTimestamp) {
+	s.update(func(status *Status) {
+		status.LastActivity = time.Now()
+		if ts != nil {
+			status.LastTimestamp = *ts
+		}
+
+		// The cursor works, so we're past whatever made tailing fail
+		if status.Failures > 0 {
+			status.Failures = 0
+			s.failuresGauge.Set(0)
+		}
+	})
+}
+
+// Records that the Tailer closed its cursor
+func (s *tailerStatus) stopped() {
+	s.update(func(status *Status) {
+		status.Tailing = false
+	})
+}
+
+// Records that tailing stopped unexpectedly, tracking the failures in a row
+// with gauge. Returns the number of failures in a row.
+func (s *tailerStatus) failed(gauge prometheus.Gauge) int {
+	var failures int
+	s.update(func(status *Status) {
+		status.Failures++
+		failures = status.Failures
+
+		s.failuresGauge = gauge
+		gauge.Set(float64(failures))
+	})
+
+	return failures
+}
+
+// Records that Tail gave up
+func (s *tailerStatus) gaveUp() {
+	s.update(func(status *Status) {
+		status.GaveUp = true
+	})
+}
+
+// Status returns the Tailer's progress. It's safe to call while the Tailer
+// is tailing.
+func (tailer *Tailer) Status() Status {
+	status := tailer.status.get()
+	status.Paused = tailer.control.isPaused()
+	return status
+}
+
+// Lag returns how far behind the end of the oplog the Tailer is: the time
+// between the last entry in the oplog and the last entry the Tailer read.
+// Oplog timestamps have a resolution of one second, so so does Lag. It
+// queries the oplog, and is safe to call while the Tailer is tailing.
+func (tailer *Tailer) Lag() (time.Duration, error) {
+	latest, err := tailer.source().LastTimestamp()
+	if err != nil {
+		return 0, err
+	}
+
+	return timestampLag(latest, tailer.Status().LastTimestamp), nil
+}
+
+// Returns the time between timestamps latest and last, or 0 if last isn't
+// before latest
+func timestampLag(latest bson.MongoTimestamp, last bson.MongoTimestamp) time.Duration {
+	lag := time.Duration(int64(latest)>>32-int64(last)>>32) * time.Second
+	if lag < 0 {
+		return 0
+	}
+
+	return lag
+}
+
+// MonitorLag sets the otr_oplog_seconds_behind_head metric to the Tailer's
+// Lag every interval, until ctx is cancelled. Each update queries the oplog.
+// It's meant to be run in a goroutine alongside Tail.
+func (tailer *Tailer) MonitorLag(ctx context.Context, interval time.Duration) {
+	gauge := metricSecondsBehindHead.WithLabelValues(tailer.Shard)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		lag, err := tailer.Lag()
+		if err != nil {
+			log.Log.Errorw("Error getting oplog lag for metrics",
+				"shard", tailer.Shard,
+				"error", err)
+			continue
+		}
+
+		gauge.Set(lag.Seconds())
+	}
+}
@@ -0,0 +1,150 @@
+package oplog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestTimestampLag(t *testing.T) {
+	tests := map[string]struct {
+		latest   bson.MongoTimestamp
+		last     bson.MongoTimestamp
+		expected time.Duration
+	}{
+		"Caught up": {
+			latest:   bson.MongoTimestamp(1000<<32 | 5),
+			last:     bson.MongoTimestamp(1000<<32 | 5),
+			expected: 0,
+		},
+		"Same second": {
+			latest:   bson.MongoTimestamp(1000<<32 | 9),
+			last:     bson.MongoTimestamp(1000<<32 | 1),
+			expected: 0,
+		},
+		"Behind": {
+			latest:   bson.MongoTimestamp(1090<<32 | 1),
+			last:     bson.MongoTimestamp(1000<<32 | 7),
+			expected: 90 * time.Second,
+		},
+		"Ahead": {
+			latest:   bson.MongoTimestamp(1000 << 32),
+			last:     bson.MongoTimestamp(1005 << 32),
+			expected: 0,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := timestampLag(test.latest, test.last); got != test.expected {
+				t.Errorf("timestampLag() = %s, expected %s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestTailerStatus(t *testing.T) {
+	source := &fakeSource{}
+	source.add(t, bson.M{
+		"ts": bson.MongoTimestamp(2),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "a"},
+	})
+
+	tailer, err := NewTailer(WithSource(source), WithSink(&fakeSink{ts: bson.MongoTimestamp(1)}))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if tailer.Status().Tailing {
+		t.Errorf("Tailer reported tailing before Tail was called")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan *redispub.Publication)
+	done := make(chan bool)
+	go func() {
+		tailer.Tail(ctx, out)
+		close(done)
+	}()
+
+	select {
+	case <-out:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for publication")
+	}
+
+	status := tailer.Status()
+	if !status.Tailing || status.LastTimestamp != bson.MongoTimestamp(2) {
+		t.Errorf("Got status %#v, expected tailing after timestamp 2", status)
+	}
+	if time.Since(status.LastActivity) > time.Second {
+		t.Errorf("Got last activity %s, expected it to be recent", status.LastActivity)
+	}
+
+	lag, err := tailer.Lag()
+	if err != nil || lag != 0 {
+		t.Errorf("Got lag %s and error %v, expected no lag", lag, err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Tail did not return after its context was cancelled")
+	}
+
+	if tailer.Status().Tailing {
+		t.Errorf("Tailer reported tailing after Tail returned")
+	}
+}
+
+// A fakeSource whose oplog ends at the given timestamp
+type fakeHeadSource struct {
+	fakeSource
+	head bson.MongoTimestamp
+}
+
+func (s *fakeHeadSource) LastTimestamp() (bson.MongoTimestamp, error) {
+	return s.head, nil
+}
+
+func TestMonitorLag(t *testing.T) {
+	tailer := &Tailer{
+		Source: &fakeHeadSource{head: bson.MongoTimestamp(1500000012 << 32)},
+		Shard:  "monitor-lag-test",
+	}
+	tailer.status.started(bson.MongoTimestamp(1500000000 << 32))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tailer.MonitorLag(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	gauge := metricSecondsBehindHead.WithLabelValues("monitor-lag-test")
+	deadline := time.Now().Add(time.Second)
+	for {
+		var metric dto.Metric
+		if err := gauge.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+
+		if metric.GetGauge().GetValue() == 12 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %v seconds behind head, expected 12", metric.GetGauge().GetValue())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
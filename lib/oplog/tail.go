@@ -4,26 +4,247 @@
 package oplog
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
-	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/chaos"
 	"github.com/tulip/oplogtoredis/lib/log"
 	"github.com/tulip/oplogtoredis/lib/redispub"
+	"github.com/tulip/oplogtoredis/lib/tracing"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // Tailer persistently tails the oplog of a Mongo cluster, handling
 // reconnection and resumption of where it left off.
 type Tailer struct {
-	MongoClient *mgo.Session
+	MongoClient *mongo.Client
 	RedisClient redis.UniversalClient
 	RedisPrefix string
 	MaxCatchUp  time.Duration
+
+	// Which replica set members to read from, like ReadSecondary to keep
+	// load off the primary. Defaults to MongoClient's read preference. See
+	// WithReadPreference.
+	ReadPreference ReadPreference
+
+	// If non-zero, the first time we start tailing we resume from this
+	// timestamp rather than the last-processed timestamp in Redis,
+	// regardless of MaxCatchUp. This is used when another copy of
+	// oplogtoredis hands off to us and tells us exactly where it stopped,
+	// and after a Snapshot, to pick up where the snapshot started.
+	ResumeFrom bson.MongoTimestamp
+
+	// Where the first tail starts from if ResumeFrom isn't set. Defaults to
+	// StartFromCheckpoint.
+	StartFrom StartPosition
+
+	// If non-zero, Tail stops at the first entry after this timestamp,
+	// without publishing it, and returns.
+	StopAt bson.MongoTimestamp
+
+	// If true, insert and update messages include the whole document (in
+	// EJSON form) instead of just its _id, so redis-oplog consumers running
+	// with protectAgainstRaceConditions: false don't need to fetch it from
+	// Mongo. Updates that aren't replacements cost a Mongo query each.
+	FullDocument bool
+
+	// If set, FullDocument only applies to collections that the filter
+	// returns true for, so only the collections whose consumers need full
+	// documents add read load to Mongo.
+	FullDocumentFilter NamespaceFilter
+
+	// Fields to include in full documents, keyed by namespace
+	// ("<database>.<collection>"). Collections that aren't listed get the
+	// whole document. The _id is always included. Only top-level fields are
+	// supported.
+	FullDocumentProjections map[string][]string
+
+	// If true, every message includes a document version ("v"), derived from
+	// the oplog timestamp, that increases with each change to a document.
+	// Consumers can compare versions to detect stale events without reading
+	// from Mongo. See documentVersion for the format.
+	DocumentVersion bool
+
+	// If true, insert and update messages include the values the change wrote
+	// (the whole document for inserts, and the `$set` values, keyed by field
+	// path, for updates), for consumers that aren't redis-oplog and want the
+	// data without reading it from Mongo. Unlike FullDocument, this never
+	// queries Mongo, but updates don't include the rest of the document.
+	// Ignored for collections that get full documents.
+	ChangedValues bool
+
+	// Fields whose values messages are also published by, keyed by
+	// namespace ("<database>.<collection>"). For each field, a message is
+	// also published to "<database>.<collection>::<field>::<value>", so
+	// consumers can subscribe to the changes for one value (like one
+	// tenant) rather than to the whole collection. Fields may be dotted
+	// paths. Values are formatted like _ids are in specific channels.
+	// Updates that don't set a field are only published by it if
+	// RoutingLookup is set, and removes never are.
+	RoutingFields map[string][]string
+
+	// If true, routing fields that an update doesn't set are fetched from
+	// Mongo, which costs a query for each such update.
+	RoutingLookup bool
+
+	// Fields (which may be dotted paths) to leave out of messages, keyed by
+	// namespace ("<database>.<collection>"), so sensitive data like
+	// passwords never reaches Redis. They're removed from messages' field
+	// lists and documents (with FullDocument or ChangedValues), and aren't
+	// used as routing fields. Fields inside arrays of embedded documents are
+	// removed from each element. They're also removed from dead letters.
+	RedactFields map[string][]string
+
+	// Fields (which may be dotted paths) whose values are replaced by their
+	// SHA-256 hash in messages' documents and routing channels, keyed by
+	// namespace, so consumers can compare values without seeing them. They
+	// stay in messages' field lists.
+	HashFields map[string][]string
+
+	// How the changed fields in messages are reported: FieldPathsFull (the
+	// default), FieldPathsTopLevel, or FieldPathsBoth. See WithFieldPaths.
+	FieldPaths FieldPaths
+
+	// The version of the message format to publish: ProtocolVersion1 (the
+	// default) through LatestProtocolVersion. See WithProtocolVersion.
+	ProtocolVersion int
+
+	// The format of the messages we publish: PayloadFormatRedisOplog (the
+	// default), PayloadFormatCloudEvents, or PayloadFormatDebezium. See
+	// WithPayloadFormat.
+	PayloadFormat PayloadFormat
+
+	// The name of the Mongo cluster we tail, if there's more than one. It's
+	// the start of CloudEvents' source, and Debezium events' source name.
+	// See WithClusterName.
+	ClusterName string
+
+	// The largest message to publish, in bytes (0 for no limit), and what
+	// to do with larger messages: OversizeTruncate (the default),
+	// OversizeRefetch, or OversizeDrop. Huge messages (like those for
+	// replacements of big documents) can fill up Redis's output buffers.
+	// See WithMaxMessageSize.
+	MaxMessageSize int
+	OversizeAction OversizeAction
+
+	// The event names ("e") messages are published with: EventNamesMeteor
+	// (the default), EventNamesOplog, or EventNamesWords. See
+	// WithEventNames.
+	EventNames EventNames
+
+	// If true, the entries a sharded cluster writes when it moves a chunk
+	// from one shard to another (which have fromMigrate set) are published
+	// like any other. By default they're skipped, since the documents
+	// haven't changed; they've just moved. See WithPublishMigrations.
+	PublishMigrations bool
+
+	// If true, every message includes the namespace ("ns") of its document,
+	// for consumers of a global channel, which can't tell from the channel
+	// name.
+	IncludeNamespace bool
+
+	// If true, every message includes the timestamp ("ts") of its oplog
+	// entry, as "<seconds>:<increment>", and/or the wall-clock time
+	// ("wall") at which it was written. See WithIncludeTimestamp and
+	// WithIncludeWallTime.
+	IncludeTimestamp bool
+	IncludeWallTime  bool
+
+	// If set, every message includes it as the version of oplogtoredis that
+	// published it ("otrv"), so consumers can tell when copies running
+	// different versions are publishing at once. See WithPublisherVersion.
+	PublisherVersion string
+
+	// If set, entries that can't be processed (like entries with an
+	// unsupported _id or a malformed update) are published, along with the
+	// error, to this channel, instead of only being logged. See
+	// WithDeadLetterChannel.
+	DeadLetterChannel string
+
+	// If true, collection drops, renames, and database drops are published
+	// as events on the meta channel of the collection or database (see
+	// CollectionEventChannel), instead of only being logged. See
+	// WithCollectionEvents.
+	CollectionEvents bool
+
+	// If positive, when a collection is renamed, a remove is published
+	// under its old name for each of its documents, if it has at most this
+	// many, so Meteor servers stop serving them. The documents are looked up
+	// while tailing, which holds up the entries behind the rename, so larger
+	// collections get a rename collection event instead, even without
+	// CollectionEvents. Defaults to 0, which only logs renames. See
+	// WithRenameRemoves.
+	RenameRemoves int
+
+	// If set, when we find that the entries after the position we resume
+	// from have already rolled off the oplog, an event describing the gap is
+	// published to this channel. See WithDataGapChannel.
+	DataGapChannel string
+
+	// Where to restart after a data gap: StartFromOldest (the default) or
+	// StartFromNewest
+	DataGapRestart StartPosition
+
+	// If set, the name of the shard of a sharded cluster whose oplog we
+	// tail. Our publications are marked with it, so they're checkpointed and
+	// deduplicated separately from other shards' (see
+	// redispub.ShardMetadataPrefix), and the default Sink looks up the
+	// shard's last-processed timestamp.
+	Shard string
+
+	// How long to wait before restarting tailing after it stops unexpectedly
+	// (like when Mongo is down or failing over). The wait grows with each
+	// failure in a row, so a long outage doesn't become a flood of
+	// connection attempts. Defaults to backoff.Default. See
+	// WithRestartBackoff.
+	RestartBackoff backoff.Backoff
+
+	// If positive, Tail gives up and returns once tailing has failed more
+	// than this many times in a row (without reading anything from the
+	// oplog in between), and Status().GaveUp is set, so the process can exit
+	// and be restarted or alerted on. Defaults to 0, which retries forever.
+	// See WithMaxRestarts.
+	MaxRestarts int
+
+	// If set, inject oplog cursor errors. See the chaos package.
+	Chaos *chaos.Injector
+
+	// If set, trace entries through decoding, processing, and publishing.
+	// See the tracing package.
+	Tracer *tracing.Tracer
+
+	// Where to read the oplog from. Defaults to NewMongoSource(MongoClient).
+	// Replay and TailDump always read from MongoClient.
+	Source OplogSource
+
+	// Where to look up the last-processed timestamp. Defaults to
+	// NewRedisSink(RedisClient, RedisPrefix), with the prefix for Shard.
+	Sink Sink
+
+	// Set with WithNamespaceFilter and WithMetricsHook
+	namespaceFilter NamespaceFilter
+	metricsHook     MetricsHook
+
+	// Registered with OnEntry, OnPublish, OnError, and OnResume
+	hooks hooks
+
+	// Set with Pause, Resume, and Skip
+	control control
+
+	// Read with Status
+	status tailerStatus
+
+	// Set once we've reached StopAt
+	reachedStopAt bool
 }
 
 // Raw oplog entry from Mongo
@@ -35,10 +256,69 @@ type rawOplogEntry struct {
 	Namespace    string                 `bson:"ns"`
 	Doc          map[string]interface{} `bson:"o"`
 	Update       rawOplogEntryID        `bson:"o2"`
+	FromMigrate  bool                   `bson:"fromMigrate"`
+	WallTime     time.Time              `bson:"wall"`
+
+	// Only set on entries converted from change events; see
+	// NewChangeStreamSource
+	ResumeToken string `bson:"_resumeToken"`
+
+	// The operations of an applyOps entry, still raw so that their
+	// documents' _ids are decoded in order too. Set by
+	// unmarshalRawOplogEntry.
+	applyOps []bson.Raw
 }
 
+// The _id of an oplog entry's document (see decodeDocumentID)
 type rawOplogEntryID struct {
-	ID interface{} `bson:"_id"`
+	ID interface{}
+}
+
+// SetBSON implements bson.Setter
+func (id *rawOplogEntryID) SetBSON(raw bson.Raw) (err error) {
+	id.ID, err = decodeDocumentID(raw)
+	return err
+}
+
+// Unmarshals a raw oplog entry. The _id of the entry's document is decoded
+// by decodeDocumentID, and so are those of the operations of an applyOps
+// entry once they're unmarshalled in turn.
+func unmarshalRawOplogEntry(rawData bson.Raw, result *rawOplogEntry) error {
+	err := rawData.Unmarshal(result)
+	if err != nil {
+		return err
+	}
+
+	_, hasApplyOps := result.Doc["applyOps"]
+	_, compositeID := result.Doc["_id"].(map[string]interface{})
+	if !hasApplyOps && !compositeID {
+		return nil
+	}
+
+	var doc struct {
+		Doc bson.Raw `bson:"o"`
+	}
+	err = rawData.Unmarshal(&doc)
+	if err != nil {
+		return err
+	}
+
+	if compositeID {
+		result.Doc["_id"], err = decodeDocumentID(doc.Doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if hasApplyOps {
+		var ops struct {
+			ApplyOps []bson.Raw `bson:"applyOps"`
+		}
+		err = doc.Doc.Unmarshal(&ops)
+		result.applyOps = ops.ApplyOps
+	}
+
+	return err
 }
 
 const requeryDuration = time.Second
@@ -47,7 +327,7 @@ var metricOplogEntriesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
 	Namespace: "otr",
 	Subsystem: "oplog",
 	Name:      "entries_received",
-	Help:      "Oplog entries received, partitioned by database and status",
+	Help:      "Oplog entries received, partitioned by database and status (processed, ignored, filtered, skipped, or error)",
 }, []string{"database", "status"})
 
 var metricOplogEntriesReceivedSize = promauto.NewCounterVec(prometheus.CounterOpts{
@@ -57,107 +337,146 @@ var metricOplogEntriesReceivedSize = promauto.NewCounterVec(prometheus.CounterOp
 	Help:      "Size of oplog entries received in bytes, partitioned by database",
 }, []string{"database"})
 
-// Tail begins tailing the oplog. It doesn't return unless it receives a message
-// on the stop channel, in which case it wraps up its work and then returns.
-func (tailer *Tailer) Tail(out chan<- *redispub.Publication, stop <-chan bool) {
-	childStopC := make(chan bool)
-	wasStopped := false
+var metricTailRestarts = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "tail_restarts",
+	Help:      "Number of times oplog tailing stopped unexpectedly (e.g. because the cursor died or Mongo failed over) and was restarted",
+})
 
-	go func() {
-		<-stop
-		wasStopped = true
-		childStopC <- true
-	}()
+var metricTailFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "consecutive_tail_failures",
+	Help:      "How many times in a row oplog tailing has stopped unexpectedly without reading from the oplog in between, partitioned by shard (empty unless sharded). Alert on this to catch outages that retrying isn't getting past.",
+}, []string{"shard"})
+
+// Tail begins tailing the oplog. It doesn't return until ctx is cancelled, in
+// which case it wraps up its work and then returns, until it reaches StopAt,
+// or until it gives up after MaxRestarts failures in a row.
+func (tailer *Tailer) Tail(ctx context.Context, out chan<- *redispub.Publication) {
+	failuresGauge := metricTailFailures.WithLabelValues(tailer.Shard)
+	restartBackoff := tailer.RestartBackoff.OrDefault()
 
 	for {
 		log.Log.Info("Starting oplog tailing")
-		tailer.tailOnce(out, childStopC)
+		tailer.tailOnce(ctx, out)
 		log.Log.Info("Oplog tailing ended")
 
-		if wasStopped {
+		if ctx.Err() != nil || tailer.reachedStopAt {
 			return
 		}
 
-		log.Log.Errorw("Oplog tailing stopped prematurely. Waiting a second an then retrying.")
-		time.Sleep(requeryDuration)
+		failures := tailer.status.failed(failuresGauge)
+		if tailer.MaxRestarts > 0 && failures > tailer.MaxRestarts {
+			log.Log.Errorw("Oplog tailing failed too many times in a row; giving up",
+				"failures", failures,
+				"shard", tailer.Shard)
+			tailer.status.gaveUp()
+			return
+		}
+
+		delay := restartBackoff.Delay(failures)
+		log.Log.Errorw("Oplog tailing stopped prematurely; waiting and then retrying",
+			"failures", failures,
+			"delay", delay,
+			"shard", tailer.Shard)
+		metricTailRestarts.Inc()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
 	}
 }
 
-func (tailer *Tailer) tailOnce(out chan<- *redispub.Publication, stop <-chan bool) {
-	session := tailer.MongoClient.Copy()
-	oplogCollection := session.DB("local").C("oplog.rs")
+func (tailer *Tailer) tailOnce(ctx context.Context, out chan<- *redispub.Publication) {
+	source := tailer.source()
 
 	startTime := tailer.getStartTime(func() (bson.MongoTimestamp, error) {
 		// Get the timestamp of the last entry in the oplog (as a position to
 		// start from if we don't have a last-written timestamp from Redis)
-		var entry rawOplogEntry
-		mongoErr := oplogCollection.Find(bson.M{}).Sort("-$natural").One(&entry)
+		ts, mongoErr := source.LastTimestamp()
 
 		log.Log.Infow("Got latest oplog entry",
-			"entry", entry,
+			"timestamp", ts,
 			"error", mongoErr)
 
-		return entry.Timestamp, mongoErr
+		return ts, mongoErr
 	})
+	startTime, ok := tailer.checkForDataGap(ctx, out, source, startTime)
+	if !ok {
+		return
+	}
 
-	query := oplogCollection.Find(bson.M{"ts": bson.M{"$gt": startTime}})
-	iter := query.LogReplay().Sort("$natural").Tail(requeryDuration)
+	tailer.hooks.resume(startTime)
+	iter := tailer.tailFrom(source, startTime)
+	tailer.status.started(startTime)
+	defer tailer.status.stopped()
+
+	stopTailing := func() {
+		log.Log.Infof("Received stop; aborting oplog tailing")
+		_ = iter.Close()
+	}
 
-	var lastTimestamp bson.MongoTimestamp
+	lastTimestamp := startTime
 	for {
-		select {
-		case <-stop:
-			log.Log.Infof("Received stop; aborting oplog tailing")
+		if ctx.Err() != nil {
+			stopTailing()
 			return
-		default:
 		}
 
 		var rawData bson.Raw
-		var result rawOplogEntry
-
 		for iter.Next(&rawData) {
-			lastTimestamp = result.Timestamp
+			if ctx.Err() != nil {
+				stopTailing()
+				return
+			}
 
-			err := rawData.Unmarshal(&result)
-			if err != nil {
-				log.Log.Errorw("Error unmarshaling oplog entry",
-					"error", err)
+			if !tailer.control.wait(ctx) {
+				stopTailing()
+				return
+			}
 
-				continue
+			if tailer.pastStopAt(rawData) {
+				log.Log.Infow("Reached the stop timestamp; stopping oplog tailing",
+					"stopAt", int64(tailer.StopAt)>>32)
+				tailer.reachedStopAt = true
+				_ = iter.Close()
+				return
 			}
 
-			entry := tailer.parseRawOplogEntry(&result)
-			log.Log.Debugw("Received oplog entry",
-				"entry", result)
+			if chaosErr := tailer.Chaos.CursorError(); chaosErr != nil {
+				// Simulate the cursor failing before we process this entry
+				log.Log.Errorw("Error from oplog iterator",
+					"error", chaosErr)
+				tailer.hooks.error(chaosErr)
 
-			if entry == nil {
-				metricOplogEntriesReceived.WithLabelValues("(no database)", "ignored").Inc()
-				metricOplogEntriesReceivedSize.WithLabelValues("(no database)").Add(float64(len(rawData.Data)))
-				continue
+				_ = iter.Close()
+				return
 			}
 
-			metricOplogEntriesReceivedSize.WithLabelValues(entry.Database).Add(float64(len(rawData.Data)))
-
-			pub, err := processOplogEntry(entry)
-
-			if err != nil {
-				metricOplogEntriesReceived.WithLabelValues(entry.Database, "error").Inc()
-				log.Log.Errorw("Error processing oplog entry",
-					"op", entry,
-					"error", err,
-					"database", entry.Database,
-					"collection", entry.Collection)
-			} else if pub == nil {
-				metricOplogEntriesReceived.WithLabelValues(entry.Database, "ignored").Inc()
-			} else {
-				metricOplogEntriesReceived.WithLabelValues(entry.Database, "processed").Inc()
-				out <- pub
+			pubs, ts := tailer.unmarshalEntry(rawData)
+			if ts != nil {
+				lastTimestamp = *ts
+			}
+			tailer.status.active(ts)
+
+			for _, pub := range pubs {
+				select {
+				case out <- pub:
+					tailer.hooks.publish(pub)
+				case <-ctx.Done():
+					stopTailing()
+					return
+				}
 			}
 		}
 
 		if iter.Err() != nil {
 			log.Log.Errorw("Error from oplog iterator",
 				"error", iter.Err())
+			tailer.hooks.error(iter.Err())
 
 			closeErr := iter.Close()
 			if closeErr != nil {
@@ -171,13 +490,294 @@ func (tailer *Tailer) tailOnce(out chan<- *redispub.Publication, stop <-chan boo
 		if iter.Timeout() {
 			// Didn't get any messages for a while, keep trying
 			log.Log.Info("Oplog cursor timed out, will retry")
+			tailer.status.active(nil)
 			continue
 		}
 
 		// Our cursor expired. Make a new cursor to pick up from where we
 		// left off.
-		query := oplogCollection.Find(bson.M{"ts": bson.M{"$gt": lastTimestamp}})
-		iter = query.LogReplay().Sort("$natural").Tail(requeryDuration)
+		_ = iter.Close()
+		iter = tailer.tailFrom(source, lastTimestamp)
+	}
+}
+
+// Starts tailing the source after the entry with timestamp ts. Sources that
+// read a change stream resume after the recorded resume token instead, if
+// it's for that entry.
+func (tailer *Tailer) tailFrom(source OplogSource, ts bson.MongoTimestamp) OplogIterator {
+	if resumable, ok := source.(resumableSource); ok {
+		if token := tailer.resumeToken(ts); token != "" {
+			log.Log.Infow("Resuming change stream after recorded resume token",
+				"timestamp", ts)
+			return resumable.TailAfterToken(ts, token, requeryDuration)
+		}
+	}
+
+	return source.TailFrom(ts, requeryDuration)
+}
+
+// Returns the recorded resume token of the entry with timestamp ts, or the
+// empty string if the sink doesn't have one for that entry
+func (tailer *Tailer) resumeToken(ts bson.MongoTimestamp) string {
+	sink, ok := tailer.sink().(ResumeTokenSink)
+	if !ok {
+		return ""
+	}
+
+	tokenTS, token, err := sink.LastResumeToken()
+	if err != nil {
+		if err != ErrNoLastProcessed {
+			log.Log.Errorw("Error querying for last resume token. Will resume from the timestamp.",
+				"error", err)
+		}
+		return ""
+	}
+
+	if tokenTS != ts {
+		return ""
+	}
+
+	return token
+}
+
+// Returns the OplogSource to tail
+func (tailer *Tailer) source() OplogSource {
+	if tailer.Source != nil {
+		return tailer.Source
+	}
+
+	return newMongoSource(tailer.database("local"))
+}
+
+// Returns a handle on a database of MongoClient that reads per
+// ReadPreference
+func (tailer *Tailer) database(name string) *mongo.Database {
+	return tailer.MongoClient.Database(name, databaseOptions(tailer.ReadPreference))
+}
+
+// Returns the Sink to look up the last-processed timestamp in
+func (tailer *Tailer) sink() Sink {
+	if tailer.Sink != nil {
+		return tailer.Sink
+	}
+
+	return NewRedisSink(tailer.RedisClient, redispub.ShardMetadataPrefix(tailer.RedisPrefix, tailer.Shard))
+}
+
+// Process parses a single raw oplog entry and returns the publication it
+// produces, or nil if the entry shouldn't be published. It's the same code
+// path Tail uses for each entry, exposed so the parsing pipeline can be
+// driven without a Mongo server (e.g. for benchmarking). For the few entries
+// that produce more than one publication (collection renames), it returns
+// just the first.
+func (tailer *Tailer) Process(rawData bson.Raw) *redispub.Publication {
+	pubs, _ := tailer.unmarshalEntry(rawData)
+	if len(pubs) == 0 {
+		return nil
+	}
+
+	return pubs[0]
+}
+
+// Unmarshals and processes a single raw oplog entry. Returns the Publications
+// that should be sent to Redis (usually one, or none if there's nothing to
+// send), and the timestamp of the entry (or nil if it could not be
+// unmarshalled).
+func (tailer *Tailer) unmarshalEntry(rawData bson.Raw) ([]*redispub.Publication, *bson.MongoTimestamp) {
+	span := tailer.Tracer.Start("entry")
+	defer span.End()
+	span.SetAttribute("oplog.size", len(rawData.Data))
+	if tailer.Shard != "" {
+		span.SetAttribute("oplog.shard", tailer.Shard)
+	}
+
+	var result rawOplogEntry
+
+	decodeSpan := span.Child("decode")
+	err := unmarshalRawOplogEntry(rawData, &result)
+	decodeSpan.SetError(err)
+	decodeSpan.End()
+
+	if err != nil {
+		span.SetError(err)
+		log.Log.Errorw("Error unmarshaling oplog entry",
+			"error", err)
+		tailer.hooks.error(fmt.Errorf("Error unmarshaling oplog entry: %s", err))
+
+		pubs := tailer.deadLetterRaw(rawData, err)
+		if len(pubs) == 0 {
+			return nil, nil
+		}
+
+		tailer.recordEntry("(no database)", "error", len(rawData.Data))
+		traceEntry(span, pubs)
+		return pubs, &pubs[0].OplogTimestamp
+	}
+
+	if tailer.control.skipped(result.Timestamp) {
+		log.Log.Warnw("Skipping oplog entry",
+			"timestamp", FormatTimestamp(result.Timestamp),
+			"namespace", result.Namespace)
+		database, _ := parseNamespace(result.Namespace)
+		span.SetAttribute("oplog.status", "skipped")
+		tailer.recordEntry(database, "skipped", len(rawData.Data))
+		return nil, &result.Timestamp
+	}
+
+	tailer.hooks.entry(EntryInfo{
+		Timestamp: result.Timestamp,
+		Namespace: result.Namespace,
+		Operation: result.Operation,
+		Size:      len(rawData.Data),
+	})
+
+	log.Log.Debugw("Received oplog entry",
+		"entry", result)
+
+	span.SetAttribute("oplog.namespace", result.Namespace)
+	span.SetAttribute("oplog.operation", result.Operation)
+
+	processSpan := span.Child("process")
+	pubs, database, status := tailer.processEntry(&result)
+	tailer.markPublications(pubs, &result)
+	processSpan.End()
+
+	if len(pubs) > 0 {
+		pubs = tailer.hooks.filterPublications(pubs)
+		if len(pubs) == 0 && status == "processed" {
+			status = "filtered"
+		}
+	}
+	markEntryEnd(pubs)
+	tailer.recordEntry(database, status, len(rawData.Data))
+	span.SetAttribute("oplog.status", status)
+	traceEntry(span, pubs)
+
+	return pubs, &result.Timestamp
+}
+
+// Marks every publication for an entry except the last one as being followed
+// by more (see redispub.Publication.MoreInEntry), so the entry is only
+// recorded as processed once all of them are published
+func markEntryEnd(pubs []*redispub.Publication) {
+	for i, pub := range pubs {
+		pub.MoreInEntry = i < len(pubs)-1
+	}
+}
+
+// Marks the publications for an entry with the entry's span, so their
+// publishing is traced under it
+func traceEntry(span *tracing.Span, pubs []*redispub.Publication) {
+	if span == nil {
+		return
+	}
+
+	span.SetAttribute("oplog.publications", len(pubs))
+	for _, pub := range pubs {
+		pub.Trace = span
+	}
+}
+
+// Processes a single unmarshalled oplog entry. Returns the Publications that
+// should be sent to Redis (the dead letter, for entries with the error
+// status), and the database and status (processed, ignored, filtered, or
+// error) to record the entry under.
+func (tailer *Tailer) processEntry(rawEntry *rawOplogEntry) ([]*redispub.Publication, string, string) {
+	entry := tailer.parseRawOplogEntry(rawEntry)
+
+	if entry == nil && rawEntry.Operation == operationCommand {
+		database, _ := parseNamespace(rawEntry.Namespace)
+		if rawEntry.FromMigrate && !tailer.PublishMigrations {
+			// Like creating the collection on the shard a chunk moves to
+			return nil, database, "ignored"
+		}
+
+		pubs := tailer.processCommand(rawEntry)
+		if len(pubs) > 0 {
+			return pubs, database, "processed"
+		}
+
+		return nil, database, "ignored"
+	}
+
+	if entry == nil {
+		return nil, "(no database)", "ignored"
+	}
+
+	if tailer.namespaceFilter != nil && !tailer.namespaceFilter(entry.Database, entry.Collection) {
+		return nil, entry.Database, "filtered"
+	}
+
+	if tailer.FullDocument && (tailer.FullDocumentFilter == nil || tailer.FullDocumentFilter(entry.Database, entry.Collection)) {
+		tailer.addFullDocument(entry)
+	} else if tailer.ChangedValues {
+		entry.FullDocument = entry.changedValues()
+	}
+
+	tailer.addRoutes(entry)
+	tailer.redact(entry)
+
+	if tailer.DocumentVersion {
+		entry.Version = documentVersion(entry.Timestamp)
+	}
+	entry.IncludeNamespace = tailer.IncludeNamespace
+	entry.IncludeTimestamp = tailer.IncludeTimestamp
+	entry.IncludeWallTime = tailer.IncludeWallTime
+	entry.PublisherVersion = tailer.PublisherVersion
+	entry.FieldPaths = tailer.FieldPaths
+	entry.EventNames = tailer.EventNames
+	entry.ProtocolVersion = tailer.ProtocolVersion
+	entry.PayloadFormat = tailer.PayloadFormat
+	entry.Cluster = tailer.ClusterName
+	entry.Shard = tailer.Shard
+	entry.MaxMessageSize = tailer.MaxMessageSize
+	entry.OversizeAction = tailer.OversizeAction
+
+	pub, err := processOplogEntry(entry)
+
+	if err != nil {
+		log.Log.Errorw("Error processing oplog entry",
+			"op", entry,
+			"error", err,
+			"database", entry.Database,
+			"collection", entry.Collection)
+		tailer.hooks.error(fmt.Errorf("Error processing oplog entry in %s: %s", entry.Namespace, err))
+
+		return tailer.deadLetter(rawEntry, err), entry.Database, "error"
+	} else if pub == nil {
+		return nil, entry.Database, "ignored"
+	}
+
+	return []*redispub.Publication{pub}, entry.Database, "processed"
+}
+
+// Marks the publications for an entry with where they came from, for
+// checkpointing. Change events from the same transaction share a timestamp,
+// so publications from a change stream are also deduplicated by resume token.
+func (tailer *Tailer) markPublications(pubs []*redispub.Publication, rawEntry *rawOplogEntry) {
+	for _, pub := range pubs {
+		pub.ResumeToken = rawEntry.ResumeToken
+		pub.Shard = tailer.Shard
+
+		if rawEntry.ResumeToken != "" {
+			pub.DedupeSuffix = joinDedupeSuffix(rawEntry.ResumeToken, pub.DedupeSuffix)
+		}
+	}
+}
+
+// Updates the metrics for a received oplog entry, and calls the metrics hook
+// if there is one
+func (tailer *Tailer) recordEntry(database string, status string, size int) {
+	if !utf8.ValidString(database) {
+		// Prometheus panics on label values that aren't valid UTF-8
+		database = "(invalid database name)"
+	}
+
+	metricOplogEntriesReceived.WithLabelValues(database, status).Inc()
+	metricOplogEntriesReceivedSize.WithLabelValues(database).Add(float64(size))
+
+	if tailer.metricsHook != nil {
+		tailer.metricsHook(database, status, size)
 	}
 }
 
@@ -187,22 +787,39 @@ func (tailer *Tailer) tailOnce(out chan<- *redispub.Publication, stop <-chan boo
 // fallback if we don't have a latest timestamp from Redis) as an arg instead
 // of using tailer.mongoClient directly so we can unit test this function
 func (tailer *Tailer) getStartTime(getTimestampOfLastOplogEntry func() (bson.MongoTimestamp, error)) bson.MongoTimestamp {
-	ts, tsTime, redisErr := redispub.LastProcessedTimestamp(tailer.RedisClient, tailer.RedisPrefix)
-
-	if redisErr == nil {
-		// we have a last write time, check that it's not too far in the
-		// past
-		if tsTime.After(time.Now().Add(-1 * tailer.MaxCatchUp)) {
-			log.Log.Infof("Found last processed timestamp, resuming oplog tailing from %d", tsTime.Unix())
-			return ts
-		}
+	if tailer.ResumeFrom != 0 {
+		ts := tailer.ResumeFrom
+		tailer.ResumeFrom = 0
 
-		log.Log.Warnf("Found last processed timestamp, but it was too far in the past (%d). Will start from end of oplog", tsTime.Unix())
+		log.Log.Infof("Resuming oplog tailing from handoff, snapshot, or start timestamp %d", int64(ts)>>32)
+		return ts
 	}
 
-	if (redisErr != nil) && (redisErr != redis.Nil) {
-		log.Log.Errorw("Error querying Redis for last processed timestamp. Will start from end of oplog.",
-			"error", redisErr)
+	switch tailer.StartFrom {
+	case StartFromOldest:
+		tailer.StartFrom = StartFromCheckpoint
+		return tailer.oldestStartTime()
+	case StartFromNewest:
+		tailer.StartFrom = StartFromCheckpoint
+		log.Log.Info("Ignoring the last processed timestamp, and starting from the end of the oplog")
+	default:
+		ts, tsTime, sinkErr := tailer.sink().LastProcessedTimestamp()
+
+		if sinkErr == nil {
+			// we have a last write time, check that it's not too far in the
+			// past
+			if tsTime.After(time.Now().Add(-1 * tailer.MaxCatchUp)) {
+				log.Log.Infof("Found last processed timestamp, resuming oplog tailing from %d", tsTime.Unix())
+				return ts
+			}
+
+			log.Log.Warnf("Found last processed timestamp, but it was too far in the past (%d). Will start from end of oplog", tsTime.Unix())
+		}
+
+		if (sinkErr != nil) && (sinkErr != ErrNoLastProcessed) {
+			log.Log.Errorw("Error querying for last processed timestamp. Will start from end of oplog.",
+				"error", sinkErr)
+		}
 	}
 
 	mongoOplogEndTimestamp, mongoErr := getTimestampOfLastOplogEntry()
@@ -221,6 +838,7 @@ func (tailer *Tailer) parseRawOplogEntry(rawEntry *rawOplogEntry) *oplogEntry {
 	entry := oplogEntry{
 		Operation: rawEntry.Operation,
 		Timestamp: rawEntry.Timestamp,
+		WallTime:  rawEntry.WallTime,
 		Namespace: rawEntry.Namespace,
 		Data:      rawEntry.Doc,
 	}
@@ -230,6 +848,13 @@ func (tailer *Tailer) parseRawOplogEntry(rawEntry *rawOplogEntry) *oplogEntry {
 		return nil
 	}
 
+	if rawEntry.FromMigrate && !tailer.PublishMigrations {
+		// discard the inserts and removes a sharded cluster makes when it
+		// moves a chunk from one shard to another. The documents haven't
+		// changed; they've just moved.
+		return nil
+	}
+
 	entry.Database, entry.Collection = parseNamespace(rawEntry.Namespace)
 
 	if rawEntry.Operation == operationUpdate {
@@ -1,12 +1,19 @@
 package oplog
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/kylelemons/godebug/pretty"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"github.com/tulip/oplogtoredis/lib/tracing"
 
 	"github.com/alicebob/miniredis"
 	"github.com/globalsign/mgo/bson"
@@ -38,6 +45,7 @@ func TestGetStartTime(t *testing.T) {
 	tooOld := now.Add(-120 * time.Second)
 
 	tests := map[string]struct {
+		resumeFrom         bson.MongoTimestamp
 		redisTimestamp     bson.MongoTimestamp
 		mongoEndOfOplog    bson.MongoTimestamp
 		mongoEndOfOplogErr error
@@ -59,6 +67,14 @@ func TestGetStartTime(t *testing.T) {
 			mongoEndOfOplog: mongoTS(tooOld),
 			expectedResult:  mongoTS(tooOld),
 		},
+		"Resume timestamp from handoff": {
+			// The handoff timestamp takes precedence even if it's older than
+			// MaxCatchUp
+			resumeFrom:      mongoTS(tooOld),
+			redisTimestamp:  mongoTS(notTooOld),
+			mongoEndOfOplog: mongoTS(now),
+			expectedResult:  mongoTS(tooOld),
+		},
 		"Start time not in Redis, Mongo errors": {
 			mongoEndOfOplogErr: errors.New("Some mongo error"),
 			expectedResult:     mongoTS(now),
@@ -82,6 +98,7 @@ func TestGetStartTime(t *testing.T) {
 				RedisClient: redisClient,
 				RedisPrefix: "someprefix.",
 				MaxCatchUp:  maxCatchUp,
+				ResumeFrom:  test.resumeFrom,
 			}
 
 			actualResult := tailer.getStartTime(func() (bson.MongoTimestamp, error) {
@@ -103,8 +120,9 @@ func TestGetStartTime(t *testing.T) {
 
 func TestParseRawOplogEntry(t *testing.T) {
 	tests := map[string]struct {
-		in   *rawOplogEntry
-		want *oplogEntry
+		tailer *Tailer
+		in     *rawOplogEntry
+		want   *oplogEntry
 	}{
 		"Insert": {
 			in: &rawOplogEntry{
@@ -158,6 +176,45 @@ func TestParseRawOplogEntry(t *testing.T) {
 				Collection: "Bar",
 			},
 		},
+		"Chunk migration insert": {
+			in: &rawOplogEntry{
+				Timestamp:   bson.MongoTimestamp(1234),
+				Operation:   "i",
+				Namespace:   "foo.Bar",
+				Doc:         map[string]interface{}{"_id": "someid", "foo": "bar"},
+				FromMigrate: true,
+			},
+			want: nil,
+		},
+		"Chunk migration remove": {
+			in: &rawOplogEntry{
+				Timestamp:   bson.MongoTimestamp(1234),
+				Operation:   "d",
+				Namespace:   "foo.Bar",
+				Doc:         map[string]interface{}{"_id": "someid"},
+				FromMigrate: true,
+			},
+			want: nil,
+		},
+		"Chunk migration insert, publishing migrations": {
+			tailer: &Tailer{PublishMigrations: true},
+			in: &rawOplogEntry{
+				Timestamp:   bson.MongoTimestamp(1234),
+				Operation:   "i",
+				Namespace:   "foo.Bar",
+				Doc:         map[string]interface{}{"_id": "someid", "foo": "bar"},
+				FromMigrate: true,
+			},
+			want: &oplogEntry{
+				Timestamp:  bson.MongoTimestamp(1234),
+				Operation:  "i",
+				Namespace:  "foo.Bar",
+				Data:       map[string]interface{}{"_id": "someid", "foo": "bar"},
+				DocID:      interface{}("someid"),
+				Database:   "foo",
+				Collection: "Bar",
+			},
+		},
 		"Command": {
 			in: &rawOplogEntry{
 				Timestamp: bson.MongoTimestamp(1234),
@@ -171,7 +228,12 @@ func TestParseRawOplogEntry(t *testing.T) {
 
 	for testName, test := range tests {
 		t.Run(testName, func(t *testing.T) {
-			got := (&Tailer{}).parseRawOplogEntry(test.in)
+			tailer := test.tailer
+			if tailer == nil {
+				tailer = &Tailer{}
+			}
+
+			got := tailer.parseRawOplogEntry(test.in)
 
 			if diff := pretty.Compare(got, test.want); diff != "" {
 				t.Errorf("Got incorrect result (-got +want)\n%s", diff)
@@ -219,3 +281,358 @@ func TestParseNamespace(t *testing.T) {
 		})
 	}
 }
+
+func TestProcess(t *testing.T) {
+	tailer := &Tailer{}
+
+	insert, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "someid", "some": "field"},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	pub := tailer.Process(bson.Raw{Kind: 3, Data: insert})
+	if pub == nil {
+		t.Fatalf("Expected a publication for an insert, got nil")
+	}
+	if pub.OplogTimestamp != bson.MongoTimestamp(1234) {
+		t.Errorf("Got timestamp %d, expected 1234", pub.OplogTimestamp)
+	}
+
+	command, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1235),
+		"op": "c",
+		"ns": "foo.$cmd",
+		"o":  bson.M{"drop": "bar"},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	if pub := tailer.Process(bson.Raw{Kind: 3, Data: command}); pub != nil {
+		t.Errorf("Expected no publication for a command, got %#v", pub)
+	}
+}
+
+func TestProcessTracing(t *testing.T) {
+	insert, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "someid", "some": "field"},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	// Accepts the spans and ignores them
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tracer := tracing.New(tracing.Opts{Endpoint: server.URL})
+	defer tracer.Close()
+
+	pub := (&Tailer{Tracer: tracer}).Process(bson.Raw{Kind: 3, Data: insert})
+	if pub == nil || pub.Trace == nil {
+		t.Fatalf("Expected a traced publication, got %#v", pub)
+	}
+
+	pub = (&Tailer{}).Process(bson.Raw{Kind: 3, Data: insert})
+	if pub == nil || pub.Trace != nil {
+		t.Errorf("Expected an untraced publication without a tracer, got %#v", pub)
+	}
+}
+
+func TestProcessDocumentVersion(t *testing.T) {
+	update, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "u",
+		"ns": "foo.bar",
+		"o":  bson.M{"$set": bson.M{"some": "field"}},
+		"o2": bson.M{"_id": "someid"},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	pub := (&Tailer{DocumentVersion: true}).Process(bson.Raw{Kind: 3, Data: update})
+	if pub == nil {
+		t.Fatalf("Expected a publication for an update, got nil")
+	}
+	if string(pub.Msg) != `{"e":"u","d":{"_id":"someid"},"f":["some"],"v":"0000000000000001234"}` {
+		t.Errorf("Got message %s", pub.Msg)
+	}
+
+	pub = (&Tailer{}).Process(bson.Raw{Kind: 3, Data: update})
+	if string(pub.Msg) != `{"e":"u","d":{"_id":"someid"},"f":["some"]}` {
+		t.Errorf("Got message %s without DocumentVersion", pub.Msg)
+	}
+}
+
+func TestProcessFullDocumentFilter(t *testing.T) {
+	update, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "u",
+		"ns": "foo.bar",
+		"o":  bson.M{"$set": bson.M{"some": "field"}},
+		"o2": bson.M{"_id": "someid"},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	// foo.bar doesn't get full documents, so it falls back to changed values
+	// rather than trying to fetch the document (which would fail without a
+	// Mongo client)
+	tailer := &Tailer{
+		FullDocument:       true,
+		FullDocumentFilter: func(database string, collection string) bool { return collection == "other" },
+		ChangedValues:      true,
+	}
+
+	pub := tailer.Process(bson.Raw{Kind: 3, Data: update})
+	if pub == nil {
+		t.Fatalf("Expected a publication for an update, got nil")
+	}
+	if string(pub.Msg) != `{"e":"u","d":{"_id":"someid","some":"field"},"f":["some"]}` {
+		t.Errorf("Got message %s", pub.Msg)
+	}
+}
+
+func TestProcessRoutingFields(t *testing.T) {
+	insert, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "someid", "tenantId": "acme", "orgId": 42, "flag": true},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	tailer := &Tailer{
+		RoutingFields: map[string][]string{"foo.bar": {"tenantId", "orgId", "flag"}},
+	}
+
+	pub := tailer.Process(bson.Raw{Kind: 3, Data: insert})
+	if pub == nil {
+		t.Fatalf("Expected a publication for an insert, got nil")
+	}
+
+	// Booleans can't be formatted for channels, so flag is skipped
+	want := []string{"foo.bar::orgId::int:42", "foo.bar::tenantId::acme"}
+	if !reflect.DeepEqual(pub.ExtraChannels, want) {
+		t.Errorf("Got extra channels %#v, expected %#v", pub.ExtraChannels, want)
+	}
+}
+
+// A fakeSource that records how it was asked to tail
+type fakeResumableSource struct {
+	fakeSource
+	token string
+}
+
+func (s *fakeResumableSource) TailAfterToken(ts bson.MongoTimestamp, token string, timeout time.Duration) OplogIterator {
+	s.token = token
+	return s.TailFrom(ts, timeout)
+}
+
+func TestTailFromResumeToken(t *testing.T) {
+	tests := map[string]struct {
+		storedToken string
+		ts          bson.MongoTimestamp
+		wantToken   string
+	}{
+		"No stored token": {
+			ts:        bson.MongoTimestamp(1234),
+			wantToken: "",
+		},
+		"Token for the entry": {
+			storedToken: "1234:826a3e",
+			ts:          bson.MongoTimestamp(1234),
+			wantToken:   "826a3e",
+		},
+		"Token for another entry": {
+			storedToken: "1200:826a3e",
+			ts:          bson.MongoTimestamp(1234),
+			wantToken:   "",
+		},
+		"Malformed token": {
+			storedToken: "826a3e",
+			ts:          bson.MongoTimestamp(1234),
+			wantToken:   "",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			redisServer, err := miniredis.Run()
+			if err != nil {
+				t.Fatalf("Error starting miniredis: %s", err)
+			}
+			defer redisServer.Close()
+
+			if test.storedToken != "" {
+				redisServer.Set("someprefix.lastResumeToken", test.storedToken)
+			}
+
+			tailer := &Tailer{
+				RedisClient: redis.NewUniversalClient(&redis.UniversalOptions{
+					Addrs: []string{redisServer.Addr()},
+				}),
+				RedisPrefix: "someprefix.",
+			}
+
+			source := &fakeResumableSource{}
+			_ = tailer.tailFrom(source, test.ts)
+			if source.token != test.wantToken {
+				t.Errorf("Resumed after token %q, expected %q", source.token, test.wantToken)
+			}
+		})
+	}
+}
+
+func TestShard(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Error starting miniredis: %s", err)
+	}
+	defer redisServer.Close()
+
+	nowTS := mongoTS(time.Now())
+	redisServer.Set("someprefix.lastProcessedEntry", "1234")
+	redisServer.Set("someprefix.shard::shard0::lastProcessedEntry", strconv.FormatInt(int64(nowTS), 10))
+
+	tailer := &Tailer{
+		RedisClient: redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs: []string{redisServer.Addr()},
+		}),
+		RedisPrefix: "someprefix.",
+		MaxCatchUp:  time.Minute,
+		Shard:       "shard0",
+	}
+
+	// The shard's own last-processed timestamp is used
+	startTime := tailer.getStartTime(func() (bson.MongoTimestamp, error) {
+		return 0, errors.New("Should not be called")
+	})
+	if startTime != nowTS {
+		t.Errorf("Got start time %d, expected the shard's last-processed timestamp %d", startTime, nowTS)
+	}
+
+	insert, err := bson.Marshal(bson.M{
+		"ts": nowTS,
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "someid"},
+	})
+	if err != nil {
+		t.Fatalf("Could not marshal test entry: %s", err)
+	}
+
+	pub := tailer.Process(bson.Raw{Kind: 3, Data: insert})
+	if pub == nil || pub.Shard != "shard0" {
+		t.Errorf("Expected a publication marked with the shard, got %#v", pub)
+	}
+}
+
+// A fakeSource whose first failures cursors fail right away
+type failingSource struct {
+	fakeSource
+	failures int
+}
+
+func (s *failingSource) TailFrom(ts bson.MongoTimestamp, timeout time.Duration) OplogIterator {
+	if s.failures > 0 {
+		s.failures--
+		return &failingIterator{}
+	}
+
+	return s.fakeSource.TailFrom(ts, timeout)
+}
+
+type failingIterator struct{}
+
+func (i *failingIterator) Next(result interface{}) bool { return false }
+func (i *failingIterator) Err() error                   { return errors.New("Some error") }
+func (i *failingIterator) Timeout() bool                { return false }
+func (i *failingIterator) Close() error                 { return nil }
+
+func TestTailRestarts(t *testing.T) {
+	tests := map[string]struct {
+		failures     int
+		maxRestarts  int
+		wantGaveUp   bool
+		wantFailures int
+	}{
+		"Gives up after too many failures": {
+			failures:     10,
+			maxRestarts:  2,
+			wantGaveUp:   true,
+			wantFailures: 3,
+		},
+		"Recovers within max restarts": {
+			failures:     2,
+			maxRestarts:  2,
+			wantFailures: 0,
+		},
+		"Retries forever by default": {
+			failures:     10,
+			wantFailures: 0,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tailer, err := NewTailer(
+				WithSource(&failingSource{failures: test.failures}),
+				WithSink(&fakeSink{ts: bson.MongoTimestamp(1)}),
+				WithRestartBackoff(backoff.Backoff{Initial: time.Millisecond, Max: time.Millisecond}),
+				WithMaxRestarts(test.maxRestarts),
+			)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan bool)
+			go func() {
+				tailer.Tail(ctx, make(chan *redispub.Publication))
+				close(done)
+			}()
+
+			if test.wantGaveUp {
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatalf("Tail did not give up")
+				}
+			} else {
+				// Wait for a cursor that works
+				deadline := time.Now().Add(time.Second)
+				for status := tailer.Status(); !status.Tailing || status.Failures > 0; status = tailer.Status() {
+					if time.Now().After(deadline) {
+						t.Fatalf("Tail did not recover; status is %#v", status)
+					}
+					time.Sleep(time.Millisecond)
+				}
+
+				cancel()
+				<-done
+			}
+
+			status := tailer.Status()
+			if status.GaveUp != test.wantGaveUp {
+				t.Errorf("Got GaveUp %t, expected %t", status.GaveUp, test.wantGaveUp)
+			}
+			if status.Failures != test.wantFailures {
+				t.Errorf("Got %d failures, expected %d", status.Failures, test.wantFailures)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package oplog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ParseTimestamp parses a user-supplied oplog position into a
+// bson.MongoTimestamp. It accepts:
+//
+// - An RFC 3339 time, like "2018-05-18T13:01:51Z"
+//
+// - A number of seconds since the Unix epoch, like "1526648511"
+//
+// - A Mongo timestamp in the "<seconds>:<increment>" form that the mongo shell
+// prints as Timestamp(<seconds>, <increment>), like "1526648511:3"
+//
+// Times without an increment are converted to the first possible timestamp in
+// that second.
+func ParseTimestamp(s string) (bson.MongoTimestamp, error) {
+	s = strings.TrimSpace(s)
+
+	if parts := strings.SplitN(s, ":", 2); len(parts) == 2 && !strings.Contains(s, "T") {
+		seconds, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid seconds in timestamp %q: %s", s, err)
+		}
+
+		increment, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid increment in timestamp %q: %s", s, err)
+		}
+
+		return bson.MongoTimestamp(int64(seconds<<32 | increment)), nil
+	}
+
+	if seconds, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return bson.MongoTimestamp(int64(seconds << 32)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse %q as an RFC 3339 time, Unix time, or <seconds>:<increment> Mongo timestamp", s)
+	}
+
+	return bson.MongoTimestamp(t.Unix() << 32), nil
+}
+
+// FormatTimestamp formats a bson.MongoTimestamp in the "<seconds>:<increment>"
+// form accepted by ParseTimestamp.
+func FormatTimestamp(ts bson.MongoTimestamp) string {
+	return fmt.Sprintf("%d:%d", int64(ts)>>32, int64(ts)&0xFFFFFFFF)
+}
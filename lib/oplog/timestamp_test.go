@@ -0,0 +1,78 @@
+package oplog
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := map[string]struct {
+		in        string
+		want      bson.MongoTimestamp
+		wantError bool
+	}{
+		"RFC 3339": {
+			in:   "2018-05-18T13:01:51Z",
+			want: bson.MongoTimestamp(1526648511 << 32),
+		},
+		"RFC 3339 with offset": {
+			in:   "2018-05-18T09:01:51-04:00",
+			want: bson.MongoTimestamp(1526648511 << 32),
+		},
+		"Unix seconds": {
+			in:   "1526648511",
+			want: bson.MongoTimestamp(1526648511 << 32),
+		},
+		"Seconds and increment": {
+			in:   "1526648511:3",
+			want: bson.MongoTimestamp(1526648511<<32 + 3),
+		},
+		"Invalid increment": {
+			in:        "1526648511:x",
+			wantError: true,
+		},
+		"Garbage": {
+			in:        "yesterday",
+			wantError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got, err := ParseTimestamp(test.in)
+
+			if test.wantError {
+				if err == nil {
+					t.Errorf("ParseTimestamp(%s) did not error", test.in)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("ParseTimestamp(%s) gave unexpected error: %s", test.in, err)
+			}
+
+			if got != test.want {
+				t.Errorf("ParseTimestamp(%s) = %d, wanted %d", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	ts := bson.MongoTimestamp(1526648511<<32 | 3)
+
+	formatted := FormatTimestamp(ts)
+	if formatted != "1526648511:3" {
+		t.Errorf("FormatTimestamp(%d) = %q, expected \"1526648511:3\"", ts, formatted)
+	}
+
+	parsed, err := ParseTimestamp(formatted)
+	if err != nil {
+		t.Fatalf("Got unexpected error parsing formatted timestamp: %s", err)
+	}
+	if parsed != ts {
+		t.Errorf("Formatted timestamp did not round-trip: got %d, expected %d", parsed, ts)
+	}
+}
@@ -0,0 +1,89 @@
+package oplog
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+var metricOplogWindow = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "oplog",
+	Name:      "window_seconds",
+	Help:      "How much time the oplog covers (between its oldest and newest entries), partitioned by shard (empty unless sharded). If oplogtoredis is down for longer than this, changes are lost. Updated by Tailer.MonitorWindow.",
+}, []string{"shard"})
+
+// Window returns how much time the oplog covers: the time between its
+// oldest and newest entries. If the Tailer is stopped for longer than this,
+// entries roll off the oplog before it can publish them. It queries the
+// oplog, and is safe to call while the Tailer is tailing.
+func (tailer *Tailer) Window() (time.Duration, error) {
+	source, ok := tailer.source().(oldestTimestampSource)
+	if !ok {
+		return 0, errors.New("The oplog source can't report its oldest entry")
+	}
+
+	oldest, err := source.OldestTimestamp()
+	if err != nil {
+		return 0, err
+	}
+
+	newest, err := source.LastTimestamp()
+	if err != nil {
+		return 0, err
+	}
+
+	return timestampLag(newest, oldest), nil
+}
+
+// MonitorWindow sets the otr_oplog_window_seconds metric to the Tailer's
+// Window every interval, until ctx is cancelled, and logs a warning when the
+// window shrinks below MaxCatchUp or minWindow (whichever is longer). Each
+// update queries the oplog. It's meant to be run in a goroutine alongside
+// Tail; it returns immediately if the Tailer's source can't report its oldest
+// entry.
+func (tailer *Tailer) MonitorWindow(ctx context.Context, interval time.Duration, minWindow time.Duration) {
+	if _, ok := tailer.source().(oldestTimestampSource); !ok {
+		return
+	}
+
+	gauge := metricOplogWindow.WithLabelValues(tailer.Shard)
+	if tailer.MaxCatchUp > minWindow {
+		minWindow = tailer.MaxCatchUp
+	}
+
+	tooSmall := false
+	for {
+		window, err := tailer.Window()
+		if err != nil {
+			log.Log.Errorw("Error getting oplog window for metrics",
+				"shard", tailer.Shard,
+				"error", err)
+		} else {
+			gauge.Set(window.Seconds())
+
+			if window < minWindow && !tooSmall {
+				log.Log.Warnw("The oplog covers less time than oplogtoredis needs to catch up after an outage. If oplogtoredis is down for longer than this, changes will be lost. Increase the oplog size.",
+					"shard", tailer.Shard,
+					"window", window.String(),
+					"minWindow", minWindow.String())
+			} else if window >= minWindow && tooSmall {
+				log.Log.Infow("The oplog window has grown back above the minimum",
+					"shard", tailer.Shard,
+					"window", window.String(),
+					"minWindow", minWindow.String())
+			}
+			tooSmall = window < minWindow
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package oplog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// A fakeSource that knows its oldest and newest entries
+type fakeWindowSource struct {
+	fakeOldestSource
+	head bson.MongoTimestamp
+}
+
+func (s *fakeWindowSource) LastTimestamp() (bson.MongoTimestamp, error) {
+	return s.head, nil
+}
+
+func TestWindow(t *testing.T) {
+	tailer := &Tailer{
+		Source: &fakeWindowSource{
+			fakeOldestSource: fakeOldestSource{oldest: bson.MongoTimestamp(1500000000<<32 | 5)},
+			head:             bson.MongoTimestamp(1500003600<<32 | 1),
+		},
+	}
+
+	window, err := tailer.Window()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if window != time.Hour {
+		t.Errorf("Got window %s, expected 1h", window)
+	}
+
+	tailer = &Tailer{Source: &fakeSource{}}
+	if _, err := tailer.Window(); err == nil {
+		t.Error("Expected an error for a source that can't report its oldest entry")
+	}
+}
+
+func TestMonitorWindow(t *testing.T) {
+	tailer := &Tailer{
+		Source: &fakeWindowSource{
+			fakeOldestSource: fakeOldestSource{oldest: bson.MongoTimestamp(1500000000 << 32)},
+			head:             bson.MongoTimestamp(1500000030 << 32),
+		},
+		Shard:      "monitor-window-test",
+		MaxCatchUp: time.Minute,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tailer.MonitorWindow(ctx, time.Millisecond, time.Hour)
+		close(done)
+	}()
+
+	gauge := metricOplogWindow.WithLabelValues("monitor-window-test")
+	deadline := time.Now().Add(time.Second)
+	for {
+		var metric dto.Metric
+		if err := gauge.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+
+		if metric.GetGauge().GetValue() == 30 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Got window of %v seconds, expected 30", metric.GetGauge().GetValue())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestMonitorWindowUnsupported(t *testing.T) {
+	tailer := &Tailer{Source: &fakeSource{}}
+
+	done := make(chan struct{})
+	go func() {
+		tailer.MonitorWindow(context.Background(), time.Millisecond, 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("MonitorWindow did not return for a source that can't report its oldest entry")
+	}
+}
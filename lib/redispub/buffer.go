@@ -0,0 +1,382 @@
+package redispub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// OverflowPolicy says what BufferPublications does with a publication that
+// arrives when its buffer is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits for room in the buffer, which holds up tailing
+	// the oplog until the publisher catches up
+	OverflowBlock OverflowPolicy = "block"
+
+	// OverflowDropOldest drops the oldest publication in the buffer to make
+	// room. Dropped publications are never published, and are counted in
+	// the otr_redispub_buffer_dropped metric.
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+
+	// OverflowSpill appends publications to a file on disk until there's
+	// room in the buffer again, so memory use stays bounded without losing
+	// or reordering anything
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// BufferOpts configures BufferPublications
+type BufferOpts struct {
+	// The maximum number of publications to hold in memory
+	Size int
+
+	// What to do when the buffer is full. Defaults to OverflowBlock.
+	Overflow OverflowPolicy
+
+	// The directory to create the spill file in, for OverflowSpill. Defaults
+	// to the system's temporary directory.
+	SpillDir string
+
+	// If set, updated with how many publications the buffer holds
+	Stats *BufferStats
+}
+
+// BufferStats reports how many publications BufferPublications holds, in
+// memory or spilled to disk. It's safe to read while the buffer is in use.
+type BufferStats struct {
+	depth int64
+}
+
+// Depth returns how many publications the buffer holds
+func (s *BufferStats) Depth() int {
+	if s == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt64(&s.depth))
+}
+
+func (s *BufferStats) set(depth int) {
+	if s != nil {
+		atomic.StoreInt64(&s.depth, int64(depth))
+	}
+}
+
+var metricBufferDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "buffer_dropped",
+	Help:      "Publications dropped because the buffer between the oplog tailer and the publisher was full (with OTR_BUFFER_OVERFLOW=drop-oldest)",
+})
+
+var metricBufferSpilled = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "buffer_spilled",
+	Help:      "Publications currently spilled to disk because the buffer between the oplog tailer and the publisher was full (with OTR_BUFFER_OVERFLOW=spill)",
+})
+
+// BufferPublications reads publications from in, holds up to opts.Size of
+// them in memory, and writes them to out, in order, handling a full buffer
+// according to opts.Overflow. It's meant to sit between a Tailer and a
+// publisher, so a slow Redis doesn't hold up tailing.
+//
+// Once in is closed, it writes every buffered publication to out, closes out,
+// and returns nil. If ctx is cancelled first, it closes out and returns
+// ctx.Err() without writing the rest. It returns an error if a spill file
+// can't be written or read.
+// nolint: gocyclo
+func BufferPublications(ctx context.Context, in <-chan *Publication, out chan<- *Publication, opts *BufferOpts) error {
+	defer close(out)
+
+	var spill *spillFile
+	if opts.Overflow == OverflowSpill {
+		var err error
+		spill, err = newSpillFile(opts.SpillDir)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = spill.close() }()
+	}
+
+	var queue []*Publication
+	inClosed := false
+
+	for {
+		spilled := spill != nil && spill.count > 0
+		if spilled {
+			opts.Stats.set(len(queue) + spill.count)
+		} else {
+			opts.Stats.set(len(queue))
+		}
+
+		if inClosed && len(queue) == 0 && !spilled {
+			return nil
+		}
+
+		var recvC <-chan *Publication
+		if !inClosed && (len(queue) < opts.Size || opts.Overflow == OverflowDropOldest || opts.Overflow == OverflowSpill) {
+			recvC = in
+		}
+
+		var sendC chan<- *Publication
+		var next *Publication
+		if len(queue) > 0 {
+			sendC = out
+			next = queue[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case p, ok := <-recvC:
+			if !ok {
+				inClosed = true
+				continue
+			}
+
+			switch {
+			case spilled:
+				// Publications that are already spilled come first
+				if err := spill.write(p); err != nil {
+					return err
+				}
+			case len(queue) < opts.Size:
+				queue = append(queue, p)
+			case opts.Overflow == OverflowDropOldest:
+				log.Log.Debugw("Buffer is full; dropping oldest publication",
+					"timestamp", queue[0].OplogTimestamp)
+				metricBufferDropped.Inc()
+				queue = append(queue[1:], p)
+			default:
+				if err := spill.write(p); err != nil {
+					return err
+				}
+			}
+
+		case sendC <- next:
+			queue[0] = nil
+			queue = queue[1:]
+
+			if spilled {
+				p, err := spill.read()
+				if err == nil && spill.count == 0 {
+					err = spill.reset()
+				}
+				if err != nil {
+					return err
+				}
+				queue = append(queue, p)
+			}
+		}
+	}
+}
+
+// A file that publications are appended to, and read back from in the same
+// order. It's used both for spilling the buffer (see OverflowSpill), in a
+// temporary file, and for the write-ahead log (see WALSink), which is kept
+// between runs.
+type spillFile struct {
+	file   *os.File
+	writer *bufio.Writer
+
+	readFile *os.File
+	reader   *bufio.Reader
+
+	// The number of publications written but not yet read
+	count int
+
+	// Whether to sync every write to disk
+	sync bool
+
+	// Whether to delete the file when it's closed
+	temporary bool
+
+	// Tracks count
+	gauge prometheus.Gauge
+}
+
+// Creates a temporary spill file in dir (or the system's temporary directory,
+// if it's empty), which is deleted when it's closed
+func newSpillFile(dir string) (*spillFile, error) {
+	file, err := ioutil.TempFile(dir, "oplogtoredis-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating spill file: %s", err)
+	}
+
+	spill, err := wrapSpillFile(file, 0)
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+
+	spill.temporary = true
+	spill.gauge = metricBufferSpilled
+	return spill, nil
+}
+
+// Opens the spill file at path, creating it if it doesn't exist. Publications
+// already in the file are read back first. If the last one was only
+// partially written (because we crashed while writing it), it's discarded.
+func openSpillFile(path string) (*spillFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening %s: %s", path, err)
+	}
+
+	count := 0
+	var length int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("Error reading %s: %s", path, err)
+		}
+
+		count++
+		length += int64(len(line))
+	}
+
+	err = file.Truncate(length)
+	if err == nil {
+		_, err = file.Seek(length, 0)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("Error opening %s: %s", path, err)
+	}
+
+	return wrapSpillFile(file, count)
+}
+
+// Creates a spillFile that appends to file at its current offset, and reads
+// back from its start
+func wrapSpillFile(file *os.File, count int) (*spillFile, error) {
+	readFile, err := os.Open(file.Name())
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("Error opening spill file: %s", err)
+	}
+
+	return &spillFile{
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		readFile: readFile,
+		reader:   bufio.NewReader(readFile),
+		count:    count,
+	}, nil
+}
+
+func (s *spillFile) write(p *Publication) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Error encoding publication for spill file: %s", err)
+	}
+
+	_, err = s.writer.Write(append(data, '\n'))
+	if err == nil && s.sync {
+		err = s.writer.Flush()
+		if err == nil {
+			err = s.file.Sync()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing to spill file: %s", err)
+	}
+
+	s.count++
+	s.updateGauge()
+	return nil
+}
+
+// Reads back the next publication. Once everything's been read, the caller
+// should call reset, after it's done with the publication.
+func (s *spillFile) read() (*Publication, error) {
+	err := s.writer.Flush()
+	if err != nil {
+		return nil, fmt.Errorf("Error writing to spill file: %s", err)
+	}
+
+	line, err := s.reader.ReadBytes('\n')
+	if err != nil {
+		// There's no telling where the next publication starts, so the rest
+		// of the file is lost
+		s.count = 0
+		s.updateGauge()
+		return nil, fmt.Errorf("Error reading from spill file: %s", err)
+	}
+
+	s.count--
+	s.updateGauge()
+
+	var p Publication
+	err = json.Unmarshal(line, &p)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding publication from spill file: %s", err)
+	}
+
+	return &p, nil
+}
+
+// Empties the file, once everything's been read back
+func (s *spillFile) reset() error {
+	err := s.writer.Flush()
+	if err == nil {
+		err = s.file.Truncate(0)
+	}
+	if err == nil {
+		_, err = s.file.Seek(0, 0)
+	}
+	if err == nil && s.sync {
+		err = s.file.Sync()
+	}
+	if err == nil {
+		_, err = s.readFile.Seek(0, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("Error resetting spill file: %s", err)
+	}
+
+	s.count = 0
+	s.updateGauge()
+	s.reader.Reset(s.readFile)
+	return nil
+}
+
+func (s *spillFile) updateGauge() {
+	if s.gauge != nil {
+		s.gauge.Set(float64(s.count))
+	}
+}
+
+func (s *spillFile) close() error {
+	err := s.writer.Flush()
+	_ = s.file.Close()
+	_ = s.readFile.Close()
+
+	if s.temporary {
+		_ = os.Remove(s.file.Name())
+		s.count = 0
+		s.updateGauge()
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error writing to spill file: %s", err)
+	}
+
+	return nil
+}
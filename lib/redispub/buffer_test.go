@@ -0,0 +1,238 @@
+package redispub
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Fills a closed channel with publications with timestamps 1 to n
+func bufferTestInput(n int) chan *Publication {
+	in := make(chan *Publication, n)
+	for i := 1; i <= n; i++ {
+		in <- &Publication{
+			SpecificChannel: "db.coll::doc",
+			Msg:             []byte(`{"e":"u"}`),
+			OplogTimestamp:  bson.MongoTimestamp(i),
+		}
+	}
+	close(in)
+
+	return in
+}
+
+// Runs BufferPublications with nothing reading its output until it's read
+// all of its input, and then returns the timestamps of everything it writes
+func runBufferPublications(t *testing.T, n int, opts *BufferOpts) []bson.MongoTimestamp {
+	in := bufferTestInput(n)
+	out := make(chan *Publication)
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- BufferPublications(context.Background(), in, out, opts)
+	}()
+
+	for len(in) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var got []bson.MongoTimestamp
+	for p := range out {
+		got = append(got, p.OplogTimestamp)
+	}
+
+	if err := <-errC; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	return got
+}
+
+func timestampRange(from int, to int) []bson.MongoTimestamp {
+	var result []bson.MongoTimestamp
+	for i := from; i <= to; i++ {
+		result = append(result, bson.MongoTimestamp(i))
+	}
+
+	return result
+}
+
+func TestBufferPublicationsDropOldest(t *testing.T) {
+	got := runBufferPublications(t, 10, &BufferOpts{Size: 3, Overflow: OverflowDropOldest})
+
+	want := timestampRange(8, 10)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got publications %v, expected %v", got, want)
+	}
+}
+
+func TestBufferPublicationsSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplogtoredis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	got := runBufferPublications(t, 100, &BufferOpts{Size: 3, Overflow: OverflowSpill, SpillDir: dir})
+
+	want := timestampRange(1, 100)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got publications %v, expected %v", got, want)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected the spill file to be removed, but found %d files", len(files))
+	}
+}
+
+func TestBufferStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplogtoredis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := bufferTestInput(10)
+	out := make(chan *Publication)
+	stats := &BufferStats{}
+
+	go func() {
+		_ = BufferPublications(context.Background(), in, out, &BufferOpts{Size: 3, Overflow: OverflowSpill, SpillDir: dir, Stats: stats})
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for stats.Depth() != 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Got depth %d, expected 10 (3 in memory and 7 spilled)", stats.Depth())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for range out {
+	}
+
+	if stats.Depth() != 0 {
+		t.Errorf("Got depth %d after the buffer was drained, expected 0", stats.Depth())
+	}
+
+	var nilStats *BufferStats
+	if nilStats.Depth() != 0 {
+		t.Errorf("Expected nil stats to have a depth of 0")
+	}
+}
+
+func TestBufferPublicationsBlock(t *testing.T) {
+	in := bufferTestInput(10)
+	out := make(chan *Publication)
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- BufferPublications(context.Background(), in, out, &BufferOpts{Size: 3, Overflow: OverflowBlock})
+	}()
+
+	// Once the buffer is full, it stops reading its input
+	time.Sleep(20 * time.Millisecond)
+	if len(in) != 7 {
+		t.Errorf("Expected 7 publications to be left in the input, got %d", len(in))
+	}
+
+	var got []bson.MongoTimestamp
+	for p := range out {
+		got = append(got, p.OplogTimestamp)
+	}
+
+	want := timestampRange(1, 10)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got publications %v, expected %v", got, want)
+	}
+
+	if err := <-errC; err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestBufferPublicationsCancel(t *testing.T) {
+	in := make(chan *Publication)
+	out := make(chan *Publication)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := BufferPublications(ctx, in, out, &BufferOpts{Size: 3, Overflow: OverflowDropOldest})
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, ok := <-out; ok {
+		t.Error("Expected the output channel to be closed")
+	}
+}
+
+func TestBufferPublicationsSpillError(t *testing.T) {
+	in := make(chan *Publication)
+	out := make(chan *Publication)
+
+	err := BufferPublications(context.Background(), in, out, &BufferOpts{
+		Size:     3,
+		Overflow: OverflowSpill,
+		SpillDir: "/nonexistent/directory",
+	})
+	if err == nil {
+		t.Error("Expected an error creating the spill file")
+	}
+}
+
+func TestSpillFile(t *testing.T) {
+	spill, err := newSpillFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spill.close()
+
+	want := &Publication{
+		CollectionChannel: "db.coll",
+		SpecificChannel:   "db.coll::doc",
+		ExtraChannels:     []string{"extra"},
+		Msg:               []byte(`{"e":"i","d":{"_id":"doc"}}`),
+		OplogTimestamp:    bson.MongoTimestamp(12345),
+		DedupeSuffix:      "suffix",
+		Shard:             "shard1",
+	}
+
+	// Read everything back twice, so the file is reset in between
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 3; i++ {
+			if err := spill.write(want); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 3; i++ {
+			got, err := spill.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Got %#v, expected %#v", got, want)
+			}
+		}
+
+		if spill.count != 0 {
+			t.Errorf("Expected an empty spill file, got count %d", spill.count)
+		}
+
+		if err := spill.reset(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
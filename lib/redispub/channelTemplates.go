@@ -0,0 +1,159 @@
+package redispub
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// ChannelTemplates are templates (in the syntax of Go's text/template) for
+// the names of the channels publications are published to, for consumers
+// that expect a different naming scheme than redis-oplog's. They're executed
+// with a ChannelTemplateData, once per channel prefix.
+//
+// Custom collection channels (PublishOpts.CollectionChannels) and the global
+// channel aren't affected; they're still the channel prefix followed by the
+// channel name.
+type ChannelTemplates struct {
+	// Template for the collection channel. The default is
+	// DefaultCollectionChannelTemplate.
+	Collection *template.Template
+
+	// Template for the document-specific channel. The default is
+	// DefaultSpecificChannelTemplate.
+	Specific *template.Template
+}
+
+// ChannelTemplateData is what ChannelTemplates are executed with
+type ChannelTemplateData struct {
+	// The channel prefix (see PublishOpts.ChannelPrefixes)
+	Prefix string
+
+	// The database and collection the publication is for
+	Database   string
+	Collection string
+
+	// The _id of the document, in the form used at the end of the default
+	// specific channel. Empty for collection channels.
+	DocID string
+}
+
+// The templates that produce the channels oplogtoredis uses by default, which
+// are the ones redis-oplog subscribes to.
+const (
+	DefaultCollectionChannelTemplate = "{{.Prefix}}{{.Database}}.{{.Collection}}"
+	DefaultSpecificChannelTemplate   = "{{.Prefix}}{{.Database}}.{{.Collection}}::{{.DocID}}"
+)
+
+// NewChannelTemplates parses templates for the collection and specific
+// channels. An empty template is replaced by the default one. It returns an
+// error if either template doesn't parse, or fails when executed (for
+// example, because it refers to a field ChannelTemplateData doesn't have).
+func NewChannelTemplates(collection string, specific string) (*ChannelTemplates, error) {
+	if collection == "" {
+		collection = DefaultCollectionChannelTemplate
+	}
+	if specific == "" {
+		specific = DefaultSpecificChannelTemplate
+	}
+
+	collectionTemplate, err := parseChannelTemplate("collection", collection)
+	if err != nil {
+		return nil, err
+	}
+
+	specificTemplate, err := parseChannelTemplate("specific", specific)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChannelTemplates{
+		Collection: collectionTemplate,
+		Specific:   specificTemplate,
+	}, nil
+}
+
+// Parses a channel template, and checks that it can be executed
+func parseChannelTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s channel template: %s", name, err)
+	}
+
+	_, err = executeChannelTemplate(tmpl, &ChannelTemplateData{
+		Prefix:     "prefix.",
+		Database:   "db",
+		Collection: "collection",
+		DocID:      "id",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error executing %s channel template: %s", name, err)
+	}
+
+	return tmpl, nil
+}
+
+func executeChannelTemplate(tmpl *template.Template, data *ChannelTemplateData) (string, error) {
+	var channel bytes.Buffer
+	err := tmpl.Execute(&channel, data)
+	if err != nil {
+		return "", err
+	}
+
+	if channel.Len() == 0 {
+		return "", fmt.Errorf("Channel template %s produced an empty channel name", tmpl.Name())
+	}
+
+	return channel.String(), nil
+}
+
+// Returns a publication's collection channel, with the given prefix. Nil
+// ChannelTemplates use the default channel.
+func (t *ChannelTemplates) collectionChannel(p *Publication, prefix string) string {
+	if t == nil || t.Collection == nil {
+		return prefix + p.CollectionChannel
+	}
+
+	return t.execute(t.Collection, p, prefix, "", prefix+p.CollectionChannel)
+}
+
+// Returns a publication's specific channel, with the given prefix. Nil
+// ChannelTemplates use the default channel.
+func (t *ChannelTemplates) specificChannel(p *Publication, prefix string) string {
+	if t == nil || t.Specific == nil {
+		return prefix + p.SpecificChannel
+	}
+
+	docID := strings.TrimPrefix(p.SpecificChannel, p.CollectionChannel+"::")
+	return t.execute(t.Specific, p, prefix, docID, prefix+p.SpecificChannel)
+}
+
+// Executes one of the templates for a publication. Templates are checked
+// when they're parsed, but if one still fails, we log it and use the default
+// channel rather than dropping the publication.
+func (t *ChannelTemplates) execute(tmpl *template.Template, p *Publication, prefix string, docID string, defaultChannel string) string {
+	data := &ChannelTemplateData{
+		Prefix: prefix,
+		DocID:  docID,
+	}
+
+	parts := strings.SplitN(p.CollectionChannel, ".", 2)
+	data.Database = parts[0]
+	if len(parts) == 2 {
+		data.Collection = parts[1]
+	}
+
+	channel, err := executeChannelTemplate(tmpl, data)
+	if err != nil {
+		log.Log.Errorw("Error executing channel template; using the default channel",
+			"template", tmpl.Name(),
+			"channel", defaultChannel,
+			"error", err)
+		return defaultChannel
+	}
+
+	return channel
+}
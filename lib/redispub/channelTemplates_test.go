@@ -0,0 +1,88 @@
+package redispub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChannelTemplates(t *testing.T) {
+
+	tests := map[string]struct {
+		collection string
+		specific   string
+		opts       PublishOpts
+		extra      []string
+		want       []string
+	}{
+		"Defaults": {
+			opts: PublishOpts{ChannelPrefixes: []string{"", "new."}},
+			want: []string{"foo.bar.baz", "foo.bar.baz::someid", "new.foo.bar.baz", "new.foo.bar.baz::someid"},
+		},
+		"Custom templates": {
+			collection: "{{.Prefix}}changes/{{.Database}}/{{.Collection}}",
+			specific:   "{{.Prefix}}changes/{{.Database}}/{{.Collection}}/{{.DocID}}",
+			opts:       PublishOpts{ChannelPrefixes: []string{"app:"}, GlobalChannel: "firehose"},
+			want:       []string{"app:changes/foo/bar.baz", "app:changes/foo/bar.baz/someid", "app:firehose"},
+		},
+		"Prefix after the name": {
+			specific: "{{.Database}}.{{.Collection}}#{{.DocID}}{{.Prefix}}",
+			opts:     PublishOpts{ChannelPrefixes: []string{".v2"}},
+			want:     []string{".v2foo.bar.baz", "foo.bar.baz#someid.v2"},
+		},
+		"Extra channels": {
+			specific: "{{.Database}}/{{.Collection}}/{{.DocID}}",
+			opts:     PublishOpts{ChannelPrefixes: []string{"p."}},
+			extra:    []string{"foo.bar.baz::tenantId::acme"},
+			want:     []string{"p.foo.bar.baz", "foo/bar.baz/someid", "p.foo.bar.baz::tenantId::acme"},
+		},
+		"Custom collection channels": {
+			collection: "{{.Prefix}}changes/{{.Database}}/{{.Collection}}",
+			opts: PublishOpts{
+				CollectionChannels: map[string][]string{"foo.bar.baz": {"foo.custom"}},
+			},
+			want: []string{"foo.custom", "foo.bar.baz::someid"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			templates, err := NewChannelTemplates(test.collection, test.specific)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			publication := &Publication{
+				CollectionChannel: "foo.bar.baz",
+				SpecificChannel:   "foo.bar.baz::someid",
+				ExtraChannels:     test.extra,
+			}
+
+			test.opts.ChannelTemplates = templates
+			got := publicationChannels(publication, &test.opts, true)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("publicationChannels() = %#v, wanted %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewChannelTemplatesInvalid(t *testing.T) {
+	tests := map[string]struct {
+		collection string
+		specific   string
+	}{
+		"Syntax error":  {collection: "{{.Database"},
+		"Unknown field": {specific: "{{.Database}}.{{.Collection}}::{{.ID}}"},
+		"Empty channel": {collection: "{{if false}}x{{end}}"},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			_, err := NewChannelTemplates(test.collection, test.specific)
+			if err == nil {
+				t.Errorf("Expected an error, got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package redispub
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricCheckpointTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "checkpoint_timestamp_seconds",
+	Help:      "The time of the last-processed oplog entry most recently written to the checkpoint store, as a Unix timestamp, by shard",
+}, []string{"shard"})
+
+var metricCheckpointWrites = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "checkpoint_writes",
+	Help:      "Writes of the last-processed timestamp to the checkpoint store (see OTR_CHECKPOINT_FLUSH_STRATEGY)",
+})
+
+// FlushStrategy is when the last-processed timestamp is written to the
+// checkpoint store. Whatever the strategy, it's written when no messages
+// arrive for PublishOpts.FlushInterval, and when publishing stops.
+type FlushStrategy string
+
+const (
+	// FlushEveryInterval writes it at most once per PublishOpts.FlushInterval.
+	// This is the default.
+	FlushEveryInterval FlushStrategy = "interval"
+
+	// FlushEveryCount writes it once every PublishOpts.FlushCount messages.
+	FlushEveryCount FlushStrategy = "count"
+
+	// FlushOnIdle only writes it once no messages arrive for
+	// PublishOpts.FlushInterval. Under constant load it's never written
+	// until publishing stops, so it's only suitable for bursty workloads.
+	FlushOnIdle FlushStrategy = "idle"
+)
+
+// Checkpointer records the last-processed timestamp for code that delivers
+// publications itself rather than through PublishStream. Like PublishStream,
+// it writes the timestamp to Redis (or opts.CheckpointStore) according to
+// opts.FlushStrategy, and writes the final timestamp when closed.
+type Checkpointer struct {
+	timestamps chan checkpoint
+	done       chan bool
+}
+
+// NewCheckpointer starts a Checkpointer. Only opts.FlushInterval,
+// opts.FlushStrategy, opts.FlushCount, opts.MetadataPrefix,
+// opts.CheckpointStore, and opts.DisableCheckpoint are used. The caller must
+// call Close when done.
+func NewCheckpointer(client redis.UniversalClient, opts *PublishOpts) *Checkpointer {
+	c := &Checkpointer{
+		timestamps: make(chan checkpoint),
+		done:       make(chan bool),
+	}
+
+	go func() {
+		periodicallyUpdateTimestamp(client, c.timestamps, opts)
+		close(c.done)
+	}()
+
+	return c
+}
+
+// Record marks the oplog entry with the given timestamp (and every entry
+// before it) as processed.
+func (c *Checkpointer) Record(ts bson.MongoTimestamp) {
+	c.timestamps <- checkpoint{ts: ts}
+}
+
+// Close writes the most recently recorded timestamp and stops the
+// Checkpointer.
+func (c *Checkpointer) Close() {
+	close(c.timestamps)
+	<-c.done
+}
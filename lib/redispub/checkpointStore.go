@@ -0,0 +1,273 @@
+package redispub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CheckpointStore is where the last-processed timestamp is stored: the
+// timestamp of the last oplog entry whose publications were delivered, along
+// with the change stream resume token of that entry, if it has one.
+// Checkpoints are stored under a metadata prefix (see ShardMetadataPrefix),
+// so each shard, and each copy of oplogtoredis with a different
+// OTR_REDIS_METADATA_PREFIX, has its own.
+type CheckpointStore interface {
+	// SaveCheckpoint records ts, and resumeToken if it isn't empty, as the
+	// last-processed position under metadataPrefix.
+	SaveCheckpoint(metadataPrefix string, ts bson.MongoTimestamp, resumeToken string) error
+
+	// LastProcessedTimestamp returns the last-processed timestamp under
+	// metadataPrefix, and the time it represents. It returns
+	// ErrNoCheckpoint if none has been saved.
+	LastProcessedTimestamp(metadataPrefix string) (bson.MongoTimestamp, time.Time, error)
+
+	// LastResumeToken returns the last resume token saved under
+	// metadataPrefix, and the timestamp of its change event. It returns
+	// ErrNoCheckpoint if none has been saved.
+	LastResumeToken(metadataPrefix string) (bson.MongoTimestamp, string, error)
+}
+
+// ErrNoCheckpoint is returned by a CheckpointStore when nothing has been
+// saved under a metadata prefix.
+var ErrNoCheckpoint = errors.New("No checkpoint saved")
+
+// NewRedisCheckpointStore creates a CheckpointStore that stores checkpoints in
+// Redis, under "<metadataPrefix>lastProcessedEntry" and
+// "<metadataPrefix>lastResumeToken". It's the default, and it's where
+// LastProcessedTimestamp and LastResumeToken read from.
+func NewRedisCheckpointStore(client redis.UniversalClient) CheckpointStore {
+	return &redisCheckpointStore{client: client}
+}
+
+type redisCheckpointStore struct {
+	client redis.UniversalClient
+}
+
+func (s *redisCheckpointStore) SaveCheckpoint(metadataPrefix string, ts bson.MongoTimestamp, resumeToken string) error {
+	if resumeToken != "" {
+		err := s.client.Set(metadataPrefix+"lastResumeToken", encodeResumeToken(ts, resumeToken), 0).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.client.Set(metadataPrefix+"lastProcessedEntry", encodeMongoTimestamp(ts), 0).Err()
+}
+
+func (s *redisCheckpointStore) LastProcessedTimestamp(metadataPrefix string) (bson.MongoTimestamp, time.Time, error) {
+	ts, t, err := LastProcessedTimestamp(s.client, metadataPrefix)
+	if err == redis.Nil {
+		err = ErrNoCheckpoint
+	}
+
+	return ts, t, err
+}
+
+func (s *redisCheckpointStore) LastResumeToken(metadataPrefix string) (bson.MongoTimestamp, string, error) {
+	ts, token, err := LastResumeToken(s.client, metadataPrefix)
+	if err == redis.Nil {
+		err = ErrNoCheckpoint
+	}
+
+	return ts, token, err
+}
+
+// NewFileCheckpointStore creates a CheckpointStore that stores checkpoints in
+// a JSON file on the local disk, for deployments where Redis doesn't persist
+// its data. The file is replaced atomically each time a checkpoint is saved,
+// so it's never left half-written.
+func NewFileCheckpointStore(path string) CheckpointStore {
+	return &fileCheckpointStore{path: path}
+}
+
+type fileCheckpointStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// A checkpoint, as stored in a file or in Mongo
+type storedCheckpoint struct {
+	Timestamp   bson.MongoTimestamp `json:"timestamp" bson:"ts"`
+	ResumeToken string              `json:"resumeToken,omitempty" bson:"resumeToken,omitempty"`
+
+	// The timestamp of the change event of ResumeToken, which may be older
+	// than Timestamp
+	ResumeTokenTimestamp bson.MongoTimestamp `json:"resumeTokenTimestamp,omitempty" bson:"resumeTokenTs,omitempty"`
+}
+
+func (s *fileCheckpointStore) SaveCheckpoint(metadataPrefix string, ts bson.MongoTimestamp, resumeToken string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	c := checkpoints[metadataPrefix]
+	c.Timestamp = ts
+	if resumeToken != "" {
+		c.ResumeToken = resumeToken
+		c.ResumeTokenTimestamp = ts
+	}
+	checkpoints[metadataPrefix] = c
+
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a new file and move it into place, so a crash can't leave a
+	// partial file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing checkpoint file: %s", err)
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("Error writing checkpoint file: %s", err)
+	}
+
+	return nil
+}
+
+func (s *fileCheckpointStore) LastProcessedTimestamp(metadataPrefix string) (bson.MongoTimestamp, time.Time, error) {
+	c, err := s.load(metadataPrefix)
+	if err != nil {
+		return 0, time.Unix(0, 0), err
+	}
+
+	return c.Timestamp, mongoTimestampToTime(c.Timestamp), nil
+}
+
+func (s *fileCheckpointStore) LastResumeToken(metadataPrefix string) (bson.MongoTimestamp, string, error) {
+	c, err := s.load(metadataPrefix)
+	if err != nil {
+		return 0, "", err
+	}
+	if c.ResumeToken == "" {
+		return 0, "", ErrNoCheckpoint
+	}
+
+	return c.ResumeTokenTimestamp, c.ResumeToken, nil
+}
+
+// Returns the checkpoint saved under metadataPrefix
+func (s *fileCheckpointStore) load(metadataPrefix string) (storedCheckpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return storedCheckpoint{}, err
+	}
+
+	c, ok := checkpoints[metadataPrefix]
+	if !ok {
+		return storedCheckpoint{}, ErrNoCheckpoint
+	}
+
+	return c, nil
+}
+
+// Reads every checkpoint in the file, keyed by metadata prefix. A missing
+// file has no checkpoints.
+func (s *fileCheckpointStore) read() (map[string]storedCheckpoint, error) {
+	checkpoints := map[string]storedCheckpoint{}
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading checkpoint file: %s", err)
+	}
+
+	err = json.Unmarshal(data, &checkpoints)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing checkpoint file %s: %s", s.path, err)
+	}
+
+	return checkpoints, nil
+}
+
+// NewMongoCheckpointStore creates a CheckpointStore that stores checkpoints in
+// a Mongo collection, with one document per metadata prefix. Writing
+// checkpoints to the database we tail adds entries to the oplog, so the
+// collection should be excluded from tailing (see WithNamespaceFilter in the
+// oplog package).
+func NewMongoCheckpointStore(client *mongo.Client, database string, collection string) CheckpointStore {
+	return &mongoCheckpointStore{collection: client.Database(database).Collection(collection)}
+}
+
+type mongoCheckpointStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoCheckpointStore) SaveCheckpoint(metadataPrefix string, ts bson.MongoTimestamp, resumeToken string) error {
+	set := bson.M{"ts": ts, "updatedAt": time.Now()}
+	if resumeToken != "" {
+		set["resumeToken"] = resumeToken
+		set["resumeTokenTs"] = ts
+	}
+
+	_, err := s.collection.UpdateOne(context.Background(),
+		mongodb.Doc(bson.M{"_id": metadataPrefix}),
+		mongodb.Doc(bson.M{"$set": set}),
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (s *mongoCheckpointStore) LastProcessedTimestamp(metadataPrefix string) (bson.MongoTimestamp, time.Time, error) {
+	c, err := s.load(metadataPrefix)
+	if err != nil {
+		return 0, time.Unix(0, 0), err
+	}
+
+	return c.Timestamp, mongoTimestampToTime(c.Timestamp), nil
+}
+
+func (s *mongoCheckpointStore) LastResumeToken(metadataPrefix string) (bson.MongoTimestamp, string, error) {
+	c, err := s.load(metadataPrefix)
+	if err != nil {
+		return 0, "", err
+	}
+	if c.ResumeToken == "" {
+		return 0, "", ErrNoCheckpoint
+	}
+
+	return c.ResumeTokenTimestamp, c.ResumeToken, nil
+}
+
+func (s *mongoCheckpointStore) load(metadataPrefix string) (storedCheckpoint, error) {
+	var c storedCheckpoint
+	err := mongodb.FindOne(s.collection, bson.M{"_id": metadataPrefix}, &c)
+	if err == mongo.ErrNoDocuments {
+		return c, ErrNoCheckpoint
+	}
+
+	return c, err
+}
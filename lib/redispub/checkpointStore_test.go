@@ -0,0 +1,120 @@
+package redispub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Checks that a store round-trips checkpoints, and keeps each metadata
+// prefix separate
+func testCheckpointStore(t *testing.T, store CheckpointStore) {
+	_, _, err := store.LastProcessedTimestamp("someprefix.")
+	if err != ErrNoCheckpoint {
+		t.Errorf("Expected ErrNoCheckpoint before saving, got %v", err)
+	}
+
+	err = store.SaveCheckpoint("someprefix.", bson.MongoTimestamp(1000<<32), "token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SaveCheckpoint("someprefix.", bson.MongoTimestamp(2000<<32), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SaveCheckpoint("otherprefix.", bson.MongoTimestamp(3000<<32), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, tsTime, err := store.LastProcessedTimestamp("someprefix.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != bson.MongoTimestamp(2000<<32) {
+		t.Errorf("Got timestamp %d, expected %d", ts, bson.MongoTimestamp(2000<<32))
+	}
+	if tsTime.Unix() != 2000 {
+		t.Errorf("Got time %d, expected 2000", tsTime.Unix())
+	}
+
+	// The resume token is kept when a later checkpoint doesn't have one
+	tokenTS, token, err := store.LastResumeToken("someprefix.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token1" || tokenTS != bson.MongoTimestamp(1000<<32) {
+		t.Errorf("Got resume token %s at %d, expected token1 at %d", token, tokenTS, bson.MongoTimestamp(1000<<32))
+	}
+
+	ts, _, err = store.LastProcessedTimestamp("otherprefix.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != bson.MongoTimestamp(3000<<32) {
+		t.Errorf("Got timestamp %d, expected %d", ts, bson.MongoTimestamp(3000<<32))
+	}
+
+	_, _, err = store.LastResumeToken("otherprefix.")
+	if err != ErrNoCheckpoint {
+		t.Errorf("Expected ErrNoCheckpoint for a prefix without a resume token, got %v", err)
+	}
+}
+
+func TestRedisCheckpointStore(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	testCheckpointStore(t, NewRedisCheckpointStore(redisClient))
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplogtoredis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoints.json")
+	testCheckpointStore(t, NewFileCheckpointStore(path))
+
+	// A new store reads what the old one saved
+	ts, _, err := NewFileCheckpointStore(path).LastProcessedTimestamp("otherprefix.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts != bson.MongoTimestamp(3000<<32) {
+		t.Errorf("Got timestamp %d, expected %d", ts, bson.MongoTimestamp(3000<<32))
+	}
+
+	// Only the checkpoint file is left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected only the checkpoint file, but found %d files", len(files))
+	}
+}
+
+func TestFileCheckpointStoreCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplogtoredis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoints.json")
+	err = ioutil.WriteFile(path, []byte("not json"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = NewFileCheckpointStore(path).LastProcessedTimestamp("someprefix.")
+	if err == nil || err == ErrNoCheckpoint {
+		t.Errorf("Expected an error parsing the checkpoint file, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package redispub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestCheckpointer(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	checkpointer := NewCheckpointer(redisClient, &PublishOpts{
+		FlushInterval:  time.Hour,
+		MetadataPrefix: "someprefix.",
+	})
+
+	// The first timestamp is flushed immediately; the rest wait for the
+	// flush interval or Close
+	checkpointer.Record(bson.MongoTimestamp(1))
+	checkpointer.Record(bson.MongoTimestamp(2))
+	checkpointer.Record(bson.MongoTimestamp(3))
+
+	if got, _ := redisServer.Get("someprefix.lastProcessedEntry"); got != "1" {
+		t.Errorf("Expected timestamp 1 before Close, got %q", got)
+	}
+
+	checkpointer.Close()
+
+	if got, _ := redisServer.Get("someprefix.lastProcessedEntry"); got != "3" {
+		t.Errorf("Expected timestamp 3 after Close, got %q", got)
+	}
+}
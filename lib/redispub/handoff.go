@@ -0,0 +1,179 @@
+package redispub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// The handoff protocol lets a newly-started copy of oplogtoredis take over
+// from an already-running copy without a window where events are published
+// twice or not at all:
+//
+// 1. The running copy subscribes to the <prefix>handoff channel at startup.
+//
+// 2. The new copy publishes a random request ID to that channel, and then
+// blocks on the <prefix>handoff::<request ID> list.
+//
+// 3. One running copy claims the request (by setting the
+// <prefix>handoff::<request ID>::claimed key), stops tailing, drains the
+// publications it has already buffered, and writes its final
+// last-processed timestamp.
+//
+// 4. That copy then pushes its final timestamp onto the
+// <prefix>handoff::<request ID> list and exits. The new copy pops the
+// timestamp and starts tailing from exactly that point.
+
+// ErrHandoffTimeout is returned by RequestHandoff if a running copy of
+// oplogtoredis received the request but did not complete the handoff in time.
+var ErrHandoffTimeout = errors.New("Timed out waiting for handoff to complete")
+
+// HandoffListener receives handoff requests from newly-started copies of
+// oplogtoredis.
+type HandoffListener struct {
+	pubsub   *redis.PubSub
+	requests chan string
+}
+
+// ListenForHandoff subscribes to handoff requests. The returned listener's
+// Requests channel receives the ID of the first request that this process
+// claims; the caller should then drain its publications and call
+// CompleteHandoff.
+func ListenForHandoff(client redis.UniversalClient, metadataPrefix string, timeout time.Duration) (*HandoffListener, error) {
+	pubsub := client.Subscribe(metadataPrefix + "handoff")
+
+	// Wait for the subscription to be confirmed, so we don't miss any requests
+	// sent after we return
+	_, err := pubsub.Receive()
+	if err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	listener := &HandoffListener{
+		pubsub:   pubsub,
+		requests: make(chan string, 1),
+	}
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			claimed, claimErr := claimHandoff(client, metadataPrefix, msg.Payload, timeout)
+			if claimErr != nil {
+				log.Log.Errorw("Error claiming handoff request",
+					"error", claimErr,
+					"requestID", msg.Payload)
+				continue
+			}
+
+			if claimed {
+				listener.requests <- msg.Payload
+				return
+			}
+		}
+	}()
+
+	return listener, nil
+}
+
+// Requests returns a channel that receives the ID of a handoff request once
+// this process has claimed one.
+func (l *HandoffListener) Requests() <-chan string {
+	return l.requests
+}
+
+// Close unsubscribes from handoff requests.
+func (l *HandoffListener) Close() error {
+	return l.pubsub.Close()
+}
+
+// Claims a handoff request, so that only one running copy of oplogtoredis
+// hands off to the new copy. Returns whether we successfully claimed it.
+func claimHandoff(client redis.UniversalClient, metadataPrefix string, requestID string, timeout time.Duration) (bool, error) {
+	return client.SetNX(handoffResponseKey(metadataPrefix, requestID)+"::claimed", 1, timeout).Result()
+}
+
+// CompleteHandoff sends our final last-processed timestamp, read from store,
+// to the copy of oplogtoredis that requested the handoff. It must only be
+// called once all buffered publications have been published and the final
+// timestamp has been written (i.e., once PublishStream has returned after its
+// input channel was closed).
+func CompleteHandoff(client redis.UniversalClient, store CheckpointStore, metadataPrefix string, requestID string, timeout time.Duration) error {
+	ts, _, err := store.LastProcessedTimestamp(metadataPrefix)
+	if err == ErrNoCheckpoint {
+		// We never published anything; the new copy will need to figure out
+		// where to start on its own
+		ts = 0
+	} else if err != nil {
+		return err
+	}
+
+	key := handoffResponseKey(metadataPrefix, requestID)
+	pipe := client.Pipeline()
+	defer pipe.Close()
+
+	pipe.RPush(key, encodeMongoTimestamp(ts))
+	pipe.Expire(key, timeout)
+	_, err = pipe.Exec()
+
+	return err
+}
+
+// RequestHandoff asks an already-running copy of oplogtoredis to stop and hand
+// off to us. It blocks until the handoff completes, and returns the timestamp
+// we should resume tailing from.
+//
+// If no other copy of oplogtoredis is running, or the running copy had not
+// processed any entries, it returns 0 and no error.
+func RequestHandoff(client redis.UniversalClient, metadataPrefix string, timeout time.Duration) (bson.MongoTimestamp, error) {
+	requestID, err := newHandoffRequestID()
+	if err != nil {
+		return 0, err
+	}
+
+	receivers, err := client.Publish(metadataPrefix+"handoff", requestID).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if receivers == 0 {
+		return 0, nil
+	}
+
+	log.Log.Infow("Requested handoff from running copy of oplogtoredis; waiting for it to drain",
+		"requestID", requestID)
+
+	return waitForHandoff(client, metadataPrefix, requestID, timeout)
+}
+
+// Waits for the copy of oplogtoredis that claimed our handoff request to
+// send us its final timestamp
+func waitForHandoff(client redis.UniversalClient, metadataPrefix string, requestID string, timeout time.Duration) (bson.MongoTimestamp, error) {
+	result, err := client.BLPop(timeout, handoffResponseKey(metadataPrefix, requestID)).Result()
+	if err == redis.Nil {
+		return 0, ErrHandoffTimeout
+	} else if err != nil {
+		return 0, err
+	}
+
+	// BLPOP returns the key name followed by the value
+	return decodeMongoTimestamp(result[1])
+}
+
+func handoffResponseKey(metadataPrefix string, requestID string) string {
+	return metadataPrefix + "handoff::" + requestID
+}
+
+func newHandoffRequestID() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
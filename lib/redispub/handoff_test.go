@@ -0,0 +1,114 @@
+package redispub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestClaimHandoff(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	claimed, err := claimHandoff(redisClient, "someprefix.", "request1", time.Minute)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !claimed {
+		t.Errorf("Expected first claim to succeed")
+	}
+
+	claimed, err = claimHandoff(redisClient, "someprefix.", "request1", time.Minute)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if claimed {
+		t.Errorf("Expected second claim of the same request to fail")
+	}
+}
+
+func TestCompleteHandoff(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	redisServer.Set("someprefix.lastProcessedEntry", encodeMongoTimestamp(bson.MongoTimestamp(1234)))
+
+	err := CompleteHandoff(redisClient, NewRedisCheckpointStore(redisClient), "someprefix.", "request1", time.Minute)
+	if err != nil {
+		t.Fatalf("Got unexpected error completing handoff: %s", err)
+	}
+
+	ts, err := waitForHandoff(redisClient, "someprefix.", "request1", time.Second)
+	if err != nil {
+		t.Fatalf("Got unexpected error waiting for handoff: %s", err)
+	}
+
+	if ts != bson.MongoTimestamp(1234) {
+		t.Errorf("Expected handoff timestamp 1234, got %d", ts)
+	}
+}
+
+func TestCompleteHandoffCheckpointStore(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	dir, err := ioutil.TempDir("", "oplogtoredis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The timestamp comes from the store we checkpoint to, not from Redis
+	redisServer.Set("someprefix.lastProcessedEntry", encodeMongoTimestamp(bson.MongoTimestamp(1234)))
+	store := NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json"))
+	if err := store.SaveCheckpoint("someprefix.", bson.MongoTimestamp(5678), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	err = CompleteHandoff(redisClient, store, "someprefix.", "request1", time.Minute)
+	if err != nil {
+		t.Fatalf("Got unexpected error completing handoff: %s", err)
+	}
+
+	ts, err := waitForHandoff(redisClient, "someprefix.", "request1", time.Second)
+	if err != nil {
+		t.Fatalf("Got unexpected error waiting for handoff: %s", err)
+	}
+
+	if ts != bson.MongoTimestamp(5678) {
+		t.Errorf("Expected handoff timestamp 5678, got %d", ts)
+	}
+}
+
+func TestCompleteHandoffNothingProcessed(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	err := CompleteHandoff(redisClient, NewRedisCheckpointStore(redisClient), "someprefix.", "request1", time.Minute)
+	if err != nil {
+		t.Fatalf("Got unexpected error completing handoff: %s", err)
+	}
+
+	ts, err := waitForHandoff(redisClient, "someprefix.", "request1", time.Second)
+	if err != nil {
+		t.Fatalf("Got unexpected error waiting for handoff: %s", err)
+	}
+
+	if ts != 0 {
+		t.Errorf("Expected handoff timestamp 0, got %d", ts)
+	}
+}
+
+func TestWaitForHandoffTimeout(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	_, err := waitForHandoff(redisClient, "someprefix.", "request1", time.Second)
+	if err != ErrHandoffTimeout {
+		t.Errorf("Expected ErrHandoffTimeout, got %v", err)
+	}
+}
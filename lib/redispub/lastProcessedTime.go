@@ -1,6 +1,8 @@
 package redispub
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/globalsign/mgo/bson"
@@ -30,3 +32,42 @@ func LastProcessedTimestamp(redisClient redis.UniversalClient, metadataPrefix st
 	time := mongoTimestampToTime(ts)
 	return ts, time, nil
 }
+
+// LastResumeToken returns the change stream resume token that oplogtoredis
+// recorded with the last-processed timestamp, and the timestamp of the change
+// event it belongs to. The token is written before the timestamp, and neither
+// is written atomically with the other, so callers should only use the token
+// if its timestamp matches the last-processed timestamp.
+//
+// If oplogtoredis has not recorded a resume token, returns redis.Nil as an
+// error.
+func LastResumeToken(redisClient redis.UniversalClient, metadataPrefix string) (bson.MongoTimestamp, string, error) {
+	str, err := redisClient.Get(metadataPrefix + "lastResumeToken").Result()
+	if err != nil {
+		return 0, "", err
+	}
+
+	return decodeResumeToken(str)
+}
+
+// Converts a resume token and the timestamp of its change event into a
+// string, in the form "<timestamp>:<token>"
+func encodeResumeToken(ts bson.MongoTimestamp, token string) string {
+	return encodeMongoTimestamp(ts) + ":" + token
+}
+
+// Converts a string from encodeResumeToken back into a timestamp and resume
+// token
+func decodeResumeToken(str string) (bson.MongoTimestamp, string, error) {
+	parts := strings.SplitN(str, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", errors.New("Malformed resume token")
+	}
+
+	ts, err := decodeMongoTimestamp(parts[0])
+	if err != nil {
+		return 0, "", err
+	}
+
+	return ts, parts[1], nil
+}
@@ -87,3 +87,31 @@ func TestLastProcessedTimestampRedisError(t *testing.T) {
 		t.Errorf("Expected TCP error, got: %s", err)
 	}
 }
+
+func TestLastResumeToken(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	_, _, err := LastResumeToken(redisClient, "someprefix.")
+	if err != redis.Nil {
+		t.Errorf("Expected redis.Nil error, got: %v", err)
+	}
+
+	redisServer.Set("someprefix.lastResumeToken", encodeResumeToken(bson.MongoTimestamp(1234), "826a3e"))
+
+	ts, token, err := LastResumeToken(redisClient, "someprefix.")
+	if err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+	if ts != bson.MongoTimestamp(1234) || token != "826a3e" {
+		t.Errorf("Got timestamp %d and token %q, expected 1234 and \"826a3e\"", ts, token)
+	}
+
+	for _, malformed := range []string{"826a3e", "1234:", "abc:826a3e"} {
+		redisServer.Set("someprefix.lastResumeToken", malformed)
+		_, _, err = LastResumeToken(redisClient, "someprefix.")
+		if err == nil {
+			t.Errorf("Expected an error for %q, got none", malformed)
+		}
+	}
+}
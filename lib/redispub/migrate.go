@@ -0,0 +1,178 @@
+package redispub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// ErrDestinationAhead is returned by MigrateMetadata if the destination
+// already has a last-processed timestamp that is later than the source's.
+// Migrating in that case would move the resume position backwards and cause
+// events to be replayed.
+var ErrDestinationAhead = errors.New("Destination last-processed timestamp is later than the source's")
+
+// MigrateOpts configures MigrateMetadata
+type MigrateOpts struct {
+	// Whether to also copy the keys used to deduplicate messages, so that
+	// copies of oplogtoredis publishing to the destination don't re-publish
+	// messages that were already published via the source.
+	IncludeDedupe bool
+
+	// Overwrite the destination's last-processed timestamp even if it's later
+	// than the source's
+	Force bool
+}
+
+// MigrateResult describes what MigrateMetadata copied
+type MigrateResult struct {
+	// Whether the source had a last-processed timestamp to copy
+	CopiedTimestamp bool
+
+	// The number of dedupe keys copied
+	CopiedDedupeKeys int
+}
+
+// MigrateMetadata copies the last-processed timestamp (with its change stream
+// resume token, if there is one) and (optionally) the dedupe keys from one Redis server and metadata prefix to another. The
+// source and destination may be the same server with different prefixes.
+//
+// Dedupe keys are copied with their remaining expiration, so they expire on
+// the destination at the same time they would have on the source. The
+// timestamp is copied last, so if the migration is interrupted, oplogtoredis
+// will never resume from a timestamp whose dedupe keys weren't copied.
+func MigrateMetadata(from redis.UniversalClient, fromPrefix string, to redis.UniversalClient, toPrefix string, opts MigrateOpts) (*MigrateResult, error) {
+	result := &MigrateResult{}
+
+	fromTS, _, err := LastProcessedTimestamp(from, fromPrefix)
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	hasTimestamp := err == nil
+
+	if hasTimestamp && !opts.Force {
+		toTS, _, toErr := LastProcessedTimestamp(to, toPrefix)
+		if toErr == nil && toTS > fromTS {
+			return nil, ErrDestinationAhead
+		} else if toErr != nil && toErr != redis.Nil {
+			return nil, toErr
+		}
+	}
+
+	if opts.IncludeDedupe {
+		result.CopiedDedupeKeys, err = migrateDedupeKeys(from, fromPrefix, to, toPrefix)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if hasTimestamp {
+		// The resume token is only used along with a matching timestamp, so
+		// copy it first
+		token, err := from.Get(fromPrefix + "lastResumeToken").Result()
+		if err == nil {
+			err = to.Set(toPrefix+"lastResumeToken", token, 0).Err()
+		}
+		if err != nil && err != redis.Nil {
+			return result, err
+		}
+
+		err = to.Set(toPrefix+"lastProcessedEntry", encodeMongoTimestamp(fromTS), 0).Err()
+		if err != nil {
+			return result, err
+		}
+		result.CopiedTimestamp = true
+	}
+
+	return result, nil
+}
+
+// Copies every dedupe key under fromPrefix, preserving its remaining
+// expiration. Returns the number of keys copied.
+func migrateDedupeKeys(from redis.UniversalClient, fromPrefix string, to redis.UniversalClient, toPrefix string) (int, error) {
+	copied := 0
+	iter := from.Scan(0, fromPrefix+"processed::*", 1000).Iterator()
+
+	for iter.Next() {
+		key := iter.Val()
+
+		ttl, err := from.PTTL(key).Result()
+		if err != nil {
+			return copied, err
+		}
+
+		// PTTL returns -2 if the key doesn't exist, and -1 if it has no
+		// expiration
+		switch {
+		case ttl == -2*time.Millisecond, ttl == 0:
+			// The key expired (or is about to) since we scanned it
+			continue
+		case ttl == -1*time.Millisecond:
+			// oplogtoredis always sets an expiration, but if someone removed
+			// it, don't make up our own
+			ttl = 0
+		}
+
+		err = to.Set(toPrefix+key[len(fromPrefix):], 1, ttl).Err()
+		if err != nil {
+			return copied, err
+		}
+		copied++
+	}
+
+	return copied, iter.Err()
+}
+
+// UpgradeCheckpoint moves a checkpoint to a new metadata prefix, for when the
+// prefix it's stored under changes (e.g. when a cluster name is set, or an
+// unsharded deployment starts tailing each shard). If there's no checkpoint
+// under prefix, it copies the first one it finds under legacyPrefixes, along
+// with its resume token, and returns true. The legacy checkpoint is left in
+// place, so downgrading still works.
+//
+// Dedupe keys aren't copied, so messages published shortly before the
+// upgrade may be published again after it.
+func UpgradeCheckpoint(store CheckpointStore, prefix string, legacyPrefixes ...string) (bool, error) {
+	_, _, err := store.LastProcessedTimestamp(prefix)
+	if err != ErrNoCheckpoint {
+		// Already upgraded (or the store isn't working)
+		return false, err
+	}
+
+	for _, legacyPrefix := range legacyPrefixes {
+		if legacyPrefix == prefix {
+			continue
+		}
+
+		ts, _, err := store.LastProcessedTimestamp(legacyPrefix)
+		if err == ErrNoCheckpoint {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		// Only copy the resume token if it belongs to this timestamp;
+		// otherwise resuming from it would skip to an older position
+		tokenTS, token, err := store.LastResumeToken(legacyPrefix)
+		if err != nil && err != ErrNoCheckpoint {
+			return false, err
+		}
+		if tokenTS != ts {
+			token = ""
+		}
+
+		err = store.SaveCheckpoint(prefix, ts, token)
+		if err != nil {
+			return false, err
+		}
+
+		log.Log.Infow("Upgraded last-processed timestamp to a new metadata prefix",
+			"from", legacyPrefix,
+			"to", prefix)
+		return true, nil
+	}
+
+	return false, nil
+}
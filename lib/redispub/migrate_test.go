@@ -0,0 +1,191 @@
+package redispub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateMetadata(t *testing.T) {
+	fromServer, fromClient := startMiniredis()
+	defer fromServer.Close()
+	toServer, toClient := startMiniredis()
+	defer toServer.Close()
+
+	fromServer.Set("old.lastProcessedEntry", "1234")
+	fromServer.Set("old.lastResumeToken", "1234:abcd")
+	fromServer.Set("old.processed::1000", "1")
+	fromServer.SetTTL("old.processed::1000", 30*time.Second)
+	fromServer.Set("old.processed::1234", "1")
+	fromServer.SetTTL("old.processed::1234", 60*time.Second)
+	fromServer.Set("other.processed::1234", "1")
+
+	result, err := MigrateMetadata(fromClient, "old.", toClient, "new.", MigrateOpts{IncludeDedupe: true})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if !result.CopiedTimestamp {
+		t.Errorf("Expected timestamp to be copied")
+	}
+	if result.CopiedDedupeKeys != 2 {
+		t.Errorf("Expected 2 dedupe keys to be copied, got %d", result.CopiedDedupeKeys)
+	}
+
+	ts, err := toServer.Get("new.lastProcessedEntry")
+	if err != nil || ts != "1234" {
+		t.Errorf("Expected new.lastProcessedEntry to be 1234, got %s (error %v)", ts, err)
+	}
+
+	token, err := toServer.Get("new.lastResumeToken")
+	if err != nil || token != "1234:abcd" {
+		t.Errorf("Expected new.lastResumeToken to be 1234:abcd, got %s (error %v)", token, err)
+	}
+
+	if !toServer.Exists("new.processed::1000") || !toServer.Exists("new.processed::1234") {
+		t.Errorf("Expected dedupe keys to be copied, got keys %v", toServer.Keys())
+	}
+	if len(toServer.Keys()) != 4 {
+		t.Errorf("Expected only keys under the source prefix to be copied, got keys %v", toServer.Keys())
+	}
+
+	if ttl := toServer.TTL("new.processed::1234"); ttl <= 30*time.Second || ttl > 60*time.Second {
+		t.Errorf("Expected dedupe key expiration to be preserved, got %s", ttl)
+	}
+}
+
+func TestMigrateMetadataSameServer(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	redisServer.Set("old.lastProcessedEntry", "1234")
+	redisServer.Set("old.processed::1234", "1")
+
+	result, err := MigrateMetadata(redisClient, "old.", redisClient, "new.", MigrateOpts{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if !result.CopiedTimestamp || result.CopiedDedupeKeys != 0 {
+		t.Errorf("Expected only the timestamp to be copied, got %+v", result)
+	}
+
+	if ts, _ := redisServer.Get("new.lastProcessedEntry"); ts != "1234" {
+		t.Errorf("Expected new.lastProcessedEntry to be 1234, got %s", ts)
+	}
+	if redisServer.Exists("new.processed::1234") {
+		t.Errorf("Did not expect dedupe keys to be copied")
+	}
+}
+
+func TestMigrateMetadataDestinationAhead(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	redisServer.Set("old.lastProcessedEntry", "1234")
+	redisServer.Set("new.lastProcessedEntry", "5678")
+
+	_, err := MigrateMetadata(redisClient, "old.", redisClient, "new.", MigrateOpts{})
+	if err != ErrDestinationAhead {
+		t.Errorf("Expected ErrDestinationAhead, got %v", err)
+	}
+	if ts, _ := redisServer.Get("new.lastProcessedEntry"); ts != "5678" {
+		t.Errorf("Expected new.lastProcessedEntry to be unchanged, got %s", ts)
+	}
+
+	_, err = MigrateMetadata(redisClient, "old.", redisClient, "new.", MigrateOpts{Force: true})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if ts, _ := redisServer.Get("new.lastProcessedEntry"); ts != "1234" {
+		t.Errorf("Expected new.lastProcessedEntry to be overwritten with --force, got %s", ts)
+	}
+}
+
+func TestMigrateMetadataNothingToCopy(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	result, err := MigrateMetadata(redisClient, "old.", redisClient, "new.", MigrateOpts{IncludeDedupe: true})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if result.CopiedTimestamp || result.CopiedDedupeKeys != 0 {
+		t.Errorf("Expected nothing to be copied, got %+v", result)
+	}
+}
+
+func TestUpgradeCheckpoint(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+	store := NewRedisCheckpointStore(redisClient)
+
+	redisServer.Set("otr::lastProcessedEntry", "1234")
+	redisServer.Set("otr::lastResumeToken", "1234:abcd")
+
+	prefix := ShardMetadataPrefix(ClusterMetadataPrefix("otr::", "eu"), "shard0")
+	upgraded, err := UpgradeCheckpoint(store, prefix, "otr::shard::shard0::", "otr::")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !upgraded {
+		t.Errorf("Expected the checkpoint to be upgraded")
+	}
+
+	redisServer.CheckGet(t, "otr::cluster::eu::shard::shard0::lastProcessedEntry", "1234")
+	redisServer.CheckGet(t, "otr::cluster::eu::shard::shard0::lastResumeToken", "1234:abcd")
+
+	// The legacy checkpoint is left in place
+	redisServer.CheckGet(t, "otr::lastProcessedEntry", "1234")
+
+	// Once upgraded, the new checkpoint isn't overwritten
+	redisServer.Set("otr::lastProcessedEntry", "5678")
+	upgraded, err = UpgradeCheckpoint(store, prefix, "otr::")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if upgraded {
+		t.Errorf("Expected the checkpoint not to be upgraded again")
+	}
+	redisServer.CheckGet(t, "otr::cluster::eu::shard::shard0::lastProcessedEntry", "1234")
+}
+
+func TestUpgradeCheckpointFirstLegacyPrefix(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+	store := NewRedisCheckpointStore(redisClient)
+
+	redisServer.Set("otr::shard::shard0::lastProcessedEntry", "2000")
+	redisServer.Set("otr::lastProcessedEntry", "1000")
+
+	// A resume token for an older entry isn't copied
+	redisServer.Set("otr::shard::shard0::lastResumeToken", "1500:abcd")
+
+	upgraded, err := UpgradeCheckpoint(store, "otr::cluster::eu::shard::shard0::", "otr::shard::shard0::", "otr::")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !upgraded {
+		t.Errorf("Expected the checkpoint to be upgraded")
+	}
+
+	redisServer.CheckGet(t, "otr::cluster::eu::shard::shard0::lastProcessedEntry", "2000")
+	if redisServer.Exists("otr::cluster::eu::shard::shard0::lastResumeToken") {
+		t.Errorf("Expected the stale resume token not to be copied")
+	}
+}
+
+func TestUpgradeCheckpointNothingToUpgrade(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	upgraded, err := UpgradeCheckpoint(NewRedisCheckpointStore(redisClient), "otr::cluster::eu::", "otr::")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if upgraded {
+		t.Errorf("Expected nothing to be upgraded")
+	}
+	if len(redisServer.Keys()) != 0 {
+		t.Errorf("Expected no keys to be written, got %v", redisServer.Keys())
+	}
+}
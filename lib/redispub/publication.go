@@ -1,7 +1,10 @@
 package redispub
 
 import (
+	"strings"
+
 	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/tracing"
 )
 
 // Publication represents a message to be sent to Redis about an
@@ -11,11 +14,89 @@ type Publication struct {
 	CollectionChannel string
 	SpecificChannel   string
 
+	// Other channels to send the message to, like the channels for the
+	// values of a collection's routing fields. Channel prefixes are applied
+	// to them too.
+	ExtraChannels []string
+
 	// Message to send
 	Msg []byte
 
+	// The message before it was encoded as Msg, for sinks that publish
+	// messages in another encoding (see lib/encoding), so they don't have to
+	// parse Msg. It's nil for publications that were stored (since it isn't
+	// stored) and for ones that aren't changes to documents.
+	Payload interface{} `json:"-"`
+
 	// The timestamp of the oplog entry. Note that this serves as *both*
 	// a monotonically increasing timestamp *and* a unique identifier --
 	// see https://docs.mongodb.com/manual/reference/bson-types/#timestamps
 	OplogTimestamp bson.MongoTimestamp
+
+	// Distinguishes the publications for an oplog entry that produces more
+	// than one (like the removes for a renamed collection), so they aren't
+	// deduplicated against each other. Empty for most publications.
+	DedupeSuffix string
+
+	// If true, this isn't the last publication for its oplog entry (like
+	// one of the operations of a transaction's applyOps entry), so
+	// publishing it doesn't record the entry as processed: otherwise, a
+	// checkpoint written before the rest are published would skip them when
+	// tailing resumes. Only the last publication records the entry.
+	MoreInEntry bool
+
+	// For publications read from a change stream, the resume token of the
+	// change event (hex-encoded BSON). It's recorded along with the
+	// last-processed timestamp, so the stream can be resumed right after
+	// this event. Empty otherwise.
+	ResumeToken string
+
+	// If true, the publication isn't a change to a document, but an event
+	// about the oplog itself, like a dead letter for an entry that couldn't
+	// be processed, or a data gap. It's only published to CollectionChannel,
+	// the channel for those events (once per channel prefix).
+	Meta bool
+
+	// For publications from one shard of a sharded cluster, the name of the
+	// shard. Each shard has its own oplog, so the shard's publications are
+	// checkpointed and deduplicated separately (see ShardMetadataPrefix).
+	// Empty otherwise.
+	Shard string
+
+	// If the oplog entry is being traced, its span. Publishing and
+	// checkpointing the publication are traced as children of it. It isn't
+	// stored, so publications replayed from a write-ahead log aren't traced.
+	Trace *tracing.Span `json:"-"`
+}
+
+// DocumentID returns the ID of the document a publication is about, in the
+// form redis-oplog uses in document channel names (e.g. the hex string of an
+// ObjectId)
+func DocumentID(p *Publication) string {
+	return strings.TrimPrefix(p.SpecificChannel, p.CollectionChannel+"::")
+}
+
+// ClusterMetadataPrefix returns the metadata prefix for everything a copy of
+// oplogtoredis stores for one Mongo cluster, so that copies tailing different
+// clusters can share a Redis server and metadata prefix. It returns
+// metadataPrefix itself for the empty cluster name. Shards are namespaced
+// within it (see ShardMetadataPrefix).
+func ClusterMetadataPrefix(metadataPrefix string, cluster string) string {
+	if cluster == "" {
+		return metadataPrefix
+	}
+
+	return metadataPrefix + "cluster::" + cluster + "::"
+}
+
+// ShardMetadataPrefix returns the metadata prefix for the last-processed
+// timestamp and dedupe keys of a shard's publications. Timestamps are only
+// unique within one oplog, so each shard needs its own. It returns
+// metadataPrefix itself for the empty shard name.
+func ShardMetadataPrefix(metadataPrefix string, shard string) string {
+	if shard == "" {
+		return metadataPrefix
+	}
+
+	return metadataPrefix + "shard::" + shard + "::"
 }
@@ -5,6 +5,9 @@
 package redispub
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"time"
 
@@ -13,6 +16,8 @@ import (
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/chaos"
 	"github.com/tulip/oplogtoredis/lib/log"
 )
 
@@ -21,16 +26,95 @@ type PublishOpts struct {
 	FlushInterval    time.Duration
 	DedupeExpiration time.Duration
 	MetadataPrefix   string
+
+	// Prefixes prepended to the channel names of every publication. Each
+	// publication is published once per prefix, which allows consumers to
+	// migrate from one prefix to another without a flag-day cutover. If
+	// empty, publications are published with unprefixed channel names.
+	ChannelPrefixes []string
+
+	// Channels to publish to instead of the collection channel, keyed by
+	// collection channel ("<db>.<collection>"). This replicates redis-oplog's
+	// `channel` and `namespace` mutation options, which make redis-oplog
+	// publish to (and subscribe to) custom channels. The document-specific
+	// channel is used as usual, just like redis-oplog does. Channel prefixes
+	// are applied to these channels too.
+	CollectionChannels map[string][]string
+
+	// If set, templates for the names of the collection and specific
+	// channels. See ChannelTemplates.
+	ChannelTemplates *ChannelTemplates
+
+	// If set, every publication is also published to this channel (once per
+	// channel prefix), for consumers that want every change without
+	// subscribing to thousands of collection channels.
+	GlobalChannel string
+
+	// If true, deduplicate publications on a hash of their message and
+	// channels, as well as their oplog timestamp. This lets copies of
+	// oplogtoredis with different configurations (for example, in the middle
+	// of a rolling deploy that changes the channel prefix) run side by side:
+	// each distinct message is published exactly once, however many copies
+	// publish it. The copies must produce byte-identical messages to
+	// deduplicate each other, so they must be running the same version.
+	DedupeByContent bool
+
+	// Where to store the last-processed timestamp. Defaults to Redis (see
+	// NewRedisCheckpointStore).
+	CheckpointStore CheckpointStore
+
+	// When to write the last-processed timestamp. Defaults to
+	// FlushEveryInterval. FlushCount is the number of messages between
+	// writes for FlushEveryCount.
+	FlushStrategy FlushStrategy
+	FlushCount    int
+
+	// If true, don't record the timestamp of the last published message. This
+	// is used when republishing old oplog entries, which must not move the
+	// last-processed timestamp backwards.
+	DisableCheckpoint bool
+
+	// How many times to retry publishing a message before giving up on it.
+	// Redis Sentinel takes a while to fail over to a new master, so when
+	// publishing through Sentinel this should cover the failover time.
+	// Defaults to 30. Relay mode uses RelayOpts.MaxOutage instead.
+	MaxRetries int
+
+	// How long to wait between retries, which grows after each failure in a
+	// row, so that an outage doesn't turn into a flood of retries. Defaults
+	// to backoff.Default.
+	RetryBackoff backoff.Backoff
+
+	// If set, publish in relay mode. See RelayOpts.
+	Relay *RelayOpts
+
+	// If set, add publications to Redis Streams instead of publishing them.
+	// See StreamOpts. Ignored in relay mode.
+	Streams *StreamOpts
+
+	// If set, publish with several workers at once. See WorkerOpts. Ignored
+	// in relay mode.
+	Workers *WorkerOpts
+
+	// If set, inject publish failures and latency. See the chaos package.
+	Chaos *chaos.Injector
+
+	// If set, called with each publication that couldn't be published after
+	// retrying (see MaxRetries and RelayOpts.MaxOutage), and the error,
+	// so embedders can record or divert it. It's called from the publishing
+	// goroutine, so it should be fast.
+	OnPublishError func(p *Publication, err error)
 }
 
 // This script checks whether KEYS[1] is set. If it is, it does nothing. It not,
 // it sets the key, using ARGV[1] as the expiration, and then publishes the
-// message ARGV[2] to channels ARGV[3] and ARGV[4].
+// message ARGV[2] to each of the channels ARGV[3] through ARGV[n].
 var publishDedupe = redis.NewScript(`
 	if redis.call("GET", KEYS[1]) == false then
 		redis.call("SETEX", KEYS[1], ARGV[1], 1)
-		redis.call("PUBLISH", ARGV[3], ARGV[2])
-		redis.call("PUBLISH", ARGV[4], ARGV[2])
+		for i = 3, #ARGV do
+			redis.call("PUBLISH", ARGV[i], ARGV[2])
+		end
 	end
 
 	return true
@@ -47,60 +131,96 @@ var metricTemporaryFailures = promauto.NewCounter(prometheus.CounterOpts{
 	Namespace: "otr",
 	Subsystem: "redispub",
 	Name:      "temporary_send_failures",
-	Help:      "Number of failures encountered when trying to send a message. We automatically retry, and only register a permanent failure (in otr_redispub_processed_messages) after 30 failures.",
+	Help:      "Number of failures encountered when trying to send a message. We automatically retry, and only register a permanent failure (in otr_redispub_processed_messages) after running out of retries (30 by default).",
+})
+
+var metricPublishLag = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "publish_lag_seconds",
+	Help:      "The time between an oplog entry being written and its messages being published, outside of relay mode (see otr_redispub_relay_lag_seconds). Oplog timestamps only have a resolution of one second.",
+	Buckets:   []float64{0.5, 1, 1.5, 2, 3, 5, 10, 30, 60, 120, 300},
+})
+
+var metricLastPublishLag = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "last_publish_lag_seconds",
+	Help:      "The time between the most recently published oplog entry being written and its messages being published, in relay mode or not. Oplog timestamps only have a resolution of one second.",
 })
 
+// Records the time between an oplog entry being written and its messages
+// being published
+func observePublishLag(histogram prometheus.Histogram, p *Publication, now time.Time) {
+	lag := now.Sub(mongoTimestampToTime(p.OplogTimestamp)).Seconds()
+	histogram.Observe(lag)
+	metricLastPublishLag.Set(lag)
+}
+
 // PublishStream reads Publications from the given channel and publishes them
 // to Redis.
-func PublishStream(client redis.UniversalClient, in <-chan *Publication, opts *PublishOpts, stop <-chan bool) {
-	// Start up a background goroutine for periodically updating the last-processed
-	// timestamp
-	timestampC := make(chan bson.MongoTimestamp)
-	go periodicallyUpdateTimestamp(client, timestampC, opts)
+//
+// It returns when ctx is cancelled, or when the in channel is closed. In the latter case, it first publishes every
+// Publication remaining in the channel. In both cases, it writes the
+// timestamp of the last Publication it published before returning.
+func PublishStream(ctx context.Context, client redis.UniversalClient, in <-chan *Publication, opts *PublishOpts) {
+	sink := NewRedisSink(client, opts)
+	defer sink.Close()
+
+	if opts.Relay != nil {
+		// Redis expiration is in integer seconds, so we have to convert the
+		// time.Duration
+		dedupeExpirationSeconds := int(opts.DedupeExpiration.Seconds())
+
+		relayPublications(ctx, in, opts.Relay, opts.RetryBackoff, sink.checkpointer.timestamps, opts.OnPublishError, func(batch []*Publication) error {
+			if err := opts.Chaos.PublishError(); err != nil {
+				return err
+			}
+			return publishBatch(batch, client, opts.MetadataPrefix, dedupeExpirationSeconds, opts, opts.Relay.Compress)
+		})
+		return
+	}
 
+	if opts.Workers != nil && opts.Workers.Workers > 1 {
+		publishWithWorkers(ctx, in, sink, opts.Workers, opts.MaxRetries, opts.RetryBackoff, opts.OnPublishError)
+		return
+	}
+
+	publishToSinks(ctx, in, []Sink{sink}, opts.MaxRetries, opts.RetryBackoff, opts.OnPublishError)
+}
+
+// Publish publishes a single Publication to Redis (or adds it to streams, in
+// streams mode), deduplicating it just like PublishStream does. Unlike
+// PublishStream, it doesn't retry, and doesn't record the last-processed
+// timestamp; it's for callers that take care of those themselves. Relay mode
+// isn't supported.
+func Publish(client redis.UniversalClient, p *Publication, opts *PublishOpts) error {
 	// Redis expiration is in integer seconds, so we have to convert the
 	// time.Duration
 	dedupeExpirationSeconds := int(opts.DedupeExpiration.Seconds())
 
-	publishFn := func(p *Publication) error {
-		return publishSingleMessage(p, client, opts.MetadataPrefix, dedupeExpirationSeconds)
+	if opts.Streams != nil {
+		return addToStreams(p, client, opts.MetadataPrefix, dedupeExpirationSeconds, opts)
 	}
 
-	metricSendFailed := metricSentMessages.WithLabelValues("failed")
-	metricSendSuccess := metricSentMessages.WithLabelValues("sent")
-
-	for {
-		select {
-		case <-stop:
-			close(timestampC)
-			return
-
-		case p := <-in:
-			err := publishSingleMessageWithRetries(p, 30, time.Second, publishFn)
-
-			if err != nil {
-				metricSendFailed.Inc()
-				log.Log.Errorw("Permanent error while trying to publish message; giving up",
-					"error", err,
-					"message", p)
-			} else {
-				metricSendSuccess.Inc()
-
-				// We want to make sure we do this *after* we've successfully published
-				// the messages
-				timestampC <- p.OplogTimestamp
-			}
-		}
-	}
+	return publishSingleMessage(p, client, opts.MetadataPrefix, dedupeExpirationSeconds, opts)
 }
 
-func publishSingleMessageWithRetries(p *Publication, maxRetries int, sleepTime time.Duration, publishFn func(p *Publication) error) error {
+// Calls publishFn until it succeeds, up to maxRetries times, waiting longer
+// after each failure. Stops retrying once ctx is cancelled (e.g. because we
+// lost leadership, and must stop publishing before another copy of
+// oplogtoredis takes over).
+func publishSingleMessageWithRetries(ctx context.Context, p *Publication, maxRetries int, retryBackoff backoff.Backoff, publishFn func(p *Publication) error) error {
 	retries := 0
 
 	for retries < maxRetries {
 		err := publishFn(p)
 
 		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("Stopped publishing message after retrying %d times: %s", retries, err)
+			}
+
 			log.Log.Errorw("Error publishing message, will retry",
 				"error", err,
 				"retryNumber", retries)
@@ -108,7 +228,12 @@ func publishSingleMessageWithRetries(p *Publication, maxRetries int, sleepTime t
 			// failure, retry
 			metricTemporaryFailures.Inc()
 			retries++
-			time.Sleep(sleepTime)
+			if retries < maxRetries {
+				select {
+				case <-time.After(retryBackoff.OrDefault().Delay(retries)):
+				case <-ctx.Done():
+				}
+			}
 		} else {
 			// success, return
 			return nil
@@ -118,42 +243,175 @@ func publishSingleMessageWithRetries(p *Publication, maxRetries int, sleepTime t
 	return fmt.Errorf("Failed to send message after retrying %d times", maxRetries)
 }
 
-func publishSingleMessage(p *Publication, client redis.UniversalClient, prefix string, dedupeExpirationSeconds int) error {
+func publishSingleMessage(p *Publication, client redis.UniversalClient, prefix string, dedupeExpirationSeconds int, opts *PublishOpts) error {
 	_, err := publishDedupe.Run(
 		client,
-		[]string{
-			// The key used for deduplication
-			// The oplog timestamp isn't really a timestamp -- it's a 64-bit int
-			// where the first 32 bits are a unix timestamp (seconds since
-			// the epoch), and the next 32 bits are a monotonically-increasing
-			// sequence number for operations within that second. It's
-			// guaranteed-unique, so we can use it for deduplication
-			prefix + "processed::" + encodeMongoTimestamp(p.OplogTimestamp),
-		},
-		dedupeExpirationSeconds, // ARGV[1], expiration time
-		p.Msg,               // ARGV[2], message
-		p.CollectionChannel, // ARGV[3], channel #1
-		p.SpecificChannel,   // ARGV[4], channel #2
+		[]string{publishDedupeKey(p, prefix, opts)},
+		publishArgs(p, p.Msg, dedupeExpirationSeconds, opts)...,
 	).Result()
 
 	return err
 }
 
-// Periodically updates the last-processed-entry timestamp in Redis.
+// Returns the key used for deduplication.
+//
+// The oplog timestamp isn't really a timestamp -- it's a 64-bit int where the
+// first 32 bits are a unix timestamp (seconds since the epoch), and the next
+// 32 bits are a monotonically-increasing sequence number for operations
+// within that second. It's guaranteed-unique, so we can use it for
+// deduplication, along with the DedupeSuffix for entries that produce more
+// than one publication
+func dedupeKey(p *Publication, prefix string) string {
+	key := ShardMetadataPrefix(prefix, p.Shard) + "processed::" + encodeMongoTimestamp(p.OplogTimestamp)
+	if p.DedupeSuffix != "" {
+		key += "::" + p.DedupeSuffix
+	}
+
+	return key
+}
+
+// Returns the key used for deduplicating a publication published with opts.
+// If opts.DedupeByContent is set, it includes a hash of the message and the
+// channels it's published to, so that copies of oplogtoredis that publish
+// different messages for an entry (because they're configured differently)
+// don't deduplicate each other's messages.
+func publishDedupeKey(p *Publication, prefix string, opts *PublishOpts) string {
+	key := dedupeKey(p, prefix)
+	if !opts.DedupeByContent {
+		return key
+	}
+
+	hash := sha256.New()
+	_, _ = hash.Write(p.Msg)
+	for _, channel := range publicationChannels(p, opts, true) {
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(channel))
+	}
+
+	return key + "::" + hex.EncodeToString(hash.Sum(nil)[:8])
+}
+
+// Returns the ARGV for the publishDedupe script: the expiration time, the
+// message, and then the channels to publish the message to
+func publishArgs(p *Publication, msg []byte, dedupeExpirationSeconds int, opts *PublishOpts) []interface{} {
+	channels := publicationChannels(p, opts, true)
+
+	args := make([]interface{}, 0, 2+len(channels))
+	args = append(args, dedupeExpirationSeconds, msg)
+	for _, channel := range channels {
+		args = append(args, channel)
+	}
+
+	return args
+}
+
+// Returns the channels to publish a publication to: the collection channels,
+// the specific channel (if includeSpecific is set), the extra channels, and
+// the global channel, once per channel prefix. Meta publications (like dead
+// letters) only go to their collection channel.
+func publicationChannels(p *Publication, opts *PublishOpts, includeSpecific bool) []string {
+	channelPrefixes := opts.ChannelPrefixes
+	if len(channelPrefixes) == 0 {
+		channelPrefixes = []string{""}
+	}
+
+	if p.Meta {
+		channels := make([]string, 0, len(channelPrefixes))
+		for _, channelPrefix := range channelPrefixes {
+			channels = append(channels, channelPrefix+p.CollectionChannel)
+		}
+
+		return channels
+	}
+
+	customChannels, hasCustomChannels := opts.CollectionChannels[p.CollectionChannel]
+
+	channels := make([]string, 0, (len(customChannels)+len(p.ExtraChannels)+3)*len(channelPrefixes))
+
+	for _, channelPrefix := range channelPrefixes {
+		if hasCustomChannels {
+			for _, channel := range customChannels {
+				channels = append(channels, channelPrefix+channel)
+			}
+		} else {
+			channels = append(channels, opts.ChannelTemplates.collectionChannel(p, channelPrefix))
+		}
+
+		if includeSpecific {
+			channels = append(channels, opts.ChannelTemplates.specificChannel(p, channelPrefix))
+		}
+
+		for _, channel := range p.ExtraChannels {
+			channels = append(channels, channelPrefix+channel)
+		}
+
+		if opts.GlobalChannel != "" {
+			channels = append(channels, channelPrefix+opts.GlobalChannel)
+		}
+	}
+
+	return channels
+}
+
+// A position in the oplog (of a shard, for sharded clusters) to record as
+// processed
+type checkpoint struct {
+	ts          bson.MongoTimestamp
+	resumeToken string
+	shard       string
+}
+
+func publicationCheckpoint(p *Publication) checkpoint {
+	return checkpoint{ts: p.OplogTimestamp, resumeToken: p.ResumeToken, shard: p.Shard}
+}
+
+// Returns where to store the last-processed timestamp: opts.CheckpointStore,
+// or Redis, through client
+func (opts *PublishOpts) checkpointStore(client redis.UniversalClient) CheckpointStore {
+	if opts.CheckpointStore != nil {
+		return opts.CheckpointStore
+	}
+
+	return NewRedisCheckpointStore(client)
+}
+
+// Periodically updates the last-processed-entry timestamp in Redis (or
+// opts.CheckpointStore).
 // PublishStream sends the timestamp for *every* entry it processes to the
-// channel, and this function throttles that to only update occasionally.
+// channel, and this function throttles that to only update occasionally, per
+// opts.FlushStrategy.
 //
-// This blocks forever; it should be run in a goroutine
-func periodicallyUpdateTimestamp(client redis.UniversalClient, timestamps <-chan bson.MongoTimestamp, opts *PublishOpts) {
+// This blocks until the timestamps channel is closed; it should be run in a
+// goroutine. Change stream resume tokens are written along with the timestamp
+// they belong to (see LastResumeToken), and each shard's timestamp is written
+// under its own prefix (see ShardMetadataPrefix).
+func periodicallyUpdateTimestamp(client redis.UniversalClient, timestamps <-chan checkpoint, opts *PublishOpts) {
+	store := opts.checkpointStore(client)
+
 	var lastFlush time.Time
-	var mostRecentTimestamp bson.MongoTimestamp
+	mostRecent := map[string]checkpoint{}
 	var needFlush bool
+	var unflushed int
 
 	flush := func() {
-		if needFlush {
-			client.Set(opts.MetadataPrefix+"lastProcessedEntry", encodeMongoTimestamp(mostRecentTimestamp), 0)
+		if needFlush && !opts.DisableCheckpoint {
+			for shard, c := range mostRecent {
+				prefix := ShardMetadataPrefix(opts.MetadataPrefix, shard)
+				err := store.SaveCheckpoint(prefix, c.ts, c.resumeToken)
+				if err != nil {
+					log.Log.Errorw("Error saving last-processed timestamp",
+						"error", err,
+						"shard", shard)
+					continue
+				}
+
+				metricCheckpointWrites.Inc()
+				metricCheckpointTimestamp.WithLabelValues(shard).Set(float64(mongoTimestampToTime(c.ts).Unix()))
+			}
+			mostRecent = map[string]checkpoint{}
 			lastFlush = time.Now()
 			needFlush = false
+			unflushed = 0
 		}
 	}
 
@@ -161,15 +419,26 @@ func periodicallyUpdateTimestamp(client redis.UniversalClient, timestamps <-chan
 		select {
 		case timestamp, ok := <-timestamps:
 			if !ok {
-				// channel got closed
+				// channel got closed; write out the final timestamp
+				flush()
 				return
 			}
 
-			mostRecentTimestamp = timestamp
+			mostRecent[timestamp.shard] = timestamp
 			needFlush = true
+			unflushed++
 
-			if time.Since(lastFlush) > opts.FlushInterval {
-				flush()
+			switch opts.FlushStrategy {
+			case FlushEveryCount:
+				if unflushed >= opts.FlushCount {
+					flush()
+				}
+			case FlushOnIdle:
+				// Wait until no messages arrive for a whole FlushInterval
+			default:
+				if time.Since(lastFlush) > opts.FlushInterval {
+					flush()
+				}
 			}
 		case <-time.After(opts.FlushInterval):
 			if needFlush {
@@ -1,7 +1,10 @@
 package redispub
 
 import (
+	"context"
 	"errors"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -9,12 +12,16 @@ import (
 	"github.com/alicebob/miniredis"
 	"github.com/globalsign/mgo/bson"
 	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
 )
 
 // We don't test PublishStream here -- it requires a real Redis server because
 // miniredis doesn't support PUBLISH and its lua support is spotty. It gets
 // tested in integration tests.
 
+// Retries right away, so tests of retrying don't wait
+var noBackoff = backoff.Backoff{Initial: time.Nanosecond, Max: time.Nanosecond}
+
 func TestPublishSingleMessageWithRetriesImmediateSuccess(t *testing.T) {
 	publication := &Publication{
 		CollectionChannel: "a",
@@ -34,7 +41,7 @@ func TestPublishSingleMessageWithRetriesImmediateSuccess(t *testing.T) {
 		return nil
 	}
 
-	err := publishSingleMessageWithRetries(publication, 30, time.Second, publishFn)
+	err := publishSingleMessageWithRetries(context.Background(), publication, 30, backoff.Backoff{}, publishFn)
 
 	if err != nil {
 		t.Errorf("Got unexpected error: %s", err)
@@ -69,7 +76,7 @@ func TestPublishSingleMessageWithRetriesTransientFailure(t *testing.T) {
 		return nil
 	}
 
-	err := publishSingleMessageWithRetries(publication, 30, 0, publishFn)
+	err := publishSingleMessageWithRetries(context.Background(), publication, 30, noBackoff, publishFn)
 
 	if err != nil {
 		t.Errorf("Got unexpected error: %s", err)
@@ -88,7 +95,7 @@ func TestPublishSingleMessageWithRetriesPermanentFailure(t *testing.T) {
 		return errors.New("Some error")
 	}
 
-	err := publishSingleMessageWithRetries(publication, 30, 0, publishFn)
+	err := publishSingleMessageWithRetries(context.Background(), publication, 30, noBackoff, publishFn)
 
 	if err == nil {
 		t.Errorf("Expected an error, but didn't get one")
@@ -96,6 +103,184 @@ func TestPublishSingleMessageWithRetriesPermanentFailure(t *testing.T) {
 		t.Errorf("Got wrong error: %s", err)
 	}
 }
+
+func TestPublishSingleMessageWithRetriesBackoff(t *testing.T) {
+	var attempts []time.Time
+	publishFn := func(p *Publication) error {
+		attempts = append(attempts, time.Now())
+		return errors.New("Some error")
+	}
+
+	retryBackoff := backoff.Backoff{Initial: 10 * time.Millisecond, Max: 40 * time.Millisecond}
+	_ = publishSingleMessageWithRetries(context.Background(), &Publication{}, 5, retryBackoff, publishFn)
+
+	if len(attempts) != 5 {
+		t.Fatalf("Expected 5 attempts, got %d", len(attempts))
+	}
+
+	// 10ms, 20ms, 40ms, and then 40ms again, with nothing after the last
+	// attempt
+	for i, expected := range []time.Duration{10, 20, 40, 40} {
+		if gap := attempts[i+1].Sub(attempts[i]); gap < expected*time.Millisecond {
+			t.Errorf("Expected at least %dms before attempt %d, got %s", expected, i+2, gap)
+		}
+	}
+}
+
+func TestPublishSingleMessageWithRetriesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	callCount := 0
+	publishFn := func(p *Publication) error {
+		callCount++
+		return errors.New("Some error")
+	}
+
+	start := time.Now()
+	err := publishSingleMessageWithRetries(ctx, &Publication{}, 30, backoff.Backoff{Initial: time.Second}, publishFn)
+
+	if err == nil {
+		t.Errorf("Expected an error, but didn't get one")
+	}
+
+	if callCount != 1 {
+		t.Errorf("Expected a single attempt after cancellation, got %d", callCount)
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Kept retrying for %s after cancellation", time.Since(start))
+	}
+}
+
+func TestPublishArgs(t *testing.T) {
+	publication := &Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+		Msg:               []byte("asdf"),
+		OplogTimestamp:    bson.MongoTimestamp(0),
+	}
+
+	tests := map[string]struct {
+		channelPrefixes    []string
+		collectionChannels map[string][]string
+		globalChannel      string
+		want               []interface{}
+	}{
+		"No prefixes": {
+			want: []interface{}{120, publication.Msg, "foo.bar", "foo.bar::someid"},
+		},
+		"Single prefix": {
+			channelPrefixes: []string{"old."},
+			want:            []interface{}{120, publication.Msg, "old.foo.bar", "old.foo.bar::someid"},
+		},
+		"Tee prefixes": {
+			channelPrefixes: []string{"", "new."},
+			want: []interface{}{120, publication.Msg,
+				"foo.bar", "foo.bar::someid",
+				"new.foo.bar", "new.foo.bar::someid"},
+		},
+		"Custom collection channels": {
+			collectionChannels: map[string][]string{
+				"foo.bar":   {"foo.ns::bar", "foo.custom"},
+				"foo.other": {"foo.unused"},
+			},
+			want: []interface{}{120, publication.Msg, "foo.ns::bar", "foo.custom", "foo.bar::someid"},
+		},
+		"Custom collection channels for another collection": {
+			collectionChannels: map[string][]string{
+				"foo.other": {"foo.unused"},
+			},
+			want: []interface{}{120, publication.Msg, "foo.bar", "foo.bar::someid"},
+		},
+		"Custom collection channels with prefixes": {
+			channelPrefixes: []string{"", "new."},
+			collectionChannels: map[string][]string{
+				"foo.bar": {"foo.custom"},
+			},
+			want: []interface{}{120, publication.Msg,
+				"foo.custom", "foo.bar::someid",
+				"new.foo.custom", "new.foo.bar::someid"},
+		},
+		"Global channel": {
+			globalChannel: "firehose",
+			want:          []interface{}{120, publication.Msg, "foo.bar", "foo.bar::someid", "firehose"},
+		},
+		"Global channel with prefixes": {
+			channelPrefixes: []string{"", "new."},
+			globalChannel:   "firehose",
+			want: []interface{}{120, publication.Msg,
+				"foo.bar", "foo.bar::someid", "firehose",
+				"new.foo.bar", "new.foo.bar::someid", "new.firehose"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got := publishArgs(publication, publication.Msg, 120, &PublishOpts{
+				ChannelPrefixes:    test.channelPrefixes,
+				CollectionChannels: test.collectionChannels,
+				GlobalChannel:      test.globalChannel,
+			})
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("publishArgs() = %#v, wanted %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDedupeKey(t *testing.T) {
+	publication := &Publication{OplogTimestamp: bson.MongoTimestamp(1234)}
+	if got := dedupeKey(publication, "someprefix::"); got != "someprefix::processed::1234" {
+		t.Errorf("dedupeKey() = %q, wanted %q", got, "someprefix::processed::1234")
+	}
+
+	publication.DedupeSuffix = "foo.bar::someid"
+	if got := dedupeKey(publication, "someprefix::"); got != "someprefix::processed::1234::foo.bar::someid" {
+		t.Errorf("dedupeKey() = %q, wanted %q", got, "someprefix::processed::1234::foo.bar::someid")
+	}
+
+	publication = &Publication{OplogTimestamp: bson.MongoTimestamp(1234), Shard: "shard0"}
+	if got := dedupeKey(publication, "someprefix::"); got != "someprefix::shard::shard0::processed::1234" {
+		t.Errorf("dedupeKey() = %q, wanted %q", got, "someprefix::shard::shard0::processed::1234")
+	}
+}
+
+func TestPublishDedupeKey(t *testing.T) {
+	publication := &Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+		Msg:               []byte("asdf"),
+		OplogTimestamp:    bson.MongoTimestamp(1234),
+	}
+
+	if got := publishDedupeKey(publication, "someprefix::", &PublishOpts{}); got != "someprefix::processed::1234" {
+		t.Errorf("publishDedupeKey() = %q without DedupeByContent, wanted the plain dedupe key", got)
+	}
+
+	opts := &PublishOpts{DedupeByContent: true}
+	key := publishDedupeKey(publication, "someprefix::", opts)
+	if !strings.HasPrefix(key, "someprefix::processed::1234::") || len(key) != len("someprefix::processed::1234::")+16 {
+		t.Errorf("publishDedupeKey() = %q, wanted the plain dedupe key with a hash", key)
+	}
+
+	if got := publishDedupeKey(publication, "someprefix::", opts); got != key {
+		t.Errorf("publishDedupeKey() = %q the second time, wanted %q", got, key)
+	}
+
+	prefixed := publishDedupeKey(publication, "someprefix::", &PublishOpts{DedupeByContent: true, ChannelPrefixes: []string{"new."}})
+	if prefixed == key {
+		t.Errorf("publishDedupeKey() didn't change with the channels")
+	}
+
+	changed := *publication
+	changed.Msg = []byte("fdsa")
+	if got := publishDedupeKey(&changed, "someprefix::", opts); got == key {
+		t.Errorf("publishDedupeKey() didn't change with the message")
+	}
+}
+
 func TestPeriodicallyUpdateTimestamp(t *testing.T) {
 	// The code under test operates at a configurable speed (for things like
 	// periodic flushing). Adjusting this value controls that speed. Making it
@@ -116,7 +301,7 @@ func TestPeriodicallyUpdateTimestamp(t *testing.T) {
 	})
 
 	// Start up the periodic updater
-	timestampC := make(chan bson.MongoTimestamp)
+	timestampC := make(chan checkpoint)
 	waitGroup := sync.WaitGroup{}
 	waitGroup.Add(1)
 
@@ -136,7 +321,7 @@ func TestPeriodicallyUpdateTimestamp(t *testing.T) {
 	}
 
 	// Write something
-	timestampC <- bson.MongoTimestamp(1)
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(1)}
 	time.Sleep(testSpeed / 4) // t = 0.25
 
 	// Key should be set
@@ -144,14 +329,14 @@ func TestPeriodicallyUpdateTimestamp(t *testing.T) {
 
 	// Wait less FlushInterval and write something
 	time.Sleep(testSpeed / 2) // t = 0.75
-	timestampC <- bson.MongoTimestamp(2)
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(2)}
 
 	// Key should not have updated
 	redisServer.CheckGet(t, key, "1")
 
 	// Wait FlushInterval and write something
 	time.Sleep(testSpeed / 2) // t = 1.25
-	timestampC <- bson.MongoTimestamp(3)
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(3)}
 	time.Sleep(testSpeed / 4) // t = 1.5
 
 	// Key should have been updated
@@ -159,7 +344,7 @@ func TestPeriodicallyUpdateTimestamp(t *testing.T) {
 
 	// Wait less than FlushInterval and write something
 	time.Sleep(testSpeed / 4) // t = 1.75
-	timestampC <- bson.MongoTimestamp(4)
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(4)}
 
 	// Key should not have been updated (making sure that when it *was* updated, we reset the timer)
 	redisServer.CheckGet(t, key, "3")
@@ -174,3 +359,144 @@ func TestPeriodicallyUpdateTimestamp(t *testing.T) {
 	close(timestampC)
 	waitGroup.Wait()
 }
+
+func TestPeriodicallyUpdateTimestampResumeToken(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	timestampC := make(chan checkpoint)
+	done := make(chan bool)
+	go func() {
+		periodicallyUpdateTimestamp(redisClient, timestampC, &PublishOpts{
+			MetadataPrefix: "someprefix.",
+			FlushInterval:  time.Hour,
+		})
+		close(done)
+	}()
+
+	timestampC <- publicationCheckpoint(&Publication{
+		OplogTimestamp: bson.MongoTimestamp(1234),
+		ResumeToken:    "826a3e",
+	})
+	close(timestampC)
+	<-done
+
+	redisServer.CheckGet(t, "someprefix.lastProcessedEntry", "1234")
+	redisServer.CheckGet(t, "someprefix.lastResumeToken", "1234:826a3e")
+}
+
+func TestPeriodicallyUpdateTimestampShards(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	timestampC := make(chan checkpoint)
+	done := make(chan bool)
+	go func() {
+		periodicallyUpdateTimestamp(redisClient, timestampC, &PublishOpts{
+			MetadataPrefix: "someprefix.",
+			FlushInterval:  time.Hour,
+		})
+		close(done)
+	}()
+
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(1), shard: "shard0"}
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(2), shard: "shard1"}
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(3), shard: "shard0"}
+	close(timestampC)
+	<-done
+
+	redisServer.CheckGet(t, "someprefix.shard::shard0::lastProcessedEntry", "3")
+	redisServer.CheckGet(t, "someprefix.shard::shard1::lastProcessedEntry", "2")
+	if redisServer.Exists("someprefix.lastProcessedEntry") {
+		t.Errorf("Expected no unsharded last-processed timestamp")
+	}
+}
+
+func TestPeriodicallyUpdateTimestampEveryCount(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	timestampC := make(chan checkpoint)
+	done := make(chan bool)
+	go func() {
+		periodicallyUpdateTimestamp(redisClient, timestampC, &PublishOpts{
+			MetadataPrefix: "someprefix.",
+			FlushInterval:  time.Hour,
+			FlushStrategy:  FlushEveryCount,
+			FlushCount:     3,
+		})
+		close(done)
+	}()
+
+	key := "someprefix.lastProcessedEntry"
+
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(1)}
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(2)}
+	if redisServer.Exists(key) {
+		t.Errorf("Key existed before the third message")
+	}
+
+	// The channel is unbuffered, so once the fourth message is received, the
+	// third has been flushed
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(3)}
+	timestampC <- checkpoint{ts: bson.MongoTimestamp(4)}
+	redisServer.CheckGet(t, key, "3")
+
+	close(timestampC)
+	<-done
+	redisServer.CheckGet(t, key, "4")
+}
+
+func TestPeriodicallyUpdateTimestampOnIdle(t *testing.T) {
+	var testSpeed = 200 * time.Millisecond
+
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	timestampC := make(chan checkpoint)
+	done := make(chan bool)
+	go func() {
+		periodicallyUpdateTimestamp(redisClient, timestampC, &PublishOpts{
+			MetadataPrefix: "someprefix.",
+			FlushInterval:  testSpeed,
+			FlushStrategy:  FlushOnIdle,
+		})
+		close(done)
+	}()
+
+	key := "someprefix.lastProcessedEntry"
+
+	// Messages arriving more often than FlushInterval aren't flushed, even
+	// though it's been longer than FlushInterval since the last flush
+	for i := 1; i <= 5; i++ {
+		timestampC <- checkpoint{ts: bson.MongoTimestamp(i)}
+		time.Sleep(testSpeed / 2)
+	}
+	if redisServer.Exists(key) {
+		t.Errorf("Key existed before going idle")
+	}
+
+	time.Sleep(testSpeed * 2)
+	redisServer.CheckGet(t, key, "5")
+
+	close(timestampC)
+	<-done
+}
+
+func TestPublicationChannelsDeadLetter(t *testing.T) {
+	p := &Publication{
+		CollectionChannel: "deadletters",
+		ExtraChannels:     []string{"extra"},
+		Meta:              true,
+	}
+	opts := &PublishOpts{
+		ChannelPrefixes: []string{"", "new."},
+		GlobalChannel:   "firehose",
+	}
+
+	got := publicationChannels(p, opts, true)
+	want := []string{"deadletters", "new.deadletters"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("publicationChannels() = %#v, want %#v", got, want)
+	}
+}
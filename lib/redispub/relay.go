@@ -0,0 +1,301 @@
+package redispub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/tracing"
+)
+
+// RelayOpts configures relay mode, which is optimized for publishing to a
+// Redis server in another region. Instead of sending each publication in its
+// own round trip, publications are collected into batches that are sent in a
+// single pipeline, optionally compressed, and retried for long enough to ride
+// out brief WAN outages.
+type RelayOpts struct {
+	// Maximum number of publications to send in a single pipeline
+	BatchSize int
+
+	// Maximum time to wait for a batch to fill up before sending it
+	BatchWindow time.Duration
+
+	// Whether to gzip message payloads. Consumers must decompress messages
+	// when this is enabled.
+	Compress bool
+
+	// How long to keep retrying a batch before giving up on it
+	MaxOutage time.Duration
+}
+
+var metricRelayLag = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "relay_lag_seconds",
+	Help:      "In relay mode (or with OTR_PUBLISH_BATCH_SIZE), the time between an oplog entry being written and it being published to Redis",
+	Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+})
+
+var metricRelayBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "relay_batch_size",
+	Help:      "In relay mode (or with OTR_PUBLISH_BATCH_SIZE), the number of publications sent in each pipeline",
+	Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+})
+
+// Reads publications from the input channel, collects them into batches,
+// and sends each batch with publishFn, retrying with retryBackoff. If a
+// batch can't be sent, onError (if it's set) is called with each of its
+// publications. Returns when ctx is cancelled or when the input channel is
+// closed.
+//
+// ctx is cancelled when the shutdown timeout passes, so once it's cancelled
+// we stop retrying: the batch we're sending (or the final batch) gets one
+// more attempt, and whatever's left unsent is logged and left to be re-read
+// from the oplog.
+func relayPublications(ctx context.Context, in <-chan *Publication, opts *RelayOpts, retryBackoff backoff.Backoff, timestampC chan<- checkpoint, onError func(*Publication, error), publishFn func([]*Publication) error) {
+	metricSendFailed := metricSentMessages.WithLabelValues("failed")
+	metricSendSuccess := metricSentMessages.WithLabelValues("sent")
+
+	batch := make([]*Publication, 0, opts.BatchSize)
+	var windowC <-chan time.Time
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		metricRelayBatchSize.Observe(float64(len(batch)))
+
+		spans := traceBatch(batch, "publish")
+		err := publishBatchWithRetries(ctx, batch, opts.MaxOutage, retryBackoff, publishFn)
+		for _, span := range spans {
+			span.SetError(err)
+			span.End()
+		}
+
+		if err != nil {
+			metricSendFailed.Add(float64(len(batch)))
+			if ctx.Err() != nil {
+				log.Log.Errorw("Shutting down without relaying batch; it will be re-read from the oplog",
+					"error", err,
+					"unsent", len(batch),
+					"firstTimestamp", batch[0].OplogTimestamp,
+					"lastTimestamp", batch[len(batch)-1].OplogTimestamp)
+			} else {
+				log.Log.Errorw("Permanent error while trying to relay batch; giving up",
+					"error", err,
+					"batchSize", len(batch),
+					"firstTimestamp", batch[0].OplogTimestamp,
+					"lastTimestamp", batch[len(batch)-1].OplogTimestamp)
+			}
+
+			if onError != nil {
+				for _, p := range batch {
+					onError(p, err)
+				}
+			}
+		} else {
+			metricSendSuccess.Add(float64(len(batch)))
+
+			now := time.Now()
+			for _, p := range batch {
+				observePublishLag(metricRelayLag, p, now)
+			}
+
+			spans = traceBatch(batch, "checkpoint")
+			for _, c := range batchCheckpoints(batch) {
+				timestampC <- c
+			}
+			for _, span := range spans {
+				span.End()
+			}
+		}
+
+		batch = make([]*Publication, 0, opts.BatchSize)
+		windowC = nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Send whatever we've already collected before we stop
+			flush()
+			return
+
+		case p, ok := <-in:
+			if !ok {
+				// The input channel was closed; send the final batch
+				flush()
+				return
+			}
+
+			batch = append(batch, p)
+
+			if len(batch) == 1 {
+				windowC = time.After(opts.BatchWindow)
+			}
+
+			if len(batch) >= opts.BatchSize {
+				flush()
+			}
+
+		case <-windowC:
+			flush()
+		}
+	}
+}
+
+// Starts a span for each traced publication in a batch, since they're all
+// published (or checkpointed) together
+func traceBatch(batch []*Publication, name string) []*tracing.Span {
+	var spans []*tracing.Span
+	for _, p := range batch {
+		if span := p.Trace.Child(name); span != nil {
+			span.SetAttribute("otr.batch_size", len(batch))
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+// Calls publishFn until it succeeds, until maxOutage has elapsed since the
+// first attempt, or until ctx is cancelled, waiting longer after each failure.
+// publishFn is always called at least once, even if ctx is already cancelled.
+func publishBatchWithRetries(ctx context.Context, batch []*Publication, maxOutage time.Duration, retryBackoff backoff.Backoff, publishFn func([]*Publication) error) error {
+	start := time.Now()
+	retries := 0
+
+	for {
+		err := publishFn(batch)
+		if err == nil {
+			return nil
+		}
+
+		if time.Since(start) >= maxOutage {
+			return fmt.Errorf("Failed to relay batch after retrying %d times over %s: %s",
+				retries, maxOutage, err)
+		}
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("Stopped relaying batch after retrying %d times: %s",
+				retries, err)
+		}
+
+		log.Log.Errorw("Error relaying batch, will retry",
+			"error", err,
+			"retryNumber", retries)
+
+		metricTemporaryFailures.Inc()
+		retries++
+
+		// Make the last attempt at the end of the outage, rather than
+		// sleeping past it
+		delay := retryBackoff.OrDefault().Delay(retries)
+		if remaining := maxOutage - time.Since(start); delay > remaining {
+			delay = remaining
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Sends a batch of publications in a single pipeline
+func publishBatch(batch []*Publication, client redis.UniversalClient, prefix string, dedupeExpirationSeconds int, opts *PublishOpts, compress bool) error {
+	msgs := make([][]byte, len(batch))
+	for i, p := range batch {
+		msgs[i] = p.Msg
+
+		if compress {
+			compressed, err := gzipMessage(p.Msg)
+			if err != nil {
+				return err
+			}
+			msgs[i] = compressed
+		}
+	}
+
+	exec := func() error {
+		pipe := client.Pipeline()
+		defer pipe.Close()
+
+		for i, p := range batch {
+			publishDedupe.EvalSha(
+				pipe,
+				[]string{publishDedupeKey(p, prefix, opts)},
+				publishArgs(p, msgs[i], dedupeExpirationSeconds, opts)...,
+			)
+		}
+
+		_, err := pipe.Exec()
+		return err
+	}
+
+	err := exec()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		// We use EVALSHA rather than EVAL so we don't send the script over the
+		// WAN with every message. If the remote server doesn't have the script
+		// cached yet, load it and try again.
+		err = publishDedupe.Load(client).Err()
+		if err != nil {
+			return err
+		}
+
+		err = exec()
+	}
+
+	return err
+}
+
+// Compresses a message with gzip
+func gzipMessage(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+
+	_, err := writer.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Returns the checkpoints for a published batch: the last publication of
+// each shard (or just the last publication, for unsharded clusters) that
+// ends its oplog entry (see Publication.MoreInEntry)
+func batchCheckpoints(batch []*Publication) []checkpoint {
+	var checkpoints []checkpoint
+	seen := map[string]bool{}
+
+	for i := len(batch) - 1; i >= 0; i-- {
+		if batch[i].MoreInEntry {
+			continue
+		}
+
+		if !seen[batch[i].Shard] {
+			seen[batch[i].Shard] = true
+			checkpoints = append(checkpoints, publicationCheckpoint(batch[i]))
+		}
+	}
+
+	return checkpoints
+}
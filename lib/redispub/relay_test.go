@@ -0,0 +1,253 @@
+package redispub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+)
+
+func TestRelayPublicationsBatching(t *testing.T) {
+	in := make(chan *Publication)
+	ctx, cancel := context.WithCancel(context.Background())
+	timestampC := make(chan checkpoint, 100)
+
+	var mutex sync.Mutex
+	var batchSizes []int
+	publishFn := func(batch []*Publication) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		batchSizes = append(batchSizes, len(batch))
+		return nil
+	}
+
+	done := make(chan bool)
+	go func() {
+		relayPublications(ctx, in, &RelayOpts{
+			BatchSize:   3,
+			BatchWindow: 50 * time.Millisecond,
+			MaxOutage:   time.Second,
+		}, noBackoff, timestampC, nil, publishFn)
+		done <- true
+	}()
+
+	// 4 publications should produce one full batch of 3 right away, and then
+	// a batch of 1 when the window closes
+	for i := 1; i <= 4; i++ {
+		in <- &Publication{OplogTimestamp: bson.MongoTimestamp(i)}
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	// 1 more publication should be flushed on stop
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(5)}
+	cancel()
+	<-done
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(batchSizes) != 3 || batchSizes[0] != 3 || batchSizes[1] != 1 || batchSizes[2] != 1 {
+		t.Errorf("Expected batches of sizes [3 1 1], got %v", batchSizes)
+	}
+
+	close(timestampC)
+	var timestamps []bson.MongoTimestamp
+	for c := range timestampC {
+		timestamps = append(timestamps, c.ts)
+	}
+
+	if len(timestamps) != 3 || timestamps[0] != 3 || timestamps[1] != 4 || timestamps[2] != 5 {
+		t.Errorf("Expected checkpoint timestamps [3 4 5], got %v", timestamps)
+	}
+}
+
+func TestRelayPublicationsOnError(t *testing.T) {
+	in := make(chan *Publication, 2)
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(1)}
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(2)}
+	close(in)
+
+	var failed []bson.MongoTimestamp
+	onError := func(p *Publication, err error) {
+		failed = append(failed, p.OplogTimestamp)
+	}
+
+	relayPublications(context.Background(), in, &RelayOpts{
+		BatchSize:   10,
+		BatchWindow: time.Second,
+		MaxOutage:   0,
+	}, noBackoff, make(chan checkpoint, 10), onError, func(batch []*Publication) error {
+		return errors.New("Some error")
+	})
+
+	if len(failed) != 2 || failed[0] != 1 || failed[1] != 2 {
+		t.Errorf("Expected failed publications [1 2], got %v", failed)
+	}
+}
+
+func TestPublishBatchWithRetriesTransientFailure(t *testing.T) {
+	callCount := 0
+	publishFn := func(batch []*Publication) error {
+		callCount++
+		if callCount < 5 {
+			return errors.New("Some error")
+		}
+		return nil
+	}
+
+	err := publishBatchWithRetries(context.Background(), nil, time.Second, noBackoff, publishFn)
+	if err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+
+	if callCount != 5 {
+		t.Errorf("Expected callCount 5, got %d", callCount)
+	}
+}
+
+func TestPublishBatchWithRetriesPermanentFailure(t *testing.T) {
+	publishFn := func(batch []*Publication) error {
+		return errors.New("Some error")
+	}
+
+	start := time.Now()
+	err := publishBatchWithRetries(context.Background(), nil, 50*time.Millisecond, backoff.Backoff{Initial: 10 * time.Millisecond}, publishFn)
+
+	if err == nil {
+		t.Errorf("Expected an error, but didn't get one")
+	}
+
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Gave up after %s, before MaxOutage elapsed", time.Since(start))
+	}
+}
+
+func TestPublishBatchWithRetriesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	callCount := 0
+	publishFn := func(batch []*Publication) error {
+		callCount++
+		return errors.New("Some error")
+	}
+
+	start := time.Now()
+	err := publishBatchWithRetries(ctx, nil, time.Minute, backoff.Backoff{Initial: time.Second}, publishFn)
+
+	if err == nil {
+		t.Errorf("Expected an error, but didn't get one")
+	}
+
+	if callCount != 1 {
+		t.Errorf("Expected a single attempt after cancellation, got %d", callCount)
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Kept retrying for %s after cancellation", time.Since(start))
+	}
+}
+
+func TestRelayPublicationsShutdownDeadline(t *testing.T) {
+	in := make(chan *Publication)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var mutex sync.Mutex
+	var failed []bson.MongoTimestamp
+	onError := func(p *Publication, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		failed = append(failed, p.OplogTimestamp)
+	}
+
+	done := make(chan bool)
+	go func() {
+		relayPublications(ctx, in, &RelayOpts{
+			BatchSize:   10,
+			BatchWindow: time.Hour,
+			MaxOutage:   time.Hour,
+		}, backoff.Backoff{Initial: time.Second}, make(chan checkpoint, 10), onError, func(batch []*Publication) error {
+			return errors.New("Some error")
+		})
+		done <- true
+	}()
+
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(1)}
+	cancel()
+
+	// The final flush must give up at the shutdown deadline, rather than
+	// retrying for MaxOutage
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relayPublications didn't return after ctx was cancelled")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(failed) != 1 || failed[0] != 1 {
+		t.Errorf("Expected failed publications [1], got %v", failed)
+	}
+}
+
+func TestGzipMessage(t *testing.T) {
+	msg := []byte(`{"e":"i","d":{"_id":"someid"},"f":["some"]}`)
+
+	compressed, err := gzipMessage(msg)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Could not read gzip header: %s", err)
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Could not decompress: %s", err)
+	}
+
+	if !bytes.Equal(decompressed, msg) {
+		t.Errorf("Decompressed message %s did not match original %s", decompressed, msg)
+	}
+}
+
+func TestBatchCheckpoints(t *testing.T) {
+	batch := []*Publication{
+		{OplogTimestamp: bson.MongoTimestamp(1), Shard: "shard0"},
+		{OplogTimestamp: bson.MongoTimestamp(1), Shard: "shard1"},
+		{OplogTimestamp: bson.MongoTimestamp(2), Shard: "shard0"},
+	}
+
+	checkpoints := batchCheckpoints(batch)
+	if len(checkpoints) != 2 {
+		t.Fatalf("Expected 2 checkpoints, got %v", checkpoints)
+	}
+	if checkpoints[0] != (checkpoint{ts: 2, shard: "shard0"}) || checkpoints[1] != (checkpoint{ts: 1, shard: "shard1"}) {
+		t.Errorf("Expected the last publication of each shard, got %v", checkpoints)
+	}
+
+	checkpoints = batchCheckpoints([]*Publication{{OplogTimestamp: 1}, {OplogTimestamp: 2}})
+	if len(checkpoints) != 1 || checkpoints[0].ts != 2 {
+		t.Errorf("Expected just the last publication, got %v", checkpoints)
+	}
+
+	// A batch that ends partway through an entry checkpoints the entry before
+	checkpoints = batchCheckpoints([]*Publication{{OplogTimestamp: 1}, {OplogTimestamp: 2, MoreInEntry: true}})
+	if len(checkpoints) != 1 || checkpoints[0].ts != 1 {
+		t.Errorf("Expected the last publication that ends an entry, got %v", checkpoints)
+	}
+
+	checkpoints = batchCheckpoints([]*Publication{{OplogTimestamp: 2, MoreInEntry: true}})
+	if len(checkpoints) != 0 {
+		t.Errorf("Expected no checkpoints, got %v", checkpoints)
+	}
+}
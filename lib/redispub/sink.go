@@ -0,0 +1,154 @@
+package redispub
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// Sink is a destination for publications. PublishToSinks delivers every
+// publication to each of its sinks, which lets oplogtoredis write to more
+// than one place at once -- for example, to both an old Redis server and a
+// new Redis Cluster while migrating between them.
+type Sink interface {
+	// Publish delivers a single publication. It's retried if it returns an
+	// error, so it must handle being called more than once with the same
+	// publication.
+	Publish(p *Publication) error
+
+	// Checkpoint records that p, and every publication from the same shard
+	// before it, has been delivered, so that oplogtoredis can resume after
+	// it when it restarts. It's called after every successful Publish, so
+	// sinks should throttle how often they store it.
+	Checkpoint(p *Publication)
+}
+
+// RedisSink is a Sink that publishes to Redis, and records the
+// last-processed timestamp there, just like PublishStream does.
+type RedisSink struct {
+	client       redis.UniversalClient
+	opts         *PublishOpts
+	checkpointer *Checkpointer
+}
+
+// NewRedisSink creates a RedisSink. opts.Relay is ignored. The caller must
+// call Close when done, to write the final checkpoint.
+func NewRedisSink(client redis.UniversalClient, opts *PublishOpts) *RedisSink {
+	return &RedisSink{
+		client:       client,
+		opts:         opts,
+		checkpointer: NewCheckpointer(client, opts),
+	}
+}
+
+// Publish publishes p to Redis (or adds it to streams, in streams mode). See
+// the package-level Publish.
+func (s *RedisSink) Publish(p *Publication) error {
+	if err := s.opts.Chaos.PublishError(); err != nil {
+		return err
+	}
+
+	return Publish(s.client, p, s.opts)
+}
+
+// Checkpoint records p's position in the oplog as the last-processed entry.
+// It's written to Redis according to opts.FlushStrategy.
+func (s *RedisSink) Checkpoint(p *Publication) {
+	if p.MoreInEntry {
+		return
+	}
+
+	s.checkpointer.timestamps <- publicationCheckpoint(p)
+}
+
+// Close writes the most recent checkpoint and stops the RedisSink.
+func (s *RedisSink) Close() {
+	s.checkpointer.Close()
+}
+
+// PublishToSinks reads Publications from the given channel and delivers each
+// of them to every sink, in order. Each sink is retried up to maxRetries
+// times (30 if maxRetries isn't positive), backing off exponentially (see
+// backoff.Default), before we give up on delivering the publication to it; a
+// sink that's down therefore holds up delivery to the others. A sink is only
+// checkpointed after publications are delivered to it.
+//
+// Like PublishStream, it returns when ctx is cancelled or the in channel is
+// closed, in which case it first delivers every Publication remaining in the
+// channel.
+func PublishToSinks(ctx context.Context, in <-chan *Publication, sinks []Sink, maxRetries int) {
+	publishToSinks(ctx, in, sinks, maxRetries, backoff.Backoff{}, nil)
+}
+
+// PublishToSinksWithOpts is like PublishToSinks, but retries each sink up to
+// opts.MaxRetries times, waiting opts.RetryBackoff between retries, and calls
+// opts.OnPublishError (if it's set) with each publication it gives up on
+// delivering to a sink. The rest of opts is ignored; it's for the sinks
+// themselves.
+func PublishToSinksWithOpts(ctx context.Context, in <-chan *Publication, sinks []Sink, opts *PublishOpts) {
+	publishToSinks(ctx, in, sinks, opts.MaxRetries, opts.RetryBackoff, opts.OnPublishError)
+}
+
+// Implements PublishToSinks, calling onError (if it's set) for each
+// publication we give up on delivering to a sink
+func publishToSinks(ctx context.Context, in <-chan *Publication, sinks []Sink, maxRetries int, retryBackoff backoff.Backoff, onError func(*Publication, error)) {
+	if maxRetries <= 0 {
+		maxRetries = 30
+	}
+
+	metricSendFailed := metricSentMessages.WithLabelValues("failed")
+	metricSendSuccess := metricSentMessages.WithLabelValues("sent")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case p, ok := <-in:
+			if !ok {
+				// The input channel was closed, and we've published everything
+				// that was in it
+				return
+			}
+
+			delivered := false
+			for i, sink := range sinks {
+				span := p.Trace.Child("publish")
+				span.SetAttribute("otr.sink", i)
+				err := publishSingleMessageWithRetries(ctx, p, maxRetries, retryBackoff, sink.Publish)
+				span.SetError(err)
+				span.End()
+
+				if err != nil {
+					metricSendFailed.Inc()
+					log.Log.Errorw("Permanent error while trying to publish message; giving up",
+						"error", err,
+						"sink", i,
+						"message", p)
+
+					if onError != nil {
+						onError(p, err)
+					}
+					continue
+				}
+
+				metricSendSuccess.Inc()
+				delivered = true
+
+				// We want to make sure we do this *after* we've successfully
+				// published the message
+				span = p.Trace.Child("checkpoint")
+				span.SetAttribute("otr.sink", i)
+				sink.Checkpoint(p)
+				span.End()
+			}
+
+			if delivered {
+				observePublishLag(metricPublishLag, p, time.Now())
+			}
+		}
+	}
+}
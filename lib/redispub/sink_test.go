@@ -0,0 +1,184 @@
+package redispub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/tracing"
+)
+
+type fakeSink struct {
+	fail        bool
+	published   []bson.MongoTimestamp
+	checkpoints []bson.MongoTimestamp
+}
+
+func (s *fakeSink) Publish(p *Publication) error {
+	if s.fail {
+		return errors.New("Some error")
+	}
+
+	s.published = append(s.published, p.OplogTimestamp)
+	return nil
+}
+
+func (s *fakeSink) Checkpoint(p *Publication) {
+	s.checkpoints = append(s.checkpoints, p.OplogTimestamp)
+}
+
+func TestPublishToSinks(t *testing.T) {
+	in := make(chan *Publication, 2)
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(1)}
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(2)}
+	close(in)
+
+	first := &fakeSink{}
+	second := &fakeSink{}
+	failing := &fakeSink{fail: true}
+
+	PublishToSinks(context.Background(), in, []Sink{first, failing, second}, 1)
+
+	want := []bson.MongoTimestamp{1, 2}
+	for name, sink := range map[string]*fakeSink{"first": first, "second": second} {
+		if !reflect.DeepEqual(sink.published, want) {
+			t.Errorf("The %s sink got publications %v, expected %v", name, sink.published, want)
+		}
+		if !reflect.DeepEqual(sink.checkpoints, want) {
+			t.Errorf("The %s sink got checkpoints %v, expected %v", name, sink.checkpoints, want)
+		}
+	}
+
+	if len(failing.checkpoints) != 0 {
+		t.Errorf("The failing sink was checkpointed: %v", failing.checkpoints)
+	}
+}
+
+func TestPublishToSinksOnError(t *testing.T) {
+	in := make(chan *Publication, 1)
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(1)}
+	close(in)
+
+	var failed []bson.MongoTimestamp
+	onError := func(p *Publication, err error) {
+		failed = append(failed, p.OplogTimestamp)
+	}
+
+	publishToSinks(context.Background(), in, []Sink{&fakeSink{}, &fakeSink{fail: true}}, 1, noBackoff, onError)
+
+	if !reflect.DeepEqual(failed, []bson.MongoTimestamp{1}) {
+		t.Errorf("Got failed publications %v, expected [1]", failed)
+	}
+}
+
+func TestPublishToSinksTracing(t *testing.T) {
+	var mutex sync.Mutex
+	var names []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						Name string `json:"name"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					names = append(names, span.Name)
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	tracer := tracing.New(tracing.Opts{Endpoint: server.URL})
+
+	in := make(chan *Publication, 1)
+	in <- &Publication{OplogTimestamp: bson.MongoTimestamp(1), Trace: tracer.Start("entry")}
+	close(in)
+
+	PublishToSinks(context.Background(), in, []Sink{&fakeSink{}, &fakeSink{fail: true}}, 1)
+	tracer.Close()
+
+	want := []string{"publish", "checkpoint", "publish"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Got spans %v, expected %v", names, want)
+	}
+}
+
+func TestRedisSink(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	sink := NewRedisSink(redisClient, &PublishOpts{
+		FlushInterval:    time.Hour,
+		DedupeExpiration: time.Minute,
+		MetadataPrefix:   "someprefix.",
+	})
+
+	publication := &Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+		Msg:               []byte("asdf"),
+		OplogTimestamp:    bson.MongoTimestamp(1234 << 32),
+	}
+
+	if err := sink.Publish(publication); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	sink.Checkpoint(publication)
+	sink.Close()
+
+	if got, _ := redisServer.Get("someprefix.lastProcessedEntry"); got != encodeMongoTimestamp(publication.OplogTimestamp) {
+		t.Errorf("Got last processed entry %q after Close", got)
+	}
+}
+
+func TestRedisSinkPartialEntry(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	sink := NewRedisSink(redisClient, &PublishOpts{
+		FlushInterval:    time.Hour,
+		DedupeExpiration: time.Minute,
+		MetadataPrefix:   "someprefix.",
+	})
+
+	// The first operation of a transaction is published, but not the rest
+	first := &Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+		Msg:               []byte("asdf"),
+		OplogTimestamp:    bson.MongoTimestamp(1234 << 32),
+		MoreInEntry:       true,
+	}
+	if err := sink.Publish(first); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	sink.Checkpoint(first)
+	sink.Close()
+
+	if redisServer.Exists("someprefix.lastProcessedEntry") {
+		got, _ := redisServer.Get("someprefix.lastProcessedEntry")
+		t.Errorf("Expected the entry not to be recorded as processed, got %q", got)
+	}
+}
@@ -0,0 +1,70 @@
+package redispub
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// StreamOpts configures streams mode. Instead of PUBLISHing messages, which
+// consumers miss if they aren't connected at that moment, messages are
+// added to Redis Streams (with XADD), which consumers can read from where
+// they left off. There's a stream for each channel a message would have been
+// published to, named the same as the channel.
+//
+// Streams mode delivers messages at least once: if adding a message to one
+// of its streams fails, it's added to all of them again when we retry.
+type StreamOpts struct {
+	// Approximate maximum length of each stream. Older entries are trimmed
+	// (with "MAXLEN ~") as new entries are added. 0 disables trimming.
+	MaxLen int64
+
+	// Whether to also add messages to a stream for each document
+	// ("<db>.<collection>::<id>"). This creates a key for every document that
+	// changes, so it's disabled by default.
+	PerDocument bool
+}
+
+// The field of each stream entry that holds the message
+const streamMessageField = "msg"
+
+// Adds a publication to its streams, unless it's already been added (by us,
+// or by another copy of oplogtoredis). The streams are keys, which could be
+// in different slots of a Redis Cluster, so unlike publishDedupe this isn't a
+// single script: we check the dedupe key, add the message to each stream, and
+// only then set the dedupe key, so that a failure part way through is retried
+// rather than dropped.
+func addToStreams(p *Publication, client redis.UniversalClient, prefix string, dedupeExpirationSeconds int, opts *PublishOpts) error {
+	key := publishDedupeKey(p, prefix, opts)
+
+	processed, err := client.Exists(key).Result()
+	if err != nil {
+		return err
+	}
+	if processed > 0 {
+		return nil
+	}
+
+	pipe := client.Pipeline()
+	for _, stream := range publicationChannels(p, opts, opts.Streams.PerDocument) {
+		_ = pipe.Process(redis.NewCmd(xaddArgs(stream, p.Msg, opts.Streams.MaxLen)...))
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return err
+	}
+
+	return client.Set(key, 1, time.Duration(dedupeExpirationSeconds)*time.Second).Err()
+}
+
+// Returns the XADD command that adds msg to stream, trimming it to
+// approximately maxLen entries. The vendored Redis client predates Redis
+// Streams, so we build the command ourselves.
+func xaddArgs(stream string, msg []byte, maxLen int64) []interface{} {
+	args := []interface{}{"xadd", stream}
+	if maxLen > 0 {
+		args = append(args, "maxlen", "~", maxLen)
+	}
+
+	return append(args, "*", streamMessageField, msg)
+}
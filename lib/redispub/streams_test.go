@@ -0,0 +1,77 @@
+package redispub
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+)
+
+// miniredis doesn't support XADD, so adding messages to streams gets tested
+// in integration tests.
+
+func TestXaddArgs(t *testing.T) {
+	msg := []byte("asdf")
+
+	got := xaddArgs("foo.bar", msg, 1000)
+	want := []interface{}{"xadd", "foo.bar", "maxlen", "~", int64(1000), "*", "msg", msg}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("xaddArgs() = %#v, wanted %#v", got, want)
+	}
+
+	got = xaddArgs("foo.bar", msg, 0)
+	want = []interface{}{"xadd", "foo.bar", "*", "msg", msg}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("xaddArgs() without trimming = %#v, wanted %#v", got, want)
+	}
+}
+
+func TestPublicationChannelsWithoutSpecific(t *testing.T) {
+	publication := &Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+	}
+
+	got := publicationChannels(publication, &PublishOpts{
+		ChannelPrefixes: []string{"", "new."},
+		GlobalChannel:   "firehose",
+	}, false)
+	want := []string{"foo.bar", "firehose", "new.foo.bar", "new.firehose"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("publicationChannels() = %#v, wanted %#v", got, want)
+	}
+}
+
+func TestAddToStreamsAlreadyProcessed(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer redisServer.Close()
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisServer.Addr()},
+	})
+
+	publication := &Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+		Msg:               []byte("asdf"),
+		OplogTimestamp:    bson.MongoTimestamp(1234 << 32),
+	}
+	redisServer.Set(dedupeKey(publication, "someprefix."), "1")
+
+	err = addToStreams(publication, redisClient, "someprefix.", 120, &PublishOpts{
+		Streams: &StreamOpts{MaxLen: 1000},
+	})
+	if err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+
+	if redisServer.Exists("foo.bar") {
+		t.Errorf("Publication was added to a stream even though it was already processed")
+	}
+}
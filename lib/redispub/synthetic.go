@@ -0,0 +1,135 @@
+package redispub
+
+import (
+	"strings"
+
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// redis-oplog's synthetic mutations are messages that app servers publish to
+// Redis themselves, without a corresponding write to Mongo. Apps that want
+// them to reach the same place as oplogtoredis's own messages (e.g. when
+// oplogtoredis publishes with a channel prefix, or to a remote Redis in relay
+// mode) publish them to "<inbound prefix><channel>" instead, and a
+// SyntheticRelay republishes them to <channel>, once per channel prefix.
+
+var metricSyntheticRelayed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "synthetic_relayed",
+	Help:      "Synthetic mutations relayed from the inbound channels, partitioned by status (sent or failed)",
+}, []string{"status"})
+
+// SyntheticRelay republishes synthetic mutations from the inbound channels.
+type SyntheticRelay struct {
+	pubsub *redis.PubSub
+	done   chan bool
+}
+
+// StartSyntheticRelay subscribes to every channel starting with inboundPrefix,
+// and republishes the messages it receives with the prefix removed. The
+// channel prefixes in opts are applied to the republished channel names, and
+// messages are compressed if opts.Relay.Compress is set, just like our own
+// publications; custom collection channels are not applied, since app servers
+// choose the channels of synthetic mutations themselves.
+func StartSyntheticRelay(client redis.UniversalClient, inboundPrefix string, opts *PublishOpts) (*SyntheticRelay, error) {
+	pubsub := client.PSubscribe(escapeGlob(inboundPrefix) + "*")
+
+	// Wait for the subscription to be confirmed, so we don't miss any
+	// messages sent after we return
+	_, err := pubsub.Receive()
+	if err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	relay := &SyntheticRelay{
+		pubsub: pubsub,
+		done:   make(chan bool),
+	}
+
+	go func() {
+		defer close(relay.done)
+
+		for msg := range pubsub.Channel() {
+			err := relaySynthetic(client, msg, inboundPrefix, opts)
+			if err != nil {
+				metricSyntheticRelayed.WithLabelValues("failed").Inc()
+				log.Log.Errorw("Error relaying synthetic mutation",
+					"channel", msg.Channel,
+					"error", err)
+				continue
+			}
+
+			metricSyntheticRelayed.WithLabelValues("sent").Inc()
+		}
+	}()
+
+	return relay, nil
+}
+
+// Close unsubscribes from the inbound channels, and waits for the message
+// being relayed (if any) to be published.
+func (relay *SyntheticRelay) Close() error {
+	err := relay.pubsub.Close()
+	<-relay.done
+
+	return err
+}
+
+// Republishes a single synthetic mutation
+func relaySynthetic(client redis.UniversalClient, msg *redis.Message, inboundPrefix string, opts *PublishOpts) error {
+	payload := []byte(msg.Payload)
+	if opts.Relay != nil && opts.Relay.Compress {
+		var err error
+		payload, err = gzipMessage(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	pipe := client.Pipeline()
+	defer pipe.Close()
+
+	for _, channel := range syntheticChannels(msg.Channel, inboundPrefix, opts) {
+		pipe.Publish(channel, payload)
+	}
+
+	_, err := pipe.Exec()
+	return err
+}
+
+// Returns the channels to republish a message received on an inbound channel
+// to
+func syntheticChannels(inboundChannel string, inboundPrefix string, opts *PublishOpts) []string {
+	channel := strings.TrimPrefix(inboundChannel, inboundPrefix)
+
+	channelPrefixes := opts.ChannelPrefixes
+	if len(channelPrefixes) == 0 {
+		channelPrefixes = []string{""}
+	}
+
+	channels := make([]string, len(channelPrefixes))
+	for i, channelPrefix := range channelPrefixes {
+		channels[i] = channelPrefix + channel
+	}
+
+	return channels
+}
+
+// Escapes the characters that are special in Redis glob-style patterns
+func escapeGlob(s string) string {
+	var escaped strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+
+	return escaped.String()
+}
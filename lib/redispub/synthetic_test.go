@@ -0,0 +1,57 @@
+package redispub
+
+import (
+	"reflect"
+	"testing"
+)
+
+// We don't test StartSyntheticRelay here -- miniredis doesn't support
+// PUBLISH. It gets tested in integration tests.
+
+func TestSyntheticChannels(t *testing.T) {
+	tests := map[string]struct {
+		inboundChannel  string
+		channelPrefixes []string
+		want            []string
+	}{
+		"No prefixes": {
+			inboundChannel: "synthetic::foo.bar",
+			want:           []string{"foo.bar"},
+		},
+		"Specific channel": {
+			inboundChannel: "synthetic::foo.bar::someid",
+			want:           []string{"foo.bar::someid"},
+		},
+		"Tee prefixes": {
+			inboundChannel:  "synthetic::foo.bar",
+			channelPrefixes: []string{"", "new."},
+			want:            []string{"foo.bar", "new.foo.bar"},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			got := syntheticChannels(test.inboundChannel, "synthetic::", &PublishOpts{
+				ChannelPrefixes: test.channelPrefixes,
+			})
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("syntheticChannels() = %#v, wanted %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeGlob(t *testing.T) {
+	tests := map[string]string{
+		"synthetic::": "synthetic::",
+		"a*b?c":       `a\*b\?c`,
+		`[x]\`:        `\[x\]\\`,
+	}
+
+	for in, want := range tests {
+		if got := escapeGlob(in); got != want {
+			t.Errorf("escapeGlob(%q) = %q, wanted %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package redispub
+
+import (
+	"github.com/go-redis/redis"
+)
+
+// WasPublished checks whether the given Publication was published by a copy
+// of oplogtoredis using the given metadata prefix, by looking for the key we
+// write to deduplicate publications. Those keys expire (see
+// PublishOpts.DedupeExpiration), so this can only verify publications that are
+// more recent than that.
+func WasPublished(client redis.UniversalClient, metadataPrefix string, p *Publication) (bool, error) {
+	count, err := client.Exists(dedupeKey(p, metadataPrefix)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,30 @@
+package redispub
+
+import (
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestWasPublished(t *testing.T) {
+	redisServer, redisClient := startMiniredis()
+	defer redisServer.Close()
+
+	redisServer.Set("someprefix.processed::1234", "1")
+
+	published, err := WasPublished(redisClient, "someprefix.", &Publication{OplogTimestamp: bson.MongoTimestamp(1234)})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !published {
+		t.Errorf("Expected publication with a dedupe key to be reported as published")
+	}
+
+	published, err = WasPublished(redisClient, "someprefix.", &Publication{OplogTimestamp: bson.MongoTimestamp(5678)})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if published {
+		t.Errorf("Expected publication without a dedupe key to be reported as not published")
+	}
+}
@@ -0,0 +1,210 @@
+package redispub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+var metricWALEntries = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "wal_entries",
+	Help:      "Publications waiting in the write-ahead log (see OTR_PUBLISH_WAL_FILE) to be published once Redis recovers",
+})
+
+var metricWALWritten = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "wal_written",
+	Help:      "Publications written to the write-ahead log (see OTR_PUBLISH_WAL_FILE) because they couldn't be published",
+})
+
+// WALSink is a Sink that delivers publications to another Sink, and when that
+// fails, appends them to a write-ahead log file instead. Once the log has
+// anything in it, every later publication is appended too, so they stay in
+// order, and the log is delivered in the background, once a second, until
+// it's empty again.
+//
+// A publication is checkpointed once it's in the log, which is synced to
+// disk, so the log must be kept between runs (e.g. on a persistent volume):
+// publications left in it are delivered first when it's next opened. If we
+// stop before emptying the log, publications that were already delivered
+// from it are delivered again next time.
+type WALSink struct {
+	sink Sink
+
+	// Guards everything below, and is held while delivering from the log so
+	// that new publications wait for the log to be delivered
+	mutex sync.Mutex
+	wal   *spillFile
+
+	// The publication we read from the log but haven't delivered yet
+	head *Publication
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWALSink creates a WALSink that delivers to sink, using the log file at
+// path (which is created if it doesn't exist). The caller must call Close
+// when done, and then close sink.
+func NewWALSink(sink Sink, path string) (*WALSink, error) {
+	return newWALSink(sink, path, time.Second)
+}
+
+func newWALSink(sink Sink, path string, retryInterval time.Duration) (*WALSink, error) {
+	wal, err := openSpillFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wal.sync = true
+	wal.gauge = metricWALEntries
+	wal.updateGauge()
+
+	if wal.count > 0 {
+		log.Log.Warnw("Write-ahead log has unpublished messages; publishing them first",
+			"path", path,
+			"count", wal.count)
+	}
+
+	s := &WALSink{
+		sink: sink,
+		wal:  wal,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go s.deliverInBackground(retryInterval)
+	return s, nil
+}
+
+// Publish delivers p to the underlying sink, or appends it to the log if
+// that fails or the log isn't empty. It only returns an error if it can't
+// write to the log.
+func (s *WALSink) Publish(p *Publication) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.pending() {
+		err := s.sink.Publish(p)
+		if err == nil {
+			return nil
+		}
+
+		log.Log.Warnw("Error publishing message; writing it to the write-ahead log",
+			"error", err)
+	}
+
+	err := s.wal.write(p)
+	if err != nil {
+		return err
+	}
+
+	metricWALWritten.Inc()
+	return nil
+}
+
+// Checkpoint checkpoints p in the underlying sink. It's only called once p
+// is delivered or in the log.
+func (s *WALSink) Checkpoint(p *Publication) {
+	s.sink.Checkpoint(p)
+}
+
+// Close stops delivering from the log, and closes it. Anything left in it is
+// delivered when it's next opened.
+func (s *WALSink) Close() {
+	close(s.stop)
+	<-s.done
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pending() {
+		count := s.wal.count
+		if s.head != nil {
+			count++
+		}
+
+		log.Log.Warnw("Exiting with unpublished messages in the write-ahead log",
+			"count", count)
+	}
+
+	if err := s.wal.close(); err != nil {
+		log.Log.Errorw("Error closing write-ahead log",
+			"error", err)
+	}
+}
+
+// Whether anything in the log hasn't been delivered
+func (s *WALSink) pending() bool {
+	return s.head != nil || s.wal.count > 0
+}
+
+func (s *WALSink) deliverInBackground(retryInterval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.deliverLog()
+		}
+	}
+}
+
+// Delivers publications from the log until it's empty, delivering one fails,
+// or we're stopped
+func (s *WALSink) deliverLog() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for s.pending() {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if s.head == nil {
+			head, err := s.wal.read()
+			if err != nil {
+				// The entry is unreadable, so retrying won't help
+				log.Log.Errorw("Error reading from write-ahead log; skipping entry",
+					"error", err)
+				s.resetIfDelivered()
+				continue
+			}
+			s.head = head
+		}
+
+		err := s.sink.Publish(s.head)
+		if err != nil {
+			log.Log.Debugw("Error publishing message from write-ahead log, will retry",
+				"error", err)
+			return
+		}
+
+		s.head = nil
+		s.resetIfDelivered()
+	}
+}
+
+// Empties the log file once everything in it is delivered
+func (s *WALSink) resetIfDelivered() {
+	if s.pending() {
+		return
+	}
+
+	if err := s.wal.reset(); err != nil {
+		log.Log.Errorw("Error resetting write-ahead log",
+			"error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package redispub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestWALSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplogtoredis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	// We deliver from the log ourselves, rather than waiting for the
+	// background delivery
+	sink := &fakeSink{}
+	wal, err := newWALSink(sink, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(ts int) {
+		p := &Publication{OplogTimestamp: bson.MongoTimestamp(ts)}
+		if err := wal.Publish(p); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		wal.Checkpoint(p)
+	}
+
+	publish(1)
+
+	// While the sink fails, publications go to the log, but are still
+	// checkpointed
+	sink.fail = true
+	publish(2)
+	publish(3)
+	wal.deliverLog()
+
+	// Once the log has anything in it, later publications go there too
+	sink.fail = false
+	publish(4)
+
+	if !reflect.DeepEqual(sink.published, timestampRange(1, 1)) {
+		t.Errorf("Got publications %v before delivering the log, expected [1]", sink.published)
+	}
+
+	wal.deliverLog()
+	publish(5)
+
+	want := timestampRange(1, 5)
+	if !reflect.DeepEqual(sink.published, want) {
+		t.Errorf("Got publications %v, expected %v", sink.published, want)
+	}
+	if !reflect.DeepEqual(sink.checkpoints, want) {
+		t.Errorf("Got checkpoints %v, expected %v", sink.checkpoints, want)
+	}
+
+	wal.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("Expected the delivered log to be emptied, but it's %d bytes", info.Size())
+	}
+}
+
+func TestWALSinkReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oplogtoredis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	sink := &fakeSink{fail: true}
+	wal, err := newWALSink(sink, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err := wal.Publish(&Publication{OplogTimestamp: bson.MongoTimestamp(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wal.Close()
+
+	// Simulate crashing in the middle of writing a publication
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.WriteString(`{"OplogTimes`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	sink.fail = false
+	wal, err = newWALSink(sink, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+
+	if wal.wal.count != 3 {
+		t.Errorf("Expected 3 publications in the reopened log, got %d", wal.wal.count)
+	}
+
+	if err := wal.Publish(&Publication{OplogTimestamp: bson.MongoTimestamp(4)}); err != nil {
+		t.Fatal(err)
+	}
+	wal.deliverLog()
+
+	want := timestampRange(1, 4)
+	if !reflect.DeepEqual(sink.published, want) {
+		t.Errorf("Got publications %v, expected %v", sink.published, want)
+	}
+}
+
+func TestNewWALSinkError(t *testing.T) {
+	_, err := NewWALSink(&fakeSink{}, "/nonexistent/directory/wal")
+	if err == nil {
+		t.Error("Expected an error opening the log")
+	}
+}
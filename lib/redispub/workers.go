@@ -0,0 +1,205 @@
+package redispub
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// WorkerOpts configures PublishStream to publish with several workers at
+// once, rather than one publication at a time. Publications are assigned to
+// workers by a hash of their document, so each document's publications are
+// still published in order, but publications for different documents (even
+// in the same collection) may be published out of order.
+type WorkerOpts struct {
+	// The number of workers publishing at once
+	Workers int
+
+	// The number of publications each worker queues up. When a worker's
+	// queue is full, we wait for it before handing out any more
+	// publications, so one slow document holds up the others.
+	QueueSize int
+}
+
+var metricWorkerQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "otr",
+	Subsystem: "redispub",
+	Name:      "worker_queue_depth",
+	Help:      "With OTR_PUBLISH_WORKERS, the number of publications waiting in each publisher worker's queue",
+}, []string{"worker"})
+
+// A publication handed out to a worker, numbered in the order we read them
+type workerPublication struct {
+	seq uint64
+	pub *Publication
+}
+
+// Publishes the publications from the in channel to the sink with
+// opts.Workers workers, retrying each up to maxRetries times with
+// retryBackoff like PublishToSinks. The sink is checkpointed in the order we
+// read publications, so a publication is only checkpointed once every
+// earlier one is done.
+//
+// Like PublishToSinks, it returns when ctx is cancelled or the in channel is
+// closed, in which case it first publishes every Publication remaining in
+// the channel.
+func publishWithWorkers(ctx context.Context, in <-chan *Publication, sink Sink, opts *WorkerOpts, maxRetries int, retryBackoff backoff.Backoff, onError func(*Publication, error)) {
+	if maxRetries <= 0 {
+		maxRetries = 30
+	}
+
+	metricSendFailed := metricSentMessages.WithLabelValues("failed")
+	metricSendSuccess := metricSentMessages.WithLabelValues("sent")
+
+	tracker := newCheckpointTracker(sink)
+
+	queues := make([]chan workerPublication, opts.Workers)
+	depths := make([]prometheus.Gauge, opts.Workers)
+	var wg sync.WaitGroup
+
+	for i := range queues {
+		queue := make(chan workerPublication, opts.QueueSize)
+		workerID := i
+		depth := metricWorkerQueueDepth.WithLabelValues(strconv.Itoa(i))
+		queues[i] = queue
+		depths[i] = depth
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				var item workerPublication
+				var ok bool
+
+				select {
+				case <-ctx.Done():
+					return
+				case item, ok = <-queue:
+					if !ok {
+						return
+					}
+				}
+				depth.Set(float64(len(queue)))
+
+				span := item.pub.Trace.Child("publish")
+				span.SetAttribute("otr.worker", workerID)
+				err := publishSingleMessageWithRetries(ctx, item.pub, maxRetries, retryBackoff, sink.Publish)
+				span.SetError(err)
+				span.End()
+				if err != nil {
+					metricSendFailed.Inc()
+					log.Log.Errorw("Permanent error while trying to publish message; giving up",
+						"error", err,
+						"message", item.pub)
+
+					if onError != nil {
+						onError(item.pub, err)
+					}
+				} else {
+					metricSendSuccess.Inc()
+					observePublishLag(metricPublishLag, item.pub, time.Now())
+				}
+
+				tracker.done(item.seq, item.pub, err == nil)
+			}
+		}()
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	var seq uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case p, ok := <-in:
+			if !ok {
+				// The input channel was closed; the workers finish what's in
+				// their queues before we return
+				return
+			}
+
+			i := workerFor(p, opts.Workers)
+			select {
+			case queues[i] <- workerPublication{seq: seq, pub: p}:
+				depths[i].Set(float64(len(queues[i])))
+			case <-ctx.Done():
+				return
+			}
+			seq++
+		}
+	}
+}
+
+// Returns the worker that publishes a publication: the same one for every
+// publication about a given document
+func workerFor(p *Publication, workers int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(p.SpecificChannel))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// Checkpoints publications in the order they were read, once every earlier
+// publication is done, even though workers finish them out of order
+type checkpointTracker struct {
+	sink Sink
+
+	mutex sync.Mutex
+
+	// The sequence number of the first publication that isn't done
+	next uint64
+
+	// Publications that are done, but come after one that isn't, keyed by
+	// sequence number. Failed publications are nil, since they're not
+	// checkpointed.
+	finished map[uint64]*Publication
+}
+
+func newCheckpointTracker(sink Sink) *checkpointTracker {
+	return &checkpointTracker{
+		sink:     sink,
+		finished: map[uint64]*Publication{},
+	}
+}
+
+// Marks a publication as done, and checkpoints it (if it was published
+// successfully) along with any later publications that were waiting on it
+func (t *checkpointTracker) done(seq uint64, p *Publication, success bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !success {
+		p = nil
+	}
+	t.finished[seq] = p
+
+	for {
+		p, ok := t.finished[t.next]
+		if !ok {
+			return
+		}
+
+		delete(t.finished, t.next)
+		t.next++
+
+		if p != nil {
+			span := p.Trace.Child("checkpoint")
+			t.sink.Checkpoint(p)
+			span.End()
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package redispub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// A Sink that's safe to use from several workers, and that slows down
+// publications for one document
+type concurrentFakeSink struct {
+	mutex       sync.Mutex
+	published   map[string][]bson.MongoTimestamp
+	checkpoints []bson.MongoTimestamp
+	slowChannel string
+}
+
+func (s *concurrentFakeSink) Publish(p *Publication) error {
+	if p.SpecificChannel == s.slowChannel {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.published[p.SpecificChannel] = append(s.published[p.SpecificChannel], p.OplogTimestamp)
+	return nil
+}
+
+func (s *concurrentFakeSink) Checkpoint(p *Publication) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.checkpoints = append(s.checkpoints, p.OplogTimestamp)
+}
+
+func TestPublishWithWorkers(t *testing.T) {
+	in := make(chan *Publication, 100)
+	for i := 1; i <= 100; i++ {
+		in <- &Publication{
+			SpecificChannel: fmt.Sprintf("db.coll::doc%d", i%5),
+			OplogTimestamp:  bson.MongoTimestamp(i),
+		}
+	}
+	close(in)
+
+	sink := &concurrentFakeSink{
+		published:   map[string][]bson.MongoTimestamp{},
+		slowChannel: "db.coll::doc0",
+	}
+
+	publishWithWorkers(context.Background(), in, sink, &WorkerOpts{Workers: 4, QueueSize: 10}, 1, noBackoff, nil)
+
+	// Each document's publications are published in order
+	for doc := 0; doc < 5; doc++ {
+		channel := fmt.Sprintf("db.coll::doc%d", doc)
+
+		var want []bson.MongoTimestamp
+		for i := 1; i <= 100; i++ {
+			if i%5 == doc {
+				want = append(want, bson.MongoTimestamp(i))
+			}
+		}
+
+		if !reflect.DeepEqual(sink.published[channel], want) {
+			t.Errorf("Got publications %v for %s, expected %v", sink.published[channel], channel, want)
+		}
+	}
+
+	// Checkpoints are in the order the publications were read
+	if len(sink.checkpoints) != 100 {
+		t.Fatalf("Got %d checkpoints, expected 100", len(sink.checkpoints))
+	}
+	for i, ts := range sink.checkpoints {
+		if ts != bson.MongoTimestamp(i+1) {
+			t.Fatalf("Got checkpoints out of order: %v", sink.checkpoints)
+		}
+	}
+}
+
+func TestPublishWithWorkersCancel(t *testing.T) {
+	in := make(chan *Publication)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan bool)
+	go func() {
+		publishWithWorkers(ctx, in, &concurrentFakeSink{published: map[string][]bson.MongoTimestamp{}}, &WorkerOpts{Workers: 2, QueueSize: 1}, 1, noBackoff, nil)
+		close(done)
+	}()
+
+	in <- &Publication{SpecificChannel: "db.coll::a", OplogTimestamp: 1}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishWithWorkers didn't return after ctx was cancelled")
+	}
+}
+
+func TestCheckpointTracker(t *testing.T) {
+	sink := &concurrentFakeSink{}
+	tracker := newCheckpointTracker(sink)
+	pub := func(ts int) *Publication { return &Publication{OplogTimestamp: bson.MongoTimestamp(ts)} }
+
+	tracker.done(1, pub(2), true)
+	tracker.done(2, pub(3), false)
+	if len(sink.checkpoints) != 0 {
+		t.Errorf("Got checkpoints %v before the first publication was done", sink.checkpoints)
+	}
+
+	tracker.done(0, pub(1), true)
+	want := []bson.MongoTimestamp{1, 2}
+	if !reflect.DeepEqual(sink.checkpoints, want) {
+		t.Errorf("Got checkpoints %v, expected %v", sink.checkpoints, want)
+	}
+
+	tracker.done(3, pub(4), true)
+	want = []bson.MongoTimestamp{1, 2, 4}
+	if !reflect.DeepEqual(sink.checkpoints, want) {
+		t.Errorf("Got checkpoints %v, expected %v", sink.checkpoints, want)
+	}
+}
+
+func TestWorkerFor(t *testing.T) {
+	p := &Publication{SpecificChannel: "db.coll::someid"}
+	first := workerFor(p, 8)
+
+	for i := 0; i < 10; i++ {
+		if workerFor(&Publication{SpecificChannel: "db.coll::someid"}, 8) != first {
+			t.Fatal("Publications for the same document went to different workers")
+		}
+	}
+
+	if first < 0 || first >= 8 {
+		t.Errorf("Got worker %d, expected one of 0-7", first)
+	}
+}
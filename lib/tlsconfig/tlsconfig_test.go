@@ -0,0 +1,179 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a self-signed certificate and its key to PEM files in dir, and
+// returns their paths
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "oplogtoredis-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir)
+
+	config, err := New(Options{
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "redis.internal",
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if config.RootCAs == nil || len(config.RootCAs.Subjects()) != 1 {
+		t.Errorf("Expected the CA file's certificate to be trusted")
+	}
+
+	if len(config.Certificates) != 1 {
+		t.Errorf("Expected a client certificate, got %d", len(config.Certificates))
+	}
+
+	if config.ServerName != "redis.internal" {
+		t.Errorf("Got server name %q", config.ServerName)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir)
+
+	tests := map[string]Options{
+		"Missing CA file":      {CAFile: filepath.Join(dir, "missing.pem")},
+		"CA file without PEM":  {CAFile: keyFile},
+		"Cert without key":     {CertFile: certFile},
+		"Key without cert":     {KeyFile: keyFile},
+		"Mismatched cert file": {CertFile: keyFile, KeyFile: keyFile},
+	}
+
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(opts); err == nil {
+				t.Errorf("Expected an error for options %#v", opts)
+			}
+		})
+	}
+}
+
+func TestForHost(t *testing.T) {
+	original := &tls.Config{}
+
+	config := ForHost(original, "redis.internal")
+	if config.ServerName != "redis.internal" {
+		t.Errorf("Got server name %q, expected the host", config.ServerName)
+	}
+	if original.ServerName != "" {
+		t.Errorf("ForHost modified the original config")
+	}
+
+	config = ForHost(&tls.Config{ServerName: "other"}, "redis.internal")
+	if config.ServerName != "other" {
+		t.Errorf("Got server name %q, expected the configured one", config.ServerName)
+	}
+
+	config = ForHost(&tls.Config{InsecureSkipVerify: true}, "redis.internal") // nolint: gas
+	if config.ServerName != "" {
+		t.Errorf("Got server name %q, expected none when skipping verification", config.ServerName)
+	}
+}
+
+func TestAllowInvalidHostnames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCert(t, dir)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := New(Options{CAFile: certFile, AllowInvalidHostnames: true})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	// The certificate isn't for any host, but it's signed by a trusted CA
+	if err := config.VerifyPeerCertificate(cert.Certificate, nil); err != nil {
+		t.Errorf("Got unexpected error verifying a trusted certificate: %s", err)
+	}
+
+	otherDir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(otherDir)
+
+	otherCertFile, otherKeyFile := writeCert(t, otherDir)
+	otherCert, err := tls.LoadX509KeyPair(otherCertFile, otherKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.VerifyPeerCertificate(otherCert.Certificate, nil); err == nil {
+		t.Errorf("Expected an error verifying an untrusted certificate")
+	}
+}
@@ -0,0 +1,152 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The OTLP/JSON encoding of an ExportTraceServiceRequest. See
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding -- IDs
+// are hex-encoded, and 64-bit integers are strings.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []keyValue  `json:"attributes,omitempty"`
+	Status            *spanStatus `json:"status,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type spanStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+// The instrumentation scope of our spans
+const scopeName = "github.com/tulip/oplogtoredis"
+
+// Returns the export request for a batch of spans
+func (t *Tracer) exportRequest(spans []*Span) *exportRequest {
+	serviceName := t.opts.ServiceName
+
+	converted := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		converted[i] = span.otlp()
+	}
+
+	return &exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{
+				Attributes: []keyValue{{
+					Key:   "service.name",
+					Value: anyValue{StringValue: &serviceName},
+				}},
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName},
+				Spans: converted,
+			}},
+		}},
+	}
+}
+
+// Returns the OTLP encoding of a span that has ended
+func (s *Span) otlp() otlpSpan {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        s.attributes,
+	}
+
+	if s.parentID != ([8]byte{}) {
+		span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+
+	if s.err != nil {
+		span.Status = &spanStatus{Code: statusCodeError, Message: s.err.Error()}
+	}
+
+	return span
+}
+
+// POSTs a batch of spans to the OTLP endpoint
+func (t *Tracer) export(spans []*Span) error {
+	body, err := json.Marshal(t.exportRequest(spans))
+	if err != nil {
+		return fmt.Errorf("Error encoding spans: %s", err)
+	}
+
+	url := strings.TrimSuffix(t.opts.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error creating request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error sending spans: %s", err)
+	}
+
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP endpoint responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
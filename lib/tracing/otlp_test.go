@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExportRequestJSON(t *testing.T) {
+	tracer := &Tracer{opts: Opts{ServiceName: "some-service"}}
+
+	span := &Span{
+		tracer:   tracer,
+		traceID:  [16]byte{0x01, 0x02, 15: 0xff},
+		spanID:   [8]byte{0xab, 7: 0xcd},
+		parentID: [8]byte{0x12, 7: 0x34},
+		name:     "publish",
+		start:    time.Unix(1526648511, 5),
+		end:      time.Unix(1526648512, 0),
+	}
+	span.SetAttribute("otr.sink", 1)
+	span.SetAttribute("otr.relay", true)
+	span.SetAttribute("mongodb.namespace", "foo.bar")
+	span.SetError(errors.New("some error"))
+
+	data, err := json.Marshal(tracer.exportRequest([]*Span{span}))
+	if err != nil {
+		t.Fatalf("Error encoding export request: %s", err)
+	}
+
+	expected := `{"resourceSpans":[{` +
+		`"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"some-service"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/tulip/oplogtoredis"},"spans":[{` +
+		`"traceId":"010200000000000000000000000000ff",` +
+		`"spanId":"ab000000000000cd",` +
+		`"parentSpanId":"1200000000000034",` +
+		`"name":"publish",` +
+		`"kind":1,` +
+		`"startTimeUnixNano":"1526648511000000005",` +
+		`"endTimeUnixNano":"1526648512000000000",` +
+		`"attributes":[` +
+		`{"key":"otr.sink","value":{"intValue":"1"}},` +
+		`{"key":"otr.relay","value":{"boolValue":true}},` +
+		`{"key":"mongodb.namespace","value":{"stringValue":"foo.bar"}}],` +
+		`"status":{"code":2,"message":"some error"}}]}]}]}`
+
+	if string(data) != expected {
+		t.Errorf("Incorrect export request.\n  Got:      %s\n  Expected: %s", data, expected)
+	}
+}
+
+func TestExportRequestRootSpan(t *testing.T) {
+	span := (&Span{name: "entry"}).otlp()
+
+	if span.ParentSpanID != "" || span.Status != nil {
+		t.Errorf("Expected a root span without a parent or status, got %#v", span)
+	}
+}
@@ -0,0 +1,298 @@
+// Package tracing traces oplog entries through oplogtoredis -- decoding,
+// processing, publishing, and checkpointing -- and exports the spans to an
+// OpenTelemetry collector over OTLP/HTTP, so you can see where latency
+// accumulates when oplogtoredis falls behind.
+//
+// Each traced oplog entry gets an "entry" span, with "decode" and "process"
+// spans under it, and a "publish" and "checkpoint" span for each sink its
+// publications are delivered to. The entry span is carried to the publisher
+// by the publications themselves (see redispub.Publication's Trace).
+//
+// A nil *Tracer and a nil *Span are valid and do nothing, so callers don't
+// need to check whether tracing is enabled, or whether an entry was sampled.
+package tracing
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/log"
+)
+
+// Opts configures a Tracer
+type Opts struct {
+	// The base URL of the OTLP/HTTP receiver, like http://localhost:4318.
+	// Spans are POSTed to <Endpoint>/v1/traces.
+	Endpoint string
+
+	// The service.name of the exported spans. Defaults to "oplogtoredis".
+	ServiceName string
+
+	// The fraction (from 0 to 1) of oplog entries to trace. Defaults to 1
+	// (every entry).
+	SampleRate float64
+
+	// The most spans to send in one request, and how long to wait for a
+	// batch to fill up before sending it anyway. Default to 512 and 5
+	// seconds.
+	BatchSize   int
+	BatchWindow time.Duration
+
+	// The most finished spans to hold while they wait to be sent. Spans that
+	// finish while the queue is full are dropped. Defaults to 2048.
+	QueueSize int
+
+	// The timeout for each request. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+var metricExportedSpans = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "tracing",
+	Name:      "exported_spans",
+	Help:      "Spans sent to the OTLP endpoint, partitioned by whether they were sent successfully, or dropped because the queue was full",
+}, []string{"status"})
+
+// Tracer starts spans, and exports them in the background once they end
+type Tracer struct {
+	opts   Opts
+	client *http.Client
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+
+	queue    chan *Span
+	stop     chan bool
+	finished chan bool
+}
+
+// New creates a Tracer, and starts exporting its spans. The caller must call
+// Close when done, to send the last of them.
+func New(opts Opts) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "oplogtoredis"
+	}
+	if opts.SampleRate <= 0 {
+		opts.SampleRate = 1
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.BatchWindow <= 0 {
+		opts.BatchWindow = 5 * time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 2048
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	tracer := &Tracer{
+		opts:     opts,
+		client:   &http.Client{Timeout: opts.Timeout},
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		queue:    make(chan *Span, opts.QueueSize),
+		stop:     make(chan bool),
+		finished: make(chan bool),
+	}
+
+	go tracer.run()
+
+	return tracer
+}
+
+// Start starts a root span (the first span of a new trace), or returns nil
+// if the trace isn't sampled
+func (t *Tracer) Start(name string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.opts.SampleRate < 1 && t.rand.Float64() >= t.opts.SampleRate {
+		return nil
+	}
+
+	span := &Span{tracer: t, name: name, start: time.Now()}
+	t.rand.Read(span.traceID[:])
+	t.rand.Read(span.spanID[:])
+
+	return span
+}
+
+// Returns a new span ID
+func (t *Tracer) newSpanID() [8]byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var id [8]byte
+	t.rand.Read(id[:])
+	return id
+}
+
+// Close sends the spans that have ended, and stops the Tracer. Spans that
+// end afterwards aren't sent.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+
+	close(t.stop)
+	<-t.finished
+}
+
+// Queues a span that ended to be sent
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		metricExportedSpans.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Sends the queued spans in batches, until the Tracer is closed
+func (t *Tracer) run() {
+	defer close(t.finished)
+
+	batch := make([]*Span, 0, t.opts.BatchSize)
+	ticker := time.NewTicker(t.opts.BatchWindow)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		err := t.export(batch)
+		if err != nil {
+			log.Log.Errorw("Error exporting trace spans",
+				"error", err,
+				"spans", len(batch))
+			metricExportedSpans.WithLabelValues("failed").Add(float64(len(batch)))
+		} else {
+			metricExportedSpans.WithLabelValues("sent").Add(float64(len(batch)))
+		}
+
+		batch = make([]*Span, 0, t.opts.BatchSize)
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= t.opts.BatchSize {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+
+		case <-t.stop:
+			// Send whatever's left in the queue before we stop
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+					if len(batch) >= t.opts.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Span is a timed operation in a trace. Its methods must only be called
+// from one goroutine at a time, except Child, which may be called from any
+// goroutine, even after the span has ended.
+type Span struct {
+	tracer *Tracer
+
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes []keyValue
+	err        error
+	ended      bool
+}
+
+// Child starts a span under s, in the same trace
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return &Span{
+		tracer:   s.tracer,
+		traceID:  s.traceID,
+		spanID:   s.tracer.newSpanID(),
+		parentID: s.spanID,
+		name:     name,
+		start:    time.Now(),
+	}
+}
+
+// SetAttribute records a key and value about the span's operation. Values
+// are exported as strings, integers, or booleans; other values are
+// formatted with fmt.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	var v anyValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		i := strconv.Itoa(value)
+		v.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(value, 10)
+		v.IntValue = &i
+	default:
+		str := fmt.Sprint(value)
+		v.StringValue = &str
+	}
+
+	s.attributes = append(s.attributes, keyValue{Key: key, Value: v})
+}
+
+// SetError marks the span's operation as failed, if err isn't nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.err = err
+}
+
+// End ends the span, and queues it to be sent. Only the first call does
+// anything.
+func (s *Span) End() {
+	if s == nil || s.ended {
+		return
+	}
+
+	s.ended = true
+	s.end = time.Now()
+	s.tracer.enqueue(s)
+}
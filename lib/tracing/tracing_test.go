@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// An OTLP endpoint that records the requests it receives
+type fakeCollector struct {
+	mutex    sync.Mutex
+	paths    []string
+	requests []exportRequest
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var req exportRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.paths = append(c.paths, r.URL.Path)
+	c.requests = append(c.requests, req)
+}
+
+// Returns every span the collector received
+func (c *fakeCollector) spans() []otlpSpan {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var spans []otlpSpan
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+
+	return spans
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	span := tracer.Start("entry")
+	if span != nil {
+		t.Fatalf("Expected a nil span from a nil tracer, got %#v", span)
+	}
+
+	// None of these should panic
+	child := span.Child("process")
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("some error"))
+	child.End()
+	span.End()
+	tracer.Close()
+}
+
+func TestTracerExportsSpans(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	tracer := New(Opts{Endpoint: server.URL + "/"})
+
+	root := tracer.Start("entry")
+	root.SetAttribute("mongodb.namespace", "foo.bar")
+	child := root.Child("publish")
+	child.SetError(errors.New("some error"))
+	child.End()
+	root.End()
+
+	tracer.Close()
+
+	if len(collector.paths) != 1 || collector.paths[0] != "/v1/traces" {
+		t.Fatalf("Expected one request to /v1/traces, got %v", collector.paths)
+	}
+
+	spans := collector.spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+
+	publish, entry := spans[0], spans[1]
+	if publish.Name != "publish" || entry.Name != "entry" {
+		t.Errorf("Expected the publish span and then the entry span, got %s and %s", publish.Name, entry.Name)
+	}
+	if publish.TraceID != entry.TraceID {
+		t.Errorf("Expected the spans to share a trace ID, got %s and %s", publish.TraceID, entry.TraceID)
+	}
+	if publish.ParentSpanID != entry.SpanID || publish.SpanID == entry.SpanID {
+		t.Errorf("Expected the publish span to be a child of the entry span, got %#v", publish)
+	}
+	if entry.ParentSpanID != "" {
+		t.Errorf("Expected the entry span to have no parent, got %s", entry.ParentSpanID)
+	}
+	if publish.Status == nil || publish.Status.Code != statusCodeError || publish.Status.Message != "some error" {
+		t.Errorf("Expected the publish span to have an error status, got %#v", publish.Status)
+	}
+	if len(entry.Attributes) != 1 || *entry.Attributes[0].Value.StringValue != "foo.bar" {
+		t.Errorf("Expected the entry span to have the namespace attribute, got %#v", entry.Attributes)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	tracer := &Tracer{
+		opts: Opts{SampleRate: 0.25},
+		rand: rand.New(rand.NewSource(1)),
+	}
+
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if tracer.Start("entry") != nil {
+			sampled++
+		}
+	}
+
+	if sampled < 2000 || sampled > 3000 {
+		t.Errorf("Expected about a quarter of 10000 entries to be sampled, got %d", sampled)
+	}
+}
+
+func TestSpanEndsOnce(t *testing.T) {
+	tracer := &Tracer{queue: make(chan *Span, 2)}
+
+	span := &Span{tracer: tracer}
+	span.End()
+	span.End()
+
+	if len(tracer.queue) != 1 {
+		t.Errorf("Expected the span to be queued once, got %d", len(tracer.queue))
+	}
+}
+
+func TestSpanDroppedWhenQueueFull(t *testing.T) {
+	tracer := &Tracer{queue: make(chan *Span, 1)}
+
+	(&Span{tracer: tracer}).End()
+	(&Span{tracer: tracer}).End()
+
+	if len(tracer.queue) != 1 {
+		t.Errorf("Expected one span to be queued and the other dropped, got %d", len(tracer.queue))
+	}
+}
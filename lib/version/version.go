@@ -0,0 +1,51 @@
+// Package version reports which build of oplogtoredis is running. The
+// values are embedded at build time with -ldflags, e.g.:
+//
+//	go build -ldflags "-X github.com/tulip/oplogtoredis/lib/version.Version=v2.1.0 \
+//	    -X github.com/tulip/oplogtoredis/lib/version.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/tulip/oplogtoredis/lib/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Builds without them (like `go build .` or `go test`) report "dev".
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// These are variables rather than constants so that -ldflags -X can set them
+var (
+	// Version is the release, like "v2.1.0"
+	Version = "dev"
+
+	// Commit is the git commit the build is from
+	Commit = "unknown"
+
+	// BuildTime is when the binary was built, in RFC 3339
+	BuildTime = "unknown"
+)
+
+// Info describes a build of oplogtoredis, as reported by the health
+// endpoint
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the running build's Info
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// String describes the build on one line, as printed by --version
+func (info Info) String() string {
+	return fmt.Sprintf("oplogtoredis %s (commit %s, built %s, %s)",
+		info.Version, info.Commit, info.BuildTime, info.GoVersion)
+}
@@ -0,0 +1,40 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	defer func(version, commit, buildTime string) {
+		Version, Commit, BuildTime = version, commit, buildTime
+	}(Version, Commit, BuildTime)
+
+	Version = "v2.1.0"
+	Commit = "abc123"
+	BuildTime = "2020-01-02T03:04:05Z"
+
+	info := Get()
+	expected := Info{
+		Version:   "v2.1.0",
+		Commit:    "abc123",
+		BuildTime: "2020-01-02T03:04:05Z",
+		GoVersion: runtime.Version(),
+	}
+	if info != expected {
+		t.Errorf("Got %#v, expected %#v", info, expected)
+	}
+
+	str := info.String()
+	expectedStr := "oplogtoredis v2.1.0 (commit abc123, built 2020-01-02T03:04:05Z, " + runtime.Version() + ")"
+	if str != expectedStr {
+		t.Errorf("Got %q, expected %q", str, expectedStr)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	info := Get()
+	if info.Version != "dev" || info.Commit != "unknown" || info.BuildTime != "unknown" {
+		t.Errorf("Unexpected defaults: %#v", info)
+	}
+}
@@ -0,0 +1,283 @@
+// Package webhook delivers publications to an HTTP endpoint, by POSTing
+// batches of them as JSON. This lets consumers that can't hold a Redis
+// connection open (like serverless functions) receive changes.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/tulip/oplogtoredis/lib/export"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// SignatureHeader is the header that carries the HMAC-SHA256 signature of
+// the request body, as "sha256=<hex digest>", when Opts.Secret is set.
+const SignatureHeader = "X-OTR-Signature"
+
+// Opts configures a Sink
+type Opts struct {
+	// The URL to POST batches to
+	URL string
+
+	// If set, each request is signed with this secret (see SignatureHeader),
+	// so the endpoint can check that the request came from oplogtoredis.
+	Secret string
+
+	// The most publications to send in one request. Defaults to 100.
+	BatchSize int
+
+	// How long to wait for a batch to fill up before sending it anyway.
+	// Defaults to 1 second.
+	BatchWindow time.Duration
+
+	// The most requests to have in flight at once. Once this many are in
+	// flight, Publish blocks until one of them finishes. Defaults to 4.
+	Concurrency int
+
+	// How many times to retry a failed request before giving up on its
+	// batch. Retries back off exponentially, starting at InitialBackoff and
+	// doubling up to MaxBackoff. Defaults to 10 retries, 1 second, and 1
+	// minute.
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// The timeout for each request. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Payload is the JSON body of each request
+type Payload struct {
+	Publications []*export.Record `json:"publications"`
+}
+
+var metricSentBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "webhook",
+	Name:      "sent_batches",
+	Help:      "Batches POSTed to the webhook, partitioned by whether or not we successfully sent them",
+}, []string{"status"})
+
+var metricTemporaryFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "otr",
+	Subsystem: "webhook",
+	Name:      "temporary_send_failures",
+	Help:      "Number of failed webhook requests. We retry with exponential backoff, and only register a permanent failure (in otr_webhook_sent_batches) after running out of retries.",
+})
+
+// Sink is a redispub.Sink that POSTs publications to a webhook. Publications
+// are batched, and batches are sent concurrently, so the endpoint may receive
+// them out of order; each record carries its oplog timestamp for ordering.
+//
+// Delivery is best-effort, like Redis pub/sub: a batch that still fails
+// after its retries is dropped, and publications that haven't been sent when
+// oplogtoredis crashes aren't resent when it restarts.
+type Sink struct {
+	opts   Opts
+	client *http.Client
+
+	mutex sync.Mutex
+	batch []*redispub.Publication
+	timer *time.Timer
+
+	// Holds a value for each request in flight, to limit concurrency
+	slots    chan bool
+	inFlight sync.WaitGroup
+}
+
+// New creates a Sink. The caller must call Close when done, to send the last
+// batch.
+func New(opts Opts) *Sink {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchWindow <= 0 {
+		opts.BatchWindow = time.Second
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 10
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Minute
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	return &Sink{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+		slots:  make(chan bool, opts.Concurrency),
+	}
+}
+
+// Publish adds p to the current batch, and sends the batch if it's full. It
+// never fails; failed requests are retried in the background.
+func (s *Sink) Publish(p *redispub.Publication) error {
+	s.mutex.Lock()
+	s.batch = append(s.batch, p)
+	if len(s.batch) == 1 {
+		s.timer = time.AfterFunc(s.opts.BatchWindow, s.flush)
+	}
+
+	var full []*redispub.Publication
+	if len(s.batch) >= s.opts.BatchSize {
+		full = s.takeBatch()
+	}
+	s.mutex.Unlock()
+
+	if full != nil {
+		s.send(full)
+	}
+
+	return nil
+}
+
+// Checkpoint does nothing: the webhook doesn't keep track of where
+// oplogtoredis left off.
+func (s *Sink) Checkpoint(p *redispub.Publication) {}
+
+// Close sends the current batch, and waits for every request to finish.
+func (s *Sink) Close() {
+	s.flush()
+	s.inFlight.Wait()
+}
+
+// Sends the current batch, if there is one
+func (s *Sink) flush() {
+	s.mutex.Lock()
+	batch := s.takeBatch()
+	s.mutex.Unlock()
+
+	if len(batch) > 0 {
+		s.send(batch)
+	}
+}
+
+// Returns the current batch and starts a new one. The caller must hold the
+// mutex.
+func (s *Sink) takeBatch() []*redispub.Publication {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	batch := s.batch
+	s.batch = nil
+	return batch
+}
+
+// Sends a batch in the background, once fewer than opts.Concurrency
+// requests are in flight
+func (s *Sink) send(batch []*redispub.Publication) {
+	s.slots <- true
+	s.inFlight.Add(1)
+
+	go func() {
+		defer func() {
+			<-s.slots
+			s.inFlight.Done()
+		}()
+
+		err := s.sendWithRetries(batch)
+		if err != nil {
+			metricSentBatches.WithLabelValues("failed").Inc()
+			log.Log.Errorw("Permanent error while trying to POST batch to webhook; giving up",
+				"error", err,
+				"batchSize", len(batch))
+		} else {
+			metricSentBatches.WithLabelValues("sent").Inc()
+		}
+	}()
+}
+
+func (s *Sink) sendWithRetries(batch []*redispub.Publication) error {
+	payload := Payload{Publications: make([]*export.Record, len(batch))}
+	for i, p := range batch {
+		payload.Publications[i] = export.NewRecord(p)
+	}
+
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		return fmt.Errorf("Error encoding batch: %s", err)
+	}
+
+	backoff := s.opts.InitialBackoff
+	for retries := 0; ; retries++ {
+		err = s.post(body)
+		if err == nil {
+			return nil
+		}
+
+		if retries >= s.opts.MaxRetries {
+			return fmt.Errorf("Failed to send batch after retrying %d times: %s", retries, err)
+		}
+
+		log.Log.Errorw("Error POSTing batch to webhook, will retry",
+			"error", err,
+			"retryNumber", retries)
+		metricTemporaryFailures.Inc()
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > s.opts.MaxBackoff {
+			backoff = s.opts.MaxBackoff
+		}
+	}
+}
+
+// Makes a single request with the given body
+func (s *Sink) post(body []byte) error {
+	req, err := http.NewRequest("POST", s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.opts.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(s.opts.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Read the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the value of SignatureHeader for a request body. Endpoints
+// can compare it to the header (with hmac.Equal) to authenticate requests.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
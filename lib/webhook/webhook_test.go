@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func testPublication(i int) *redispub.Publication {
+	return &redispub.Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::someid",
+		Msg:               []byte(`{"e":"i","d":{"_id":"someid"},"f":["some"]}`),
+		OplogTimestamp:    bson.MongoTimestamp(1526648511<<32 | int64(i)),
+	}
+}
+
+// A webhook endpoint that records the batches it receives. It fails the
+// first `failures` requests.
+type fakeEndpoint struct {
+	mutex    sync.Mutex
+	batches  []Payload
+	bodies   [][]byte
+	headers  []http.Header
+	failures int
+}
+
+func (e *fakeEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.failures > 0 {
+		e.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	e.batches = append(e.batches, payload)
+	e.bodies = append(e.bodies, body)
+	e.headers = append(e.headers, r.Header)
+}
+
+func TestSinkBatching(t *testing.T) {
+	endpoint := &fakeEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	sink := New(Opts{
+		URL:         server.URL,
+		Secret:      "s3cret",
+		BatchSize:   2,
+		BatchWindow: time.Hour,
+		Concurrency: 1,
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := sink.Publish(testPublication(i)); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+
+	// The last publication is only sent once the batch window passes, or
+	// when the sink is closed
+	sink.Close()
+
+	if len(endpoint.batches) != 2 {
+		t.Fatalf("Got %d batches, expected 2", len(endpoint.batches))
+	}
+
+	sizes := map[int]int{}
+	for _, batch := range endpoint.batches {
+		sizes[len(batch.Publications)]++
+	}
+	if sizes[2] != 1 || sizes[1] != 1 {
+		t.Errorf("Got batches %#v, expected one of 2 publications and one of 1", endpoint.batches)
+	}
+
+	record := endpoint.batches[0].Publications[0]
+	if record.CollectionChannel != "foo.bar" || record.Timestamp != "1526648511:0" {
+		t.Errorf("Got unexpected record %#v", record)
+	}
+
+	for i, headers := range endpoint.headers {
+		if got, want := headers.Get(SignatureHeader), Sign("s3cret", endpoint.bodies[i]); got != want {
+			t.Errorf("Got signature %q, expected %q", got, want)
+		}
+	}
+}
+
+func TestSinkBatchWindow(t *testing.T) {
+	endpoint := &fakeEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	sink := New(Opts{
+		URL:         server.URL,
+		BatchWindow: 10 * time.Millisecond,
+	})
+	defer sink.Close()
+
+	_ = sink.Publish(testPublication(0))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		endpoint.mutex.Lock()
+		sent := len(endpoint.batches)
+		endpoint.mutex.Unlock()
+
+		if sent == 1 {
+			if endpoint.headers[0].Get(SignatureHeader) != "" {
+				t.Errorf("Request was signed without a secret")
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Errorf("The batch wasn't sent after the batch window")
+}
+
+func TestSinkRetries(t *testing.T) {
+	endpoint := &fakeEndpoint{failures: 2}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	sink := New(Opts{
+		URL:            server.URL,
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+	})
+	_ = sink.Publish(testPublication(0))
+	sink.Close()
+
+	if len(endpoint.batches) != 1 {
+		t.Errorf("Got %d batches after transient failures, expected 1", len(endpoint.batches))
+	}
+}
+
+func TestSinkPermanentFailure(t *testing.T) {
+	endpoint := &fakeEndpoint{failures: 3}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	sink := New(Opts{
+		URL:            server.URL,
+		MaxRetries:     2,
+		InitialBackoff: time.Millisecond,
+	})
+	_ = sink.Publish(testPublication(0))
+	sink.Close()
+
+	if len(endpoint.batches) != 0 || endpoint.failures != 0 {
+		t.Errorf("Expected the batch to be dropped after 3 attempts, got %d batches and %d failures left",
+			len(endpoint.batches), endpoint.failures)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac s3cret
+	want := "sha256=adbde1ce40c89c14215687d5d762a47df6dfaefcfad61e2e86718ffc8498571b"
+	if got := Sign("s3cret", []byte("{}")); got != want {
+		t.Errorf("Sign() = %q, expected %q", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Implements `oplogtoredis listen`, which subscribes to the channels that
+// oplogtoredis publishes to and prints each message it receives. It's used to
+// verify end-to-end delivery.
+func runListen(args []string) error {
+	flags := flag.NewFlagSet("listen", flag.ContinueOnError)
+	redisURL := flags.String("redis-url", os.Getenv("OTR_REDIS_URL"), "Redis URL to subscribe to. Defaults to OTR_REDIS_URL.")
+	prefix := flags.String("prefix", os.Getenv("OTR_CHANNEL_PREFIX"), "Channel prefix. Defaults to OTR_CHANNEL_PREFIX.")
+	raw := flags.Bool("raw", false, "Print messages exactly as received, rather than decoding them")
+	var namespaces stringSliceFlag
+	flags.Var(&namespaces, "ns", "Only print messages for this namespace (<database>.<collection>). May be repeated.")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialRedis(*redisURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Every message is published on both the collection channel and the
+	// specific (per-document) channel. We only listen to collection channels so
+	// we print each message once.
+	var pubsub *redis.PubSub
+	var description string
+	if len(namespaces) > 0 {
+		channels := make([]string, len(namespaces))
+		for i, ns := range namespaces {
+			channels[i] = *prefix + ns
+		}
+
+		pubsub = client.Subscribe(channels...)
+		description = strings.Join(channels, ", ")
+	} else {
+		pubsub = client.PSubscribe(*prefix + "*")
+		description = *prefix + "*"
+	}
+	defer pubsub.Close()
+
+	// Wait for the subscription to be confirmed, so we report connection
+	// errors rather than silently printing nothing
+	_, err = pubsub.Receive()
+	if err != nil {
+		return fmt.Errorf("Error subscribing to %s: %s", description, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Listening on %s. Interrupt to exit.\n", description)
+
+	done := make(chan bool)
+	go func() {
+		printMessages(pubsub.Channel(), *prefix, *raw)
+		close(done)
+	}()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	defer signal.Reset()
+
+	select {
+	case <-signalChan:
+	case <-done:
+	}
+
+	return nil
+}
+
+// Prints messages from a subscription until the subscription is closed
+func printMessages(messages <-chan *redis.Message, prefix string, raw bool) {
+	for msg := range messages {
+		if strings.Contains(strings.TrimPrefix(msg.Channel, prefix), "::") {
+			// Specific (per-document) channel
+			continue
+		}
+
+		payload := []byte(msg.Payload)
+		fmt.Printf("%s %s %s\n", time.Now().Format("15:04:05.000"), msg.Channel, formatMessage(payload, raw))
+	}
+}
+
+// Formats a published message for display
+func formatMessage(payload []byte, raw bool) string {
+	if raw {
+		return string(payload)
+	}
+
+	msg, err := decodeMessage(payload)
+	if err != nil {
+		return fmt.Sprintf("(could not decode: %s) %s", err, payload)
+	}
+
+	id, _ := json.Marshal(msg.Doc["_id"])
+	return fmt.Sprintf("%s _id=%s fields=%v", eventDescription(msg.Event), id, msg.Fields)
+}
+
+// A published message, decoded
+type decodedMessage struct {
+	Event  string                 `json:"e"`
+	Doc    map[string]interface{} `json:"d"`
+	Fields []string               `json:"f"`
+}
+
+// Decodes a published message, decompressing it first if necessary
+func decodeMessage(payload []byte) (*decodedMessage, error) {
+	// Messages may be gzipped in relay mode
+	if len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err == nil {
+			decompressed, readErr := ioutil.ReadAll(reader)
+			if readErr == nil {
+				payload = decompressed
+			}
+		}
+	}
+
+	var msg decodedMessage
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// Returns a human-readable name for an event type
+func eventDescription(event string) string {
+	switch event {
+	case "i":
+		return "insert"
+	case "u":
+		return "update"
+	case "r":
+		return "remove"
+	default:
+		return event
+	}
+}
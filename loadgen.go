@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The kinds of writes loadgen performs
+var loadgenOperations = []string{"insert", "update", "delete"}
+
+// Implements `oplogtoredis loadgen`, which writes a configurable mix of
+// inserts, updates, and deletes into a test Mongo database at a target rate.
+// It's used for capacity planning and for measuring performance changes
+// reproducibly.
+//
+// It never writes to a database unless it's explicitly named with --db.
+func runLoadgen(args []string) error {
+	flags := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	mongoURL := flags.String("mongo-url", os.Getenv("OTR_MONGO_URL"), "Mongo URL to write to. Defaults to OTR_MONGO_URL.")
+	dbName := flags.String("db", "", "Database to write to. Required.")
+	collectionName := flags.String("collection", "loadgen", "Collection to write to")
+	rate := flags.Float64("rate", 100, "Target writes per second")
+	duration := flags.Duration("duration", 0, "How long to run for. Runs until interrupted if 0.")
+	mixFlag := flags.String("mix", "insert=50,update=40,delete=10", "Relative weights of each kind of write")
+	workers := flags.Int("workers", 4, "Number of concurrent writers")
+	docSize := flags.Int("doc-size", 100, "Approximate size of each document's payload, in bytes")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *dbName == "" {
+		return errors.New("--db is required")
+	}
+	if *rate <= 0 || *workers <= 0 {
+		return errors.New("--rate and --workers must be positive")
+	}
+
+	mix, err := parseOperationMix(*mixFlag)
+	if err != nil {
+		return err
+	}
+
+	mongoClient, err := dialMongo(*mongoURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+
+	gen := &loadGenerator{
+		collection: mongoClient.Database(*dbName).Collection(*collectionName),
+		mix:        mix,
+		payload:    strings.Repeat("x", *docSize),
+		counts:     map[string]*int64{},
+	}
+	for _, op := range append(loadgenOperations, "error") {
+		gen.counts[op] = new(int64)
+	}
+
+	// Hand out one token per write at the target rate; the workers perform a
+	// write for each token they receive
+	tokens := make(chan bool, *workers)
+	waitGroup := sync.WaitGroup{}
+	for i := 0; i < *workers; i++ {
+		waitGroup.Add(1)
+		go func() {
+			for range tokens {
+				gen.write()
+			}
+			waitGroup.Done()
+		}()
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	defer signal.Reset()
+
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	report := time.NewTicker(5 * time.Second)
+	defer report.Stop()
+
+	fmt.Printf("Writing to %s.%s at %.1f writes/sec\n", *dbName, *collectionName, *rate)
+	start := time.Now()
+
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			select {
+			case tokens <- true:
+			default:
+				// All of the workers are busy, so Mongo can't keep up with the
+				// target rate. Skip this write rather than queueing it up.
+			}
+		case <-report.C:
+			gen.printStats(time.Since(start))
+		case <-deadline:
+			break loop
+		case <-signalChan:
+			break loop
+		}
+	}
+
+	close(tokens)
+	waitGroup.Wait()
+
+	fmt.Println("Done.")
+	gen.printStats(time.Since(start))
+
+	return nil
+}
+
+// Relative weights of each kind of write
+type operationMix map[string]int
+
+// Parses a mix like "insert=50,update=40,delete=10"
+func parseOperationMix(s string) (operationMix, error) {
+	mix := operationMix{}
+	total := 0
+
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid --mix entry %q; expected <operation>=<weight>", part)
+		}
+
+		valid := false
+		for _, op := range loadgenOperations {
+			valid = valid || op == kv[0]
+		}
+		if !valid {
+			return nil, fmt.Errorf("Invalid --mix operation %q; expected one of %s", kv[0], strings.Join(loadgenOperations, ", "))
+		}
+
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("Invalid --mix weight %q for %s", kv[1], kv[0])
+		}
+
+		mix[kv[0]] = weight
+		total += weight
+	}
+
+	if total == 0 {
+		return nil, errors.New("--mix weights must not all be zero")
+	}
+
+	return mix, nil
+}
+
+// Picks an operation at random according to the weights
+func (mix operationMix) pick() string {
+	total := 0
+	for _, weight := range mix {
+		total += weight
+	}
+
+	n := rand.Intn(total)
+	for _, op := range loadgenOperations {
+		if n < mix[op] {
+			return op
+		}
+		n -= mix[op]
+	}
+
+	return "insert"
+}
+
+type loadGenerator struct {
+	collection *mongo.Collection
+	mix        operationMix
+	payload    string
+	counts     map[string]*int64
+
+	// IDs of the documents we've inserted and not yet deleted, so we have
+	// something to update and delete
+	ids      []bson.ObjectId
+	idsMutex sync.Mutex
+}
+
+// Performs a single write
+func (gen *loadGenerator) write() {
+	op := gen.mix.pick()
+
+	var err error
+	switch op {
+	case "update":
+		id, ok := gen.randomID(false)
+		if !ok {
+			op = "insert"
+			break
+		}
+		_, err = gen.collection.UpdateOne(context.Background(), mongodb.Doc(bson.M{"_id": id}), mongodb.Doc(bson.M{
+			"$set": bson.M{"payload": gen.payload, "updatedAt": time.Now()},
+			"$inc": bson.M{"version": 1},
+		}))
+	case "delete":
+		id, ok := gen.randomID(true)
+		if !ok {
+			op = "insert"
+			break
+		}
+		_, err = gen.collection.DeleteOne(context.Background(), mongodb.Doc(bson.M{"_id": id}))
+	}
+
+	if op == "insert" {
+		id := bson.NewObjectId()
+		_, err = gen.collection.InsertOne(context.Background(), mongodb.Doc(bson.M{
+			"_id":       id,
+			"payload":   gen.payload,
+			"createdAt": time.Now(),
+			"version":   0,
+		}))
+		if err == nil {
+			gen.idsMutex.Lock()
+			gen.ids = append(gen.ids, id)
+			gen.idsMutex.Unlock()
+		}
+	}
+
+	if err != nil {
+		atomic.AddInt64(gen.counts["error"], 1)
+		return
+	}
+	atomic.AddInt64(gen.counts[op], 1)
+}
+
+// Returns the ID of a random document we inserted, optionally forgetting it
+// (because we're about to delete it)
+func (gen *loadGenerator) randomID(remove bool) (bson.ObjectId, bool) {
+	gen.idsMutex.Lock()
+	defer gen.idsMutex.Unlock()
+
+	if len(gen.ids) == 0 {
+		return "", false
+	}
+
+	i := rand.Intn(len(gen.ids))
+	id := gen.ids[i]
+
+	if remove {
+		gen.ids[i] = gen.ids[len(gen.ids)-1]
+		gen.ids = gen.ids[:len(gen.ids)-1]
+	}
+
+	return id, true
+}
+
+func (gen *loadGenerator) printStats(elapsed time.Duration) {
+	total := int64(0)
+	for _, op := range loadgenOperations {
+		total += atomic.LoadInt64(gen.counts[op])
+	}
+
+	fmt.Printf("%s: %d writes (%.1f/sec): %d inserts, %d updates, %d deletes, %d errors\n",
+		elapsed.Truncate(time.Second),
+		total,
+		float64(total)/elapsed.Seconds(),
+		atomic.LoadInt64(gen.counts["insert"]),
+		atomic.LoadInt64(gen.counts["update"]),
+		atomic.LoadInt64(gen.counts["delete"]),
+		atomic.LoadInt64(gen.counts["error"]))
+}
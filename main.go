@@ -2,39 +2,94 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/chaos"
 	"github.com/tulip/oplogtoredis/lib/config"
+	"github.com/tulip/oplogtoredis/lib/encoding"
+	"github.com/tulip/oplogtoredis/lib/kafka"
+	"github.com/tulip/oplogtoredis/lib/leader"
 	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
 	"github.com/tulip/oplogtoredis/lib/mongourl"
 	"github.com/tulip/oplogtoredis/lib/oplog"
 	"github.com/tulip/oplogtoredis/lib/redispub"
+	"github.com/tulip/oplogtoredis/lib/tlsconfig"
+	"github.com/tulip/oplogtoredis/lib/tracing"
+	"github.com/tulip/oplogtoredis/lib/version"
+	"github.com/tulip/oplogtoredis/lib/webhook"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
 
-	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 	"github.com/go-redis/redis"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	if len(os.Args) > 1 && !isRunFlag(os.Args[1]) {
+		exitCode := runCommand(os.Args[1], os.Args[2:])
+		log.Sync()
+		os.Exit(exitCode)
+	}
+
+	flags, err := parseRunFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(2)
+	}
+
+	// Set when we exit because something failed, so we exit with an error
+	// once everything below has been cleaned up
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	defer log.Sync()
 
-	err := config.ParseEnv()
+	err = config.ParseEnv()
 	if err != nil {
 		panic("Error parsing environment variables: " + err.Error())
 	}
 
-	mongoSession, err := createMongoClient()
+	err = configureLogging()
+	if err != nil {
+		panic("Error configuring logging: " + err.Error())
+	}
+
+	info := version.Get()
+	log.Log.Infow("Starting oplogtoredis",
+		"version", info.Version,
+		"commit", info.Commit,
+		"buildTime", info.BuildTime)
+
+	mongoClient, err := createMongoClient()
 	if err != nil {
 		panic("Error initialize oplog tailer: " + err.Error())
 	}
-	defer mongoSession.Close()
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
 	log.Log.Info("Initialized connection to Mongo")
 
 	redisClient, err := createRedisClient()
@@ -50,56 +105,242 @@ func main() {
 	}()
 	log.Log.Info("Initialized connection to Redis")
 
+	// If dual-writing, every message is also published to a secondary Redis
+	var secondaryRedisClient redis.UniversalClient
+	if config.SecondaryRedisURL() != "" {
+		secondaryRedisClient, err = dialRedis(config.SecondaryRedisURL())
+		if err != nil {
+			panic("Error initializing secondary Redis client: " + err.Error())
+		}
+		defer func() {
+			redisCloseErr := secondaryRedisClient.Close()
+			if redisCloseErr != nil {
+				log.Log.Errorw("Error closing secondary Redis client",
+					"error", redisCloseErr)
+			}
+		}()
+		log.Log.Info("Initialized connection to secondary Redis")
+	}
+
+	// Start a goroutine for the HTTP server. We start this before waiting for
+	// leadership so that standby copies still pass health checks.
+	httpTailers := &readyTailers{}
+	admin := newAdminAPI(config.AdminToken(), httpTailers)
+	httpServer := makeHTTPServer(redisClient, mongoClient, httpTailers, admin)
+	go func() {
+		httpErr := httpServer.ListenAndServe()
+		if httpErr != nil && httpErr != http.ErrServerClosed {
+			panic("Could not start up HTTP server: " + httpErr.Error())
+		}
+	}()
+
+	// If handoff is enabled, ask any already-running copy of oplogtoredis to
+	// drain and tell us where it stopped. We do this before campaigning for
+	// leadership, because the running copy will be holding it.
+	var resumeFrom bson.MongoTimestamp
+	if config.Handoff() {
+		resumeFrom, err = redispub.RequestHandoff(redisClient, metadataPrefix(), config.HandoffTimeout())
+		if err != nil {
+			log.Log.Errorw("Error requesting handoff; starting up without one",
+				"error", err)
+		}
+	}
+	if flags.startTS != 0 || flags.startFrom != oplog.StartFromCheckpoint {
+		// Where the operator told us to start overrides where the copy we
+		// replaced stopped
+		resumeFrom = flags.startTS
+	}
+
+	// If leader election is enabled, wait until we're the leader before we
+	// start tailing. leadershipLost stays nil (and so never fires) if leader
+	// election is disabled.
+	elector, err := createElector(redisClient)
+	if err != nil {
+		panic("Error initializing leader election: " + err.Error())
+	}
+
+	var leadershipLost <-chan struct{}
+	if elector != nil {
+		leadershipLost = elector.Campaign()
+		defer func() {
+			resignErr := elector.Resign()
+			if resignErr != nil {
+				log.Log.Errorw("Error resigning leadership",
+					"error", resignErr)
+			}
+		}()
+	}
+
+	chaosInjector := createChaosInjector()
+
+	tracer := createTracer()
+	defer tracer.Close()
+
+	channelTemplates, err := createChannelTemplates()
+	if err != nil {
+		panic("Error parsing channel templates: " + err.Error())
+	}
+
+	checkpointStore := createCheckpointStore(redisClient, mongoClient)
+
+	tailers, closeShards, err := createTailers(mongoClient, redisClient, checkpointStore, resumeFrom, flags, chaosInjector, tracer)
+	if err != nil {
+		panic("Error initializing oplog tailer: " + err.Error())
+	}
+	defer closeShards()
+	admin.countTailers(tailers)
+	httpTailers.set(tailers)
+
 	// We crate two goroutines:
 	//
 	// The oplog.Tail goroutine reads messages from the oplog, and generates the
 	// messages that we need to write to redis. It then writes them to a
-	// buffered channel.
+	// buffered channel. (For sharded clusters, it runs one Tail per shard,
+	// all writing to the same channel.)
 	//
 	// The redispub.PublishStream goroutine reads messages from the buffered channel
 	// and sends them to Redis.
 	//
-	// TODO PERF: Use a leaky buffer (https://github.com/tulip/oplogtoredis/issues/2)
-	redisPubs := make(chan *redispub.Publication, 10000)
-	waitGroup := sync.WaitGroup{}
+	// With an overflow policy other than "block", a third goroutine
+	// (redispub.BufferPublications) sits between them and holds the buffer,
+	// so it can drop or spill messages when it's full.
+	redisPubCtx, stopRedisPub := context.WithCancel(context.Background())
+	defer stopRedisPub()
+	tailerPubs, redisPubs, bufferDepth := createPublicationBuffer(redisPubCtx)
+	admin.attach(checkpointStore, bufferDepth)
 
-	stopOplogTail := make(chan bool)
-	waitGroup.Add(1)
+	oplogTailCtx, stopOplogTail := context.WithCancel(context.Background())
+	defer stopOplogTail()
+	oplogTailDone := make(chan bool, 1)
+	oplogTailStopped := make(chan struct{})
+	oplogTailFailed := make(chan string, len(tailers))
 	go func() {
-		tailer := oplog.Tailer{
-			MongoClient: mongoSession,
-			RedisClient: redisClient,
-			RedisPrefix: config.RedisMetadataPrefix(),
-			MaxCatchUp:  config.MaxCatchUp(),
+		// In snapshot mode, publish every existing document first, and then
+		// tail from where the oplog was when the snapshot started. Snapshots
+		// can't be combined with sharding, so there's only one tailer.
+		snapshot, checkpointErr := needsSnapshot(checkpointStore, resumeFrom, flags)
+		if checkpointErr != nil {
+			panic("Error reading last-processed timestamp: " + checkpointErr.Error())
+		}
+		if snapshot {
+			snapshotTS, snapshotErr := tailers[0].Snapshot(oplogTailCtx, tailerPubs, config.SnapshotNamespaces(), config.SnapshotRate())
+			if snapshotErr != nil && oplogTailCtx.Err() == nil {
+				panic("Error taking snapshot: " + snapshotErr.Error())
+			}
+			tailers[0].ResumeFrom = snapshotTS
 		}
-		tailer.Tail(redisPubs, stopOplogTail)
+
+		var waitGroup sync.WaitGroup
+		for _, tailer := range tailers {
+			waitGroup.Add(1)
+			go func(tailer *oplog.Tailer) {
+				defer waitGroup.Done()
+				tailer.Tail(oplogTailCtx, tailerPubs)
+				if tailer.Status().GaveUp {
+					oplogTailFailed <- tailer.Shard
+				}
+			}(tailer)
+
+			go tailer.MonitorLag(oplogTailCtx, config.LagMetricInterval())
+			go tailer.MonitorWindow(oplogTailCtx, config.OplogWindowMetricInterval(), config.MinOplogWindow())
+		}
+		waitGroup.Wait()
 
 		log.Log.Info("Oplog tailer completed")
-		waitGroup.Done()
+		reachedStopAt := oplogTailCtx.Err() == nil
+		for _, tailer := range tailers {
+			if tailer.Status().GaveUp {
+				reachedStopAt = false
+			}
+		}
+		if reachedStopAt {
+			// Every tailer reached --stop-at-ts
+			close(oplogTailStopped)
+		}
+		oplogTailDone <- true
 	}()
 
-	stopRedisPub := make(chan bool)
-	waitGroup.Add(1)
+	redisPubDone := make(chan bool, 1)
 	go func() {
-		redispub.PublishStream(redisClient, redisPubs, &redispub.PublishOpts{
-			FlushInterval:    config.TimestampFlushInterval(),
-			DedupeExpiration: config.RedisDedupeExpiration(),
-			MetadataPrefix:   config.RedisMetadataPrefix(),
-		}, stopRedisPub)
+		publishOpts := &redispub.PublishOpts{
+			FlushInterval:      config.TimestampFlushInterval(),
+			DedupeExpiration:   config.RedisDedupeExpiration(),
+			DedupeByContent:    config.DedupeByContent(),
+			MetadataPrefix:     metadataPrefix(),
+			ChannelPrefixes:    config.ChannelPrefixes(),
+			CollectionChannels: config.CustomCollectionChannels(),
+			ChannelTemplates:   channelTemplates,
+			GlobalChannel:      config.GlobalChannel(),
+			MaxRetries:         config.PublishMaxRetries(),
+			RetryBackoff:       reconnectBackoff(),
+			Relay:              createRelayOpts(),
+			Streams:            createStreamOpts(),
+			Workers:            createWorkerOpts(),
+			CheckpointStore:    checkpointStore,
+			FlushStrategy:      redispub.FlushStrategy(config.CheckpointFlushStrategy()),
+			FlushCount:         config.CheckpointFlushCount(),
+			Chaos:              chaosInjector,
+		}
+
+		redisClients := []redis.UniversalClient{redisClient}
+		if secondaryRedisClient != nil {
+			redisClients = append(redisClients, secondaryRedisClient)
+		}
+		publish(redisPubCtx, redisClients, redisPubs, publishOpts, config.PublishWALFile())
 
 		log.Log.Info("Redis publisher completed")
-		waitGroup.Done()
+		redisPubDone <- true
 	}()
 	log.Log.Info("Started up processing goroutines")
 
-	// Start one more goroutine for the HTTP server
-	httpServer := makeHTTPServer(redisClient, mongoSession)
-	go func() {
-		httpErr := httpServer.ListenAndServe()
-		if httpErr != nil {
-			panic("Could not start up HTTP server: " + httpErr.Error())
+	// If enabled, relay redis-oplog synthetic mutations from the inbound
+	// channels to the channels we publish to
+	if config.SyntheticChannelPrefix() != "" {
+		syntheticRelay, relayErr := redispub.StartSyntheticRelay(redisClient, config.SyntheticChannelPrefix(), &redispub.PublishOpts{
+			ChannelPrefixes: config.ChannelPrefixes(),
+			Relay:           createRelayOpts(),
+		})
+		if relayErr != nil {
+			panic("Error starting synthetic mutation relay: " + relayErr.Error())
 		}
-	}()
+		defer syntheticRelay.Close()
+	}
+
+	// Now that we're running, listen for handoff requests from copies of
+	// oplogtoredis that start up after us. handoffRequests stays nil (and so
+	// never fires) if handoff is disabled.
+	var handoffRequests <-chan string
+	if config.Handoff() {
+		handoffListener, listenErr := redispub.ListenForHandoff(redisClient, metadataPrefix(), config.HandoffTimeout())
+		if listenErr != nil {
+			panic("Error listening for handoff requests: " + listenErr.Error())
+		}
+		defer handoffListener.Close()
+
+		handoffRequests = handoffListener.Requests()
+	}
+
+	// Stops tailing the oplog, and then waits for the publisher to publish
+	// everything we've buffered and write the final last-processed
+	// timestamp. If that takes longer than the shutdown timeout, stops the
+	// publisher, leaving the rest of the buffer to be re-read from the oplog
+	// when we (or another copy) next start up. An entry that was only partly
+	// published (like a transaction) isn't recorded as processed, so all of
+	// it is re-read.
+	drain := func() {
+		stopOplogTail()
+		<-oplogTailDone
+
+		close(tailerPubs)
+		select {
+		case <-redisPubDone:
+		case <-time.After(config.ShutdownTimeout()):
+			log.Log.Errorw("Timed out publishing buffered messages; exiting without publishing the rest",
+				"unpublished", len(redisPubs))
+			stopRedisPub()
+			<-redisPubDone
+		}
+	}
 
 	// Now we just wait until we get an exit signal, then exit cleanly
 	//
@@ -107,60 +348,691 @@ func main() {
 	// if we're not ready to receive when the signal is sent.
 	// See examples from https://golang.org/pkg/os/signal/#Notify
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
-	sig := <-signalChan
+	select {
+	case sig := <-signalChan:
+		// We got a SIGINT or SIGTERM (e.g. during a rolling deploy). Publish
+		// what we've buffered, so the next copy of oplogtoredis doesn't have
+		// to, and then return so that the `defer`s above can close the Mongo
+		// and Redis connection.
+		//
+		// We also call signal.Reset() to clear our signal handler so if we get
+		// another signal we immediately exit without cleaning up.
+		log.Log.Warnf("Exiting cleanly due to signal %s; publishing buffered messages first. Interrupt again to force unclean shutdown.", sig)
+		signal.Reset()
 
-	// We got a SIGINT, cleanly stop background goroutines and then return so
-	// that the `defer`s above can close the Mongo and Redis connection.
-	//
-	// We also call signal.Reset() to clear our signal handler so if we get
-	// another SIGINT we immediately exit without cleaning up.
-	log.Log.Warnf("Exiting cleanly due to signal %s. Interrupt again to force unclean shutdown.", sig)
-	signal.Reset()
+		drain()
+		shutdownHTTPServer(httpServer)
+		return
+
+	case <-oplogTailStopped:
+		// We've reached --stop-at-ts. Publish what we've buffered, and exit.
+		log.Log.Warn("Reached --stop-at-ts; publishing buffered messages and exiting.")
 
-	stopOplogTail <- true
-	stopRedisPub <- true
+		drain()
+		shutdownHTTPServer(httpServer)
+		return
 
-	err = httpServer.Shutdown(context.Background())
+	case shard := <-oplogTailFailed:
+		// Tailing failed more than OTR_TAIL_MAX_RESTARTS times in a row.
+		// Publish what we've buffered, and then exit with an error, so our
+		// supervisor restarts us or alerts someone.
+		log.Log.Errorw("Oplog tailing gave up after too many failures in a row; publishing buffered messages and exiting.",
+			"shard", shard)
+		exitCode = 1
+
+		drain()
+		shutdownHTTPServer(httpServer)
+		return
+
+	case <-leadershipLost:
+		// Another copy of oplogtoredis may take over at any moment, so we
+		// stop publishing straight away, without publishing what we've
+		// buffered, and wait for the publisher to stop (abandoning any
+		// retries) before we do anything else. Electors give up leadership
+		// before it expires, so this happens before another copy can take
+		// over. We expect to be restarted by our supervisor, at which point
+		// we'll campaign for leadership again.
+		log.Log.Error("Lost leadership; stopping publishing and exiting.")
+
+		stopRedisPub()
+		<-redisPubDone
+		log.Log.Info("Stopped publishing after losing leadership")
+
+	case handoffRequestID := <-handoffRequests:
+		// A new copy of oplogtoredis has started up and wants to take over.
+		// Stop tailing, publish everything we've buffered, and then tell the
+		// new copy where we stopped.
+		log.Log.Warnw("Handing off to new copy of oplogtoredis; draining and exiting.",
+			"requestID", handoffRequestID)
+
+		drain()
+
+		err = redispub.CompleteHandoff(redisClient, checkpointStore, metadataPrefix(), handoffRequestID, config.HandoffTimeout())
+		if err != nil {
+			log.Log.Errorw("Error completing handoff",
+				"error", err)
+		}
+
+		shutdownHTTPServer(httpServer)
+		return
+	}
+
+	stopOplogTail()
+
+	shutdownHTTPServer(httpServer)
+
+	<-oplogTailDone
+}
+
+func shutdownHTTPServer(httpServer *http.Server) {
+	err := httpServer.Shutdown(context.Background())
 	if err != nil {
 		log.Log.Errorw("Error shutting down HTTP server",
 			"error", err)
 	}
+}
+
+// Returns the version of oplogtoredis to include in messages, or "" if
+// OTR_MESSAGE_PUBLISHER_VERSION isn't set
+func publisherVersion() string {
+	if !config.MessagePublisherVersion() {
+		return ""
+	}
+
+	return version.Version
+}
+
+// Returns whether to take a snapshot of OTR_SNAPSHOT_NAMESPACES before
+// tailing. Snapshots are only taken when there's nowhere to resume from: when
+// nothing has been recorded as processed yet (because oplogtoredis has never
+// run, or was interrupted during the snapshot), and neither the flags nor a
+// handoff say where to start.
+func needsSnapshot(checkpointStore redispub.CheckpointStore, resumeFrom bson.MongoTimestamp, flags *runFlags) (bool, error) {
+	if len(config.SnapshotNamespaces()) == 0 || resumeFrom != 0 || flags.startFrom != oplog.StartFromCheckpoint {
+		return false, nil
+	}
+
+	_, _, err := checkpointStore.LastProcessedTimestamp(metadataPrefix())
+	if err == redispub.ErrNoCheckpoint {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	log.Log.Info("Skipping snapshot, since there's a last-processed timestamp to resume from")
+	return false, nil
+}
+
+// Creates the oplog tailers: just one, or for sharded clusters, one for each
+// shard, reading the shard's oplog directly. Lookups (like fetching full
+// documents) still go through mongoClient. The returned function closes the
+// connections to the shards. The tailers read the last-processed timestamp
+// from checkpointStore, unless flags say where to start.
+func createTailers(mongoClient *mongo.Client, redisClient redis.UniversalClient, checkpointStore redispub.CheckpointStore, resumeFrom bson.MongoTimestamp, flags *runFlags, chaosInjector *chaos.Injector, tracer *tracing.Tracer) ([]*oplog.Tailer, func(), error) {
+	tailerOpts, err := createTailerOpts(mongoClient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tailerOpts = append(tailerOpts,
+		oplog.WithRedisClient(redisClient),
+		oplog.WithRedisPrefix(metadataPrefix()),
+		oplog.WithMaxCatchUp(config.MaxCatchUp()),
+		oplog.WithResumeFrom(resumeFrom),
+		oplog.WithStartFrom(flags.startFrom),
+		oplog.WithStopAt(flags.stopAtTS),
+		oplog.WithDataGapChannel(config.DataGapChannel()),
+		oplog.WithDataGapRestart(oplog.StartPosition(config.DataGapRestart())),
+		oplog.WithChaos(chaosInjector),
+		oplog.WithTracer(tracer),
+		oplog.WithRestartBackoff(reconnectBackoff()),
+		oplog.WithMaxRestarts(config.TailMaxRestarts()),
+	)
+	if config.ChangeStreams() {
+		tailerOpts = append(tailerOpts, oplog.WithSource(oplog.NewChangeStreamSource(mongoClient)))
+	}
+
+	if !config.Sharded() {
+		_, err := redispub.UpgradeCheckpoint(checkpointStore, metadataPrefix(), config.RedisMetadataPrefix())
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error upgrading last-processed timestamp: %s", err)
+		}
+
+		tailerOpts = append(tailerOpts, oplog.WithSink(oplog.NewCheckpointStoreSink(checkpointStore, metadataPrefix())))
+
+		tailer, err := oplog.NewTailer(tailerOpts...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return []*oplog.Tailer{tailer}, func() {}, nil
+	}
+
+	shards, err := oplog.ListShards(mongoClient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error listing shards: %s", err)
+	}
+	if len(shards) == 0 {
+		return nil, nil, errors.New("No shards found in config.shards; OTR_MONGO_URL must point at mongos")
+	}
+
+	var tailers []*oplog.Tailer
+	var shardClients []*mongo.Client
+	closeShards := func() {
+		for _, client := range shardClients {
+			_ = client.Disconnect(context.Background())
+		}
+	}
+
+	for _, shard := range shards {
+		shardClient, err := dialShard(shard)
+		if err != nil {
+			closeShards()
+			return nil, nil, fmt.Errorf("Error connecting to shard %s: %s", shard.Name, err)
+		}
+		shardClients = append(shardClients, shardClient)
+
+		shardPrefix := redispub.ShardMetadataPrefix(metadataPrefix(), shard.Name)
+
+		// Pick up where we left off before a cluster name was set, or before
+		// we tailed each shard
+		_, err = redispub.UpgradeCheckpoint(checkpointStore, shardPrefix,
+			redispub.ShardMetadataPrefix(config.RedisMetadataPrefix(), shard.Name),
+			metadataPrefix(),
+			config.RedisMetadataPrefix(),
+		)
+		if err != nil {
+			closeShards()
+			return nil, nil, fmt.Errorf("Error upgrading last-processed timestamp of shard %s: %s", shard.Name, err)
+		}
+
+		tailer, err := oplog.NewTailer(append(tailerOpts,
+			oplog.WithShard(shard.Name),
+			oplog.WithSource(oplog.NewMongoSource(shardClient)),
+			oplog.WithSink(oplog.NewCheckpointStoreSink(checkpointStore, shardPrefix)),
+		)...)
+		if err != nil {
+			closeShards()
+			return nil, nil, err
+		}
+
+		log.Log.Infow("Tailing shard",
+			"shard", shard.Name,
+			"replicaSet", shard.ReplicaSet,
+			"addrs", shard.Addrs)
+		tailers = append(tailers, tailer)
+	}
+
+	return tailers, closeShards, nil
+}
+
+// Returns the tailer options that determine what's published for each oplog
+// entry, which createTailers and `oplogtoredis replay` share so that replayed
+// entries are published just like they were the first time
+func createTailerOpts(mongoClient *mongo.Client) ([]oplog.Option, error) {
+	tailerOpts := []oplog.Option{
+		oplog.WithMongoClient(mongoClient),
+		oplog.WithFullDocument(config.FullDocument()),
+		oplog.WithFullDocumentProjections(config.FullDocumentProjections()),
+		oplog.WithChangedValues(config.ChangedValues()),
+		oplog.WithRoutingFields(config.RoutingFields()),
+		oplog.WithRoutingLookup(config.RoutingLookup()),
+		oplog.WithRedactFields(config.RedactFields()),
+		oplog.WithHashFields(config.HashFields()),
+		oplog.WithDeadLetterChannel(config.DeadLetterChannel()),
+		oplog.WithCollectionEvents(config.CollectionEvents()),
+		oplog.WithRenameRemoves(config.RenameRemoves()),
+		oplog.WithPublishMigrations(config.PublishMigrations()),
+		oplog.WithFieldPaths(oplog.FieldPaths(config.FieldPaths())),
+		oplog.WithEventNames(oplog.EventNames(config.EventNames())),
+		oplog.WithProtocolVersion(config.ProtocolVersion()),
+		oplog.WithPayloadFormat(oplog.PayloadFormat(config.PayloadFormat())),
+		oplog.WithClusterName(config.ClusterName()),
+		oplog.WithMaxMessageSize(config.MaxMessageSize(), oplog.OversizeAction(config.OversizeAction())),
+		oplog.WithDocumentVersion(config.DocumentVersion()),
+		oplog.WithIncludeNamespace(config.GlobalChannel() != "" || config.MessageNamespace()),
+		oplog.WithIncludeTimestamp(config.MessageTimestamp()),
+		oplog.WithIncludeWallTime(config.MessageWallTime()),
+		oplog.WithPublisherVersion(publisherVersion()),
+	}
+	if config.MongoReadPreference() != "" {
+		tailerOpts = append(tailerOpts, oplog.WithReadPreference(oplog.ReadPreference(config.MongoReadPreference())))
+	}
+
+	excludeNamespaces := config.ExcludeNamespaces()
+	if config.CheckpointStore() == "mongo" {
+		// Saving checkpoints writes to the oplog, so don't publish them
+		excludeNamespaces = append(excludeNamespaces, config.CheckpointCollection())
+	}
+
+	namespaceFilter, err := oplog.NewGlobNamespaceFilter(config.IncludeNamespaces(), excludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	if namespaceFilter != nil {
+		tailerOpts = append(tailerOpts, oplog.WithNamespaceFilter(namespaceFilter))
+	}
 
-	waitGroup.Wait()
+	fullDocumentFilter, err := oplog.NewGlobNamespaceFilter(config.FullDocumentNamespaces(), nil)
+	if err != nil {
+		return nil, err
+	}
+	tailerOpts = append(tailerOpts, oplog.WithFullDocumentFilter(fullDocumentFilter))
+
+	return tailerOpts, nil
 }
 
 // Connects to mongo
-func createMongoClient() (*mgo.Session, error) {
-	// configure mgo to use our logger
-	stdLog, err := zap.NewStdLogAt(log.RawLog, zap.InfoLevel)
+func createMongoClient() (*mongo.Client, error) {
+	return dialMongo(config.MongoURL())
+}
+
+// Connects directly to a shard of the cluster at OTR_MONGO_URL, with the same
+// credentials and options
+func dialShard(shard oplog.Shard) (*mongo.Client, error) {
+	opts, err := mongourl.Parse(config.MongoURL())
 	if err != nil {
-		return nil, fmt.Errorf("Could not create a std logger: %s", err)
+		return nil, fmt.Errorf("Could not parse Mongo URL: %s", err)
 	}
 
-	mgo.SetLogger(stdLog)
+	opts.SetHosts(shard.Addrs)
+	if shard.ReplicaSet == "" {
+		opts.ReplicaSet = nil
+		opts.SetDirect(true)
+	} else {
+		opts.SetReplicaSet(shard.ReplicaSet)
+	}
+
+	return dialMongoWithOptions(opts)
+}
 
-	// get a mgo session
-	dialInfo, err := mongourl.Parse(config.MongoURL())
+// Connects to the mongo server at the given URL
+func dialMongo(mongoURL string) (*mongo.Client, error) {
+	opts, err := mongourl.Parse(mongoURL)
 	if err != nil {
 		return nil, fmt.Errorf("Could not parse Mongo URL: %s", err)
 	}
 
-	session, err := mgo.DialWithInfo(dialInfo)
+	if config.MongoDirect() {
+		opts.SetDirect(true)
+	}
+
+	return dialMongoWithOptions(opts)
+}
+
+// Connects to the mongo server described by opts, with the timeouts, pool
+// size, and app name from the config
+func dialMongoWithOptions(opts *options.ClientOptions) (*mongo.Client, error) {
+	opts.SetConnectTimeout(config.MongoConnectTimeout()).
+		SetServerSelectionTimeout(config.MongoConnectTimeout()).
+		SetSocketTimeout(config.MongoSocketTimeout()).
+		SetAppName(config.MongoAppName())
+	if config.MongoPoolSize() > 0 {
+		opts.SetMaxPoolSize(uint64(config.MongoPoolSize()))
+	}
+
+	client, err := mongodb.Connect(opts)
 	if err != nil {
 		return nil, fmt.Errorf("Error connecting to Mongo: %s", err)
 	}
 
-	session.SetMode(mgo.Monotonic, true)
+	return client, nil
+}
+
+// Publishes every publication to each of the Redis clients (the primary
+// first), and to the webhook and Kafka if they're configured: with
+// redispub.PublishStream if there's just the primary with the default
+// encoding, and with publishToSinks otherwise. With walFile, publications
+// the primary can't take are written to that write-ahead log. Returns when
+// the publisher does.
+func publish(ctx context.Context, clients []redis.UniversalClient, in <-chan *redispub.Publication, opts *redispub.PublishOpts, walFile string) {
+	if len(clients) == 1 && config.WebhookURL() == "" && len(config.KafkaBrokers()) == 0 && walFile == "" && config.RedisEncoding() == "json" && config.RedisCompression() == "none" {
+		redispub.PublishStream(ctx, clients[0], in, opts)
+		return
+	}
+
+	publishToSinks(ctx, clients, in, opts, walFile)
+}
+
+// Publishes every publication to each of the Redis clients, recording the
+// last-processed timestamp in each, and to the webhook and Kafka if they're
+// configured.
+// Messages are encoded and compressed for each client per
+// OTR_REDIS_ENCODING, OTR_SECONDARY_REDIS_ENCODING, OTR_REDIS_COMPRESSION,
+// and OTR_SECONDARY_REDIS_COMPRESSION. With walFile, publications the
+// first client can't take are written to the write-ahead log.
+// Returns when redispub.PublishToSinks does.
+func publishToSinks(ctx context.Context, clients []redis.UniversalClient, in <-chan *redispub.Publication, opts *redispub.PublishOpts, walFile string) {
+	// Secondary clients record the last-processed timestamp in their own
+	// Redis, not in the configured checkpoint store
+	secondaryOpts := *opts
+	secondaryOpts.CheckpointStore = nil
+
+	var sinks []redispub.Sink
+	for i, client := range clients {
+		clientOpts := opts
+		encodingOpts := encoding.SinkOpts{
+			Encoding:             encoding.Encoding(config.RedisEncoding()),
+			Compression:          encoding.Compression(config.RedisCompression()),
+			CompressionThreshold: config.CompressionThreshold(),
+		}
+		if i > 0 {
+			clientOpts = &secondaryOpts
+			encodingOpts.Encoding = encoding.Encoding(config.SecondaryRedisEncoding())
+			encodingOpts.Compression = encoding.Compression(config.SecondaryRedisCompression())
+		}
+
+		sink := redispub.NewRedisSink(client, clientOpts)
+		defer sink.Close()
+
+		sinks = append(sinks, encoding.NewSink(sink, encodingOpts))
+	}
+
+	if walFile != "" {
+		// Only the primary Redis gets a write-ahead log
+		walSink, err := redispub.NewWALSink(sinks[0], walFile)
+		if err != nil {
+			panic("Error opening write-ahead log: " + err.Error())
+		}
+		defer walSink.Close()
+
+		sinks[0] = walSink
+	}
+
+	if config.WebhookURL() != "" {
+		sink := webhook.New(webhook.Opts{
+			URL:         config.WebhookURL(),
+			Secret:      config.WebhookSecret(),
+			BatchSize:   config.WebhookBatchSize(),
+			BatchWindow: config.WebhookBatchWindow(),
+			Concurrency: config.WebhookConcurrency(),
+			MaxRetries:  config.WebhookMaxRetries(),
+		})
+		defer sink.Close()
 
-	return session, nil
+		sinks = append(sinks, sink)
+	}
+
+	if len(config.KafkaBrokers()) > 0 {
+		sink, err := createKafkaSink()
+		if err != nil {
+			panic("Error configuring Kafka: " + err.Error())
+		}
+		defer sink.Close()
+
+		sinks = append(sinks, sink)
+	}
+
+	redispub.PublishToSinksWithOpts(ctx, in, sinks, opts)
+}
+
+// Creates the sink that writes to Kafka, per the OTR_KAFKA_* options
+func createKafkaSink() (*kafka.Sink, error) {
+	var tlsConfig *tls.Config
+	if config.KafkaTLS() {
+		var err error
+		tlsConfig, err = tlsconfig.New(tlsconfig.Options{
+			CAFile:             config.KafkaTLSCAFile(),
+			CertFile:           config.KafkaTLSCertFile(),
+			KeyFile:            config.KafkaTLSKeyFile(),
+			InsecureSkipVerify: config.KafkaTLSInsecureSkipVerify(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error configuring Kafka TLS: %s", err)
+		}
+	}
+
+	return kafka.New(kafka.Opts{
+		Brokers:       config.KafkaBrokers(),
+		TopicPrefix:   config.KafkaTopicPrefix(),
+		BatchSize:     config.KafkaBatchSize(),
+		BatchTimeout:  config.KafkaBatchTimeout(),
+		TLS:           tlsConfig,
+		SASLMechanism: config.KafkaSASLMechanism(),
+		SASLUsername:  config.KafkaSASLUsername(),
+		SASLPassword:  config.KafkaSASLPassword(),
+	})
+}
+
+// Returns the metadata prefix for this cluster: OTR_REDIS_METADATA_PREFIX,
+// namespaced by OTR_CLUSTER_NAME if it's set
+func metadataPrefix() string {
+	return redispub.ClusterMetadataPrefix(config.RedisMetadataPrefix(), config.ClusterName())
+}
+
+// Returns where to store the last-processed timestamp, per
+// OTR_CHECKPOINT_STORE
+func createCheckpointStore(redisClient redis.UniversalClient, mongoClient *mongo.Client) redispub.CheckpointStore {
+	switch config.CheckpointStore() {
+	case "file":
+		return redispub.NewFileCheckpointStore(config.CheckpointFile())
+	case "mongo":
+		// ParseEnv checks that this is "database.collection"
+		parts := strings.SplitN(config.CheckpointCollection(), ".", 2)
+		return redispub.NewMongoCheckpointStore(mongoClient, parts[0], parts[1])
+	default:
+		return redispub.NewRedisCheckpointStore(redisClient)
+	}
+}
+
+// Returns the redispub.StreamOpts for streams mode, or nil if streams mode
+// is disabled
+func createStreamOpts() *redispub.StreamOpts {
+	if !config.Streams() {
+		return nil
+	}
+
+	return &redispub.StreamOpts{
+		MaxLen:      config.StreamsMaxLen(),
+		PerDocument: config.StreamsPerDocument(),
+	}
+}
+
+// Creates the buffer between the oplog tailers and the publisher, returning
+// the channel the tailers write to, the channel the publisher reads from,
+// and a function that returns how many publications are buffered.
+// With OTR_BUFFER_OVERFLOW=block, that's a single buffered channel; otherwise,
+// it starts redispub.BufferPublications between two channels, which closes
+// the second once the first is closed and drained (or ctx is cancelled).
+func createPublicationBuffer(ctx context.Context) (chan *redispub.Publication, chan *redispub.Publication, func() int) {
+	overflow := redispub.OverflowPolicy(config.BufferOverflow())
+	if overflow == redispub.OverflowBlock {
+		pubs := make(chan *redispub.Publication, config.BufferSize())
+		return pubs, pubs, func() int { return len(pubs) }
+	}
+
+	in := make(chan *redispub.Publication)
+	out := make(chan *redispub.Publication)
+	stats := &redispub.BufferStats{}
+	go func() {
+		err := redispub.BufferPublications(ctx, in, out, &redispub.BufferOpts{
+			Size:     config.BufferSize(),
+			Overflow: overflow,
+			SpillDir: config.BufferSpillDir(),
+			Stats:    stats,
+		})
+		if err != nil && ctx.Err() == nil {
+			panic("Error buffering publications: " + err.Error())
+		}
+	}()
+
+	return in, out, stats.Depth
+}
+
+// Returns the redispub.WorkerOpts for publishing with several workers, or nil
+// if there's just one
+func createWorkerOpts() *redispub.WorkerOpts {
+	if config.PublishWorkers() <= 1 {
+		return nil
+	}
+
+	return &redispub.WorkerOpts{
+		Workers:   config.PublishWorkers(),
+		QueueSize: config.PublishWorkerQueueSize(),
+	}
+}
+
+// Returns the redispub.ChannelTemplates for custom channel names, or nil if
+// neither template is set.
+func createChannelTemplates() (*redispub.ChannelTemplates, error) {
+	if config.CollectionChannelTemplate() == "" && config.SpecificChannelTemplate() == "" {
+		return nil, nil
+	}
+
+	return redispub.NewChannelTemplates(config.CollectionChannelTemplate(), config.SpecificChannelTemplate())
+}
+
+// Returns the redispub.RelayOpts for relay mode or for batched publishing
+// (see OTR_PUBLISH_BATCH_SIZE), or nil if neither is enabled.
+func createRelayOpts() *redispub.RelayOpts {
+	if config.RelayMode() {
+		return &redispub.RelayOpts{
+			BatchSize:   config.RelayBatchSize(),
+			BatchWindow: config.RelayBatchWindow(),
+			Compress:    config.RelayCompression(),
+			MaxOutage:   config.RelayMaxOutage(),
+		}
+	}
+
+	if config.PublishBatchSize() > 1 {
+		// Batching uses relay mode's pipelined publisher, retrying batches for
+		// as long as we'd retry a single publication
+		return &redispub.RelayOpts{
+			BatchSize:   config.PublishBatchSize(),
+			BatchWindow: config.PublishBatchWindow(),
+			MaxOutage:   reconnectBackoff().Total(config.PublishMaxRetries()),
+		}
+	}
+
+	return nil
+}
+
+// Returns how long to wait before reconnecting to Mongo and retrying
+// publishes, per OTR_RECONNECT_BACKOFF_INITIAL, OTR_RECONNECT_BACKOFF_MAX, and
+// OTR_RECONNECT_BACKOFF_JITTER
+func reconnectBackoff() backoff.Backoff {
+	return backoff.Backoff{
+		Initial:    config.ReconnectBackoffInitial(),
+		Max:        config.ReconnectBackoffMax(),
+		Multiplier: 2,
+		Jitter:     config.ReconnectBackoffJitter(),
+	}
+}
+
+// Returns the chaos.Injector for chaos mode, or nil if chaos mode is
+// disabled.
+func createChaosInjector() *chaos.Injector {
+	if !config.ChaosMode() {
+		return nil
+	}
+
+	log.Log.Warnw("Chaos mode is enabled; faults will be injected. Never enable this in production.",
+		"publishFailureRate", config.ChaosPublishFailureRate(),
+		"cursorErrorRate", config.ChaosCursorErrorRate(),
+		"latencyRate", config.ChaosLatencyRate(),
+		"maxLatency", config.ChaosLatency())
+
+	return chaos.NewInjector(
+		config.ChaosPublishFailureRate(),
+		config.ChaosCursorErrorRate(),
+		config.ChaosLatencyRate(),
+		config.ChaosLatency(),
+	)
+}
+
+// Reconfigures the logger per OTR_LOG_LEVEL, OTR_LOG_FORMAT,
+// OTR_LOG_SAMPLING_INITIAL, OTR_LOG_SAMPLING_THEREAFTER, and
+// OTR_LOG_THROTTLE
+func configureLogging() error {
+	return log.Configure(log.Opts{
+		Level:              config.LogLevel(),
+		Format:             config.LogFormat(),
+		SamplingInitial:    config.LogSamplingInitial(),
+		SamplingThereafter: config.LogSamplingThereafter(),
+		Throttle:           config.LogThrottle(),
+	})
+}
+
+// Returns the tracing.Tracer that sends spans to OTR_OTLP_ENDPOINT, or nil
+// if tracing is disabled.
+func createTracer() *tracing.Tracer {
+	if config.OtlpEndpoint() == "" {
+		return nil
+	}
+
+	log.Log.Infow("Tracing is enabled",
+		"endpoint", config.OtlpEndpoint(),
+		"sampleRate", config.TraceSampleRate())
+
+	return tracing.New(tracing.Opts{
+		Endpoint:   config.OtlpEndpoint(),
+		SampleRate: config.TraceSampleRate(),
+	})
+}
+
+// Creates the leader.Elector for the configured leader election mechanism, or
+// returns nil if leader election is disabled.
+func createElector(redisClient redis.UniversalClient) (leader.Elector, error) {
+	switch config.LeaderElection() {
+	case "kubernetes":
+		return leader.NewInClusterKubernetesLease(
+			config.LeaderElectionLeaseNamespace(),
+			config.LeaderElectionLeaseName(),
+			config.LeaderElectionIdentity(),
+			config.LeaderElectionLeaseDuration(),
+			config.LeaderElectionRenewDeadline(),
+			config.LeaderElectionRetryPeriod(),
+		)
+	case "redis":
+		return leader.NewRedisLock(
+			redisClient,
+			metadataPrefix()+"leader::"+config.LeaderElectionLeaseName(),
+			config.LeaderElectionIdentity(),
+			config.LeaderElectionLeaseDuration(),
+			config.LeaderElectionRenewDeadline(),
+			config.LeaderElectionRetryPeriod(),
+		)
+	default:
+		return nil, nil
+	}
 }
 
 // Goroutine that just reads messages and sends them to Redis. We don't do this
 // inline above so that messages can queue up in the channel if we lose our
 // redis connection
 func createRedisClient() (redis.UniversalClient, error) {
+	var tlsConfig *tls.Config
+	if config.RedisTLS() {
+		var err error
+		tlsConfig, err = tlsconfig.New(tlsconfig.Options{
+			CAFile:             config.RedisTLSCAFile(),
+			CertFile:           config.RedisTLSCertFile(),
+			KeyFile:            config.RedisTLSKeyFile(),
+			InsecureSkipVerify: config.RedisTLSInsecureSkipVerify(),
+			ServerName:         config.RedisTLSServerName(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error configuring Redis TLS: %s", err)
+		}
+	}
+
+	return dialRedisTopology(config.RedisURL(), config.RedisSentinelMaster(), tlsConfig)
+}
+
+// Connects to the Redis server at the given URL (over TLS if it's a rediss://
+// URL)
+func dialRedis(redisURL string) (redis.UniversalClient, error) {
+	return dialRedisTopology(redisURL, "", nil)
+}
+
+// Connects to Redis. redisURL may be a comma-separated list of URLs: if
+// sentinelMaster is set, they're the URLs of Redis Sentinels to ask for the
+// master's address; otherwise, if there's more than one, they're nodes of a
+// Redis Cluster. If tlsConfig is set, or the URL is a rediss:// URL, we
+// connect over TLS, which our Redis client only supports for a single server.
+func dialRedisTopology(redisURL string, sentinelMaster string, tlsConfig *tls.Config) (redis.UniversalClient, error) {
 	// Configure go-redis to use our logger
 	stdLog, err := zap.NewStdLogAt(log.RawLog, zap.InfoLevel)
 	if err != nil {
@@ -169,18 +1041,51 @@ func createRedisClient() (redis.UniversalClient, error) {
 
 	redis.SetLogger(stdLog)
 
-	// Parse the Redis URL
-	parsedRedisURL, err := redis.ParseURL(config.RedisURL())
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing Redis URL: %s", err)
+	// Parse the Redis URLs. The database and password come from the first
+	// one.
+	var parsedRedisURL *redis.Options
+	var addrs []string
+	for _, url := range strings.Split(redisURL, ",") {
+		parsed, err := redis.ParseURL(strings.TrimSpace(url))
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing Redis URL: %s", err)
+		}
+
+		if parsedRedisURL == nil {
+			parsedRedisURL = parsed
+		}
+		addrs = append(addrs, parsed.Addr)
 	}
 
-	// Create a Redis client
-	client := redis.NewUniversalClient(&redis.UniversalOptions{
-		Addrs:    []string{parsedRedisURL.Addr},
-		DB:       parsedRedisURL.DB,
-		Password: parsedRedisURL.Password,
-	})
+	if tlsConfig == nil {
+		tlsConfig = parsedRedisURL.TLSConfig
+	}
+
+	// Create a Redis client. NewUniversalClient creates a Sentinel-backed
+	// client if MasterName is set, and a Cluster client if there's more than
+	// one address, but it can't pass on a TLS config, so we create a plain
+	// client ourselves for TLS.
+	var client redis.UniversalClient
+	if tlsConfig != nil {
+		if len(addrs) > 1 || sentinelMaster != "" {
+			return nil, errors.New("TLS is only supported for a single Redis server, not for Redis Cluster or Sentinel")
+		}
+
+		host, _, err := net.SplitHostPort(parsedRedisURL.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing Redis address: %s", err)
+		}
+
+		parsedRedisURL.TLSConfig = tlsconfig.ForHost(tlsConfig, host)
+		client = redis.NewClient(parsedRedisURL)
+	} else {
+		client = redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs:      addrs,
+			MasterName: sentinelMaster,
+			DB:         parsedRedisURL.DB,
+			Password:   parsedRedisURL.Password,
+		})
+	}
 
 	// Check that we have a connection
 	_, err = client.Ping().Result()
@@ -191,43 +1096,145 @@ func createRedisClient() (redis.UniversalClient, error) {
 	return client, nil
 }
 
-func makeHTTPServer(redis redis.UniversalClient, mongo *mgo.Session) *http.Server {
+func makeHTTPServer(redis redis.UniversalClient, mongoClient *mongo.Client, tailers *readyTailers, admin *adminAPI) *http.Server {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		redisErr := redis.Ping().Err()
-		redisOK := redisErr == nil
-		if !redisOK {
-			log.Log.Errorw("Error connecting to Redis during healthz check",
-				"error", redisErr)
-		}
+		redisOK, mongoOK := checkConnections(redis, mongoClient, "healthz")
 
-		mongoErr := mongo.Ping()
-		mongoOK := mongoErr == nil
+		writeHealthResponse(w, "healthz", mongoOK && redisOK, map[string]interface{}{
+			"mongoOK": mongoOK,
+			"redisOK": redisOK,
+			"version": version.Get(),
+		})
+	})
 
-		if !mongoOK {
-			log.Log.Errorw("Error connecting to Mongo during healthz check",
-				"error", mongoErr)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		redisOK, mongoOK := checkConnections(redis, mongoClient, "readyz")
+
+		// Standby copies of oplogtoredis (waiting for leadership or a
+		// handoff) don't tail, so they're ready as long as they can connect
+		tailersOK := true
+		var maxLag time.Duration
+		for _, tailer := range tailers.get() {
+			status := tailer.Status()
+			if !status.Paused && (!status.Tailing || time.Since(status.LastActivity) > config.ReadyMaxLag()) {
+				log.Log.Errorw("Oplog tailing is stalled during readyz check",
+					"shard", tailer.Shard,
+					"tailing", status.Tailing,
+					"lastActivity", status.LastActivity)
+				tailersOK = false
+				continue
+			}
+
+			lag, err := tailer.Lag()
+			if err != nil {
+				log.Log.Errorw("Error getting oplog lag during readyz check",
+					"shard", tailer.Shard,
+					"error", err)
+				tailersOK = false
+				continue
+			}
+
+			if lag > maxLag {
+				maxLag = lag
+			}
 		}
 
-		if mongoOK && redisOK {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+		if maxLag > config.ReadyMaxLag() {
+			log.Log.Errorw("Oplog lag exceeds OTR_READY_MAX_LAG during readyz check",
+				"lag", maxLag)
+			tailersOK = false
 		}
 
-		jsonErr := json.NewEncoder(w).Encode(map[string]interface{}{
-			"mongoOK": mongoOK,
-			"redisOK": redisOK,
+		writeHealthResponse(w, "readyz", mongoOK && redisOK && tailersOK, map[string]interface{}{
+			"mongoOK":    mongoOK,
+			"redisOK":    redisOK,
+			"tailingOK":  tailersOK,
+			"lagSeconds": maxLag.Seconds(),
 		})
-		if jsonErr != nil {
-			log.Log.Errorw("Error writing healthz response",
-				"error", jsonErr)
-			http.Error(w, jsonErr.Error(), http.StatusInternalServerError)
-		}
 	})
 
 	mux.Handle("/metrics", promhttp.Handler())
+	admin.register(mux)
+
+	if config.DebugEndpoints() {
+		registerDebugHandlers(mux)
+	}
 
 	return &http.Server{Addr: config.HTTPServerAddr(), Handler: mux}
 }
+
+// Registers Go's profiling endpoints under /debug/pprof/, and its expvar
+// variables on /debug/vars. These are what importing net/http/pprof and
+// expvar register on http.DefaultServeMux, which we don't serve.
+func registerDebugHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	log.Log.Warnw("Serving profiling endpoints on the HTTP server",
+		"addr", config.HTTPServerAddr())
+}
+
+// Pings Redis and Mongo, logging any errors, and returns whether they're
+// reachable
+func checkConnections(redis redis.UniversalClient, mongoClient *mongo.Client, check string) (bool, bool) {
+	redisErr := redis.Ping().Err()
+	redisOK := redisErr == nil
+	if !redisOK {
+		log.Log.Errorw("Error connecting to Redis during "+check+" check",
+			"error", redisErr)
+	}
+
+	mongoErr := mongoClient.Ping(context.Background(), nil)
+	mongoOK := mongoErr == nil
+
+	if !mongoOK {
+		log.Log.Errorw("Error connecting to Mongo during "+check+" check",
+			"error", mongoErr)
+	}
+
+	return redisOK, mongoOK
+}
+
+// Writes the JSON response of a health check, with a 200 status if ok and a
+// 500 otherwise
+func writeHealthResponse(w http.ResponseWriter, check string, ok bool, body map[string]interface{}) {
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	jsonErr := json.NewEncoder(w).Encode(body)
+	if jsonErr != nil {
+		log.Log.Errorw("Error writing "+check+" response",
+			"error", jsonErr)
+		http.Error(w, jsonErr.Error(), http.StatusInternalServerError)
+	}
+}
+
+// The tailers the /readyz endpoint checks. They're created once we're the
+// leader, after the HTTP server has started; until then, there are none.
+type readyTailers struct {
+	mutex   sync.Mutex
+	tailers []*oplog.Tailer
+}
+
+func (r *readyTailers) set(tailers []*oplog.Tailer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tailers = tailers
+}
+
+func (r *readyTailers) get() []*oplog.Tailer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.tailers
+}
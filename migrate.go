@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Implements `oplogtoredis migrate-position`, which copies the
+// last-processed timestamp (and optionally the dedupe keys) to another Redis
+// server or metadata prefix. Use it when moving Redis or renaming
+// OTR_REDIS_METADATA_PREFIX, so the new configuration resumes where the old
+// one left off instead of skipping or replaying events.
+//
+// oplogtoredis should be stopped while this runs; otherwise it will keep
+// advancing the source timestamp after we've copied it.
+func runMigratePosition(args []string) error {
+	flags := flag.NewFlagSet("migrate-position", flag.ContinueOnError)
+	fromURL := flags.String("from-redis-url", os.Getenv("OTR_REDIS_URL"), "Redis URL to copy from. Defaults to OTR_REDIS_URL.")
+	toURL := flags.String("to-redis-url", "", "Redis URL to copy to. Defaults to --from-redis-url.")
+	fromPrefix := flags.String("from-prefix", envOrDefault("OTR_REDIS_METADATA_PREFIX", "oplogtoredis::"), "Metadata prefix to copy from. Defaults to OTR_REDIS_METADATA_PREFIX.")
+	toPrefix := flags.String("to-prefix", "", "Metadata prefix to copy to. Defaults to --from-prefix.")
+	includeDedupe := flags.Bool("dedupe", true, "Also copy the keys used to deduplicate messages")
+	force := flags.Bool("force", false, "Overwrite the destination's last-processed timestamp even if it's later than the source's")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *toURL == "" {
+		*toURL = *fromURL
+	}
+	if *toPrefix == "" {
+		*toPrefix = *fromPrefix
+	}
+	if *toURL == *fromURL && *toPrefix == *fromPrefix {
+		return errors.New("Source and destination are the same; set --to-redis-url or --to-prefix")
+	}
+
+	from, err := dialRedis(*fromURL)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	to, err := dialRedis(*toURL)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	result, err := redispub.MigrateMetadata(from, *fromPrefix, to, *toPrefix, redispub.MigrateOpts{
+		IncludeDedupe: *includeDedupe,
+		Force:         *force,
+	})
+	if err == redispub.ErrDestinationAhead {
+		return fmt.Errorf("%s; pass --force to overwrite it anyway", err)
+	} else if err != nil {
+		return fmt.Errorf("Error migrating metadata: %s", err)
+	}
+
+	if !result.CopiedTimestamp {
+		fmt.Println("No last-processed timestamp found under the source prefix; nothing to resume from")
+	} else {
+		fmt.Println("Copied last-processed timestamp")
+	}
+	if *includeDedupe {
+		fmt.Printf("Copied %d dedupe keys\n", result.CopiedDedupeKeys)
+	}
+
+	return nil
+}
+
+// Returns the value of the given environment variable, or def if it's unset
+func envOrDefault(name string, def string) string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	return value
+}
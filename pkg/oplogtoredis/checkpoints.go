@@ -0,0 +1,83 @@
+package oplogtoredis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/mongodb"
+	"github.com/tulip/oplogtoredis/lib/mongourl"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CheckpointStore is where the last-processed timestamp is stored, so the
+// pipeline resumes where it left off after a restart. By default, it's
+// stored in Config.RedisClient. See OTR_CHECKPOINT_STORE.
+type CheckpointStore struct {
+	store redispub.CheckpointStore
+
+	// The client a Mongo store is connected with
+	client *mongo.Client
+}
+
+// NewRedisCheckpointStore creates a CheckpointStore that stores checkpoints
+// in Redis, under "<MetadataPrefix>lastProcessedEntry". It's the default.
+func NewRedisCheckpointStore(client redis.UniversalClient) *CheckpointStore {
+	return &CheckpointStore{store: redispub.NewRedisCheckpointStore(client)}
+}
+
+// NewFileCheckpointStore creates a CheckpointStore that stores checkpoints in
+// a JSON file on the local disk, for deployments where Redis doesn't persist
+// its data
+func NewFileCheckpointStore(path string) *CheckpointStore {
+	return &CheckpointStore{store: redispub.NewFileCheckpointStore(path)}
+}
+
+// NewMongoCheckpointStore connects to mongoURL and creates a CheckpointStore
+// that stores checkpoints in a collection there, with one document per
+// metadata prefix. If it's the database being tailed, exclude the collection
+// from tailing (see NewGlobNamespaceFilter). The caller must call Close when
+// done.
+func NewMongoCheckpointStore(mongoURL string, database string, collection string) (*CheckpointStore, error) {
+	client, err := dialMongo(mongoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckpointStore{
+		store:  redispub.NewMongoCheckpointStore(client, database, collection),
+		client: client,
+	}, nil
+}
+
+// Close closes the store's connection to Mongo, if it has one
+func (s *CheckpointStore) Close() {
+	if s.client != nil {
+		_ = s.client.Disconnect(context.Background())
+	}
+}
+
+// Returns the store to pass to the lib packages, or nil for the default
+func (s *CheckpointStore) internal() redispub.CheckpointStore {
+	if s == nil {
+		return nil
+	}
+
+	return s.store
+}
+
+// Connects to the Mongo server at the given URL
+func dialMongo(mongoURL string) (*mongo.Client, error) {
+	opts, err := mongourl.Parse(mongoURL)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse Mongo URL: %s", err)
+	}
+
+	client, err := mongodb.Connect(opts)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to Mongo: %s", err)
+	}
+
+	return client, nil
+}
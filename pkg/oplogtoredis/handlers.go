@@ -0,0 +1,191 @@
+package oplogtoredis
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/encoding"
+	"github.com/tulip/oplogtoredis/lib/kafka"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// RedisHandler returns a Handler that publishes to Redis just like Run does.
+// Combine it with your own handlers (see Handlers) to deliver changes
+// somewhere else, like Kafka, in addition to Redis pub/sub.
+func (p *Pipeline) RedisHandler() Handler {
+	return p.RedisHandlerFor(p.config.RedisClient)
+}
+
+// RedisHandlerFor returns a Handler that publishes to another Redis server
+// just like Run publishes to Config.RedisClient, deduplicating with the same
+// keys. Combine it with RedisHandler (see Handlers) to publish every change
+// to several Redis servers. Only Config.RedisClient (or
+// Config.CheckpointStore) stores the last-processed timestamp.
+func (p *Pipeline) RedisHandlerFor(client redis.UniversalClient) Handler {
+	opts := p.publishOpts()
+
+	return func(pub *Publication) error {
+		return redispub.Publish(client, pub.internal(), opts)
+	}
+}
+
+// Handlers combines handlers into a single Handler that calls each of them
+// in turn. If one of them fails, the combined handler fails straight away,
+// and Tail retries the publication with all of the handlers, so each must
+// cope with seeing a publication more than once (RedisHandler does). The
+// combined handler skips a publication only if all of them do.
+func Handlers(handlers ...Handler) Handler {
+	return func(pub *Publication) error {
+		skipped := 0
+
+		for _, handler := range handlers {
+			err := handler(pub)
+			if err == ErrSkip {
+				skipped++
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if len(handlers) > 0 && skipped == len(handlers) {
+			return ErrSkip
+		}
+
+		return nil
+	}
+}
+
+// DocumentID returns the ID of the document a publication is about, in the
+// form redis-oplog uses in document channel names (e.g. the hex string of an
+// ObjectId). Together with pub.CollectionChannel ("<db>.<collection>"), it's
+// suitable for partitioning changes by document, e.g. as a Kafka message key
+// on a topic per collection (which is what KafkaSink does).
+func DocumentID(pub *Publication) string {
+	return redispub.DocumentID(pub.internal())
+}
+
+// Encoding is the name of a message encoding
+type Encoding string
+
+// The message encodings. See OTR_REDIS_ENCODING.
+const (
+	EncodingJSON        Encoding = "json"
+	EncodingMessagePack Encoding = "msgpack"
+	EncodingProtobuf    Encoding = "protobuf"
+)
+
+// Compression is how large messages are compressed
+type Compression string
+
+// The compressions for large messages. See OTR_REDIS_COMPRESSION.
+const (
+	NoCompression   Compression = "none"
+	GzipCompression Compression = "gzip"
+)
+
+// EncodingOpts configures EncodedHandler
+type EncodingOpts struct {
+	// The encoding of messages. Defaults to EncodingJSON.
+	Encoding Encoding
+
+	// How to compress messages that are larger than CompressionThreshold
+	// bytes once they're encoded. Defaults to NoCompression.
+	Compression          Compression
+	CompressionThreshold int
+}
+
+// EncodedHandler wraps a Handler so that it receives messages in another
+// encoding, or compressed, like EncodedHandler(pipeline.RedisHandler(),
+// opts). The publication passed to the wrapped handler is a copy; the
+// original isn't modified, since other handlers may need it as it is.
+func EncodedHandler(handler Handler, opts EncodingOpts) Handler {
+	sink := encoding.NewSink(handlerSink(handler), encoding.SinkOpts{
+		Encoding:             encoding.Encoding(opts.Encoding),
+		Compression:          encoding.Compression(opts.Compression),
+		CompressionThreshold: opts.CompressionThreshold,
+	})
+
+	return func(pub *Publication) error {
+		return sink.Publish(pub.internal())
+	}
+}
+
+// Adapts a Handler to the sinks in the lib packages. Checkpoints are
+// recorded by Tail, not by sinks.
+type handlerSink Handler
+
+func (h handlerSink) Publish(pub *redispub.Publication) error {
+	return h(newPublication(pub))
+}
+
+func (h handlerSink) Checkpoint(pub *redispub.Publication) {}
+
+// KafkaSink writes publications to Kafka, to a topic per collection, keyed
+// by document ID (see DocumentID). Its Publish method is a Handler, so it
+// can be passed to Tail on its own, or combined with RedisHandler (see
+// Handlers). Records are written in batches in the background, and delivery
+// is best-effort: a batch that fails is logged and dropped. See
+// OTR_KAFKA_BROKERS.
+type KafkaSink struct {
+	sink *kafka.Sink
+}
+
+// KafkaOpts configures NewKafkaSink. See OTR_KAFKA_BROKERS and the other
+// OTR_KAFKA_* options.
+type KafkaOpts struct {
+	// The addresses ("host:port") of one or more brokers to look up the rest
+	// of the cluster from
+	Brokers []string
+
+	// Prepended to "<db>.<collection>" to name the topic for each collection
+	TopicPrefix string
+
+	// The client ID to send to the brokers. Defaults to "oplogtoredis".
+	ClientID string
+
+	// The timeout for connecting to a broker, and for each request. Defaults
+	// to 10 seconds.
+	Timeout time.Duration
+
+	// The most records to send to a partition in one request, and how long
+	// to wait for a batch to fill up before sending it anyway. Default to
+	// 100 and 1 second.
+	BatchSize    int
+	BatchTimeout time.Duration
+
+	// If set, connections to the brokers use TLS with this config
+	TLS *tls.Config
+
+	// If set, connections to the brokers are authenticated with SASL, using
+	// this mechanism ("plain", "scram-sha-256", or "scram-sha-512") and
+	// credentials
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+}
+
+// NewKafkaSink creates a KafkaSink. It fails if opts are invalid, but doesn't
+// connect to Kafka until the first Publish. The caller must call Close when
+// done.
+func NewKafkaSink(opts KafkaOpts) (*KafkaSink, error) {
+	sink, err := kafka.New(kafka.Opts(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaSink{sink: sink}, nil
+}
+
+// Publish queues pub's message to be written to Kafka. It only fails once
+// the sink is closed. Publications that aren't changes to documents (like
+// dead letters) aren't written.
+func (s *KafkaSink) Publish(pub *Publication) error {
+	return s.sink.Publish(pub.internal())
+}
+
+// Close writes the records that haven't been written yet, and closes the
+// sink's connections
+func (s *KafkaSink) Close() {
+	s.sink.Close()
+}
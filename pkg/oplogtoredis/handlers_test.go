@@ -0,0 +1,91 @@
+package oplogtoredis
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHandlers(t *testing.T) {
+	someErr := errors.New("Some error")
+
+	tests := map[string]struct {
+		results   []error
+		wantCalls int
+		wantErr   error
+	}{
+		"All acknowledge": {
+			results:   []error{nil, nil},
+			wantCalls: 2,
+		},
+		"One skips": {
+			results:   []error{ErrSkip, nil},
+			wantCalls: 2,
+		},
+		"All skip": {
+			results:   []error{ErrSkip, ErrSkip},
+			wantCalls: 2,
+			wantErr:   ErrSkip,
+		},
+		"First fails": {
+			results:   []error{someErr, nil},
+			wantCalls: 1,
+			wantErr:   someErr,
+		},
+		"Second fails": {
+			results:   []error{nil, someErr},
+			wantCalls: 2,
+			wantErr:   someErr,
+		},
+		"No handlers": {
+			wantErr: nil,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			calls := 0
+
+			var handlers []Handler
+			for _, result := range test.results {
+				result := result
+				handlers = append(handlers, func(*Publication) error {
+					calls++
+					return result
+				})
+			}
+
+			err := Handlers(handlers...)(&Publication{})
+			if err != test.wantErr {
+				t.Errorf("Got error %v, expected %v", err, test.wantErr)
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("Got %d handler calls, expected %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDocumentID(t *testing.T) {
+	pub := &Publication{
+		CollectionChannel: "foo.bar",
+		SpecificChannel:   "foo.bar::5a1b2c3d4e5f607182930a1b",
+	}
+
+	if id := DocumentID(pub); id != "5a1b2c3d4e5f607182930a1b" {
+		t.Errorf("DocumentID() = %q, expected the ID from the document channel", id)
+	}
+}
+
+func TestNewKafkaSink(t *testing.T) {
+	sink, err := NewKafkaSink(KafkaOpts{Brokers: []string{"kafka:9092"}, SASLMechanism: "plain", SASLUsername: "otr"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	sink.Close()
+
+	_, err = NewKafkaSink(KafkaOpts{Brokers: []string{"kafka:9092"}, SASLMechanism: "gssapi", SASLUsername: "otr"})
+	if err == nil {
+		t.Error("Expected an error for an invalid SASL mechanism, but didn't get one")
+	}
+}
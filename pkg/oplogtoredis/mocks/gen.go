@@ -0,0 +1,199 @@
+// +build ignore
+
+// This program generates mocks_gen.go. It's invoked by go generate (see
+// mocks.go); run it again whenever one of the mocked interfaces changes.
+//
+// Each mock is a struct with a <Method>Func field for each method of the
+// interface. Calling a method records the call and then calls the
+// corresponding function.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+)
+
+// The interfaces to mock, keyed by the name of the mock
+var interfaces = map[string]reflect.Type{
+	"OplogSource":   reflect.TypeOf((*oplogtoredis.OplogSource)(nil)).Elem(),
+	"OplogIterator": reflect.TypeOf((*oplogtoredis.OplogIterator)(nil)).Elem(),
+}
+
+func main() {
+	imports := map[string]bool{}
+	var body bytes.Buffer
+
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		writeMock(&body, name, interfaces[name], imports)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintln(&out, "// Code generated by gen.go; DO NOT EDIT.")
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, "package mocks")
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, "import (")
+	for _, path := range sortedKeys(imports) {
+		if isStdlib(path) {
+			fmt.Fprintf(&out, "\t%q\n", path)
+		}
+	}
+	fmt.Fprintln(&out)
+	for _, path := range sortedKeys(imports) {
+		if !isStdlib(path) {
+			fmt.Fprintf(&out, "\t%q\n", path)
+		}
+	}
+	fmt.Fprintln(&out, ")")
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatalf("Could not format generated code: %s\n%s", err, out.Bytes())
+	}
+
+	err = ioutil.WriteFile("mocks_gen.go", src, 0644)
+	if err != nil {
+		log.Fatalf("Could not write mocks_gen.go: %s", err)
+	}
+}
+
+// Writes the struct and methods of a single mock
+func writeMock(w *bytes.Buffer, name string, iface reflect.Type, imports map[string]bool) {
+	imports[importPath(iface)] = true
+
+	fmt.Fprintf(w, "\n// %s is a mock implementation of %s.\n", name, iface)
+	fmt.Fprintf(w, "type %s struct {\n", name)
+	for i := 0; i < iface.NumMethod(); i++ {
+		method := iface.Method(i)
+		fmt.Fprintf(w, "\t%sFunc %s\n", method.Name, funcSignature(method.Type, imports))
+	}
+	fmt.Fprintln(w, "\n\tcalls callRecorder")
+	fmt.Fprintln(w, "}")
+
+	fmt.Fprintf(w, "\nvar _ %s = &%s{}\n", iface, name)
+
+	fmt.Fprintf(w, "\n// Calls returns the calls made to the mock so far, in order.\n")
+	fmt.Fprintf(w, "func (m *%s) Calls() []Call {\n\treturn m.calls.get()\n}\n", name)
+
+	for i := 0; i < iface.NumMethod(); i++ {
+		writeMethod(w, name, iface.Method(i), imports)
+	}
+}
+
+// Writes a method of a mock, which records the call and calls <Method>Func
+func writeMethod(w *bytes.Buffer, name string, method reflect.Method, imports map[string]bool) {
+	t := method.Type
+
+	params := make([]string, t.NumIn())
+	args := make([]string, t.NumIn())
+	for i := range params {
+		args[i] = fmt.Sprintf("arg%d", i)
+		params[i] = args[i] + " " + typeName(t.In(i), imports)
+	}
+
+	fmt.Fprintf(w, "\n// %s calls %sFunc.\n", method.Name, method.Name)
+	fmt.Fprintf(w, "func (m *%s) %s(%s) %s {\n", name, method.Name, strings.Join(params, ", "), results(t, imports))
+	fmt.Fprintf(w, "\tif m.%sFunc == nil {\n", method.Name)
+	fmt.Fprintf(w, "\t\tpanic(\"mocks: %s.%s called, but %sFunc is nil\")\n", name, method.Name, method.Name)
+	fmt.Fprintln(w, "\t}")
+	fmt.Fprintf(w, "\tm.calls.record(%s)\n", strings.Join(append([]string{fmt.Sprintf("%q", method.Name)}, args...), ", "))
+
+	call := fmt.Sprintf("m.%sFunc(%s)", method.Name, strings.Join(args, ", "))
+	if t.NumOut() > 0 {
+		fmt.Fprintf(w, "\treturn %s\n", call)
+	} else {
+		fmt.Fprintf(w, "\t%s\n", call)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// Returns the Go syntax for a function type
+func funcSignature(t reflect.Type, imports map[string]bool) string {
+	params := make([]string, t.NumIn())
+	for i := range params {
+		params[i] = typeName(t.In(i), imports)
+	}
+
+	return fmt.Sprintf("func(%s) %s", strings.Join(params, ", "), results(t, imports))
+}
+
+// Returns the Go syntax for the results of a function type
+func results(t reflect.Type, imports map[string]bool) string {
+	out := make([]string, t.NumOut())
+	for i := range out {
+		out[i] = typeName(t.Out(i), imports)
+	}
+
+	if len(out) == 1 {
+		return out[0]
+	}
+	if len(out) > 1 {
+		return "(" + strings.Join(out, ", ") + ")"
+	}
+	return ""
+}
+
+// Returns the Go syntax for a type, and records the packages it needs
+func typeName(t reflect.Type, imports map[string]bool) string {
+	if t.PkgPath() != "" {
+		imports[importPath(t)] = true
+		return t.String()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + typeName(t.Elem(), imports)
+	case reflect.Slice:
+		return "[]" + typeName(t.Elem(), imports)
+	case reflect.Map:
+		return "map[" + typeName(t.Key(), imports) + "]" + typeName(t.Elem(), imports)
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return "interface{}"
+		}
+	case reflect.Uint8:
+		// byte is an alias, so reflect can't tell them apart; the interfaces
+		// we mock only use byte
+		return "byte"
+	}
+
+	return t.String()
+}
+
+// Returns the path to import a named type's package from, without the
+// vendor directory (if any)
+func importPath(t reflect.Type) string {
+	path := t.PkgPath()
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		path = path[i+len("/vendor/"):]
+	}
+	return path
+}
+
+func isStdlib(path string) bool {
+	return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,117 @@
+// Package mocks provides mock implementations of the interfaces in the
+// oplogtoredis package, for programs that want to test their use of the
+// package without a Mongo or Redis server.
+//
+// The OplogSource and OplogIterator mocks are generated: each has a
+// <Method>Func field per method, which the method calls after recording the
+// call. Hooks records the calls to a Pipeline's lifecycle hooks.
+package mocks
+
+//go:generate go run gen.go
+
+import (
+	"sync"
+
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+)
+
+// Call is a call made to a mock
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Records calls to a mock. It's safe for concurrent use.
+type callRecorder struct {
+	mutex sync.Mutex
+	calls []Call
+}
+
+func (r *callRecorder) record(method string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
+
+func (r *callRecorder) get() []Call {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]Call(nil), r.calls...)
+}
+
+// Hooks records the calls to the lifecycle hooks of the Pipelines it's
+// registered on. It's safe for concurrent use.
+type Hooks struct {
+	mutex        sync.Mutex
+	entries      []oplogtoredis.EntryInfo
+	publications []*oplogtoredis.Publication
+	errors       []error
+	resumes      []oplogtoredis.Timestamp
+}
+
+// Register registers the hooks on a Pipeline. Like the Pipeline's own hook
+// methods, it must be called before the Pipeline starts tailing.
+func (h *Hooks) Register(pipeline *oplogtoredis.Pipeline) {
+	pipeline.OnEntry(h.OnEntry)
+	pipeline.OnPublish(h.OnPublish)
+	pipeline.OnError(h.OnError)
+	pipeline.OnResume(h.OnResume)
+}
+
+// OnEntry records an OnEntry call
+func (h *Hooks) OnEntry(info oplogtoredis.EntryInfo) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.entries = append(h.entries, info)
+}
+
+// OnPublish records an OnPublish call
+func (h *Hooks) OnPublish(pub *oplogtoredis.Publication) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.publications = append(h.publications, pub)
+}
+
+// OnError records an OnError call
+func (h *Hooks) OnError(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.errors = append(h.errors, err)
+}
+
+// OnResume records an OnResume call
+func (h *Hooks) OnResume(ts oplogtoredis.Timestamp) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.resumes = append(h.resumes, ts)
+}
+
+// Entries returns the entries passed to OnEntry so far
+func (h *Hooks) Entries() []oplogtoredis.EntryInfo {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]oplogtoredis.EntryInfo(nil), h.entries...)
+}
+
+// Publications returns the publications passed to OnPublish so far
+func (h *Hooks) Publications() []*oplogtoredis.Publication {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]*oplogtoredis.Publication(nil), h.publications...)
+}
+
+// Errors returns the errors passed to OnError so far
+func (h *Hooks) Errors() []error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]error(nil), h.errors...)
+}
+
+// Resumes returns the timestamps passed to OnResume so far
+func (h *Hooks) Resumes() []oplogtoredis.Timestamp {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]oplogtoredis.Timestamp(nil), h.resumes...)
+}
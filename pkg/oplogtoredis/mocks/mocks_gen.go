@@ -0,0 +1,95 @@
+// Code generated by gen.go; DO NOT EDIT.
+
+package mocks
+
+import (
+	"time"
+
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+)
+
+// OplogIterator is a mock implementation of oplogtoredis.OplogIterator.
+type OplogIterator struct {
+	CloseFunc   func() error
+	ErrFunc     func() error
+	NextFunc    func() ([]byte, bool)
+	TimeoutFunc func() bool
+
+	calls callRecorder
+}
+
+var _ oplogtoredis.OplogIterator = &OplogIterator{}
+
+// Calls returns the calls made to the mock so far, in order.
+func (m *OplogIterator) Calls() []Call {
+	return m.calls.get()
+}
+
+// Close calls CloseFunc.
+func (m *OplogIterator) Close() error {
+	if m.CloseFunc == nil {
+		panic("mocks: OplogIterator.Close called, but CloseFunc is nil")
+	}
+	m.calls.record("Close")
+	return m.CloseFunc()
+}
+
+// Err calls ErrFunc.
+func (m *OplogIterator) Err() error {
+	if m.ErrFunc == nil {
+		panic("mocks: OplogIterator.Err called, but ErrFunc is nil")
+	}
+	m.calls.record("Err")
+	return m.ErrFunc()
+}
+
+// Next calls NextFunc.
+func (m *OplogIterator) Next() ([]byte, bool) {
+	if m.NextFunc == nil {
+		panic("mocks: OplogIterator.Next called, but NextFunc is nil")
+	}
+	m.calls.record("Next")
+	return m.NextFunc()
+}
+
+// Timeout calls TimeoutFunc.
+func (m *OplogIterator) Timeout() bool {
+	if m.TimeoutFunc == nil {
+		panic("mocks: OplogIterator.Timeout called, but TimeoutFunc is nil")
+	}
+	m.calls.record("Timeout")
+	return m.TimeoutFunc()
+}
+
+// OplogSource is a mock implementation of oplogtoredis.OplogSource.
+type OplogSource struct {
+	LastTimestampFunc func() (oplogtoredis.Timestamp, error)
+	TailFromFunc      func(oplogtoredis.Timestamp, time.Duration) oplogtoredis.OplogIterator
+
+	calls callRecorder
+}
+
+var _ oplogtoredis.OplogSource = &OplogSource{}
+
+// Calls returns the calls made to the mock so far, in order.
+func (m *OplogSource) Calls() []Call {
+	return m.calls.get()
+}
+
+// LastTimestamp calls LastTimestampFunc.
+func (m *OplogSource) LastTimestamp() (oplogtoredis.Timestamp, error) {
+	if m.LastTimestampFunc == nil {
+		panic("mocks: OplogSource.LastTimestamp called, but LastTimestampFunc is nil")
+	}
+	m.calls.record("LastTimestamp")
+	return m.LastTimestampFunc()
+}
+
+// TailFrom calls TailFromFunc.
+func (m *OplogSource) TailFrom(arg0 oplogtoredis.Timestamp, arg1 time.Duration) oplogtoredis.OplogIterator {
+	if m.TailFromFunc == nil {
+		panic("mocks: OplogSource.TailFrom called, but TailFromFunc is nil")
+	}
+	m.calls.record("TailFrom", arg0, arg1)
+	return m.TailFromFunc(arg0, arg1)
+}
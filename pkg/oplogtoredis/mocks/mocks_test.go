@@ -0,0 +1,123 @@
+package mocks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+)
+
+func TestMocksWithPipeline(t *testing.T) {
+	data, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(2),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": "someid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An iterator that returns one entry, and then times out forever
+	sent := false
+	iter := &OplogIterator{
+		NextFunc: func() ([]byte, bool) {
+			if sent {
+				time.Sleep(time.Millisecond)
+				return nil, false
+			}
+			sent = true
+			return data, true
+		},
+		ErrFunc:     func() error { return nil },
+		TimeoutFunc: func() bool { return sent },
+		CloseFunc:   func() error { return nil },
+	}
+	source := &OplogSource{
+		TailFromFunc: func(oplogtoredis.Timestamp, time.Duration) oplogtoredis.OplogIterator {
+			return iter
+		},
+	}
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisServer.Close()
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{redisServer.Addr()}})
+	defer client.Close()
+
+	pipeline, err := oplogtoredis.New(oplogtoredis.Config{
+		RedisClient: client,
+		TailerOptions: []oplogtoredis.TailerOption{
+			oplogtoredis.WithSource(source),
+			oplogtoredis.WithResumeFrom(oplogtoredis.Timestamp(1)),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	hooks := &Hooks{}
+	hooks.Register(pipeline)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan *oplogtoredis.Publication, 1)
+	done := make(chan bool)
+	go func() {
+		_ = pipeline.Tail(ctx, func(pub *oplogtoredis.Publication) error {
+			out <- pub
+			return nil
+		})
+		close(done)
+	}()
+
+	select {
+	case pub := <-out:
+		if pub.SpecificChannel != "foo.bar::someid" {
+			t.Errorf("Got publication on %s, expected foo.bar::someid", pub.SpecificChannel)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for publication")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Tail did not return after its context was cancelled")
+	}
+
+	calls := source.Calls()
+	if len(calls) == 0 || calls[0].Method != "TailFrom" || calls[0].Args[0] != oplogtoredis.Timestamp(1) {
+		t.Errorf("Expected TailFrom to be called with timestamp 1, got calls %v", calls)
+	}
+
+	if len(hooks.Resumes()) != 1 || hooks.Resumes()[0] != oplogtoredis.Timestamp(1) {
+		t.Errorf("Expected to resume from timestamp 1, got %v", hooks.Resumes())
+	}
+	if len(hooks.Entries()) != 1 || hooks.Entries()[0].Namespace != "foo.bar" {
+		t.Errorf("Expected 1 entry for foo.bar, got %v", hooks.Entries())
+	}
+	if len(hooks.Publications()) != 1 {
+		t.Errorf("Expected 1 publication, got %v", hooks.Publications())
+	}
+	if len(hooks.Errors()) != 0 {
+		t.Errorf("Expected no errors, got %v", hooks.Errors())
+	}
+}
+
+func TestMockPanicsWithoutFunc(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic when calling a method with no Func set")
+		}
+	}()
+
+	(&OplogSource{}).LastTimestamp()
+}
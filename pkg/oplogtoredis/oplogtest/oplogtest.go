@@ -0,0 +1,194 @@
+// Package oplogtest synthesizes oplog entries, for testing code that consumes
+// the oplog (or the publications oplogtoredis produces from it) without a
+// Mongo server.
+//
+// The functions Insert, Update, UpdateV2, Replace, Remove, Drop,
+// DropDatabase, and Transaction return oplog operations shaped like the ones
+// Mongo writes. A Generator assigns them timestamps and records them in
+// order:
+//
+//	gen := oplogtest.NewGenerator(time.Now())
+//	gen.Add(
+//		oplogtest.Insert("app.users", map[string]interface{}{"_id": "a", "name": "Ann"}),
+//		oplogtest.Update("app.users", "a", map[string]interface{}{
+//			"$set": map[string]interface{}{"name": "Anne"},
+//		}),
+//	)
+//
+// Documents may contain any value that can be marshalled to BSON. The
+// entries can then be passed to Tailer.Process, or tailed by a pipeline
+// through gen.Source() (see oplogtoredis.WithSource).
+package oplogtest
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+)
+
+// Insert returns an insert of doc, which should include an _id
+func Insert(ns string, doc map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"op": "i", "ns": ns, "o": doc}
+}
+
+// Update returns an update to the document with the given _id, in the
+// $set/$unset form that Mongo versions before 5.0 write.
+func Update(ns string, id interface{}, update map[string]interface{}) map[string]interface{} {
+	o := map[string]interface{}{"$v": 1}
+	for key, value := range update {
+		o[key] = value
+	}
+
+	return map[string]interface{}{"op": "u", "ns": ns, "o": o, "o2": map[string]interface{}{"_id": id}}
+}
+
+// UpdateV2 returns an update to the document with the given _id, in the
+// "$v": 2 delta form that Mongo 5.0 and later write. The diff uses Mongo's
+// field names: "i" for inserted fields, "u" for updated fields, "d" for
+// deleted fields, and "s<field>" for a diff of a subdocument.
+func UpdateV2(ns string, id interface{}, diff map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"op": "u",
+		"ns": ns,
+		"o":  map[string]interface{}{"$v": 2, "diff": diff},
+		"o2": map[string]interface{}{"_id": id},
+	}
+}
+
+// Replace returns a replacement of the document with the given _id by doc
+func Replace(ns string, id interface{}, doc map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"op": "u", "ns": ns, "o": doc, "o2": map[string]interface{}{"_id": id}}
+}
+
+// Remove returns a removal of the document with the given _id
+func Remove(ns string, id interface{}) map[string]interface{} {
+	return map[string]interface{}{"op": "d", "ns": ns, "o": map[string]interface{}{"_id": id}}
+}
+
+// Drop returns a command that drops the collection ns
+func Drop(ns string) map[string]interface{} {
+	db, coll := splitNamespace(ns)
+	return map[string]interface{}{"op": "c", "ns": db + ".$cmd", "o": map[string]interface{}{"drop": coll}}
+}
+
+// DropDatabase returns a command that drops the database db
+func DropDatabase(db string) map[string]interface{} {
+	return map[string]interface{}{"op": "c", "ns": db + ".$cmd", "o": map[string]interface{}{"dropDatabase": 1}}
+}
+
+// Transaction returns the applyOps command that Mongo writes when a
+// multi-document transaction containing ops commits. ops may themselves be
+// transactions, to produce nested applyOps.
+func Transaction(ops ...map[string]interface{}) map[string]interface{} {
+	applyOps := make([]interface{}, len(ops))
+	for i, op := range ops {
+		applyOps[i] = op
+	}
+
+	return map[string]interface{}{
+		"op": "c",
+		"ns": "admin.$cmd",
+		"o":  map[string]interface{}{"applyOps": applyOps},
+	}
+}
+
+func splitNamespace(ns string) (string, string) {
+	parts := strings.SplitN(ns, ".", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// Generator assigns timestamps to oplog operations and records the resulting
+// entries. It's safe for concurrent use.
+type Generator struct {
+	mutex   sync.Mutex
+	seconds int64
+	inc     int64
+	entries [][]byte
+
+	// Closed and replaced each time entries are added, to wake up iterators
+	added chan struct{}
+}
+
+// NewGenerator creates a Generator whose first entry has a timestamp in the
+// second of start.
+func NewGenerator(start time.Time) *Generator {
+	return &Generator{
+		seconds: start.Unix(),
+		added:   make(chan struct{}),
+	}
+}
+
+// Add assigns timestamps to ops, in order, and records them as entries (BSON
+// documents). It returns the new entries.
+func (g *Generator) Add(ops ...map[string]interface{}) ([][]byte, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	added := make([][]byte, 0, len(ops))
+	for _, op := range ops {
+		g.inc++
+		ts := bson.MongoTimestamp(g.seconds<<32 | g.inc)
+
+		entry := map[string]interface{}{
+			"ts":   ts,
+			"h":    g.inc,
+			"v":    2,
+			"wall": time.Unix(g.seconds, 0),
+		}
+		for key, value := range op {
+			entry[key] = value
+		}
+
+		data, err := bson.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		added = append(added, data)
+	}
+
+	g.entries = append(g.entries, added...)
+	close(g.added)
+	g.added = make(chan struct{})
+
+	return added, nil
+}
+
+// Tick advances the clock by d, so the next entry's timestamp is in a later
+// second.
+func (g *Generator) Tick(d time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.seconds += int64(d / time.Second)
+	g.inc = 0
+}
+
+// Entries returns all of the entries added so far
+func (g *Generator) Entries() [][]byte {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return append([][]byte(nil), g.entries...)
+}
+
+// Publications returns the publications that tailer produces for the
+// entries added so far. Entries that don't produce a publication are
+// skipped.
+func (g *Generator) Publications(tailer *oplogtoredis.Tailer) []*oplogtoredis.Publication {
+	var pubs []*oplogtoredis.Publication
+	for _, entry := range g.Entries() {
+		pub := tailer.Process(entry)
+		if pub != nil {
+			pubs = append(pubs, pub)
+		}
+	}
+
+	return pubs
+}
@@ -0,0 +1,173 @@
+package oplogtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+)
+
+var start = time.Unix(1526648511, 0)
+
+func TestGeneratorEntries(t *testing.T) {
+	gen := NewGenerator(start)
+	entries, err := gen.Add(
+		Insert("foo.bar", bson.M{"_id": "a", "x": 1}),
+		UpdateV2("foo.bar", "a", bson.M{"u": bson.M{"x": 2}}),
+		Transaction(
+			Insert("foo.bar", bson.M{"_id": "b"}),
+			Remove("foo.bar", "a"),
+		),
+		Drop("foo.bar"),
+	)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(entries) != 4 || len(gen.Entries()) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(gen.Entries()))
+	}
+
+	var parsed []bson.M
+	for _, entry := range entries {
+		var doc bson.M
+		err = bson.Unmarshal(entry, &doc)
+		if err != nil {
+			t.Fatalf("Could not unmarshal entry: %s", err)
+		}
+		parsed = append(parsed, doc)
+	}
+
+	for i, doc := range parsed {
+		want := oplogtoredis.TimestampAt(start) + oplogtoredis.Timestamp(i+1)
+		if got := oplogtoredis.Timestamp(doc["ts"].(bson.MongoTimestamp)); got != want {
+			t.Errorf("Entry %d has timestamp %s, expected %s", i, got, want)
+		}
+	}
+
+	update := parsed[1]["o"].(bson.M)
+	if update["$v"] != 2 || update["diff"] == nil {
+		t.Errorf("Expected a $v: 2 update, got %v", update)
+	}
+
+	txn := parsed[2]
+	if txn["op"] != "c" || txn["ns"] != "admin.$cmd" {
+		t.Errorf("Expected an admin.$cmd command, got %v", txn)
+	}
+	applyOps, _ := txn["o"].(bson.M)["applyOps"].([]interface{})
+	if len(applyOps) != 2 {
+		t.Errorf("Expected 2 operations in applyOps, got %v", txn["o"])
+	}
+
+	drop := parsed[3]
+	if drop["ns"] != "foo.$cmd" || drop["o"].(bson.M)["drop"] != "bar" {
+		t.Errorf("Expected a drop of foo.bar, got %v", drop)
+	}
+}
+
+func TestGeneratorTick(t *testing.T) {
+	gen := NewGenerator(start)
+	_, _ = gen.Add(Insert("foo.bar", bson.M{"_id": "a"}))
+	gen.Tick(2 * time.Second)
+	entries, _ := gen.Add(Insert("foo.bar", bson.M{"_id": "b"}))
+
+	if got := timestamp(entries[0]); got.String() != "1526648513:1" {
+		t.Errorf("Got timestamp %s, expected 1526648513:1", got)
+	}
+}
+
+func TestGeneratorPublications(t *testing.T) {
+	gen := NewGenerator(start)
+	_, _ = gen.Add(
+		Insert("foo.bar", bson.M{"_id": "a", "x": 1}),
+		Update("foo.bar", "a", bson.M{"$set": bson.M{"x": 2}}),
+		Replace("foo.bar", "a", bson.M{"_id": "a", "y": 1}),
+		Remove("foo.bar", "a"),
+		DropDatabase("foo"),
+	)
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{"localhost:6379"}})
+	defer client.Close()
+
+	pipeline, err := oplogtoredis.New(oplogtoredis.Config{
+		RedisClient:   client,
+		TailerOptions: []oplogtoredis.TailerOption{oplogtoredis.WithSource(gen.Source())},
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	// Processing entries doesn't connect to Redis
+	tailer, err := pipeline.NewTailer()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	defer tailer.Close()
+
+	pubs := gen.Publications(tailer)
+	if len(pubs) != 4 {
+		t.Fatalf("Expected 4 publications, got %d", len(pubs))
+	}
+	for _, pub := range pubs {
+		if pub.SpecificChannel != "foo.bar::a" {
+			t.Errorf("Got publication on %s, expected foo.bar::a", pub.SpecificChannel)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	gen := NewGenerator(start)
+	_, _ = gen.Add(Insert("foo.bar", bson.M{"_id": "a"}))
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisServer.Close()
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{redisServer.Addr()}})
+	defer client.Close()
+
+	pipeline, err := oplogtoredis.New(oplogtoredis.Config{
+		RedisClient:   client,
+		TailerOptions: []oplogtoredis.TailerOption{oplogtoredis.WithSource(gen.Source())},
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan *oplogtoredis.Publication, 1)
+	done := make(chan bool)
+	go func() {
+		_ = pipeline.Tail(ctx, func(pub *oplogtoredis.Publication) error {
+			out <- pub
+			return nil
+		})
+		close(done)
+	}()
+
+	// Tailing starts after the last existing entry, so only entries added
+	// from now on are published
+	time.Sleep(50 * time.Millisecond)
+	_, _ = gen.Add(Insert("foo.bar", bson.M{"_id": "b"}))
+
+	select {
+	case pub := <-out:
+		if pub.SpecificChannel != "foo.bar::b" {
+			t.Errorf("Got publication on %s, expected foo.bar::b", pub.SpecificChannel)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for publication")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Tail did not return after its context was cancelled")
+	}
+}
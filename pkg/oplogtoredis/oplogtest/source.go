@@ -0,0 +1,81 @@
+package oplogtest
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/pkg/oplogtoredis"
+)
+
+// Source returns an OplogSource that serves the Generator's entries,
+// including ones added after tailing begins.
+func (g *Generator) Source() oplogtoredis.OplogSource {
+	return &source{gen: g}
+}
+
+type source struct {
+	gen *Generator
+}
+
+func (s *source) LastTimestamp() (oplogtoredis.Timestamp, error) {
+	s.gen.mutex.Lock()
+	defer s.gen.mutex.Unlock()
+
+	if len(s.gen.entries) == 0 {
+		return oplogtoredis.Timestamp(s.gen.seconds << 32), nil
+	}
+
+	return timestamp(s.gen.entries[len(s.gen.entries)-1]), nil
+}
+
+func (s *source) TailFrom(ts oplogtoredis.Timestamp, timeout time.Duration) oplogtoredis.OplogIterator {
+	return &iterator{gen: s.gen, after: ts, timeout: timeout}
+}
+
+type iterator struct {
+	gen      *Generator
+	after    oplogtoredis.Timestamp
+	next     int
+	timeout  time.Duration
+	timedOut bool
+}
+
+func (i *iterator) Next() ([]byte, bool) {
+	i.timedOut = false
+	deadline := time.After(i.timeout)
+
+	for {
+		i.gen.mutex.Lock()
+		for i.next < len(i.gen.entries) {
+			entry := i.gen.entries[i.next]
+			i.next++
+
+			if timestamp(entry) > i.after {
+				i.gen.mutex.Unlock()
+				return entry, true
+			}
+		}
+		added := i.gen.added
+		i.gen.mutex.Unlock()
+
+		select {
+		case <-added:
+		case <-deadline:
+			i.timedOut = true
+			return nil, false
+		}
+	}
+}
+
+func (i *iterator) Err() error    { return nil }
+func (i *iterator) Timeout() bool { return i.timedOut }
+func (i *iterator) Close() error  { return nil }
+
+// Returns the timestamp of an entry created by a Generator
+func timestamp(entry []byte) oplogtoredis.Timestamp {
+	var parsed struct {
+		Timestamp bson.MongoTimestamp `bson:"ts"`
+	}
+	_ = bson.Unmarshal(entry, &parsed)
+	return oplogtoredis.Timestamp(parsed.Timestamp)
+}
@@ -0,0 +1,450 @@
+// Package oplogtoredis lets other Go programs embed the oplogtoredis pipeline
+// directly, instead of running oplogtoredis as a separate process. It tails
+// the oplog of a Mongo replica set and publishes changes to Redis in the
+// format expected by redis-oplog, with the same deduplication and resumption
+// behavior as the oplogtoredis binary.
+//
+// Most programs only need New and Pipeline.Run. Programs that want to deliver
+// changes somewhere other than Redis pub/sub can use Pipeline.Tail, which
+// calls a function with each change instead. Programs that need to connect
+// the two halves of the pipeline themselves can read the oplog with a
+// Tailer (see Pipeline.NewTailer), and publish with Pipeline.Publish.
+//
+// Everything that runs until it's stopped (Pipeline.Run, Pipeline.Tail,
+// Pipeline.Publish, and Tailer.Tail) takes a context.Context, and stops when
+// it's cancelled.
+package oplogtoredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/mongourl"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrGaveUp is returned by Run and Tail when tailing failed more than
+// WithMaxRestarts times in a row, and they gave up.
+var ErrGaveUp = errors.New("Oplog tailing failed too many times in a row; gave up")
+
+// FlushStrategy is when the last-processed timestamp is written
+type FlushStrategy string
+
+// The flush strategies. See OTR_CHECKPOINT_FLUSH_STRATEGY.
+const (
+	// Write it at most once per Config.FlushInterval. The default.
+	FlushEveryInterval FlushStrategy = "interval"
+
+	// Write it once every Config.FlushCount messages
+	FlushEveryCount FlushStrategy = "count"
+
+	// Only write it once no messages arrive for Config.FlushInterval
+	FlushOnIdle FlushStrategy = "idle"
+)
+
+// ChannelTemplates are templates for the names of the channels publications
+// are published to. See NewChannelTemplates.
+type ChannelTemplates struct {
+	templates *redispub.ChannelTemplates
+}
+
+// NewChannelTemplates parses text/template templates for the collection and
+// document channels. They're executed with the fields Prefix (the channel
+// prefix), Database, Collection, and DocID (the document's _id, as it
+// appears at the end of the default document channel; empty for collection
+// channels). An empty template is replaced by the default one, which is
+// redis-oplog's. See OTR_COLLECTION_CHANNEL_TEMPLATE.
+func NewChannelTemplates(collection string, specific string) (*ChannelTemplates, error) {
+	templates, err := redispub.NewChannelTemplates(collection, specific)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChannelTemplates{templates: templates}, nil
+}
+
+// Config configures a Pipeline. MongoURL and RedisClient are required;
+// everything else has the same default as the corresponding oplogtoredis
+// environment variable.
+type Config struct {
+	// The URL of the Mongo replica set whose oplog to tail, in the format of
+	// OTR_MONGO_URL. It may be left empty if TailerOptions include
+	// WithSource, but then options that read documents from Mongo (like
+	// WithFullDocument) aren't available.
+	MongoURL string
+
+	// The Redis client to publish to
+	RedisClient redis.UniversalClient
+
+	// Prefix for the keys used to store metadata, like the last-processed
+	// timestamp. Defaults to "oplogtoredis::". See OTR_REDIS_METADATA_PREFIX.
+	MetadataPrefix string
+
+	// How far back to resume from after a restart. Defaults to 60s. See
+	// OTR_MAX_CATCH_UP.
+	MaxCatchUp time.Duration
+
+	// How many Publications to buffer between the tailer and the publisher.
+	// Defaults to 10,000. See OTR_BUFFER_SIZE.
+	BufferSize int
+
+	// How often to record the last-processed timestamp. Defaults to 1s. See
+	// OTR_TIMESTAMP_FLUSH_INTERVAL.
+	FlushInterval time.Duration
+
+	// When to record the last-processed timestamp. Defaults to
+	// FlushEveryInterval. See OTR_CHECKPOINT_FLUSH_STRATEGY.
+	FlushStrategy FlushStrategy
+
+	// How many messages to publish between recording the last-processed
+	// timestamp with FlushEveryCount. Defaults to 1000. See
+	// OTR_CHECKPOINT_FLUSH_COUNT.
+	FlushCount int
+
+	// How long to remember published messages for deduplication. Defaults to
+	// 120s. See OTR_REDIS_DEDUPE_EXPIRATION.
+	DedupeExpiration time.Duration
+
+	// Prefixes to prepend to channel names. Defaults to no prefix. See
+	// OTR_CHANNEL_PREFIX.
+	ChannelPrefixes []string
+
+	// Channels to publish to instead of "<db>.<collection>", keyed by
+	// "<db>.<collection>", for apps that use redis-oplog's channel or
+	// namespace options. See OTR_COLLECTION_NAMESPACES.
+	CollectionChannels map[string][]string
+
+	// A channel that every message is also published to, in which case
+	// messages include their namespace. Defaults to none. See
+	// OTR_GLOBAL_CHANNEL.
+	GlobalChannel string
+
+	// Templates for the names of the channels to publish to. Defaults to
+	// redis-oplog's channel names. See NewChannelTemplates.
+	ChannelTemplates *ChannelTemplates
+
+	// Where to store the last-processed timestamp. Defaults to RedisClient.
+	// See OTR_CHECKPOINT_STORE.
+	CheckpointStore *CheckpointStore
+
+	// How long to wait before reconnecting after tailing fails, and between
+	// retries of a failed publish. Defaults to DefaultBackoff. See
+	// OTR_RECONNECT_BACKOFF_INITIAL.
+	ReconnectBackoff Backoff
+
+	// Additional options for the Tailer, such as WithNamespaceFilter
+	TailerOptions []TailerOption
+}
+
+// Pipeline tails the oplog and publishes changes to Redis.
+type Pipeline struct {
+	config Config
+
+	// Registers the lifecycle hooks on each Tailer we create
+	registerHooks []func(*oplog.Tailer)
+
+	// Called with each publication that Run gives up on publishing
+	onPublishError []func(*Publication, error)
+}
+
+// New validates the config, fills in defaults, and returns a Pipeline. It
+// doesn't connect to Mongo; Run, Tail, and NewTailer do.
+func New(config Config) (*Pipeline, error) {
+	hasSource := false
+	for _, opt := range config.TailerOptions {
+		if opt.option == nil {
+			return nil, errors.New("TailerOptions must be created with the With* functions")
+		}
+
+		hasSource = hasSource || opt.source
+	}
+
+	if config.MongoURL == "" && !hasSource {
+		return nil, errors.New("MongoURL is required")
+	}
+
+	if config.MongoURL != "" {
+		_, err := mongourl.Parse(config.MongoURL)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse MongoURL: %s", err)
+		}
+	}
+
+	if config.RedisClient == nil {
+		return nil, errors.New("RedisClient is required")
+	}
+
+	if config.MetadataPrefix == "" {
+		config.MetadataPrefix = "oplogtoredis::"
+	}
+
+	if config.MaxCatchUp == 0 {
+		config.MaxCatchUp = 60 * time.Second
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = 10000
+	}
+
+	if config.FlushInterval == 0 {
+		config.FlushInterval = time.Second
+	}
+
+	if config.FlushCount == 0 {
+		config.FlushCount = 1000
+	}
+
+	if config.DedupeExpiration == 0 {
+		config.DedupeExpiration = 120 * time.Second
+	}
+
+	if config.MaxCatchUp < 0 || config.BufferSize < 0 || config.FlushInterval < 0 || config.FlushCount < 0 || config.DedupeExpiration < 0 {
+		return nil, errors.New("MaxCatchUp, BufferSize, FlushInterval, FlushCount, and DedupeExpiration must not be negative")
+	}
+
+	switch config.FlushStrategy {
+	case "", FlushEveryInterval, FlushEveryCount, FlushOnIdle:
+	default:
+		return nil, fmt.Errorf("Invalid FlushStrategy %q", config.FlushStrategy)
+	}
+
+	if config.DedupeExpiration < time.Second {
+		// Redis expirations are in whole seconds
+		return nil, errors.New("DedupeExpiration must be at least 1s")
+	}
+
+	// Check that the tailer options are valid. The client is only used once
+	// we've connected, so we don't need one here.
+	for _, opt := range config.TailerOptions {
+		err := opt.option(nil)(&oplog.Tailer{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Pipeline{config: config}, nil
+}
+
+// NewTailer connects to Mongo and creates a Tailer from the pipeline's
+// config, with the pipeline's hooks registered on it. The caller must call
+// Close when done. Run and Tail create their own, so this is only needed to
+// connect the tailer to a publisher yourself (see Publish).
+func (p *Pipeline) NewTailer() (*Tailer, error) {
+	var client *mongo.Client
+	if p.config.MongoURL != "" {
+		var err error
+		client, err = dialMongo(p.config.MongoURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tailer, err := p.newTailer(client)
+	if err != nil {
+		if client != nil {
+			_ = client.Disconnect(context.Background())
+		}
+		return nil, err
+	}
+
+	return &Tailer{tailer: tailer, client: client}, nil
+}
+
+// Creates a tailer from the pipeline's config that reads with client, if it
+// isn't nil
+func (p *Pipeline) newTailer(client *mongo.Client) (*oplog.Tailer, error) {
+	opts := []oplog.Option{
+		oplog.WithRedisClient(p.config.RedisClient),
+		oplog.WithRedisPrefix(p.config.MetadataPrefix),
+		oplog.WithMaxCatchUp(p.config.MaxCatchUp),
+		oplog.WithIncludeNamespace(p.config.GlobalChannel != ""),
+		oplog.WithRestartBackoff(backoff.Backoff(p.config.ReconnectBackoff)),
+	}
+	if client != nil {
+		opts = append(opts, oplog.WithMongoClient(client))
+	}
+	if p.config.CheckpointStore != nil {
+		opts = append(opts, oplog.WithSink(oplog.NewCheckpointStoreSink(p.config.CheckpointStore.internal(), p.config.MetadataPrefix)))
+	}
+	for _, opt := range p.config.TailerOptions {
+		opts = append(opts, opt.option(client))
+	}
+
+	tailer, err := oplog.NewTailer(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, register := range p.registerHooks {
+		register(tailer)
+	}
+
+	return tailer, nil
+}
+
+// OnEntry registers a function to be called with each oplog entry received.
+// Hooks must be registered before calling Run, Tail, or NewTailer.
+func (p *Pipeline) OnEntry(fn func(EntryInfo)) {
+	p.registerHooks = append(p.registerHooks, func(tailer *oplog.Tailer) {
+		tailer.OnEntry(func(info oplog.EntryInfo) { fn(newEntryInfo(info)) })
+	})
+}
+
+// BeforePublish registers a function that can change each publication the
+// tailer produces, or drop it by returning false, before it's published (or
+// passed to Tail's handler). Use it to redact fields or add metadata without
+// forking the processor. Hooks must be registered before calling Run, Tail,
+// or NewTailer.
+func (p *Pipeline) BeforePublish(fn func(*Publication) bool) {
+	p.registerHooks = append(p.registerHooks, func(tailer *oplog.Tailer) {
+		tailer.BeforePublish(func(pub *redispub.Publication) bool {
+			wrapped := newPublication(pub)
+			keep := fn(wrapped)
+			wrapped.internal()
+			return keep
+		})
+	})
+}
+
+// OnPublishError registers a function to be called with each publication
+// that Run couldn't publish to Redis after retrying, and the error. (Tail
+// retries failing handlers until they succeed, so it never gives up on a
+// publication.)
+func (p *Pipeline) OnPublishError(fn func(*Publication, error)) {
+	p.onPublishError = append(p.onPublishError, fn)
+}
+
+// OnPublish registers a function to be called with each publication the
+// tailer produces, once it's handed off to be published
+func (p *Pipeline) OnPublish(fn func(*Publication)) {
+	p.registerHooks = append(p.registerHooks, func(tailer *oplog.Tailer) {
+		tailer.OnPublish(func(pub *redispub.Publication) { fn(newPublication(pub)) })
+	})
+}
+
+// OnError registers a function to be called with each error the tailer
+// encounters
+func (p *Pipeline) OnError(fn func(error)) {
+	p.registerHooks = append(p.registerHooks, func(tailer *oplog.Tailer) { tailer.OnError(fn) })
+}
+
+// OnResume registers a function to be called with the timestamp the tailer
+// starts after, each time it starts tailing
+func (p *Pipeline) OnResume(fn func(Timestamp)) {
+	p.registerHooks = append(p.registerHooks, func(tailer *oplog.Tailer) {
+		tailer.OnResume(func(ts bson.MongoTimestamp) { fn(Timestamp(ts)) })
+	})
+}
+
+// Run connects to Mongo, and tails the oplog and publishes changes until
+// ctx is cancelled. Before returning, it publishes every change it has
+// already read from the oplog and records the last-processed timestamp, so
+// the next Run resumes exactly where this one stopped.
+//
+// Run returns ctx.Err() once ctx is cancelled, nil if it stopped at the
+// timestamp given to WithStopAt, ErrGaveUp if tailing failed more than
+// WithMaxRestarts times in a row, and an error if it can't connect to Mongo.
+func (p *Pipeline) Run(ctx context.Context) error {
+	tailer, err := p.NewTailer()
+	if err != nil {
+		return err
+	}
+	defer tailer.Close()
+
+	pubs := make(chan *redispub.Publication, p.config.BufferSize)
+
+	tailDone := make(chan bool)
+	go func() {
+		tailer.tailer.Tail(ctx, pubs)
+		close(tailDone)
+	}()
+
+	publishDone := make(chan bool)
+	go func() {
+		// The publisher isn't cancelled with ctx; it stops when we close pubs
+		redispub.PublishStream(context.Background(), p.config.RedisClient, pubs, p.publishOpts())
+		close(publishDone)
+	}()
+
+	<-tailDone
+
+	// Closing the channel makes the publisher drain it and return
+	close(pubs)
+	<-publishDone
+
+	return tailer.err(ctx)
+}
+
+// Publish publishes the Publications it reads from in to Redis, just like
+// Run, and records the last-processed timestamp. It's the other half of
+// Tailer.Tail, for programs that connect the two themselves.
+//
+// It returns when ctx is cancelled, or when in is closed, in which case it
+// first publishes every Publication remaining in it. In both cases, it
+// records the timestamp of the last Publication it published before
+// returning.
+func (p *Pipeline) Publish(ctx context.Context, in <-chan *Publication) {
+	pubs := make(chan *redispub.Publication)
+	go func() {
+		defer close(pubs)
+
+		for {
+			select {
+			case pub, ok := <-in:
+				if !ok {
+					return
+				}
+
+				select {
+				case pubs <- pub.internal():
+				case <-ctx.Done():
+					return
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	redispub.PublishStream(ctx, p.config.RedisClient, pubs, p.publishOpts())
+}
+
+// Returns the PublishOpts for publishing to Redis from the pipeline's config
+func (p *Pipeline) publishOpts() *redispub.PublishOpts {
+	opts := &redispub.PublishOpts{
+		FlushInterval:      p.config.FlushInterval,
+		FlushStrategy:      redispub.FlushStrategy(p.config.FlushStrategy),
+		FlushCount:         p.config.FlushCount,
+		DedupeExpiration:   p.config.DedupeExpiration,
+		MetadataPrefix:     p.config.MetadataPrefix,
+		ChannelPrefixes:    p.config.ChannelPrefixes,
+		CollectionChannels: p.config.CollectionChannels,
+		GlobalChannel:      p.config.GlobalChannel,
+		CheckpointStore:    p.config.CheckpointStore.internal(),
+		RetryBackoff:       backoff.Backoff(p.config.ReconnectBackoff),
+	}
+
+	if p.config.ChannelTemplates != nil {
+		opts.ChannelTemplates = p.config.ChannelTemplates.templates
+	}
+
+	if len(p.onPublishError) > 0 {
+		onPublishError := p.onPublishError
+		opts.OnPublishError = func(pub *redispub.Publication, err error) {
+			wrapped := newPublication(pub)
+			for _, fn := range onPublishError {
+				fn(wrapped, err)
+			}
+		}
+	}
+
+	return opts
+}
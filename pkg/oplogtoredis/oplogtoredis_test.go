@@ -0,0 +1,356 @@
+package oplogtoredis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/backoff"
+	"github.com/tulip/oplogtoredis/lib/encoding"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+func TestNew(t *testing.T) {
+	mongoURL := "mongodb://localhost"
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{"localhost:6379"}})
+	defer client.Close()
+
+	tests := map[string]struct {
+		config      Config
+		want        Config
+		expectError bool
+	}{
+		"Defaults": {
+			config: Config{MongoURL: mongoURL, RedisClient: client},
+			want: Config{
+				MongoURL:         mongoURL,
+				RedisClient:      client,
+				MetadataPrefix:   "oplogtoredis::",
+				MaxCatchUp:       60 * time.Second,
+				BufferSize:       10000,
+				FlushInterval:    time.Second,
+				FlushCount:       1000,
+				DedupeExpiration: 120 * time.Second,
+			},
+		},
+		"Overrides": {
+			config: Config{
+				MongoURL:         mongoURL,
+				RedisClient:      client,
+				MetadataPrefix:   "someprefix.",
+				MaxCatchUp:       time.Minute,
+				BufferSize:       10,
+				FlushInterval:    time.Minute,
+				FlushStrategy:    FlushEveryCount,
+				FlushCount:       50,
+				DedupeExpiration: time.Minute,
+			},
+			want: Config{
+				MongoURL:         mongoURL,
+				RedisClient:      client,
+				MetadataPrefix:   "someprefix.",
+				MaxCatchUp:       time.Minute,
+				BufferSize:       10,
+				FlushInterval:    time.Minute,
+				FlushStrategy:    FlushEveryCount,
+				FlushCount:       50,
+				DedupeExpiration: time.Minute,
+			},
+		},
+		"Source instead of Mongo URL": {
+			config: Config{RedisClient: client, TailerOptions: []TailerOption{WithSource(&failingSource{})}},
+			want: Config{
+				RedisClient:      client,
+				MetadataPrefix:   "oplogtoredis::",
+				MaxCatchUp:       60 * time.Second,
+				BufferSize:       10000,
+				FlushInterval:    time.Second,
+				FlushCount:       1000,
+				DedupeExpiration: 120 * time.Second,
+			},
+		},
+		"Missing Mongo URL": {
+			config:      Config{RedisClient: client},
+			expectError: true,
+		},
+		"Invalid Mongo URL": {
+			config:      Config{MongoURL: "mongodb://localhost/?ssl=maybe", RedisClient: client},
+			expectError: true,
+		},
+		"Missing Redis client": {
+			config:      Config{MongoURL: mongoURL},
+			expectError: true,
+		},
+		"Invalid tailer option": {
+			config:      Config{MongoURL: mongoURL, RedisClient: client, TailerOptions: []TailerOption{WithMaxRestarts(-1)}},
+			expectError: true,
+		},
+		"Zero tailer option": {
+			config:      Config{MongoURL: mongoURL, RedisClient: client, TailerOptions: []TailerOption{{}}},
+			expectError: true,
+		},
+		"Negative buffer size": {
+			config:      Config{MongoURL: mongoURL, RedisClient: client, BufferSize: -1},
+			expectError: true,
+		},
+		"Invalid flush strategy": {
+			config:      Config{MongoURL: mongoURL, RedisClient: client, FlushStrategy: "never"},
+			expectError: true,
+		},
+		"Sub-second dedupe expiration": {
+			config:      Config{MongoURL: mongoURL, RedisClient: client, DedupeExpiration: time.Millisecond},
+			expectError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			pipeline, err := New(test.config)
+
+			if test.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, but didn't get one")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Got unexpected error: %s", err)
+			}
+
+			got := pipeline.config
+			if got.MongoURL != test.want.MongoURL ||
+				got.RedisClient != test.want.RedisClient ||
+				got.MetadataPrefix != test.want.MetadataPrefix ||
+				got.MaxCatchUp != test.want.MaxCatchUp ||
+				got.BufferSize != test.want.BufferSize ||
+				got.FlushInterval != test.want.FlushInterval ||
+				got.FlushStrategy != test.want.FlushStrategy ||
+				got.FlushCount != test.want.FlushCount ||
+				got.DedupeExpiration != test.want.DedupeExpiration {
+				t.Errorf("Got config %#v, expected %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPublishOpts(t *testing.T) {
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{"localhost:6379"}})
+	defer client.Close()
+
+	templates, err := NewChannelTemplates("", "{{.Prefix}}{{.Collection}}/{{.DocID}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileCheckpointStore("/tmp/checkpoints.json")
+
+	pipeline, err := New(Config{
+		MongoURL:         "mongodb://localhost",
+		RedisClient:      client,
+		ChannelPrefixes:  []string{"prefix."},
+		GlobalChannel:    "firehose",
+		ChannelTemplates: templates,
+		CheckpointStore:  store,
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	opts := pipeline.publishOpts()
+	if opts.MetadataPrefix != "oplogtoredis::" ||
+		opts.DedupeExpiration != 120*time.Second ||
+		!reflect.DeepEqual(opts.ChannelPrefixes, []string{"prefix."}) ||
+		opts.GlobalChannel != "firehose" ||
+		opts.ChannelTemplates != templates.templates ||
+		opts.CheckpointStore != store.store {
+		t.Errorf("Got publish options %#v", opts)
+	}
+}
+
+func TestOnPublishError(t *testing.T) {
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{"localhost:6379"}})
+	defer client.Close()
+
+	pipeline, err := New(Config{MongoURL: "mongodb://localhost", RedisClient: client})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if pipeline.publishOpts().OnPublishError != nil {
+		t.Error("Expected no OnPublishError without any hooks")
+	}
+
+	var calls []string
+	pipeline.OnPublishError(func(pub *Publication, err error) { calls = append(calls, "first "+err.Error()) })
+	pipeline.OnPublishError(func(pub *Publication, err error) { calls = append(calls, "second "+err.Error()) })
+
+	pipeline.publishOpts().OnPublishError(&redispub.Publication{}, errors.New("oops"))
+
+	if !reflect.DeepEqual(calls, []string{"first oops", "second oops"}) {
+		t.Errorf("Got calls %v, expected [first oops second oops]", calls)
+	}
+}
+
+// A source whose cursors always fail straight away
+type failingSource struct{}
+
+func (s *failingSource) LastTimestamp() (Timestamp, error) {
+	return TimestampAt(time.Now()), nil
+}
+
+func (s *failingSource) TailFrom(ts Timestamp, timeout time.Duration) OplogIterator {
+	return &failingIterator{}
+}
+
+type failingIterator struct{}
+
+func (i *failingIterator) Next() ([]byte, bool) { return nil, false }
+func (i *failingIterator) Err() error           { return errors.New("Cursor failed") }
+func (i *failingIterator) Timeout() bool        { return false }
+func (i *failingIterator) Close() error         { return nil }
+
+func TestGiveUp(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisServer.Close()
+
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{redisServer.Addr()}})
+	defer client.Close()
+
+	pipeline, err := New(Config{
+		RedisClient:      client,
+		ReconnectBackoff: Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		TailerOptions: []TailerOption{
+			WithSource(&failingSource{}),
+			WithMaxRestarts(2),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	tests := map[string]func(ctx context.Context) error{
+		"Run": pipeline.Run,
+		"Tail": func(ctx context.Context) error {
+			return pipeline.Tail(ctx, func(*Publication) error { return nil })
+		},
+	}
+
+	for testName, run := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := run(ctx)
+			if err != ErrGaveUp {
+				t.Errorf("Got error %v, expected ErrGaveUp", err)
+			}
+		})
+	}
+}
+
+func TestBeforePublish(t *testing.T) {
+	client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{"localhost:6379"}})
+	defer client.Close()
+
+	pipeline, err := New(Config{
+		RedisClient:   client,
+		TailerOptions: []TailerOption{WithSource(&failingSource{})},
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	pipeline.BeforePublish(func(pub *Publication) bool {
+		pub.ExtraChannels = append(pub.ExtraChannels, "extra")
+		return pub.SpecificChannel != "foo.bar::dropped"
+	})
+
+	tailer, err := pipeline.NewTailer()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	defer tailer.Close()
+
+	pub := tailer.Process(marshalEntry(t, "kept"))
+	if pub == nil || !reflect.DeepEqual(pub.ExtraChannels, []string{"extra"}) {
+		t.Errorf("Got publication %#v, expected one with the extra channel", pub)
+	}
+	if pub != nil && !reflect.DeepEqual(pub.internal().ExtraChannels, []string{"extra"}) {
+		t.Errorf("Extra channel wasn't passed on to the publisher: %#v", pub.internal())
+	}
+
+	if pub := tailer.Process(marshalEntry(t, "dropped")); pub != nil {
+		t.Errorf("Got publication %#v, expected it to be dropped", pub)
+	}
+}
+
+// Returns an insert of a document with the given _id into foo.bar
+func marshalEntry(t *testing.T, id string) []byte {
+	data, err := bson.Marshal(bson.M{
+		"ts": bson.MongoTimestamp(1234),
+		"op": "i",
+		"ns": "foo.bar",
+		"o":  bson.M{"_id": id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// The constants here are converted to the lib packages' types, so they must
+// stay in sync with them
+func TestConstants(t *testing.T) {
+	tests := map[string][2]interface{}{
+		"ReadPrimary":              {string(ReadPrimary), string(oplog.ReadPrimary)},
+		"ReadPrimaryPreferred":     {string(ReadPrimaryPreferred), string(oplog.ReadPrimaryPreferred)},
+		"ReadSecondary":            {string(ReadSecondary), string(oplog.ReadSecondary)},
+		"ReadSecondaryPreferred":   {string(ReadSecondaryPreferred), string(oplog.ReadSecondaryPreferred)},
+		"ReadNearest":              {string(ReadNearest), string(oplog.ReadNearest)},
+		"StartFromCheckpoint":      {string(StartFromCheckpoint), string(oplog.StartFromCheckpoint)},
+		"StartFromOldest":          {string(StartFromOldest), string(oplog.StartFromOldest)},
+		"StartFromNewest":          {string(StartFromNewest), string(oplog.StartFromNewest)},
+		"FieldPathsFull":           {string(FieldPathsFull), string(oplog.FieldPathsFull)},
+		"FieldPathsTopLevel":       {string(FieldPathsTopLevel), string(oplog.FieldPathsTopLevel)},
+		"FieldPathsBoth":           {string(FieldPathsBoth), string(oplog.FieldPathsBoth)},
+		"ProtocolVersion1":         {int(ProtocolVersion1), int(oplog.ProtocolVersion1)},
+		"ProtocolVersion2":         {int(ProtocolVersion2), int(oplog.ProtocolVersion2)},
+		"LatestProtocolVersion":    {int(LatestProtocolVersion), int(oplog.LatestProtocolVersion)},
+		"PayloadFormatRedisOplog":  {string(PayloadFormatRedisOplog), string(oplog.PayloadFormatRedisOplog)},
+		"PayloadFormatCloudEvents": {string(PayloadFormatCloudEvents), string(oplog.PayloadFormatCloudEvents)},
+		"PayloadFormatDebezium":    {string(PayloadFormatDebezium), string(oplog.PayloadFormatDebezium)},
+		"OversizeTruncate":         {string(OversizeTruncate), string(oplog.OversizeTruncate)},
+		"OversizeRefetch":          {string(OversizeRefetch), string(oplog.OversizeRefetch)},
+		"OversizeDrop":             {string(OversizeDrop), string(oplog.OversizeDrop)},
+		"RefetchEvent":             {string(RefetchEvent), string(oplog.RefetchEvent)},
+		"EventNamesMeteor":         {string(EventNamesMeteor), string(oplog.EventNamesMeteor)},
+		"EventNamesOplog":          {string(EventNamesOplog), string(oplog.EventNamesOplog)},
+		"EventNamesWords":          {string(EventNamesWords), string(oplog.EventNamesWords)},
+		"EncodingJSON":             {string(EncodingJSON), string(encoding.JSON)},
+		"EncodingMessagePack":      {string(EncodingMessagePack), string(encoding.MessagePack)},
+		"EncodingProtobuf":         {string(EncodingProtobuf), string(encoding.Protobuf)},
+		"NoCompression":            {string(NoCompression), string(encoding.NoCompression)},
+		"GzipCompression":          {string(GzipCompression), string(encoding.Gzip)},
+		"FlushEveryInterval":       {string(FlushEveryInterval), string(redispub.FlushEveryInterval)},
+		"FlushEveryCount":          {string(FlushEveryCount), string(redispub.FlushEveryCount)},
+		"FlushOnIdle":              {string(FlushOnIdle), string(redispub.FlushOnIdle)},
+		"DefaultBackoff":           {backoff.Backoff(DefaultBackoff), backoff.Default},
+	}
+
+	for name, test := range tests {
+		if !reflect.DeepEqual(test[0], test[1]) {
+			t.Errorf("%s is %#v, but the lib packages use %#v", name, test[0], test[1])
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package oplogtoredis
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/tracing"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TailerOption configures the Tailer that a Pipeline tails the oplog with.
+// Pass them in Config.TailerOptions.
+type TailerOption struct {
+	// Returns the option for the tailer, given the client it reads the
+	// oplog from
+	option func(client *mongo.Client) oplog.Option
+
+	// Whether the option sets where the oplog is read from, so the pipeline
+	// doesn't need Config.MongoURL to read it
+	source bool
+}
+
+// Returns a TailerOption that doesn't need the client
+func tailerOption(option oplog.Option) TailerOption {
+	return TailerOption{option: func(*mongo.Client) oplog.Option { return option }}
+}
+
+// NamespaceFilter decides whether entries for a collection should be
+// processed. Entries it returns false for are not published.
+type NamespaceFilter func(database string, collection string) bool
+
+// NewGlobNamespaceFilter creates a NamespaceFilter that includes and excludes
+// collections by glob patterns matched against "<database>.<collection>",
+// like OTR_INCLUDE_NAMESPACES and OTR_EXCLUDE_NAMESPACES. It returns nil
+// (which includes everything) if both lists are empty.
+func NewGlobNamespaceFilter(include []string, exclude []string) (NamespaceFilter, error) {
+	filter, err := oplog.NewGlobNamespaceFilter(include, exclude)
+	if err != nil || filter == nil {
+		return nil, err
+	}
+
+	return NamespaceFilter(filter), nil
+}
+
+// MetricsHook is called once for each oplog entry received, with its
+// database, status (processed, ignored, filtered, skipped, or error), and
+// size in bytes, so callers can export their own metrics.
+type MetricsHook func(database string, status string, size int)
+
+// ReadPreference is which replica set members the oplog and documents are
+// read from
+type ReadPreference string
+
+// The read preferences. They have the same meaning as Mongo's read
+// preference modes.
+const (
+	ReadPrimary            ReadPreference = "primary"
+	ReadPrimaryPreferred   ReadPreference = "primaryPreferred"
+	ReadSecondary          ReadPreference = "secondary"
+	ReadSecondaryPreferred ReadPreference = "secondaryPreferred"
+	ReadNearest            ReadPreference = "nearest"
+)
+
+// StartPosition is where tailing starts when there's no timestamp to resume
+// from
+type StartPosition string
+
+// The start positions
+const (
+	// Resume from the last-processed timestamp, or from the newest entry if
+	// there isn't one (or it's older than Config.MaxCatchUp). The default.
+	StartFromCheckpoint StartPosition = "checkpoint"
+
+	// Start from the oldest entry in the oplog, ignoring the last-processed
+	// timestamp
+	StartFromOldest StartPosition = "oldest"
+
+	// Start from the newest entry in the oplog, ignoring the last-processed
+	// timestamp
+	StartFromNewest StartPosition = "newest"
+)
+
+// FieldPaths is how the changed fields in messages are reported
+type FieldPaths string
+
+// The ways to report changed fields. See OTR_FIELD_PATHS.
+const (
+	FieldPathsFull     FieldPaths = "full"
+	FieldPathsTopLevel FieldPaths = "top-level"
+	FieldPathsBoth     FieldPaths = "both"
+)
+
+// The versions of the message format. See OTR_PROTOCOL_VERSION.
+const (
+	ProtocolVersion1      = 1
+	ProtocolVersion2      = 2
+	LatestProtocolVersion = ProtocolVersion2
+)
+
+// PayloadFormat is the format of the messages we publish
+type PayloadFormat string
+
+// The message formats. See OTR_PAYLOAD_FORMAT.
+const (
+	PayloadFormatRedisOplog  PayloadFormat = "redis-oplog"
+	PayloadFormatCloudEvents PayloadFormat = "cloudevents"
+	PayloadFormatDebezium    PayloadFormat = "debezium"
+)
+
+// OversizeAction is what to do with a message that's larger than the
+// maximum message size
+type OversizeAction string
+
+// The actions for messages that are too large. See OTR_OVERSIZE_ACTION.
+const (
+	OversizeTruncate OversizeAction = "truncate"
+	OversizeRefetch  OversizeAction = "refetch"
+	OversizeDrop     OversizeAction = "drop"
+)
+
+// RefetchEvent is the event of the marker message that OversizeRefetch
+// publishes instead of a message that's too large
+const RefetchEvent = "refetch"
+
+// EventNames is the set of event names messages are published with
+type EventNames string
+
+// The sets of event names. See OTR_EVENT_NAMES.
+const (
+	EventNamesMeteor EventNames = "meteor"
+	EventNamesOplog  EventNames = "oplog"
+	EventNamesWords  EventNames = "words"
+)
+
+// Backoff describes how long to wait between retries: exponentially longer
+// after each failure in a row, up to a maximum, with jitter. Its zero value
+// means DefaultBackoff.
+type Backoff struct {
+	// The delay before the first retry
+	Initial time.Duration
+
+	// The longest delay
+	Max time.Duration
+
+	// How much longer each delay is than the one before it
+	Multiplier float64
+
+	// The fraction (from 0 to 1) of each delay that's randomized: a delay d
+	// becomes a random delay between d*(1-Jitter) and d.
+	Jitter float64
+}
+
+// DefaultBackoff is the Backoff used when one isn't configured: 1 second,
+// doubling up to 30 seconds, with half of each delay randomized.
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.5,
+}
+
+// WithReadPreference sets which replica set members the oplog and documents
+// are read from, like ReadSecondary to tail a hidden secondary. Defaults to
+// ReadPrimary. See OTR_MONGO_READ_PREFERENCE.
+func WithReadPreference(preference ReadPreference) TailerOption {
+	return tailerOption(oplog.WithReadPreference(oplog.ReadPreference(preference)))
+}
+
+// WithSource sets where the oplog is read from, instead of Config.MongoURL.
+// Documents (for options like WithFullDocument) are still read from
+// Config.MongoURL.
+func WithSource(source OplogSource) TailerOption {
+	opt := tailerOption(oplog.WithSource(&sourceAdapter{source: source}))
+	opt.source = true
+	return opt
+}
+
+// WithChangeStreams reads changes with a cluster-wide change stream instead
+// of reading the oplog directly, for deployments where the oplog can't be
+// read (like MongoDB Atlas shared tiers). See OTR_CHANGE_STREAMS.
+func WithChangeStreams() TailerOption {
+	return TailerOption{option: func(client *mongo.Client) oplog.Option {
+		return oplog.WithSource(oplog.NewChangeStreamSource(client))
+	}}
+}
+
+// WithIncludeNamespace makes every message include the namespace of its
+// document. It's set when Config.GlobalChannel is.
+func WithIncludeNamespace(includeNamespace bool) TailerOption {
+	return tailerOption(oplog.WithIncludeNamespace(includeNamespace))
+}
+
+// WithIncludeTimestamp makes every message include the timestamp of its oplog
+// entry. See OTR_MESSAGE_TIMESTAMP.
+func WithIncludeTimestamp(includeTimestamp bool) TailerOption {
+	return tailerOption(oplog.WithIncludeTimestamp(includeTimestamp))
+}
+
+// WithIncludeWallTime makes every message include the wall-clock time at
+// which its oplog entry was written. See OTR_MESSAGE_WALL_TIME.
+func WithIncludeWallTime(includeWallTime bool) TailerOption {
+	return tailerOption(oplog.WithIncludeWallTime(includeWallTime))
+}
+
+// WithPublisherVersion makes every message include the version of the
+// program that published it, unless version is empty
+func WithPublisherVersion(version string) TailerOption {
+	return tailerOption(oplog.WithPublisherVersion(version))
+}
+
+// WithMaxRestarts makes tailing give up after it fails more than
+// maxRestarts times in a row, in which case Run and Tail return ErrGaveUp.
+// Defaults to 0, which retries forever. See OTR_TAIL_MAX_RESTARTS.
+func WithMaxRestarts(maxRestarts int) TailerOption {
+	return tailerOption(oplog.WithMaxRestarts(maxRestarts))
+}
+
+// WithShard makes the pipeline tail one shard of a sharded cluster, with its
+// own last-processed timestamp. Config.MongoURL should point at that shard.
+func WithShard(name string) TailerOption {
+	return tailerOption(oplog.WithShard(name))
+}
+
+// WithNamespaceFilter only processes entries for collections that the filter
+// returns true for
+func WithNamespaceFilter(filter NamespaceFilter) TailerOption {
+	return tailerOption(oplog.WithNamespaceFilter(oplog.NamespaceFilter(filter)))
+}
+
+// WithMetricsHook calls hook for each oplog entry received
+func WithMetricsHook(hook MetricsHook) TailerOption {
+	return tailerOption(oplog.WithMetricsHook(oplog.MetricsHook(hook)))
+}
+
+// WithResumeFrom makes the first tail resume from the given timestamp, rather
+// than from the last-processed timestamp
+func WithResumeFrom(ts Timestamp) TailerOption {
+	return tailerOption(oplog.WithResumeFrom(bson.MongoTimestamp(ts)))
+}
+
+// WithStartFrom sets where the first tail starts from, if there's no
+// WithResumeFrom timestamp. Defaults to StartFromCheckpoint.
+func WithStartFrom(position StartPosition) TailerOption {
+	return tailerOption(oplog.WithStartFrom(oplog.StartPosition(position)))
+}
+
+// WithStopAt makes tailing stop once it reaches an entry after the given
+// timestamp, in which case Run and Tail return nil
+func WithStopAt(ts Timestamp) TailerOption {
+	return tailerOption(oplog.WithStopAt(bson.MongoTimestamp(ts)))
+}
+
+// WithFullDocument makes messages for inserts and updates include the whole
+// document. See OTR_FULL_DOCUMENT.
+func WithFullDocument(fullDocument bool) TailerOption {
+	return tailerOption(oplog.WithFullDocument(fullDocument))
+}
+
+// WithFullDocumentFilter makes WithFullDocument only apply to collections
+// that the filter returns true for
+func WithFullDocumentFilter(filter NamespaceFilter) TailerOption {
+	return tailerOption(oplog.WithFullDocumentFilter(oplog.NamespaceFilter(filter)))
+}
+
+// WithFullDocumentProjections limits the fields included in full documents,
+// per collection. See OTR_FULL_DOCUMENT_PROJECTIONS.
+func WithFullDocumentProjections(projections map[string][]string) TailerOption {
+	return tailerOption(oplog.WithFullDocumentProjections(projections))
+}
+
+// WithChangedValues makes messages for inserts and updates include the values
+// they wrote. See OTR_CHANGED_VALUES.
+func WithChangedValues(changedValues bool) TailerOption {
+	return tailerOption(oplog.WithChangedValues(changedValues))
+}
+
+// WithDocumentVersion makes every message include a document version. See
+// OTR_DOCUMENT_VERSION.
+func WithDocumentVersion(documentVersion bool) TailerOption {
+	return tailerOption(oplog.WithDocumentVersion(documentVersion))
+}
+
+// WithRoutingFields makes messages also be published to channels for the
+// values of some fields of their documents, per collection. See
+// OTR_ROUTING_FIELDS.
+func WithRoutingFields(fields map[string][]string) TailerOption {
+	return tailerOption(oplog.WithRoutingFields(fields))
+}
+
+// WithRoutingLookup fetches routing fields that updates don't set from Mongo.
+// See OTR_ROUTING_LOOKUP.
+func WithRoutingLookup(routingLookup bool) TailerOption {
+	return tailerOption(oplog.WithRoutingLookup(routingLookup))
+}
+
+// WithRedactFields leaves fields, like passwords, out of messages, per
+// collection. See OTR_REDACT_FIELDS.
+func WithRedactFields(fields map[string][]string) TailerOption {
+	return tailerOption(oplog.WithRedactFields(fields))
+}
+
+// WithHashFields replaces the values of fields in messages with their hash,
+// per collection. See OTR_HASH_FIELDS.
+func WithHashFields(fields map[string][]string) TailerOption {
+	return tailerOption(oplog.WithHashFields(fields))
+}
+
+// WithDeadLetterChannel publishes entries that can't be processed, with the
+// error, to channel. See OTR_DEAD_LETTER_CHANNEL.
+func WithDeadLetterChannel(channel string) TailerOption {
+	return tailerOption(oplog.WithDeadLetterChannel(channel))
+}
+
+// WithFieldPaths sets how the changed fields in messages are reported.
+// Defaults to FieldPathsFull.
+func WithFieldPaths(fieldPaths FieldPaths) TailerOption {
+	return tailerOption(oplog.WithFieldPaths(oplog.FieldPaths(fieldPaths)))
+}
+
+// WithProtocolVersion sets the version of the message format to publish.
+// Defaults to ProtocolVersion1.
+func WithProtocolVersion(version int) TailerOption {
+	return tailerOption(oplog.WithProtocolVersion(version))
+}
+
+// WithPayloadFormat sets the format of the messages we publish. Defaults to
+// PayloadFormatRedisOplog.
+func WithPayloadFormat(payloadFormat PayloadFormat) TailerOption {
+	return tailerOption(oplog.WithPayloadFormat(oplog.PayloadFormat(payloadFormat)))
+}
+
+// WithClusterName sets the name of the Mongo cluster we tail, which is the
+// start of CloudEvents' source. See OTR_CLUSTER_NAME.
+func WithClusterName(name string) TailerOption {
+	return tailerOption(oplog.WithClusterName(name))
+}
+
+// WithMaxMessageSize sets the largest message to publish, in bytes (0 for no
+// limit), and what to do with larger ones. See OTR_MAX_MESSAGE_SIZE.
+func WithMaxMessageSize(size int, action OversizeAction) TailerOption {
+	return tailerOption(oplog.WithMaxMessageSize(size, oplog.OversizeAction(action)))
+}
+
+// WithEventNames sets the event names messages are published with. Defaults
+// to EventNamesMeteor.
+func WithEventNames(eventNames EventNames) TailerOption {
+	return tailerOption(oplog.WithEventNames(oplog.EventNames(eventNames)))
+}
+
+// WithPublishMigrations publishes the entries a sharded cluster writes when
+// it moves a chunk between shards, which are skipped by default
+func WithPublishMigrations(publishMigrations bool) TailerOption {
+	return tailerOption(oplog.WithPublishMigrations(publishMigrations))
+}
+
+// WithCollectionEvents publishes collection drops, renames, and database
+// drops as events on per-collection meta channels (see
+// CollectionEventChannel). See OTR_COLLECTION_EVENTS.
+func WithCollectionEvents(collectionEvents bool) TailerOption {
+	return tailerOption(oplog.WithCollectionEvents(collectionEvents))
+}
+
+// WithRenameRemoves publishes removes for the documents of renamed
+// collections with at most limit documents. Defaults to 0, which never
+// does. See OTR_RENAME_REMOVES.
+func WithRenameRemoves(limit int) TailerOption {
+	return tailerOption(oplog.WithRenameRemoves(limit))
+}
+
+// CollectionEventChannel returns the meta channel that WithCollectionEvents
+// publishes a collection's (or, for dropDatabase, a database's) events to:
+// the namespace followed by "::$meta".
+func CollectionEventChannel(namespace string) string {
+	return oplog.CollectionEventChannel(namespace)
+}
+
+// WithDataGapChannel publishes an event to channel when the oplog has rolled
+// over past where we resume from. See OTR_DATA_GAP_CHANNEL.
+func WithDataGapChannel(channel string) TailerOption {
+	return tailerOption(oplog.WithDataGapChannel(channel))
+}
+
+// WithDataGapRestart sets where to restart after a data gap:
+// StartFromOldest (the default) or StartFromNewest
+func WithDataGapRestart(position StartPosition) TailerOption {
+	return tailerOption(oplog.WithDataGapRestart(oplog.StartPosition(position)))
+}
+
+// Tracer traces oplog entries through processing and publishing, and sends
+// the spans to an OpenTelemetry collector
+type Tracer struct {
+	tracer *tracing.Tracer
+}
+
+// TracerOpts configures NewTracer
+type TracerOpts struct {
+	// The base URL of the OTLP/HTTP receiver, like http://localhost:4318.
+	// Spans are POSTed to <Endpoint>/v1/traces.
+	Endpoint string
+
+	// The service.name of the exported spans. Defaults to "oplogtoredis".
+	ServiceName string
+
+	// The fraction (from 0 to 1) of oplog entries to trace. Defaults to 1
+	// (every entry).
+	SampleRate float64
+
+	// The most spans to send in one request, and how long to wait for a
+	// batch to fill up before sending it anyway. Default to 512 and 5
+	// seconds.
+	BatchSize   int
+	BatchWindow time.Duration
+
+	// The most finished spans to hold while they wait to be sent. Defaults
+	// to 2048.
+	QueueSize int
+
+	// The timeout for each request. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// NewTracer creates a Tracer. The caller must call Close when done.
+func NewTracer(opts TracerOpts) *Tracer {
+	return &Tracer{tracer: tracing.New(tracing.Opts(opts))}
+}
+
+// Close sends the spans that haven't been sent yet, and stops the Tracer
+func (t *Tracer) Close() {
+	t.tracer.Close()
+}
+
+// WithTracer traces entries through decoding, processing, and publishing
+func WithTracer(tracer *Tracer) TailerOption {
+	return tailerOption(oplog.WithTracer(tracer.tracer))
+}
@@ -0,0 +1,106 @@
+package oplogtoredis
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// Timestamp is the timestamp of an oplog entry: the seconds since the epoch
+// in the high 32 bits, and an increasing counter within that second in the
+// low 32 bits. It's both a position in the oplog and a unique identifier of
+// an entry; see
+// https://docs.mongodb.com/manual/reference/bson-types/#timestamps
+type Timestamp int64
+
+// TimestampAt returns the first Timestamp in the second of t. Tailing from
+// it (see WithResumeFrom) reads every entry written in that second or after.
+func TimestampAt(t time.Time) Timestamp {
+	return Timestamp(t.Unix() << 32)
+}
+
+// Time returns the time the entry was written, to the second
+func (ts Timestamp) Time() time.Time {
+	return time.Unix(int64(ts)>>32, 0)
+}
+
+// String formats the timestamp as "<seconds>:<counter>", like the mongo
+// shell's Timestamp(<seconds>, <counter>)
+func (ts Timestamp) String() string {
+	return oplog.FormatTimestamp(bson.MongoTimestamp(ts))
+}
+
+// Publication is a message about a single change, to be published to
+// Redis (or passed to a Handler). Hooks and handlers may change the
+// channels and the message; the changes are published.
+type Publication struct {
+	// The channel for the change's collection ("<db>.<collection>"), and
+	// the one for its document ("<db>.<collection>::<id>")
+	CollectionChannel string
+	SpecificChannel   string
+
+	// Other channels to send the message to, like the channels for the
+	// values of a collection's routing fields (see WithRoutingFields)
+	ExtraChannels []string
+
+	// The message to send, in the format set by WithPayloadFormat
+	Msg []byte
+
+	// The timestamp of the oplog entry
+	OplogTimestamp Timestamp
+
+	// The publication the tailer produced, which carries what we need to
+	// deduplicate and checkpoint it. Nil for publications created by the
+	// caller.
+	pub *redispub.Publication
+}
+
+// Wraps a publication produced by the tailer
+func newPublication(pub *redispub.Publication) *Publication {
+	return &Publication{
+		CollectionChannel: pub.CollectionChannel,
+		SpecificChannel:   pub.SpecificChannel,
+		ExtraChannels:     pub.ExtraChannels,
+		Msg:               pub.Msg,
+		OplogTimestamp:    Timestamp(pub.OplogTimestamp),
+		pub:               pub,
+	}
+}
+
+// Returns the tailer's publication, updated with any changes made to pub
+func (pub *Publication) internal() *redispub.Publication {
+	if pub.pub == nil {
+		pub.pub = &redispub.Publication{}
+	}
+
+	pub.pub.CollectionChannel = pub.CollectionChannel
+	pub.pub.SpecificChannel = pub.SpecificChannel
+	pub.pub.ExtraChannels = pub.ExtraChannels
+	pub.pub.Msg = pub.Msg
+	pub.pub.OplogTimestamp = bson.MongoTimestamp(pub.OplogTimestamp)
+
+	return pub.pub
+}
+
+// EntryInfo describes an oplog entry passed to an OnEntry hook
+type EntryInfo struct {
+	Timestamp Timestamp
+	Namespace string
+
+	// The oplog operation: "i", "u", "d", "c", or "n"
+	Operation string
+
+	// Size of the raw entry in bytes
+	Size int
+}
+
+func newEntryInfo(info oplog.EntryInfo) EntryInfo {
+	return EntryInfo{
+		Timestamp: Timestamp(info.Timestamp),
+		Namespace: info.Namespace,
+		Operation: info.Operation,
+		Size:      info.Size,
+	}
+}
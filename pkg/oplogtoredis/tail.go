@@ -0,0 +1,151 @@
+package oplogtoredis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// ErrSkip can be returned by a Handler to drop a publication without
+// retrying it. The publication is treated as processed.
+var ErrSkip = errors.New("Skip this publication")
+
+// Handler receives publications from Pipeline.Tail. Its return value
+// controls what happens next:
+//
+// - nil acknowledges the publication. It's counted as processed, so it
+// won't be delivered again after a restart.
+//
+// - ErrSkip drops the publication. It's also counted as processed.
+//
+// - Any other error causes the publication to be retried, with backoff,
+// until the handler succeeds or the context is cancelled. Publications are
+// delivered in order, so no later publication is delivered in the
+// meantime.
+type Handler func(*Publication) error
+
+// The backoff between retries of a failing Handler
+const (
+	minHandlerRetryInterval = 100 * time.Millisecond
+	maxHandlerRetryInterval = 30 * time.Second
+)
+
+// Tail connects to Mongo, tails the oplog, and calls handler with each
+// publication, instead of publishing to Redis. This lets you deliver changes
+// however you like. The handler is called from a single goroutine, in oplog
+// order.
+//
+// Like Run, Tail records the timestamp of the last acknowledged publication
+// in Redis (or Config.CheckpointStore), and resumes from it on the next call
+// (subject to MaxCatchUp). Publications that were read from the oplog but
+// not acknowledged when Tail returns will be delivered again.
+//
+// Tail returns ctx.Err() once ctx is cancelled. If tailing stops by itself,
+// Tail first delivers every publication that was already read, and then
+// returns nil if it stopped at the timestamp given to WithStopAt, or
+// ErrGaveUp if it failed more than WithMaxRestarts times in a row. It
+// returns an error straight away if it can't connect to Mongo.
+func (p *Pipeline) Tail(ctx context.Context, handler Handler) error {
+	tailer, err := p.NewTailer()
+	if err != nil {
+		return err
+	}
+	defer tailer.Close()
+
+	pubs := make(chan *redispub.Publication, p.config.BufferSize)
+
+	tailCtx, stopTail := context.WithCancel(ctx)
+	tailDone := make(chan bool)
+	go func() {
+		tailer.tailer.Tail(tailCtx, pubs)
+		close(tailDone)
+	}()
+
+	// Only used to record the last-processed timestamp, which it does
+	// just like Run's publisher
+	sink := redispub.NewRedisSink(p.config.RedisClient, p.publishOpts())
+	defer sink.Close()
+
+	defer func() {
+		// Stop the tailer, discarding anything it's buffered
+		stopTail()
+		<-tailDone
+	}()
+
+	handle := func(pub *redispub.Publication) error {
+		err := deliver(ctx, handler, newPublication(pub))
+		if err != nil {
+			return err
+		}
+
+		sink.Checkpoint(pub)
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case pub := <-pubs:
+			if err := handle(pub); err != nil {
+				return err
+			}
+
+		case <-tailDone:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// The tailer stopped by itself; deliver what it already read
+			for {
+				select {
+				case pub := <-pubs:
+					if err := handle(pub); err != nil {
+						return err
+					}
+				default:
+					return tailer.err(ctx)
+				}
+			}
+		}
+	}
+}
+
+// Calls the handler until it acknowledges or skips the publication. Returns
+// an error only if ctx is cancelled first.
+func deliver(ctx context.Context, handler Handler, pub *Publication) error {
+	retryInterval := minHandlerRetryInterval
+
+	for {
+		err := handler(pub)
+		if err == nil {
+			return nil
+		}
+
+		if err == ErrSkip {
+			log.Log.Debugw("Handler skipped publication",
+				"timestamp", pub.OplogTimestamp)
+			return nil
+		}
+
+		log.Log.Errorw("Error from publication handler, will retry",
+			"error", err,
+			"timestamp", pub.OplogTimestamp,
+			"retryInterval", retryInterval)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryInterval):
+		}
+
+		retryInterval *= 2
+		if retryInterval > maxHandlerRetryInterval {
+			retryInterval = maxHandlerRetryInterval
+		}
+	}
+}
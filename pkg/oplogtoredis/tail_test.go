@@ -0,0 +1,75 @@
+package oplogtoredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeliver(t *testing.T) {
+	pub := &Publication{OplogTimestamp: Timestamp(1234)}
+
+	tests := map[string]struct {
+		results     []error
+		wantCalls   int
+		expectError bool
+	}{
+		"Acknowledged": {
+			results:   []error{nil},
+			wantCalls: 1,
+		},
+		"Skipped": {
+			results:   []error{ErrSkip},
+			wantCalls: 1,
+		},
+		"Retried until acknowledged": {
+			results:   []error{errors.New("Some error"), errors.New("Some error"), nil},
+			wantCalls: 3,
+		},
+		"Cancelled while retrying": {
+			results:     []error{errors.New("Some error")},
+			wantCalls:   1,
+			expectError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			handler := func(p *Publication) error {
+				if p != pub {
+					t.Errorf("Handler called with unexpected publication %#v", p)
+				}
+
+				result := test.results[calls]
+				calls++
+
+				if calls == len(test.results) && result != nil && result != ErrSkip {
+					// Last result is a failure; cancel so we stop retrying
+					cancel()
+				}
+				return result
+			}
+
+			start := time.Now()
+			err := deliver(ctx, handler, pub)
+
+			if test.expectError && err != context.Canceled {
+				t.Errorf("Expected context.Canceled, got %v", err)
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("Got unexpected error: %s", err)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("Handler was called %d times, expected %d", calls, test.wantCalls)
+			}
+			if time.Since(start) > 2*time.Second {
+				t.Errorf("deliver took %s; backoff is too long", time.Since(start))
+			}
+		})
+	}
+}
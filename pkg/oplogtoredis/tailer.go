@@ -0,0 +1,220 @@
+package oplogtoredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OplogSource provides the oplog entries that a pipeline reads, instead of
+// a Mongo server's oplog. See WithSource.
+type OplogSource interface {
+	// LastTimestamp returns the timestamp of the most recent oplog entry
+	LastTimestamp() (Timestamp, error)
+
+	// TailFrom returns a tailable cursor over the oplog entries with
+	// timestamps after ts, in oplog order. If no entries arrive within
+	// timeout, the cursor's Next returns false and its Timeout returns true;
+	// the caller may then call Next again to keep waiting.
+	TailFrom(ts Timestamp, timeout time.Duration) OplogIterator
+}
+
+// OplogIterator is a cursor over oplog entries, like the one returned by
+// OplogSource.TailFrom
+type OplogIterator interface {
+	// Next returns the next entry, as a BSON document, and false when there
+	// are no more entries (or an error occurred, or the cursor timed out)
+	Next() ([]byte, bool)
+	Err() error
+	Timeout() bool
+	Close() error
+}
+
+// Adapts an OplogSource to the tailer's
+type sourceAdapter struct {
+	source OplogSource
+}
+
+func (s *sourceAdapter) LastTimestamp() (bson.MongoTimestamp, error) {
+	ts, err := s.source.LastTimestamp()
+	return bson.MongoTimestamp(ts), err
+}
+
+func (s *sourceAdapter) TailFrom(ts bson.MongoTimestamp, timeout time.Duration) oplog.OplogIterator {
+	return &iteratorAdapter{iter: s.source.TailFrom(Timestamp(ts), timeout)}
+}
+
+// Adapts an OplogIterator to the tailer's
+type iteratorAdapter struct {
+	iter OplogIterator
+	err  error
+}
+
+func (i *iteratorAdapter) Next(result interface{}) bool {
+	entry, ok := i.iter.Next()
+	if !ok {
+		return false
+	}
+
+	if raw, isRaw := result.(*bson.Raw); isRaw {
+		*raw = bson.Raw{Kind: 3, Data: entry}
+		return true
+	}
+
+	i.err = bson.Unmarshal(entry, result)
+	return i.err == nil
+}
+
+func (i *iteratorAdapter) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+
+	return i.iter.Err()
+}
+
+func (i *iteratorAdapter) Timeout() bool { return i.iter.Timeout() }
+func (i *iteratorAdapter) Close() error  { return i.iter.Close() }
+
+// Tailer reads the oplog and produces Publications. Most programs don't
+// need one: Pipeline.Run and Pipeline.Tail create their own. Create one
+// with Pipeline.NewTailer to connect the two halves of the pipeline
+// yourself, with Tailer.Tail reading the oplog into a channel, and
+// Pipeline.Publish publishing from it.
+type Tailer struct {
+	tailer *oplog.Tailer
+
+	// The client the tailer reads with, if it's connected to Mongo
+	client *mongo.Client
+}
+
+// TailerStatus is a Tailer's progress
+type TailerStatus struct {
+	// Whether the Tailer has an open oplog cursor
+	Tailing bool
+
+	// When the cursor last returned an entry, or timed out waiting for one.
+	// A live cursor does one or the other at least once a second, so if this
+	// is old, the Tailer is stuck.
+	LastActivity time.Time
+
+	// The timestamp of the last entry the Tailer read (or that it started
+	// tailing after, if it hasn't read any yet)
+	LastTimestamp Timestamp
+
+	// Whether the Tailer is paused (see Pause)
+	Paused bool
+
+	// How many times in a row tailing has stopped unexpectedly
+	Failures int
+
+	// Whether tailing gave up after more than WithMaxRestarts failures in a
+	// row
+	GaveUp bool
+}
+
+// Tail reads the oplog and sends a Publication to out for each change,
+// until ctx is cancelled. Publications that haven't been sent when ctx is
+// cancelled are dropped; they're read again after a restart, since they
+// weren't recorded as processed. Tail doesn't close out.
+//
+// Tail returns ctx.Err() once ctx is cancelled, nil if it stopped at the
+// timestamp given to WithStopAt, and ErrGaveUp if it gave up after more
+// than WithMaxRestarts failures in a row.
+func (t *Tailer) Tail(ctx context.Context, out chan<- *Publication) error {
+	pubs := make(chan *redispub.Publication)
+
+	tailDone := make(chan bool)
+	go func() {
+		t.tailer.Tail(ctx, pubs)
+		close(tailDone)
+	}()
+
+	for {
+		select {
+		case pub := <-pubs:
+			select {
+			case out <- newPublication(pub):
+			case <-ctx.Done():
+			}
+
+		case <-tailDone:
+			return t.err(ctx)
+		}
+	}
+}
+
+// Returns why the tailer stopped, once it has
+func (t *Tailer) err(ctx context.Context) error {
+	if t.tailer.Status().GaveUp {
+		return ErrGaveUp
+	}
+
+	return ctx.Err()
+}
+
+// Process processes a single oplog entry (a BSON document) and returns the
+// Publication it produces, or nil if the entry shouldn't be published. It's
+// the same code path Tail uses for each entry, so it can be driven without a
+// Mongo server (e.g. with entries from the oplogtest package).
+func (t *Tailer) Process(entry []byte) *Publication {
+	pub := t.tailer.Process(bson.Raw{Kind: 3, Data: entry})
+	if pub == nil {
+		return nil
+	}
+
+	return newPublication(pub)
+}
+
+// Pause stops the Tailer from processing entries until Resume is called.
+// It's safe to call while the Tailer is tailing.
+func (t *Tailer) Pause() {
+	t.tailer.Pause()
+}
+
+// Resume resumes a Tailer stopped by Pause. It's safe to call while the
+// Tailer is tailing.
+func (t *Tailer) Resume() {
+	t.tailer.Resume()
+}
+
+// Skip makes the Tailer skip the entry with timestamp ts, if it hasn't read
+// it yet, to get past an entry that can't be processed or published without
+// restarting. It's safe to call while the Tailer is tailing.
+func (t *Tailer) Skip(ts Timestamp) {
+	t.tailer.Skip(bson.MongoTimestamp(ts))
+}
+
+// Status returns the Tailer's progress. It's safe to call while the Tailer
+// is tailing.
+func (t *Tailer) Status() TailerStatus {
+	status := t.tailer.Status()
+
+	return TailerStatus{
+		Tailing:       status.Tailing,
+		LastActivity:  status.LastActivity,
+		LastTimestamp: Timestamp(status.LastTimestamp),
+		Paused:        status.Paused,
+		Failures:      status.Failures,
+		GaveUp:        status.GaveUp,
+	}
+}
+
+// Lag returns how far behind the end of the oplog the Tailer is, to the
+// second. It queries the oplog, and is safe to call while the Tailer is
+// tailing.
+func (t *Tailer) Lag() (time.Duration, error) {
+	return t.tailer.Lag()
+}
+
+// Close closes the Tailer's connection to Mongo. Call it once the Tailer is
+// done tailing.
+func (t *Tailer) Close() {
+	if t.client != nil {
+		_ = t.client.Disconnect(context.Background())
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/config"
+	"github.com/tulip/oplogtoredis/lib/log"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Implements `oplogtoredis replay`, which reads a range of the oplog and
+// republishes the matching entries through the normal publisher. This is
+// useful for recovering consumers after they've missed messages (for example,
+// because of a Redis outage).
+//
+// Republished messages are deduplicated among themselves, but not against
+// messages that were already published, and they don't affect the
+// last-processed timestamp of running copies of oplogtoredis.
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	from := flags.String("from", "", "Replay entries after this timestamp (RFC 3339, Unix seconds, or <seconds>:<increment>). Required.")
+	flags.StringVar(from, "since", "", "Alias for --from.")
+	to := flags.String("to", "", "Replay entries up to and including this timestamp. Defaults to now.")
+	flags.StringVar(to, "until", "", "Alias for --to.")
+	var namespaces stringSliceFlag
+	flags.Var(&namespaces, "ns", "Only replay entries for this namespace (<database>.<collection>). May be repeated.")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *from == "" {
+		return errors.New("--from (or --since) is required")
+	}
+
+	fromTS, err := oplog.ParseTimestamp(*from)
+	if err != nil {
+		return err
+	}
+
+	// By default, replay through the last possible timestamp in the current
+	// second
+	toTS := bson.MongoTimestamp((time.Now().Unix()+1)<<32 - 1)
+	if *to != "" {
+		toTS, err = oplog.ParseTimestamp(*to)
+		if err != nil {
+			return err
+		}
+	}
+
+	if toTS <= fromTS {
+		return errors.New("--to must be after --from")
+	}
+
+	mongoClient, redisClient, err := connectForCommand()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+	defer redisClient.Close()
+
+	// We deduplicate replayed messages under their own prefix, so that
+	// entries that were already published recently still get republished
+	replayID := make([]byte, 8)
+	_, err = rand.Read(replayID)
+	if err != nil {
+		return err
+	}
+	replayPrefix := metadataPrefix() + "replay::" + hex.EncodeToString(replayID) + "::"
+
+	log.Log.Infow("Replaying oplog entries",
+		"from", *from,
+		"to", *to,
+		"namespaces", []string(namespaces))
+
+	tailer, err := createReplayTailer(mongoClient, redisClient, replayPrefix)
+	if err != nil {
+		return err
+	}
+
+	channelTemplates, err := createChannelTemplates()
+	if err != nil {
+		return err
+	}
+
+	redisClients := []redis.UniversalClient{redisClient}
+	if config.SecondaryRedisURL() != "" {
+		secondaryRedisClient, err := dialRedis(config.SecondaryRedisURL())
+		if err != nil {
+			return err
+		}
+		defer secondaryRedisClient.Close()
+
+		redisClients = append(redisClients, secondaryRedisClient)
+	}
+
+	// Publish to the same sinks, with the same encodings, as when
+	// oplogtoredis runs, except for the write-ahead log, which belongs to the
+	// running copy
+	redisPubs := make(chan *redispub.Publication, config.BufferSize())
+	redisPubDone := make(chan bool)
+	go func() {
+		publish(context.Background(), redisClients, redisPubs, &redispub.PublishOpts{
+			FlushInterval:      config.TimestampFlushInterval(),
+			DedupeExpiration:   config.RedisDedupeExpiration(),
+			DedupeByContent:    config.DedupeByContent(),
+			MetadataPrefix:     replayPrefix,
+			ChannelPrefixes:    config.ChannelPrefixes(),
+			CollectionChannels: config.CustomCollectionChannels(),
+			ChannelTemplates:   channelTemplates,
+			GlobalChannel:      config.GlobalChannel(),
+			MaxRetries:         config.PublishMaxRetries(),
+			RetryBackoff:       reconnectBackoff(),
+			Relay:              createRelayOpts(),
+			Streams:            createStreamOpts(),
+			Workers:            createWorkerOpts(),
+			DisableCheckpoint:  true,
+		}, "")
+		redisPubDone <- true
+	}()
+
+	replayErr := tailer.Replay(redisPubs, fromTS, toTS, namespaces)
+
+	// Wait for everything we read to be published
+	close(redisPubs)
+	<-redisPubDone
+
+	return replayErr
+}
+
+// Creates the tailer for replaying entries, with the same options as when
+// oplogtoredis runs, so replayed entries are published just like they were
+// the first time
+func createReplayTailer(mongoClient *mongo.Client, redisClient redis.UniversalClient, replayPrefix string) (*oplog.Tailer, error) {
+	tailerOpts, err := createTailerOpts(mongoClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replay doesn't record a last-processed timestamp, but tailers need
+	// somewhere they could
+	return oplog.NewTailer(append(tailerOpts,
+		oplog.WithRedisClient(redisClient),
+		oplog.WithRedisPrefix(replayPrefix),
+	)...)
+}
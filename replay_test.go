@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/tulip/oplogtoredis/lib/config"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Replayed entries must be published exactly like they were when they were
+// first tailed, whatever the message format options are
+func TestReplayPublishesLikeTail(t *testing.T) {
+	env := map[string]string{
+		"OTR_MONGO_URL":         "mongodb://localhost",
+		"OTR_REDIS_URL":         "redis://localhost",
+		"OTR_PAYLOAD_FORMAT":    "cloudevents",
+		"OTR_CLUSTER_NAME":      "prod",
+		"OTR_FIELD_PATHS":       "both",
+		"OTR_EVENT_NAMES":       "words",
+		"OTR_PROTOCOL_VERSION":  "2",
+		"OTR_MESSAGE_NAMESPACE": "true",
+		"OTR_MESSAGE_TIMESTAMP": "true",
+		"OTR_REDACT_FIELDS":     "foo.bar:secret",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	err := config.ParseEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redisServer, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redisServer.Close()
+
+	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+		Addrs: []string{redisServer.Addr()},
+	})
+	defer redisClient.Close()
+
+	// Never connected: processing these entries doesn't query Mongo
+	mongoClient := &mongo.Client{}
+
+	tailers, closeShards, err := createTailers(mongoClient, redisClient, redispub.NewRedisCheckpointStore(redisClient), 0, &runFlags{startFrom: oplog.StartFromCheckpoint}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeShards()
+
+	replayTailer, err := createReplayTailer(mongoClient, redisClient, "otr::replay::test::")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string]bson.M{
+		"Insert": {
+			"ts": bson.MongoTimestamp(1500000000<<32 + 1),
+			"op": "i",
+			"ns": "foo.bar",
+			"o":  bson.M{"_id": "someid", "a": 1, "secret": "hunter2"},
+		},
+		"Update": {
+			"ts": bson.MongoTimestamp(1500000000<<32 + 2),
+			"op": "u",
+			"ns": "foo.bar",
+			"o":  bson.M{"$set": bson.M{"a.b": 2, "secret": "hunter3"}},
+			"o2": bson.M{"_id": "someid"},
+		},
+	}
+
+	for name, entry := range entries {
+		t.Run(name, func(t *testing.T) {
+			data, err := bson.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw := bson.Raw{Kind: 3, Data: data}
+
+			live := tailers[0].Process(raw)
+			replayed := replayTailer.Process(raw)
+			if live == nil || replayed == nil {
+				t.Fatalf("Got publications %#v and %#v, expected both to be published", live, replayed)
+			}
+
+			if !reflect.DeepEqual(replayed, live) {
+				t.Errorf("Replayed publication differs from the tailed one.\n    Replayed: %s %#v\n    Tailed: %s %#v",
+					replayed.Msg, replayed, live.Msg, live)
+			}
+		})
+	}
+}
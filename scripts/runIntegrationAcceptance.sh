@@ -34,3 +34,26 @@ for mongo_tag in "${mongo_tags[@]}"; do
         done
     done
 done
+
+# Run against the other Redis topologies with a single Mongo/Redis version
+for redis_topology in "sentinel" "cluster"; do
+    echo "=================================="
+    echo "|      ACCEPTANCE TEST RUN       |"
+    echo "=================================="
+    echo
+    echo "> Redis Topology: $redis_topology "
+    echo
+    echo "=================================="
+
+    export MONGO_TAG="${mongo_tags[0]}"
+    export REDIS_TAG="${redis_tags[1]}"
+    export OTR_DOCKERFILE="Dockerfile.racedetector"
+    compose="docker-compose -f docker-compose.yml -f docker-compose.$redis_topology.yml"
+
+    $compose rm -vf
+    $compose down -v
+    $compose up \
+        --build \
+        --exit-code-from test \
+        --abort-on-container-exit
+done
@@ -8,6 +8,14 @@ mongo_tag="3.6.11"
 redis_tag="3.2.4"
 otr_dockerfile="Dockerfile.racedetector"
 
+# Set REDIS_TOPOLOGY to "sentinel" or "cluster" to run against those instead
+# of a standalone Redis
+redis_topology="${REDIS_TOPOLOGY:-standalone}"
+compose="docker-compose -f docker-compose.yml"
+if [ "$redis_topology" != "standalone" ]; then
+    compose="$compose -f docker-compose.$redis_topology.yml"
+fi
+
 echo "=================================="
 echo "|      ACCEPTANCE TEST RUN       |"
 echo "=================================="
@@ -15,6 +23,7 @@ echo
 echo "> Mongo Tag: $mongo_tag           "
 echo "> Redis Tag: $redis_tag           "
 echo "> Dockerfile: $otr_dockerfile     "
+echo "> Redis Topology: $redis_topology "
 echo
 echo "=================================="
 
@@ -22,9 +31,9 @@ export MONGO_TAG="$mongo_tag"
 export REDIS_TAG="$redis_tag"
 export OTR_DOCKERFILE="$otr_dockerfile"
 
-docker-compose rm -vf
-docker-compose down -v
-docker-compose up \
+$compose rm -vf
+$compose down -v
+$compose up \
     --build \
     --exit-code-from test \
     --abort-on-container-exit
@@ -3,9 +3,68 @@
 set -eu
 cd `dirname "$0"`'/../integration-tests/meteor'
 
-docker-compose rm -vf
-docker-compose down -v
-docker-compose up \
+# METEOR_INSTANCES sets how many Meteor app servers to run (at least 2), and
+# METEOR_RELEASE and REDIS_OPLOG_VERSION select the versions of Meteor and
+# redis-oplog they're built with (defaulting to the versions testapp is
+# pinned to). For example:
+#
+#   METEOR_INSTANCES=4 REDIS_OPLOG_VERSION=1.2.8 scripts/runIntegrationMeteor.sh
+instances="${METEOR_INSTANCES:-2}"
+compose="docker-compose -f docker-compose.yml"
+
+if [ "$instances" -gt 2 ]; then
+    # docker-compose.yml only defines testapp1 and testapp2, so generate an
+    # override file with the rest
+    override="docker-compose.instances.yml"
+    trap 'rm -f "$override"' EXIT
+
+    urls="ws://testapp1:8080/websocket,ws://testapp2:8080/websocket"
+    services=""
+    for i in $(seq 3 "$instances"); do
+        urls="$urls,ws://testapp$i:8080/websocket"
+        services="$services
+  testapp$i:
+    build:
+      context: ../../testapp
+      args:
+        - METEOR_RELEASE=\${METEOR_RELEASE:-1.6.1.1}
+        - REDIS_OPLOG_VERSION=\${REDIS_OPLOG_VERSION:-1.2.7_1}
+    environment:
+      - MONGO_URL_NO_RS=mongodb://mongo/tests
+      - MONGO_URL=mongodb://mongo/tests?replicaSet=myapp
+      - MONGO_OPLOG_URL=mongodb://mongo/local?replicaSet=myapp
+      - ROOT_URL=http://testapp$i:8080
+    volumes:
+      - ../../scripts/wait-for.sh:/wait-for.sh
+      - ./meteor-entry.sh:/meteor-entry.sh
+      - ./meteor-settings.json:/meteor-settings.json
+    command:
+      - /wait-for.sh
+      - --timeout=60
+      - mongo:27017
+      - '--'
+      - /wait-for.sh
+      - --timeout=60
+      - redis:6379
+      - '--'
+      - /meteor-entry.sh"
+    done
+
+    cat > "$override" <<EOF
+version: "3"
+services:
+  test:
+    environment:
+      - TESTAPP_URLS=$urls
+$services
+EOF
+
+    compose="$compose -f $override"
+fi
+
+$compose rm -vf
+$compose down -v
+$compose up \
     --build \
     --exit-code-from test \
     --abort-on-container-exit
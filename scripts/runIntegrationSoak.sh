@@ -0,0 +1,16 @@
+#!/bin/bash
+
+# Runs the soak test from the fault-injection suite. Set SOAK_DURATION (e.g.
+# 4h) to control how long it runs, and optionally SOAK_RATE (writes/sec) and
+# SOAK_INTERVAL (how often to sample).
+
+set -eu
+cd `dirname "$0"`'/..'
+
+docker build . -f integration-tests/fault-injection/Dockerfile -t oplogtoredis-fault-injection
+docker run --rm -e TERM=xterm \
+    -e SOAK_DURATION="${SOAK_DURATION:-4h}" \
+    -e SOAK_RATE="${SOAK_RATE:-100}" \
+    -e SOAK_INTERVAL="${SOAK_INTERVAL:-1m}" \
+    oplogtoredis-fault-injection \
+    go test . -run TestSoak -timeout 0 -v
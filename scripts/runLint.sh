@@ -6,6 +6,6 @@
 set -e
 cd `dirname "$0"`'/..'
 
-gometalinter . ./lib/... ./integration-tests/...
+gometalinter . ./lib/... ./pkg/... ./integration-tests/...
 
 echo 'Lint passed.'
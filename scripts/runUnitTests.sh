@@ -5,4 +5,6 @@
 set -e
 cd `dirname "$0"`'/..'
 
-go test -race -cover -timeout 5s . ./lib/...
+# Some tests wait for real Redis and Kubernetes timeouts (which have a
+# resolution of a second), so leave plenty of room for slow CI machines
+go test -race -cover -timeout 60s . ./lib/... ./pkg/...
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/oplog"
+)
+
+// Implements `oplogtoredis tail-dump`, which tails the oplog and prints each
+// entry, how we parsed it, and what we would publish for it. It doesn't
+// connect to Redis or publish anything.
+func runTailDump(args []string) error {
+	flags := flag.NewFlagSet("tail-dump", flag.ContinueOnError)
+	mongoURL := flags.String("mongo-url", os.Getenv("OTR_MONGO_URL"), "Mongo URL to read the oplog from. Defaults to OTR_MONGO_URL.")
+	from := flags.String("from", "", "Start after this timestamp (RFC 3339, Unix seconds, or <seconds>:<increment>). Defaults to the end of the oplog.")
+	var namespaces stringSliceFlag
+	flags.Var(&namespaces, "ns", "Only print entries for this namespace (<database>.<collection>). May be repeated.")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var start bson.MongoTimestamp
+	if *from != "" {
+		start, err = oplog.ParseTimestamp(*from)
+		if err != nil {
+			return err
+		}
+	}
+
+	mongoClient, err := dialMongo(*mongoURL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	defer signal.Reset()
+	go func() {
+		<-signalChan
+		cancel()
+	}()
+
+	tailer := oplog.Tailer{MongoClient: mongoClient}
+	return tailer.TailDump(ctx, os.Stdout, start, namespaces)
+}
@@ -0,0 +1,59 @@
+import { Meteor } from 'meteor/meteor'
+import nestedTestCollection from '../imports/api/nestedTest.js';
+import insertIgnoreDupKey from '../imports/api/insertIgnoreDupKey.js';
+
+// For testing array operators and nested field updates
+Meteor.publish('nestedTest.pub', function() {
+  return nestedTestCollection.find();
+});
+
+function initializeFixtures() {
+  insertIgnoreDupKey(nestedTestCollection, {
+    _id: 'test',
+    tags: ['a', 'b', 'c'],
+    items: [
+      { name: 'x', qty: 1 },
+      { name: 'y', qty: 2 },
+    ],
+    profile: {
+      name: 'someone',
+      address: { city: 'Boston', zip: '02110' },
+    },
+  })
+}
+
+Meteor.startup(initializeFixtures)
+
+Meteor.methods({
+  'nestedTest.initializeFixtures': initializeFixtures,
+
+  'nestedTest.push'() {
+    nestedTestCollection.update({ _id: 'test' }, {
+      $push: { tags: 'd' },
+    });
+  },
+
+  'nestedTest.pull'() {
+    nestedTestCollection.update({ _id: 'test' }, {
+      $pull: { tags: 'b' },
+    });
+  },
+
+  'nestedTest.positional'() {
+    nestedTestCollection.update({ _id: 'test', 'items.name': 'y' }, {
+      $set: { 'items.$.qty': 5 },
+    });
+  },
+
+  'nestedTest.deepSet'() {
+    nestedTestCollection.update({ _id: 'test' }, {
+      $set: { 'profile.address.city': 'Cambridge' },
+    });
+  },
+
+  'nestedTest.deepUnset'() {
+    nestedTestCollection.update({ _id: 'test' }, {
+      $unset: { 'profile.address.zip': true },
+    });
+  },
+});
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tulip/oplogtoredis/lib/config"
+)
+
+// Implements `oplogtoredis validate-config` (or `--validate-config`), which
+// parses the configuration file and environment variables the same way
+// oplogtoredis does at startup, without connecting to anything, and reports
+// whether they're valid. The file to check can be given as an argument
+// instead of through OTR_CONFIG_FILE.
+func runValidateConfig(args []string) error {
+	if len(args) > 1 {
+		return errors.New("validate-config takes at most one argument, the configuration file")
+	}
+
+	if len(args) == 1 {
+		err := os.Setenv("OTR_CONFIG_FILE", args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := config.ParseEnv()
+	if err != nil {
+		return fmt.Errorf("Invalid configuration: %s", err)
+	}
+
+	if file := os.Getenv("OTR_CONFIG_FILE"); file != "" {
+		fmt.Printf("Configuration in %s and the environment is valid\n", file)
+	} else {
+		fmt.Println("Configuration in the environment is valid")
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/tulip/oplogtoredis/lib/config"
+	"github.com/tulip/oplogtoredis/lib/redispub"
+)
+
+// The maximum number of missing publications we print details about
+const maxReportedGaps = 20
+
+// Implements `oplogtoredis verify`, which reads recent oplog entries and
+// checks that each one that should have been published was published,
+// reporting any gaps.
+//
+// We check using the keys that the publisher writes to deduplicate messages,
+// so we can only check entries that are more recent than
+// RedisDedupeExpiration. We also only check entries up to the last-processed
+// timestamp, because later entries may still be in flight.
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	window := flags.Duration("window", 0, "How far back to check. Defaults to 10s less than OTR_REDIS_DEDUPE_EXPIRATION.")
+	var namespaces stringSliceFlag
+	flags.Var(&namespaces, "ns", "Only check entries for this namespace (<database>.<collection>). May be repeated.")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if config.DedupeByContent() {
+		return errors.New("verify can't check publications when OTR_DEDUPE_BY_CONTENT is enabled, because their dedupe keys depend on the exact messages published")
+	}
+
+	mongoClient, redisClient, err := connectForCommand()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = mongoClient.Disconnect(context.Background()) }()
+	defer redisClient.Close()
+
+	if *window == 0 {
+		*window = config.RedisDedupeExpiration() - 10*time.Second
+	}
+	if *window <= 0 || *window > config.RedisDedupeExpiration() {
+		return fmt.Errorf("--window must be positive and no longer than OTR_REDIS_DEDUPE_EXPIRATION (%s)", config.RedisDedupeExpiration())
+	}
+
+	store := createCheckpointStore(redisClient, mongoClient)
+	checkpoint, checkpointTime, err := store.LastProcessedTimestamp(metadataPrefix())
+	if err == redispub.ErrNoCheckpoint {
+		return errors.New("No last-processed timestamp found in the checkpoint store; is oplogtoredis running with this OTR_REDIS_METADATA_PREFIX?")
+	} else if err != nil {
+		return fmt.Errorf("Error reading last-processed timestamp: %s", err)
+	}
+
+	windowStart := time.Now().Add(-*window)
+	if checkpointTime.Before(windowStart) {
+		return fmt.Errorf("Last-processed timestamp (%s) is older than the verification window; oplogtoredis may be stalled or far behind", checkpointTime)
+	}
+
+	fmt.Printf("Checking oplog entries from %s to %s\n", windowStart.Format(time.RFC3339), checkpointTime.Format(time.RFC3339))
+
+	// Read the publications that should have been made, and check each one.
+	// The tailer has the same options as when oplogtoredis runs, so entries
+	// it doesn't publish (like those for excluded namespaces) aren't
+	// reported as gaps.
+	tailer, err := createReplayTailer(mongoClient, redisClient, metadataPrefix())
+	if err != nil {
+		return err
+	}
+
+	pubs := make(chan *redispub.Publication, config.BufferSize())
+	replayErrC := make(chan error, 1)
+	go func() {
+		replayErrC <- tailer.Replay(pubs, bson.MongoTimestamp(windowStart.Unix()<<32), checkpoint, namespaces)
+		close(pubs)
+	}()
+
+	checked := 0
+	var gaps []*redispub.Publication
+	for p := range pubs {
+		published, checkErr := redispub.WasPublished(redisClient, metadataPrefix(), p)
+		if checkErr != nil {
+			return fmt.Errorf("Error checking publication: %s", checkErr)
+		}
+
+		checked++
+		if !published {
+			gaps = append(gaps, p)
+		}
+	}
+
+	err = <-replayErrC
+	if err != nil {
+		return fmt.Errorf("Error reading oplog: %s", err)
+	}
+
+	fmt.Printf("Checked %d entries; %d were not published\n", checked, len(gaps))
+
+	for i, p := range gaps {
+		if i >= maxReportedGaps {
+			fmt.Printf("  ... and %d more\n", len(gaps)-maxReportedGaps)
+			break
+		}
+
+		fmt.Printf("  %d:%d %s\n", int64(p.OplogTimestamp)>>32, int64(p.OplogTimestamp)&0xFFFFFFFF, p.SpecificChannel)
+	}
+
+	if len(gaps) > 0 {
+		return fmt.Errorf("Found %d unpublished entries", len(gaps))
+	}
+
+	return nil
+}